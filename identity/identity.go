@@ -0,0 +1,445 @@
+// Package identity implements the SIP Identity header (RFC 8224) with
+// PASSporT tokens (RFC 8225) using the SHAKEN extension (RFC 8588), used by
+// carriers to sign and verify the calling number of a request.
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+// Errors returned when verifying an Identity header.
+var (
+	ErrNoIdentity      = errors.New("identity: no identity header")
+	ErrInvalidIdentity = errors.New("identity: invalid identity header")
+	ErrBadSignature    = errors.New("identity: bad signature")
+	ErrExpired         = errors.New("identity: passport expired")
+	ErrMismatch        = errors.New("identity: passport does not match request")
+	ErrNoRoots         = errors.New("identity: no trusted roots")
+	ErrInsecureURL     = errors.New("identity: certificate url is not https")
+	ErrUntrusted       = errors.New("identity: untrusted certificate")
+	ErrTooLarge        = errors.New("identity: certificate too large")
+)
+
+// Attestation levels of SHAKEN.
+const (
+	AttestFull    = "A"
+	AttestPartial = "B"
+	AttestGateway = "C"
+)
+
+// DefaultMaxAge is how old a PASSporT may be when it is verified, as
+// recommended by RFC 8224.
+const DefaultMaxAge = 60 * time.Second
+
+// DefaultCacheTTL is how long a fetched certificate is cached for by a
+// Verifier, unless it expires sooner.
+const DefaultCacheTTL = time.Hour
+
+// DefaultMaxCached is the number of certificate URLs a Verifier caches.
+const DefaultMaxCached = 256
+
+// maxCertificateSize is the largest certificate chain fetched over HTTPS.
+const maxCertificateSize = 64 * 1024
+
+// Claims are the claims of a SHAKEN PASSporT.
+type Claims struct {
+	Attest   string `json:"attest"`
+	Dest     Dest   `json:"dest"`
+	IssuedAt int64  `json:"iat"`
+	Orig     Orig   `json:"orig"`
+	OrigID   string `json:"origid"`
+}
+
+// Orig is the originating identity of a PASSporT.
+type Orig struct {
+	TN string `json:"tn"`
+}
+
+// Dest is the destination identities of a PASSporT.
+type Dest struct {
+	TN []string `json:"tn"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Ppt string `json:"ppt"`
+	Typ string `json:"typ"`
+	X5U string `json:"x5u"`
+}
+
+// NewClaims returns the claims for a request, with the originating and
+// destination telephone numbers taken from the users of its From and To
+// headers, issued now.
+func NewClaims(req *sipnet.Request, attest, origID string) (Claims, error) {
+	from, to, err := sipnet.ParseUserHeader(req.Header)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return Claims{
+		Attest:   attest,
+		Dest:     Dest{TN: []string{telephoneNumber(to.URI.Username)}},
+		IssuedAt: time.Now().Unix(),
+		Orig:     Orig{TN: telephoneNumber(from.URI.Username)},
+		OrigID:   origID,
+	}, nil
+}
+
+// telephoneNumber returns the digits of a telephone number, removing visual
+// separators and the leading "+".
+func telephoneNumber(user string) string {
+	if i := strings.Index(user, ";"); i >= 0 {
+		user = user[:i]
+	}
+
+	var digits []rune
+	for _, r := range user {
+		if (r >= '0' && r <= '9') || r == '*' || r == '#' {
+			digits = append(digits, r)
+		}
+	}
+	return string(digits)
+}
+
+// Sign returns the value of an Identity header with a PASSporT of the
+// claims, signed with key using ES256. certURL is the URL of the
+// certificate of key, which verifiers fetch.
+func Sign(claims Claims, key *ecdsa.PrivateKey, certURL string) (string, error) {
+	headerJSON, err := json.Marshal(header{
+		Alg: "ES256",
+		Ppt: "shaken",
+		Typ: "passport",
+		X5U: certURL,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := make([]byte, 64)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(signature[32-len(rBytes):32], rBytes)
+	copy(signature[64-len(sBytes):], sBytes)
+
+	return signingInput + "." + encode(signature) + ";info=<" + certURL +
+		">;alg=ES256;ppt=shaken", nil
+}
+
+// AddIdentity signs the claims for a request, and adds them to the request
+// as an Identity header.
+func AddIdentity(req *sipnet.Request, claims Claims, key *ecdsa.PrivateKey,
+	certURL string) error {
+	value, err := Sign(claims, key, certURL)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Identity", value)
+	return nil
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Verifier verifies the Identity headers of requests. The certificates used
+// to sign PASSporTs are fetched from their https URLs, verified against the
+// trusted roots, and cached.
+type Verifier struct {
+	// Roots are the trusted roots of the certificates used to sign
+	// PASSporTs, such as those of the STI-CAs. They are required, and
+	// Verify returns ErrNoRoots without them.
+	Roots *x509.CertPool
+
+	// Fetch fetches the certificate chain at a URL, starting with the
+	// certificate used to sign and followed by its intermediates. If nil,
+	// the chain is fetched over HTTPS as PEM.
+	Fetch func(url string) ([]*x509.Certificate, error)
+
+	// MaxAge is how old a PASSporT may be. If zero, DefaultMaxAge is used.
+	MaxAge time.Duration
+
+	// CacheTTL is how long a fetched certificate is cached for. If zero,
+	// DefaultCacheTTL is used.
+	CacheTTL time.Duration
+
+	// MaxCached is the number of certificate URLs cached. If zero,
+	// DefaultMaxCached is used.
+	MaxCached int
+
+	mutex sync.Mutex
+	cache map[string]cachedChain
+}
+
+type cachedChain struct {
+	chain   []*x509.Certificate
+	expires time.Time
+}
+
+// Verify verifies the Identity header of a request, and returns the claims
+// of its PASSporT. The certificate it is signed with must chain to the
+// Roots and be valid now, and the originating and destination numbers of
+// the claims must match the From and To of the request.
+func (v *Verifier) Verify(req *sipnet.Request) (Claims, error) {
+	if v.Roots == nil {
+		return Claims{}, ErrNoRoots
+	}
+
+	value := req.Header.Get("Identity")
+	if value == "" {
+		return Claims{}, ErrNoIdentity
+	}
+
+	token := value
+	var params string
+	if i := strings.Index(value, ";"); i >= 0 {
+		token, params = value[:i], value[i+1:]
+	}
+
+	infoURL := strings.Trim(sipnet.ParsePairs(params).Get("info"), "<>")
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidIdentity
+	}
+
+	var h header
+	var claims Claims
+	if decodeJSON(parts[0], &h) != nil || decodeJSON(parts[1], &claims) != nil {
+		return Claims{}, ErrInvalidIdentity
+	}
+
+	if h.Alg != "ES256" {
+		return Claims{}, ErrInvalidIdentity
+	}
+
+	if infoURL == "" {
+		infoURL = h.X5U
+	}
+
+	cert, err := v.certificate(infoURL)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return Claims{}, ErrInvalidIdentity
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return Claims{}, ErrInvalidIdentity
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return Claims{}, ErrBadSignature
+	}
+
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+
+	issued := time.Unix(claims.IssuedAt, 0)
+	if time.Now().Sub(issued) > maxAge {
+		return claims, ErrExpired
+	}
+
+	expected, err := NewClaims(req, "", "")
+	if err != nil {
+		return claims, err
+	}
+
+	if claims.Orig.TN != expected.Orig.TN || len(claims.Dest.TN) == 0 ||
+		claims.Dest.TN[0] != expected.Dest.TN[0] {
+		return claims, ErrMismatch
+	}
+
+	return claims, nil
+}
+
+func decodeJSON(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// certificate returns the verified certificate at an https URL, fetching
+// its chain if it isn't cached. The chain is verified against the roots
+// each time, so a cached certificate is not used once it expires.
+func (v *Verifier) certificate(certURL string) (*x509.Certificate, error) {
+	parsed, err := url.Parse(certURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, ErrInsecureURL
+	}
+
+	now := time.Now()
+	v.mutex.Lock()
+	cached, found := v.cache[certURL]
+	v.mutex.Unlock()
+
+	chain := cached.chain
+	if !found || now.After(cached.expires) {
+		fetch := v.Fetch
+		if fetch == nil {
+			fetch = fetchCertificate
+		}
+
+		chain, err = fetch(certURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, ErrInvalidIdentity
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         v.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, ErrUntrusted
+	}
+
+	if !found || now.After(cached.expires) {
+		v.store(certURL, chain, now)
+	}
+
+	return chain[0], nil
+}
+
+// store caches a verified chain until the CacheTTL elapses or it expires,
+// evicting expired entries, or any entry if none have, to stay within
+// MaxCached.
+func (v *Verifier) store(certURL string, chain []*x509.Certificate, now time.Time) {
+	ttl := v.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	expires := now.Add(ttl)
+	for _, cert := range chain {
+		if cert.NotAfter.Before(expires) {
+			expires = cert.NotAfter
+		}
+	}
+
+	limit := v.MaxCached
+	if limit <= 0 {
+		limit = DefaultMaxCached
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.cache == nil {
+		v.cache = make(map[string]cachedChain)
+	}
+
+	if _, found := v.cache[certURL]; !found && len(v.cache) >= limit {
+		for key, entry := range v.cache {
+			if now.After(entry.expires) {
+				delete(v.cache, key)
+			}
+		}
+
+		for key := range v.cache {
+			if len(v.cache) < limit {
+				break
+			}
+			delete(v.cache, key)
+		}
+	}
+
+	v.cache[certURL] = cachedChain{chain: chain, expires: expires}
+}
+
+// fetchCertificate fetches the PEM certificate chain at the URL, of up to
+// maxCertificateSize bytes.
+func fetchCertificate(certURL string) ([]*x509.Certificate, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrInvalidIdentity
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCertificateSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxCertificateSize {
+		return nil, ErrTooLarge
+	}
+
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, ErrInvalidIdentity
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,135 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Bandwidth represents a b= line, the proposed bandwidth of a session or
+// media description (RFC 4566 section 5.8).
+type Bandwidth struct {
+	// Type is the bandwidth type, i.e. "AS" (kilobits per second) or "TIAS"
+	// (bits per second, RFC 3890).
+	Type  string
+	Value int
+}
+
+// ParseBandwidth parses the value of a b= line, i.e. "AS:128".
+func ParseBandwidth(value string) (Bandwidth, error) {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return Bandwidth{}, ErrParseError
+	}
+
+	bandwidth, err := strconv.Atoi(strings.TrimSpace(value[i+1:]))
+	if err != nil {
+		return Bandwidth{}, ErrParseError
+	}
+
+	return Bandwidth{Type: value[:i], Value: bandwidth}, nil
+}
+
+// String returns the value of the b= line of the bandwidth.
+func (b Bandwidth) String() string {
+	return b.Type + ":" + strconv.Itoa(b.Value)
+}
+
+func getBandwidths(lines []Line) ([]Bandwidth, error) {
+	var bandwidths []Bandwidth
+	for _, line := range lines {
+		if line.Type != 'b' {
+			continue
+		}
+
+		bandwidth, err := ParseBandwidth(line.Value)
+		if err != nil {
+			return nil, err
+		}
+		bandwidths = append(bandwidths, bandwidth)
+	}
+
+	return bandwidths, nil
+}
+
+func setBandwidth(lines []Line, b Bandwidth, order string) []Line {
+	for i, line := range lines {
+		if line.Type == 'b' && strings.HasPrefix(line.Value, b.Type+":") {
+			lines[i].Value = b.String()
+			return lines
+		}
+	}
+
+	return insertLine(lines, Line{Type: 'b', Value: b.String()}, order)
+}
+
+// Bandwidths returns the session level bandwidths.
+func (s *Session) Bandwidths() ([]Bandwidth, error) {
+	return getBandwidths(s.Lines)
+}
+
+// SetBandwidth sets the session level bandwidth of the given type,
+// replacing any existing bandwidth of the type.
+func (s *Session) SetBandwidth(b Bandwidth) {
+	s.Lines = setBandwidth(s.Lines, b, sessionLineOrder)
+}
+
+// Bandwidths returns the bandwidths of the media description.
+func (m *Media) Bandwidths() ([]Bandwidth, error) {
+	return getBandwidths(m.Lines)
+}
+
+// SetBandwidth sets the bandwidth of the given type of the media
+// description, replacing any existing bandwidth of the type.
+func (m *Media) SetBandwidth(b Bandwidth) {
+	m.Lines = setBandwidth(m.Lines, b, mediaLineOrder)
+}
+
+// Key represents a k= line, the encryption key of a session or media
+// description (RFC 4566 section 5.12).
+type Key struct {
+	// Method is the method of obtaining the key, i.e. "clear", "base64",
+	// "uri" or "prompt".
+	Method string
+	Key    string
+}
+
+// ParseKey parses the value of a k= line, i.e. "base64:c2VjcmV0".
+func ParseKey(value string) Key {
+	if i := strings.Index(value, ":"); i >= 0 {
+		return Key{Method: value[:i], Key: value[i+1:]}
+	}
+
+	return Key{Method: value}
+}
+
+// String returns the value of the k= line of the key.
+func (k Key) String() string {
+	if k.Key == "" {
+		return k.Method
+	}
+	return k.Method + ":" + k.Key
+}
+
+// EncryptionKey returns the encryption key of a media description, which
+// may be set at the media or session level, and whether it has one.
+func (s *Session) EncryptionKey(m *Media) (Key, bool) {
+	value := m.Get('k')
+	if value == "" {
+		value = s.Get('k')
+	}
+
+	if value == "" {
+		return Key{}, false
+	}
+	return ParseKey(value), true
+}
+
+// SetEncryptionKey sets the session level encryption key.
+func (s *Session) SetEncryptionKey(k Key) {
+	s.Lines = setLine(s.Lines, 'k', k.String(), sessionLineOrder)
+}
+
+// SetEncryptionKey sets the encryption key of the media description.
+func (m *Media) SetEncryptionKey(k Key) {
+	m.Lines = setLine(m.Lines, 'k', k.String(), mediaLineOrder)
+}
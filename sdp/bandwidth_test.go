@@ -0,0 +1,92 @@
+package sdp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const bandwidthOffer = "v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 192.0.2.1\r\n" +
+	"b=AS:128\r\n" +
+	"t=0 0\r\n" +
+	"k=prompt\r\n" +
+	"m=audio 49170 RTP/AVP 0\r\n" +
+	"b=AS:64\r\n" +
+	"b=TIAS:64000\r\n" +
+	"k=base64:c2VjcmV0\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"m=video 51372 RTP/AVP 31\r\n" +
+	"a=rtpmap:31 H261/90000\r\n"
+
+func TestParseBandwidths(t *testing.T) {
+	session, err := Parse([]byte(bandwidthOffer))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	bandwidths, err := session.Bandwidths()
+	if err != nil {
+		t.Fatalf("failed to parse session bandwidths: %v", err)
+	}
+	if expected := []Bandwidth{{"AS", 128}}; !reflect.DeepEqual(bandwidths, expected) {
+		t.Errorf("session bandwidths are %v, expected %v", bandwidths, expected)
+	}
+
+	bandwidths, err = session.Media[0].Bandwidths()
+	if err != nil {
+		t.Fatalf("failed to parse media bandwidths: %v", err)
+	}
+	expected := []Bandwidth{{"AS", 64}, {"TIAS", 64000}}
+	if !reflect.DeepEqual(bandwidths, expected) {
+		t.Errorf("media bandwidths are %v, expected %v", bandwidths, expected)
+	}
+
+	if _, err := ParseBandwidth("AS:lots"); err != ErrParseError {
+		t.Errorf("parsing a malformed bandwidth returned %v", err)
+	}
+}
+
+func TestSetBandwidth(t *testing.T) {
+	session, err := Parse([]byte(bandwidthOffer))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	session.SetBandwidth(Bandwidth{"AS", 256})
+	session.Media[1].SetBandwidth(Bandwidth{"AS", 512})
+	body := string(session.Bytes())
+
+	if !strings.Contains(body, "c=IN IP4 192.0.2.1\r\nb=AS:256\r\nt=0 0\r\n") ||
+		strings.Contains(body, "b=AS:128") {
+		t.Errorf("session bandwidth wasn't replaced in place:\n%s", body)
+	}
+	if !strings.Contains(body, "m=video 51372 RTP/AVP 31\r\nb=AS:512\r\n"+
+		"a=rtpmap:31 H261/90000\r\n") {
+		t.Errorf("media bandwidth wasn't added before the attributes:\n%s", body)
+	}
+}
+
+func TestEncryptionKey(t *testing.T) {
+	session, err := Parse([]byte(bandwidthOffer))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	// The audio has its own key, and the video the session level key.
+	if key, found := session.EncryptionKey(session.Media[0]); !found ||
+		key != (Key{Method: "base64", Key: "c2VjcmV0"}) {
+		t.Errorf("audio key is %v, %v", key, found)
+	}
+	if key, found := session.EncryptionKey(session.Media[1]); !found ||
+		key != (Key{Method: "prompt"}) {
+		t.Errorf("video key is %v, %v", key, found)
+	}
+
+	session.Media[1].SetEncryptionKey(Key{Method: "clear", Key: "secret"})
+	if key, _ := session.EncryptionKey(session.Media[1]); key.String() != "clear:secret" {
+		t.Errorf("video key is %q after setting it", key)
+	}
+}
@@ -0,0 +1,74 @@
+package sdp
+
+// Direction is the direction of a media stream, given by its a=sendrecv,
+// a=sendonly, a=recvonly or a=inactive attribute (RFC 3264).
+type Direction string
+
+// Media directions.
+const (
+	SendRecv Direction = "sendrecv"
+	SendOnly Direction = "sendonly"
+	RecvOnly Direction = "recvonly"
+	Inactive Direction = "inactive"
+)
+
+var directions = []Direction{SendRecv, SendOnly, RecvOnly, Inactive}
+
+// Reverse returns the direction of the answer to an offer with the
+// direction, from the point of view of the answerer, i.e. sendonly for
+// recvonly.
+func (d Direction) Reverse() Direction {
+	switch d {
+	case SendOnly:
+		return RecvOnly
+	case RecvOnly:
+		return SendOnly
+	default:
+		return d
+	}
+}
+
+func getDirection(lines []Line) (Direction, bool) {
+	for _, d := range directions {
+		if _, found := getAttribute(lines, string(d)); found {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+// Direction returns the direction of a media description, which may be set
+// at the media or session level. The default is sendrecv.
+func (s *Session) Direction(m *Media) Direction {
+	if d, found := getDirection(m.Lines); found {
+		return d
+	}
+	if d, found := getDirection(s.Lines); found {
+		return d
+	}
+	return SendRecv
+}
+
+// SetDirection sets the direction of the media description, replacing any
+// existing direction attribute.
+func (m *Media) SetDirection(d Direction) {
+	var lines []Line
+	for _, line := range m.Lines {
+		if key, _ := splitAttribute(line.Value); line.Type == 'a' &&
+			isDirection(key) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	m.Lines = append(lines, Line{Type: 'a', Value: string(d)})
+}
+
+func isDirection(name string) bool {
+	for _, d := range directions {
+		if string(d) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package sdp
+
+import (
+	"strings"
+	"testing"
+)
+
+const directionOffer = "v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 192.0.2.1\r\n" +
+	"t=0 0\r\n" +
+	"a=recvonly\r\n" +
+	"m=audio 49170 RTP/AVP 0\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"m=video 51372 RTP/AVP 31\r\n" +
+	"a=rtpmap:31 H261/90000\r\n" +
+	"a=inactive\r\n"
+
+func TestDirection(t *testing.T) {
+	session, err := Parse([]byte(directionOffer))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	// The audio has the session level direction, and the video its own.
+	if d := session.Direction(session.Media[0]); d != RecvOnly {
+		t.Errorf("audio is %s, expected recvonly", d)
+	}
+	if d := session.Direction(session.Media[1]); d != Inactive {
+		t.Errorf("video is %s, expected inactive", d)
+	}
+
+	session.Lines = session.Lines[:len(session.Lines)-1]
+	if d := session.Direction(session.Media[0]); d != SendRecv {
+		t.Errorf("audio without a direction is %s, expected sendrecv", d)
+	}
+}
+
+func TestSetDirection(t *testing.T) {
+	session, err := Parse([]byte(directionOffer))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	video := session.Media[1]
+	video.SetDirection(SendOnly)
+	if d := session.Direction(video); d != SendOnly {
+		t.Errorf("video is %s after setting it, expected sendonly", d)
+	}
+
+	str := string(session.Bytes())
+	str = str[strings.Index(str, "m=video"):]
+	if strings.Contains(str, "a=inactive") ||
+		strings.Count(str, "a=sendonly") != 1 {
+		t.Errorf("direction wasn't replaced:\n%s", str)
+	}
+	if !strings.Contains(str, "a=rtpmap:31 H261/90000") {
+		t.Errorf("other attributes were removed:\n%s", str)
+	}
+}
+
+func TestDirectionReverse(t *testing.T) {
+	tests := map[Direction]Direction{
+		SendRecv: SendRecv,
+		SendOnly: RecvOnly,
+		RecvOnly: SendOnly,
+		Inactive: Inactive,
+	}
+
+	for offer, answer := range tests {
+		if reversed := offer.Reverse(); reversed != answer {
+			t.Errorf("%s reversed to %s, expected %s", offer, reversed, answer)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package sdp
+
+import (
+	"net"
+	"strings"
+)
+
+// HoldMethod is how a media stream is put on hold.
+type HoldMethod int
+
+// Hold methods.
+const (
+	// NotHeld is a media stream which is not on hold.
+	NotHeld HoldMethod = iota
+	// HoldConnection is the hold of RFC 2543, with a null connection
+	// address (c=IN IP4 0.0.0.0).
+	HoldConnection
+	// HoldDirection is the hold of RFC 3264, with an a=sendonly or
+	// a=inactive direction.
+	HoldDirection
+)
+
+// Connection returns the connection address of a media description (the
+// address of its c= line), which may be set at the media or session level.
+func (s *Session) Connection(m *Media) string {
+	value := m.Get('c')
+	if value == "" {
+		value = s.Get('c')
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return ""
+	}
+
+	// Strip the TTL and number of addresses of multicast addresses.
+	return strings.Split(fields[2], "/")[0]
+}
+
+// SetConnection sets the connection address of the media description,
+// with an IP4 or IP6 address type as appropriate.
+func (m *Media) SetConnection(addr string) {
+	addrType := "IP4"
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		addrType = "IP6"
+	}
+
+	m.Lines = setLine(m.Lines, 'c', "IN "+addrType+" "+addr, mediaLineOrder)
+}
+
+// Hold returns how a media description was put on hold, or NotHeld if it
+// is not on hold. A recvonly stream is not on hold, as the remote UA is
+// holding the local UA.
+func (s *Session) Hold(m *Media) HoldMethod {
+	addr := s.Connection(m)
+	if addr == "0.0.0.0" {
+		return HoldConnection
+	}
+
+	switch s.Direction(m) {
+	case SendOnly, Inactive:
+		return HoldDirection
+	}
+
+	return NotHeld
+}
+
+// SetHold puts the media description on hold with the given method. The
+// RFC 3264 method is recommended, as the connection address is kept. Use
+// Resume to take the media description off hold.
+func (m *Media) SetHold(method HoldMethod) {
+	switch method {
+	case HoldConnection:
+		m.SetConnection("0.0.0.0")
+	case HoldDirection:
+		m.SetDirection(SendOnly)
+	}
+}
+
+// Resume takes the media description off hold, setting its direction to
+// sendrecv. If addr is not empty, the connection address is set to addr,
+// which is needed to resume from an RFC 2543 hold.
+func (m *Media) Resume(addr string) {
+	if addr != "" {
+		m.SetConnection(addr)
+	}
+	m.SetDirection(SendRecv)
+}
+
+// The order of the lines of a session and a media description (RFC 4566).
+const (
+	sessionLineOrder = "vosiuepcbtrzka"
+	mediaLineOrder   = "icbka"
+)
+
+// setLine replaces the value of the first line of the given type, or adds
+// the line in its place in the given order.
+func setLine(lines []Line, lineType byte, value, order string) []Line {
+	for i, line := range lines {
+		if line.Type == lineType {
+			lines[i].Value = value
+			return lines
+		}
+	}
+
+	return insertLine(lines, Line{Type: lineType, Value: value}, order)
+}
+
+// insertLine adds a line after the existing lines which come before or with
+// it in the given order.
+func insertLine(lines []Line, line Line, order string) []Line {
+	position := strings.IndexByte(order, line.Type)
+	for i, existing := range lines {
+		if strings.IndexByte(order, existing.Type) > position {
+			result := append([]Line(nil), lines[:i]...)
+			result = append(result, line)
+			return append(result, lines[i:]...)
+		}
+	}
+
+	return append(lines, line)
+}
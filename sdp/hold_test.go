@@ -0,0 +1,90 @@
+package sdp
+
+import (
+	"strings"
+	"testing"
+)
+
+// holdSession returns an audio session with the given connection address
+// and direction attribute, if any.
+func holdSession(t *testing.T, connection, direction string) *Session {
+	t.Helper()
+
+	body := "v=0\r\n" +
+		"o=alice 2890844526 2890844527 IN IP4 192.0.2.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 " + connection + "\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 49170 RTP/AVP 0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n"
+	if direction != "" {
+		body += "a=" + direction + "\r\n"
+	}
+
+	session, err := Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	return session
+}
+
+func TestHold(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection string
+		direction  string
+		expected   HoldMethod
+	}{
+		{"active", "192.0.2.1", "", NotHeld},
+		{"RFC 2543", "0.0.0.0", "", HoldConnection},
+		{"RFC 3264 sendonly", "192.0.2.1", "sendonly", HoldDirection},
+		{"RFC 3264 inactive", "192.0.2.1", "inactive", HoldDirection},
+		{"held by the remote UA", "192.0.2.1", "recvonly", NotHeld},
+	}
+
+	for _, test := range tests {
+		session := holdSession(t, test.connection, test.direction)
+		if hold := session.Hold(session.Media[0]); hold != test.expected {
+			t.Errorf("%s: hold is %v, expected %v", test.name, hold,
+				test.expected)
+		}
+	}
+}
+
+func TestSetHoldAndResume(t *testing.T) {
+	// The RFC 2543 hold replaces the connection address, so it is given
+	// again when resuming.
+	session := holdSession(t, "192.0.2.1", "")
+	media := session.Media[0]
+	media.SetHold(HoldConnection)
+	if hold := session.Hold(media); hold != HoldConnection {
+		t.Errorf("hold is %v, expected HoldConnection", hold)
+	}
+	if !strings.Contains(string(session.Bytes()), "c=IN IP4 0.0.0.0\r\n") {
+		t.Errorf("null connection wasn't set:\n%s", session.Bytes())
+	}
+
+	media.Resume("192.0.2.1")
+	if hold := session.Hold(media); hold != NotHeld {
+		t.Errorf("hold is %v after resuming, expected NotHeld", hold)
+	}
+	if addr := session.Connection(media); addr != "192.0.2.1" {
+		t.Errorf("connection is %q after resuming, expected 192.0.2.1", addr)
+	}
+
+	// The RFC 3264 hold keeps the connection address.
+	session = holdSession(t, "192.0.2.1", "")
+	media = session.Media[0]
+	media.SetHold(HoldDirection)
+	if hold := session.Hold(media); hold != HoldDirection {
+		t.Errorf("hold is %v, expected HoldDirection", hold)
+	}
+	if addr := session.Connection(media); addr != "192.0.2.1" {
+		t.Errorf("connection is %q on hold, expected 192.0.2.1", addr)
+	}
+
+	media.Resume("")
+	if d := session.Direction(media); d != SendRecv {
+		t.Errorf("direction is %s after resuming, expected sendrecv", d)
+	}
+}
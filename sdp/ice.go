@@ -0,0 +1,166 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Candidate represents an ICE candidate (RFC 8839) of an a=candidate
+// attribute.
+type Candidate struct {
+	Foundation string
+	Component  int
+	Transport  string
+	Priority   uint32
+	Address    string
+	Port       int
+	Type       string
+
+	// Extensions are the remaining fields after the candidate type, such as
+	// "raddr", "rport" and "generation" with their values, kept as is.
+	Extensions []string
+}
+
+// ParseCandidate parses the value of an a=candidate attribute.
+func ParseCandidate(value string) (Candidate, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return Candidate{}, ErrParseError
+	}
+
+	component, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Candidate{}, ErrParseError
+	}
+
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return Candidate{}, ErrParseError
+	}
+
+	port, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return Candidate{}, ErrParseError
+	}
+
+	return Candidate{
+		Foundation: fields[0],
+		Component:  component,
+		Transport:  fields[2],
+		Priority:   uint32(priority),
+		Address:    fields[4],
+		Port:       port,
+		Type:       fields[7],
+		Extensions: fields[8:],
+	}, nil
+}
+
+// String returns the value of the a=candidate attribute of the candidate.
+func (c Candidate) String() string {
+	fields := append([]string{
+		c.Foundation,
+		strconv.Itoa(c.Component),
+		c.Transport,
+		strconv.FormatUint(uint64(c.Priority), 10),
+		c.Address,
+		strconv.Itoa(c.Port),
+		"typ",
+		c.Type,
+	}, c.Extensions...)
+	return strings.Join(fields, " ")
+}
+
+// Candidates returns the ICE candidates of the media description in order.
+func (m *Media) Candidates() ([]Candidate, error) {
+	var candidates []Candidate
+	for _, value := range m.Attributes("candidate") {
+		candidate, err := ParseCandidate(value)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// SetCandidates replaces the ICE candidates of the media description. The
+// new candidates take the place of the first existing candidate, or are
+// added to the end if there were none.
+func (m *Media) SetCandidates(candidates []Candidate) {
+	values := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		values[i] = candidate.String()
+	}
+
+	m.Lines = replaceAttributes(m.Lines, "candidate", values)
+}
+
+// Fingerprint represents a DTLS certificate fingerprint (RFC 8122) of an
+// a=fingerprint attribute.
+type Fingerprint struct {
+	// Hash is the hash function, i.e. "sha-256".
+	Hash string
+
+	// Value is the fingerprint as upper case hex with colons.
+	Value string
+}
+
+// ParseFingerprint parses the value of an a=fingerprint attribute.
+func ParseFingerprint(value string) (Fingerprint, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return Fingerprint{}, ErrParseError
+	}
+
+	return Fingerprint{Hash: fields[0], Value: fields[1]}, nil
+}
+
+// String returns the value of the a=fingerprint attribute.
+func (f Fingerprint) String() string {
+	return f.Hash + " " + f.Value
+}
+
+// ICECredentials returns the ICE username fragment and password of a media
+// description, which may be set at the media or session level.
+func (s *Session) ICECredentials(m *Media) (ufrag, pwd string) {
+	ufrag, found := m.Attribute("ice-ufrag")
+	if !found {
+		ufrag, _ = s.Attribute("ice-ufrag")
+	}
+
+	pwd, found = m.Attribute("ice-pwd")
+	if !found {
+		pwd, _ = s.Attribute("ice-pwd")
+	}
+
+	return ufrag, pwd
+}
+
+// SetICECredentials sets the ICE username fragment and password of the
+// media description.
+func (m *Media) SetICECredentials(ufrag, pwd string) {
+	m.SetAttribute("ice-ufrag", ufrag)
+	m.SetAttribute("ice-pwd", pwd)
+}
+
+// Fingerprint returns the DTLS fingerprint of a media description, which
+// may be set at the media or session level, and whether it has one.
+func (s *Session) Fingerprint(m *Media) (Fingerprint, bool, error) {
+	value, found := m.Attribute("fingerprint")
+	if !found {
+		value, found = s.Attribute("fingerprint")
+	}
+
+	if !found {
+		return Fingerprint{}, false, nil
+	}
+
+	fingerprint, err := ParseFingerprint(value)
+	return fingerprint, err == nil, err
+}
+
+// SetFingerprint sets the DTLS fingerprint of the media description.
+func (m *Media) SetFingerprint(f Fingerprint) {
+	m.SetAttribute("fingerprint", f.String())
+}
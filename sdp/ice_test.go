@@ -0,0 +1,106 @@
+package sdp
+
+import "testing"
+
+const webRTCOffer = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=group:BUNDLE 0\r\n" +
+	"a=ice-ufrag:F7gI\r\n" +
+	"a=ice-pwd:x9cml/YzichV2+XlhiMu8g1F\r\n" +
+	"a=fingerprint:sha-256 D2:FA:0E:C3:22:59:5E:14:95:69:92:3D:13:B4:84:24:" +
+	"2C:C2:A2:C0:3E:FD:34:8E:5E:EA:6F:AF:52:CE:E6:0F\r\n" +
+	"m=audio 54400 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"c=IN IP4 198.51.100.7\r\n" +
+	"a=mid:0\r\n" +
+	"a=candidate:1467250027 1 udp 2122260223 192.168.0.196 46243 typ host generation 0\r\n" +
+	"a=candidate:435653019 1 tcp 1845501695 198.51.100.7 0 typ srflx raddr 192.168.0.196 rport 0 tcptype active\r\n" +
+	"a=setup:actpass\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n"
+
+func TestParseWebRTCOffer(t *testing.T) {
+	session, err := Parse([]byte(webRTCOffer))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	media := session.Media[0]
+
+	candidates, err := media.Candidates()
+	if err != nil {
+		t.Fatalf("failed to parse candidates: %v", err)
+	}
+	if len(candidates) != 2 {
+		t.Fatalf("parsed %d candidates, expected 2", len(candidates))
+	}
+	host := candidates[0]
+	if host.Foundation != "1467250027" || host.Component != 1 ||
+		host.Transport != "udp" || host.Priority != 2122260223 ||
+		host.Address != "192.168.0.196" || host.Port != 46243 ||
+		host.Type != "host" {
+		t.Errorf("parsed host candidate %+v", host)
+	}
+	if srflx := candidates[1]; srflx.Type != "srflx" || len(srflx.Extensions) != 6 {
+		t.Errorf("parsed server reflexive candidate %+v", srflx)
+	}
+
+	ufrag, pwd := session.ICECredentials(media)
+	if ufrag != "F7gI" || pwd != "x9cml/YzichV2+XlhiMu8g1F" {
+		t.Errorf("ICE credentials are %q, %q", ufrag, pwd)
+	}
+
+	fingerprint, found, err := session.Fingerprint(media)
+	if err != nil || !found || fingerprint.Hash != "sha-256" {
+		t.Errorf("fingerprint is %+v, %v, %v", fingerprint, found, err)
+	}
+
+	_, err = ParseCandidate("1 1 udp 2130706431 203.0.113.1 30000 host")
+	if err != ErrParseError {
+		t.Errorf("parsed a candidate without typ with %v", err)
+	}
+}
+
+func TestWebRTCOfferRoundTrip(t *testing.T) {
+	session, err := Parse([]byte(webRTCOffer))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	media := session.Media[0]
+
+	// Setting the parsed attributes again leaves them intact.
+	candidates, _ := media.Candidates()
+	media.SetCandidates(candidates)
+	fingerprint, _, _ := session.Fingerprint(media)
+	session.Lines = setAttribute(session.Lines, "fingerprint",
+		fingerprint.String())
+
+	if body := string(session.Bytes()); body != webRTCOffer {
+		t.Errorf("re-serialized offer differs:\n%s\nexpected:\n%s", body,
+			webRTCOffer)
+	}
+}
+
+func TestSetCandidates(t *testing.T) {
+	session, err := Parse([]byte(webRTCOffer))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	media := session.Media[0]
+
+	// A B2BUA relaying media replaces the candidates with its own.
+	relay, err := ParseCandidate("1 1 udp 2130706431 203.0.113.1 30000 typ host")
+	if err != nil {
+		t.Fatalf("failed to parse candidate: %v", err)
+	}
+	media.SetCandidates([]Candidate{relay})
+	media.SetICECredentials("b2bu", "relaypassword0000000000")
+
+	candidates, _ := media.Candidates()
+	if len(candidates) != 1 || candidates[0].Address != "203.0.113.1" {
+		t.Errorf("candidates are %+v, expected the relay", candidates)
+	}
+	if ufrag, pwd := session.ICECredentials(media); ufrag != "b2bu" ||
+		pwd != "relaypassword0000000000" {
+		t.Errorf("ICE credentials are %q, %q", ufrag, pwd)
+	}
+}
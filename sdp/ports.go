@@ -0,0 +1,78 @@
+package sdp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrPortsExhausted is returned by PortAllocator.Allocate if there are no
+// free ports left in its range.
+var ErrPortsExhausted = errors.New("sdp: ports exhausted")
+
+// PortAllocator allocates RTP ports from a range. RTP ports are always even,
+// with the following odd port reserved for RTCP.
+type PortAllocator struct {
+	min   int
+	max   int
+	next  int
+	used  map[int]bool
+	mutex *sync.Mutex
+}
+
+// NewPortAllocator returns a new port allocator for the range of ports
+// between min and max inclusive.
+func NewPortAllocator(min, max int) *PortAllocator {
+	if min%2 != 0 {
+		min++
+	}
+
+	return &PortAllocator{
+		min:   min,
+		max:   max,
+		next:  min,
+		used:  make(map[int]bool),
+		mutex: new(sync.Mutex),
+	}
+}
+
+// Allocate reserves and returns an even RTP port. The following odd port is
+// reserved for RTCP.
+func (a *PortAllocator) Allocate() (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for i := a.min; i+1 <= a.max; i += 2 {
+		port := a.next
+		a.next += 2
+		if a.next+1 > a.max {
+			a.next = a.min
+		}
+
+		if !a.used[port] {
+			a.used[port] = true
+			return port, nil
+		}
+	}
+
+	return 0, ErrPortsExhausted
+}
+
+// Release releases an RTP port (and its RTCP port) returned by Allocate.
+func (a *PortAllocator) Release(port int) {
+	a.mutex.Lock()
+	delete(a.used, port)
+	a.mutex.Unlock()
+}
+
+// SetPort sets the RTP port of the media description, updating its
+// a=rtcp attribute if it has one.
+func (m *Media) SetPort(port int) {
+	m.Port = port
+	if value, found := m.Attribute("rtcp"); found && value != "" {
+		fields := strings.Fields(value)
+		fields[0] = strconv.Itoa(port + 1)
+		m.SetAttribute("rtcp", strings.Join(fields, " "))
+	}
+}
@@ -0,0 +1,62 @@
+package sdp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPortAllocatorPairs(t *testing.T) {
+	// An odd minimum is rounded up, so RTP ports are always even.
+	allocator := NewPortAllocator(10001, 10007)
+
+	expected := []int{10002, 10004, 10006}
+	for _, port := range expected {
+		allocated, err := allocator.Allocate()
+		if err != nil {
+			t.Fatalf("failed to allocate: %v", err)
+		}
+		if allocated != port {
+			t.Errorf("allocated %d, expected %d", allocated, port)
+		}
+	}
+}
+
+func TestPortAllocatorExhaustion(t *testing.T) {
+	// Port 10004 can't be allocated, as its RTCP port is out of range.
+	allocator := NewPortAllocator(10000, 10004)
+
+	first, _ := allocator.Allocate()
+	second, _ := allocator.Allocate()
+	if first != 10000 || second != 10002 {
+		t.Fatalf("allocated %d and %d, expected 10000 and 10002", first, second)
+	}
+
+	if _, err := allocator.Allocate(); err != ErrPortsExhausted {
+		t.Errorf("allocated from a full range with %v, expected "+
+			"ErrPortsExhausted", err)
+	}
+
+	allocator.Release(first)
+	port, err := allocator.Allocate()
+	if err != nil || port != first {
+		t.Errorf("allocated %d, %v after releasing, expected %d", port, err, first)
+	}
+}
+
+func TestSetPort(t *testing.T) {
+	session, err := Parse([]byte("v=0\r\n" +
+		"m=audio 49170 RTP/AVP 0\r\n" +
+		"a=rtcp:49171 IN IP4 127.0.0.1\r\n"))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	session.Media[0].SetPort(20000)
+	body := string(session.Bytes())
+	if !strings.Contains(body, "m=audio 20000 RTP/AVP 0\r\n") {
+		t.Errorf("media port wasn't set: %q", body)
+	}
+	if !strings.Contains(body, "a=rtcp:20001 IN IP4 127.0.0.1\r\n") {
+		t.Errorf("RTCP port wasn't set: %q", body)
+	}
+}
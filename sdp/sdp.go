@@ -0,0 +1,266 @@
+// Package sdp contains tools for parsing and manipulating SDP (Session
+// Description Protocol) bodies as defined in RFC 4566.
+package sdp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrParseError is returned when an SDP body fails to be parsed.
+var ErrParseError = errors.New("sdp: parse error")
+
+// Line represents a single type=value line of an SDP body.
+type Line struct {
+	Type  byte
+	Value string
+}
+
+// Session represents a session description. Session level lines are kept
+// in the order they appeared, followed by the media descriptions.
+type Session struct {
+	Lines []Line
+	Media []*Media
+}
+
+// Media represents a media description, starting with its m= line.
+type Media struct {
+	Type     string
+	Port     int
+	NumPorts int
+	Proto    string
+	Formats  []string
+	Lines    []Line
+}
+
+// Parse parses an SDP body into a Session.
+func Parse(data []byte) (*Session, error) {
+	session := new(Session)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+
+		if len(text) < 2 || text[1] != '=' {
+			return nil, ErrParseError
+		}
+
+		line := Line{Type: text[0], Value: text[2:]}
+		if line.Type == 'm' {
+			media, err := parseMedia(line.Value)
+			if err != nil {
+				return nil, err
+			}
+			session.Media = append(session.Media, media)
+			continue
+		}
+
+		if len(session.Media) > 0 {
+			media := session.Media[len(session.Media)-1]
+			media.Lines = append(media.Lines, line)
+		} else {
+			session.Lines = append(session.Lines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func parseMedia(value string) (*Media, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, ErrParseError
+	}
+
+	media := &Media{
+		Type:    fields[0],
+		Proto:   fields[2],
+		Formats: fields[3:],
+	}
+
+	port := fields[1]
+	if i := strings.Index(port, "/"); i >= 0 {
+		numPorts, err := strconv.Atoi(port[i+1:])
+		if err != nil {
+			return nil, ErrParseError
+		}
+		media.NumPorts = numPorts
+		port = port[:i]
+	}
+
+	var err error
+	media.Port, err = strconv.Atoi(port)
+	if err != nil {
+		return nil, ErrParseError
+	}
+
+	return media, nil
+}
+
+// Bytes returns the serialized SDP body.
+func (s *Session) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	writeLines(buf, s.Lines)
+	for _, media := range s.Media {
+		buf.WriteString("m=" + media.String() + "\r\n")
+		writeLines(buf, media.Lines)
+	}
+
+	return buf.Bytes()
+}
+
+func writeLines(buf *bytes.Buffer, lines []Line) {
+	for _, line := range lines {
+		buf.WriteByte(line.Type)
+		buf.WriteString("=" + line.Value + "\r\n")
+	}
+}
+
+// String returns the value of the m= line of the media description.
+func (m *Media) String() string {
+	port := strconv.Itoa(m.Port)
+	if m.NumPorts > 0 {
+		port += "/" + strconv.Itoa(m.NumPorts)
+	}
+
+	fields := append([]string{m.Type, port, m.Proto}, m.Formats...)
+	return strings.Join(fields, " ")
+}
+
+// Get returns the value of the first line of the given type. It returns an
+// empty string if there is no such line.
+func (s *Session) Get(lineType byte) string {
+	return getLine(s.Lines, lineType)
+}
+
+// Get returns the value of the first line of the given type. It returns an
+// empty string if there is no such line.
+func (m *Media) Get(lineType byte) string {
+	return getLine(m.Lines, lineType)
+}
+
+func getLine(lines []Line, lineType byte) string {
+	for _, line := range lines {
+		if line.Type == lineType {
+			return line.Value
+		}
+	}
+
+	return ""
+}
+
+// Attribute returns the value of the first a= attribute with the given name,
+// and whether the attribute exists.
+func (m *Media) Attribute(name string) (string, bool) {
+	return getAttribute(m.Lines, name)
+}
+
+// Attribute returns the value of the first session level a= attribute with
+// the given name, and whether the attribute exists.
+func (s *Session) Attribute(name string) (string, bool) {
+	return getAttribute(s.Lines, name)
+}
+
+func getAttribute(lines []Line, name string) (string, bool) {
+	for _, line := range lines {
+		if line.Type != 'a' {
+			continue
+		}
+
+		key, value := splitAttribute(line.Value)
+		if key == name {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// Attributes returns the values of all a= attributes with the given name in
+// order, for attributes which may appear multiple times.
+func (m *Media) Attributes(name string) []string {
+	var values []string
+	for _, line := range m.Lines {
+		if line.Type != 'a' {
+			continue
+		}
+
+		if key, value := splitAttribute(line.Value); key == name {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// SetAttribute replaces the value of the first a= attribute with the given
+// name, or adds the attribute if it doesn't exist. An empty value sets
+// a property attribute (i.e. a=name).
+func (m *Media) SetAttribute(name, value string) {
+	m.Lines = setAttribute(m.Lines, name, value)
+}
+
+func setAttribute(lines []Line, name, value string) []Line {
+	attr := name
+	if value != "" {
+		attr += ":" + value
+	}
+
+	for i, line := range lines {
+		if line.Type != 'a' {
+			continue
+		}
+
+		if key, _ := splitAttribute(line.Value); key == name {
+			lines[i].Value = attr
+			return lines
+		}
+	}
+
+	return append(lines, Line{Type: 'a', Value: attr})
+}
+
+// replaceAttributes replaces all a= attributes with the given name by the
+// given values, in place of the first existing attribute.
+func replaceAttributes(lines []Line, name string, values []string) []Line {
+	var result []Line
+	inserted := false
+	for _, line := range lines {
+		if key, _ := splitAttribute(line.Value); line.Type != 'a' || key != name {
+			result = append(result, line)
+			continue
+		}
+
+		if !inserted {
+			for _, value := range values {
+				result = append(result, Line{Type: 'a', Value: name + ":" + value})
+			}
+			inserted = true
+		}
+	}
+
+	if !inserted {
+		for _, value := range values {
+			result = append(result, Line{Type: 'a', Value: name + ":" + value})
+		}
+	}
+
+	return result
+}
+
+func splitAttribute(value string) (string, string) {
+	if i := strings.Index(value, ":"); i >= 0 {
+		return value[:i], value[i+1:]
+	}
+
+	return value, ""
+}
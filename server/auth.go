@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,6 +27,10 @@ type authSession struct {
 var authSessions = make(map[string]authSession)
 var authSessionMutex = new(sync.Mutex)
 
+// nonces issues the nonces of challenges, and rejects stale or replayed
+// nonces.
+var nonces sipnet.NonceStore = sipnet.NewMemoryNonceStore(time.Minute)
+
 // ErrInvalidAuthHeader is returned when the Authorization header fails to be
 // parsed.
 var ErrInvalidAuthHeader = errors.New("server: invalid authorization header")
@@ -47,7 +52,8 @@ func parseAuthHeader(header string) (sipnet.HeaderArgs, error) {
 	return sipnet.ParsePairs(header[7:]), nil
 }
 
-func requestAuthentication(r *sipnet.Request, conn *sipnet.Conn, from sipnet.User) {
+func requestAuthentication(r *sipnet.Request, conn *sipnet.Conn,
+	from sipnet.User, stale bool) {
 	resp := sipnet.NewResponse()
 
 	callID := r.Header.Get("Call-ID")
@@ -56,7 +62,7 @@ func requestAuthentication(r *sipnet.Request, conn *sipnet.Conn, from sipnet.Use
 		return
 	}
 
-	nonce := generateNonce(32)
+	nonce := nonces.Issue()
 
 	resp.StatusCode = sipnet.StatusUnauthorized
 	// No auth header, deny.
@@ -69,7 +75,11 @@ func requestAuthentication(r *sipnet.Request, conn *sipnet.Conn, from sipnet.Use
 	authArgs.Set("qop", "auth")
 	authArgs.Set("nonce", nonce)
 	authArgs.Set("opaque", "")
-	authArgs.Set("stale", "FALSE")
+	if stale {
+		authArgs.Set("stale", "TRUE")
+	} else {
+		authArgs.Set("stale", "FALSE")
+	}
 	authArgs.Set("algorithm", "MD5")
 	resp.Header.Set("WWW-Authenticate", "Digest "+authArgs.CommaString())
 
@@ -98,24 +108,24 @@ func checkAuthorization(r *sipnet.Request, conn *sipnet.Conn,
 	session, found := authSessions[callID]
 	authSessionMutex.Unlock()
 	if !found {
-		requestAuthentication(r, conn, user)
+		requestAuthentication(r, conn, user, false)
 		return
 	}
 
 	if authArgs.Get("username") != user.URI.Username {
-		requestAuthentication(r, conn, user)
+		requestAuthentication(r, conn, user, false)
 		return
 	}
 
 	if authArgs.Get("nonce") != session.nonce {
-		requestAuthentication(r, conn, user)
+		requestAuthentication(r, conn, user, false)
 		return
 	}
 
 	username := user.URI.Username
 	account, found := accounts[username]
 	if !found {
-		requestAuthentication(r, conn, user)
+		requestAuthentication(r, conn, user, false)
 		return
 	}
 
@@ -125,7 +135,40 @@ func checkAuthorization(r *sipnet.Request, conn *sipnet.Conn,
 		":" + authArgs.Get("cnonce") + ":auth:" + ha2)
 
 	if response != authArgs.Get("response") {
-		requestAuthentication(r, conn, user)
+		requestAuthentication(r, conn, user, false)
+		return
+	}
+
+	nc, err := strconv.ParseUint(authArgs.Get("nc"), 16, 32)
+	if err != nil {
+		requestAuthentication(r, conn, user, false)
+		return
+	}
+
+	err = nonces.Use(session.nonce, uint32(nc))
+	if err != nil {
+		requestAuthentication(r, conn, user, err == sipnet.ErrStaleNonce)
+		return
+	}
+
+	path, err := sipnet.ParsePath(r.Header)
+	if err != nil {
+		resp := sipnet.NewResponse()
+		resp.BadRequest(conn, r, "Failed to parse Path header.")
+		return
+	}
+
+	contacts, err := registeredContacts(r, username)
+	if err != nil {
+		resp := sipnet.NewResponse()
+		resp.BadRequest(conn, r, "Failed to parse Contact header.")
+		return
+	}
+
+	flows, err := sipnet.RegistrationFlows(r)
+	if err != nil {
+		resp := sipnet.NewResponse()
+		resp.BadRequest(conn, r, "Failed to parse Contact header.")
 		return
 	}
 
@@ -133,9 +176,10 @@ func checkAuthorization(r *sipnet.Request, conn *sipnet.Conn,
 		registeredUsersMutex.Lock()
 		delete(registeredUsers, username)
 		registeredUsersMutex.Unlock()
+		removeTempGRUUs(username)
 		println("logged out " + username)
 	} else {
-		registerUser(session)
+		registerUser(session, path, flows)
 		println("registered " + username)
 	}
 
@@ -145,11 +189,36 @@ func checkAuthorization(r *sipnet.Request, conn *sipnet.Conn,
 
 	user.Arguments.Set("tag", generateNonce(5))
 	resp.Header.Set("To", user.String())
+	if r.Header.Get("Expires") != "0" {
+		for _, contact := range contacts {
+			resp.Header.Add("Contact", contact.String())
+		}
+	}
+	if supportsPath(r) {
+		for _, value := range r.Header.Values("Path") {
+			resp.Header.Add("Path", value)
+		}
+	}
+	if len(flows) > 0 {
+		// The registration is bound to the flows it was received over.
+		resp.Header.AddOptionTag("Require", sipnet.OptionOutbound)
+	}
 	resp.WriteTo(conn, r)
 
 	return
 }
 
+// supportsPath returns whether the UA registering indicated support for
+// Path, in which case the Path is returned in the 200 OK.
+func supportsPath(r *sipnet.Request) bool {
+	for _, tag := range r.Header.OptionTags("Supported") {
+		if strings.ToLower(tag) == "path" {
+			return true
+		}
+	}
+	return false
+}
+
 // HandleRegister handles REGISTER SIP requests.
 func HandleRegister(r *sipnet.Request, conn *sipnet.Conn) {
 	from, to, err := sipnet.ParseUserHeader(r.Header)
@@ -167,7 +236,7 @@ func HandleRegister(r *sipnet.Request, conn *sipnet.Conn) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		requestAuthentication(r, conn, from)
+		requestAuthentication(r, conn, from, false)
 		return
 	}
 
@@ -190,6 +259,7 @@ func registrationJanitor() {
 			}
 		}
 		authSessionMutex.Unlock()
+		removeExpiredTempGRUUs()
 		time.Sleep(time.Second * 10)
 	}
 }
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+// authTest registers a test account with HandleRegister over a pipe, with
+// nonces issued by a store with a fake clock.
+type authTest struct {
+	t      *testing.T
+	conn   *sipnet.Conn
+	remote net.Conn
+	clock  *sipnet.FakeClock
+	branch int
+}
+
+func newAuthTest(t *testing.T) *authTest {
+	conn, remote := sipnet.NewPipeConn("tcp")
+	clock := sipnet.NewFakeClock(time.Unix(1000, 0))
+	store := sipnet.NewMemoryNonceStore(time.Minute)
+	store.Clock = clock
+	nonces = store
+	accounts["noncetest"] = account{"secret"}
+
+	return &authTest{t: t, conn: conn, remote: remote, clock: clock}
+}
+
+func (a *authTest) Close() {
+	nonces = sipnet.NewMemoryNonceStore(time.Minute)
+	delete(accounts, "noncetest")
+	registeredUsersMutex.Lock()
+	delete(registeredUsers, "noncetest")
+	registeredUsersMutex.Unlock()
+	a.conn.Close()
+	a.remote.Close()
+}
+
+// register sends a REGISTER with the given Authorization, if any, and
+// returns the response.
+func (a *authTest) register(authorization string) *sipnet.Response {
+	a.t.Helper()
+
+	a.branch++
+	extra := []string{"Contact: <sip:noncetest@127.0.0.1:5070>"}
+	if authorization != "" {
+		extra = append(extra, "Authorization: "+authorization)
+	}
+	msg := "REGISTER sip:127.0.0.1 SIP/2.0\r\n" +
+		"Via: SIP/2.0/TCP 127.0.0.1:5070;branch=z9hG4bKauth" +
+		strconv.Itoa(a.branch) + "\r\n" +
+		"From: <sip:noncetest@127.0.0.1>;tag=a1\r\n" +
+		"To: <sip:noncetest@127.0.0.1>\r\n" +
+		"Call-ID: nonce@127.0.0.1\r\n" +
+		"CSeq: " + strconv.Itoa(a.branch) + " REGISTER\r\n" +
+		"Max-Forwards: 70\r\n"
+	for _, line := range extra {
+		msg += line + "\r\n"
+	}
+	req := parseRequest(a.t, msg+"Content-Length: 0\r\n\r\n")
+
+	go HandleRegister(req, a.conn)
+	return readPipeResponse(a.t, a.remote)
+}
+
+// challenge returns the arguments of the digest challenge of a 401.
+func (a *authTest) challenge(resp *sipnet.Response) sipnet.HeaderArgs {
+	a.t.Helper()
+
+	if resp.StatusCode != sipnet.StatusUnauthorized {
+		a.t.Fatalf("got %d, expected a 401 challenge", resp.StatusCode)
+	}
+	args, err := parseAuthHeader(resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		a.t.Fatalf("failed to parse the challenge: %v", err)
+	}
+	return args
+}
+
+// authorization returns the Authorization answering a challenge with the
+// nonce count nc.
+func authorization(nonce, nc string) string {
+	uri := "sip:127.0.0.1"
+	ha1 := md5Hex("noncetest:" + hostname + ":secret")
+	ha2 := md5Hex(sipnet.MethodRegister + ":" + uri)
+	response := md5Hex(ha1 + ":" + nonce + ":" + nc + ":c1:auth:" + ha2)
+
+	args := make(sipnet.HeaderArgs)
+	args.Set("username", "noncetest")
+	args.Set("realm", hostname)
+	args.Set("nonce", nonce)
+	args.Set("uri", uri)
+	args.Set("qop", "auth")
+	args.Set("nc", nc)
+	args.Set("cnonce", "c1")
+	args.Set("response", response)
+	return "Digest " + args.CommaString()
+}
+
+func TestRegisterRejectsReplayedNonceCount(t *testing.T) {
+	a := newAuthTest(t)
+	defer a.Close()
+
+	nonce := a.challenge(a.register("")).Get("nonce")
+	if resp := a.register(authorization(nonce, "00000001")); resp.StatusCode != sipnet.StatusOK {
+		t.Fatalf("authorized REGISTER got %d, expected 200", resp.StatusCode)
+	}
+
+	// The same request replayed is challenged again, without stale.
+	challenge := a.challenge(a.register(authorization(nonce, "00000001")))
+	if stale := challenge.Get("stale"); stale != "FALSE" {
+		t.Errorf("replay challenged with stale=%s, expected FALSE", stale)
+	}
+}
+
+func TestRegisterRejectsStaleNonce(t *testing.T) {
+	a := newAuthTest(t)
+	defer a.Close()
+
+	nonce := a.challenge(a.register("")).Get("nonce")
+	a.clock.Advance(time.Minute + time.Second)
+
+	challenge := a.challenge(a.register(authorization(nonce, "00000001")))
+	if stale := challenge.Get("stale"); stale != "TRUE" {
+		t.Errorf("stale nonce challenged with stale=%s, expected TRUE", stale)
+	}
+	if challenge.Get("nonce") == nonce {
+		t.Error("challenged again with the stale nonce")
+	}
+}
@@ -0,0 +1,445 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+// byeTimeout is how long a B2BUA waits for the response to a BYE it sent
+// before tearing down the call.
+const byeTimeout = 32 * time.Second
+
+// B2BUA is a back to back user agent. It answers an inbound INVITE as a UAS,
+// and bridges it to a new outbound dialog to Target as a UAC. The two legs
+// have independent Call-IDs, tags and CSeqs.
+type B2BUA struct {
+	// Target is the URI outbound calls are sent to.
+	Target sipnet.URI
+
+	// Dial opens the connection of the outbound leg. If nil,
+	// sipnet.DialURIConn is used.
+	Dial func(target sipnet.URI) (*sipnet.Conn, error)
+}
+
+// Call is a call bridged by a B2BUA.
+type Call struct {
+	Inbound  *sipnet.Dialog
+	Outbound *sipnet.Dialog
+
+	inbound  *leg
+	outbound *leg
+}
+
+// leg is one side of a bridged call.
+type leg struct {
+	dialog *sipnet.Dialog
+
+	// relayed is the request received on this leg which was relayed to the
+	// other leg, and is waiting for its response.
+	relayed *sipnet.Request
+}
+
+// ringingLeg is the state of an outbound INVITE which hasn't received its
+// final response yet, shared with the goroutine reading CANCELs of the
+// inbound INVITE.
+type ringingLeg struct {
+	invite  *sipnet.Request
+	outConn *sipnet.Conn
+
+	mutex      sync.Mutex
+	proceeding bool
+	cancelled  bool
+	cancelSent bool
+}
+
+// relayProgress relays a provisional response of the outbound leg to the
+// inbound leg, unless the inbound INVITE has been cancelled and answered
+// with a 487 already. Once the outbound INVITE has a provisional response,
+// a pending CANCEL is sent for it.
+func (l *ringingLeg) relayProgress(progress *sipnet.Response,
+	relay func(*sipnet.Response)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.proceeding = true
+	l.sendCancel()
+	if l.cancelled || progress.StatusCode == sipnet.StatusTrying {
+		return
+	}
+	relay(progress)
+}
+
+// cancel answers the inbound INVITE with a 487 with terminate, and cancels
+// the outbound INVITE, as soon as it has a provisional response (RFC 3261
+// section 9.1). It does nothing if the call has been cancelled already.
+func (l *ringingLeg) cancel(terminate func()) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.cancelled {
+		return
+	}
+
+	l.cancelled = true
+	terminate()
+	l.sendCancel()
+}
+
+// sendCancel sends the CANCEL of the outbound INVITE if the call has been
+// cancelled and the INVITE has a provisional response. l.mutex must be held.
+func (l *ringingLeg) sendCancel() {
+	if l.cancelled && l.proceeding && !l.cancelSent {
+		l.cancelSent = true
+		sipnet.NewCancel(l.invite).WriteTo(l.outConn)
+	}
+}
+
+func (l *ringingLeg) wasCancelled() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.cancelled
+}
+
+// watchCancel reads the inbound connection while the outbound leg is
+// ringing, until stop is closed. A CANCEL of the inbound INVITE is answered
+// with a 200 and cancels the call, as does the inbound connection closing.
+// Other messages are discarded.
+func (l *ringingLeg) watchCancel(r *sipnet.Request, conn *sipnet.Conn,
+	terminate func(), stop <-chan struct{}) {
+	key := sipnet.TransactionKey(r)
+	for {
+		var msg interface{}
+		var more bool
+		select {
+		case msg, more = <-conn.ReadMessage:
+		case <-stop:
+			return
+		}
+
+		if !more {
+			l.cancel(func() {})
+			return
+		}
+
+		cancel, ok := msg.(*sipnet.Request)
+		if !ok || cancel.Method != sipnet.MethodCancel {
+			continue
+		}
+
+		resp := sipnet.NewResponse()
+		resp.Header.Set("From", cancel.Header.Get("From"))
+		resp.Header.Set("To", cancel.Header.Get("To"))
+		if sipnet.CancelKey(cancel) != key {
+			resp.StatusCode = sipnet.StatusCallTransactionDoesNotExist
+			resp.WriteTo(conn, cancel)
+			continue
+		}
+
+		resp.StatusCode = sipnet.StatusOK
+		resp.WriteTo(conn, cancel)
+		l.cancel(terminate)
+	}
+}
+
+// failureStatus returns the status the inbound INVITE is answered with if
+// the outbound INVITE failed with err. Only a transaction timeout is a 408,
+// as the target is unreachable if its connection failed, and misbehaving if
+// it sent a response which couldn't be parsed.
+func failureStatus(err error) int {
+	if err == sipnet.ErrTimeout {
+		return sipnet.StatusRequestTimeout
+	}
+
+	if _, ok := err.(net.Error); ok || err == io.EOF {
+		return sipnet.StatusServiceUnavailable
+	}
+
+	return sipnet.StatusBadGateway
+}
+
+// HandleInvite answers an inbound INVITE received over conn by sending an
+// INVITE to the target and relaying its responses. If the call is
+// established, the established call is returned and Bridge should be called
+// to relay requests between the legs. The connection must be locked.
+//
+// While the target is ringing, a CANCEL of the inbound INVITE is answered,
+// the inbound INVITE is answered with a 487 Request Terminated, and the
+// outbound INVITE is cancelled. If the target answers the call regardless,
+// it is hung up with a BYE.
+func (b *B2BUA) HandleInvite(r *sipnet.Request, conn *sipnet.Conn) (*Call, error) {
+	trying(r, conn)
+
+	from, to, err := sipnet.ParseUserHeader(r.Header)
+	if err != nil {
+		resp := sipnet.NewResponse()
+		resp.BadRequest(conn, r, "Failed to parse From or To header.")
+		return nil, err
+	}
+
+	dial := b.Dial
+	if dial == nil {
+		dial = sipnet.DialURIConn
+	}
+
+	outConn, err := dial(b.Target)
+	if err != nil {
+		resp := sipnet.NewResponse()
+		resp.ServerError(conn, r, "Failed to reach target.")
+		return nil, err
+	}
+
+	invite := b.newInvite(r, from, outConn)
+	conn.PropagateTrace(r.Header, invite.Header)
+	inboundTag := sipnet.NewTag()
+
+	relay := func(resp *sipnet.Response) {
+		relayResponse(resp, conn, r, to, inboundTag)
+	}
+	terminate := func() {
+		resp := sipnet.NewResponse()
+		resp.StatusCode = sipnet.StatusRequestTerminated
+		relay(resp)
+	}
+
+	ringing := &ringingLeg{invite: invite, outConn: outConn}
+	stop := make(chan struct{})
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		ringing.watchCancel(r, conn, terminate, stop)
+	}()
+
+	resp, err := outConn.DoProgress(invite, func(progress *sipnet.Response) {
+		ringing.relayProgress(progress, relay)
+	})
+	close(stop)
+	<-watching
+
+	if ringing.wasCancelled() {
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			// The target answered before the CANCEL reached it.
+			outbound, err := sipnet.NewClientDialog(invite, resp, outConn)
+			if err == nil {
+				outbound.NewRequest(sipnet.MethodAck).WriteTo(outConn)
+				outConn.Do(outbound.NewRequest(sipnet.MethodBye))
+			}
+		}
+		outConn.Close()
+		return nil, nil
+	}
+
+	if err != nil {
+		outConn.Close()
+		resp := sipnet.NewResponse()
+		resp.StatusCode = failureStatus(err)
+		resp.WriteTo(conn, r)
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		outConn.Close()
+		relayResponse(resp, conn, r, to, inboundTag)
+		return nil, nil
+	}
+
+	outbound, err := sipnet.NewClientDialog(invite, resp, outConn)
+	if err != nil {
+		outConn.Close()
+		resp := sipnet.NewResponse()
+		resp.ServerError(conn, r, "Bad response from target.")
+		return nil, err
+	}
+	outbound.NewRequest(sipnet.MethodAck).WriteTo(outConn)
+
+	err = relayResponse(resp, conn, r, to, inboundTag)
+	if err != nil {
+		outConn.Close()
+		return nil, err
+	}
+
+	inbound, err := sipnet.NewServerDialog(r, inboundTag, conn)
+	if err != nil {
+		outConn.Close()
+		return nil, err
+	}
+
+	return &Call{
+		Inbound:  inbound,
+		Outbound: outbound,
+		inbound:  &leg{dialog: inbound},
+		outbound: &leg{dialog: outbound},
+	}, nil
+}
+
+// newInvite returns the INVITE of the outbound leg for an inbound INVITE.
+func (b *B2BUA) newInvite(r *sipnet.Request, from sipnet.User,
+	conn *sipnet.Conn) *sipnet.Request {
+	local := sipnet.User{
+		Name:      from.Name,
+		URI:       from.URI,
+		Arguments: sipnet.HeaderArgs{"tag": sipnet.NewTag()},
+	}
+	remote := sipnet.User{
+		URI:       b.Target,
+		Arguments: make(sipnet.HeaderArgs),
+	}
+
+	invite := sipnet.NewRequest()
+	invite.Method = sipnet.MethodInvite
+	invite.Server = b.Target.String()
+	invite.Header.Set("Via", conn.NewVia().String())
+	invite.Header.Set("From", local.String())
+	invite.Header.Set("To", remote.String())
+	invite.Header.Set("Call-ID", sipnet.NewCallID(conn.SentBy()))
+	invite.Header.Set("CSeq", sipnet.CSeq{Sequence: 1,
+		Method: sipnet.MethodInvite}.String())
+	invite.Header.Set("Contact", conn.Contact(from.URI.Username).String())
+	invite.SetMaxForwards(sipnet.DefaultMaxForwards)
+	copyBody(invite.Header, r.Header)
+	invite.Body = r.Body
+
+	return invite
+}
+
+// relayResponse sends the status and body of resp, received on one leg, as
+// the response to r on the other leg, with the To tag of that leg.
+func relayResponse(resp *sipnet.Response, conn *sipnet.Conn,
+	r *sipnet.Request, to sipnet.User, tag string) error {
+	to.Arguments = sipnet.HeaderArgs{"tag": tag}
+
+	relayed := sipnet.NewResponse()
+	relayed.StatusCode = resp.StatusCode
+	relayed.Header.Set("From", r.Header.Get("From"))
+	relayed.Header.Set("To", to.String())
+	relayed.Header.Set("Contact", conn.Contact(to.URI.Username).String())
+	copyBody(relayed.Header, resp.Header)
+	relayed.Body = resp.Body
+	conn.PropagateTrace(resp.Header, relayed.Header)
+
+	return relayed.WriteTo(conn, r)
+}
+
+// copyBody copies the headers describing the body of a message.
+func copyBody(dst, src sipnet.Header) {
+	for _, key := range []string{"Content-Type", "Content-Disposition"} {
+		if value := src.Get(key); value != "" {
+			dst.Set(key, value)
+		}
+	}
+}
+
+// Bridge relays requests and responses within the call between the two
+// legs until either leg hangs up with a BYE, or a connection is closed.
+// ACKs for 2xx responses are generated by the B2BUA on each leg. The
+// outbound connection is closed when the call ends.
+func (c *Call) Bridge() {
+	defer c.Outbound.Conn.Close()
+
+	var byeTimer <-chan time.Time
+	for {
+		var msg interface{}
+		var from, to *leg
+		var more bool
+
+		select {
+		case msg, more = <-c.Inbound.Conn.ReadMessage:
+			from, to = c.inbound, c.outbound
+		case msg, more = <-c.Outbound.Conn.ReadMessage:
+			from, to = c.outbound, c.inbound
+		case <-byeTimer:
+			return
+		}
+
+		if !more {
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *sipnet.Request:
+			if !from.dialog.Matches(msg) {
+				continue
+			}
+
+			if c.relayRequest(msg, from, to) {
+				byeTimer = time.After(byeTimeout)
+			}
+		case *sipnet.Response:
+			cseq, err := sipnet.ParseCSeq(msg.Header.Get("CSeq"))
+			if err != nil {
+				continue
+			}
+
+			if cseq.Method == sipnet.MethodBye {
+				return
+			}
+
+			c.relayBack(msg, cseq, from, to)
+		case error:
+			fmt.Println("warning: b2bua: failed to read message:", msg)
+			return
+		}
+	}
+}
+
+// relayRequest relays a request received on from to the other leg, and
+// returns whether it was a BYE.
+func (c *Call) relayRequest(req *sipnet.Request, from, to *leg) bool {
+	if from.dialog.Receive(req) == sipnet.ErrCSeqOutOfOrder {
+		resp := sipnet.NewResponse()
+		resp.ServerError(from.dialog.Conn, req, "CSeq out of order.")
+		return false
+	}
+
+	switch req.Method {
+	case sipnet.MethodAck:
+		// The ACK on the other leg is sent when the 2xx is relayed.
+		return false
+	case sipnet.MethodBye:
+		resp := sipnet.NewResponse()
+		resp.StatusCode = sipnet.StatusOK
+		resp.Header.Set("From", req.Header.Get("From"))
+		resp.Header.Set("To", req.Header.Get("To"))
+		resp.WriteTo(from.dialog.Conn, req)
+
+		to.dialog.NewRequest(sipnet.MethodBye).WriteTo(to.dialog.Conn)
+		return true
+	}
+
+	relayed := to.dialog.NewRequest(req.Method)
+	copyBody(relayed.Header, req.Header)
+	relayed.Body = req.Body
+	from.dialog.Conn.PropagateTrace(req.Header, relayed.Header)
+	if req.Method == sipnet.MethodInvite {
+		relayed.Header.Set("Contact",
+			to.dialog.Conn.Contact(to.dialog.LocalUser.URI.Username).String())
+	}
+
+	from.relayed = req
+	relayed.WriteTo(to.dialog.Conn)
+	return false
+}
+
+// relayBack relays a response received on from to the request it answers,
+// which was received on the other leg.
+func (c *Call) relayBack(resp *sipnet.Response, cseq sipnet.CSeq, from, to *leg) {
+	req := to.relayed
+	if req == nil || !strings.EqualFold(req.Method, cseq.Method) {
+		return
+	}
+
+	if resp.StatusCode >= 200 {
+		to.relayed = nil
+	}
+
+	if cseq.Method == sipnet.MethodInvite && resp.StatusCode >= 200 &&
+		resp.StatusCode < 300 {
+		from.dialog.NewRequest(sipnet.MethodAck).WriteTo(from.dialog.Conn)
+	}
+
+	local := to.dialog.LocalUser
+	relayResponse(resp, to.dialog.Conn, req, local, to.dialog.LocalTag)
+}
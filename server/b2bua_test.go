@@ -0,0 +1,194 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+// b2buaTest is a B2BUA bridging a caller to a target over pipes.
+type b2buaTest struct {
+	b2bua *B2BUA
+
+	inConn  *sipnet.Conn
+	caller  net.Conn
+	fromB2B <-chan string
+
+	outConn *sipnet.Conn
+	target  net.Conn
+	toB2B   <-chan string
+}
+
+func newB2BUATest() *b2buaTest {
+	test := new(b2buaTest)
+	test.inConn, test.caller = sipnet.NewPipeConn("tcp")
+	test.outConn, test.target = sipnet.NewPipeConn("tcp")
+	test.fromB2B = collect(test.caller)
+	test.toB2B = collect(test.target)
+
+	target, _ := sipnet.ParseURI("sip:bob@127.0.0.1:5090")
+	test.b2bua = &B2BUA{
+		Target: target,
+		Dial: func(sipnet.URI) (*sipnet.Conn, error) {
+			return test.outConn, nil
+		},
+	}
+	return test
+}
+
+func (test *b2buaTest) close() {
+	test.inConn.Close()
+	test.outConn.Close()
+}
+
+// callResult is the result of B2BUA.HandleInvite.
+type callResult struct {
+	call *Call
+	err  error
+}
+
+// invite sends an INVITE from the caller, returning the INVITE as received
+// by the B2BUA, and the INVITE sent to the target.
+func (test *b2buaTest) invite(t *testing.T) (<-chan callResult, *sipnet.Request,
+	*sipnet.Request) {
+	t.Helper()
+
+	msg := testRequest(sipnet.MethodInvite, "z9hG4bKcaller",
+		"Contact: <sip:alice@127.0.0.1:5070;transport=tcp>")
+	test.caller.Write([]byte(msg))
+	r := test.inConn.Read().(*sipnet.Request)
+
+	results := make(chan callResult, 1)
+	go func() {
+		call, err := test.b2bua.HandleInvite(r, test.inConn)
+		results <- callResult{call, err}
+	}()
+
+	if resp := nextResponse(t, test.fromB2B); resp.StatusCode != sipnet.StatusTrying {
+		t.Fatalf("caller received %d, expected a 100", resp.StatusCode)
+	}
+	return results, r, nextRequest(t, test.toB2B)
+}
+
+func TestB2BUACallSetupAndTeardown(t *testing.T) {
+	test := newB2BUATest()
+	defer test.close()
+
+	results, _, invite := test.invite(t)
+	if invite.Header.Get("Call-ID") == "call1@127.0.0.1" {
+		t.Error("outbound leg has the Call-ID of the inbound leg")
+	}
+
+	test.target.Write([]byte(testResponse(invite, "180 Ringing")))
+	if resp := nextResponse(t, test.fromB2B); resp.StatusCode != sipnet.StatusRinging {
+		t.Fatalf("caller received %d, expected the 180", resp.StatusCode)
+	}
+
+	test.target.Write([]byte(testResponse(invite, "200 OK",
+		"Contact: <sip:bob@127.0.0.1:5090;transport=tcp>")))
+	if ack := nextRequest(t, test.toB2B); ack.Method != sipnet.MethodAck {
+		t.Fatalf("target received %s, expected an ACK", ack.Method)
+	}
+	answer := nextResponse(t, test.fromB2B)
+	if answer.StatusCode != sipnet.StatusOK {
+		t.Fatalf("caller received %d, expected the 200", answer.StatusCode)
+	}
+
+	result := <-results
+	if result.err != nil || result.call == nil {
+		t.Fatalf("call not established: %v", result.err)
+	}
+	bridged := make(chan struct{})
+	go func() {
+		result.call.Bridge()
+		close(bridged)
+	}()
+
+	// The caller hangs up, which is relayed to the target.
+	test.caller.Write([]byte("BYE sip:alice@127.0.0.1:5070 SIP/2.0\r\n" +
+		"Via: SIP/2.0/TCP 127.0.0.1:5070;branch=z9hG4bKbye\r\n" +
+		"From: <sip:alice@127.0.0.1>;tag=a1\r\n" +
+		"To: " + answer.Header.Get("To") + "\r\n" +
+		"Call-ID: call1@127.0.0.1\r\n" +
+		"CSeq: 2 BYE\r\n" +
+		"Max-Forwards: 70\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	if resp := nextResponse(t, test.fromB2B); resp.StatusCode != sipnet.StatusOK {
+		t.Errorf("caller's BYE answered with %d, expected a 200", resp.StatusCode)
+	}
+
+	bye := nextRequest(t, test.toB2B)
+	if bye.Method != sipnet.MethodBye ||
+		bye.Header.Get("Call-ID") != invite.Header.Get("Call-ID") {
+		t.Fatalf("target received %s, expected a BYE of the outbound leg",
+			bye.Method)
+	}
+	test.target.Write([]byte(testResponse(bye, "200 OK")))
+
+	select {
+	case <-bridged:
+	case <-time.After(testTimeout):
+		t.Fatal("Bridge didn't return once the call was torn down")
+	}
+}
+
+func TestB2BUACancel(t *testing.T) {
+	test := newB2BUATest()
+	defer test.close()
+
+	results, _, invite := test.invite(t)
+	test.target.Write([]byte(testResponse(invite, "180 Ringing")))
+	ringing := nextResponse(t, test.fromB2B)
+
+	test.caller.Write([]byte(testRequest(sipnet.MethodCancel, "z9hG4bKcaller")))
+
+	// The CANCEL and the INVITE are answered in turn.
+	cancelled := nextResponse(t, test.fromB2B)
+	if cancelled.StatusCode != sipnet.StatusOK ||
+		cancelled.Header.Get("CSeq") != "1 CANCEL" {
+		t.Errorf("caller received %d for %s, expected a 200 for the CANCEL",
+			cancelled.StatusCode, cancelled.Header.Get("CSeq"))
+	}
+	terminated := nextResponse(t, test.fromB2B)
+	if terminated.StatusCode != sipnet.StatusRequestTerminated ||
+		terminated.Header.Get("To") != ringing.Header.Get("To") {
+		t.Errorf("caller received %d with To %q, expected a 487 with To %q",
+			terminated.StatusCode, terminated.Header.Get("To"),
+			ringing.Header.Get("To"))
+	}
+
+	cancel := nextRequest(t, test.toB2B)
+	if cancel.Method != sipnet.MethodCancel ||
+		cancel.Header.Get("Via") != invite.Header.Get("Via") {
+		t.Fatalf("target received %s, expected a CANCEL of the INVITE",
+			cancel.Method)
+	}
+	test.target.Write([]byte(testResponse(cancel, "200 OK")))
+	test.target.Write([]byte(testResponse(invite, "487 Request Terminated")))
+	if ack := nextRequest(t, test.toB2B); ack.Method != sipnet.MethodAck {
+		t.Errorf("target received %s, expected an ACK for the 487", ack.Method)
+	}
+
+	if result := <-results; result.call != nil || result.err != nil {
+		t.Errorf("HandleInvite returned %v, %v, expected no call", result.call,
+			result.err)
+	}
+}
+
+func TestB2BUATargetClosed(t *testing.T) {
+	test := newB2BUATest()
+	defer test.close()
+
+	results, _, _ := test.invite(t)
+	test.target.Close()
+
+	resp := nextResponse(t, test.fromB2B)
+	if resp.StatusCode != sipnet.StatusServiceUnavailable {
+		t.Errorf("caller received %d, expected a 503", resp.StatusCode)
+	}
+	if result := <-results; result.err == nil {
+		t.Error("HandleInvite returned no error")
+	}
+}
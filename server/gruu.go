@@ -0,0 +1,135 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+// gruuExpiry bounds how long the temporary GRUUs assigned by a REGISTER
+// remain valid, which is the duration of the registration.
+var gruuExpiry = sipnet.ExpiryLimits{
+	Max:     24 * time.Hour,
+	Default: time.Hour,
+}
+
+type tempGRUU struct {
+	username string
+	expires  time.Time
+}
+
+// a map[temp-gruu username]tempGRUU pair
+var tempGRUUs = make(map[string]tempGRUU)
+var tempGRUUsMutex = new(sync.Mutex)
+
+// supportsGRUU returns whether the UA registering indicated support for
+// GRUU (RFC 5627).
+func supportsGRUU(r *sipnet.Request) bool {
+	for _, tag := range r.Header.OptionTags("Supported") {
+		if strings.ToLower(tag) == "gruu" {
+			return true
+		}
+	}
+	return false
+}
+
+// registeredContacts returns the Contacts of a REGISTER to be returned in
+// its 200 response. If the UA supports GRUU, a public and temporary GRUU
+// are assigned to each Contact with an instance ID. Temporary GRUUs expire
+// with the registration.
+func registeredContacts(r *sipnet.Request, username string) ([]sipnet.User, error) {
+	contacts, err := sipnet.ParseUsers(r.Header, "Contact")
+	if err != nil {
+		return nil, err
+	}
+
+	if !supportsGRUU(r) {
+		return contacts, nil
+	}
+
+	duration, err := gruuExpiry.Grant(r)
+	if err != nil {
+		duration = gruuExpiry.Default
+	}
+	expires := time.Now().Add(duration)
+
+	for i, contact := range contacts {
+		instance := contact.InstanceID()
+		if instance == "" {
+			continue
+		}
+
+		gr := make(sipnet.HeaderArgs)
+		gr.Set("gr", instance)
+		pub := sipnet.URI{
+			Scheme:    "sip",
+			Username:  username,
+			Domain:    hostname,
+			Arguments: gr,
+		}
+
+		tempUsername := "tgruu." + generateNonce(8)
+		temp := sipnet.URI{
+			Scheme:    "sip",
+			Username:  tempUsername,
+			Domain:    hostname,
+			Arguments: sipnet.HeaderArgs{"gr": ""},
+		}
+
+		tempGRUUsMutex.Lock()
+		tempGRUUs[tempUsername] = tempGRUU{
+			username: username,
+			expires:  expires,
+		}
+		tempGRUUsMutex.Unlock()
+
+		contacts[i].Arguments.Set("pub-gruu", pub.String())
+		contacts[i].Arguments.Set("temp-gruu", temp.String())
+	}
+
+	return contacts, nil
+}
+
+// resolveGRUU returns the username a request URI is addressed to, resolving
+// temporary GRUUs to the user they were assigned to until they expire.
+func resolveGRUU(uri sipnet.URI) string {
+	if !uri.IsGRUU() {
+		return uri.Username
+	}
+
+	tempGRUUsMutex.Lock()
+	defer tempGRUUsMutex.Unlock()
+	if temp, found := tempGRUUs[uri.Username]; found &&
+		time.Now().Before(temp.expires) {
+		return temp.username
+	}
+
+	return uri.Username
+}
+
+// removeTempGRUUs removes the temporary GRUUs assigned to a user, such as
+// once the user unregisters.
+func removeTempGRUUs(username string) {
+	tempGRUUsMutex.Lock()
+	defer tempGRUUsMutex.Unlock()
+	for tempUsername, temp := range tempGRUUs {
+		if temp.username == username {
+			delete(tempGRUUs, tempUsername)
+		}
+	}
+}
+
+// removeExpiredTempGRUUs removes the temporary GRUUs of registrations which
+// have expired.
+func removeExpiredTempGRUUs() {
+	tempGRUUsMutex.Lock()
+	defer tempGRUUsMutex.Unlock()
+	now := time.Now()
+	for tempUsername, temp := range tempGRUUs {
+		if !now.Before(temp.expires) {
+			delete(tempGRUUs, tempUsername)
+		}
+	}
+}
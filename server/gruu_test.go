@@ -0,0 +1,61 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+const testInstanceID = "urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6"
+
+func TestGRUUAssignment(t *testing.T) {
+	register := parseRequest(t, testRequest(sipnet.MethodRegister, "z9hG4bK1",
+		"Supported: gruu",
+		"Contact: <sip:alice@192.0.2.4>;+sip.instance=\"<"+testInstanceID+">\"",
+		"Contact: <sip:alice@192.0.2.5>"))
+	contacts, err := registeredContacts(register, "alice")
+	if err != nil {
+		t.Fatalf("failed to assign GRUUs: %v", err)
+	}
+	if contacts[1].Arguments.Get("pub-gruu") != "" {
+		t.Error("GRUU assigned to a contact without an instance ID")
+	}
+
+	// The UA extracts the GRUUs from the 200 response.
+	resp := sipnet.NewResponse()
+	for _, contact := range contacts {
+		resp.Header.Add("Contact", contact.String())
+	}
+	pub, temp, err := sipnet.ParseGRUU(resp, testInstanceID)
+	if err != nil {
+		t.Fatalf("failed to parse assigned GRUU: %v", err)
+	}
+	if pub.Username != "alice" || pub.Domain != hostname ||
+		pub.Arguments.Get("gr") != testInstanceID {
+		t.Errorf("public GRUU is %q", pub)
+	}
+	if !temp.IsGRUU() || !strings.HasPrefix(temp.Username, "tgruu.") {
+		t.Errorf("temporary GRUU is %q", temp)
+	}
+
+	// Requests to either GRUU are routed to the user.
+	if user := resolveGRUU(pub); user != "alice" {
+		t.Errorf("public GRUU resolved to %q, expected alice", user)
+	}
+	if user := resolveGRUU(temp); user != "alice" {
+		t.Errorf("temporary GRUU resolved to %q, expected alice", user)
+	}
+}
+
+func TestGRUUNotSupported(t *testing.T) {
+	register := parseRequest(t, testRequest(sipnet.MethodRegister, "z9hG4bK1",
+		"Contact: <sip:alice@192.0.2.4>;+sip.instance=\"<"+testInstanceID+">\""))
+	contacts, err := registeredContacts(register, "alice")
+	if err != nil {
+		t.Fatalf("failed to parse contacts: %v", err)
+	}
+	if contacts[0].Arguments.Get("pub-gruu") != "" {
+		t.Error("GRUU assigned to a UA which doesn't support it")
+	}
+}
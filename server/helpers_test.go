@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+// testTimeout is how long tests wait for a message which is expected to
+// arrive.
+const testTimeout = 2 * time.Second
+
+// testRequest returns a serialized request with the given method from
+// alice to bob, sent by a UA at 127.0.0.1:5070. Extra header lines are
+// added before the Content-Length.
+func testRequest(method, branch string, extra ...string) string {
+	msg := method + " sip:bob@127.0.0.1 SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 127.0.0.1:5070;branch=" + branch + "\r\n" +
+		"From: <sip:alice@127.0.0.1>;tag=a1\r\n" +
+		"To: <sip:bob@127.0.0.1>\r\n" +
+		"Call-ID: call1@127.0.0.1\r\n" +
+		"CSeq: 1 " + method + "\r\n" +
+		"Max-Forwards: 70\r\n"
+	for _, line := range extra {
+		msg += line + "\r\n"
+	}
+	return msg + "Content-Length: 0\r\n\r\n"
+}
+
+// parseRequest parses a serialized request.
+func parseRequest(t *testing.T, msg string) *sipnet.Request {
+	t.Helper()
+
+	req, err := sipnet.ReadRequest(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("failed to parse request: %v", err)
+	}
+	return req
+}
+
+// readPipe reads the data of the next write of a Conn from the remote end of
+// a pipe from sipnet.NewPipeConn.
+func readPipe(t *testing.T, remote net.Conn) string {
+	t.Helper()
+
+	buf := make([]byte, 65535)
+	remote.SetReadDeadline(time.Now().Add(testTimeout))
+	n, err := remote.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read from pipe: %v", err)
+	}
+	return string(buf[:n])
+}
+
+// readPipeResponse reads and parses the next response written by a Conn to
+// the remote end of a pipe from sipnet.NewPipeConn.
+func readPipeResponse(t *testing.T, remote net.Conn) *sipnet.Response {
+	t.Helper()
+
+	resp, err := sipnet.ReadResponse(strings.NewReader(readPipe(t, remote)))
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return resp
+}
+
+// testResponse returns a serialized response to a request, with the given
+// status line (i.e. "200 OK") and a To tag if the To of the request has
+// none. Extra header lines are added before the Content-Length.
+func testResponse(req *sipnet.Request, status string, extra ...string) string {
+	msg := sipnet.SIPVersion + " " + status + "\r\n"
+	for _, via := range req.Header.Values("Via") {
+		msg += "Via: " + via + "\r\n"
+	}
+
+	to := req.Header.Get("To")
+	if !strings.Contains(to, "tag=") {
+		to += ";tag=b1"
+	}
+	msg += "From: " + req.Header.Get("From") + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Call-ID: " + req.Header.Get("Call-ID") + "\r\n" +
+		"CSeq: " + req.Header.Get("CSeq") + "\r\n"
+	for _, line := range extra {
+		msg += line + "\r\n"
+	}
+	return msg + "Content-Length: 0\r\n\r\n"
+}
+
+// collect reads the messages written by a Conn to the remote end of a pipe
+// from sipnet.NewPipeConn from a goroutine of its own, so the Conn never
+// blocks writing to the pipe, until the pipe is closed.
+func collect(remote net.Conn) <-chan string {
+	messages := make(chan string, 32)
+	go func() {
+		defer close(messages)
+		buf := make([]byte, 65535)
+		for {
+			n, err := remote.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return messages
+}
+
+// next returns the next message collected by collect.
+func next(t *testing.T, messages <-chan string) string {
+	t.Helper()
+
+	select {
+	case msg, more := <-messages:
+		if !more {
+			t.Fatal("pipe closed while waiting for a message")
+		}
+		return msg
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a message")
+	}
+	return ""
+}
+
+// nextResponse returns the next message collected by collect, which must be
+// a response.
+func nextResponse(t *testing.T, messages <-chan string) *sipnet.Response {
+	t.Helper()
+
+	resp, err := sipnet.ReadResponse(strings.NewReader(next(t, messages)))
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return resp
+}
+
+// nextRequest returns the next message collected by collect, which must be
+// a request.
+func nextRequest(t *testing.T, messages <-chan string) *sipnet.Request {
+	t.Helper()
+
+	return parseRequest(t, next(t, messages))
+}
@@ -27,7 +27,7 @@ func HandleInvite(r *sipnet.Request, conn *sipnet.Conn) {
 	user, found := registeredUsers[username]
 	registeredUsersMutex.Unlock()
 
-	if !found || user.conn != conn {
+	if !found || !user.registeredOver(conn) {
 		resp := sipnet.NewResponse()
 		resp.StatusCode = sipnet.StatusForbidden
 		resp.Header.Set("Reason-Phrase", "Not registered.")
@@ -35,7 +35,7 @@ func HandleInvite(r *sipnet.Request, conn *sipnet.Conn) {
 		return
 	}
 
-	recipient := to.URI.Username
+	recipient := resolveGRUU(to.URI)
 	recipientUser, found := registeredUsers[recipient]
 	if !found {
 		resp := sipnet.NewResponse()
@@ -44,14 +44,23 @@ func HandleInvite(r *sipnet.Request, conn *sipnet.Conn) {
 		return
 	}
 
-	recipientUser.conn.Lock()
-	defer recipientUser.conn.Unlock()
+	if !checkProxyRequire(r, conn) || !r.DecrementMaxForwards(conn) {
+		return
+	}
+
+	if len(recipientUser.path) > 0 {
+		r.SetRouteSet(recipientUser.path)
+	}
+
+	recipientConn := recipientUser.activeConn()
+	recipientConn.Lock()
+	defer recipientConn.Unlock()
 	conn.Lock()
 	defer conn.Unlock()
 
 	fmt.Println("calling " + recipientUser.username)
 
-	initiateCall(r, conn, recipientUser.conn)
+	initiateCall(r, conn, recipientConn)
 }
 
 func initiateCall(initialRequest *sipnet.Request,
@@ -79,7 +88,9 @@ func initiateCall(initialRequest *sipnet.Request,
 				fmt.Println("from --> to request, forwarding")
 				fmt.Println(req)
 				lastRequest = req
-				req.WriteTo(to)
+				if req.DecrementMaxForwards(from) {
+					req.WriteTo(to)
+				}
 			case *sipnet.Response:
 				resp := read.(*sipnet.Response)
 				fmt.Println("from --> to response, forwarding")
@@ -121,7 +132,9 @@ func initiateCall(initialRequest *sipnet.Request,
 				fmt.Println(req)
 				lastRequest = req
 
-				req.WriteTo(from)
+				if req.DecrementMaxForwards(to) {
+					req.WriteTo(from)
+				}
 			case *sipnet.Response:
 				resp := read.(*sipnet.Response)
 				if resp.StatusCode == sipnet.StatusTrying {
@@ -0,0 +1,22 @@
+package server
+
+import "github.com/1lann/go-sip/sipnet"
+
+// proxyExtensions are the option tags supported by the server when acting
+// as a proxy, keyed by lower case option tag.
+var proxyExtensions = map[string]bool{}
+
+// checkProxyRequire responds with a 420 Bad Extension if the request has
+// a Proxy-Require option tag the proxy doesn't support, and returns whether
+// the request may be forwarded. Require is end-to-end, so it is left for
+// the UAS to check.
+func checkProxyRequire(r *sipnet.Request, conn *sipnet.Conn) bool {
+	unsupported := r.Header.UnsupportedTags("Proxy-Require", proxyExtensions)
+	if len(unsupported) == 0 {
+		return true
+	}
+
+	resp := sipnet.NewResponse()
+	resp.BadExtension(conn, r, unsupported)
+	return false
+}
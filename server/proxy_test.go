@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+func TestProxyRequireUnsupported(t *testing.T) {
+	conn, remote := sipnet.NewPipeConn("udp")
+	defer conn.Close()
+
+	req := parseRequest(t, testRequest(sipnet.MethodInvite, "z9hG4bKpr",
+		"Proxy-Require: foo"))
+
+	forward := make(chan bool, 1)
+	go func() {
+		forward <- checkProxyRequire(req, conn)
+	}()
+
+	resp := readPipeResponse(t, remote)
+	if resp.StatusCode != sipnet.StatusBadExtension {
+		t.Errorf("responded with %d, expected 420", resp.StatusCode)
+	}
+	if unsupported := resp.Header.Get("Unsupported"); unsupported != "foo" {
+		t.Errorf("Unsupported is %q, expected foo", unsupported)
+	}
+	if <-forward {
+		t.Error("request with an unsupported Proxy-Require was forwarded")
+	}
+}
+
+func TestRequireForwarded(t *testing.T) {
+	conn, _ := sipnet.NewPipeConn("udp")
+	defer conn.Close()
+
+	req := parseRequest(t, testRequest(sipnet.MethodInvite, "z9hG4bKreq",
+		"Require: foo"))
+	if !checkProxyRequire(req, conn) {
+		t.Error("request with a Require for the UAS wasn't forwarded")
+	}
+}
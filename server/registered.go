@@ -9,23 +9,87 @@ import (
 type registeredUser struct {
 	username string
 	conn     *sipnet.Conn
+
+	// path is the Path of the registration, which is the route set of
+	// requests to the user.
+	path []sipnet.User
+
+	// flows are the connections of each outbound (RFC 5626) flow the user
+	// has registered over. conn is the most recently registered flow.
+	flows map[sipnet.Flow]*sipnet.Conn
+}
+
+// registeredOver returns whether the user is registered over a connection.
+func (u registeredUser) registeredOver(conn *sipnet.Conn) bool {
+	if u.conn == conn {
+		return true
+	}
+
+	for _, flowConn := range u.flows {
+		if flowConn == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// activeConn returns the connection requests to the user are sent over,
+// which is the most recently registered flow, or another registered flow
+// if it has closed.
+func (u registeredUser) activeConn() *sipnet.Conn {
+	if !u.conn.Closed {
+		return u.conn
+	}
+
+	for _, flowConn := range u.flows {
+		if !flowConn.Closed {
+			return flowConn
+		}
+	}
+	return u.conn
 }
 
 var registeredUsers = make(map[string]registeredUser)
 var registeredUsersMutex = new(sync.Mutex)
 
-func registerUser(session authSession) {
+func registerUser(session authSession, path []sipnet.User,
+	flows []sipnet.Flow) {
 	registeredUsersMutex.Lock()
 	defer registeredUsersMutex.Unlock()
 
 	username := session.user.URI.Username
-	if connected, found := registeredUsers[username]; found {
-		connected.conn.Close()
-	}
+	connected, found := registeredUsers[username]
 
 	newUser := registeredUser{
 		username: username,
 		conn:     session.conn,
+		path:     path,
+		flows:    make(map[sipnet.Flow]*sipnet.Conn),
+	}
+
+	if len(flows) == 0 {
+		if found {
+			connected.conn.Close()
+		}
+
+		registeredUsers[username] = newUser
+		return
+	}
+
+	// Flows registered with a different reg-id or instance remain
+	// registered alongside the new flow, and only a flow registered again
+	// over a new connection replaces its old connection.
+	if found {
+		for flow, conn := range connected.flows {
+			newUser.flows[flow] = conn
+		}
+	}
+
+	for _, flow := range flows {
+		if old, ok := newUser.flows[flow]; ok && old != session.conn {
+			old.Close()
+		}
+		newUser.flows[flow] = session.conn
 	}
 
 	registeredUsers[username] = newUser
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/1lann/go-sip/sipnet"
+)
+
+func TestRegisterStoresPath(t *testing.T) {
+	conn, remote := sipnet.NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	register := parseRequest(t, testRequest(sipnet.MethodRegister, "z9hG4bK1",
+		"Path: <sip:edge.example.com;lr>"))
+	path, err := sipnet.ParsePath(register.Header)
+	if err != nil {
+		t.Fatalf("failed to parse Path: %v", err)
+	}
+
+	user, _ := sipnet.ParseUser("<sip:pathuser@127.0.0.1>")
+	registerUser(authSession{user: user, conn: conn}, path, nil)
+	defer func() {
+		registeredUsersMutex.Lock()
+		delete(registeredUsers, "pathuser")
+		registeredUsersMutex.Unlock()
+	}()
+
+	registeredUsersMutex.Lock()
+	stored := registeredUsers["pathuser"].path
+	registeredUsersMutex.Unlock()
+	if len(stored) != 1 || stored[0].URI.Domain != "edge.example.com" {
+		t.Errorf("stored Path is %v, expected the Path of the REGISTER",
+			stored)
+	}
+}
+
+// registerFlow registers flowuser over conn with a REGISTER with a Contact
+// of the given reg-id.
+func registerFlow(t *testing.T, conn *sipnet.Conn, regID string) {
+	t.Helper()
+
+	register := parseRequest(t, testRequest(sipnet.MethodRegister, "z9hG4bK1",
+		"Supported: outbound",
+		`Contact: <sip:flowuser@192.0.2.1;transport=tcp>;reg-id=`+regID+
+			`;+sip.instance="<urn:uuid:00000000-0000-1000-8000-AABBCCDDEEFF>"`))
+	flows, err := sipnet.RegistrationFlows(register)
+	if err != nil || len(flows) != 1 {
+		t.Fatalf("parsed flows %v, %v, expected one flow", flows, err)
+	}
+
+	user, _ := sipnet.ParseUser("<sip:flowuser@127.0.0.1>")
+	registerUser(authSession{user: user, conn: conn}, nil, flows)
+}
+
+func TestRegisterFlows(t *testing.T) {
+	var conns []*sipnet.Conn
+	for i := 0; i < 3; i++ {
+		conn, remote := sipnet.NewPipeConn("tcp")
+		defer conn.Close()
+		defer remote.Close()
+		conns = append(conns, conn)
+	}
+	defer func() {
+		registeredUsersMutex.Lock()
+		delete(registeredUsers, "flowuser")
+		registeredUsersMutex.Unlock()
+	}()
+
+	// Each reg-id is a flow of its own, so both remain registered.
+	registerFlow(t, conns[0], "1")
+	registerFlow(t, conns[1], "2")
+
+	registeredUsersMutex.Lock()
+	user := registeredUsers["flowuser"]
+	registeredUsersMutex.Unlock()
+	if len(user.flows) != 2 || !user.registeredOver(conns[0]) ||
+		!user.registeredOver(conns[1]) {
+		t.Fatalf("registered flows %v, expected both connections",
+			user.flows)
+	}
+	if user.activeConn() != conns[1] {
+		t.Error("the most recent flow isn't the active connection")
+	}
+
+	// A flow registered again over a new connection replaces the old one.
+	registerFlow(t, conns[2], "1")
+
+	registeredUsersMutex.Lock()
+	user = registeredUsers["flowuser"]
+	registeredUsersMutex.Unlock()
+	if !conns[0].Closed || user.registeredOver(conns[0]) {
+		t.Error("the replaced connection of reg-id 1 is still registered")
+	}
+	if !user.registeredOver(conns[1]) || !user.registeredOver(conns[2]) {
+		t.Errorf("registered flows %v, expected the other connections",
+			user.flows)
+	}
+
+	conns[2].Close()
+	if user.activeConn() != conns[1] {
+		t.Error("a closed flow is the active connection")
+	}
+}
@@ -0,0 +1,75 @@
+package sipnet
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrBufferOverflow is returned by Request.AppendTo and Response.AppendTo if
+// the message doesn't fit in the capacity of the buffer.
+var ErrBufferOverflow = errors.New("sip: message too large for buffer")
+
+// fixedBuffer is a writer that appends to a slice without growing it beyond
+// its capacity.
+type fixedBuffer struct {
+	buf []byte
+}
+
+func (b *fixedBuffer) Write(p []byte) (int, error) {
+	if len(p) > cap(b.buf)-len(b.buf) {
+		return 0, ErrBufferOverflow
+	}
+
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *fixedBuffer) WriteString(s string) error {
+	if len(s) > cap(b.buf)-len(b.buf) {
+		return ErrBufferOverflow
+	}
+
+	b.buf = append(b.buf, s...)
+	return nil
+}
+
+// appendMessage appends a message with the given start line to dst.
+func appendMessage(dst []byte, startLine string, h Header, raw []string,
+	body []byte) ([]byte, error) {
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+
+	b := &fixedBuffer{buf: dst}
+	if err := b.WriteString(startLine + "\r\n"); err != nil {
+		return dst, err
+	}
+
+	if _, err := writeHeader(b, h, raw); err != nil {
+		return dst, err
+	}
+
+	if _, err := b.Write(body); err != nil {
+		return dst, err
+	}
+
+	return b.buf, nil
+}
+
+// AppendTo appends the serialized request to dst and returns the extended
+// buffer, setting its Content-Length like WriteTo. Unlike append, it never
+// grows dst: if the request doesn't fit in the spare capacity of dst,
+// ErrBufferOverflow is returned with dst unchanged, so pooled buffers of a
+// fixed size can be used without allocating.
+//
+// The request is written as it is, without the changes made by a Conn's
+// middleware.
+func (r *Request) AppendTo(dst []byte) ([]byte, error) {
+	return appendMessage(dst, r.Method+" "+r.Server+" "+SIPVersion,
+		r.Header, r.RawHeaders, r.Body)
+}
+
+// AppendTo appends the serialized response to dst and returns the extended
+// buffer, like Request.AppendTo. The reason phrase is Reason.
+func (r *Response) AppendTo(dst []byte) ([]byte, error) {
+	return appendMessage(dst, SIPVersion+" "+strconv.Itoa(r.StatusCode)+
+		" "+r.Reason(), r.Header, r.RawHeaders, r.Body)
+}
@@ -0,0 +1,89 @@
+package sipnet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAppendToExactFit(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKappend"))
+	req.Body = []byte("hello")
+
+	full, err := req.AppendTo(make([]byte, 0, 4096))
+	if err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	// A buffer of exactly the message size fits it.
+	exact, err := req.AppendTo(make([]byte, 0, len(full)))
+	if err != nil {
+		t.Fatalf("failed to append to an exactly sized buffer: %v", err)
+	}
+	if len(exact) != len(full) || cap(exact) != len(full) {
+		t.Errorf("appended %d bytes with capacity %d, expected %d",
+			len(exact), cap(exact), len(full))
+	}
+
+	parsed := parseRequest(t, string(exact))
+	if parsed.Method != MethodMessage || string(parsed.Body) != "hello" ||
+		parsed.Header.Get("Content-Length") != "5" {
+		t.Errorf("appended %q", exact)
+	}
+}
+
+func TestAppendToOverflow(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKappend"))
+	req.Body = []byte("hello")
+
+	full, err := req.AppendTo(make([]byte, 0, 4096))
+	if err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	// The buffer isn't grown, and is returned unchanged on overflow.
+	dst := append(make([]byte, 0, len(full)), "prefix"...)
+	result, err := req.AppendTo(dst)
+	if err != ErrBufferOverflow {
+		t.Fatalf("overflowing returned %v, expected ErrBufferOverflow", err)
+	}
+	if string(result) != "prefix" || cap(result) != len(full) {
+		t.Errorf("overflowing returned %q with capacity %d", result,
+			cap(result))
+	}
+}
+
+func TestAppendToPooledBuffer(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKpooled"))
+	resp := NewResponse()
+	resp.StatusCode = StatusRinging
+	resp.Header.Set("Via", req.Header.Get("Via"))
+	resp.Header.Set("Call-ID", req.Header.Get("Call-ID"))
+	resp.Header.Set("CSeq", req.Header.Get("CSeq"))
+
+	// Several messages can be appended to the same buffer, as is done when
+	// reusing a pooled buffer.
+	buf := make([]byte, 0, 4096)
+	buf, err := req.AppendTo(buf)
+	if err != nil {
+		t.Fatalf("failed to append the request: %v", err)
+	}
+	split := len(buf)
+	buf, err = resp.AppendTo(buf)
+	if err != nil {
+		t.Fatalf("failed to append the response: %v", err)
+	}
+
+	if parsed := parseRequest(t, string(buf[:split])); parsed.Method != MethodInvite {
+		t.Errorf("appended %s, expected INVITE", parsed.Method)
+	}
+
+	parsed, err := ReadResponse(bytes.NewReader(buf[split:]))
+	if err != nil {
+		t.Fatalf("failed to parse the response: %v", err)
+	}
+	if parsed.StatusCode != StatusRinging ||
+		!strings.HasPrefix(string(buf[split:]), "SIP/2.0 180 Ringing\r\n") {
+		t.Errorf("appended %q", buf[split:])
+	}
+}
@@ -0,0 +1,228 @@
+package sipnet
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrMutualAuthentication is returned by DigestClient.Update if the rspauth
+// of an Authentication-Info header does not prove the server knows the
+// credentials.
+var ErrMutualAuthentication = errors.New("sip: mutual authentication failed")
+
+// AuthenticationInfo represents the Authentication-Info header (RFC 2617
+// section 3.2.3) of a response to an authenticated request.
+type AuthenticationInfo struct {
+	// NextNonce is the nonce to use for the next request, if any.
+	NextNonce string
+	Qop       string
+	// Rspauth proves that the server knows the credentials.
+	Rspauth string
+	Cnonce  string
+	NC      string
+}
+
+// ParseAuthenticationInfo parses the value of an Authentication-Info
+// header.
+func ParseAuthenticationInfo(str string) (AuthenticationInfo, error) {
+	if strings.TrimSpace(str) == "" {
+		return AuthenticationInfo{}, ErrParseError
+	}
+
+	args := ParsePairs(str)
+	return AuthenticationInfo{
+		NextNonce: args.Get("nextnonce"),
+		Qop:       args.Get("qop"),
+		Rspauth:   args.Get("rspauth"),
+		Cnonce:    args.Get("cnonce"),
+		NC:        args.Get("nc"),
+	}, nil
+}
+
+// String returns the Authentication-Info as a header value.
+func (a AuthenticationInfo) String() string {
+	var params []string
+	if a.NextNonce != "" {
+		params = append(params, "nextnonce="+QuoteString(a.NextNonce))
+	}
+	if a.Qop != "" {
+		params = append(params, "qop="+a.Qop)
+	}
+	if a.Rspauth != "" {
+		params = append(params, "rspauth="+QuoteString(a.Rspauth))
+	}
+	if a.Cnonce != "" {
+		params = append(params, "cnonce="+QuoteString(a.Cnonce))
+	}
+	if a.NC != "" {
+		params = append(params, "nc="+a.NC)
+	}
+
+	return strings.Join(params, ", ")
+}
+
+// AuthenticationInfo returns the Authentication-Info of a response, and
+// whether it has one.
+func (r *Response) AuthenticationInfo() (AuthenticationInfo, bool) {
+	info, err := ParseAuthenticationInfo(r.Header.Get("Authentication-Info"))
+	return info, err == nil
+}
+
+// DigestClient answers Digest challenges for a series of requests to the
+// same server. Unlike Authorize, it remembers the last challenge, so later
+// requests are authorized without being challenged again, and it adopts
+// the nextnonce of Authentication-Info headers. It is safe to use from
+// multiple goroutines.
+type DigestClient struct {
+	Credentials Credentials
+
+	mutex     sync.Mutex
+	challenge *digestChallenge
+	nc        uint32
+}
+
+// NewDigestClient returns a DigestClient which answers challenges with the
+// given credentials.
+func NewDigestClient(creds Credentials) *DigestClient {
+	return &DigestClient{Credentials: creds}
+}
+
+// Authorize answers the challenge of resp like Authorize, and remembers it
+// for later requests.
+func (d *DigestClient) Authorize(req *Request, resp *Response) error {
+	ch, err := parseChallenge(resp)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.challenge = &ch
+	d.nc = 1
+	ch.authorize(req, d.Credentials, d.nc)
+	return nil
+}
+
+// Preauthorize authorizes req with the last challenge, incrementing the
+// nonce count, and returns whether there was a challenge to answer.
+func (d *DigestClient) Preauthorize(req *Request) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.challenge == nil {
+		return false
+	}
+
+	d.nc++
+	d.challenge.authorize(req, d.Credentials, d.nc)
+	return true
+}
+
+// Update handles the Authentication-Info of a response to a request
+// authorized by the client. If it has a rspauth, it is verified against
+// the authorization of the request, and ErrMutualAuthentication is
+// returned if it doesn't match. If it has a nextnonce, it is used for the
+// following requests.
+func (d *DigestClient) Update(req *Request, resp *Response) error {
+	info, ok := resp.AuthenticationInfo()
+	if !ok {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.challenge == nil {
+		return nil
+	}
+
+	if info.Rspauth != "" && !d.verify(req, info) {
+		return ErrMutualAuthentication
+	}
+
+	if info.NextNonce != "" {
+		next := *d.challenge
+		next.nonce = info.NextNonce
+		d.challenge = &next
+		d.nc = 0
+	}
+
+	return nil
+}
+
+// verify returns whether the rspauth of info matches the authorization of
+// req, which is calculated like the response of the request with an empty
+// method (RFC 2617 section 3.2.3).
+func (d *DigestClient) verify(req *Request, info AuthenticationInfo) bool {
+	auth := req.Header.Get(d.challenge.authKey)
+	if len(auth) < 7 || strings.ToLower(auth[:7]) != "digest " {
+		return false
+	}
+
+	hash := func(data string) string {
+		return digestHex(d.challenge.algorithm, data)
+	}
+
+	args := ParsePairs(auth[7:])
+	ha1 := hash(d.Credentials.Username + ":" + args.Get("realm") + ":" +
+		d.Credentials.Password)
+	ha2 := hash(":" + args.Get("uri"))
+
+	var expected string
+	if args.Get("qop") != "" {
+		if info.Cnonce != "" && info.Cnonce != args.Get("cnonce") {
+			return false
+		}
+		if info.NC != "" {
+			nc, err := strconv.ParseUint(info.NC, 16, 32)
+			if err != nil || formatNonceCount(uint32(nc)) != args.Get("nc") {
+				return false
+			}
+		}
+
+		expected = hash(ha1 + ":" + args.Get("nonce") + ":" +
+			args.Get("nc") + ":" + args.Get("cnonce") + ":auth:" + ha2)
+	} else {
+		expected = hash(ha1 + ":" + args.Get("nonce") + ":" + ha2)
+	}
+
+	return strings.EqualFold(expected, info.Rspauth)
+}
+
+// DoDigest sends a request like DoAuthenticated, answering challenges with
+// the DigestClient. If the client has answered a challenge before, the
+// request is authorized before it is first sent. The Authentication-Info of
+// the final response is passed to DigestClient.Update, whose error is
+// returned alongside the response.
+func (c *Conn) DoDigest(req *Request, client *DigestClient) (*Response, error) {
+	client.Preauthorize(req)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if isChallenge(resp) {
+		err = client.Authorize(req, resp)
+		if err != nil {
+			return resp, nil
+		}
+
+		req.Header.Set("Via", c.NewVia().String())
+		cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+		if err == nil {
+			cseq.Sequence++
+			req.Header.Set("CSeq", cseq.String())
+		}
+
+		resp, err = c.Do(req)
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	return resp, client.Update(req, resp)
+}
@@ -0,0 +1,115 @@
+package sipnet
+
+import "testing"
+
+func TestParseAuthenticationInfo(t *testing.T) {
+	info, err := ParseAuthenticationInfo(`nextnonce="47364c23432d2e131a5fb210812c", ` +
+		`qop=auth, rspauth="6629fae49393a05397450978507c4ef1", ` +
+		`cnonce="0a4f113b", nc=00000001`)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	expected := AuthenticationInfo{
+		NextNonce: "47364c23432d2e131a5fb210812c",
+		Qop:       "auth",
+		Rspauth:   "6629fae49393a05397450978507c4ef1",
+		Cnonce:    "0a4f113b",
+		NC:        "00000001",
+	}
+	if info != expected {
+		t.Errorf("parsed %+v, expected %+v", info, expected)
+	}
+
+	parsed, err := ParseAuthenticationInfo(info.String())
+	if err != nil || parsed != expected {
+		t.Errorf("round-tripped %q to %+v, %v", info.String(), parsed, err)
+	}
+
+	if _, err := ParseAuthenticationInfo(" "); err != ErrParseError {
+		t.Errorf("parsing an empty header returned %v, expected "+
+			"ErrParseError", err)
+	}
+}
+
+// digestChallengeResponse returns a 401 challenging a request with the
+// given nonce.
+func digestChallengeResponse(nonce string) *Response {
+	resp := NewResponse()
+	resp.StatusCode = StatusUnauthorized
+	resp.Header.Set("WWW-Authenticate", `Digest realm="example.com", nonce="`+
+		nonce+`", qop="auth"`)
+	return resp
+}
+
+// rspauth returns the rspauth proving knowledge of alice's credentials for
+// an authorized request.
+func rspauth(req *Request) string {
+	args := ParsePairs(req.Header.Get("Authorization")[len("Digest "):])
+	ha1 := md5Hex("alice:example.com:secret")
+	ha2 := md5Hex(":" + args.Get("uri"))
+	return md5Hex(ha1 + ":" + args.Get("nonce") + ":" + args.Get("nc") + ":" +
+		args.Get("cnonce") + ":auth:" + ha2)
+}
+
+func TestDigestClientNextNonce(t *testing.T) {
+	client := NewDigestClient(Credentials{Username: "alice", Password: "secret"})
+
+	first := newTestRequest(MethodRegister, "sip:example.com")
+	if client.Preauthorize(first) {
+		t.Fatal("preauthorized without a challenge")
+	}
+	if err := client.Authorize(first, digestChallengeResponse("n1")); err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+
+	// The 200 proves the server knows the credentials, and gives the nonce
+	// of the next request.
+	ok := NewResponse()
+	ok.StatusCode = StatusOK
+	ok.Header.Set("Authentication-Info", AuthenticationInfo{
+		NextNonce: "n2",
+		Qop:       "auth",
+		Rspauth:   rspauth(first),
+	}.String())
+	if err := client.Update(first, ok); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	next := newTestRequest(MethodRegister, "sip:example.com")
+	if !client.Preauthorize(next) {
+		t.Fatal("the next request wasn't preauthorized")
+	}
+	args := ParsePairs(next.Header.Get("Authorization")[len("Digest "):])
+	if args.Get("nonce") != "n2" || args.Get("nc") != "00000001" {
+		t.Errorf("authorized with nonce %q and nc %s, expected \"n2\" and "+
+			"00000001", args.Get("nonce"), args.Get("nc"))
+	}
+}
+
+func TestDigestClientMutualAuthentication(t *testing.T) {
+	client := NewDigestClient(Credentials{Username: "alice", Password: "secret"})
+	req := newTestRequest(MethodRegister, "sip:example.com")
+	if err := client.Authorize(req, digestChallengeResponse("n1")); err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+
+	ok := NewResponse()
+	ok.StatusCode = StatusOK
+	ok.Header.Set("Authentication-Info", AuthenticationInfo{
+		NextNonce: "n2",
+		Rspauth:   md5Hex("forged"),
+	}.String())
+	if err := client.Update(req, ok); err != ErrMutualAuthentication {
+		t.Errorf("a forged rspauth returned %v, expected "+
+			"ErrMutualAuthentication", err)
+	}
+
+	// The nextnonce of a forged response isn't adopted.
+	next := newTestRequest(MethodRegister, "sip:example.com")
+	client.Preauthorize(next)
+	args := ParsePairs(next.Header.Get("Authorization")[len("Digest "):])
+	if args.Get("nonce") != "n1" {
+		t.Errorf("authorized with nonce %q, expected \"n1\"", args.Get("nonce"))
+	}
+}
@@ -0,0 +1,89 @@
+package sipnet
+
+import (
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+)
+
+// SetBody replaces the body of the request, and updates its Content-Length
+// to match, so a request modified in place, such as by middleware, is
+// framed correctly however it is serialized. A streamed BodyReader is
+// discarded.
+func (r *Request) SetBody(body []byte) {
+	streamedBody(r).discard()
+	r.BodyReader = nil
+	r.Body = body
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// SetBody replaces the body of the response, and updates its
+// Content-Length to match.
+func (r *Response) SetBody(body []byte) {
+	r.Body = body
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// bodyReader streams the body of a message from the connection it was
+// received on. The reader of the connection waits until the body has been
+// read or closed before reading the next message.
+type bodyReader struct {
+	r    io.Reader
+	done chan struct{}
+	once sync.Once
+}
+
+func newBodyReader(rd io.Reader, length int) *bodyReader {
+	return &bodyReader{
+		r:    io.LimitReader(rd, int64(length)),
+		done: make(chan struct{}),
+	}
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil {
+		b.once.Do(func() { close(b.done) })
+	}
+	return n, err
+}
+
+// Close discards the unread remainder of the body.
+func (b *bodyReader) Close() error {
+	_, err := io.Copy(ioutil.Discard, b.r)
+	b.once.Do(func() { close(b.done) })
+	return err
+}
+
+// streamedBody returns the streamed body of a request, or nil if it has
+// none.
+func streamedBody(req *Request) *bodyReader {
+	if req == nil {
+		return nil
+	}
+
+	body, _ := req.BodyReader.(*bodyReader)
+	return body
+}
+
+// discard discards the body if it is streamed, for requests which are not
+// delivered to the user.
+func (b *bodyReader) discard() {
+	if b != nil {
+		b.Close()
+	}
+}
+
+// wait blocks until the body has been read or closed, if it is streamed,
+// or until closed is closed, such as when the connection closes.
+func (b *bodyReader) wait(closed <-chan struct{}) {
+	if b == nil {
+		return
+	}
+
+	select {
+	case <-b.done:
+	case <-closed:
+	}
+}
@@ -0,0 +1,173 @@
+package sipnet
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// largeBody returns a body of n bytes which isn't a repetition of a short
+// sequence, so misplaced reads are noticed.
+func largeBody(n int) []byte {
+	body := make([]byte, n)
+	for i := range body {
+		body[i] = byte('a' + i%26 + i/4096%7)
+	}
+	return body
+}
+
+// requestWithBody returns a serialized request with a body.
+func requestWithBody(branch string, body []byte) string {
+	msg := testRequest(MethodMessage, branch, "Content-Type: text/plain")
+	msg = strings.Replace(msg, "Content-Length: 0",
+		"Content-Length: "+strconv.Itoa(len(body)), 1)
+	return msg + string(body)
+}
+
+func TestParserStreamsLargeBody(t *testing.T) {
+	body := largeBody(1 << 20)
+	parser := &Parser{StreamBodyThreshold: 4096}
+	req, err := parser.ReadRequest(strings.NewReader(requestWithBody("z9hG4bK1",
+		body)))
+	if err != nil {
+		t.Fatalf("failed to parse request: %v", err)
+	}
+	if req.BodyReader == nil || req.Body != nil {
+		t.Fatal("large body was buffered, expected it to be streamed")
+	}
+
+	streamed, err := ioutil.ReadAll(req.BodyReader)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if !bytes.Equal(streamed, body) {
+		t.Errorf("streamed %d bytes, which differ from the %d byte body",
+			len(streamed), len(body))
+	}
+
+	// Small bodies are buffered.
+	req, err = parser.ReadRequest(strings.NewReader(requestWithBody("z9hG4bK2",
+		[]byte("hello"))))
+	if err != nil {
+		t.Fatalf("failed to parse request: %v", err)
+	}
+	if req.BodyReader != nil || string(req.Body) != "hello" {
+		t.Errorf("small body was streamed, expected it to be buffered")
+	}
+}
+
+func TestConnStreamsLargeBody(t *testing.T) {
+	l := listenTest(t, Config{Parser: Parser{StreamBodyThreshold: 4096}})
+	defer l.Close()
+
+	peer, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer peer.Close()
+
+	// The body is larger than any buffer of the reader, and is followed by
+	// another request on the same connection.
+	body := largeBody(1 << 20)
+	go func() {
+		peer.SetWriteDeadline(time.Now().Add(testTimeout))
+		peer.Write([]byte(requestWithBody("z9hG4bK1", body) +
+			testRequest(MethodMessage, "z9hG4bK2")))
+	}()
+
+	req, _ := acceptRequest(t, l)
+	if req.BodyReader == nil {
+		t.Fatal("large body was buffered, expected it to be streamed")
+	}
+	streamed, err := ioutil.ReadAll(req.BodyReader)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if !bytes.Equal(streamed, body) {
+		t.Errorf("streamed %d bytes, which differ from the %d byte body",
+			len(streamed), len(body))
+	}
+
+	next, _ := acceptRequest(t, l)
+	if next.Header.Get("Via") != "SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bK2" {
+		t.Errorf("accepted %q, expected the request after the body",
+			next.Header.Get("Via"))
+	}
+}
+
+func TestSetBodyRecomputesContentLength(t *testing.T) {
+	parser := &Parser{PreserveHeaders: []string{"Content-Length", "Call-ID"}}
+	req, err := parser.ReadRequest(strings.NewReader(
+		requestWithBody("z9hG4bKmutate", []byte("hello"))))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	req.SetBody([]byte("hello world"))
+	if length := req.Header.Get("Content-Length"); length != "11" {
+		t.Errorf("Content-Length is %q after setting the body, expected 11",
+			length)
+	}
+
+	// The preserved Content-Length line is stale, so it isn't written.
+	data := writeRequest(t, req)
+	if strings.Contains(data, "Content-Length: 5\r\n") {
+		t.Error("the stale Content-Length was written")
+	}
+	if parsed := parseRequest(t, data); string(parsed.Body) != "hello world" {
+		t.Errorf("body round-tripped to %q", parsed.Body)
+	}
+
+	resp := NewResponse()
+	resp.SetBody([]byte("hi"))
+	if length := resp.Header.Get("Content-Length"); length != "2" {
+		t.Errorf("Content-Length of the response is %q, expected 2", length)
+	}
+}
+
+func TestContentLengthMismatch(t *testing.T) {
+	msg := requestWithBody("z9hG4bKmismatch", []byte("hello"))
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{"malformed", strings.Replace(msg, "Content-Length: 5",
+			"Content-Length: five", 1)},
+		{"negative", strings.Replace(msg, "Content-Length: 5",
+			"Content-Length: -5", 1)},
+		{"conflicting", strings.Replace(msg, "Content-Length: 5",
+			"Content-Length: 5\r\nContent-Length: 7", 1)},
+		{"truncated", strings.TrimSuffix(msg, "lo")},
+	}
+
+	for _, test := range tests {
+		if _, err := ReadRequest(strings.NewReader(test.msg)); err != ErrContentLength {
+			t.Errorf("%s: parsed with %v, expected ErrContentLength",
+				test.name, err)
+		}
+	}
+}
+
+func TestStreamMissingContentLength(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	writePipe(t, remote, strings.Replace(testRequest(MethodMessage,
+		"z9hG4bKnolength"), "Content-Length: 0\r\n", "", 1))
+	_, err := conn.ReadTimeout(testTimeout)
+	if msgErr, ok := err.(*MessageError); !ok || msgErr.Err != ErrContentLength {
+		t.Fatalf("read error %v, expected a *MessageError of ErrContentLength",
+			err)
+	}
+
+	// The next message is still read.
+	writePipe(t, remote, testRequest(MethodOptions, "z9hG4bKlength"))
+	if req := readRequest(t, conn); req.Method != MethodOptions {
+		t.Errorf("read %s, expected OPTIONS", req.Method)
+	}
+}
@@ -0,0 +1,37 @@
+package sipnet
+
+// BranchStore records the transactions of received requests, so that
+// retransmissions are detected and absorbed. A store shared between
+// instances, such as one backed by Redis, lets a retransmission received by
+// any instance of a clustered deployment be recognised. Keys should be
+// kept for at least the transaction timeout of 32 seconds.
+type BranchStore interface {
+	// Seen returns whether a transaction key has been recorded.
+	Seen(key string) bool
+
+	// Record records a transaction key.
+	Record(key string)
+}
+
+// seenBranch returns whether the transaction of a received request has been
+// recorded with recordBranch, in the configured BranchStore, or in
+// ReceivedBranches if there is none. BranchMutex must be held.
+func (c *Conn) seenBranch(key string) bool {
+	if store := c.config().BranchStore; store != nil {
+		return store.Seen(key)
+	}
+
+	_, seen := c.ReceivedBranches[key]
+	return seen
+}
+
+// recordBranch records the transaction of a received request. BranchMutex
+// must be held.
+func (c *Conn) recordBranch(key string) {
+	if store := c.config().BranchStore; store != nil {
+		store.Record(key)
+		return
+	}
+
+	c.ReceivedBranches[key] = c.clock().Now()
+}
@@ -0,0 +1,99 @@
+package sipnet
+
+import (
+	"sync"
+	"testing"
+)
+
+// mockBranchStore is a BranchStore recording the calls made to it.
+type mockBranchStore struct {
+	mutex    sync.Mutex
+	keys     map[string]bool
+	seen     []string
+	recorded []string
+}
+
+func newMockBranchStore() *mockBranchStore {
+	return &mockBranchStore{keys: make(map[string]bool)}
+}
+
+func (m *mockBranchStore) Seen(key string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.seen = append(m.seen, key)
+	return m.keys[key]
+}
+
+func (m *mockBranchStore) Record(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.recorded = append(m.recorded, key)
+	m.keys[key] = true
+}
+
+// calls returns the number of calls made to Seen and Record.
+func (m *mockBranchStore) calls() (int, int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.seen), len(m.recorded)
+}
+
+func TestBranchStoreDeduplicates(t *testing.T) {
+	store := newMockBranchStore()
+	l := listenTest(t, Config{BranchStore: store})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	msg := testRequest(MethodMessage, "z9hG4bKstore")
+	sendUDP(t, peer, l, msg)
+	_, conn := acceptRequest(t, l)
+
+	// The retransmission is absorbed, by the decision of the store.
+	sendUDP(t, peer, l, msg)
+	waitFor(t, "the retransmission to be checked", func() bool {
+		seen, _ := store.calls()
+		return seen == 2
+	})
+	key := TransactionKey(parseRequest(t, msg))
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKstorenext"))
+	if req, _ := acceptRequest(t, l); TransactionKey(req) == key {
+		t.Error("the retransmission was accepted")
+	}
+
+	store.mutex.Lock()
+	if store.seen[0] != key || store.seen[1] != key || store.recorded[0] != key {
+		t.Errorf("store was asked about %q and recorded %q, expected %q",
+			store.seen, store.recorded, key)
+	}
+	if len(store.recorded) != 2 {
+		t.Errorf("recorded %q, expected the two transactions", store.recorded)
+	}
+	store.mutex.Unlock()
+
+	conn.BranchMutex.Lock()
+	if len(conn.ReceivedBranches) != 0 {
+		t.Errorf("conn recorded %v, expected the store to be used alone",
+			conn.ReceivedBranches)
+	}
+	conn.BranchMutex.Unlock()
+}
+
+func TestBranchStoreSharedTransaction(t *testing.T) {
+	// The transaction was received by another instance sharing the store.
+	store := newMockBranchStore()
+	msg := testRequest(MethodMessage, "z9hG4bKshared")
+	store.Record(TransactionKey(parseRequest(t, msg)))
+
+	l := listenTest(t, Config{BranchStore: store})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, msg)
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKsharednext"))
+	req, _ := acceptRequest(t, l)
+	if TransactionKey(req) == TransactionKey(parseRequest(t, msg)) {
+		t.Error("accepted a transaction recorded by another instance")
+	}
+}
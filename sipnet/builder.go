@@ -0,0 +1,210 @@
+package sipnet
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// BranchMagicCookie is the prefix of branches generated by RFC 3261
+// compliant implementations.
+const BranchMagicCookie = "z9hG4bK"
+
+// NewBranch returns a newly generated branch for a Via, prefixed with
+// BranchMagicCookie.
+func NewBranch() string {
+	return BranchMagicCookie + randomHex(8)
+}
+
+// StatelessBranch returns a branch for a Via derived from a received
+// request (RFC 3261 section 16.11), so the same branch is generated when
+// the request is retransmitted. It is derived from the top Via, Request-URI,
+// Call-ID, tags and CSeq number of the request, so a CANCEL or ACK for a
+// non-2xx response has the same branch as the INVITE it belongs to.
+func StatelessBranch(req *Request) string {
+	var via string
+	if vias := splitVias(req.Header); len(vias) > 0 {
+		via = vias[0]
+	}
+
+	var fromTag, toTag string
+	from, to, err := ParseUserHeader(req.Header)
+	if err == nil {
+		fromTag = from.Arguments.Get("tag")
+		toTag = to.Arguments.Get("tag")
+	}
+
+	var seq string
+	cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+	if err == nil {
+		seq = strconv.FormatUint(uint64(cseq.Sequence), 10)
+	}
+
+	if req.Method == MethodAck {
+		// The To tag of an ACK was added by the response, so it is not
+		// part of the INVITE's branch.
+		toTag = ""
+	}
+
+	sum := sha1.Sum([]byte(strings.Join([]string{via, req.Server,
+		req.Header.Get("Call-ID"), fromTag, toTag, seq}, "\n")))
+	return BranchMagicCookie + hex.EncodeToString(sum[:8])
+}
+
+// NewTag returns a newly generated tag for the From or To header.
+func NewTag() string {
+	return randomHex(8)
+}
+
+// NewCallID returns a newly generated Call-ID, unique to the host.
+func NewCallID(host string) string {
+	return randomHex(16) + "@" + host
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// SentBy returns the host:port that identifies the listener in Via sent-by,
+// Contact and Record-Route headers. This is the advertised host and port if
+// they are configured, otherwise the address the listener is bound to.
+func (l *Listener) SentBy() string {
+	return l.SentByTransport(TCP.Name())
+}
+
+// SentByTransport returns the host:port that identifies the listener like
+// SentBy, for connections of the named transport. This is the advertised
+// address of the transport if it is configured in AdvertisedAddrs,
+// otherwise the address the listener is bound to with the transport.
+func (l *Listener) SentByTransport(transport string) string {
+	addr := l.TransportAddr(transport).String()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if l.config.AdvertisedHost != "" {
+		host = l.config.AdvertisedHost
+	}
+
+	if l.config.AdvertisedPort != 0 {
+		port = strconv.Itoa(l.config.AdvertisedPort)
+	}
+
+	advertised := l.config.AdvertisedAddrs[strings.ToLower(transport)]
+	advertisedHost, advertisedPort, err := net.SplitHostPort(advertised)
+	if err == nil && advertisedHost != "" {
+		host = advertisedHost
+	}
+	if err == nil && advertisedPort != "" {
+		port = advertisedPort
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// advertisesHost returns whether a host is advertised for connections of
+// the named transport, rather than the host the listener is bound to.
+func (l *Listener) advertisesHost(transport string) bool {
+	if l.config.AdvertisedHost != "" {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(
+		l.config.AdvertisedAddrs[strings.ToLower(transport)])
+	return err == nil && host != ""
+}
+
+// SentBy returns the host:port that identifies the local side of the
+// connection in Via sent-by, Contact and Record-Route headers. For a
+// connection of a listener, this is the address the listener advertises for
+// the connection's transport. For a
+// listener bound to an unspecified address with PacketInfo configured, the
+// host is the address the peer's datagrams are received on.
+func (c *Conn) SentBy() string {
+	if c.Listener != nil {
+		transport := c.protocol().Name()
+		sentBy := c.Listener.SentByTransport(transport)
+		ip := c.receivedOn()
+		host, port, err := net.SplitHostPort(sentBy)
+		if ip == nil || err != nil || c.Listener.advertisesHost(transport) {
+			return sentBy
+		}
+
+		if bound := net.ParseIP(host); bound != nil && bound.IsUnspecified() {
+			return net.JoinHostPort(ip.String(), port)
+		}
+		return sentBy
+	}
+
+	return c.LocalAddr().String()
+}
+
+// NewVia returns a Via identifying the local side of the connection, with
+// a newly generated branch, to be added to requests sent over the connection.
+// Its protocol is the transport of the connection, i.e. "SIP/2.0/TLS".
+func (c *Conn) NewVia() Via {
+	args := make(HeaderArgs)
+	args.Set("branch", NewBranch())
+	args.Set("rport", "")
+
+	return Via{
+		SIPVersion: SIPVersion,
+		Transport:  strings.ToUpper(c.protocol().Name()),
+		Client:     c.SentBy(),
+		Arguments:  args,
+	}
+}
+
+// ForwardVia returns a Via identifying the local side of the connection,
+// to be added to the top of a request forwarded over the connection. Its
+// branch is derived from the request if StatelessBranches is configured.
+func (c *Conn) ForwardVia(req *Request) Via {
+	via := c.NewVia()
+	if c.config().StatelessBranches {
+		via.Arguments.Set("branch", StatelessBranch(req))
+	}
+	return via
+}
+
+// Contact returns a user to be used in the Contact header of messages sent
+// over the connection for the given username.
+func (c *Conn) Contact(username string) User {
+	args := make(HeaderArgs)
+	args.Set("transport", c.protocol().Name())
+
+	return User{
+		URI: URI{
+			Scheme:    "sip",
+			Username:  username,
+			Domain:    c.SentBy(),
+			Arguments: args,
+		},
+		Arguments: make(HeaderArgs),
+	}
+}
+
+// RecordRoute returns a user to be used in the Record-Route header of
+// requests forwarded over the connection.
+func (c *Conn) RecordRoute() User {
+	args := make(HeaderArgs)
+	args.Set("transport", c.protocol().Name())
+	args.Set("lr", "")
+
+	return User{
+		URI: URI{
+			Scheme:    "sip",
+			Domain:    c.SentBy(),
+			Arguments: args,
+		},
+		Arguments: make(HeaderArgs),
+	}
+}
@@ -0,0 +1,162 @@
+package sipnet
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBuilderUsesAdvertisedAddress(t *testing.T) {
+	l, err := ListenWithConfig("0.0.0.0:0", Config{
+		AdvertisedHost: "203.0.113.5",
+		AdvertisedPort: 5080,
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	const expected = "203.0.113.5:5080"
+	if sentBy := conn.NewVia().Client; sentBy != expected {
+		t.Errorf("Via sent-by is %s, expected %s", sentBy, expected)
+	}
+	if domain := conn.Contact("alice").URI.Domain; domain != expected {
+		t.Errorf("Contact host is %s, expected %s", domain, expected)
+	}
+	if domain := conn.RecordRoute().URI.Domain; domain != expected {
+		t.Errorf("Record-Route host is %s, expected %s", domain, expected)
+	}
+}
+
+func TestViaFollowsTransport(t *testing.T) {
+	l := listenTest(t, Config{
+		AdvertisedAddrs: map[string]string{"tls": ":5061"},
+	})
+	defer l.Close()
+	cert, _ := testCertificate(t, "127.0.0.1")
+	transport := &TLSTransport{Config: &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}}
+	if err := l.ListenTransport(transport, "127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to listen with TLS: %v", err)
+	}
+
+	tests := []struct {
+		transport Transport
+		protocol  string
+		sentBy    string
+	}{
+		{UDP, "UDP", l.TransportAddr("udp").String()},
+		{TCP, "TCP", l.Addr().String()},
+		{transport, "TLS", "127.0.0.1:5061"},
+	}
+
+	for _, test := range tests {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		conn := newConn(test.transport, local)
+		conn.Listener = l
+
+		via := conn.NewVia()
+		if via.Transport != test.protocol {
+			t.Errorf("%s: Via protocol is %s", test.protocol, via.Transport)
+		}
+		if via.Client != test.sentBy {
+			t.Errorf("%s: Via sent-by is %s, expected %s", test.protocol,
+				via.Client, test.sentBy)
+		}
+
+		contact := conn.Contact("alice").URI
+		if transport := contact.Arguments.Get("transport"); transport !=
+			test.transport.Name() {
+			t.Errorf("%s: Contact transport is %q", test.protocol, transport)
+		}
+	}
+
+	// The TLS listener is advertised when no address is configured for it.
+	plain := listenTest(t, Config{})
+	defer plain.Close()
+	if err := plain.ListenTransport(transport, "127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to listen with TLS: %v", err)
+	}
+	sentBy := plain.SentByTransport("TLS")
+	if expected := plain.TransportAddr("tls").String(); sentBy != expected ||
+		sentBy == plain.Addr().String() {
+		t.Errorf("TLS sent-by is %s, expected %s", sentBy, expected)
+	}
+}
+
+func TestNewBranchUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		branch := NewBranch()
+		if !strings.HasPrefix(branch, BranchMagicCookie) {
+			t.Fatalf("branch %q lacks the magic cookie", branch)
+		}
+		if seen[branch] {
+			t.Fatalf("branch %q was generated twice", branch)
+		}
+		seen[branch] = true
+	}
+}
+
+func TestStatelessBranch(t *testing.T) {
+	invite := testRequest(MethodInvite, "z9hG4bKstateless")
+	branch := StatelessBranch(parseRequest(t, invite))
+	if !strings.HasPrefix(branch, BranchMagicCookie) {
+		t.Errorf("branch %q lacks the magic cookie", branch)
+	}
+
+	// A retransmission has the same branch.
+	if again := StatelessBranch(parseRequest(t, invite)); again != branch {
+		t.Errorf("retransmission has branch %q, expected %q", again, branch)
+	}
+
+	// So does the ACK for a non-2xx response, which has the To tag of the
+	// response.
+	ack := strings.Replace(strings.Replace(invite, "INVITE", "ACK", -1),
+		"To: <sip:bob@127.0.0.1>", "To: <sip:bob@127.0.0.1>;tag=b1", 1)
+	if ackBranch := StatelessBranch(parseRequest(t, ack)); ackBranch != branch {
+		t.Errorf("ACK has branch %q, expected %q", ackBranch, branch)
+	}
+
+	// Another request has a different branch.
+	other := StatelessBranch(parseRequest(t,
+		testRequest(MethodInvite, "z9hG4bKother")))
+	if other == branch {
+		t.Error("requests with different Vias have the same branch")
+	}
+}
+
+func TestForwardViaStatelessBranches(t *testing.T) {
+	peer := udpPeer(t)
+	defer peer.Close()
+	req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKforward"))
+
+	for _, stateless := range []bool{false, true} {
+		l := listenTest(t, Config{StatelessBranches: stateless})
+		conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		first := conn.ForwardVia(req).Arguments.Get("branch")
+		second := conn.ForwardVia(req).Arguments.Get("branch")
+		if stateless && (first != second || first != StatelessBranch(req)) {
+			t.Errorf("stateless branches %q and %q aren't derived from the "+
+				"request", first, second)
+		} else if !stateless && first == second {
+			t.Errorf("stateful branch %q was reused", first)
+		}
+		l.Close()
+	}
+}
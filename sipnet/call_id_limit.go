@@ -0,0 +1,101 @@
+package sipnet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// callIDWindow is how long a Call-ID is remembered for a source after its
+// last request, during which its requests don't count as new Call-IDs.
+const callIDWindow = maxPendingInvite
+
+// callIDLimiter limits the rate of new Call-IDs started by each source IP,
+// which bounds call bombing by a single UDP peer.
+type callIDLimiter struct {
+	limiter *rateLimiter
+
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+func newCallIDLimiter(rate float64, burst int, clock Clock) *callIDLimiter {
+	return &callIDLimiter{
+		limiter: newRateLimiter(rate, burst, clock),
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// allow records the Call-ID of a request from addr, and returns whether it
+// is a known Call-ID of the source, or a new one within the rate limit.
+func (c *callIDLimiter) allow(addr net.Addr, callID string) bool {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	key := host + " " + callID
+	now := c.limiter.clock.Now()
+
+	c.mutex.Lock()
+	_, known := c.seen[key]
+	if known {
+		c.seen[key] = now
+	}
+	c.mutex.Unlock()
+
+	if known {
+		return true
+	}
+
+	if !c.limiter.allow(addr) {
+		return false
+	}
+
+	c.mutex.Lock()
+	c.seen[key] = now
+	c.mutex.Unlock()
+	return true
+}
+
+// expire forgets the Call-IDs which haven't been used within the window,
+// and the buckets which have refilled.
+func (c *callIDLimiter) expire() {
+	now := c.limiter.clock.Now()
+
+	c.mutex.Lock()
+	for key, last := range c.seen {
+		if now.Sub(last) > callIDWindow {
+			delete(c.seen, key)
+		}
+	}
+	c.mutex.Unlock()
+
+	c.limiter.expire()
+}
+
+// callIDLimited returns whether a request received over UDP starts a new
+// Call-ID beyond the configured CallIDRate of its source, counting it in
+// CallIDThrottled if it does, and answering it with a 503 Service
+// Unavailable if RejectCallIDFlood is configured. An ACK is never answered.
+func (c *Conn) callIDLimited(req *Request) bool {
+	l := c.Listener
+	if l == nil || l.callIDs == nil || c.Address == nil ||
+		l.callIDs.allow(c.Address, req.Header.Get("Call-ID")) {
+		return false
+	}
+
+	atomic.AddUint64(&l.callIDThrottled, 1)
+	if l.config.RejectCallIDFlood && req.Method != MethodAck {
+		rejectUnavailable(c, req, time.Duration(float64(time.Second)/l.config.CallIDRate))
+	}
+
+	return true
+}
+
+// CallIDThrottled returns the number of requests received over UDP that
+// have been dropped or rejected because they started a new Call-ID beyond
+// the configured CallIDRate of their source.
+func (l *Listener) CallIDThrottled() uint64 {
+	return atomic.LoadUint64(&l.callIDThrottled)
+}
@@ -0,0 +1,62 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// callIDRequest returns a MESSAGE of testRequest with another Call-ID.
+func callIDRequest(callID, branch string) string {
+	return strings.Replace(testRequest(MethodMessage, branch),
+		"call1@127.0.0.1", callID, 1)
+}
+
+func TestCallIDFloodThrottled(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	l := listenTest(t, Config{
+		Clock:             clock,
+		CallIDRate:        1,
+		CallIDBurst:       2,
+		RejectCallIDFlood: true,
+	})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	// The burst of new Call-IDs is accepted, and so are further requests
+	// of an accepted Call-ID.
+	for _, msg := range []string{
+		callIDRequest("a@127.0.0.1", "z9hG4bKa1"),
+		callIDRequest("b@127.0.0.1", "z9hG4bKb1"),
+		callIDRequest("a@127.0.0.1", "z9hG4bKa2"),
+	} {
+		sendUDP(t, peer, l, msg)
+		_, conn := acceptRequest(t, l)
+		conn.Unlock()
+	}
+
+	// The next new Call-ID is rejected.
+	sendUDP(t, peer, l, callIDRequest("c@127.0.0.1", "z9hG4bKc1"))
+	data, _ := readUDP(t, peer)
+	resp, err := ReadResponse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse the response: %v", err)
+	}
+	if resp.StatusCode != StatusServiceUnavailable ||
+		resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("rejected with %d and Retry-After %q, expected a 503",
+			resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if throttled := l.CallIDThrottled(); throttled != 1 {
+		t.Errorf("%d requests throttled, expected 1", throttled)
+	}
+
+	// Once the rate allows it, the Call-ID is accepted.
+	clock.Advance(time.Second)
+	sendUDP(t, peer, l, callIDRequest("c@127.0.0.1", "z9hG4bKc2"))
+	req, _ := acceptRequest(t, l)
+	if callID := req.Header.Get("Call-ID"); callID != "c@127.0.0.1" {
+		t.Errorf("accepted %s, expected the Call-ID after the refill", callID)
+	}
+}
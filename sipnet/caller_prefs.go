@@ -0,0 +1,345 @@
+package sipnet
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContactPredicate is a feature set predicate of an Accept-Contact or
+// Reject-Contact header (RFC 3841), i.e. "*;audio;methods=\"INVITE\"".
+type ContactPredicate struct {
+	// Features are the feature tags of the predicate, by lower case name,
+	// with their unquoted values. A boolean feature has an empty value.
+	Features HeaderArgs
+
+	// Require discards contacts whose feature set doesn't match the
+	// predicate, rather than only scoring them lower.
+	Require bool
+
+	// Explicit only scores contacts which explicitly list the features of
+	// the predicate.
+	Explicit bool
+}
+
+// ParseContactPredicate parses the value of an Accept-Contact or
+// Reject-Contact header.
+func ParseContactPredicate(str string) (ContactPredicate, error) {
+	str = strings.TrimSpace(str)
+	if !strings.HasPrefix(str, "*") {
+		return ContactPredicate{}, ErrParseError
+	}
+
+	p := ContactPredicate{Features: make(HeaderArgs)}
+	for name, value := range ParsePairs(str[1:]) {
+		switch name = strings.ToLower(name); name {
+		case "require":
+			p.Require = true
+		case "explicit":
+			p.Explicit = true
+		default:
+			p.Features.Set(name, value)
+		}
+	}
+
+	return p, nil
+}
+
+// String returns the header value of the predicate.
+func (p ContactPredicate) String() string {
+	features := make(HeaderArgs, len(p.Features)+2)
+	for name, value := range p.Features {
+		features.Set(name, value)
+	}
+	if p.Require {
+		features.Set("require", "")
+	}
+	if p.Explicit {
+		features.Set("explicit", "")
+	}
+
+	return FeatureCapsString(features)
+}
+
+// contactPredicates parses the predicates of a header key, which may also be
+// sent in its compact form.
+func contactPredicates(h Header, key, compact string) ([]ContactPredicate, error) {
+	var predicates []ContactPredicate
+	for _, value := range append(h.Values(key), h.Values(compact)...) {
+		for _, item := range splitUserList(value) {
+			p, err := ParseContactPredicate(item)
+			if err != nil {
+				return nil, err
+			}
+			predicates = append(predicates, p)
+		}
+	}
+
+	return predicates, nil
+}
+
+// AcceptContacts returns the predicates of the Accept-Contact header
+// (compact form "a").
+func AcceptContacts(h Header) ([]ContactPredicate, error) {
+	return contactPredicates(h, "Accept-Contact", "a")
+}
+
+// RejectContacts returns the predicates of the Reject-Contact header
+// (compact form "j").
+func RejectContacts(h Header) ([]ContactPredicate, error) {
+	return contactPredicates(h, "Reject-Contact", "j")
+}
+
+// RequestDisposition is the Request-Disposition header (compact form "d")
+// of a request (RFC 3841). The zero value is the default disposition of
+// proxying, cancelling, forking, recursing in parallel without queuing.
+type RequestDisposition struct {
+	Redirect   bool
+	NoCancel   bool
+	NoFork     bool
+	NoRecurse  bool
+	Sequential bool
+	Queue      bool
+}
+
+// ParseRequestDisposition returns the Request-Disposition of a header.
+// Unknown directives are ignored.
+func ParseRequestDisposition(h Header) RequestDisposition {
+	var d RequestDisposition
+	for _, directive := range eventTokens(h, "Request-Disposition", "d") {
+		switch strings.ToLower(directive) {
+		case "redirect":
+			d.Redirect = true
+		case "proxy":
+			d.Redirect = false
+		case "no-cancel":
+			d.NoCancel = true
+		case "cancel":
+			d.NoCancel = false
+		case "no-fork":
+			d.NoFork = true
+		case "fork":
+			d.NoFork = false
+		case "no-recurse":
+			d.NoRecurse = true
+		case "recurse":
+			d.NoRecurse = false
+		case "sequential":
+			d.Sequential = true
+		case "parallel":
+			d.Sequential = false
+		case "queue":
+			d.Queue = true
+		case "no-queue":
+			d.Queue = false
+		}
+	}
+
+	return d
+}
+
+// baseFeatureTags are the feature tags of RFC 3840 without a "+" prefix.
+var baseFeatureTags = map[string]bool{
+	"audio": true, "automata": true, "class": true, "duplex": true,
+	"data": true, "control": true, "mobility": true, "description": true,
+	"events": true, "priority": true, "methods": true, "schemes": true,
+	"application": true, "video": true, "language": true, "type": true,
+	"isfocus": true, "actor": true, "text": true, "extensions": true,
+}
+
+// contactFeatures returns the feature tags of a contact's header
+// parameters, by lower case name.
+func contactFeatures(contact User) HeaderArgs {
+	features := make(HeaderArgs)
+	for name, value := range contact.Arguments {
+		name = strings.ToLower(name)
+		if baseFeatureTags[name] || strings.HasPrefix(name, "+") {
+			features.Set(name, value)
+		}
+	}
+	return features
+}
+
+// matchesFeature returns whether a contact's feature value matches the
+// value of a predicate's feature. A predicate value is a comma separated
+// list of alternatives, each of which may be negated with "!", and may be
+// a token (compared ignoring case), a <string> (compared exactly), or a
+// numeric comparison ("#=1", "#<=2", "#>=3" or the range "#1:3").
+func matchesFeature(predicate, value string) bool {
+	if predicate == "" || strings.EqualFold(predicate, "TRUE") {
+		return value == "" || strings.EqualFold(value, "TRUE")
+	}
+	if strings.EqualFold(predicate, "FALSE") {
+		return strings.EqualFold(value, "FALSE")
+	}
+
+	values := strings.Split(value, ",")
+	for _, alternative := range strings.Split(predicate, ",") {
+		alternative = strings.TrimSpace(alternative)
+		negated := strings.HasPrefix(alternative, "!")
+		alternative = strings.TrimPrefix(alternative, "!")
+
+		matched := false
+		for _, v := range values {
+			if matchesAlternative(alternative, strings.TrimSpace(v)) {
+				matched = true
+				break
+			}
+		}
+
+		if matched != negated {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAlternative(alternative, value string) bool {
+	switch {
+	case strings.HasPrefix(alternative, "<") && strings.HasSuffix(alternative, ">"):
+		return alternative == value
+	case strings.HasPrefix(alternative, "#"):
+		n, err := strconv.ParseFloat(strings.TrimPrefix(value, "#"), 64)
+		if err != nil {
+			return false
+		}
+		return matchesNumber(alternative[1:], n)
+	default:
+		return strings.EqualFold(alternative, value)
+	}
+}
+
+func matchesNumber(comparison string, n float64) bool {
+	parse := func(s string) (float64, bool) {
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+
+	switch {
+	case strings.HasPrefix(comparison, "<="):
+		limit, ok := parse(comparison[2:])
+		return ok && n <= limit
+	case strings.HasPrefix(comparison, ">="):
+		limit, ok := parse(comparison[2:])
+		return ok && n >= limit
+	case strings.HasPrefix(comparison, "="):
+		limit, ok := parse(comparison[1:])
+		return ok && n == limit
+	}
+
+	if i := strings.Index(comparison, ":"); i >= 0 {
+		low, lowOK := parse(comparison[:i])
+		high, highOK := parse(comparison[i+1:])
+		return lowOK && highOK && n >= low && n <= high
+	}
+
+	return false
+}
+
+// matchPredicate returns whether the features listed by a contact match a
+// predicate, ignoring features of the predicate the contact doesn't list,
+// and the number of features of the predicate the contact lists.
+func matchPredicate(p ContactPredicate, features HeaderArgs) (bool, int) {
+	listed := 0
+	for name, value := range p.Features {
+		contactValue, found := features[name]
+		if !found {
+			continue
+		}
+
+		listed++
+		if !matchesFeature(value, contactValue) {
+			return false, listed
+		}
+	}
+
+	return true, listed
+}
+
+// ScoreContact returns the caller preference score of a contact between 0
+// and 1 for the given Accept-Contact and Reject-Contact predicates, and
+// whether the contact is acceptable (RFC 3841 section 7.2).
+//
+// A contact which lists no feature tags is immune to the predicates, and
+// is acceptable with a score of 0. Otherwise, a contact is rejected if it
+// lists and matches all of the features of a Reject-Contact predicate, or
+// if it doesn't match an Accept-Contact predicate with Require. Its score
+// is the mean over the Accept-Contact predicates of the fraction of their
+// features it lists, or 0 for a predicate it doesn't match, or doesn't list
+// all of the features of if the predicate is Explicit.
+func ScoreContact(contact User, accept, reject []ContactPredicate) (float64, bool) {
+	features := contactFeatures(contact)
+	if len(features) == 0 {
+		return 0, true
+	}
+
+	for _, p := range reject {
+		matched, listed := matchPredicate(p, features)
+		if matched && listed == len(p.Features) {
+			return 0, false
+		}
+	}
+
+	if len(accept) == 0 {
+		return 0, true
+	}
+
+	var total float64
+	for _, p := range accept {
+		matched, listed := matchPredicate(p, features)
+		if !matched && p.Require {
+			return 0, false
+		}
+
+		if !matched || len(p.Features) == 0 ||
+			(p.Explicit && listed < len(p.Features)) {
+			continue
+		}
+		total += float64(listed) / float64(len(p.Features))
+	}
+
+	return total / float64(len(accept)), true
+}
+
+// SelectContacts returns the acceptable contacts for the caller
+// preferences of a request, such as the registered contacts of its target,
+// ordered by their score and then by their q-value.
+func SelectContacts(req *Request, contacts []User) ([]User, error) {
+	accept, err := AcceptContacts(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	reject, err := RejectContacts(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		contact User
+		score   float64
+	}
+
+	// Contacts with the same score are kept in order of their q-value.
+	contacts = append([]User(nil), contacts...)
+	sortByQ(contacts)
+
+	var selected []scored
+	for _, contact := range contacts {
+		score, ok := ScoreContact(contact, accept, reject)
+		if ok {
+			selected = append(selected, scored{contact, score})
+		}
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].score > selected[j].score
+	})
+
+	sorted := make([]User, len(selected))
+	for i, s := range selected {
+		sorted[i] = s.contact
+	}
+	return sorted, nil
+}
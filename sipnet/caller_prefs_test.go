@@ -0,0 +1,94 @@
+package sipnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseContactPredicate(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKprefs",
+		`Accept-Contact: *;audio;methods="INVITE,BYE";require;explicit`,
+		`a: *;video`))
+
+	accept, err := AcceptContacts(req.Header)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	expected := []ContactPredicate{
+		{
+			Features: HeaderArgs{"audio": "", "methods": "INVITE,BYE"},
+			Require:  true,
+			Explicit: true,
+		},
+		{Features: HeaderArgs{"video": ""}},
+	}
+	if !reflect.DeepEqual(accept, expected) {
+		t.Errorf("parsed %+v, expected %+v", accept, expected)
+	}
+
+	if _, err := ParseContactPredicate("audio;video"); err != ErrParseError {
+		t.Errorf("parsing a predicate without \"*\" returned %v, expected "+
+			"ErrParseError", err)
+	}
+}
+
+func TestSelectContacts(t *testing.T) {
+	contacts, err := ParseUserList(`<sip:a@127.0.0.1>;audio;video;q=0.2, ` +
+		`<sip:b@127.0.0.1>;audio;video="FALSE";q=0.9, ` +
+		`<sip:c@127.0.0.1>;q=0.5, ` +
+		`<sip:d@127.0.0.1>;audio;video;actor="msg-taker";q=1.0`)
+	if err != nil {
+		t.Fatalf("failed to parse contacts: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		extra    []string
+		expected []string
+	}{
+		// Contacts are ordered by their q-value without preferences.
+		{"none", nil, []string{"d", "b", "c", "a"}},
+		// b doesn't support video, and c lists no features, so is immune.
+		{"require", []string{"Accept-Contact: *;video;require"},
+			[]string{"d", "a", "c"}},
+		{"reject", []string{"Accept-Contact: *;video;require",
+			`Reject-Contact: *;actor="msg-taker"`}, []string{"a", "c"}},
+		// a and b list half of the features, so score higher than c,
+		// unless the predicate is explicit.
+		{"implicit", []string{`Accept-Contact: *;audio;actor="msg-taker"`},
+			[]string{"d", "b", "a", "c"}},
+		{"explicit", []string{`Accept-Contact: *;audio;actor="msg-taker";explicit`},
+			[]string{"d", "b", "c", "a"}},
+	}
+
+	for _, test := range tests {
+		req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKselect",
+			test.extra...))
+		selected, err := SelectContacts(req, contacts)
+		if err != nil {
+			t.Errorf("%s: failed to select: %v", test.name, err)
+			continue
+		}
+
+		var users []string
+		for _, contact := range selected {
+			users = append(users, contact.URI.Username)
+		}
+		if !reflect.DeepEqual(users, test.expected) {
+			t.Errorf("%s: selected %q, expected %q", test.name, users,
+				test.expected)
+		}
+	}
+}
+
+func TestParseRequestDisposition(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKdisposition",
+		"Request-Disposition: redirect, no-fork", "d: sequential, flush"))
+
+	expected := RequestDisposition{Redirect: true, NoFork: true,
+		Sequential: true}
+	if d := ParseRequestDisposition(req.Header); d != expected {
+		t.Errorf("parsed %+v, expected %+v", d, expected)
+	}
+}
@@ -0,0 +1,219 @@
+package sipnet
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRequestTerminated is returned by Response.WriteTo for a final response
+// to an INVITE which has been cancelled and answered with a 487 Request
+// Terminated by PendingInvites.HandleCancel.
+var ErrRequestTerminated = errors.New("sip: request terminated")
+
+// maxPendingInvite is the duration an INVITE is tracked by PendingInvites
+// without a final response, after which proxies give up on it (timer C of
+// RFC 3261).
+const maxPendingInvite = 3 * time.Minute
+
+type pendingInvite struct {
+	req       *Request
+	conn      *Conn
+	received  time.Time
+	cancelled chan struct{}
+}
+
+// PendingInvites tracks received INVITEs which have not been answered with a
+// final response yet, so a CANCEL for them terminates them as a UAS must
+// (RFC 3261 section 9.2). It is safe to use from multiple goroutines.
+type PendingInvites struct {
+	mutex   sync.Mutex
+	invites map[string]*pendingInvite
+}
+
+// NewPendingInvites returns a new empty set of pending INVITEs.
+func NewPendingInvites() *PendingInvites {
+	return &PendingInvites{
+		invites: make(map[string]*pendingInvite),
+	}
+}
+
+// Add tracks a received INVITE. The returned channel is closed if the
+// INVITE is cancelled, after it has been answered with a 487, at which point
+// the UAS should stop ringing.
+func (p *PendingInvites) Add(req *Request, conn *Conn) <-chan struct{} {
+	invite := &pendingInvite{
+		req:       req,
+		conn:      conn,
+		received:  conn.clock().Now(),
+		cancelled: make(chan struct{}),
+	}
+
+	key := TransactionKey(req)
+	if key == "" {
+		return invite.cancelled
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if existing, found := p.invites[key]; found {
+		return existing.cancelled
+	}
+
+	p.invites[key] = invite
+	return invite.cancelled
+}
+
+// Cancelled returns the channel which is closed when a tracked INVITE is
+// cancelled, or nil if the INVITE is not tracked.
+func (p *PendingInvites) Cancelled(req *Request) <-chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if invite, found := p.invites[TransactionKey(req)]; found {
+		return invite.cancelled
+	}
+	return nil
+}
+
+// CancelKey returns the TransactionKey of the INVITE server transaction a
+// CANCEL matches (RFC 3261 section 9.2), which is keyed by the branch and
+// sent-by of the CANCEL's top Via regardless of its method. Lower Vias are
+// ignored, so the CANCEL still matches if a proxy has altered them. If the
+// branch is not an RFC 3261 branch, the Call-ID, From tag and CSeq number
+// are matched instead. An empty string is returned if the request is not a
+// CANCEL, or has no valid Via or CSeq.
+func CancelKey(cancel *Request) string {
+	if cancel.Method != MethodCancel {
+		return ""
+	}
+
+	return transactionKey(cancel.Header, MethodInvite)
+}
+
+// Match returns the pending INVITE a CANCEL matches, or nil if there is
+// none.
+func (p *PendingInvites) Match(cancel *Request) *Request {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if invite := p.match(CancelKey(cancel), cancel); invite != nil {
+		return invite.req
+	}
+	return nil
+}
+
+// match returns the pending INVITE with the key of a CANCEL. Without an RFC
+// 3261 branch, the Request-URI of the INVITE must also match, as the legacy
+// key doesn't identify the transaction uniquely. p.mutex must be held.
+func (p *PendingInvites) match(key string, cancel *Request) *pendingInvite {
+	if key == "" {
+		return nil
+	}
+
+	invite, found := p.invites[key]
+	if !found {
+		return nil
+	}
+
+	if !strings.HasPrefix(key, strings.ToLower(BranchMagicCookie)) &&
+		invite.req.Server != cancel.Server {
+		return nil
+	}
+
+	return invite
+}
+
+// HandleCancel responds to a received CANCEL, and returns whether it
+// matched a pending INVITE. The CANCEL is answered with a 200 OK if it
+// matches an INVITE, which is then answered with a 487 Request Terminated
+// unless it already has a final response. Otherwise, it is answered with a
+// 481 Call/Transaction Does Not Exist.
+//
+// The 200 and 487 have the To tag of the provisional responses already sent
+// for the INVITE, so they belong to the same dialog. Once the 487 is sent, any other
+// final response to the INVITE is refused with ErrRequestTerminated.
+func (p *PendingInvites) HandleCancel(cancel *Request, conn *Conn) bool {
+	key := CancelKey(cancel)
+
+	p.mutex.Lock()
+	invite := p.match(key, cancel)
+	if invite != nil {
+		delete(p.invites, key)
+	}
+	p.mutex.Unlock()
+
+	if invite == nil {
+		resp := NewResponse()
+		resp.StatusCode = StatusCallTransactionDoesNotExist
+		resp.Header.Set("To", cancel.Header.Get("To"))
+		resp.Header.Set("From", cancel.Header.Get("From"))
+		resp.WriteTo(conn, cancel)
+		return false
+	}
+
+	terminated, tag := invite.conn.terminate(key)
+
+	resp := NewResponse()
+	resp.StatusCode = StatusOK
+	resp.Header.Set("To", cancel.Header.Get("To"))
+	if tag != "" {
+		resp.Header.Set("To", withTag(cancel.Header.Get("To"), tag))
+	}
+	resp.Header.Set("From", cancel.Header.Get("From"))
+	resp.WriteTo(conn, cancel)
+
+	if terminated {
+		resp := NewResponse()
+		resp.StatusCode = StatusRequestTerminated
+		resp.Header.Set("To", withTag(invite.req.Header.Get("To"), tag))
+		resp.Header.Set("From", invite.req.Header.Get("From"))
+		resp.WriteTo(invite.conn, invite.req)
+		close(invite.cancelled)
+	}
+
+	return true
+}
+
+// expire stops tracking INVITEs which have been answered, or have been
+// pending for too long.
+func (p *PendingInvites) expire(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for key, invite := range p.invites {
+		if invite.conn.Closed || invite.conn.hasFinalResponse(key) ||
+			now.Sub(invite.received) > maxPendingInvite {
+			delete(p.invites, key)
+		}
+	}
+}
+
+// withToTag returns a To header value with a tag, adding a new tag if it
+// doesn't have one.
+func withToTag(to string) string {
+	return withTag(to, "")
+}
+
+// withTag returns a To header value with a tag, adding the given tag, or a
+// new tag if it is empty, if it doesn't have one.
+func withTag(to, tag string) string {
+	user, err := ParseUser(to)
+	if err != nil || user.Arguments.Get("tag") != "" {
+		return to
+	}
+
+	if tag == "" {
+		tag = NewTag()
+	}
+	user.Arguments.Set("tag", tag)
+	return user.String()
+}
+
+// hasFinalResponse returns whether a final response has been sent for the
+// server transaction with the given key.
+func (c *Conn) hasFinalResponse(key string) bool {
+	c.BranchMutex.Lock()
+	cached, found := c.responseCache[key]
+	c.BranchMutex.Unlock()
+
+	return found && cached.statusCode >= 200
+}
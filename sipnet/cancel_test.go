@@ -0,0 +1,145 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelTerminatesInvite(t *testing.T) {
+	l := listenTest(t, Config{})
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	late := make(chan error, 1)
+	var s *Server
+	s = NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+		if req.Method != MethodInvite {
+			return
+		}
+
+		ringing := NewResponse()
+		ringing.StatusCode = StatusRinging
+		ringing.Header.Set("From", req.Header.Get("From"))
+		ringing.Header.Set("To", req.Header.Get("To")+";tag=b1")
+		ringing.WriteTo(conn, req)
+
+		// The UAS stops ringing once the INVITE is cancelled, after which it
+		// can no longer answer it.
+		select {
+		case <-s.Invites.Cancelled(req):
+		case <-time.After(testTimeout):
+		}
+		ok := NewResponse()
+		ok.StatusCode = StatusOK
+		ok.Header.Set("From", req.Header.Get("From"))
+		ok.Header.Set("To", req.Header.Get("To")+";tag=b1")
+		late <- ok.WriteTo(conn, req)
+	}, l)
+	go s.Serve()
+	defer s.Close()
+
+	invite := testRequest(MethodInvite, "z9hG4bKcancel",
+		"Contact: <sip:alice@127.0.0.1:5070>")
+	sendUDP(t, peer, l, invite)
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 180 Ringing" {
+		t.Fatalf("INVITE answered with %q, expected a 180", startLine(data))
+	}
+
+	sendUDP(t, peer, l, testRequest(MethodCancel, "z9hG4bKcancel"))
+
+	responses := make(map[string]*Response)
+	for i := 0; i < 2; i++ {
+		data, _ := readUDP(t, peer)
+		resp, err := ReadResponse(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		cseq, _ := ParseCSeq(resp.Header.Get("CSeq"))
+		responses[cseq.Method] = resp
+	}
+
+	if resp := responses[MethodCancel]; resp == nil || resp.StatusCode != StatusOK {
+		t.Errorf("CANCEL answered with %v, expected a 200", resp)
+	}
+	resp := responses[MethodInvite]
+	if resp == nil || resp.StatusCode != StatusRequestTerminated {
+		t.Fatalf("INVITE answered with %v, expected a 487", resp)
+	}
+	if _, to, _ := ParseUserHeader(resp.Header); to.Arguments.Get("tag") != "b1" {
+		t.Errorf("487 has To tag %q, expected the tag of the 180",
+			to.Arguments.Get("tag"))
+	}
+
+	select {
+	case err := <-late:
+		if err != ErrRequestTerminated {
+			t.Errorf("late 200 returned %v, expected ErrRequestTerminated", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("handler wasn't told the INVITE was cancelled")
+	}
+}
+
+func TestCancelWithoutInvite(t *testing.T) {
+	l := listenTest(t, Config{})
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	s := NewServer(func(req *Request, conn *Conn, dialog *Dialog) {}, l)
+	go s.Serve()
+	defer s.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodCancel, "z9hG4bKunknown"))
+	if data, _ := readUDP(t, peer); startLine(data) !=
+		"SIP/2.0 481 Call/Transaction Does Not Exist" {
+		t.Errorf("CANCEL answered with %q, expected a 481", startLine(data))
+	}
+}
+
+func TestCancelMatchedByTopVia(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+	defer remote.Close()
+
+	p := NewPendingInvites()
+	invite := parseRequest(t, testRequest(MethodInvite, "z9hG4bKtop",
+		"Via: SIP/2.0/UDP 192.0.2.9:5060;branch=z9hG4bKlower"))
+	p.Add(invite, conn)
+	legacy := parseRequest(t, strings.Replace(testRequest(MethodInvite,
+		"legacy"), "call1@", "call2@", 1))
+	p.Add(legacy, conn)
+
+	tests := []struct {
+		name    string
+		cancel  string
+		matched *Request
+	}{
+		{"altered lower Via", testRequest(MethodCancel, "z9hG4bKtop",
+			"Via: SIP/2.0/TCP 198.51.100.1;branch=z9hG4bKaltered"), invite},
+		{"another branch", testRequest(MethodCancel, "z9hG4bKother"), nil},
+		{"another sent-by", strings.Replace(testRequest(MethodCancel,
+			"z9hG4bKtop"), "127.0.0.1:5070", "127.0.0.1:5080", 1), nil},
+		{"not a CANCEL", testRequest(MethodBye, "z9hG4bKtop"), nil},
+		{"legacy branch", strings.Replace(testRequest(MethodCancel,
+			"legacy"), "call1@", "call2@", 1), legacy},
+		{"legacy branch for another Request-URI", strings.Replace(
+			strings.Replace(testRequest(MethodCancel, "legacy"),
+				"call1@", "call2@", 1),
+			"CANCEL sip:bob@", "CANCEL sip:carol@", 1), nil},
+	}
+
+	for _, test := range tests {
+		if matched := p.Match(parseRequest(t, test.cancel)); matched !=
+			test.matched {
+			t.Errorf("%s: matched %v, expected %v", test.name, matched,
+				test.matched)
+		}
+	}
+
+	if key := CancelKey(parseRequest(t, testRequest(MethodCancel,
+		"z9hG4bKtop"))); key != TransactionKey(invite) {
+		t.Errorf("CANCEL has key %q, expected the INVITE's %q", key,
+			TransactionKey(invite))
+	}
+}
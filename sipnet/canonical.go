@@ -0,0 +1,117 @@
+package sipnet
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// compactForms maps the compact forms of header names (RFC 3261 section
+// 7.3.3, and of extensions) to their full names.
+var compactForms = map[string]string{
+	"a": "Accept-Contact",
+	"b": "Referred-By",
+	"c": "Content-Type",
+	"d": "Request-Disposition",
+	"e": "Content-Encoding",
+	"f": "From",
+	"i": "Call-ID",
+	"j": "Reject-Contact",
+	"k": "Supported",
+	"l": "Content-Length",
+	"m": "Contact",
+	"o": "Event",
+	"r": "Refer-To",
+	"s": "Subject",
+	"t": "To",
+	"u": "Allow-Events",
+	"v": "Via",
+	"x": "Session-Expires",
+	"y": "Identity",
+}
+
+// Canonical returns a deterministic byte form of the headers with the given
+// keys, for signing them or computing an HMAC over them, which is the same
+// however the message was formatted. Each header present is written as a
+// "name:value" line ending in CRLF, in order of their lower case names,
+// with the values of its compact form merged into it. The values of a header
+// are joined with commas, and outside of quoted strings, runs of whitespace
+// are replaced with a single space, and whitespace around ",", ";", "=",
+// "<" and ">" is removed.
+func (h Header) Canonical(keys ...string) []byte {
+	names := make(map[string]bool)
+	for _, key := range keys {
+		if full, found := compactForms[strings.ToLower(key)]; found {
+			key = full
+		}
+		names[strings.ToLower(key)] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	compact := make(map[string]string)
+	for short, full := range compactForms {
+		compact[strings.ToLower(full)] = short
+	}
+
+	var buf bytes.Buffer
+	for _, name := range sorted {
+		values := h.Values(name)
+		if short, found := compact[name]; found {
+			values = append(values[:len(values):len(values)], h.Values(short)...)
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		normalized := make([]string, len(values))
+		for i, value := range values {
+			normalized[i] = canonicalValue(value)
+		}
+
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(strings.Join(normalized, ","))
+		buf.WriteString("\r\n")
+	}
+
+	return buf.Bytes()
+}
+
+// canonicalValue normalizes the whitespace of a header value for Canonical.
+func canonicalValue(value string) string {
+	isSeparator := func(r byte) bool {
+		return strings.IndexByte(",;=<>", r) >= 0
+	}
+
+	var out []byte
+	var quote, escape, space bool
+	for i := 0; i < len(value); i++ {
+		r := value[i]
+		switch {
+		case escape:
+			escape = false
+		case quote && r == '\\':
+			escape = true
+		case r == '"':
+			quote = !quote
+		case !quote && (r == ' ' || r == '\t' || r == '\r' || r == '\n'):
+			space = true
+			continue
+		}
+
+		if space && len(out) > 0 && !isSeparator(out[len(out)-1]) &&
+			!(isSeparator(r) && !quote) {
+			out = append(out, ' ')
+		}
+		space = false
+		out = append(out, r)
+	}
+
+	return string(out)
+}
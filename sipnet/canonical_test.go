@@ -0,0 +1,62 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalStableAcrossRoundTrips(t *testing.T) {
+	const keys = "From,To,Call-ID,Subject,Date"
+	const expected = "call-id:call1@127.0.0.1\r\n" +
+		"from:\"Alice  Smith\"<sip:alice@127.0.0.1>;tag=a1\r\n" +
+		"subject:Lunch at noon\r\n" +
+		"to:<sip:bob@127.0.0.1>\r\n"
+
+	// The same headers formatted differently, with compact forms.
+	messy := "MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\n" +
+		"v: SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKcanonical\r\n" +
+		"f:  \"Alice  Smith\"   <sip:alice@127.0.0.1> ; tag = a1\r\n" +
+		"t:\t<sip:bob@127.0.0.1>\r\n" +
+		"i: call1@127.0.0.1\r\n" +
+		"CSeq: 1 MESSAGE\r\n" +
+		"s: Lunch   at\tnoon\r\n" +
+		"Content-Length: 0\r\n\r\n"
+	tidy := "MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKother\r\n" +
+		"From: \"Alice  Smith\" <sip:alice@127.0.0.1>;tag=a1\r\n" +
+		"To: <sip:bob@127.0.0.1>\r\n" +
+		"Call-ID: call1@127.0.0.1\r\n" +
+		"CSeq: 2 MESSAGE\r\n" +
+		"Subject: Lunch at noon\r\n" +
+		"Content-Length: 0\r\n\r\n"
+
+	for _, msg := range []string{messy, tidy} {
+		req := parseRequest(t, msg)
+		for i := 0; i < 3; i++ {
+			canonical := string(req.Header.Canonical(strings.Split(keys, ",")...))
+			if canonical != expected {
+				t.Fatalf("cycle %d: canonical form is %q, expected %q", i,
+					canonical, expected)
+			}
+			req = parseRequest(t, writeRequest(t, req))
+		}
+	}
+}
+
+func TestCanonicalKeys(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKkeys",
+		"Subject: hi"))
+
+	// Keys are matched regardless of case and compact forms, and only once.
+	canonical := string(req.Header.Canonical("s", "SUBJECT", "i"))
+	if expected := "call-id:call1@127.0.0.1\r\nsubject:hi\r\n"; canonical != expected {
+		t.Errorf("canonical form is %q, expected %q", canonical, expected)
+	}
+
+	// A header with several values is merged into one line.
+	req.Header.Add("Subject", "there")
+	if canonical := string(req.Header.Canonical("Subject")); canonical !=
+		"subject:hi,there\r\n" {
+		t.Errorf("canonical form is %q", canonical)
+	}
+}
@@ -0,0 +1,305 @@
+package sipnet
+
+import "strings"
+
+// Challenge represents a Digest challenge (RFC 2617) sent in the
+// WWW-Authenticate of a 401 by a UAS, or the Proxy-Authenticate of a 407 by
+// a proxy.
+type Challenge struct {
+	// Scheme is the authentication scheme of the challenge, which is
+	// "Digest" if empty.
+	Scheme string
+
+	Realm  string
+	Nonce  string
+	Opaque string
+
+	// Algorithm is the digest algorithm of the challenge, i.e. "SHA-256"
+	// (RFC 8760), which is MD5 if empty.
+	Algorithm string
+
+	// Qop are the qop options of the challenge, i.e. "auth". String offers
+	// "auth" if there are none.
+	Qop []string
+
+	// Stale indicates the credentials of the request were valid, but its
+	// nonce has expired, so the UAC should retry with the new nonce rather
+	// than prompting for new credentials.
+	Stale bool
+}
+
+// String returns the challenge as the value of a WWW-Authenticate or
+// Proxy-Authenticate header, requesting the MD5 algorithm and the auth qop
+// unless another Algorithm or Qop is set.
+func (c Challenge) String() string {
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "Digest"
+	}
+
+	qop := "auth"
+	if len(c.Qop) > 0 {
+		qop = strings.Join(c.Qop, ",")
+	}
+
+	algorithm := c.Algorithm
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+
+	result := scheme + " realm=" + QuoteString(c.Realm) +
+		", nonce=" + QuoteString(c.Nonce) +
+		", opaque=" + QuoteString(c.Opaque) +
+		", qop=" + QuoteString(qop) + ", algorithm=" + algorithm
+	if c.Stale {
+		result += ", stale=TRUE"
+	}
+
+	return result
+}
+
+// ParseChallenges parses the value of a WWW-Authenticate or
+// Proxy-Authenticate header, which may hold several comma separated
+// challenges, each starting with its scheme.
+func ParseChallenges(str string) ([]Challenge, error) {
+	var challenges []Challenge
+	var params []string
+	var scheme string
+
+	finish := func() {
+		if scheme != "" {
+			challenges = append(challenges, newChallenge(scheme,
+				ParsePairs(strings.Join(params, ", "))))
+		}
+	}
+
+	for _, item := range ParseList(str) {
+		if item == "" {
+			continue
+		}
+
+		// A new challenge starts with its scheme, followed by its first
+		// parameter.
+		space := strings.IndexAny(item, " \t")
+		equals := strings.Index(item, "=")
+		if space >= 0 && (equals < 0 || space < equals) {
+			finish()
+			scheme = item[:space]
+			params = []string{strings.TrimSpace(item[space+1:])}
+			continue
+		} else if equals < 0 && scheme == "" {
+			// A challenge without parameters.
+			scheme = item
+			continue
+		}
+
+		if scheme == "" {
+			return nil, ErrParseError
+		}
+		params = append(params, item)
+	}
+	finish()
+
+	if len(challenges) == 0 {
+		return nil, ErrParseError
+	}
+
+	return challenges, nil
+}
+
+// newChallenge returns the challenge of a scheme with the given parameters.
+func newChallenge(scheme string, args HeaderArgs) Challenge {
+	lower := make(HeaderArgs)
+	for key, value := range args {
+		lower.Set(strings.ToLower(key), value)
+	}
+
+	var qop []string
+	for _, option := range strings.Split(lower.Get("qop"), ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			qop = append(qop, option)
+		}
+	}
+
+	return Challenge{
+		Scheme:    scheme,
+		Realm:     lower.Get("realm"),
+		Nonce:     lower.Get("nonce"),
+		Opaque:    lower.Get("opaque"),
+		Algorithm: lower.Get("algorithm"),
+		Qop:       qop,
+		Stale:     strings.EqualFold(lower.Get("stale"), "true"),
+	}
+}
+
+// Challenges returns the challenges of a 401 from its WWW-Authenticate
+// headers, or of a 407 from its Proxy-Authenticate headers, in the order
+// they were sent. Challenges which fail to be parsed are skipped.
+func (r *Response) Challenges() []Challenge {
+	key := "WWW-Authenticate"
+	if r.StatusCode == StatusProxyAuthenticationRequired {
+		key = "Proxy-Authenticate"
+	}
+
+	var challenges []Challenge
+	for _, value := range r.Header.Values(key) {
+		parsed, err := ParseChallenges(value)
+		if err == nil {
+			challenges = append(challenges, parsed...)
+		}
+	}
+
+	return challenges
+}
+
+// digestStrength ranks the strength of the Digest algorithms.
+var digestStrength = map[string]int{
+	"MD5":         1,
+	"SHA-256":     2,
+	"SHA-512-256": 3,
+}
+
+// SelectChallenge returns the Digest challenge with the strongest of the
+// given algorithms (i.e. "SHA-256" and "MD5") from a list of challenges,
+// such as those of Response.Challenges, and whether there was one. A
+// challenge without an algorithm is an MD5 challenge. The algorithms are
+// ranked MD5, SHA-256 and SHA-512-256 from weakest to strongest, and others
+// are never selected. Of challenges with the same algorithm, the first one
+// is selected.
+func SelectChallenge(challenges []Challenge, algorithms []string) (Challenge, bool) {
+	var selected Challenge
+	strongest := 0
+	for _, ch := range challenges {
+		if !strings.EqualFold(ch.Scheme, "Digest") {
+			continue
+		}
+
+		algorithm := strings.ToUpper(ch.Algorithm)
+		if algorithm == "" {
+			algorithm = "MD5"
+		}
+
+		supported := false
+		for _, candidate := range algorithms {
+			if strings.EqualFold(candidate, algorithm) {
+				supported = true
+			}
+		}
+
+		strength := digestStrength[algorithm]
+		if supported && strength > strongest {
+			selected = ch
+			strongest = strength
+		}
+	}
+
+	return selected, strongest > 0
+}
+
+// Unauthorized responds to a Conn with a StatusUnauthorized carrying the
+// challenge in WWW-Authenticate, as a UAS or registrar does.
+func (r *Response) Unauthorized(conn *Conn, req *Request, challenge Challenge) {
+	r.StatusCode = StatusUnauthorized
+	r.Header.Add("WWW-Authenticate", challenge.String())
+	r.writeChallenge(conn, req)
+}
+
+// ProxyAuthenticationRequired responds to a Conn with a
+// StatusProxyAuthenticationRequired carrying the challenge in
+// Proxy-Authenticate, as a proxy does.
+func (r *Response) ProxyAuthenticationRequired(conn *Conn, req *Request,
+	challenge Challenge) {
+	r.StatusCode = StatusProxyAuthenticationRequired
+	r.Header.Add("Proxy-Authenticate", challenge.String())
+	r.writeChallenge(conn, req)
+}
+
+func (r *Response) writeChallenge(conn *Conn, req *Request) {
+	if r.Header.Get("From") == "" {
+		r.Header.Set("From", req.Header.Get("From"))
+	}
+	if r.Header.Get("To") == "" {
+		r.Header.Set("To", req.Header.Get("To"))
+	}
+
+	r.WriteTo(conn, req)
+}
+
+// DigestCredentials represents the Digest credentials of an Authorization
+// or Proxy-Authorization header, answering a Challenge.
+type DigestCredentials struct {
+	Username string
+	Realm    string
+	Nonce    string
+	URI      string
+	Response string
+	Cnonce   string
+	Qop      string
+	NC       string
+	Opaque   string
+}
+
+// ParseDigestCredentials parses the value of an Authorization or
+// Proxy-Authorization header with the Digest scheme.
+func ParseDigestCredentials(str string) (DigestCredentials, error) {
+	if len(str) < 7 || strings.ToLower(str[:7]) != "digest " {
+		return DigestCredentials{}, ErrUnsupportedChallenge
+	}
+
+	args := ParsePairs(str[7:])
+	return DigestCredentials{
+		Username: args.Get("username"),
+		Realm:    args.Get("realm"),
+		Nonce:    args.Get("nonce"),
+		URI:      args.Get("uri"),
+		Response: args.Get("response"),
+		Cnonce:   args.Get("cnonce"),
+		Qop:      args.Get("qop"),
+		NC:       args.Get("nc"),
+		Opaque:   args.Get("opaque"),
+	}, nil
+}
+
+// Credentials returns the Digest credentials of a request for a realm. A
+// proxy should get its credentials from Proxy-Authorization, and a UAS from
+// Authorization, as a request may carry both, as well as the credentials
+// of other proxies for other realms (RFC 3261 section 22.3). It returns
+// false if the request has no credentials for the realm.
+func (r *Request) Credentials(realm string, proxy bool) (DigestCredentials, bool) {
+	key := "Authorization"
+	if proxy {
+		key = "Proxy-Authorization"
+	}
+
+	for _, value := range r.Header.Values(key) {
+		creds, err := ParseDigestCredentials(value)
+		if err == nil && creds.Realm == realm {
+			return creds, true
+		}
+	}
+
+	return DigestCredentials{}, false
+}
+
+// Verify returns whether the credentials answer a challenge with the given
+// nonce correctly for a request with the given method, going by the
+// user's password. Checking that the nonce is fresh and not replayed, such
+// as with a NonceStore, is left to the caller.
+func (c DigestCredentials) Verify(method, nonce, password string) bool {
+	if c.Nonce != nonce {
+		return false
+	}
+
+	ha1 := md5Hex(c.Username + ":" + c.Realm + ":" + password)
+	ha2 := md5Hex(method + ":" + c.URI)
+
+	var expected string
+	if c.Qop != "" {
+		expected = md5Hex(ha1 + ":" + c.Nonce + ":" + c.NC + ":" + c.Cnonce +
+			":" + c.Qop + ":" + ha2)
+	} else {
+		expected = md5Hex(ha1 + ":" + c.Nonce + ":" + ha2)
+	}
+
+	return strings.EqualFold(expected, c.Response)
+}
@@ -0,0 +1,203 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+)
+
+// challengeResponse returns the response written by a challenge builder to
+// a request over a pipe.
+func challengeResponse(t *testing.T, write func(conn *Conn)) *Response {
+	t.Helper()
+
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	done := goWrite(func() error {
+		write(conn)
+		return nil
+	})
+	resp, err := ReadResponse(strings.NewReader(readPipe(t, remote)))
+	<-done
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return resp
+}
+
+func TestRequestWithProxyAndUASCredentials(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKboth"))
+
+	proxy := challengeResponse(t, func(conn *Conn) {
+		NewResponse().ProxyAuthenticationRequired(conn, req, Challenge{
+			Realm: "proxy.example.com", Nonce: "pn", Opaque: "po"})
+	})
+	if proxy.StatusCode != StatusProxyAuthenticationRequired ||
+		proxy.Header.Get("Proxy-Authenticate") == "" {
+		t.Fatalf("proxy challenged with %d and Proxy-Authenticate %q",
+			proxy.StatusCode, proxy.Header.Get("Proxy-Authenticate"))
+	}
+
+	uas := challengeResponse(t, func(conn *Conn) {
+		NewResponse().Unauthorized(conn, req, Challenge{
+			Realm: "uas.example.com", Nonce: "un", Opaque: "uo"})
+	})
+	if uas.StatusCode != StatusUnauthorized ||
+		uas.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("UAS challenged with %d and WWW-Authenticate %q",
+			uas.StatusCode, uas.Header.Get("WWW-Authenticate"))
+	}
+
+	// The request answers both challenges, with different passwords.
+	if err := Authorize(req, proxy, Credentials{"alice", "proxy secret"}); err != nil {
+		t.Fatalf("failed to answer the proxy: %v", err)
+	}
+	if err := Authorize(req, uas, Credentials{"alice", "uas secret"}); err != nil {
+		t.Fatalf("failed to answer the UAS: %v", err)
+	}
+
+	proxyCreds, found := req.Credentials("proxy.example.com", true)
+	if !found || !proxyCreds.Verify(MethodInvite, "pn", "proxy secret") {
+		t.Errorf("proxy found credentials %+v, %v, expected valid ones",
+			proxyCreds, found)
+	}
+	uasCreds, found := req.Credentials("uas.example.com", false)
+	if !found || !uasCreds.Verify(MethodInvite, "un", "uas secret") {
+		t.Errorf("UAS found credentials %+v, %v, expected valid ones",
+			uasCreds, found)
+	}
+
+	// Each only looks at its own header.
+	if _, found := req.Credentials("proxy.example.com", false); found {
+		t.Error("found the proxy's credentials in Authorization")
+	}
+	if _, found := req.Credentials("uas.example.com", true); found {
+		t.Error("found the UAS's credentials in Proxy-Authorization")
+	}
+	if uasCreds.Verify(MethodInvite, "un", "proxy secret") {
+		t.Error("verified the UAS's credentials with the proxy's password")
+	}
+}
+
+func TestProxyCredentialsForSeveralRealms(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKrealms"))
+	challenge := func(realm, nonce string) *Response {
+		resp := NewResponse()
+		resp.StatusCode = StatusProxyAuthenticationRequired
+		resp.Header.Set("Proxy-Authenticate", Challenge{
+			Realm: realm, Nonce: nonce}.String())
+		return resp
+	}
+
+	creds := Credentials{"alice", "secret"}
+	Authorize(req, challenge("edge.example.com", "e1"), creds)
+	Authorize(req, challenge("core.example.com", "c1"), creds)
+
+	// Answering a realm again replaces its credentials only.
+	Authorize(req, challenge("edge.example.com", "e2"), creds)
+
+	if values := req.Header.Values("Proxy-Authorization"); len(values) != 2 {
+		t.Fatalf("request has Proxy-Authorization %q, expected one per realm",
+			values)
+	}
+	if edge, _ := req.Credentials("edge.example.com", true); edge.Nonce != "e2" {
+		t.Errorf("edge credentials have nonce %q, expected \"e2\"", edge.Nonce)
+	}
+	if core, _ := req.Credentials("core.example.com", true); core.Nonce != "c1" {
+		t.Errorf("core credentials have nonce %q, expected \"c1\"", core.Nonce)
+	}
+}
+
+func TestParseMD5AndSHA256Challenges(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodRegister, "z9hG4bKchallenges"))
+	resp, err := ReadResponse(strings.NewReader(testResponse(req,
+		"401 Unauthorized",
+		`WWW-Authenticate: Digest realm="example.com", nonce="n1", `+
+			`qop="auth,auth-int", algorithm=MD5, opaque="o1"`,
+		`WWW-Authenticate: Digest realm="example.com", nonce="n2", `+
+			`qop="auth", algorithm=SHA-256, stale=TRUE`)))
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	challenges := resp.Challenges()
+	if len(challenges) != 2 {
+		t.Fatalf("parsed %d challenges, expected 2", len(challenges))
+	}
+	md5, sha256 := challenges[0], challenges[1]
+	if md5.Scheme != "Digest" || md5.Realm != "example.com" ||
+		md5.Nonce != "n1" || md5.Algorithm != "MD5" || md5.Opaque != "o1" ||
+		strings.Join(md5.Qop, " ") != "auth auth-int" || md5.Stale {
+		t.Errorf("parsed the MD5 challenge as %+v", md5)
+	}
+	if sha256.Nonce != "n2" || sha256.Algorithm != "SHA-256" ||
+		strings.Join(sha256.Qop, " ") != "auth" || !sha256.Stale {
+		t.Errorf("parsed the SHA-256 challenge as %+v", sha256)
+	}
+
+	// Authorize answers the strongest challenge.
+	if err := Authorize(req, resp, Credentials{"alice", "secret"}); err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+	creds, found := req.Credentials("example.com", false)
+	if !found || creds.Nonce != "n2" || len(creds.Response) != 64 {
+		t.Errorf("answered with %+v, expected the SHA-256 challenge", creds)
+	}
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth,
+		"algorithm=SHA-256") {
+		t.Errorf("Authorization %q isn't for SHA-256", auth)
+	}
+}
+
+func TestParseChallengesInOneHeader(t *testing.T) {
+	challenges, err := ParseChallenges(`Digest realm="a", nonce="n1", ` +
+		`algorithm=SHA-256, Digest realm="a", nonce="n2", Basic realm="b"`)
+	if err != nil {
+		t.Fatalf("failed to parse challenges: %v", err)
+	}
+	if len(challenges) != 3 {
+		t.Fatalf("parsed %d challenges, expected 3", len(challenges))
+	}
+	if challenges[0].Nonce != "n1" || challenges[0].Algorithm != "SHA-256" {
+		t.Errorf("parsed the first challenge as %+v", challenges[0])
+	}
+	if challenges[1].Nonce != "n2" || challenges[1].Algorithm != "" {
+		t.Errorf("parsed the second challenge as %+v", challenges[1])
+	}
+	if challenges[2].Scheme != "Basic" || challenges[2].Realm != "b" {
+		t.Errorf("parsed the third challenge as %+v", challenges[2])
+	}
+
+	if _, err := ParseChallenges(`realm="a"`); err != ErrParseError {
+		t.Errorf("parsing parameters without a scheme returned %v", err)
+	}
+}
+
+func TestSelectChallenge(t *testing.T) {
+	challenges := []Challenge{
+		{Scheme: "Basic", Nonce: "basic"},
+		{Scheme: "Digest", Nonce: "md5"},
+		{Scheme: "Digest", Nonce: "sha256", Algorithm: "sha-256"},
+		{Scheme: "Digest", Nonce: "unknown", Algorithm: "SHA-1024"},
+	}
+
+	tests := []struct {
+		algorithms []string
+		nonce      string
+		ok         bool
+	}{
+		{[]string{"MD5", "SHA-256"}, "sha256", true},
+		{[]string{"MD5"}, "md5", true},
+		{[]string{"SHA-512-256", "SHA-1024"}, "", false},
+		{nil, "", false},
+	}
+
+	for _, test := range tests {
+		selected, ok := SelectChallenge(challenges, test.algorithms)
+		if selected.Nonce != test.nonce || ok != test.ok {
+			t.Errorf("%q: selected %q, %v, expected %q, %v", test.algorithms,
+				selected.Nonce, ok, test.nonce, test.ok)
+		}
+	}
+}
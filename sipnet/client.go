@@ -0,0 +1,309 @@
+package sipnet
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrTimeout is returned by Conn.Do if no final response is received before
+// the transaction times out, and by Conn.ReadTimeout if no message is
+// received in time.
+var ErrTimeout = errors.New("sip: timeout")
+
+// ErrRedirectLoop is returned by Conn.DoFollowingRedirects if the redirect
+// limit is reached.
+var ErrRedirectLoop = errors.New("sip: too many redirects")
+
+// Do sends a request over the connection and waits for its final response,
+// skipping provisional responses. A Via is added to the request if it
+// doesn't have one. Over UDP, the request is retransmitted with the timers
+// defined in RFC 3261, and an ACK is sent automatically for a non-2xx final
+// response to an INVITE. The Service-Route of a REGISTER response is stored
+// in ServiceRoute, and used as the Route of later out-of-dialog requests.
+// Responses received by the connection's listener from another address are
+// routed to the request by the listener's TransactionTable.
+//
+// The connection must be locked, and any other messages read from the
+// connection while waiting are discarded.
+func (c *Conn) Do(req *Request) (*Response, error) {
+	return c.DoProgress(req, nil)
+}
+
+// DoProgress sends a request like Do, but calls progress for each
+// provisional response received before the final response. progress may be
+// nil.
+//
+// Once an INVITE has received a provisional response, it no longer times
+// out after 32 seconds, but waits for the final response for the
+// configured InviteTimeout. If that passes, a CANCEL is sent for it, and
+// the final response it then receives (normally a 487) is returned.
+func (c *Conn) DoProgress(req *Request, progress func(*Response)) (*Response, error) {
+	if !c.Locked {
+		return nil, ErrNotLocked
+	}
+
+	if req.Header.Get("Via") == "" {
+		req.Header.Set("Via", c.NewVia().String())
+	}
+	c.preloadServiceRoute(req)
+
+	key := TransactionKey(req)
+	defer c.addTransaction(req)()
+
+	err := req.WriteTo(c)
+	if err != nil {
+		return nil, err
+	}
+
+	clock := c.clock()
+	interval := timerT1
+	var retransmitTimer Timer
+	var retransmit <-chan time.Time
+	if !c.protocol().IsStream() {
+		retransmitTimer = clock.NewTimer(interval)
+		defer retransmitTimer.Stop()
+		retransmit = retransmitTimer.C()
+	}
+
+	timeoutTimer := clock.NewTimer(transactionTimeout)
+	defer timeoutTimer.Stop()
+	timeout := timeoutTimer.C()
+	proceeding := false
+	cancelled := false
+
+	for {
+		select {
+		case msg, more := <-c.ReadMessage:
+			if !more {
+				return nil, io.EOF
+			}
+
+			switch msg := msg.(type) {
+			case *Response:
+				if TransactionKey(msg) != key {
+					continue
+				}
+
+				if msg.StatusCode < 200 {
+					if progress != nil {
+						progress(msg)
+					}
+
+					if req.Method == MethodInvite {
+						retransmit = nil
+						if !proceeding {
+							proceeding = true
+							timeout = resetTimer(timeoutTimer,
+								c.config().inviteTimeout())
+						}
+					} else {
+						interval = timerT2
+					}
+					continue
+				}
+
+				if req.Method == MethodInvite && msg.StatusCode >= 300 {
+					newAck(req, msg).WriteTo(c)
+				}
+				c.updateServiceRoute(req, msg)
+
+				return msg, nil
+			case error:
+				return nil, msg
+			}
+		case <-retransmit:
+			err := req.WriteTo(c)
+			if err != nil {
+				return nil, err
+			}
+			c.countRetransmission()
+
+			interval *= 2
+			if req.Method != MethodInvite && interval > timerT2 {
+				interval = timerT2
+			}
+			retransmitTimer.Reset(interval)
+		case <-timeout:
+			if !proceeding || cancelled {
+				return nil, ErrTimeout
+			}
+
+			// Timer C fired, so the INVITE is cancelled, and the
+			// transaction times out if it isn't answered either.
+			cancelled = true
+			NewCancel(req).WriteTo(c)
+			timeout = resetTimer(timeoutTimer, transactionTimeout)
+		}
+	}
+}
+
+// resetTimer resets a timer which may have fired to fire once d has passed,
+// returning its channel, or stops it and returns nil if d is negative.
+func resetTimer(timer Timer, d time.Duration) <-chan time.Time {
+	timer.Stop()
+	select {
+	case <-timer.C():
+	default:
+	}
+
+	if d < 0 {
+		return nil
+	}
+
+	timer.Reset(d)
+	return timer.C()
+}
+
+// NewCancel returns a CANCEL for an INVITE sent by a UAC, which has the
+// Request-URI, Call-ID, From, To, CSeq number, Route and top Via of the
+// INVITE, as required by RFC 3261 section 9.1. It should only be sent once
+// a provisional response to the INVITE has been received.
+func NewCancel(invite *Request) *Request {
+	cancel := NewRequest()
+	cancel.Method = MethodCancel
+	cancel.Server = invite.Server
+	cancel.Header.Set("Via", splitVias(invite.Header)[0])
+	cancel.Header.Set("From", invite.Header.Get("From"))
+	cancel.Header.Set("To", invite.Header.Get("To"))
+	cancel.Header.Set("Call-ID", invite.Header.Get("Call-ID"))
+	cancel.SetMaxForwards(DefaultMaxForwards)
+	for _, route := range invite.Header.Values("Route") {
+		cancel.Header.Add("Route", route)
+	}
+
+	cseq, err := ParseCSeq(invite.Header.Get("CSeq"))
+	if err == nil {
+		cseq.Method = MethodCancel
+		cancel.Header.Set("CSeq", cseq.String())
+	}
+
+	return cancel
+}
+
+// newAck returns the ACK for a non-2xx final response to an INVITE, which
+// is part of the INVITE transaction.
+func newAck(invite *Request, resp *Response) *Request {
+	ack := NewRequest()
+	ack.Method = MethodAck
+	ack.Server = invite.Server
+	ack.Header.Set("Via", splitVias(invite.Header)[0])
+	ack.Header.Set("From", invite.Header.Get("From"))
+	ack.Header.Set("To", resp.Header.Get("To"))
+	ack.Header.Set("Call-ID", invite.Header.Get("Call-ID"))
+	ack.SetMaxForwards(DefaultMaxForwards)
+	for _, route := range invite.Header.Values("Route") {
+		ack.Header.Add("Route", route)
+	}
+
+	cseq, err := ParseCSeq(invite.Header.Get("CSeq"))
+	if err == nil {
+		cseq.Method = MethodAck
+		ack.Header.Set("CSeq", cseq.String())
+	}
+
+	return ack
+}
+
+// DoFollowingRedirects sends a request like Do, but if a 3xx response is
+// received, the request is sent again to each Contact of the response in
+// order of preference (q-value), until a non-3xx final response is received
+// or the contacts are exhausted. Each attempt updates the Request-URI,
+// increments the CSeq and uses a new branch.
+//
+// At most maxRedirects redirects are followed, after which ErrRedirectLoop
+// is returned. Contacts which have already been tried are skipped. If a
+// contact responds with a Retry-After, the next contact is tried instead.
+func (c *Conn) DoFollowingRedirects(req *Request, maxRedirects int) (*Response, error) {
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tried := map[string]bool{req.Server: true}
+	redirects := 0
+	for resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		contacts, err := ParseUsers(resp.Header, "Contact")
+		if err != nil {
+			return resp, err
+		}
+		sortByQ(contacts)
+
+		redirected := resp
+		for _, contact := range contacts {
+			target := contact.URI.String()
+			if tried[target] {
+				continue
+			}
+			tried[target] = true
+
+			if redirects >= maxRedirects {
+				return resp, ErrRedirectLoop
+			}
+			redirects++
+
+			next, err := redirect(req, contact.URI)
+			if err != nil {
+				continue
+			}
+
+			resp = next
+			if _, ok := resp.RetryAfter(); ok && retryable(resp) {
+				// The target is unavailable for now, so try the next
+				// contact rather than waiting for it.
+				continue
+			}
+
+			if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+				return resp, nil
+			}
+
+			// Follow the redirect of the new target.
+			break
+		}
+
+		if resp == redirected {
+			return resp, nil
+		}
+	}
+
+	return resp, nil
+}
+
+// redirect sends req to the target URI over a new connection.
+func redirect(req *Request, target URI) (*Response, error) {
+	conn, err := DialURIConn(target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req.Server = target.String()
+	req.Header.Set("Via", conn.NewVia().String())
+
+	cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+	if err == nil {
+		cseq.Sequence++
+		req.Header.Set("CSeq", cseq.String())
+	}
+
+	return conn.Do(req)
+}
+
+// sortByQ sorts users by their q parameter in descending order. Users
+// without a q parameter have a q-value of 1.
+func sortByQ(users []User) {
+	q := func(user User) float64 {
+		value, err := strconv.ParseFloat(user.Arguments.Get("q"), 64)
+		if err != nil {
+			return 1
+		}
+		return value
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return q(users[i]) > q(users[j])
+	})
+}
@@ -0,0 +1,167 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDoFollowingRedirects(t *testing.T) {
+	redirector, first, second := udpPeer(t), udpPeer(t), udpPeer(t)
+	defer redirector.Close()
+	defer first.Close()
+	defer second.Close()
+
+	conn, err := DialConn(redirector.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := newTestRequest(MethodInvite, "sip:bob@"+redirector.LocalAddr().String())
+	results := make(chan doResult, 1)
+	go func() {
+		resp, err := conn.DoFollowingRedirects(req, 5)
+		results <- doResult{resp, err}
+	}()
+
+	// The contact with the higher q-value is tried first.
+	invite, from := readUDPRequest(t, redirector)
+	redirector.WriteTo([]byte(testResponse(invite, "302 Moved Temporarily",
+		"Contact: <sip:bob@"+second.LocalAddr().String()+">;q=0.5",
+		"Contact: <sip:bob@"+first.LocalAddr().String()+">;q=0.9")), from)
+
+	firstInvite, from := readUDPRequest(t, first)
+	if firstInvite.Server != "sip:bob@"+first.LocalAddr().String() {
+		t.Errorf("redirected to %s, expected the first contact",
+			firstInvite.Server)
+	}
+	if firstInvite.Header.Get("CSeq") != "2 INVITE" {
+		t.Errorf("redirected with CSeq %q, expected 2 INVITE",
+			firstInvite.Header.Get("CSeq"))
+	}
+	if TransactionKey(firstInvite) == TransactionKey(invite) {
+		t.Error("redirected with the branch of the original request")
+	}
+	first.WriteTo([]byte(testResponse(firstInvite, "503 Service Unavailable",
+		"Retry-After: 60")), from)
+
+	// The first contact is unavailable, so the second is tried.
+	secondInvite, from := readUDPRequest(t, second)
+	if secondInvite.Server != "sip:bob@"+second.LocalAddr().String() {
+		t.Errorf("redirected to %s, expected the second contact",
+			secondInvite.Server)
+	}
+	second.WriteTo([]byte(testResponse(secondInvite, "200 OK")), from)
+
+	select {
+	case result := <-results:
+		if result.err != nil || result.resp.StatusCode != StatusOK {
+			t.Errorf("returned %v, %v, expected the 200", result.resp, result.err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("DoFollowingRedirects didn't return")
+	}
+}
+
+func TestDoRedirectLimit(t *testing.T) {
+	redirector, target := udpPeer(t), udpPeer(t)
+	defer redirector.Close()
+	defer target.Close()
+
+	conn, err := DialConn(redirector.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := newTestRequest(MethodOptions, "sip:bob@"+redirector.LocalAddr().String())
+	results := make(chan doResult, 1)
+	go func() {
+		resp, err := conn.DoFollowingRedirects(req, 0)
+		results <- doResult{resp, err}
+	}()
+
+	options, from := readUDPRequest(t, redirector)
+	redirector.WriteTo([]byte(testResponse(options, "302 Moved Temporarily",
+		"Contact: <sip:bob@"+target.LocalAddr().String()+">")), from)
+
+	result := <-results
+	if result.err != ErrRedirectLoop {
+		t.Errorf("returned %v, expected ErrRedirectLoop", result.err)
+	}
+	expectNoUDP(t, target)
+}
+
+func TestInviteTimerC(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock})
+	defer l.Close()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	req := newTestRequest(MethodInvite, "sip:bob@"+peer.LocalAddr().String())
+	results := goDo(conn, req, nil)
+
+	invite, from := readUDPRequest(t, peer)
+	peer.WriteTo([]byte(testResponse(invite, "180 Ringing")), from)
+	waitForTimer(t, clock, start.Add(DefaultInviteTimeout))
+
+	// Timer B no longer applies once the INVITE has a provisional response.
+	clock.Advance(transactionTimeout + 10*time.Second)
+	select {
+	case result := <-results:
+		t.Fatalf("returned %v, %v before Timer C", result.resp, result.err)
+	case <-time.After(quietTimeout):
+	}
+
+	clock.Advance(DefaultInviteTimeout)
+	cancel, _ := readUDPRequest(t, peer)
+	if cancel.Method != MethodCancel ||
+		TransactionKey(cancel) == TransactionKey(invite) ||
+		cancel.Header.Get("Via") != invite.Header.Get("Via") {
+		t.Fatalf("sent %s, expected a CANCEL for the INVITE", cancel.Method)
+	}
+
+	peer.WriteTo([]byte(testResponse(cancel, "200 OK")), from)
+	peer.WriteTo([]byte(testResponse(invite, "487 Request Terminated")), from)
+	result := <-results
+	if result.err != nil || result.resp.StatusCode != StatusRequestTerminated {
+		t.Errorf("returned %v, %v, expected the 487", result.resp, result.err)
+	}
+
+	if ack, _ := readUDPRequest(t, peer); ack.Method != MethodAck {
+		t.Errorf("sent %s for the 487, expected an ACK", ack.Method)
+	}
+}
+
+func TestDoTimesOut(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock})
+	defer l.Close()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	results := goDo(conn, newTestRequest(MethodInvite,
+		"sip:bob@"+peer.LocalAddr().String()), nil)
+	readUDP(t, peer)
+	waitForTimer(t, clock, start.Add(transactionTimeout))
+
+	clock.Advance(transactionTimeout)
+	if result := <-results; result.err != ErrTimeout {
+		t.Errorf("returned %v, %v, expected ErrTimeout", result.resp, result.err)
+	}
+}
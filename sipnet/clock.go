@@ -0,0 +1,242 @@
+package sipnet
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the time to connections and their transactions, so timers
+// can be controlled in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel which receives the current time once d has
+	// passed.
+	After(d time.Duration) <-chan time.Time
+
+	// NewTimer returns a timer which fires once d has passed. Unlike After,
+	// it can be stopped to release it before it fires.
+	NewTimer(d time.Duration) Timer
+
+	// NewTicker returns a ticker which ticks every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer fires once after a duration, like a time.Timer.
+type Timer interface {
+	// C returns the channel the time is delivered on when the timer fires.
+	C() <-chan time.Time
+
+	// Stop stops the timer if it hasn't fired.
+	Stop()
+
+	// Reset changes the timer to fire once d has passed. It should only be
+	// called on a stopped timer or one whose channel has been drained.
+	Reset(d time.Duration)
+}
+
+// Ticker delivers ticks at intervals, like a time.Ticker.
+type Ticker interface {
+	// C returns the channel the ticks are delivered on.
+	C() <-chan time.Time
+
+	// Stop stops the ticker.
+	Stop()
+}
+
+// RealClock is the Clock backed by the time package, which is used by
+// default.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realTimer) Stop() {
+	t.timer.Stop()
+}
+
+func (t realTimer) Reset(d time.Duration) {
+	t.timer.Reset(d)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// FakeClock is a Clock which only moves forward when Advance is called, for
+// deterministic tests of transaction timers.
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	at       time.Time
+	interval time.Duration
+	c        chan time.Time
+	stopped  bool
+}
+
+// NewFakeClock returns a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (f *FakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// After returns a channel which receives the time once the clock has been
+// advanced by d.
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	return f.wait(d, 0).c
+}
+
+// NewTimer returns a timer which fires once the clock has been advanced by
+// d.
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	return fakeTimer{f, f.wait(d, 0)}
+}
+
+// NewTicker returns a ticker which ticks each time the clock is advanced
+// past another multiple of d.
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{f, f.wait(d, d)}
+}
+
+func (f *FakeClock) wait(d, interval time.Duration) *fakeWaiter {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	w := &fakeWaiter{
+		at:       f.now.Add(d),
+		interval: interval,
+		c:        make(chan time.Time, 1),
+	}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward by d, firing any timers and tickers which
+// are due. Ticks are dropped if a ticker's channel is full, like a
+// time.Ticker.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+
+	var waiting []*fakeWaiter
+	for _, w := range f.waiters {
+		for !w.stopped && !w.at.After(f.now) {
+			select {
+			case w.c <- f.now:
+			default:
+			}
+
+			if w.interval == 0 {
+				w.stopped = true
+				break
+			}
+			w.at = w.at.Add(w.interval)
+		}
+
+		if !w.stopped {
+			waiting = append(waiting, w)
+		}
+	}
+	f.waiters = waiting
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	waiter *fakeWaiter
+}
+
+func (t fakeTimer) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t fakeTimer) Stop() {
+	t.clock.mutex.Lock()
+	t.waiter.stopped = true
+	t.clock.mutex.Unlock()
+}
+
+func (t fakeTimer) Reset(d time.Duration) {
+	f := t.clock
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	t.waiter.at = f.now.Add(d)
+	t.waiter.stopped = false
+
+	// A timer which has fired or been stopped may have been removed from
+	// the waiters by Advance.
+	for _, w := range f.waiters {
+		if w == t.waiter {
+			return
+		}
+	}
+	f.waiters = append(f.waiters, t.waiter)
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	waiter *fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t fakeTicker) Stop() {
+	t.clock.mutex.Lock()
+	t.waiter.stopped = true
+	t.clock.mutex.Unlock()
+}
+
+// clock returns the clock of the connection.
+func (c *Conn) clock() Clock {
+	return c.config().clock()
+}
+
+func (c *Config) clock() Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return RealClock
+}
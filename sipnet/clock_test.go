@@ -0,0 +1,172 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+// expectFired fails the test if c doesn't receive a time.
+func expectFired(t *testing.T, what string, c <-chan time.Time) {
+	t.Helper()
+
+	select {
+	case <-c:
+	default:
+		t.Errorf("%s hasn't fired", what)
+	}
+}
+
+// expectNotFired fails the test if c has received a time.
+func expectNotFired(t *testing.T, what string, c <-chan time.Time) {
+	t.Helper()
+
+	select {
+	case <-c:
+		t.Errorf("%s fired early", what)
+	default:
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+
+	after := clock.After(time.Second)
+	timer := clock.NewTimer(2 * time.Second)
+	stopped := clock.NewTimer(time.Second)
+	stopped.Stop()
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	expectNotFired(t, "After", after)
+	expectNotFired(t, "ticker", ticker.C())
+
+	clock.Advance(time.Millisecond)
+	if now := clock.Now(); !now.Equal(start.Add(time.Second)) {
+		t.Errorf("clock is at %v, expected %v", now, start.Add(time.Second))
+	}
+	expectFired(t, "After", after)
+	expectFired(t, "ticker", ticker.C())
+	expectNotFired(t, "timer", timer.C())
+	expectNotFired(t, "stopped timer", stopped.C())
+
+	// A reset timer fires relative to the time it was reset.
+	timer.Reset(5 * time.Second)
+	clock.Advance(2 * time.Second)
+	expectNotFired(t, "reset timer", timer.C())
+	expectFired(t, "ticker", ticker.C())
+	clock.Advance(3 * time.Second)
+	expectFired(t, "reset timer", timer.C())
+	expectFired(t, "ticker", ticker.C())
+
+	ticker.Stop()
+	clock.Advance(time.Second)
+	expectNotFired(t, "stopped ticker", ticker.C())
+}
+
+// readMessageReal reads the next message received by a locked Conn with a
+// real timeout, for connections with a FakeClock.
+func readMessageReal(t *testing.T, conn *Conn) interface{} {
+	t.Helper()
+
+	messages := make(chan interface{}, 1)
+	go func() {
+		messages <- conn.Read()
+	}()
+
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(testTimeout):
+		t.Fatal("timed out reading message")
+	}
+	return nil
+}
+
+func TestFakeClockExpiresBranches(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	msg := testRequest(MethodMessage, "z9hG4bKexpiry")
+	sendUDP(t, peer, l, msg)
+	req, ok := readMessageReal(t, conn).(*Request)
+	if !ok {
+		t.Fatal("read a message other than the request")
+	}
+	if err := <-respond(conn, req, StatusOK, "b1"); err != nil {
+		t.Fatalf("failed to respond: %v", err)
+	}
+	readUDP(t, peer)
+
+	// A retransmission is answered from the cache.
+	sendUDP(t, peer, l, msg)
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Fatalf("retransmission answered with %q", startLine(data))
+	}
+
+	// Once the transaction has expired, the request is new again.
+	waitForTimer(t, clock, start.Add(10*time.Second))
+	clock.Advance(transactionTimeout + 10*time.Second)
+	waitFor(t, "the branch to expire", func() bool {
+		conn.BranchMutex.Lock()
+		defer conn.BranchMutex.Unlock()
+		_, found := conn.ReceivedBranches[TransactionKey(req)]
+		return !found
+	})
+
+	sendUDP(t, peer, l, msg)
+	if _, ok := readMessageReal(t, conn).(*Request); !ok {
+		t.Error("request after expiry wasn't delivered")
+	}
+}
+
+func TestFakeClockRetransmitsRequest(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	results := goDo(conn, newTestRequest(MethodMessage,
+		"sip:bob@"+peer.LocalAddr().String()), nil)
+	first, _ := readUDPRequest(t, peer)
+
+	// Timer E fires at T1, then at twice the interval.
+	for _, interval := range []time.Duration{timerT1, 2 * timerT1} {
+		expectNoUDP(t, peer)
+		start = start.Add(interval)
+		waitForTimer(t, clock, start)
+		clock.Advance(interval)
+		resent, _ := readUDPRequest(t, peer)
+		if resent.Method != MethodMessage ||
+			TransactionKey(resent) != TransactionKey(first) {
+			t.Fatalf("sent %s, expected a retransmission of the request",
+				resent.Method)
+		}
+	}
+
+	peer.WriteTo([]byte(testResponse(first, "200 OK")), l.TransportAddr("udp"))
+	select {
+	case result := <-results:
+		if result.err != nil || result.resp.StatusCode != StatusOK {
+			t.Errorf("returned %v, %v, expected the 200", result.resp, result.err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Do didn't return")
+	}
+}
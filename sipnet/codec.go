@@ -0,0 +1,274 @@
+package sipnet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/1lann/go-sip/sdp"
+)
+
+// ErrBodyType is returned when decoding or encoding a body with a codec
+// which doesn't support the type of the value given.
+var ErrBodyType = errors.New("sip: unsupported body value type")
+
+// Content types with built in codecs, in addition to ContentTypeJSON.
+const (
+	ContentTypeSDP       = "application/sdp"
+	ContentTypeMultipart = "multipart/mixed"
+)
+
+// BodyCodec decodes and encodes the bodies of a content type, as registered
+// with RegisterCodec. Either function may be nil if the codec only decodes
+// or encodes.
+type BodyCodec struct {
+	// Decode decodes a body with the given Content-Type, including its
+	// parameters, into v, which is usually a pointer.
+	Decode func(contentType string, body []byte, v interface{}) error
+
+	// Encode encodes v as a body of the media type, and returns the
+	// Content-Type to send it with, such as with the boundary of a
+	// multipart body.
+	Encode func(mediaType string, v interface{}) (string, []byte, error)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]BodyCodec
+}{m: make(map[string]BodyCodec)}
+
+// RegisterCodec registers the codec of a media type (i.e.
+// "application/pidf+xml"), replacing any codec registered for it. It is
+// safe to call from multiple goroutines, and is usually called from init.
+// Codecs are built in for ContentTypeSDP (to *sdp.Session), ContentTypeJSON
+// (with encoding/json), ContentTypeForm (to url.Values), and
+// multipart/mixed, multipart/alternative and multipart/related (to
+// []BodyPart).
+func RegisterCodec(mediaType string, codec BodyCodec) {
+	codecs.Lock()
+	codecs.m[strings.ToLower(mediaType)] = codec
+	codecs.Unlock()
+}
+
+// lookupCodec returns the codec registered for a lower case media type. A
+// media type with a structured syntax suffix, such as
+// application/vnd.example+json, falls back to the codec of the suffix.
+func lookupCodec(media string) (BodyCodec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	if codec, found := codecs.m[media]; found {
+		return codec, true
+	}
+
+	if plus := strings.LastIndex(media, "+"); plus >= 0 {
+		codec, found := codecs.m["application/"+media[plus+1:]]
+		return codec, found
+	}
+
+	return BodyCodec{}, false
+}
+
+// unmarshalBody decodes a body with a Content-Type using its registered
+// codec, returning ErrContentType if there is none.
+func unmarshalBody(contentType string, body []byte, v interface{}) error {
+	codec, found := lookupCodec(mediaType(contentType))
+	if !found || codec.Decode == nil {
+		return ErrContentType
+	}
+
+	return codec.Decode(contentType, body, v)
+}
+
+// marshalBody encodes v as a body of the media type using its registered
+// codec, returning ErrContentType if there is none.
+func marshalBody(media string, v interface{}) (string, []byte, error) {
+	media = strings.ToLower(media)
+	codec, found := lookupCodec(media)
+	if !found || codec.Encode == nil {
+		return "", nil, ErrContentType
+	}
+
+	return codec.Encode(media, v)
+}
+
+// DecodeBody decodes the body of the request into v with the codec
+// registered for its Content-Type. The content of a CPIM message is decoded
+// in place of the CPIM wrapper. ErrContentType is returned if no codec is
+// registered for the content type.
+func (r *Request) DecodeBody(v interface{}) error {
+	contentType, body := r.content()
+	return unmarshalBody(contentType, body, v)
+}
+
+// EncodeBody sets the body of the request to v encoded with the codec
+// registered for the media type, along with its Content-Type.
+// ErrContentType is returned if no codec is registered for the media type.
+func (r *Request) EncodeBody(mediaType string, v interface{}) error {
+	contentType, body, err := marshalBody(mediaType, v)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Content-Type", contentType)
+	r.SetBody(body)
+	return nil
+}
+
+// DecodeBody decodes the body of the response into v like
+// Request.DecodeBody.
+func (r *Response) DecodeBody(v interface{}) error {
+	return unmarshalBody(r.Header.Get("Content-Type"), r.Body, v)
+}
+
+// EncodeBody sets the body of the response to v like Request.EncodeBody.
+func (r *Response) EncodeBody(mediaType string, v interface{}) error {
+	contentType, body, err := marshalBody(mediaType, v)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Content-Type", contentType)
+	r.SetBody(body)
+	return nil
+}
+
+// BodyPart is a part of a multipart body (RFC 2046), such as the SDP and
+// ISUP parts of a SIP-I INVITE.
+type BodyPart struct {
+	// Header holds the MIME headers of the part, such as its Content-Type.
+	Header Header
+	Body   []byte
+}
+
+func decodeMultipart(contentType string, body []byte, v interface{}) error {
+	parts, ok := v.(*[]BodyPart)
+	if !ok {
+		return ErrBodyType
+	}
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["boundary"] == "" {
+		return ErrParseError
+	}
+
+	rd := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := rd.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			return err
+		}
+
+		h := make(Header)
+		for key, values := range part.Header {
+			for _, value := range values {
+				h.Add(key, value)
+			}
+		}
+		*parts = append(*parts, BodyPart{Header: h, Body: data})
+	}
+}
+
+func encodeMultipart(media string, v interface{}) (string, []byte, error) {
+	parts, ok := v.([]BodyPart)
+	if !ok {
+		return "", nil, ErrBodyType
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, part := range parts {
+		h := make(textproto.MIMEHeader)
+		for key, values := range part.Header {
+			h[key] = values
+		}
+
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return "", nil, err
+		}
+		pw.Write(part.Body)
+	}
+
+	if err := w.Close(); err != nil {
+		return "", nil, err
+	}
+
+	return mime.FormatMediaType(media,
+		map[string]string{"boundary": w.Boundary()}), buf.Bytes(), nil
+}
+
+func init() {
+	RegisterCodec(ContentTypeSDP, BodyCodec{
+		Decode: func(_ string, body []byte, v interface{}) error {
+			session, ok := v.(*sdp.Session)
+			if !ok {
+				return ErrBodyType
+			}
+
+			parsed, err := sdp.Parse(body)
+			if err != nil {
+				return err
+			}
+			*session = *parsed
+			return nil
+		},
+		Encode: func(media string, v interface{}) (string, []byte, error) {
+			session, ok := v.(*sdp.Session)
+			if !ok {
+				return "", nil, ErrBodyType
+			}
+			return media, session.Bytes(), nil
+		},
+	})
+
+	RegisterCodec(ContentTypeJSON, BodyCodec{
+		Decode: func(_ string, body []byte, v interface{}) error {
+			return json.Unmarshal(body, v)
+		},
+		Encode: func(media string, v interface{}) (string, []byte, error) {
+			body, err := json.Marshal(v)
+			return media, body, err
+		},
+	})
+
+	RegisterCodec(ContentTypeForm, BodyCodec{
+		Decode: func(_ string, body []byte, v interface{}) error {
+			values, ok := v.(*url.Values)
+			if !ok {
+				return ErrBodyType
+			}
+
+			var err error
+			*values, err = url.ParseQuery(strings.TrimSpace(string(body)))
+			return err
+		},
+		Encode: func(media string, v interface{}) (string, []byte, error) {
+			values, ok := v.(url.Values)
+			if !ok {
+				return "", nil, ErrBodyType
+			}
+			return media, []byte(values.Encode()), nil
+		},
+	})
+
+	multipartCodec := BodyCodec{Decode: decodeMultipart, Encode: encodeMultipart}
+	for _, media := range []string{ContentTypeMultipart,
+		"multipart/alternative", "multipart/related"} {
+		RegisterCodec(media, multipartCodec)
+	}
+}
@@ -0,0 +1,153 @@
+package sipnet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/1lann/go-sip/sdp"
+)
+
+// presence is the value of the test codec of application/pidf+xml.
+type presence struct {
+	Entity string
+	Open   bool
+}
+
+// init registers a codec for presence, which only handles the document of
+// its Encode.
+func init() {
+	RegisterCodec("application/pidf+xml", BodyCodec{
+		Decode: func(_ string, body []byte, v interface{}) error {
+			p, ok := v.(*presence)
+			if !ok {
+				return ErrBodyType
+			}
+
+			fields := strings.Split(string(body), `"`)
+			if len(fields) != 5 {
+				return ErrParseError
+			}
+			p.Entity, p.Open = fields[1], fields[3] == "open"
+			return nil
+		},
+		Encode: func(media string, v interface{}) (string, []byte, error) {
+			p, ok := v.(presence)
+			if !ok {
+				return "", nil, ErrBodyType
+			}
+
+			status := "closed"
+			if p.Open {
+				status = "open"
+			}
+			return media, []byte(`<presence entity="` + p.Entity +
+				`" status="` + status + `"/>`), nil
+		},
+	})
+}
+
+func TestCustomCodecRoundTrip(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodNotify, "z9hG4bKpidf"))
+	sent := presence{"pres:alice@example.com", true}
+	if err := req.EncodeBody("Application/PIDF+XML", sent); err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+
+	parsed := parseRequest(t, writeRequest(t, req))
+	if contentType := parsed.Header.Get("Content-Type"); contentType !=
+		"application/pidf+xml" {
+		t.Errorf("Content-Type is %q", contentType)
+	}
+	var received presence
+	if err := parsed.DecodeBody(&received); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if received != sent {
+		t.Errorf("decoded %+v, expected %+v", received, sent)
+	}
+
+	// The codec also decodes responses, and checks the type of the value.
+	resp := NewResponse()
+	resp.Header.Set("Content-Type", "application/pidf+xml; charset=utf-8")
+	resp.Body = parsed.Body
+	if err := resp.DecodeBody(&received); err != nil || received != sent {
+		t.Errorf("decoded the response as %+v, %v", received, err)
+	}
+	if err := resp.DecodeBody(new(string)); err != ErrBodyType {
+		t.Errorf("decoding into a string returned %v, expected ErrBodyType",
+			err)
+	}
+}
+
+func TestBuiltInCodecs(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKcodecs"))
+
+	// A structured syntax suffix uses the codec of the suffix.
+	req.Header.Set("Content-Type", "application/vnd.example+json")
+	req.SetBody([]byte(`{"from":"alice"}`))
+	var msg chatMessage
+	if err := req.DecodeBody(&msg); err != nil || msg.From != "alice" {
+		t.Errorf("decoded %+v, %v", msg, err)
+	}
+
+	offer, err := sdp.Parse([]byte(testSDP))
+	if err != nil {
+		t.Fatalf("failed to parse the SDP: %v", err)
+	}
+	if err := req.EncodeBody(ContentTypeSDP, offer); err != nil {
+		t.Fatalf("failed to encode the SDP: %v", err)
+	}
+	var session sdp.Session
+	if err := req.DecodeBody(&session); err != nil {
+		t.Fatalf("failed to decode the SDP: %v", err)
+	}
+	if !bytes.Equal(session.Bytes(), offer.Bytes()) {
+		t.Errorf("decoded SDP %q", session.Bytes())
+	}
+
+	req.Header.Set("Content-Type", "application/x-unknown")
+	if err := req.DecodeBody(new(string)); err != ErrContentType {
+		t.Errorf("decoding an unknown type returned %v", err)
+	}
+	if err := req.EncodeBody("application/x-unknown", "body"); err !=
+		ErrContentType {
+		t.Errorf("encoding an unknown type returned %v", err)
+	}
+}
+
+func TestMultipartCodecRoundTrip(t *testing.T) {
+	sdpPart := make(Header)
+	sdpPart.Set("Content-Type", ContentTypeSDP)
+	isupPart := make(Header)
+	isupPart.Set("Content-Type", "application/ISUP;version=itu-t92+")
+	sent := []BodyPart{
+		{Header: sdpPart, Body: []byte(testSDP)},
+		{Header: isupPart, Body: []byte{0x01, 0x00, 0x49}},
+	}
+
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKmultipart"))
+	if err := req.EncodeBody(ContentTypeMultipart, sent); err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	if media := mediaType(req.Header.Get("Content-Type")); media !=
+		ContentTypeMultipart {
+		t.Errorf("Content-Type is %q", req.Header.Get("Content-Type"))
+	}
+
+	var parts []BodyPart
+	if err := parseRequest(t, writeRequest(t, req)).DecodeBody(
+		&parts); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if len(parts) != len(sent) {
+		t.Fatalf("decoded %d parts, expected %d", len(parts), len(sent))
+	}
+	for i, part := range parts {
+		if !bytes.Equal(part.Body, sent[i].Body) ||
+			part.Header.Get("Content-Type") != sent[i].Header.Get("Content-Type") {
+			t.Errorf("part %d decoded as %q with %q", i, part.Body,
+				part.Header.Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package sipnet
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for URIs that do not specify a transport or port, as defined in
+// RFC 3261.
+const (
+	DefaultTransport = "udp"
+	DefaultPort      = 5060
+	DefaultTLSPort   = 5061
+)
+
+// DefaultReadQueueDepth is the number of received messages buffered per
+// connection if Config.ReadQueueDepth is not set.
+const DefaultReadQueueDepth = 32
+
+// DefaultInviteTimeout is how long Conn.Do waits for the final response to
+// an INVITE which has received a provisional response if
+// Config.InviteTimeout is not set, which is longer than the 3 minutes
+// required of Timer C by RFC 3261.
+const DefaultInviteTimeout = 3*time.Minute + 30*time.Second
+
+// DefaultUDPMTU is the size in bytes above which messages sent over UDP
+// are considered oversized, as recommended by RFC 3261 for a path MTU of
+// 1500 bytes.
+const DefaultUDPMTU = 1300
+
+// OverflowPolicy determines what happens to a received message when
+// a connection's read queue is full.
+type OverflowPolicy int
+
+// Overflow policies for a connection's read queue.
+const (
+	// OverflowBlock stops reading from the connection until there is room
+	// in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the message that was just received.
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest message in the queue to make
+	// room for the message that was just received.
+	OverflowDropOldest
+)
+
+// Config represents the configuration of a Listener. The zero value is
+// a valid configuration which uses the defaults.
+type Config struct {
+	// ReadQueueDepth is the number of received messages buffered per
+	// connection that have not been read yet by AcceptRequest or Read.
+	// If zero, DefaultReadQueueDepth is used.
+	ReadQueueDepth int
+
+	// OverflowPolicy is the policy applied to received messages when the
+	// read queue is full. The default is OverflowBlock.
+	OverflowPolicy OverflowPolicy
+
+	// AdvertisedHost is the host (IP or domain) advertised in Via sent-by,
+	// Contact and Record-Route headers, which may differ from the address
+	// the listener is bound to (i.e. a public IP when listening on 0.0.0.0).
+	// If empty, the host of the bound address is used.
+	AdvertisedHost string
+
+	// AdvertisedPort is the port advertised alongside AdvertisedHost.
+	// If zero, the port of the bound address is used.
+	AdvertisedPort int
+
+	// AdvertisedAddrs are the host:port advertised for connections of a
+	// transport, keyed by its lower case name (i.e. "tls"), overriding
+	// AdvertisedHost and AdvertisedPort, such as when TLS is reachable on
+	// another port. An empty host or port of an address uses the host or
+	// port which would be advertised otherwise.
+	AdvertisedAddrs map[string]string
+
+	// DNS resolves the hosts dialed by the listener when PreferredFamily or
+	// HappyEyeballs is configured. If nil, SystemDNS is used.
+	DNS DNS
+
+	// PreferredFamily is the address family tried first when a host dialed
+	// by the listener resolves to both IPv4 and IPv6 addresses. The default
+	// is FamilyAny, which keeps the order of the resolver.
+	PreferredFamily AddressFamily
+
+	// HappyEyeballs races connection attempts to the addresses of a host
+	// dialed over TCP or TLS (RFC 8305), alternating between families from
+	// the PreferredFamily, so an unreachable family doesn't delay the dial.
+	HappyEyeballs bool
+
+	// HappyEyeballsDelay is the head start of each connection attempt over
+	// the next with HappyEyeballs. If zero, DefaultHappyEyeballsDelay is
+	// used.
+	HappyEyeballsDelay time.Duration
+
+	// DefaultTransport is the transport used for URIs without a transport
+	// parameter. If empty, DefaultTransport ("udp") is used.
+	DefaultTransport string
+
+	// DefaultPort is the port used for URIs without a port over UDP or TCP.
+	// If zero, DefaultPort (5060) is used.
+	DefaultPort int
+
+	// DefaultTLSPort is the port used for URIs without a port over TLS.
+	// If zero, DefaultTLSPort (5061) is used.
+	DefaultTLSPort int
+
+	// DisableKeepAliveResponse disables the automatic CRLF response to
+	// keep-alives (double CRLF) received over UDP, or between messages over
+	// a stream. Keep-alives are instead delivered to Read as a KeepAlive.
+	DisableKeepAliveResponse bool
+
+	// UDPSourcePort is the local port UDP messages are sent from, if it
+	// differs from the port the listener is bound to. Messages received
+	// on this port are also accepted. If zero, messages are sent from
+	// the bound port.
+	UDPSourcePort int
+
+	// ConnectedUDP allows pooled UDP connections to be promoted to
+	// connected UDP sockets with Conn.ConnectUDP, such as once a dialog has
+	// been established. This requires the listening UDP socket to allow
+	// its address to be reused, and is only supported on Unix platforms.
+	ConnectedUDP bool
+
+	// PacketInfo records the local address each UDP datagram was received
+	// on (IP_PKTINFO and IPV6_PKTINFO), for listeners bound to an
+	// unspecified address on a multi-homed host. The address is exposed as
+	// Request.LocalAddr, used in the Via and Contact of the Conn unless an
+	// AdvertisedHost is configured, and responses are sent from it. It is
+	// only supported on Linux.
+	PacketInfo bool
+
+	// Parser is the parser used to read messages received by the listener.
+	// The zero value is a strict parser.
+	Parser Parser
+
+	// LocalDomains are the domains and hosts (without ports) the listener is
+	// responsible for, in addition to its own address and advertised host.
+	// A request to one of them is processed locally rather than forwarded.
+	LocalDomains []string
+
+	// Clock provides the time to connections, their transaction timers and
+	// janitors. If nil, RealClock is used.
+	Clock Clock
+
+	// InviteTimeout is how long Conn.Do waits for the final response to an
+	// INVITE once a provisional response has been received, after which the
+	// INVITE is cancelled (like Timer C of RFC 3261 section 16.6). Until a
+	// provisional response is received, the transaction times out after 32
+	// seconds. If zero, DefaultInviteTimeout is used, and if negative, Do
+	// waits for the final response for as long as the connection is open.
+	InviteTimeout time.Duration
+
+	// UDPMTU is the size in bytes above which messages sent over UDP are
+	// logged and counted by Conn.Oversized, and above which responses are
+	// sent over TCP with TCPFallback. If zero, DefaultUDPMTU is used, and if
+	// negative, no size is checked.
+	UDPMTU int
+
+	// TCPFallback sends a response to a request received over UDP which is
+	// larger than the UDPMTU over a TCP connection to the UA instead (RFC
+	// 3261 section 18.2.2). If the UA can't be reached over TCP, such as a
+	// UDP only or NATed UA, the response is sent over UDP anyway. By
+	// default, responses are always sent over UDP.
+	TCPFallback bool
+
+	// ReasonPhrases provides localized reason phrases for responses, which
+	// are chosen by the Accept-Language of the request. If nil, or if there
+	// is no translation, the English phrases of RFC 3261 are used.
+	ReasonPhrases ReasonPhraseProvider
+
+	// StatusTexts overrides the reason phrases of responses by status code,
+	// such as for a branded 486. Status codes which are not overridden use
+	// the phrases of RFC 3261.
+	StatusTexts map[int]string
+
+	// StatelessBranches derives the branch of the Via added when forwarding
+	// a request from the request itself, as required of stateless proxies
+	// so that retransmissions are forwarded with the same branch.
+	StatelessBranches bool
+
+	// LooseUDPContentLength trusts the datagram boundary of messages
+	// received over UDP, replacing a Content-Length which is larger than
+	// the body or malformed with the size of the body. By default, such
+	// messages are rejected with ErrContentLength. Bytes past the
+	// Content-Length are always discarded.
+	LooseUDPContentLength bool
+
+	// OmitUDPContentLength omits the Content-Length of messages without a
+	// body sent over UDP, where it is optional. By default, Content-Length
+	// is always sent, and it is always sent over stream transports, where
+	// it frames the message.
+	OmitUDPContentLength bool
+
+	// RejectNonSIP closes stream connections as soon as they receive data
+	// which is not SIP, such as a TLS handshake or an HTTP request from a
+	// port scanner, rather than parsing it. The start line of each message
+	// is sniffed before it is parsed, and rejected connections are counted
+	// by Listener.NonSIP.
+	RejectNonSIP bool
+
+	// GzipThreshold is the body size from which responses are compressed
+	// with gzip when they are written, if the request's Accept-Encoding
+	// prefers gzip to the identity encoding. If zero, responses are only
+	// compressed with Response.EncodeFor or CompressBody.
+	GzipThreshold int
+
+	// ViaTransportPolicy is the policy applied to received requests whose
+	// top Via transport differs from the transport they were received over.
+	// The default is ViaTransportIgnore.
+	ViaTransportPolicy ViaTransportPolicy
+
+	// Capabilities, if set, answers OPTIONS requests received outside of a
+	// dialog automatically with a 200 listing the capabilities, rather than
+	// passing them to AcceptRequest.
+	Capabilities *Capabilities
+
+	// Transactions routes received responses to the Conn their client
+	// transaction was sent over with Conn.Do or Conn.HandleResponses, such
+	// as a table shared by the listeners of a Server. If nil, each listener
+	// has its own.
+	Transactions *TransactionTable
+
+	// UnhandledResponse handles responses read by AcceptRequest which don't
+	// belong to a client transaction registered with Conn.HandleResponses.
+	// If nil, they are logged and discarded.
+	UnhandledResponse ResponseHandler
+
+	// RateLimit is the number of datagrams and TCP connections per second
+	// accepted from each source IP, with bursts of up to RateBurst. Excess
+	// traffic is dropped before it is parsed, and counted by
+	// Listener.Throttled. If zero, there is no limit.
+	RateLimit float64
+
+	// RateBurst is the number of datagrams and TCP connections accepted from
+	// a source IP at once before RateLimit applies. If zero, a burst of 1 is
+	// allowed.
+	RateBurst int
+
+	// MaxStreamConns is the maximum number of TCP and TLS connections the
+	// listener has open at once, accepted or dialed, to bound the file
+	// descriptors used. Once it is reached, new connections are handled by
+	// the StreamLimitPolicy and counted by Listener.StreamLimited, while
+	// open connections are unaffected. If zero, there is no limit.
+	MaxStreamConns int
+
+	// StreamLimitPolicy is the policy applied to new connections once
+	// MaxStreamConns is reached. The default is StreamLimitReject.
+	StreamLimitPolicy StreamLimitPolicy
+
+	// CallIDRate is the number of new Call-IDs per second each source IP
+	// may start with requests received over UDP, with bursts of up to
+	// CallIDBurst, to resist call bombing. Requests starting a Call-ID
+	// beyond it are dropped, and counted by Listener.CallIDThrottled. A
+	// Call-ID is no longer new once a request with it has been accepted.
+	// If zero, there is no limit.
+	CallIDRate float64
+
+	// CallIDBurst is the number of new Call-IDs a source IP may start at
+	// once before CallIDRate applies. If zero, a burst of 1 is allowed.
+	CallIDBurst int
+
+	// RejectCallIDFlood answers requests exceeding CallIDRate with a 503
+	// Service Unavailable rather than dropping them silently.
+	RejectCallIDFlood bool
+
+	// BranchStore records the transactions of received requests to detect
+	// retransmissions, such as a store shared by the instances of a cluster.
+	// Responses are still only re-sent by the instance which sent them. If
+	// nil, each connection records its own in ReceivedBranches.
+	BranchStore BranchStore
+
+	// MaxClockSkew is the difference between the Date of a received message
+	// and the local time above which a warning is logged and recorded in
+	// the message's Warnings, as a skewed clock breaks the freshness of
+	// nonces and the validation of certificates. If zero, it is not checked.
+	MaxClockSkew time.Duration
+
+	// MaxUDPConns is the maximum number of peers with a pooled UDP Conn,
+	// such as to bound the memory used by a flood of datagrams from
+	// distinct addresses. Once exceeded, the Conn which has gone the longest
+	// without receiving a message is closed. If zero, there is no limit.
+	MaxUDPConns int
+
+	// InboundMiddleware intercepts the requests and responses received by
+	// connections of the listener before they are read, in order.
+	InboundMiddleware []Middleware
+
+	// OutboundMiddleware intercepts the requests and responses written to
+	// connections of the listener, in order. Responses are intercepted after
+	// their Via, CSeq and Call-ID are copied from the request.
+	OutboundMiddleware []Middleware
+
+	// TraceHeader is the header trace IDs are propagated in across hops,
+	// such as "X-Trace-Id". If empty, trace IDs are not propagated.
+	TraceHeader string
+
+	// Tracer starts a span around the handling of each request received by
+	// a Server. If nil, no spans are started.
+	Tracer Tracer
+
+	// OnConnect is called when the listener opens a Conn, either when a TCP
+	// or TLS connection is accepted or dialed, or when a pooled UDP Conn is
+	// created for a new peer, such as to track the presence of peers.
+	OnConnect func(conn *Conn)
+
+	// OnDisconnect is called when a Conn opened by the listener closes,
+	// with the error which caused it to close, which is io.EOF if the peer
+	// closed the connection, ErrNonSIP if it was rejected by RejectNonSIP,
+	// or nil if it was closed with Close, such as when it expired from the
+	// UDP pool. For each Conn, the callbacks are called in order from a
+	// goroutine of their own, so they never block reading from it.
+	OnDisconnect func(conn *Conn, err error)
+}
+
+var defaultConfig = &Config{}
+
+func (c *Config) readQueueDepth() int {
+	if c.ReadQueueDepth <= 0 {
+		return DefaultReadQueueDepth
+	}
+
+	return c.ReadQueueDepth
+}
+
+func (c *Config) inviteTimeout() time.Duration {
+	if c.InviteTimeout == 0 {
+		return DefaultInviteTimeout
+	}
+
+	return c.InviteTimeout
+}
+
+func (c *Config) udpMTU() int {
+	if c.UDPMTU == 0 {
+		return DefaultUDPMTU
+	}
+
+	return c.UDPMTU
+}
+
+// Target returns the address (host:port) and transport that a request to
+// the URI should be sent to, applying the configured defaults for the port
+// and transport if the URI does not specify them.
+func (c *Config) Target(u URI) (string, string) {
+	transport := strings.ToLower(u.Arguments.Get("transport"))
+	if transport == "" {
+		if strings.ToLower(u.Scheme) == "sips" {
+			transport = "tls"
+		} else if c.DefaultTransport != "" {
+			transport = strings.ToLower(c.DefaultTransport)
+		} else {
+			transport = DefaultTransport
+		}
+	}
+
+	host, port, err := net.SplitHostPort(u.Domain)
+	if err == nil {
+		return net.JoinHostPort(host, port), transport
+	}
+
+	host = strings.Trim(u.Domain, "[]")
+	if transport == "tls" {
+		if c.DefaultTLSPort != 0 {
+			return net.JoinHostPort(host, strconv.Itoa(c.DefaultTLSPort)), transport
+		}
+		return net.JoinHostPort(host, strconv.Itoa(DefaultTLSPort)), transport
+	}
+
+	if c.DefaultPort != 0 {
+		return net.JoinHostPort(host, strconv.Itoa(c.DefaultPort)), transport
+	}
+	return net.JoinHostPort(host, strconv.Itoa(DefaultPort)), transport
+}
+
+// config returns the configuration of the Listener the connection belongs
+// to, or the default configuration if it does not belong to one.
+func (c *Conn) config() *Config {
+	if c.Listener == nil || c.Listener.config == nil {
+		return defaultConfig
+	}
+
+	return c.Listener.config
+}
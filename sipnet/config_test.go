@@ -0,0 +1,64 @@
+package sipnet
+
+import "testing"
+
+func TestTargetDefaults(t *testing.T) {
+	tests := []struct {
+		uri       string
+		addr      string
+		transport string
+	}{
+		{"sip:bob@example.com", "example.com:5060", "udp"},
+		{"sip:bob@example.com;transport=tcp", "example.com:5060", "tcp"},
+		{"sip:bob@example.com;transport=TLS", "example.com:5061", "tls"},
+		{"sips:bob@example.com", "example.com:5061", "tls"},
+		{"sip:bob@example.com:5080", "example.com:5080", "udp"},
+		{"sips:bob@example.com:5081", "example.com:5081", "tls"},
+		{"sip:bob@[2001:db8::1]", "[2001:db8::1]:5060", "udp"},
+	}
+
+	for _, test := range tests {
+		u, err := ParseURI(test.uri)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", test.uri, err)
+		}
+
+		addr, transport := u.Target()
+		if addr != test.addr || transport != test.transport {
+			t.Errorf("%s targets %s over %s, expected %s over %s", test.uri,
+				addr, transport, test.addr, test.transport)
+		}
+	}
+}
+
+func TestTargetConfiguredDefaults(t *testing.T) {
+	config := &Config{
+		DefaultTransport: "TCP",
+		DefaultPort:      5070,
+		DefaultTLSPort:   5071,
+	}
+
+	tests := []struct {
+		uri       string
+		addr      string
+		transport string
+	}{
+		{"sip:bob@example.com", "example.com:5070", "tcp"},
+		{"sip:bob@example.com;transport=udp", "example.com:5070", "udp"},
+		{"sips:bob@example.com", "example.com:5071", "tls"},
+		{"sip:bob@example.com:5060", "example.com:5060", "tcp"},
+	}
+
+	for _, test := range tests {
+		u, err := ParseURI(test.uri)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", test.uri, err)
+		}
+
+		addr, transport := config.Target(u)
+		if addr != test.addr || transport != test.transport {
+			t.Errorf("%s targets %s over %s, expected %s over %s", test.uri,
+				addr, transport, test.addr, test.transport)
+		}
+	}
+}
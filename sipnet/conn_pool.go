@@ -0,0 +1,164 @@
+package sipnet
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// connPoolShards is the number of shards of a connPool. Each shard has its
+// own lock, so lookups for different peers rarely contend.
+const connPoolShards = 32
+
+// connPool is a set of Conns keyed by remote address, split into shards.
+// The Conns are also kept in a list ordered by when they last received a
+// message, so the least recently used can be found without scanning the
+// pool.
+type connPool struct {
+	shards [connPoolShards]connPoolShard
+
+	// lruMutex guards lru and the poolElem of the Conns in it. It is taken
+	// after a shard's mutex when both are held.
+	lruMutex sync.Mutex
+	lru      *list.List
+}
+
+// poolEntry is an element of the list of a connPool.
+type poolEntry struct {
+	conn *Conn
+	seen time.Time
+}
+
+type connPoolShard struct {
+	mutex sync.Mutex
+	conns map[string]*Conn
+}
+
+func newConnPool() *connPool {
+	p := &connPool{lru: list.New()}
+	for i := range p.shards {
+		p.shards[i].conns = make(map[string]*Conn)
+	}
+	return p
+}
+
+func (p *connPool) shard(key string) *connPoolShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &p.shards[h.Sum32()%connPoolShards]
+}
+
+// get returns the Conn at key, if any.
+func (p *connPool) get(key string) (*Conn, bool) {
+	s := p.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	conn, found := s.conns[key]
+	return conn, found
+}
+
+// getOrCreate returns the Conn at key, or stores and returns the Conn
+// returned by create if there is none. The second return value is whether
+// the Conn was created.
+func (p *connPool) getOrCreate(key string, create func() *Conn) (*Conn, bool) {
+	s := p.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if conn, found := s.conns[key]; found {
+		return conn, false
+	}
+
+	conn := create()
+	s.conns[key] = conn
+
+	p.lruMutex.Lock()
+	conn.poolElem = p.lru.PushFront(&poolEntry{conn: conn, seen: conn.LastMessage})
+	p.lruMutex.Unlock()
+	return conn, true
+}
+
+// touch records that conn received a message at now, making it the most
+// recently used Conn of the pool.
+func (p *connPool) touch(conn *Conn, now time.Time) {
+	p.lruMutex.Lock()
+	defer p.lruMutex.Unlock()
+	if conn.poolElem != nil {
+		conn.poolElem.Value.(*poolEntry).seen = now
+		p.lru.MoveToFront(conn.poolElem)
+	}
+}
+
+// oldest returns the least recently used Conn of the pool other than keep,
+// which is not locked by the user, or nil if there is none.
+func (p *connPool) oldest(keep *Conn) *Conn {
+	p.lruMutex.Lock()
+	defer p.lruMutex.Unlock()
+	for e := p.lru.Back(); e != nil; e = e.Prev() {
+		conn := e.Value.(*poolEntry).conn
+		if conn != keep && !conn.Locked {
+			return conn
+		}
+	}
+	return nil
+}
+
+// idle returns the Conns of the pool which haven't received a message
+// since before. Conns locked by the user or promoted with ConnectUDP are
+// in use by a dialog even while it is quiet, so they are never idle.
+func (p *connPool) idle(before time.Time) []*Conn {
+	p.lruMutex.Lock()
+	defer p.lruMutex.Unlock()
+	var conns []*Conn
+	for e := p.lru.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*poolEntry)
+		if !entry.seen.Before(before) {
+			break
+		}
+
+		if entry.conn.Locked || entry.conn.isConnected() {
+			continue
+		}
+		conns = append(conns, entry.conn)
+	}
+	return conns
+}
+
+// remove removes conn from key, if it is still the Conn at key.
+func (p *connPool) remove(key string, conn *Conn) {
+	s := p.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conns[key] != conn {
+		return
+	}
+	delete(s.conns, key)
+
+	p.lruMutex.Lock()
+	if conn.poolElem != nil {
+		p.lru.Remove(conn.poolElem)
+		conn.poolElem = nil
+	}
+	p.lruMutex.Unlock()
+}
+
+// all returns a snapshot of the Conns in the pool.
+func (p *connPool) all() []*Conn {
+	var conns []*Conn
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.mutex.Lock()
+		for _, conn := range s.conns {
+			conns = append(conns, conn)
+		}
+		s.mutex.Unlock()
+	}
+	return conns
+}
+
+// len returns the number of Conns in the pool.
+func (p *connPool) len() int {
+	p.lruMutex.Lock()
+	defer p.lruMutex.Unlock()
+	return p.lru.Len()
+}
@@ -0,0 +1,63 @@
+package sipnet
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestSlowPeerDoesNotStallOthers(t *testing.T) {
+	l := listenTest(t, Config{ReadQueueDepth: 2})
+	defer l.Close()
+	slow := udpPeer(t)
+	defer slow.Close()
+	fast := udpPeer(t)
+	defer fast.Close()
+
+	// The slow peer's conn is locked and never read, so its queues fill.
+	sendUDP(t, slow, l, testRequest(MethodMessage, "z9hG4bKslow"))
+	_, slowConn := acceptRequest(t, l)
+	slowConn.Lock()
+	for i := 0; i < 50; i++ {
+		sendUDP(t, slow, l, testRequest(MethodMessage,
+			"z9hG4bKslow"+strconv.Itoa(i)))
+	}
+	waitFor(t, "datagrams of the slow peer to be dropped", func() bool {
+		return slowConn.Dropped() > 0
+	})
+
+	sendUDP(t, fast, l, testRequest(MethodMessage, "z9hG4bKfast"))
+	req, _ := acceptRequest(t, l)
+	if req.Header.Get("Via") != "SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKfast" {
+		t.Errorf("accepted %q, expected the request of the fast peer",
+			req.Header.Get("Via"))
+	}
+}
+
+func BenchmarkDispatchManyPeers(b *testing.B) {
+	for _, peers := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("%d peers", peers), func(b *testing.B) {
+			l, err := ListenWithConfig("127.0.0.1:0", Config{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+			defer l.closeUDPPool()
+
+			sender := newMockPacketConn()
+			addrs := make([]mockAddr, peers)
+			for i := range addrs {
+				addrs[i] = mockAddr("peer" + strconv.Itoa(i))
+			}
+
+			// Nothing accepts the requests, so every peer's queue fills
+			// and further datagrams must be dropped without blocking.
+			data := []byte(testRequest(MethodMessage, "z9hG4bK1"))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				dispatchFrame(l, UDP, sender, addrs[i%peers], nil, data)
+			}
+		})
+	}
+}
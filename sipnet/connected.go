@@ -0,0 +1,66 @@
+package sipnet
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// ErrConnectUnsupported is returned by Conn.ConnectUDP if connected UDP
+// sockets are not supported for the connection.
+var ErrConnectUnsupported = errors.New("sip: connected UDP unsupported")
+
+// ConnectUDP promotes a pooled UDP connection to use a connected UDP socket
+// bound to the listener's address, for use once a dialog with the UA has
+// been established. Messages are then sent without a per-message address,
+// and the kernel only accepts datagrams from the UA's address on the
+// socket. The listener continues to accept datagrams from new UAs.
+//
+// The listener must be configured with Config.ConnectedUDP. It returns
+// io.ErrClosedPipe if the connection is closed.
+func (c *Conn) ConnectUDP() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.Closed {
+		return io.ErrClosedPipe
+	}
+
+	if c.Listener == nil || c.packetConn == nil || c.Transport != "udp" ||
+		!c.Listener.config.ConnectedUDP {
+		return ErrConnectUnsupported
+	}
+
+	localAddr, ok := c.packetConn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return ErrConnectUnsupported
+	}
+
+	dialer := net.Dialer{
+		LocalAddr: localAddr,
+		Control:   reuseAddr,
+	}
+
+	udpConn, err := dialer.Dial("udp", c.Address.String())
+	if err != nil {
+		return err
+	}
+
+	// The socket is swapped under writeMutex, so writes in progress finish
+	// on the listener's socket and later writes use the connected one.
+	c.Conn = udpConn
+	c.packetConn = nil
+	c.connected = true
+
+	go readDatagrams(c, udpConn)
+
+	return nil
+}
+
+// isConnected returns whether the connection has been promoted to a
+// connected UDP socket with ConnectUDP.
+func (c *Conn) isConnected() bool {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.connected
+}
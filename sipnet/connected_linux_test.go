@@ -0,0 +1,70 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectedUDPAcceptsOnlyPeer(t *testing.T) {
+	l := listenTest(t, Config{ConnectedUDP: true})
+	defer l.Close()
+
+	dialog, other := udpPeer(t), udpPeer(t)
+	defer dialog.Close()
+	defer other.Close()
+
+	sendUDP(t, dialog, l, testRequest(MethodInvite, "z9hG4bKdialog"))
+	_, conn := acceptRequest(t, l)
+	if err := conn.ConnectUDP(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if conn.Conn.RemoteAddr().String() != dialog.LocalAddr().String() {
+		t.Fatalf("connected to %v, expected the dialog peer %v",
+			conn.Conn.RemoteAddr(), dialog.LocalAddr())
+	}
+
+	// Datagrams from other peers are still received by the listener's
+	// socket, for conns of their own.
+	sendUDP(t, other, l, testRequest(MethodOptions, "z9hG4bKother"))
+	if _, otherConn := acceptRequest(t, l); otherConn == conn {
+		t.Error("datagram from another peer received by the connected conn")
+	}
+
+	sendUDP(t, dialog, l, testRequest(MethodBye, "z9hG4bKbye"))
+	req, byeConn := acceptRequest(t, l)
+	if byeConn != conn {
+		t.Error("datagram from the dialog peer not received by its conn")
+	}
+
+	if err := <-respond(conn, req, StatusOK, "b1"); err != nil {
+		t.Fatalf("failed to respond: %v", err)
+	}
+	if resp, _ := readUDP(t, dialog); startLine(resp) != "SIP/2.0 200 OK" {
+		t.Errorf("dialog peer received %q, expected the 200", startLine(resp))
+	}
+}
+
+func TestConnectedUDPNotExpired(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	l := listenTest(t, Config{ConnectedUDP: true, Clock: clock})
+	defer l.Close()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodInvite, "z9hG4bKdialog"))
+	_, conn := acceptRequest(t, l)
+	if err := conn.ConnectUDP(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	// The janitor only expires idle conns sharing the listener's socket.
+	for i := 0; i < 6; i++ {
+		clock.Advance(10 * time.Second)
+	}
+	select {
+	case <-conn.done:
+		t.Error("connected conn expired as idle")
+	case <-time.After(quietTimeout):
+	}
+}
@@ -1,17 +1,30 @@
 package sipnet
 
 import (
+	"bufio"
 	"bytes"
+	"container/list"
+	"errors"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"fmt"
 )
 
-// Conn represents a connection with a UA. It can be on UDP or TCP.
+// Conn represents a connection with a UA. It can be on UDP, TCP or any other
+// Transport.
 type Conn struct {
+	// dropped, oversized and counters are accessed atomically, and must be
+	// first for 64-bit alignment.
+	dropped   uint64
+	oversized uint64
+	counters  connCounters
+
 	Transport   string
 	Listener    *Listener
 	Conn        net.Conn
@@ -25,14 +38,76 @@ type Conn struct {
 
 	ReceivedBranches map[string]time.Time
 	BranchMutex      *sync.Mutex
+
+	// DisableKeepAliveResponse disables the automatic keep-alive response
+	// for this connection, regardless of the listener's configuration.
+	DisableKeepAliveResponse bool
+
+	// ServiceRoute is the Service-Route (RFC 3608) returned by the
+	// registrar in the last successful REGISTER sent with Do. It is
+	// preloaded as the Route of out-of-dialog requests sent with Do.
+	ServiceRoute []User
+
+	transport     Transport
+	packetConn    net.PacketConn
+	connected     bool
+	dialed        bool
+	responseCache map[string]cachedResponse
+
+	// destination is the net.IP the last datagram from the peer was
+	// received on, if PacketInfo is configured.
+	destination atomic.Value
+
+	receivedResponses map[string]time.Time
+	branchMethods     map[string]branchMethod
+	sentAcks          map[string]sentAck
+	responseHandlers  map[string]ResponseHandler
+
+	values sync.Map
+
+	// poolElem is the element of the Conn in the list of the UDP pool of
+	// its listener, guarded by the pool's lruMutex.
+	poolElem *list.Element
+
+	// done is closed once the connection is closed, with closeErr set to
+	// the cause.
+	done     chan struct{}
+	closeErr error
+
+	// sendMutex is held while a message is written to the write buffer and
+	// flushed, so messages sent concurrently are not interleaved.
+	sendMutex sync.Mutex
+
+	// writeMutex makes Write, Flush and Close mutually exclusive, so the
+	// connection can't be closed while the write buffer is in use. Received
+	// datagrams are also queued on UdpReceiver under it, so it isn't closed
+	// while they are queued.
+	writeMutex sync.Mutex
 }
 
+// KeepAlive is read from a Conn when a keep-alive is received and the
+// automatic keep-alive response is disabled.
+type KeepAlive struct{}
+
+// ErrNotLocked is read from a Conn if Read is called without locking the
+// connection with Lock first.
+var ErrNotLocked = errors.New("sip: connection not locked")
+
 // Read reads either a *Request, a *Response, or an error from the connection.
+// A KeepAlive may also be read if automatic keep-alive responses are
+// disabled.
+//
+// The connection must be locked with Lock, otherwise ErrNotLocked is
+// returned, as messages would otherwise be consumed by AcceptRequest.
 func (c *Conn) Read() interface{} {
 	if c.Closed {
 		return io.EOF
 	}
 
+	if !c.Locked {
+		return ErrNotLocked
+	}
+
 	msg, more := <-c.ReadMessage
 	if !more {
 		return io.EOF
@@ -41,6 +116,51 @@ func (c *Conn) Read() interface{} {
 	return msg
 }
 
+// Set stores a value on the connection at a key, such as the session of
+// the UA, so it can be retrieved when handling later messages. It is safe
+// to call from multiple goroutines.
+func (c *Conn) Set(key, value interface{}) {
+	c.values.Store(key, value)
+}
+
+// Get returns the value stored on the connection at a key with Set, or nil
+// if there is none.
+func (c *Conn) Get(key interface{}) interface{} {
+	value, _ := c.values.Load(key)
+	return value
+}
+
+// ReadTimeout reads a *Request, a *Response or a KeepAlive from the
+// connection like Read, waiting at most d. ErrTimeout is returned if no
+// message is received in time, and errors read from the connection are
+// returned as the error.
+func (c *Conn) ReadTimeout(d time.Duration) (interface{}, error) {
+	if c.Closed {
+		return nil, io.EOF
+	}
+
+	if !c.Locked {
+		return nil, ErrNotLocked
+	}
+
+	timer := c.clock().NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case msg, more := <-c.ReadMessage:
+		if !more {
+			return nil, io.EOF
+		}
+
+		if err, ok := msg.(error); ok {
+			return nil, err
+		}
+		return msg, nil
+	case <-timer.C():
+		return nil, ErrTimeout
+	}
+}
+
 // Lock must be called to use Read(). It locks the connection to be read by
 // the user rather than by read by AcceptRequest().
 func (c *Conn) Lock() {
@@ -78,90 +198,401 @@ func (c *Conn) readRequest() (*Request, error) {
 			return nil, msg.(error)
 		case *Request:
 			return msg.(*Request), nil
+		case *Response:
+			c.handleResponse(msg.(*Response))
+		case KeepAlive:
 		default:
-			fmt.Println("warning: unhandled message type (likely a response)")
+			fmt.Println("warning: unhandled message type")
 		}
 	}
 }
 
 func (c *Conn) udpReader() {
+	defer c.recoverReader()
+
 	for {
 		received, more := <-c.UdpReceiver
 		if !more {
+			// Unblock readers waiting on this connection, without blocking
+			// if the queue is full and nothing is reading it.
+			select {
+			case c.ReadMessage <- io.EOF:
+			default:
+			}
 			return
 		}
 
-		c.LastMessage = time.Now()
-		if bytes.Compare(received, []byte("\r\n\r\n")) == 0 {
-			// Acknowledge keep alive
-			c.Write([]byte("\r\n"))
+		now := c.clock().Now()
+		c.LastMessage = now
+		if c.Listener != nil {
+			c.Listener.udpPool.touch(c, now)
+		}
+		c.countIn(len(received))
+		if bytes.Compare(received, keepAlivePing) == 0 {
+			c.answerKeepAlive()
+			continue
+		}
+
+		// Parse errors only affect a single datagram, so they are delivered
+		// as a MessageError and reading continues.
+		data, err := c.checkDatagramLength(received)
+		if err != nil {
+			c.deliver(newMessageError(c.Address, received, err))
 			continue
 		}
 
-		rd := bytes.NewReader(received)
-		if bytes.Compare(received[:3], []byte("SIP")) == 0 {
-			resp, err := ReadResponse(rd)
+		rd := bytes.NewReader(data)
+		if bytes.HasPrefix(received, []byte("SIP")) {
+			resp, err := c.config().Parser.ReadResponse(rd)
 			if err != nil {
-				c.ReadMessage <- err
+				c.deliver(newMessageError(c.Address, received, err))
 				continue
 			}
-			c.ReadMessage <- resp
+			if c.absorbResponseRetransmission(resp) || c.routeResponse(resp) {
+				continue
+			}
+			c.deliver(resp)
 			continue
 		}
 
-		req, err := ReadRequest(rd)
-		if err != nil {
-			c.ReadMessage <- err
+		req, err := c.config().Parser.ReadRequest(rd)
+		if err == ErrUnsupportedEncoding {
+			NewResponse().UnsupportedMediaType(c, req,
+				"Unsupported Content-Encoding.")
+			continue
+		} else if err == ErrBodyTooLarge {
+			NewResponse().RequestEntityTooLarge(c, req, "Body too large.")
+			continue
+		} else if err == ErrVersionNotSupported {
+			if req.Method != MethodAck {
+				NewResponse().VersionNotSupported(c, req)
+			}
+			continue
+		} else if c.rejectInvalid(req, err) {
+			continue
+		} else if err != nil {
+			c.deliver(newMessageError(c.Address, received, err))
+			continue
+		}
+
+		req.RemoteAddr = c.Address
+		req.LocalAddr = c.LocalAddr()
+		req.transport = c.protocol()
+		if c.checkViaTransport(req) || c.absorbRetransmission(req) ||
+			c.callIDLimited(req) {
 			continue
 		}
 
-		c.ReadMessage <- req
+		c.deliver(req)
 	}
 }
 
 func (c *Conn) tcpReader() {
+	defer c.recoverReader()
+
+	// The reader is kept across messages, so data buffered past the end of
+	// a message is not lost. Reads block until the whole header and body
+	// have arrived, however many segments they are split into. A message
+	// received in full before the peer half-closes the connection is
+	// delivered, and the connection is only closed when the next message
+	// is read.
+	rd := bufio.NewReader(countingReader{c, c.Conn})
 	for {
-		buf := make([]byte, 3)
-		_, err := io.ReadFull(c.Conn, buf)
+		err := skipLineEndings(rd, c.answerKeepAlive)
 		if err != nil {
-			if err == io.ErrUnexpectedEOF || err == io.EOF {
-				c.Close()
+			c.closeFromReader(err)
+			return
+		}
+
+		if c.config().RejectNonSIP {
+			sip, err := looksLikeSIP(rd)
+			if err != nil {
+				c.closeFromReader(err)
+				return
+			} else if !sip {
+				c.rejectNonSIP()
 				return
 			}
 		}
 
-		rd := io.MultiReader(bytes.NewReader(buf), c.Conn)
+		start, err := rd.Peek(3)
+		if err != nil {
+			c.closeFromReader(err)
+			return
+		}
 
-		if bytes.Compare(buf, []byte("SIP")) == 0 {
-			resp, err := ReadResponse(rd)
-			if err != nil {
-				c.ReadMessage <- err
+		if bytes.Equal(start, []byte("SIP")) {
+			resp, err := c.config().Parser.ReadResponseBuffered(rd)
+			if err == nil {
+				err = c.checkStreamLength(resp.Header, &resp.Warnings)
+			}
+			if isStreamError(err) {
+				c.closeFromReader(err)
+				return
+			} else if err != nil {
+				c.deliver(newMessageError(c.Address, nil, err))
 				continue
 			}
-			c.ReadMessage <- resp
+			if c.absorbResponseRetransmission(resp) || c.routeResponse(resp) {
+				continue
+			}
+			c.deliver(resp)
 			continue
 		}
 
-		req, err := ReadRequest(rd)
-		if err != nil {
-			c.ReadMessage <- err
+		req, err := c.config().Parser.ReadRequestBuffered(rd)
+		if err == nil {
+			err = c.checkStreamLength(req.Header, &req.Warnings)
+		}
+		body := streamedBody(req)
+		if err == ErrUnsupportedEncoding {
+			NewResponse().UnsupportedMediaType(c, req,
+				"Unsupported Content-Encoding.")
+			continue
+		} else if err == ErrBodyTooLarge {
+			NewResponse().RequestEntityTooLarge(c, req, "Body too large.")
+			continue
+		} else if err == ErrVersionNotSupported {
+			body.discard()
+			if req.Method != MethodAck {
+				NewResponse().VersionNotSupported(c, req)
+			}
+			continue
+		} else if c.rejectInvalid(req, err) {
+			body.discard()
 			continue
+		} else if isStreamError(err) {
+			c.closeFromReader(err)
+			return
+		} else if err != nil {
+			body.discard()
+			c.deliver(newMessageError(c.Address, nil, err))
+			continue
+		}
+
+		req.RemoteAddr = c.Address
+		req.LocalAddr = c.LocalAddr()
+		req.transport = c.protocol()
+		if c.checkViaTransport(req) || c.absorbRetransmission(req) {
+			body.discard()
+			continue
+		}
+
+		if c.deliver(req) {
+			body.wait(c.done)
+		} else {
+			body.discard()
+		}
+	}
+}
+
+// keepAlivePing and keepAlivePong are the CRLF keep-alive ping and its
+// response (RFC 5626 section 4.4.1).
+var (
+	keepAlivePing = []byte("\r\n\r\n")
+	keepAlivePong = []byte("\r\n")
+)
+
+// skipLineEndings discards the CRLFs received before the start of a
+// message over a stream, which are ignored (RFC 3261 section 7.5), calling
+// ping for each double CRLF keep-alive ping among them as it is received.
+func skipLineEndings(rd *bufio.Reader, ping func()) error {
+	for {
+		b, err := rd.Peek(1)
+		if err != nil {
+			return err
+		}
+
+		if b[0] != '\r' && b[0] != '\n' {
+			return nil
+		}
+
+		if rd.Buffered() >= len(keepAlivePing) {
+			b, _ = rd.Peek(len(keepAlivePing))
+			if bytes.Equal(b, keepAlivePing) {
+				rd.Discard(len(keepAlivePing))
+				ping()
+				continue
+			}
+		}
+		rd.ReadByte()
+	}
+}
+
+// answerKeepAlive answers a keep-alive ping received from the peer with a
+// pong, or delivers it as a KeepAlive if keep-alive responses are disabled.
+// The pong is written between messages, so it doesn't interleave with them.
+func (c *Conn) answerKeepAlive() {
+	if c.DisableKeepAliveResponse || c.config().DisableKeepAliveResponse {
+		c.deliver(KeepAlive{})
+		return
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if !c.Closed {
+		c.writeRaw(keepAlivePong)
+	}
+}
+
+// recoverReader recovers from a panic in a reader goroutine of the
+// connection, such as from parsing a malicious message. The panic is logged
+// and the connection is closed, leaving other connections unaffected.
+func (c *Conn) recoverReader() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	fmt.Println("warning: recovered from panic reading from", c.Address, ":", r)
+	c.closeFromReader(fmt.Errorf("sip: panic reading from connection: %v", r))
+}
+
+// recoverHandler recovers from a panic in a handler called for a message
+// received by the connection, such as a ResponseHandler or the handler of a
+// Server. The panic is logged and the connection is closed, leaving other
+// connections unaffected.
+func (c *Conn) recoverHandler() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	fmt.Println("warning: recovered from panic handling message from", c.Address, ":", r)
+	c.closeWithError(fmt.Errorf("sip: panic handling message: %v", r))
+}
+
+// closeFromReader closes the connection with the error which stopped it
+// from being read once no more messages can be read from it, and unblocks
+// readers waiting on it with an io.EOF.
+func (c *Conn) closeFromReader(err error) {
+	c.closeWithError(err)
+
+	select {
+	case c.ReadMessage <- io.EOF:
+	default:
+	}
+}
+
+// isStreamError returns whether an error returned while parsing a message
+// from a stream is an error of the stream itself, after which no more
+// messages can be read.
+func isStreamError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// rejectInvalid responds with a 400 Bad Request if a request failed
+// validation and can be responded to, and returns whether it did. An ACK
+// is never responded to.
+func (c *Conn) rejectInvalid(req *Request, err error) bool {
+	headerErr, ok := err.(*HeaderError)
+	if !ok || req.Method == MethodAck ||
+		(headerErr.Key == "Via" && !headerErr.TooMany) {
+		return false
+	}
+
+	if headerErr.TooMany {
+		NewResponse().BadRequest(c, req, "Too many "+headerErr.Key+
+			" headers.")
+		return true
+	}
+
+	NewResponse().BadRequest(c, req, "Missing or malformed "+
+		headerErr.Key+" header.")
+	return true
+}
+
+// deliver queues a received message to be read after passing it through the
+// inbound middleware, applying the configured OverflowPolicy if the read
+// queue is full. It returns whether the message was queued, which it isn't
+// if it was dropped by the middleware or the OverflowPolicy.
+func (c *Conn) deliver(msg interface{}) bool {
+	switch msg.(type) {
+	case *Request, *Response:
+		atomic.AddUint64(&c.counters.messagesIn, 1)
+	}
+
+	msg = c.inbound(msg)
+	if msg == nil {
+		return false
+	}
+	c.checkClockSkew(msg)
+
+	switch c.config().OverflowPolicy {
+	case OverflowDropNewest:
+		select {
+		case c.ReadMessage <- msg:
+		default:
+			c.dropMessage()
+			return false
 		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case c.ReadMessage <- msg:
+				return true
+			default:
+			}
 
-		c.ReadMessage <- req
+			select {
+			case <-c.ReadMessage:
+				c.dropMessage()
+			default:
+			}
+		}
+	default:
+		c.ReadMessage <- msg
 	}
+	return true
+}
+
+func (c *Conn) dropMessage() {
+	atomic.AddUint64(&c.dropped, 1)
+	fmt.Println("warning: read queue full, dropped message from", c.Address)
+}
+
+// Oversized returns the number of UDP messages sent over the connection
+// which were larger than the configured UDPMTU.
+func (c *Conn) Oversized() uint64 {
+	return atomic.LoadUint64(&c.oversized)
+}
+
+// Dropped returns the number of received messages that have been dropped
+// because the read queue was full.
+func (c *Conn) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
 }
 
+// writeReceivedUDP hands a received datagram to the reader of the Conn. It
+// never blocks, so a slow peer can't stall the socket's receive loop for
+// other peers; the datagram is dropped if the peer's queue is full. It is
+// queued under writeMutex, so Close can't close UdpReceiver meanwhile.
 func (c *Conn) writeReceivedUDP(b []byte) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
 	if c.Closed {
 		return
 	}
 
-	c.UdpReceiver <- b
+	select {
+	case c.UdpReceiver <- b:
+	default:
+		c.dropMessage()
+	}
 }
 
-// Write writes data to a buffer.
+// Write writes data to a buffer. It returns io.ErrClosedPipe if the
+// connection is closed, including if it is closed concurrently.
 func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
 	if c.Closed {
 		return 0, io.ErrClosedPipe
 	}
@@ -169,23 +600,97 @@ func (c *Conn) Write(b []byte) (int, error) {
 	return c.WriteBuffer.Write(b)
 }
 
+// setContentLength sets the Content-Length of a message written to the
+// connection to the length of its body. It is always set, as some peers
+// require it even without a body, except that it is removed from bodyless
+// messages sent over UDP if OmitUDPContentLength is configured.
+func (c *Conn) setContentLength(h Header, length int) {
+	if length == 0 && !c.protocol().IsStream() &&
+		c.config().OmitUDPContentLength {
+		h.Del("Content-Length")
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(length))
+}
+
 // Flush flushes the buffered data to be written. In the case of using UDP,
-// the buffered data will be written in a single UDP packet.
+// the buffered data will be written in a single UDP packet. A packet larger
+// than the configured UDPMTU is still sent, but is likely to be fragmented
+// and dropped along the way, so a warning is logged and it is counted in
+// Oversized.
 func (c *Conn) Flush() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
 	if c.Closed {
 		return io.ErrClosedPipe
 	}
 
-	if c.Transport == "udp" {
-		udpConn := c.Conn.(*net.UDPConn)
-		_, err := udpConn.WriteTo(c.WriteBuffer.Bytes(), c.Address)
-		c.WriteBuffer.Reset()
-		return err
+	c.checkOversized(c.WriteBuffer.Len())
+	err := c.writeRaw(c.WriteBuffer.Bytes())
+	c.WriteBuffer.Reset()
+	if err == nil {
+		atomic.AddUint64(&c.counters.messagesOut, 1)
 	}
 
-	_, err := c.Conn.Write(c.WriteBuffer.Bytes())
-	c.WriteBuffer.Reset()
+	return err
+}
+
+// WriteMessage writes a complete, already serialized message to the UA
+// without copying it through the write buffer, in a single datagram over
+// UDP or a single write to the stream. It is sent in turn with the messages
+// written concurrently with WriteTo and WriteMessage, so they are never
+// interleaved. The message is sent as is, without the Content-Length and
+// outbound middleware applied by WriteTo. It returns io.ErrClosedPipe if
+// the connection is closed.
+func (c *Conn) WriteMessage(b []byte) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.Closed {
+		return io.ErrClosedPipe
+	}
 
+	c.checkOversized(len(b))
+	err := c.writeRaw(b)
+	if err == nil {
+		atomic.AddUint64(&c.counters.messagesOut, 1)
+	}
+
+	return err
+}
+
+// checkOversized logs and counts a message of size bytes about to be sent
+// over UDP if it is larger than the configured UDPMTU.
+func (c *Conn) checkOversized(size int) {
+	mtu := c.config().udpMTU()
+	if !c.protocol().IsStream() && mtu > 0 && size > mtu {
+		atomic.AddUint64(&c.oversized, 1)
+		fmt.Println("warning: sending", size,
+			"byte UDP message larger than the MTU to", c.Address)
+	}
+}
+
+// writeRaw writes b directly to the underlying connection, bypassing the
+// write buffer.
+func (c *Conn) writeRaw(b []byte) error {
+	if c.packetConn != nil {
+		if c.config().PacketInfo {
+			if sent, err := c.writeFromDestination(b); sent {
+				c.countOut(len(b))
+				return err
+			}
+		}
+
+		c.countOut(len(b))
+		return c.protocol().WriteFrame(c.packetConn, c.Address, b)
+	}
+
+	n, err := c.Conn.Write(b)
+	c.countOut(n)
 	return err
 }
 
@@ -194,38 +699,143 @@ func (c *Conn) Addr() net.Addr {
 	return c.Address
 }
 
+// LocalAddr returns the local network address messages to the UA are
+// sent from. With PacketInfo configured, this is the address the last
+// datagram from the UA was received on.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.packetConn != nil {
+		addr, ok := c.packetConn.LocalAddr().(*net.UDPAddr)
+		if ip := c.receivedOn(); ip != nil && ok {
+			return &net.UDPAddr{IP: ip, Port: addr.Port}
+		}
+		return c.packetConn.LocalAddr()
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// RemoteHostPort returns the host (without brackets for IPv6) and port of
+// the connected UA. The port is zero if the address has no port.
+func (c *Conn) RemoteHostPort() (host string, port int) {
+	return splitAddr(c.Addr())
+}
+
+// LocalHostPort returns the host (without brackets for IPv6) and port
+// messages to the UA are sent from. The port is zero if the address has no
+// port.
+func (c *Conn) LocalHostPort() (host string, port int) {
+	return splitAddr(c.LocalAddr())
+}
+
+func splitAddr(addr net.Addr) (string, int) {
+	switch addr := addr.(type) {
+	case nil:
+		return "", 0
+	case *net.UDPAddr:
+		return addr.IP.String(), addr.Port
+	case *net.TCPAddr:
+		return addr.IP.String(), addr.Port
+	}
+
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return strings.Trim(addr.String(), "[]"), 0
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return host, 0
+	}
+	return host, port
+}
+
 // Close closes the connection.
 func (c *Conn) Close() error {
+	return c.closeWithError(nil)
+}
+
+// closeWithError closes the connection, recording the error which caused
+// it to close for the OnDisconnect callback of the listener, which is nil
+// if it was closed with Close.
+func (c *Conn) closeWithError(cause error) error {
+	c.writeMutex.Lock()
 	if c.Closed {
+		c.writeMutex.Unlock()
 		return nil
 	}
 
 	c.Closed = true
+	c.closeErr = cause
+	if c.done != nil {
+		close(c.done)
+	}
+	c.writeMutex.Unlock()
 
-	if c.Transport == "udp" {
+	if !c.protocol().IsStream() {
 		if c.Listener != nil {
-			c.Listener.udpPoolMutex.Lock()
-			delete(c.Listener.udpPool, c.Address.String())
-			c.Listener.udpPoolMutex.Unlock()
+			c.Listener.udpPool.remove(c.Address.String(), c)
 		}
+
+		// Nothing is queued on UdpReceiver once Closed is set.
 		close(c.UdpReceiver)
+
+		if c.Listener == nil || c.connected {
+			// The socket is not shared with a listener.
+			return c.Conn.Close()
+		}
+
 		return nil
 	}
 
+	if c.Listener != nil {
+		c.Listener.tcpConnsMutex.Lock()
+		delete(c.Listener.tcpConns, c.Address.String())
+		c.Listener.tcpConnsMutex.Unlock()
+		c.Listener.streamClosed()
+	}
+
 	return c.Conn.Close()
 }
 
 func (c *Conn) branchJanitor() {
-	for {
-		time.Sleep(time.Second * 10)
+	clock := c.clock()
+	ticker := clock.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+
+	for range ticker.C() {
 		if c.Closed {
 			return
 		}
 
+		now := clock.Now()
+
 		c.BranchMutex.Lock()
 		for branch, t := range c.ReceivedBranches {
-			if time.Now().Sub(t) > 30*time.Second {
+			if now.Sub(t) > transactionTimeout {
 				delete(c.ReceivedBranches, branch)
+				delete(c.responseCache, branch)
+			}
+		}
+		for key, cached := range c.responseCache {
+			// Responses are also expired by themselves, as the branches
+			// of a configured BranchStore are not expired here.
+			if now.Sub(cached.sent) > transactionTimeout {
+				delete(c.responseCache, key)
+			}
+		}
+		for key, t := range c.receivedResponses {
+			if now.Sub(t) > transactionTimeout {
+				delete(c.receivedResponses, key)
+			}
+		}
+		for key, first := range c.branchMethods {
+			if now.Sub(first.received) > transactionTimeout {
+				delete(c.branchMethods, key)
+			}
+		}
+		for key, ack := range c.sentAcks {
+			if now.Sub(ack.sent) > transactionTimeout {
+				delete(c.sentAcks, key)
 			}
 		}
 		c.BranchMutex.Unlock()
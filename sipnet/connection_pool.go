@@ -9,38 +9,66 @@ import (
 )
 
 func (l *Listener) getUDPConnFromPool(address net.Addr) *Conn {
-	l.udpPoolMutex.Lock()
-	defer l.udpPoolMutex.Unlock()
-	conn, found := l.udpPool[address.String()]
-	if !found {
-		conn = &Conn{
-			Transport:        "udp",
+	return l.getPacketConnFromPool(UDP, l.udpSender, address)
+}
+
+func (l *Listener) getPacketConnFromPool(t Transport, packetConn net.PacketConn,
+	address net.Addr) *Conn {
+	conn, created := l.udpPool.getOrCreate(address.String(), func() *Conn {
+		netConn, _ := packetConn.(net.Conn)
+		return &Conn{
+			Transport:        t.Name(),
 			Listener:         l,
-			Conn:             l.udpListener,
+			Conn:             netConn,
 			Address:          address,
-			UdpReceiver:      make(chan []byte),
+			UdpReceiver:      make(chan []byte, l.config.readQueueDepth()),
 			Closed:           false,
 			Locked:           false,
 			WriteBuffer:      new(bytes.Buffer),
-			ReadMessage:      make(chan interface{}),
-			LastMessage:      time.Now(),
+			ReadMessage:      make(chan interface{}, l.config.readQueueDepth()),
+			LastMessage:      l.config.clock().Now(),
 			ReceivedBranches: make(map[string]time.Time),
 			BranchMutex:      new(sync.Mutex),
+			transport:        t,
+			packetConn:       packetConn,
+			responseCache:    make(map[string]cachedResponse),
+			done:             make(chan struct{}),
 		}
+	})
 
-		l.udpPool[address.String()] = conn
-
+	if created {
+		l.notifyLifecycle(conn)
 		go conn.udpReader()
 		go conn.branchJanitor()
 		go l.readRequests(conn)
+
+		limit := l.config.MaxUDPConns
+		if limit > 0 && l.udpPool.len() > limit {
+			l.evictIdleUDPConn(conn)
+		}
 	}
 
 	return conn
 }
 
-func (l *Listener) registerTCPConn(netConn net.Conn) {
+// evictIdleUDPConn closes the pooled Conn which has gone the longest without
+// receiving a message, other than keep, to make room in the pool. Conns
+// which are locked by the user are not evicted.
+func (l *Listener) evictIdleUDPConn(keep *Conn) {
+	if oldest := l.udpPool.oldest(keep); oldest != nil {
+		oldest.Close()
+	}
+}
+
+// UDPConns returns the number of peers the listener currently has a pooled
+// UDP Conn for.
+func (l *Listener) UDPConns() int {
+	return l.udpPool.len()
+}
+
+func (l *Listener) registerStreamConn(t Transport, netConn net.Conn) *Conn {
 	conn := &Conn{
-		Transport:        "tcp",
+		Transport:        t.Name(),
 		Listener:         l,
 		Conn:             netConn,
 		Address:          netConn.RemoteAddr(),
@@ -48,48 +76,177 @@ func (l *Listener) registerTCPConn(netConn net.Conn) {
 		Closed:           false,
 		Locked:           false,
 		WriteBuffer:      new(bytes.Buffer),
-		ReadMessage:      make(chan interface{}),
+		ReadMessage:      make(chan interface{}, l.config.readQueueDepth()),
 		LastMessage:      time.Time{},
 		ReceivedBranches: make(map[string]time.Time),
 		BranchMutex:      new(sync.Mutex),
+		transport:        t,
+		responseCache:    make(map[string]cachedResponse),
+		done:             make(chan struct{}),
 	}
 
+	l.tcpConnsMutex.Lock()
+	l.tcpConns[conn.Address.String()] = conn
+	l.tcpConnsMutex.Unlock()
+	l.streamOpened()
+	l.notifyLifecycle(conn)
+
 	go conn.tcpReader()
 	go conn.branchJanitor()
 	go l.readRequests(conn)
+
+	return conn
+}
+
+// notifyLifecycle calls the OnConnect callback for a Conn opened by the
+// listener, and then the OnDisconnect callback once it closes, from a
+// goroutine of their own.
+func (l *Listener) notifyLifecycle(conn *Conn) {
+	if l.config.OnConnect == nil && l.config.OnDisconnect == nil {
+		return
+	}
+
+	go func() {
+		if l.config.OnConnect != nil {
+			l.config.OnConnect(conn)
+		}
+
+		<-conn.done
+		if l.config.OnDisconnect != nil {
+			l.config.OnDisconnect(conn, conn.closeErr)
+		}
+	}()
+}
+
+// ReplyConn returns the Conn a response to req should be written to, based
+// on the address and transport the request was received over. For UDP and
+// other packet transports, this recreates the pooled Conn for the address
+// if it has since been removed, so stateless servers can reply without
+// holding on to the original Conn. It returns nil if the request was not
+// received by the listener, or if it was received over a stream transport
+// and the connection has since been closed.
+func (l *Listener) ReplyConn(req *Request) *Conn {
+	if req.RemoteAddr == nil {
+		return nil
+	}
+
+	t := req.transport
+	if t == nil {
+		t = transportByName(req.RemoteAddr.Network())
+	}
+	if t == nil {
+		return nil
+	}
+
+	if t.IsStream() {
+		l.tcpConnsMutex.Lock()
+		defer l.tcpConnsMutex.Unlock()
+		conn := l.tcpConns[req.RemoteAddr.String()]
+		if conn == nil || conn.protocol().Name() != t.Name() {
+			return nil
+		}
+		return conn
+	}
+
+	sender := l.packetSender(t)
+	if sender == nil {
+		return nil
+	}
+
+	conn := l.getPacketConnFromPool(t, sender, req.RemoteAddr)
+	if conn.protocol().Name() != t.Name() {
+		// The peer uses the same address with another packet transport.
+		return nil
+	}
+	return conn
+}
+
+// packetSender returns the socket messages are sent from over a packet
+// transport, or nil if the listener doesn't listen with it.
+func (l *Listener) packetSender(t Transport) net.PacketConn {
+	if t.Name() == UDP.Name() {
+		return l.udpSender
+	}
+
+	l.transportsMutex.Lock()
+	defer l.transportsMutex.Unlock()
+	return l.packetSenders[t.Name()]
+}
+
+// Connections returns a snapshot of the active connections of the listener,
+// which includes both UDP peers and TCP connections.
+func (l *Listener) Connections() []*Conn {
+	conns := l.udpPool.all()
+
+	l.tcpConnsMutex.Lock()
+	for _, conn := range l.tcpConns {
+		conns = append(conns, conn)
+	}
+	l.tcpConnsMutex.Unlock()
+
+	return conns
+}
+
+// CloseAll closes all active connections of the listener. The listener
+// itself remains open and continues to accept new connections.
+func (l *Listener) CloseAll() {
+	for _, conn := range l.Connections() {
+		conn.Close()
+	}
 }
 
 func (l *Listener) readRequests(conn *Conn) {
+	defer conn.recoverHandler()
+
 	for {
 		req, err := conn.readRequest()
+		if err == io.EOF {
+			// The connection closed, which is not an error of the
+			// listener.
+			return
+		}
+
+		if err == nil && l.answerOptions(req, conn) {
+			continue
+		}
 
 		l.requestChannel <- requestPackage{
 			conn: conn,
 			req:  req,
 			err:  err,
 		}
+	}
+}
 
-		if err == io.EOF {
-			return
-		}
+// closeUDPPool closes all pooled UDP connections. It must be called once the
+// underlying UDP socket can no longer be read from, otherwise the readers of
+// the pooled connections would wait forever.
+func (l *Listener) closeUDPPool() {
+	for _, conn := range l.udpPool.all() {
+		conn.Close()
 	}
 }
 
 func (l *Listener) udpJanitor() {
-	for {
-		time.Sleep(time.Second * 10)
-
-		var markClose []*Conn
-		l.udpPoolMutex.Lock()
-		for _, conn := range l.udpPool {
-			if time.Now().Sub(conn.LastMessage) > time.Second*30 {
-				markClose = append(markClose, conn)
-			}
+	clock := l.config.clock()
+	ticker := clock.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		if l.closed {
+			return
 		}
-		l.udpPoolMutex.Unlock()
 
-		for _, conn := range markClose {
+		for _, conn := range l.udpPool.idle(clock.Now().Add(-time.Second * 30)) {
 			conn.Close()
 		}
+
+		if l.limiter != nil {
+			l.limiter.expire()
+		}
+
+		if l.callIDs != nil {
+			l.callIDs.expire()
+		}
 	}
 }
@@ -0,0 +1,241 @@
+package sipnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPSocketErrorClosesPool(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodOptions, "z9hG4bKsocket"))
+	_, conn := acceptRequest(t, l)
+	if n := l.UDPConns(); n != 1 {
+		t.Fatalf("pool has %d conns, expected 1", n)
+	}
+
+	// Closing the socket underneath the listener fails its next read.
+	l.udpListener.Close()
+	if _, _, err := l.AcceptRequest(); err == nil {
+		t.Fatal("socket error was not returned by AcceptRequest")
+	}
+
+	waitFor(t, "the pool to be emptied", func() bool {
+		return l.UDPConns() == 0
+	})
+	select {
+	case <-conn.done:
+	case <-time.After(testTimeout):
+		t.Error("pooled conn was not closed after the socket failed")
+	}
+}
+
+func TestCloseAllConnections(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+
+	for i := 0; i < 2; i++ {
+		peer := udpPeer(t)
+		defer peer.Close()
+		sendUDP(t, peer, l, testRequest(MethodOptions, "z9hG4bKpeer"))
+		acceptRequest(t, l)
+	}
+
+	tcpPeer, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer tcpPeer.Close()
+
+	waitFor(t, "3 connections", func() bool {
+		return len(l.Connections()) == 3
+	})
+
+	var udp, tcp int
+	for _, conn := range l.Connections() {
+		switch conn.Transport {
+		case "udp":
+			udp++
+		case "tcp":
+			tcp++
+		}
+	}
+	if udp != 2 || tcp != 1 {
+		t.Errorf("enumerated %d UDP and %d TCP conns, expected 2 and 1", udp, tcp)
+	}
+
+	l.CloseAll()
+	if n := len(l.Connections()); n != 0 {
+		t.Errorf("%d connections remain after CloseAll", n)
+	}
+
+	tcpPeer.SetReadDeadline(time.Now().Add(testTimeout))
+	_, err = tcpPeer.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+		t.Error("TCP connection is still open after CloseAll")
+	}
+}
+
+func TestReplyConnRecreatesPoolEntry(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodOptions, "z9hG4bKreply"))
+	req, conn := acceptRequest(t, l)
+	conn.Close()
+	if n := l.UDPConns(); n != 0 {
+		t.Fatalf("pool has %d conns after closing, expected none", n)
+	}
+
+	reply := l.ReplyConn(req)
+	if reply == nil || reply == conn {
+		t.Fatal("ReplyConn didn't recreate the pooled conn")
+	}
+	if err := <-respond(reply, req, StatusOK, "b1"); err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+	if resp, _ := readUDP(t, peer); startLine(resp) != "SIP/2.0 200 OK" {
+		t.Errorf("peer received %q, expected the 200", startLine(resp))
+	}
+}
+
+func TestReplyConnMatchesTransport(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+	sendUDP(t, peer, l, testRequest(MethodOptions, "z9hG4bKudp"))
+	udpReq, udpConn := acceptRequest(t, l)
+
+	// Connect over TCP from the same address as the UDP peer.
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: peer.LocalAddr().(*net.UDPAddr).Port,
+	}}
+	tcpPeer, err := dialer.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer tcpPeer.Close()
+	tcpPeer.Write([]byte(testRequest(MethodOptions, "z9hG4bKtcp")))
+	tcpReq, tcpConn := acceptRequest(t, l)
+
+	if reply := l.ReplyConn(udpReq); reply != udpConn {
+		t.Error("ReplyConn of the UDP request isn't its UDP conn")
+	}
+	if reply := l.ReplyConn(tcpReq); reply != tcpConn {
+		t.Error("ReplyConn of the TCP request isn't its TCP conn")
+	}
+
+	tcpConn.Close()
+	if reply := l.ReplyConn(tcpReq); reply != nil {
+		t.Errorf("ReplyConn of a closed TCP conn is a %s conn, expected nil",
+			reply.Transport)
+	}
+}
+
+func TestUDPPoolEvictsOldest(t *testing.T) {
+	l := listenTest(t, Config{MaxUDPConns: 2})
+	defer l.Close()
+
+	var conns []*Conn
+	for i := 0; i < 3; i++ {
+		peer := udpPeer(t)
+		defer peer.Close()
+		sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKevict"))
+		_, conn := acceptRequest(t, l)
+		conns = append(conns, conn)
+
+		// The peers are told apart by when they last sent a message.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-conns[0].done:
+	case <-time.After(testTimeout):
+		t.Fatal("the oldest conn wasn't evicted")
+	}
+	waitFor(t, "the pool to shrink", func() bool {
+		return l.UDPConns() == 2
+	})
+	for _, conn := range conns[1:] {
+		if conn.Closed {
+			t.Errorf("evicted %v, expected only the oldest conn", conn.Addr())
+		}
+	}
+}
+
+func TestLifecycleCallbacks(t *testing.T) {
+	tests := []struct {
+		name      string
+		peerClose bool
+		expected  error
+	}{
+		{"peer closes", true, io.EOF},
+		{"closed locally", false, nil},
+	}
+
+	for _, test := range tests {
+		connected := make(chan *Conn, 1)
+		type disconnect struct {
+			conn *Conn
+			err  error
+		}
+		disconnected := make(chan disconnect, 1)
+
+		l := listenTest(t, Config{
+			OnConnect: func(conn *Conn) {
+				connected <- conn
+			},
+			OnDisconnect: func(conn *Conn, err error) {
+				disconnected <- disconnect{conn, err}
+			},
+		})
+		remote := dialListener(t, l)
+
+		var conn *Conn
+		select {
+		case conn = <-connected:
+		case <-time.After(testTimeout):
+			t.Fatalf("%s: OnConnect wasn't called", test.name)
+		}
+		select {
+		case <-disconnected:
+			t.Fatalf("%s: OnDisconnect was called while connected", test.name)
+		case <-time.After(quietTimeout):
+		}
+
+		if test.peerClose {
+			remote.Close()
+		} else {
+			conn.Close()
+		}
+
+		select {
+		case d := <-disconnected:
+			if d.conn != conn {
+				t.Errorf("%s: OnDisconnect was called with another conn",
+					test.name)
+			}
+			if d.err != test.expected {
+				t.Errorf("%s: OnDisconnect was called with %v, expected %v",
+					test.name, d.err, test.expected)
+			}
+		case <-time.After(testTimeout):
+			t.Errorf("%s: OnDisconnect wasn't called", test.name)
+		}
+
+		remote.Close()
+		l.Close()
+	}
+}
@@ -0,0 +1,710 @@
+package sipnet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   OverflowPolicy
+		expected []string
+	}{
+		{"drop newest", OverflowDropNewest, []string{"sip:1", "sip:2"}},
+		{"drop oldest", OverflowDropOldest, []string{"sip:2", "sip:3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &Conn{
+				Listener:    &Listener{config: &Config{OverflowPolicy: test.policy}},
+				ReadMessage: make(chan interface{}, 2),
+			}
+
+			for _, server := range []string{"sip:1", "sip:2", "sip:3"} {
+				conn.deliver(&Request{Method: MethodMessage, Server: server})
+			}
+
+			if dropped := conn.Dropped(); dropped != 1 {
+				t.Errorf("dropped %d messages, expected 1", dropped)
+			}
+			for _, expected := range test.expected {
+				if req := (<-conn.ReadMessage).(*Request); req.Server != expected {
+					t.Errorf("read %s, expected %s", req.Server, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestReadQueueOverflowBlock(t *testing.T) {
+	conn := &Conn{
+		Listener:    &Listener{config: &Config{OverflowPolicy: OverflowBlock}},
+		ReadMessage: make(chan interface{}, 1),
+	}
+
+	conn.deliver(&Request{Method: MethodMessage, Server: "sip:1"})
+	delivered := make(chan bool, 1)
+	go func() {
+		delivered <- conn.deliver(&Request{Method: MethodMessage, Server: "sip:2"})
+	}()
+
+	select {
+	case <-delivered:
+		t.Fatal("delivered to a full queue without blocking")
+	case <-time.After(quietTimeout):
+	}
+
+	<-conn.ReadMessage
+	if !<-delivered {
+		t.Error("message was not delivered once the queue had room")
+	}
+	if dropped := conn.Dropped(); dropped != 0 {
+		t.Errorf("dropped %d messages, expected none", dropped)
+	}
+}
+
+func TestKeepAliveResponse(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	writePipe(t, remote, "\r\n\r\n")
+	if pong := readPipe(t, remote); pong != "\r\n" {
+		t.Errorf("answered keep-alive with %q, expected CRLF", pong)
+	}
+	expectNoMessage(t, conn)
+}
+
+func TestKeepAliveResponseDisabled(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+	conn.DisableKeepAliveResponse = true
+
+	writePipe(t, remote, "\r\n\r\n")
+	if msg := readConn(t, conn); msg != (KeepAlive{}) {
+		t.Errorf("read %#v, expected a KeepAlive", msg)
+	}
+	expectNoPipeData(t, remote)
+}
+
+func TestKeepAliveBetweenStreamMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled bool
+	}{
+		{"answered", false},
+		{"delivered", true},
+	}
+
+	for _, test := range tests {
+		conn, remote := NewPipeConn("tcp")
+		conn.DisableKeepAliveResponse = test.disabled
+
+		written := goWrite(func() error {
+			remote.SetWriteDeadline(time.Now().Add(testTimeout))
+			_, err := remote.Write([]byte(tcpRequest(MethodMessage,
+				"z9hG4bKfirst") + "\r\n\r\n" +
+				tcpRequest(MethodMessage, "z9hG4bKsecond")))
+			return err
+		})
+
+		first := readRequest(t, conn)
+		if test.disabled {
+			if msg := readConn(t, conn); msg != (KeepAlive{}) {
+				t.Errorf("%s: read %#v, expected a KeepAlive", test.name, msg)
+			}
+		} else if pong := readPipe(t, remote); pong != "\r\n" {
+			t.Errorf("%s: answered the ping with %q, expected CRLF",
+				test.name, pong)
+		}
+		second := readRequest(t, conn)
+		if err := <-written; err != nil {
+			t.Fatalf("%s: failed to write: %v", test.name, err)
+		}
+
+		for i, req := range []*Request{first, second} {
+			branch := []string{"z9hG4bKfirst", "z9hG4bKsecond"}[i]
+			if via := req.Header.Get("Via"); !strings.Contains(via, branch) {
+				t.Errorf("%s: request %d has Via %q", test.name, i+1, via)
+			}
+		}
+		if test.disabled {
+			expectNoPipeData(t, remote)
+		}
+		conn.Close()
+		remote.Close()
+	}
+}
+
+func TestUDPSourcePort(t *testing.T) {
+	port := freeUDPPort(t)
+	l := listenTest(t, Config{UDPSourcePort: port})
+	defer l.Close()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodOptions, "z9hG4bKsource"))
+	req, conn := acceptRequest(t, l)
+	if _, local := conn.LocalHostPort(); local != port {
+		t.Errorf("conn has local port %d, expected %d", local, port)
+	}
+
+	if err := <-respond(conn, req, StatusOK, "b1"); err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+	_, from := readUDP(t, peer)
+	if source := from.(*net.UDPAddr).Port; source != port {
+		t.Errorf("response sent from port %d, expected %d", source, port)
+	}
+}
+
+func TestReadWithoutLock(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bK1"))
+	_, conn := acceptRequest(t, l)
+
+	if msg := conn.Read(); msg != ErrNotLocked {
+		t.Errorf("Read returned %v, expected ErrNotLocked", msg)
+	}
+	if msg, err := conn.ReadTimeout(quietTimeout); err != ErrNotLocked {
+		t.Errorf("ReadTimeout returned %v, %v, expected ErrNotLocked", msg, err)
+	}
+	if _, err := conn.Do(newTestRequest(MethodOptions, "sip:127.0.0.1")); err != ErrNotLocked {
+		t.Errorf("Do returned %v, expected ErrNotLocked", err)
+	}
+
+	// The next request isn't lost to the failed reads.
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bK2"))
+	req, _ := acceptRequest(t, l)
+	if req.Header.Get("Via") != "SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bK2" {
+		t.Errorf("accepted %q, expected the second request",
+			req.Header.Get("Via"))
+	}
+}
+
+func TestPanicInResponseHandlerRecovered(t *testing.T) {
+	l := listenTest(t, Config{
+		UnhandledResponse: func(resp *Response, conn *Conn) {
+			panic("handler failed")
+		},
+	})
+	defer l.Close()
+	faulty := udpPeer(t)
+	defer faulty.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	// A request is accepted first, so the pooled conn of the faulty peer
+	// is known.
+	sendUDP(t, faulty, l, testRequest(MethodMessage, "z9hG4bKfirst"))
+	_, faultyConn := acceptRequest(t, l)
+
+	req := parseRequest(t, testRequest(MethodOptions, "z9hG4bKunsolicited"))
+	sendUDP(t, faulty, l, testResponse(req, "200 OK"))
+	waitFor(t, "the conn of the panicking handler to close", func() bool {
+		return faultyConn.Closed
+	})
+
+	// The listener still serves other peers, and the faulty peer again.
+	for _, p := range []net.PacketConn{peer, faulty} {
+		sendUDP(t, p, l, testRequest(MethodMessage, "z9hG4bKafter"))
+		_, conn := acceptRequest(t, l)
+		if conn.Address.String() != p.LocalAddr().String() {
+			t.Errorf("accepted request from %v, expected %v", conn.Address,
+				p.LocalAddr())
+		}
+	}
+}
+
+func TestHalfCloseDeliversLastRequest(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+
+	addr, err := net.ResolveTCPAddr("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to resolve: %v", err)
+	}
+	peer, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer peer.Close()
+
+	// The request and the FIN arrive together.
+	peer.Write([]byte(testRequest(MethodMessage, "z9hG4bKfin")))
+	peer.CloseWrite()
+
+	req, _ := acceptRequest(t, l)
+	if req.Method != MethodMessage {
+		t.Errorf("accepted %s, expected MESSAGE", req.Method)
+	}
+
+	// The connection is then closed.
+	peer.SetReadDeadline(time.Now().Add(testTimeout))
+	if n, err := peer.Read(make([]byte, 1)); err == nil {
+		t.Errorf("read %d bytes, expected the connection to be closed", n)
+	}
+}
+
+func TestConnValues(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	type sessionKey struct{}
+
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKvalue1"))
+	_, conn := acceptRequest(t, l)
+	if value := conn.Get(sessionKey{}); value != nil {
+		t.Errorf("new connection has value %v", value)
+	}
+	conn.Set(sessionKey{}, "authenticated")
+	conn.Unlock()
+
+	// The value is still there when the next message is read.
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKvalue2"))
+	_, next := acceptRequest(t, l)
+	if next != conn {
+		t.Fatal("next request was accepted on a different connection")
+	}
+	if value := next.Get(sessionKey{}); value != "authenticated" {
+		t.Errorf("got %v, expected \"authenticated\"", value)
+	}
+}
+
+// stringAddr is a net.Addr of another network with the given address.
+type stringAddr string
+
+func (a stringAddr) Network() string { return "test" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestSplitAddr(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		host string
+		port int
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5060}, "192.0.2.1", 5060},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5061}, "2001:db8::1", 5061},
+		{stringAddr("192.0.2.1:5060"), "192.0.2.1", 5060},
+		{stringAddr("[2001:db8::1]:5060"), "2001:db8::1", 5060},
+		{stringAddr("[2001:db8::1]"), "2001:db8::1", 0},
+		{stringAddr("example.com"), "example.com", 0},
+		{nil, "", 0},
+	}
+
+	for _, test := range tests {
+		host, port := splitAddr(test.addr)
+		if host != test.host || port != test.port {
+			t.Errorf("split %v into %q, %d, expected %q, %d", test.addr,
+				host, port, test.host, test.port)
+		}
+	}
+}
+
+func TestConnHostPort(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodOptions, "z9hG4bKhostport"))
+	_, conn := acceptRequest(t, l)
+
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+	if host, port := conn.RemoteHostPort(); host != "127.0.0.1" ||
+		port != peerAddr.Port {
+		t.Errorf("remote is %s:%d, expected 127.0.0.1:%d", host, port,
+			peerAddr.Port)
+	}
+
+	localAddr := l.TransportAddr("udp").(*net.UDPAddr)
+	if host, port := conn.LocalHostPort(); host != "127.0.0.1" ||
+		port != localAddr.Port {
+		t.Errorf("local is %s:%d, expected 127.0.0.1:%d", host, port,
+			localAddr.Port)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+
+	start := time.Now()
+	if msg, err := conn.ReadTimeout(quietTimeout); err != ErrTimeout {
+		t.Fatalf("read %v, %v, expected ErrTimeout", msg, err)
+	}
+	if elapsed := time.Since(start); elapsed < quietTimeout {
+		t.Errorf("timed out after %v, expected at least %v", elapsed,
+			quietTimeout)
+	}
+
+	// A message received before the timeout is returned.
+	writePipe(t, remote, testRequest(MethodOptions, "z9hG4bKtimeout"))
+	if msg, err := conn.ReadTimeout(testTimeout); err != nil {
+		t.Errorf("failed to read: %v", err)
+	} else if req, ok := msg.(*Request); !ok || req.Method != MethodOptions {
+		t.Errorf("read %v, expected the OPTIONS", msg)
+	}
+
+	conn.Close()
+	if _, err := conn.ReadTimeout(testTimeout); err != io.EOF {
+		t.Errorf("read from a closed connection returned %v, expected io.EOF",
+			err)
+	}
+}
+
+func TestConcurrentWritesNotInterleaved(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	const senders = 8
+	const messages = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*messages)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(sender int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				id := fmt.Sprintf("%d-%d", sender, j)
+				req := newTestRequest(MethodMessage, "sip:"+id+"@127.0.0.1")
+				req.Header.Set("Content-Type", "text/plain")
+				req.Body = []byte("message " + id)
+				if err := req.WriteTo(conn); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+
+	// Each message must be parsed whole, with the body of its own
+	// Request-URI.
+	rd := bufio.NewReader(remote)
+	remote.SetReadDeadline(time.Now().Add(testTimeout))
+	seen := make(map[string]bool)
+	for i := 0; i < senders*messages; i++ {
+		req, err := ReadRequestBuffered(rd)
+		if err != nil {
+			t.Fatalf("failed to parse message %d: %v", i, err)
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(req.Server, "sip:"),
+			"@127.0.0.1")
+		if string(req.Body) != "message "+id {
+			t.Fatalf("message to %s has body %q", req.Server, req.Body)
+		}
+		if seen[id] {
+			t.Fatalf("message to %s received twice", req.Server)
+		}
+		seen[id] = true
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("failed to write: %v", err)
+	}
+}
+
+func TestConcurrentWriteMessageNotInterleaved(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	const senders = 8
+	const messages = 20
+
+	// Half of the senders write serialized messages, and the others write
+	// with WriteTo through the write buffer.
+	serialized := make([][]byte, senders*messages)
+	requests := make([]*Request, senders*messages)
+	for i := range requests {
+		id := fmt.Sprintf("%d-%d", i/messages, i%messages)
+		req := newTestRequest(MethodMessage, "sip:"+id+"@127.0.0.1")
+		req.Header.Set("Content-Type", "text/plain")
+		req.Body = []byte("message " + id)
+		requests[i] = req
+		if i/messages%2 == 0 {
+			serialized[i] = []byte(writeRequest(t, req))
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*messages)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(sender int) {
+			defer wg.Done()
+			for j := sender * messages; j < (sender+1)*messages; j++ {
+				var err error
+				if serialized[j] != nil {
+					err = conn.WriteMessage(serialized[j])
+				} else {
+					err = requests[j].WriteTo(conn)
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+
+	rd := bufio.NewReader(remote)
+	remote.SetReadDeadline(time.Now().Add(testTimeout))
+	seen := make(map[string]bool)
+	for i := 0; i < senders*messages; i++ {
+		req, err := ReadRequestBuffered(rd)
+		if err != nil {
+			t.Fatalf("failed to parse message %d: %v", i, err)
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(req.Server, "sip:"),
+			"@127.0.0.1")
+		if string(req.Body) != "message "+id || seen[id] {
+			t.Fatalf("message to %s has body %q, seen before %v", req.Server,
+				req.Body, seen[id])
+		}
+		seen[id] = true
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("failed to write: %v", err)
+	}
+	if sent := conn.Stats().MessagesOut; sent != senders*messages {
+		t.Errorf("counted %d messages sent, expected %d", sent,
+			senders*messages)
+	}
+
+	conn.Close()
+	if err := conn.WriteMessage(serialized[0]); err != io.ErrClosedPipe {
+		t.Errorf("writing to a closed connection returned %v", err)
+	}
+}
+
+func TestWriteMessageSingleDatagram(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Unlock()
+
+	req := newTestRequest(MethodMessage, "sip:bob@127.0.0.1")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Body = []byte(strings.Repeat("x", 4000))
+	msg := writeRequest(t, req)
+	if err := conn.WriteMessage([]byte(msg)); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if data, _ := readUDP(t, peer); data != msg {
+		t.Errorf("received %d bytes, expected the %d byte message",
+			len(data), len(msg))
+	}
+}
+
+func TestOversizedUDPMessage(t *testing.T) {
+	l := listenTest(t, Config{UDPMTU: 500})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Unlock()
+
+	small := newTestRequest(MethodMessage, "sip:bob@127.0.0.1")
+	if err := small.WriteTo(conn); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	readUDP(t, peer)
+	if oversized := conn.Oversized(); oversized != 0 {
+		t.Errorf("counted %d oversized messages, expected none", oversized)
+	}
+
+	// The oversized message is still sent.
+	large := newTestRequest(MethodMessage, "sip:bob@127.0.0.1")
+	large.Header.Set("Content-Type", "text/plain")
+	large.Body = []byte(strings.Repeat("x", 1000))
+	if err := large.WriteTo(conn); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if data, _ := readUDP(t, peer); len(data) <= 1000 {
+		t.Errorf("received %d bytes, expected the whole message", len(data))
+	}
+	if oversized := conn.Oversized(); oversized != 1 {
+		t.Errorf("counted %d oversized messages, expected 1", oversized)
+	}
+}
+
+func TestBodylessContentLength(t *testing.T) {
+	tests := []struct {
+		transport string
+		omit      bool
+		expected  bool
+	}{
+		{"udp", false, true},
+		{"udp", true, false},
+		{"tcp", false, true},
+		{"tcp", true, true},
+	}
+
+	for _, test := range tests {
+		l := listenTest(t, Config{OmitUDPContentLength: test.omit})
+		defer l.Close()
+
+		var data string
+		if test.transport == "udp" {
+			peer := udpPeer(t)
+			defer peer.Close()
+			conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			newTestRequest(MethodMessage, "sip:bob@127.0.0.1").WriteTo(conn)
+			conn.Unlock()
+			data, _ = readUDP(t, peer)
+		} else {
+			server, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen: %v", err)
+			}
+			defer server.Close()
+			conn, err := l.DialConn(server.Addr().String(), "tcp")
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+			newTestRequest(MethodMessage, "sip:bob@127.0.0.1").WriteTo(conn)
+
+			peer, err := server.Accept()
+			if err != nil {
+				t.Fatalf("failed to accept: %v", err)
+			}
+			defer peer.Close()
+			buf := make([]byte, 65535)
+			peer.SetReadDeadline(time.Now().Add(testTimeout))
+			n, err := peer.Read(buf)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			data = string(buf[:n])
+		}
+
+		if found := strings.Contains(data, "\r\nContent-Length: 0\r\n"); found != test.expected {
+			t.Errorf("%s with OmitUDPContentLength %v: Content-Length: 0 "+
+				"sent is %v, expected %v", test.transport, test.omit, found,
+				test.expected)
+		}
+	}
+}
+
+func TestStreamMessageOneByteAtATime(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	msg := strings.Replace(testRequest(MethodMessage, "z9hG4bKbytes",
+		"Content-Type: text/plain"), "Content-Length: 0\r\n\r\n",
+		"Content-Length: 11\r\n\r\nhello world", 1)
+
+	// Keep-alives sent before the message are skipped.
+	data := "\r\n\r\n" + msg
+	half := len(data) / 2
+	for i := 0; i < half; i++ {
+		writePipe(t, remote, data[i:i+1])
+	}
+
+	// Nothing is delivered until the whole message has arrived.
+	expectNoMessage(t, conn)
+
+	written := goWrite(func() error {
+		for i := half; i < len(data); i++ {
+			if _, err := remote.Write([]byte{data[i]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	req := readRequest(t, conn)
+	if err := <-written; err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if req.Method != MethodMessage || string(req.Body) != "hello world" {
+		t.Errorf("read %s with body %q, expected the MESSAGE", req.Method,
+			req.Body)
+	}
+	if branch := req.Header.Get("Via"); !strings.HasSuffix(branch, "branch=z9hG4bKbytes") {
+		t.Errorf("read Via %q", branch)
+	}
+}
+
+func TestWriteRacingClose(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer remote.Close()
+	go io.Copy(ioutil.Discard, remote)
+
+	const writers = 4
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := []byte("keep-alive\r\n\r\n")
+			for {
+				n, err := conn.Write(msg)
+				if err == nil {
+					err = conn.Flush()
+				}
+				if err == io.ErrClosedPipe {
+					break
+				} else if err != nil || n != len(msg) {
+					errs <- fmt.Errorf("wrote %d bytes: %v", n, err)
+					return
+				}
+			}
+
+			// Once closed, the connection stays closed.
+			if _, err := conn.Write([]byte("late")); err != io.ErrClosedPipe {
+				errs <- fmt.Errorf("write after close returned %v", err)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := conn.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
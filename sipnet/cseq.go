@@ -0,0 +1,35 @@
+package sipnet
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CSeq represents the contents of the CSeq header line.
+type CSeq struct {
+	Sequence uint32
+	Method   string
+}
+
+// ParseCSeq parses a given CSeq header value into a CSeq.
+func ParseCSeq(str string) (CSeq, error) {
+	fields := strings.Fields(str)
+	if len(fields) != 2 {
+		return CSeq{}, ErrParseError
+	}
+
+	seq, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return CSeq{}, ErrParseError
+	}
+
+	return CSeq{
+		Sequence: uint32(seq),
+		Method:   strings.ToUpper(fields[1]),
+	}, nil
+}
+
+// String returns the string representation of the CSeq header line.
+func (c CSeq) String() string {
+	return strconv.FormatUint(uint64(c.Sequence), 10) + " " + c.Method
+}
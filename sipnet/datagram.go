@@ -0,0 +1,100 @@
+package sipnet
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// ErrContentLength is read from a Conn as the Err of a MessageError if the
+// Content-Length of a message received over UDP is larger than its body,
+// which indicates a truncated or forged datagram. It is also
+// returned by the parser if the Content-Length is malformed, or the body
+// ends before it, and read from a Conn if a message received over a stream
+// transport has no Content-Length to frame it.
+var ErrContentLength = errors.New("sip: content length mismatch")
+
+// checkDatagramLength compares the Content-Length of a received datagram to
+// the size of the body following its header, returning the datagram to be
+// parsed (RFC 3261 section 18.3). Bytes past the Content-Length are
+// discarded, and a missing Content-Length is added, as the datagram defines
+// the end of the message. A body shorter than its Content-Length, or a
+// malformed Content-Length, is rejected with ErrContentLength, unless
+// LooseUDPContentLength is configured, in which case the Content-Length is
+// replaced with the size of the body.
+func (c *Conn) checkDatagramLength(data []byte) ([]byte, error) {
+	startLine, headerBlock, body, err := SplitMessage(data)
+	if err != nil {
+		// Leave the error to the parser.
+		return data, nil
+	}
+
+	found := false
+	declared := -1
+	s := NewHeaderScanner(data)
+	for s.Scan() {
+		if s.Is("Content-Length") {
+			found = true
+			declared, err = strconv.Atoi(string(s.Value()))
+			if err != nil {
+				declared = -1
+			}
+		}
+	}
+
+	if found && declared == len(body) {
+		return data, nil
+	}
+
+	if found && declared >= 0 && declared < len(body) {
+		// The body is the end of the datagram, so the trailing bytes are
+		// cut from it.
+		return data[:len(data)-len(body)+declared], nil
+	}
+
+	if found && !c.config().LooseUDPContentLength {
+		return nil, ErrContentLength
+	}
+
+	return JoinMessage(startLine, withContentLength(headerBlock, len(body)),
+		body), nil
+}
+
+// checkStreamLength returns ErrContentLength if a message received over a
+// stream transport has no Content-Length, as the end of its body can't be
+// known (RFC 3261 section 18.3). A lenient parser accepts it as having no
+// body, recording a warning instead.
+func (c *Conn) checkStreamLength(h Header, warnings *[]string) error {
+	if _, ok, _ := contentLength(h); ok {
+		return nil
+	}
+
+	if c.config().Parser.Lenient {
+		*warnings = append(*warnings, "missing Content-Length")
+		return nil
+	}
+
+	return ErrContentLength
+}
+
+// withContentLength returns a header block with its Content-Length
+// replaced by length.
+func withContentLength(headerBlock []byte, length int) []byte {
+	buf := new(bytes.Buffer)
+	for _, line := range bytes.Split(headerBlock, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		colon := bytes.IndexByte(line, ':')
+		if colon >= 0 && normalizeKey(string(bytes.TrimSpace(line[:colon]))) ==
+			"Content-Length" {
+			continue
+		}
+
+		if len(line) > 0 {
+			buf.Write(line)
+			buf.WriteString("\r\n")
+		}
+	}
+
+	buf.WriteString("Content-Length: " + strconv.Itoa(length))
+	return buf.Bytes()
+}
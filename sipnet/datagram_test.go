@@ -0,0 +1,91 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+)
+
+// datagramWithLength returns a request with the body "hello" and the given
+// Content-Length line, if any.
+func datagramWithLength(contentLength string) []byte {
+	msg := strings.TrimSuffix(testRequest(MethodMessage, "z9hG4bKlength"),
+		"Content-Length: 0\r\n\r\n")
+	if contentLength != "" {
+		msg += contentLength + "\r\n"
+	}
+	return []byte(msg + "\r\nhello")
+}
+
+func TestCheckDatagramLength(t *testing.T) {
+	strict, _ := NewPipeConn("udp")
+	defer strict.Close()
+	l := listenTest(t, Config{LooseUDPContentLength: true})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+	loose, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		contentLength string
+		strictBody    string
+		looseBody     string
+	}{
+		{"matching", "Content-Length: 5", "hello", "hello"},
+		{"under-declared", "Content-Length: 3", "hel", "hel"},
+		{"over-declared", "Content-Length: 9", "", "hello"},
+		{"malformed", "Content-Length: five", "", "hello"},
+		{"missing", "", "hello", "hello"},
+	}
+
+	for _, test := range tests {
+		for _, mode := range []struct {
+			name     string
+			conn     *Conn
+			expected string
+		}{
+			{"strict", strict, test.strictBody},
+			{"loose", loose, test.looseBody},
+		} {
+			data, err := mode.conn.checkDatagramLength(
+				datagramWithLength(test.contentLength))
+			if mode.expected == "" {
+				if err != ErrContentLength {
+					t.Errorf("%s %s: got %v, expected ErrContentLength",
+						mode.name, test.name, err)
+				}
+				continue
+			} else if err != nil {
+				t.Errorf("%s %s: failed: %v", mode.name, test.name, err)
+				continue
+			}
+
+			req := parseRequest(t, string(data))
+			if string(req.Body) != mode.expected {
+				t.Errorf("%s %s: body is %q, expected %q", mode.name,
+					test.name, req.Body, mode.expected)
+			}
+		}
+	}
+}
+
+func TestOverDeclaredDatagramRejected(t *testing.T) {
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	peer.WriteTo(datagramWithLength("Content-Length: 9"), conn.LocalAddr())
+	_, err = conn.ReadTimeout(testTimeout)
+	if msgErr, ok := err.(*MessageError); !ok || msgErr.Err != ErrContentLength {
+		t.Errorf("read error %v, expected a *MessageError of ErrContentLength",
+			err)
+	}
+}
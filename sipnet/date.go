@@ -0,0 +1,73 @@
+package sipnet
+
+import (
+	"fmt"
+	"time"
+)
+
+// DateFormat is the format of the Date header (RFC 3261 section 20.17),
+// which is an RFC 1123 date that is always in GMT.
+const DateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Date returns the time of the Date header.
+func (h Header) Date() (time.Time, error) {
+	value := h.Get("Date")
+	if value == "" {
+		return time.Time{}, ErrParseError
+	}
+
+	t, err := time.Parse(time.RFC1123, value)
+	if err != nil {
+		return time.Time{}, ErrParseError
+	}
+
+	return t, nil
+}
+
+// SetDate sets the Date header to the given time.
+func (h Header) SetDate(t time.Time) {
+	h.Set("Date", t.UTC().Format(DateFormat))
+}
+
+// ClockSkew returns how far the clock of the UA which sent a message with
+// the header is ahead of now (or behind, if negative), going by its Date
+// header, and whether it has one. As the Date has a precision of a second,
+// skews of less than a second cannot be detected.
+func ClockSkew(h Header, now time.Time) (time.Duration, bool) {
+	t, err := h.Date()
+	if err != nil {
+		return 0, false
+	}
+
+	return t.Sub(now.Truncate(time.Second)), true
+}
+
+// checkClockSkew logs a warning, and records it in the message's Warnings,
+// if the Date of a received message differs from the local time by more
+// than the configured MaxClockSkew.
+func (c *Conn) checkClockSkew(msg interface{}) {
+	maxSkew := c.config().MaxClockSkew
+	if maxSkew <= 0 {
+		return
+	}
+
+	var h Header
+	var warnings *[]string
+	switch msg := msg.(type) {
+	case *Request:
+		h, warnings = msg.Header, &msg.Warnings
+	case *Response:
+		h, warnings = msg.Header, &msg.Warnings
+	default:
+		return
+	}
+
+	skew, ok := ClockSkew(h, c.clock().Now())
+	if !ok || (skew <= maxSkew && skew >= -maxSkew) {
+		return
+	}
+
+	warning := "clock skew of " + skew.String()
+	fmt.Println("warning:", warning, "with", c.Address)
+	*warnings = append(*warnings, warning)
+}
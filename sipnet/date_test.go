@@ -0,0 +1,64 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDate(t *testing.T) {
+	h := make(Header)
+	h.Set("Date", "Sat, 13 Nov 2010 23:29:00 GMT")
+	date, err := h.Date()
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if expected := time.Date(2010, 11, 13, 23, 29, 0, 0, time.UTC); !date.Equal(expected) {
+		t.Errorf("parsed %v, expected %v", date, expected)
+	}
+
+	// The Date is always sent in GMT.
+	h.SetDate(time.Date(2010, 11, 14, 1, 29, 0, 0, time.FixedZone("EET", 7200)))
+	if value := h.Get("Date"); value != "Sat, 13 Nov 2010 23:29:00 GMT" {
+		t.Errorf("set Date %q", value)
+	}
+
+	for _, value := range []string{"", "13 Nov 2010 23:29:00"} {
+		h.Set("Date", value)
+		if _, err := h.Date(); err != ErrParseError {
+			t.Errorf("parsing %q returned %v, expected ErrParseError", value, err)
+		}
+	}
+}
+
+func TestClockSkewWarning(t *testing.T) {
+	now := time.Date(2010, 11, 13, 23, 29, 0, 0, time.UTC)
+	l := listenTest(t, Config{Clock: NewFakeClock(now), MaxClockSkew: time.Minute})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	tests := []struct {
+		date     string
+		warnings int
+	}{
+		{"Sat, 13 Nov 2010 23:29:30 GMT", 0},
+		{"Sun, 14 Nov 2010 00:29:00 GMT", 1},
+		{"Sat, 13 Nov 2010 23:27:00 GMT", 1},
+	}
+
+	for i, test := range tests {
+		sendUDP(t, peer, l, testRequest(MethodMessage,
+			"z9hG4bKdate"+string(rune('a'+i)), "Date: "+test.date))
+		req, _ := acceptRequest(t, l)
+		if len(req.Warnings) != test.warnings {
+			t.Errorf("Date %q: got warnings %q, expected %d", test.date,
+				req.Warnings, test.warnings)
+		}
+
+		skew, ok := ClockSkew(req.Header, now)
+		date, _ := req.Header.Date()
+		if !ok || skew != date.Sub(now) {
+			t.Errorf("Date %q: skew is %v, %v", test.date, skew, ok)
+		}
+	}
+}
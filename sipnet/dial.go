@@ -2,8 +2,10 @@
 package sipnet
 
 import (
+	"bytes"
 	"errors"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -13,26 +15,152 @@ var ErrInvalidTransport = errors.New("sip: invalid transport")
 
 // Dial creates a connection to a SIP UA. It does NOT "dial" a
 // user. addr is an IP:port string,
-// transport is the transport protocol to be used, (i.e. "tcp" or "udp").
+// transport is the transport protocol to be used, (i.e. "tcp", "udp" or
+// "tls").
 //
 // After dialling, you should use ReadResponse to read from the connection,
 // and Request.WriteTo to write requests to the connection.
 func Dial(addr, transport string) (net.Conn, error) {
-	if transport == "tcp" {
-		conn, err := net.DialTimeout("tcp", addr, time.Second*10)
+	t := transportByName(transport)
+	if t == nil {
+		return nil, ErrInvalidTransport
+	}
+
+	return t.Dial(addr)
+}
+
+// DialURI creates a connection to the SIP UA at the given URI, using the
+// URI's target address and transport.
+func DialURI(u URI) (net.Conn, error) {
+	addr, transport := u.Target()
+	return Dial(addr, transport)
+}
+
+// DialURI creates a connection to the SIP UA at the given URI, using the
+// listener's configured defaults for the port and transport.
+func (l *Listener) DialURI(u URI) (net.Conn, error) {
+	addr, transport := l.config.Target(u)
+	return Dial(addr, transport)
+}
+
+// DialConn creates a connection to a SIP UA at addr (IP:port) over the given
+// transport (i.e. "tcp" or "udp"). Unlike Dial, it returns a *Conn, which
+// can be used to send requests with Conn.Do, and read messages with
+// Conn.Read.
+func DialConn(addr, transport string) (*Conn, error) {
+	t := transportByName(transport)
+	if t == nil {
+		return nil, ErrInvalidTransport
+	}
+
+	netConn, err := t.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(t, netConn), nil
+}
+
+// DialURIConn creates a *Conn to the SIP UA at the given URI, using the
+// URI's target address and transport.
+func DialURIConn(u URI) (*Conn, error) {
+	addr, transport := u.Target()
+	return DialConn(addr, transport)
+}
+
+// DialConn returns a *Conn to a SIP UA at addr (IP:port) over the given
+// transport (i.e. "tcp" or "udp"), which belongs to the listener. Over UDP,
+// messages are sent from the listener's socket rather than an ephemeral
+// port, and all messages to the same peer share its pooled Conn, so
+// requests and responses use the same local port (symmetric signaling)
+// through NATs. Over TCP, a new connection is dialed and registered with the
+// listener. A host name in addr is resolved according to the configured
+// PreferredFamily and HappyEyeballs.
+//
+// The Conn is locked to be read by the user, and should be unlocked when
+// done so requests from the peer are accepted by AcceptRequest.
+func (l *Listener) DialConn(addr, transport string) (*Conn, error) {
+	t := transportByName(transport)
+	if t == nil {
+		return nil, ErrInvalidTransport
+	}
+
+	var conn *Conn
+	if t.IsStream() {
+		netConn, err := l.dialStream(t, addr)
 		if err != nil {
 			return nil, err
 		}
-
-		return conn, nil
-	} else if transport == "udp" {
-		conn, err := net.Dial("udp", addr)
+		conn = l.registerStreamConn(t, netConn)
+		conn.dialed = true
+	} else {
+		udpAddr, err := l.resolveUDP(addr)
 		if err != nil {
 			return nil, err
 		}
+		conn = l.getUDPConnFromPool(udpAddr)
+	}
+
+	conn.Lock()
+	return conn, nil
+}
 
-		return conn, nil
+// DialURIConn returns a *Conn to the SIP UA at the given URI like
+// Listener.DialConn, using the listener's configured defaults for the port
+// and transport.
+func (l *Listener) DialURIConn(u URI) (*Conn, error) {
+	addr, transport := l.config.Target(u)
+	return l.DialConn(addr, transport)
+}
+
+// newConn returns a Conn over netConn which does not belong to a listener,
+// and is locked to be read by the user.
+func newConn(t Transport, netConn net.Conn) *Conn {
+	conn := &Conn{
+		Transport:        t.Name(),
+		Conn:             netConn,
+		Address:          netConn.RemoteAddr(),
+		Closed:           false,
+		Locked:           true,
+		WriteBuffer:      new(bytes.Buffer),
+		ReadMessage:      make(chan interface{}, defaultConfig.readQueueDepth()),
+		LastMessage:      defaultConfig.clock().Now(),
+		ReceivedBranches: make(map[string]time.Time),
+		BranchMutex:      new(sync.Mutex),
+		transport:        t,
+		dialed:           true,
+		responseCache:    make(map[string]cachedResponse),
+		done:             make(chan struct{}),
+	}
+
+	if !t.IsStream() {
+		conn.UdpReceiver = make(chan []byte, defaultConfig.readQueueDepth())
+		go conn.udpReader()
+		go readDatagrams(conn, netConn)
 	} else {
-		return nil, ErrInvalidTransport
+		go conn.tcpReader()
+	}
+
+	go conn.branchJanitor()
+
+	return conn
+}
+
+// readDatagrams feeds each read from netConn into the UDP reader of the Conn
+// as a single datagram.
+func readDatagrams(conn *Conn, netConn net.Conn) {
+	defer conn.recoverReader()
+
+	buf := udpBuffers.Get().([]byte)
+	defer udpBuffers.Put(buf)
+
+	for {
+		n, err := netConn.Read(buf)
+		if err != nil {
+			conn.Close()
+			return
+		}
+
+		conn.writeReceivedUDP(append([]byte(nil), buf[:n]...))
 	}
 }
@@ -0,0 +1,41 @@
+package sipnet
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDialConnSymmetricPort(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	local := l.TransportAddr("udp").(*net.UDPAddr)
+	var first *Conn
+	for i := 0; i < 2; i++ {
+		conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		if first == nil {
+			first = conn
+		} else if conn != first {
+			t.Error("second dial returned a different connection")
+		}
+
+		req := newTestRequest(MethodMessage, "sip:bob@"+peer.LocalAddr().String())
+		if err := <-goWrite(func() error {
+			return req.WriteTo(conn)
+		}); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+		conn.Unlock()
+
+		_, from := readUDP(t, peer)
+		if port := from.(*net.UDPAddr).Port; port != local.Port {
+			t.Errorf("request %d sent from port %d, expected the listener's "+
+				"port %d", i+1, port, local.Port)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package sipnet
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrCSeqOutOfOrder is returned by Dialog.Receive if a request within the
+// dialog has a lower CSeq than the last request received within it.
+var ErrCSeqOutOfOrder = errors.New("sip: CSeq out of order")
+
+// Dialog represents a SIP dialog (RFC 3261 section 12), the peer to peer
+// relationship between two UAs established by an INVITE, from the point of
+// view of the local UA. Requests within the dialog assume loose routing.
+type Dialog struct {
+	CallID    string
+	LocalTag  string
+	RemoteTag string
+
+	// LocalUser and RemoteUser are the local and remote users of the
+	// dialog, without their tags.
+	LocalUser  User
+	RemoteUser User
+
+	// RemoteTarget is the Contact of the remote UA, where requests within
+	// the dialog are sent.
+	RemoteTarget URI
+
+	// RouteSet is the set of proxies requests within the dialog are sent
+	// through, in the order they are to be visited.
+	RouteSet []User
+
+	// LocalSeq and RemoteSeq are the CSeq numbers of the last requests sent
+	// and received within the dialog. RemoteSeq is only known once a
+	// request has been received, which is recorded in remoteSeqKnown.
+	LocalSeq  uint32
+	RemoteSeq uint32
+
+	// Conn is the connection the dialog was established over, which
+	// requests within the dialog are sent over with Do. It is replaced by a
+	// new connection to the next hop if it closes.
+	Conn *Conn
+
+	// MaxReconnects is the number of times Do reconnects to the next hop and
+	// sends a request again if the connection drops before its final
+	// response is received. If zero, the request fails instead.
+	MaxReconnects int
+
+	remoteSeqKnown bool
+	seqMutex       sync.Mutex
+	connMutex      sync.Mutex
+
+	// listener is the listener a restored dialog without a connection dials
+	// its next hop over.
+	listener *Listener
+}
+
+// NewServerDialog creates the dialog of a UAS from a received INVITE, which
+// is answered with the local tag in its To header.
+func NewServerDialog(req *Request, localTag string, conn *Conn) (*Dialog, error) {
+	from, to, err := ParseUserHeader(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dialog{
+		CallID:     req.Header.Get("Call-ID"),
+		LocalTag:   localTag,
+		RemoteTag:  from.Arguments.Get("tag"),
+		LocalUser:  withoutTag(to),
+		RemoteUser: withoutTag(from),
+		RemoteSeq:  cseq.Sequence,
+		Conn:       conn,
+
+		remoteSeqKnown: true,
+	}
+
+	d.RemoteTarget, err = remoteTarget(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	d.RouteSet, err = ParseUsers(req.Header, "Record-Route")
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// NewClientDialog creates the dialog of a UAC from a sent INVITE and a
+// received response with a To tag.
+func NewClientDialog(req *Request, resp *Response, conn *Conn) (*Dialog, error) {
+	from, err := ParseUser(req.Header.Get("From"))
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := ParseUser(resp.Header.Get("To"))
+	if err != nil {
+		return nil, err
+	}
+
+	cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dialog{
+		CallID:     req.Header.Get("Call-ID"),
+		LocalTag:   from.Arguments.Get("tag"),
+		RemoteTag:  to.Arguments.Get("tag"),
+		LocalUser:  withoutTag(from),
+		RemoteUser: withoutTag(to),
+		LocalSeq:   cseq.Sequence,
+		Conn:       conn,
+	}
+
+	d.RemoteTarget, err = remoteTarget(resp.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := ParseUsers(resp.Header, "Record-Route")
+	if err != nil {
+		return nil, err
+	}
+
+	// The route set of a UAC is the Record-Route in reverse order.
+	for i := len(routes) - 1; i >= 0; i-- {
+		d.RouteSet = append(d.RouteSet, routes[i])
+	}
+
+	return d, nil
+}
+
+// NewRequest returns a new request within the dialog. The local CSeq is
+// incremented, except for ACK and CANCEL which use the current CSeq.
+func (d *Dialog) NewRequest(method string) *Request {
+	d.seqMutex.Lock()
+	if method != MethodAck && method != MethodCancel {
+		d.LocalSeq++
+	}
+	seq := d.LocalSeq
+	d.seqMutex.Unlock()
+
+	local := d.LocalUser
+	local.Arguments = HeaderArgs{"tag": d.LocalTag}
+	remote := d.RemoteUser
+	remote.Arguments = make(HeaderArgs)
+	if d.RemoteTag != "" {
+		remote.Arguments.Set("tag", d.RemoteTag)
+	}
+
+	req := NewRequest()
+	req.Method = method
+	req.Server = d.RemoteTarget.String()
+	req.Header.Set("From", local.String())
+	req.Header.Set("To", remote.String())
+	req.Header.Set("Call-ID", d.CallID)
+	req.Header.Set("CSeq", CSeq{Sequence: seq, Method: method}.String())
+	req.SetMaxForwards(DefaultMaxForwards)
+	for _, route := range d.RouteSet {
+		req.Header.Add("Route", route.String())
+	}
+
+	if d.Conn != nil {
+		req.Header.Set("Via", d.Conn.NewVia().String())
+	}
+
+	return req
+}
+
+// Receive checks the CSeq of a request received within the dialog, and
+// records it as the remote CSeq. A request other than an ACK or CANCEL with
+// a lower CSeq than the last request received is out of order, and
+// ErrCSeqOutOfOrder is returned, in which case it should be answered with a
+// StatusServerInternalError (RFC 3261 section 12.2.2).
+func (d *Dialog) Receive(req *Request) error {
+	cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+	if err != nil {
+		return err
+	}
+
+	if req.Method == MethodAck || req.Method == MethodCancel {
+		return nil
+	}
+
+	d.seqMutex.Lock()
+	defer d.seqMutex.Unlock()
+
+	if d.remoteSeqKnown && cseq.Sequence < d.RemoteSeq {
+		return ErrCSeqOutOfOrder
+	}
+
+	d.RemoteSeq = cseq.Sequence
+	d.remoteSeqKnown = true
+	return nil
+}
+
+// Matches returns whether a request received by the local UA belongs to the
+// dialog.
+func (d *Dialog) Matches(req *Request) bool {
+	from, to, err := ParseUserHeader(req.Header)
+	if err != nil {
+		return false
+	}
+
+	return req.Header.Get("Call-ID") == d.CallID &&
+		from.Arguments.Get("tag") == d.RemoteTag &&
+		to.Arguments.Get("tag") == d.LocalTag
+}
+
+func withoutTag(u User) User {
+	args := make(HeaderArgs)
+	for key, value := range u.Arguments {
+		if key != "tag" {
+			args.Set(key, value)
+		}
+	}
+	u.Arguments = args
+	return u
+}
+
+// remoteTarget returns the URI of the first Contact of the header.
+func remoteTarget(h Header) (URI, error) {
+	contacts, err := ParseUsers(h, "Contact")
+	if err != nil {
+		return URI{}, err
+	}
+
+	if len(contacts) == 0 {
+		return URI{}, ErrParseError
+	}
+
+	return contacts[0].URI, nil
+}
+
+// nextHop returns the URI requests within the dialog are sent to, which is
+// the first route of the route set, or the remote target if there is none.
+func (d *Dialog) nextHop() URI {
+	if len(d.RouteSet) > 0 {
+		return d.RouteSet[0].URI
+	}
+
+	return d.RemoteTarget
+}
+
+// connection returns the connection bound to the dialog, reconnecting to
+// the next hop if it has closed, and whether it was replaced. A new
+// connection belongs to the same listener as the closed one.
+func (d *Dialog) connection() (*Conn, bool, error) {
+	d.connMutex.Lock()
+	defer d.connMutex.Unlock()
+
+	if d.Conn != nil && !d.Conn.Closed {
+		return d.Conn, false, nil
+	}
+
+	var conn *Conn
+	var err error
+	if d.Conn != nil && d.Conn.Listener != nil {
+		conn, err = d.Conn.Listener.DialURIConn(d.nextHop())
+	} else if d.listener != nil {
+		conn, err = d.listener.DialURIConn(d.nextHop())
+	} else {
+		conn, err = DialURIConn(d.nextHop())
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	d.Conn = conn
+	return conn, true, nil
+}
+
+// bind binds the dialog to the connection a request within it was last
+// received over.
+func (d *Dialog) bind(conn *Conn) {
+	d.connMutex.Lock()
+	d.Conn = conn
+	d.connMutex.Unlock()
+}
+
+// Do sends a request within the dialog over the connection bound to the
+// dialog and waits for its final response like Conn.Do. If the connection
+// has closed, the next hop is dialed again and bound to the dialog, and
+// the Via of the request is replaced to match it. This is repeated at most
+// MaxReconnects times if the connection drops before the final response is
+// received.
+func (d *Dialog) Do(req *Request) (*Response, error) {
+	for reconnects := 0; ; reconnects++ {
+		conn, replaced, err := d.connection()
+		if err != nil {
+			return nil, err
+		}
+
+		if replaced || req.Header.Get("Via") == "" {
+			req.Header.Set("Via", conn.NewVia().String())
+		}
+
+		resp, err := conn.Do(req)
+		if err == nil || !isDropped(conn, err) ||
+			reconnects >= d.MaxReconnects {
+			return resp, err
+		}
+		conn.Close()
+	}
+}
+
+// BYE returns a new BYE request to terminate the dialog.
+func (d *Dialog) BYE() *Request {
+	return d.NewRequest(MethodBye)
+}
+
+// Hangup terminates the dialog by sending a BYE with Do and waiting for its
+// response. If creds is not nil and the BYE is challenged with a 401 or
+// 407, the challenge is answered and the BYE is sent again with the next
+// CSeq of the dialog.
+func (d *Dialog) Hangup(creds *Credentials) (*Response, error) {
+	resp, err := d.Do(d.BYE())
+	if err != nil || creds == nil || !isChallenge(resp) {
+		return resp, err
+	}
+
+	bye := d.BYE()
+	err = Authorize(bye, resp, *creds)
+	if err != nil {
+		return resp, nil
+	}
+
+	return d.Do(bye)
+}
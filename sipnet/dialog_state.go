@@ -0,0 +1,132 @@
+package sipnet
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrSnapshotVersion is returned when restoring a dialog from a snapshot
+// made by an incompatible version of the package.
+var ErrSnapshotVersion = errors.New("sip: unsupported dialog snapshot version")
+
+// dialogSnapshotVersion is the version of the snapshot format.
+const dialogSnapshotVersion = 1
+
+// dialogSnapshot is the serialized form of a dialog. Users and URIs are
+// kept in their header form.
+type dialogSnapshot struct {
+	Version        int      `json:"v"`
+	CallID         string   `json:"call_id"`
+	LocalTag       string   `json:"local_tag"`
+	RemoteTag      string   `json:"remote_tag"`
+	LocalUser      string   `json:"local_user"`
+	RemoteUser     string   `json:"remote_user"`
+	RemoteTarget   string   `json:"remote_target"`
+	RouteSet       []string `json:"route_set,omitempty"`
+	LocalSeq       uint32   `json:"local_seq"`
+	RemoteSeq      uint32   `json:"remote_seq"`
+	RemoteSeqKnown bool     `json:"remote_seq_known"`
+	MaxReconnects  int      `json:"max_reconnects,omitempty"`
+}
+
+// MarshalBinary returns a snapshot of the state of the dialog, such as to
+// checkpoint it in an external store so a standby instance can take over
+// the dialog with RestoreDialog. The snapshot holds the identity, route set
+// and CSeq numbers of the dialog, but not its connection, which is dialed
+// again when the restored dialog next sends a request. A snapshot should
+// be made again after each request sent or received within the dialog, as
+// the CSeq numbers change.
+func (d *Dialog) MarshalBinary() ([]byte, error) {
+	d.seqMutex.Lock()
+	snapshot := dialogSnapshot{
+		Version:        dialogSnapshotVersion,
+		CallID:         d.CallID,
+		LocalTag:       d.LocalTag,
+		RemoteTag:      d.RemoteTag,
+		LocalUser:      d.LocalUser.String(),
+		RemoteUser:     d.RemoteUser.String(),
+		RemoteTarget:   d.RemoteTarget.String(),
+		LocalSeq:       d.LocalSeq,
+		RemoteSeq:      d.RemoteSeq,
+		RemoteSeqKnown: d.remoteSeqKnown,
+		MaxReconnects:  d.MaxReconnects,
+	}
+	d.seqMutex.Unlock()
+
+	for _, route := range d.RouteSet {
+		snapshot.RouteSet = append(snapshot.RouteSet, route.String())
+	}
+
+	return json.Marshal(snapshot)
+}
+
+// UnmarshalBinary restores the state of the dialog from a snapshot made by
+// MarshalBinary. The dialog has no connection until one is dialed to its
+// next hop, or it is bound to one. ErrSnapshotVersion is returned if the
+// snapshot is of an unsupported version, and ErrParseError if it is
+// malformed.
+func (d *Dialog) UnmarshalBinary(data []byte) error {
+	var snapshot dialogSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return ErrParseError
+	}
+
+	if snapshot.Version != dialogSnapshotVersion {
+		return ErrSnapshotVersion
+	}
+
+	localUser, err := ParseUser(snapshot.LocalUser)
+	if err != nil {
+		return err
+	}
+
+	remoteUser, err := ParseUser(snapshot.RemoteUser)
+	if err != nil {
+		return err
+	}
+
+	remoteTarget, err := ParseURI(snapshot.RemoteTarget)
+	if err != nil {
+		return err
+	}
+
+	var routeSet []User
+	for _, value := range snapshot.RouteSet {
+		route, err := ParseUser(value)
+		if err != nil {
+			return err
+		}
+		routeSet = append(routeSet, route)
+	}
+
+	d.seqMutex.Lock()
+	d.CallID = snapshot.CallID
+	d.LocalTag = snapshot.LocalTag
+	d.RemoteTag = snapshot.RemoteTag
+	d.LocalUser = localUser
+	d.RemoteUser = remoteUser
+	d.RemoteTarget = remoteTarget
+	d.RouteSet = routeSet
+	d.LocalSeq = snapshot.LocalSeq
+	d.RemoteSeq = snapshot.RemoteSeq
+	d.remoteSeqKnown = snapshot.RemoteSeqKnown
+	d.MaxReconnects = snapshot.MaxReconnects
+	d.seqMutex.Unlock()
+
+	return nil
+}
+
+// RestoreDialog returns the dialog restored from a snapshot made by
+// Dialog.MarshalBinary, such as by a standby instance taking over the
+// dialogs of a failed one. Requests sent within the dialog with Do dial its
+// next hop over the listener, which may be nil to dial without one, and
+// requests received within it are matched once it is added to the
+// DialogStore of a Server.
+func RestoreDialog(data []byte, l *Listener) (*Dialog, error) {
+	d := &Dialog{listener: l}
+	if err := d.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
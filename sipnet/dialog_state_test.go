@@ -0,0 +1,93 @@
+package sipnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDialogSnapshotRoundTrip(t *testing.T) {
+	d := testDialog(nil)
+	d.LocalSeq = 5
+	if err := d.Receive(parseRequest(t, strings.Replace(testRequest(
+		MethodInfo, "z9hG4bKinfo"), "CSeq: 1 ", "CSeq: 7 ", 1))); err != nil {
+		t.Fatalf("failed to receive: %v", err)
+	}
+
+	data, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	restored, err := RestoreDialog(data, nil)
+	if err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+
+	// The restored dialog sends requests with the next CSeq, to the remote
+	// target through the route set, and receives requests in order.
+	checkBYE(t, restored.BYE(), "6 BYE")
+	if restored.Conn != nil {
+		t.Error("restored dialog has a connection")
+	}
+	if err := restored.Receive(parseRequest(t, strings.Replace(testRequest(
+		MethodInfo, "z9hG4bKstale"), "CSeq: 1 ", "CSeq: 6 ", 1))); err !=
+		ErrCSeqOutOfOrder {
+		t.Errorf("received an out of order request with %v", err)
+	}
+	if found := restored.ID(); found.CallID != d.CallID ||
+		found.ToTag != d.LocalTag || found.FromTag != d.RemoteTag {
+		t.Errorf("restored dialog has ID %+v", found)
+	}
+}
+
+func TestRestoredDialogDials(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer server.Close()
+
+	d := testDialog(nil)
+	d.RouteSet = nil
+	d.RemoteTarget, _ = ParseURI("sip:bob@" + server.Addr().String() +
+		";transport=tcp")
+	data, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+
+	// The standby instance dials the remote target over its listener.
+	restored, err := RestoreDialog(data, l)
+	if err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	results := goHangup(restored, nil)
+	peer, bye := acceptTCPRequest(t, server)
+	defer peer.Close()
+	if bye.Method != MethodBye || bye.Header.Get("CSeq") != "2 BYE" ||
+		bye.Header.Get("Call-ID") != d.CallID {
+		t.Fatalf("sent %s with CSeq %q, expected the BYE of the dialog",
+			bye.Method, bye.Header.Get("CSeq"))
+	}
+	peer.Write([]byte(testResponse(bye, "200 OK")))
+	expectOK(t, results)
+}
+
+func TestRestoreMalformedSnapshot(t *testing.T) {
+	tests := []struct {
+		data string
+		err  error
+	}{
+		{"not json", ErrParseError},
+		{`{"v":2,"call_id":"call1@127.0.0.1"}`, ErrSnapshotVersion},
+	}
+
+	for _, test := range tests {
+		if _, err := RestoreDialog([]byte(test.data), nil); err != test.err {
+			t.Errorf("%q: restored with %v, expected %v", test.data, err,
+				test.err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package sipnet
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDialog returns the dialog of alice's UA with bob, over conn.
+func testDialog(conn *Conn) *Dialog {
+	alice, _ := ParseUser("<sip:alice@127.0.0.1>")
+	bob, _ := ParseUser("<sip:bob@127.0.0.1>")
+	target, _ := ParseURI("sip:bob@192.0.2.9:5060;transport=tcp")
+	proxy, _ := ParseUser("<sip:proxy.example.com;lr>")
+	return &Dialog{
+		CallID:       "call1@127.0.0.1",
+		LocalTag:     "a1",
+		RemoteTag:    "b1",
+		LocalUser:    alice,
+		RemoteUser:   bob,
+		RemoteTarget: target,
+		RouteSet:     []User{proxy},
+		LocalSeq:     1,
+		Conn:         conn,
+	}
+}
+
+// checkBYE fails the test if bye isn't a BYE within the dialog of
+// testDialog with the given CSeq.
+func checkBYE(t *testing.T, bye *Request, cseq string) {
+	t.Helper()
+
+	if bye.Method != MethodBye || bye.Header.Get("CSeq") != cseq {
+		t.Fatalf("%s with CSeq %q, expected a BYE with CSeq %q", bye.Method,
+			bye.Header.Get("CSeq"), cseq)
+	}
+	if bye.Server != "sip:bob@192.0.2.9:5060;transport=tcp" {
+		t.Errorf("BYE to %s, expected the remote target", bye.Server)
+	}
+
+	from, to, err := ParseUserHeader(bye.Header)
+	if err != nil {
+		t.Fatalf("failed to parse From and To: %v", err)
+	}
+	if from.Arguments.Get("tag") != "a1" || to.Arguments.Get("tag") != "b1" {
+		t.Errorf("BYE from tag %q to tag %q, expected a1 and b1",
+			from.Arguments.Get("tag"), to.Arguments.Get("tag"))
+	}
+
+	routes, err := ParseUsers(bye.Header, "Route")
+	if err != nil || len(routes) != 1 || routes[0].URI.Domain != "proxy.example.com" {
+		t.Errorf("BYE with Route %q, expected the route set",
+			bye.Header.Values("Route"))
+	}
+}
+
+// goHangup calls Dialog.Hangup from a goroutine of its own, returning a
+// channel receiving its result.
+func goHangup(d *Dialog, creds *Credentials) <-chan doResult {
+	results := make(chan doResult, 1)
+	go func() {
+		resp, err := d.Hangup(creds)
+		results <- doResult{resp, err}
+	}()
+	return results
+}
+
+// expectOK fails the test unless the result is a 200 response.
+func expectOK(t *testing.T, results <-chan doResult) {
+	t.Helper()
+
+	select {
+	case result := <-results:
+		if result.err != nil || result.resp.StatusCode != StatusOK {
+			t.Errorf("returned %v, %v, expected the 200", result.resp, result.err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the final response")
+	}
+}
+
+func TestDialogBYE(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	d := testDialog(conn)
+	checkBYE(t, d.BYE(), "2 BYE")
+
+	results := goHangup(d, nil)
+	bye := parseRequest(t, readPipe(t, remote))
+	checkBYE(t, bye, "3 BYE")
+	writePipe(t, remote, testResponse(bye, "200 OK"))
+	expectOK(t, results)
+}
+
+func TestDialogChallengedBYE(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	d := testDialog(conn)
+	creds := &Credentials{Username: "alice", Password: "secret"}
+	results := goHangup(d, creds)
+
+	bye := parseRequest(t, readPipe(t, remote))
+	checkBYE(t, bye, "2 BYE")
+	writePipe(t, remote, testResponse(bye, "401 Unauthorized",
+		`WWW-Authenticate: Digest realm="example.com", nonce="n0nce", qop="auth"`))
+
+	// The BYE is sent again with the next CSeq, answering the challenge.
+	authorized := parseRequest(t, readPipe(t, remote))
+	checkBYE(t, authorized, "3 BYE")
+	if TransactionKey(authorized) == TransactionKey(bye) {
+		t.Error("authorized BYE sent with the branch of the challenged BYE")
+	}
+	answer, found := authorized.Credentials("example.com", false)
+	if !found || answer.Username != "alice" ||
+		!answer.Verify(MethodBye, "n0nce", "secret") {
+		t.Errorf("BYE sent with Authorization %q, expected valid credentials",
+			authorized.Header.Get("Authorization"))
+	}
+	writePipe(t, remote, testResponse(authorized, "200 OK"))
+	expectOK(t, results)
+}
+
+func TestDialogBYEReusesConnection(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer peer.Close()
+	peer.SetDeadline(time.Now().Add(testTimeout))
+	rd := bufio.NewReader(peer)
+
+	peer.Write([]byte(testRequest(MethodInvite, "z9hG4bKbound",
+		"Contact: <sip:alice@192.0.2.1:5070;transport=tcp>")))
+	invite, conn := acceptRequest(t, l)
+	d, err := NewServerDialog(invite, "b1", conn)
+	if err != nil {
+		t.Fatalf("failed to create the dialog: %v", err)
+	}
+	<-respond(conn, invite, StatusOK, "b1")
+	if _, err := ReadResponseBuffered(rd); err != nil {
+		t.Fatalf("failed to read the 200: %v", err)
+	}
+	conn.Lock()
+	defer conn.Unlock()
+
+	// The BYE is sent over the connection the INVITE was received on,
+	// rather than to the remote target.
+	results := goHangup(d, nil)
+	bye, err := ReadRequestBuffered(rd)
+	if err != nil {
+		t.Fatalf("failed to read the BYE: %v", err)
+	}
+	if bye.Method != MethodBye {
+		t.Fatalf("read %s, expected a BYE", bye.Method)
+	}
+	peer.Write([]byte(testResponse(bye, "200 OK")))
+	expectOK(t, results)
+
+	if d.Conn != conn {
+		t.Error("the dialog was bound to another connection")
+	}
+}
+
+func TestDialogReconnects(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer target.Close()
+
+	closed, remote := NewPipeConn("tcp")
+	remote.Close()
+	closed.Close()
+
+	d := testDialog(closed)
+	d.RouteSet = nil
+	d.RemoteTarget, _ = ParseURI("sip:bob@" + target.Addr().String() +
+		";transport=tcp")
+	results := goHangup(d, nil)
+
+	peer, err := target.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer peer.Close()
+	peer.SetDeadline(time.Now().Add(testTimeout))
+
+	bye, err := ReadRequest(peer)
+	if err != nil {
+		t.Fatalf("failed to read the BYE: %v", err)
+	}
+	via, err := ParseVia(bye.Header.Get("Via"))
+	if err != nil || via.Transport != "TCP" {
+		t.Errorf("BYE sent with Via %q, expected one of the new connection",
+			bye.Header.Get("Via"))
+	}
+	peer.Write([]byte(testResponse(bye, "200 OK")))
+	expectOK(t, results)
+
+	if d.Conn == closed {
+		t.Error("the dialog is still bound to the closed connection")
+	}
+	d.Conn.Close()
+}
+
+func TestDialogRemoteTargetAndRouteSet(t *testing.T) {
+	invite := parseRequest(t, testRequest(MethodInvite, "z9hG4bKroutes",
+		"Contact: <sip:alice@192.0.2.1:5070;transport=tcp>",
+		"Record-Route: <sip:p1.example.com;lr>",
+		"Record-Route: <sip:p2.example.com;lr>"))
+	resp, err := ReadResponse(strings.NewReader(testResponse(invite, "200 OK",
+		"Contact: <sip:bob@192.0.2.9:5060>",
+		"Record-Route: <sip:p1.example.com;lr>, <sip:p2.example.com;lr>")))
+	if err != nil {
+		t.Fatalf("failed to parse the 2xx: %v", err)
+	}
+
+	server, err := NewServerDialog(invite, "b1", nil)
+	if err != nil {
+		t.Fatalf("failed to create the UAS dialog: %v", err)
+	}
+	client, err := NewClientDialog(invite, resp, nil)
+	if err != nil {
+		t.Fatalf("failed to create the UAC dialog: %v", err)
+	}
+
+	// The UAC reverses the Record-Route, so both route toward the other.
+	tests := []struct {
+		name   string
+		dialog *Dialog
+		target string
+		routes []string
+	}{
+		{"UAS", server, "sip:alice@192.0.2.1:5070;transport=tcp",
+			[]string{"p1.example.com", "p2.example.com"}},
+		{"UAC", client, "sip:bob@192.0.2.9:5060",
+			[]string{"p2.example.com", "p1.example.com"}},
+	}
+
+	for _, test := range tests {
+		if target := test.dialog.RemoteTarget.String(); target != test.target {
+			t.Errorf("%s: remote target is %s, expected %s", test.name, target,
+				test.target)
+		}
+
+		req := test.dialog.NewRequest(MethodBye)
+		if req.Server != test.target {
+			t.Errorf("%s: BYE to %s, expected the remote target", test.name,
+				req.Server)
+		}
+		routes, err := ParseUsers(req.Header, "Route")
+		if err != nil || len(routes) != len(test.routes) {
+			t.Fatalf("%s: BYE with Route %q", test.name,
+				req.Header.Values("Route"))
+		}
+		for i, route := range routes {
+			if route.URI.Domain != test.routes[i] ||
+				test.dialog.RouteSet[i].URI.Domain != test.routes[i] {
+				t.Errorf("%s: route %d is %s, expected %s", test.name, i,
+					route.URI.Domain, test.routes[i])
+			}
+		}
+	}
+}
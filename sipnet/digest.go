@@ -0,0 +1,198 @@
+package sipnet
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedChallenge is returned by Authorize if a response has no
+// Digest challenge with a supported algorithm.
+var ErrUnsupportedChallenge = errors.New("sip: unsupported authentication challenge")
+
+// Credentials are the username and password used to answer Digest
+// authentication challenges (RFC 2617) from a UAS or proxy.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Authorize adds the Authorization (for a 401) or Proxy-Authorization (for
+// a 407) header to req, answering the challenge of resp with the given
+// credentials. The request should be sent again with an incremented CSeq
+// and a new branch.
+func Authorize(req *Request, resp *Response, creds Credentials) error {
+	ch, err := parseChallenge(resp)
+	if err != nil {
+		return err
+	}
+
+	ch.authorize(req, creds, 1)
+	return nil
+}
+
+// clientAlgorithms are the Digest algorithms challenges are answered with.
+var clientAlgorithms = []string{"SHA-512-256", "SHA-256", "MD5"}
+
+// digestChallenge is a parsed Digest challenge.
+type digestChallenge struct {
+	authKey   string
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	hasQop    bool
+	hasOpaque bool
+}
+
+// parseChallenge parses the strongest supported Digest challenge of a 401
+// or 407 response.
+func parseChallenge(resp *Response) (digestChallenge, error) {
+	authKey := "Authorization"
+	if resp.StatusCode == StatusProxyAuthenticationRequired {
+		authKey = "Proxy-Authorization"
+	}
+
+	ch, ok := SelectChallenge(resp.Challenges(), clientAlgorithms)
+	if !ok {
+		return digestChallenge{}, ErrUnsupportedChallenge
+	}
+
+	algorithm := strings.ToUpper(ch.Algorithm)
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+
+	return digestChallenge{
+		authKey:   authKey,
+		realm:     ch.Realm,
+		nonce:     ch.Nonce,
+		opaque:    ch.Opaque,
+		algorithm: algorithm,
+		hasQop:    hasQopAuth(strings.Join(ch.Qop, ",")),
+		hasOpaque: ch.Opaque != "",
+	}, nil
+}
+
+// authorize sets the authorization header of req answering the challenge,
+// with the nonce count nc if the challenge has a qop.
+func (ch digestChallenge) authorize(req *Request, creds Credentials, nc uint32) {
+	hash := func(data string) string {
+		return digestHex(ch.algorithm, data)
+	}
+
+	ha1 := hash(creds.Username + ":" + ch.realm + ":" + creds.Password)
+	ha2 := hash(req.Method + ":" + req.Server)
+
+	auth := "Digest username=" + QuoteString(creds.Username) +
+		", realm=" + QuoteString(ch.realm) +
+		", nonce=" + QuoteString(ch.nonce) +
+		", uri=" + QuoteString(req.Server) +
+		", algorithm=" + ch.algorithm
+
+	if ch.hasQop {
+		cnonce := randomHex(8)
+		count := formatNonceCount(nc)
+		response := hash(ha1 + ":" + ch.nonce + ":" + count + ":" +
+			cnonce + ":auth:" + ha2)
+		auth += ", response=" + QuoteString(response) +
+			", cnonce=" + QuoteString(cnonce) + ", qop=auth, nc=" + count
+	} else {
+		auth += ", response=" + QuoteString(hash(ha1+":"+ch.nonce+":"+ha2))
+	}
+
+	if ch.hasOpaque {
+		auth += ", opaque=" + QuoteString(ch.opaque)
+	}
+
+	setCredentials(req.Header, ch.authKey, ch.realm, auth)
+}
+
+// setCredentials sets the credentials for a realm under a header key,
+// replacing any previous credentials for the realm, but keeping those for
+// other realms, such as of other proxies on the path of the request.
+func setCredentials(h Header, key, realm, value string) {
+	var values []string
+	for _, existing := range h.Values(key) {
+		creds, err := ParseDigestCredentials(existing)
+		if err != nil || creds.Realm != realm {
+			values = append(values, existing)
+		}
+	}
+
+	h.Del(key)
+	for _, existing := range values {
+		h.Add(key, existing)
+	}
+	h.Add(key, value)
+}
+
+// formatNonceCount formats a nonce count as the 8 hex digits of the nc
+// parameter.
+func formatNonceCount(nc uint32) string {
+	count := strconv.FormatUint(uint64(nc), 16)
+	return strings.Repeat("0", 8-len(count)) + count
+}
+
+func hasQopAuth(qop string) bool {
+	for _, value := range strings.Split(qop, ",") {
+		if strings.TrimSpace(value) == "auth" {
+			return true
+		}
+	}
+	return false
+}
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// digestHex returns the hex encoded hash of data with a Digest algorithm,
+// which is MD5 unless it is SHA-256 or SHA-512-256 (RFC 8760).
+func digestHex(algorithm, data string) string {
+	switch strings.ToUpper(algorithm) {
+	case "SHA-256":
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	case "SHA-512-256":
+		sum := sha512.Sum512_256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	default:
+		return md5Hex(data)
+	}
+}
+
+// isChallenge returns whether a response is an authentication challenge.
+func isChallenge(resp *Response) bool {
+	return resp.StatusCode == StatusUnauthorized ||
+		resp.StatusCode == StatusProxyAuthenticationRequired
+}
+
+// DoAuthenticated sends a request like Do, but if it is challenged with a
+// 401 or 407, the challenge is answered with the given credentials, and the
+// request is sent again once with an incremented CSeq and a new branch.
+func (c *Conn) DoAuthenticated(req *Request, creds Credentials) (*Response, error) {
+	resp, err := c.Do(req)
+	if err != nil || !isChallenge(resp) {
+		return resp, err
+	}
+
+	err = Authorize(req, resp, creds)
+	if err != nil {
+		return resp, nil
+	}
+
+	req.Header.Set("Via", c.NewVia().String())
+	cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+	if err == nil {
+		cseq.Sequence++
+		req.Header.Set("CSeq", cseq.String())
+	}
+
+	return c.Do(req)
+}
@@ -0,0 +1,90 @@
+package sipnet
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// DispatchMode determines how a Server calls its handler for the requests
+// it receives.
+type DispatchMode int
+
+// Dispatch modes of a Server.
+const (
+	// DispatchGoroutine calls the handler for each request in a new
+	// goroutine, so requests are handled concurrently without a limit, and
+	// requests within a dialog may be handled out of order.
+	DispatchGoroutine DispatchMode = iota
+	// DispatchSync calls the handler on the goroutine which accepted the
+	// request, so the requests of a listener are handled one at a time, in
+	// the order they were received.
+	DispatchSync
+	// DispatchWorkers calls the handler from a fixed pool of Workers
+	// goroutines. Requests with the same Call-ID are always handled by the
+	// same worker, so the requests of a dialog are handled in order, while
+	// a slow handler only delays the requests queued on its own worker.
+	DispatchWorkers
+)
+
+// DefaultWorkers is the number of workers of a Server with the
+// DispatchWorkers mode if Workers is not set.
+const DefaultWorkers = 16
+
+// workerQueueDepth is the number of requests queued per worker before the
+// listener stops accepting requests.
+const workerQueueDepth = 64
+
+type dispatchedRequest struct {
+	req  *Request
+	conn *Conn
+}
+
+// startWorkers starts the workers of a server with the DispatchWorkers mode.
+func (s *Server) startWorkers() {
+	n := s.Workers
+	if n <= 0 {
+		n = DefaultWorkers
+	}
+
+	s.workers = make([]chan dispatchedRequest, n)
+	for i := range s.workers {
+		queue := make(chan dispatchedRequest, workerQueueDepth)
+		s.workers[i] = queue
+
+		go func() {
+			for {
+				select {
+				case <-s.done:
+					return
+				case r := <-queue:
+					s.dispatch(r.req, r.conn)
+				}
+			}
+		}()
+	}
+}
+
+// handle calls the handler for a request according to the dispatch mode, or
+// answers it with a 503 if the server is overloaded.
+func (s *Server) handle(req *Request, conn *Conn) {
+	if s.overloaded(req, conn) {
+		return
+	}
+
+	atomic.AddInt64(&s.load, 1)
+	switch s.Dispatch {
+	case DispatchSync:
+		s.dispatch(req, conn)
+	case DispatchWorkers:
+		h := fnv.New32a()
+		h.Write([]byte(req.Header.Get("Call-ID")))
+		queue := s.workers[h.Sum32()%uint32(len(s.workers))]
+
+		select {
+		case queue <- dispatchedRequest{req: req, conn: conn}:
+		case <-s.done:
+		}
+	default:
+		go s.dispatch(req, conn)
+	}
+}
@@ -0,0 +1,165 @@
+package sipnet
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultDNSTTL is the time to live of records looked up with SystemDNS,
+// as the system resolver does not provide the TTLs of records.
+const DefaultDNSTTL = time.Minute
+
+// DefaultNegativeTTL is the duration a failed lookup is cached for by
+// DNSCache if NegativeTTL is not set.
+const DefaultNegativeTTL = 30 * time.Second
+
+// DNS looks up the DNS records used to resolve SIP URIs (RFC 3263), along
+// with the duration their results may be cached for.
+type DNS interface {
+	// LookupSRV looks up the SRV records of name, i.e.
+	// "_sip._udp.example.com".
+	LookupSRV(name string) ([]*net.SRV, time.Duration, error)
+
+	// LookupHost looks up the addresses of host.
+	LookupHost(host string) ([]string, time.Duration, error)
+}
+
+// SystemDNS is the DNS backed by the system resolver. Its results have a
+// TTL of DefaultDNSTTL.
+var SystemDNS DNS = systemDNS{}
+
+type systemDNS struct{}
+
+func (systemDNS) LookupSRV(name string) ([]*net.SRV, time.Duration, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	return records, DefaultDNSTTL, err
+}
+
+func (systemDNS) LookupHost(host string) ([]string, time.Duration, error) {
+	addrs, err := net.LookupHost(host)
+	return addrs, DefaultDNSTTL, err
+}
+
+type dnsEntry struct {
+	srv     []*net.SRV
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+// DNSCache is a DNS which caches the results of another DNS until their TTL
+// expires, so a lookup isn't made for every request. Lookups which fail
+// because the name does not exist are also cached, for NegativeTTL. It is
+// safe to use from multiple goroutines.
+type DNSCache struct {
+	// DNS is the DNS queried on a cache miss. If nil, SystemDNS is used.
+	DNS DNS
+
+	// NegativeTTL is the duration a lookup which failed because the name
+	// does not exist is cached for. If zero, DefaultNegativeTTL is used.
+	NegativeTTL time.Duration
+
+	// Clock provides the time entries expire by. If nil, RealClock is used.
+	Clock Clock
+
+	mutex   sync.Mutex
+	entries map[string]dnsEntry
+}
+
+// NewDNSCache returns a new DNS cache in front of dns.
+func NewDNSCache(dns DNS) *DNSCache {
+	return &DNSCache{
+		DNS:     dns,
+		entries: make(map[string]dnsEntry),
+	}
+}
+
+func (c *DNSCache) dns() DNS {
+	if c.DNS == nil {
+		return SystemDNS
+	}
+	return c.DNS
+}
+
+func (c *DNSCache) clock() Clock {
+	if c.Clock == nil {
+		return RealClock
+	}
+	return c.Clock
+}
+
+func (c *DNSCache) negativeTTL() time.Duration {
+	if c.NegativeTTL == 0 {
+		return DefaultNegativeTTL
+	}
+	return c.NegativeTTL
+}
+
+// lookup returns the cached entry at key, or stores the entry returned by
+// query if there is none or it has expired.
+func (c *DNSCache) lookup(key string,
+	query func() (dnsEntry, time.Duration)) (dnsEntry, time.Duration) {
+	now := c.clock().Now()
+
+	c.mutex.Lock()
+	entry, found := c.entries[key]
+	c.mutex.Unlock()
+	if found && now.Before(entry.expires) {
+		return entry, entry.expires.Sub(now)
+	}
+
+	entry, ttl := query()
+	if entry.err != nil {
+		if !isNotFound(entry.err) {
+			// Temporary failures are not cached, so the next lookup is
+			// retried.
+			return entry, 0
+		}
+		ttl = c.negativeTTL()
+	}
+
+	entry.expires = now.Add(ttl)
+	c.mutex.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]dnsEntry)
+	}
+	c.entries[key] = entry
+	c.mutex.Unlock()
+
+	return entry, ttl
+}
+
+// LookupSRV looks up the SRV records of name, from the cache if they have
+// not expired.
+func (c *DNSCache) LookupSRV(name string) ([]*net.SRV, time.Duration, error) {
+	entry, ttl := c.lookup("SRV "+name, func() (dnsEntry, time.Duration) {
+		records, ttl, err := c.dns().LookupSRV(name)
+		return dnsEntry{srv: records, err: err}, ttl
+	})
+	return entry.srv, ttl, entry.err
+}
+
+// LookupHost looks up the addresses of host, from the cache if they have
+// not expired.
+func (c *DNSCache) LookupHost(host string) ([]string, time.Duration, error) {
+	entry, ttl := c.lookup("A "+host, func() (dnsEntry, time.Duration) {
+		addrs, ttl, err := c.dns().LookupHost(host)
+		return dnsEntry{addrs: addrs, err: err}, ttl
+	})
+	return entry.addrs, ttl, entry.err
+}
+
+// Flush removes all entries from the cache.
+func (c *DNSCache) Flush() {
+	c.mutex.Lock()
+	c.entries = make(map[string]dnsEntry)
+	c.mutex.Unlock()
+}
+
+// isNotFound returns whether a lookup error means the name has no records,
+// rather than being a temporary failure.
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && !dnsErr.Temporary() && !dnsErr.Timeout()
+}
@@ -0,0 +1,115 @@
+package sipnet
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubDNS is a DNS answering from a fixed set of hosts, which counts the
+// queries made to it.
+type stubDNS struct {
+	hosts   map[string][]string
+	ttl     time.Duration
+	err     error
+	queries int
+}
+
+func (d *stubDNS) LookupSRV(name string) ([]*net.SRV, time.Duration, error) {
+	d.queries++
+	return nil, 0, &net.DNSError{Err: "no such host", Name: name}
+}
+
+func (d *stubDNS) LookupHost(host string) ([]string, time.Duration, error) {
+	d.queries++
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+
+	addrs, found := d.hosts[host]
+	if !found {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return addrs, d.ttl, nil
+}
+
+func TestDNSCacheHonorsTTL(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	dns := &stubDNS{
+		hosts: map[string][]string{"sip.example.com": {"192.0.2.1"}},
+		ttl:   time.Minute,
+	}
+	cache := NewDNSCache(dns)
+	cache.Clock = clock
+
+	addrs, ttl, err := cache.LookupHost("sip.example.com")
+	if err != nil || !reflect.DeepEqual(addrs, []string{"192.0.2.1"}) ||
+		ttl != time.Minute {
+		t.Fatalf("looked up %v with TTL %v, %v", addrs, ttl, err)
+	}
+
+	// A lookup within the TTL hits the cache, with the remaining TTL.
+	dns.hosts["sip.example.com"] = []string{"192.0.2.2"}
+	clock.Advance(59 * time.Second)
+	addrs, ttl, _ = cache.LookupHost("sip.example.com")
+	if dns.queries != 1 || addrs[0] != "192.0.2.1" || ttl != time.Second {
+		t.Errorf("looked up %v with TTL %v after %d queries, expected the "+
+			"cached address", addrs, ttl, dns.queries)
+	}
+
+	// Once the TTL expires, the name is queried again.
+	clock.Advance(time.Second)
+	addrs, _, _ = cache.LookupHost("sip.example.com")
+	if dns.queries != 2 || addrs[0] != "192.0.2.2" {
+		t.Errorf("looked up %v after %d queries, expected a new query",
+			addrs, dns.queries)
+	}
+}
+
+func TestDNSCacheNegative(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	dns := &stubDNS{hosts: map[string][]string{}, ttl: time.Minute}
+	cache := NewDNSCache(dns)
+	cache.Clock = clock
+	cache.NegativeTTL = 10 * time.Second
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := cache.LookupSRV("_sip._udp.example.com"); err == nil {
+			t.Fatal("lookup of a missing name succeeded")
+		}
+	}
+	if dns.queries != 1 {
+		t.Errorf("made %d queries, expected the missing name to be cached",
+			dns.queries)
+	}
+
+	clock.Advance(10 * time.Second)
+	cache.LookupSRV("_sip._udp.example.com")
+	if dns.queries != 2 {
+		t.Errorf("made %d queries, expected the negative entry to expire",
+			dns.queries)
+	}
+}
+
+func TestDNSCacheTemporaryFailure(t *testing.T) {
+	dns := &stubDNS{err: &net.DNSError{Err: "timeout", IsTimeout: true}}
+	cache := NewDNSCache(dns)
+
+	// Temporary failures are retried on the next lookup.
+	cache.LookupHost("sip.example.com")
+	cache.LookupHost("sip.example.com")
+	if dns.queries != 2 {
+		t.Errorf("made %d queries, expected the failure not to be cached",
+			dns.queries)
+	}
+
+	dns.err = nil
+	dns.hosts = map[string][]string{"sip.example.com": {"192.0.2.1"}}
+	if addrs, _, err := cache.LookupHost("sip.example.com"); err != nil ||
+		len(addrs) != 1 {
+		t.Errorf("looked up %v, %v after the failure", addrs, err)
+	}
+}
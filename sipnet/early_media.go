@@ -0,0 +1,47 @@
+package sipnet
+
+import (
+	"math/rand"
+	"strconv"
+)
+
+// Option100rel is the option tag of reliable provisional responses
+// (RFC 3262).
+const Option100rel = "100rel"
+
+// SessionProgress responds to a Conn with a StatusSessionProgress carrying
+// an SDP answer, for early media. If the request supports or requires
+// 100rel, the response is sent reliably with "Require: 100rel" and an RSeq
+// of rseq (such as from an RSeqCounter), or a random RSeq if rseq is zero,
+// which the UAC acknowledges with a PRACK. It returns the RSeq used, or zero
+// if the response is not reliable.
+//
+// The response is only sent once. ReliableSender.SessionProgress also
+// retransmits it over UDP until its PRACK is received.
+func (r *Response) SessionProgress(conn *Conn, req *Request, answer []byte,
+	rseq uint32) (uint32, error) {
+	rseq = r.prepareSessionProgress(req, answer, rseq)
+	return rseq, r.WriteTo(conn, req)
+}
+
+// prepareSessionProgress sets up the response as a StatusSessionProgress
+// for SessionProgress, and returns the RSeq used.
+func (r *Response) prepareSessionProgress(req *Request, answer []byte,
+	rseq uint32) uint32 {
+	r.StatusCode = StatusSessionProgress
+	r.Header.Set("Content-Type", ContentTypeSDP)
+	r.SetBody(answer)
+
+	if !req.Header.HasOptionTag("Supported", Option100rel) &&
+		!req.Header.HasOptionTag("Require", Option100rel) {
+		return 0
+	}
+
+	if rseq == 0 {
+		rseq = uint32(rand.Int31n(1<<31-1)) + 1
+	}
+
+	r.Header.Set("Require", Option100rel)
+	r.Header.Set("RSeq", strconv.FormatUint(uint64(rseq), 10))
+	return rseq
+}
@@ -0,0 +1,95 @@
+package sipnet
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/1lann/go-sip/sdp"
+)
+
+// sendonlyAnswer returns an SDP answer to an audio offer which only sends
+// early media, such as a ringback tone.
+func sendonlyAnswer(t *testing.T) []byte {
+	t.Helper()
+
+	answer, err := sdp.Parse([]byte("v=0\r\n" +
+		"o=bob 2808844564 2808844564 IN IP4 192.0.2.2\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 192.0.2.2\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 49172 RTP/AVP 0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n"))
+	if err != nil {
+		t.Fatalf("failed to parse the answer: %v", err)
+	}
+
+	answer.Media[0].SetDirection(sdp.SendOnly)
+	return answer.Bytes()
+}
+
+func TestSessionProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		extra    []string
+		reliable bool
+	}{
+		{"without 100rel", nil, false},
+		{"supporting 100rel", []string{"Supported: timer, 100rel"}, true},
+		{"requiring 100rel", []string{"Require: 100rel"}, true},
+	}
+
+	for _, test := range tests {
+		req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKearly",
+			test.extra...))
+		resp := NewResponse()
+		resp.Header.Set("From", req.Header.Get("From"))
+		resp.Header.Set("To", req.Header.Get("To")+";tag=b1")
+
+		rseq := resp.prepareSessionProgress(req, sendonlyAnswer(t), 42)
+		parsed, err := ReadResponse(strings.NewReader(
+			writeResponse(t, resp, req)))
+		if err != nil {
+			t.Fatalf("%s: failed to parse: %v", test.name, err)
+		}
+
+		if parsed.StatusCode != StatusSessionProgress ||
+			parsed.Header.Get("Content-Type") != ContentTypeSDP {
+			t.Errorf("%s: sent %d with %q, expected a 183 with SDP",
+				test.name, parsed.StatusCode, parsed.Header.Get("Content-Type"))
+		}
+
+		answer, err := sdp.Parse(parsed.Body)
+		if err != nil {
+			t.Fatalf("%s: failed to parse the SDP: %v", test.name, err)
+		}
+		if d := answer.Direction(answer.Media[0]); d != sdp.SendOnly {
+			t.Errorf("%s: answer is %s, expected sendonly", test.name, d)
+		}
+
+		if !test.reliable {
+			if rseq != 0 || parsed.Header.Get("RSeq") != "" ||
+				parsed.Header.HasOptionTag("Require", Option100rel) {
+				t.Errorf("%s: sent reliably with RSeq %q", test.name,
+					parsed.Header.Get("RSeq"))
+			}
+			continue
+		}
+
+		if rseq != 42 || parsed.Header.Get("RSeq") != strconv.Itoa(42) ||
+			!parsed.Header.HasOptionTag("Require", Option100rel) {
+			t.Errorf("%s: sent with RSeq %q and Require %q, expected a "+
+				"reliable response with RSeq 42", test.name,
+				parsed.Header.Get("RSeq"), parsed.Header.Get("Require"))
+		}
+	}
+}
+
+func TestSessionProgressRandomRSeq(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKearly",
+		"Supported: 100rel"))
+	rseq := NewResponse().prepareSessionProgress(req, sendonlyAnswer(t), 0)
+	if rseq == 0 || rseq >= 1<<31 {
+		t.Errorf("random RSeq is %d, expected it within 1 to 2**31-1", rseq)
+	}
+}
@@ -0,0 +1,227 @@
+package sipnet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedEncoding is returned by ReadRequest and ReadResponse if the
+// body of the message has a Content-Encoding which is not supported. The
+// message is still returned, with its body left encoded.
+var ErrUnsupportedEncoding = errors.New("sip: unsupported content encoding")
+
+// ErrBodyTooLarge is returned by ReadRequest and ReadResponse if the body
+// of the message is larger than the MaxDecodedBodySize of the parser once
+// its Content-Encoding is decoded. The message is still returned, with its
+// body left encoded.
+var ErrBodyTooLarge = errors.New("sip: decoded body too large")
+
+// DefaultMaxDecodedBodySize is the default maximum size in bytes of a body
+// once its Content-Encoding is decoded.
+const DefaultMaxDecodedBodySize = 1 << 20
+
+// ErrUnacceptableEncoding is returned by Response.EncodeFor if none of the
+// content encodings accepted by the request are supported.
+var ErrUnacceptableEncoding = errors.New("sip: no acceptable content encoding")
+
+// The supported content encodings.
+const (
+	EncodingGzip     = "gzip"
+	EncodingIdentity = "identity"
+)
+
+// AcceptedEncoding is a content encoding listed in an Accept-Encoding
+// header, with its q-value.
+type AcceptedEncoding struct {
+	Encoding string
+	Q        float64
+}
+
+// ParseAcceptEncoding parses the value of an Accept-Encoding header, and
+// returns its lower case content encodings in order of preference
+// (q-value). Encodings with a q-value of 0, which are not acceptable, are
+// included at the end.
+func ParseAcceptEncoding(str string) []AcceptedEncoding {
+	var encodings []AcceptedEncoding
+	for _, value := range strings.Split(str, ",") {
+		parts := strings.Split(value, ";")
+		encoding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if encoding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				parsed, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		encodings = append(encodings, AcceptedEncoding{encoding, q})
+	}
+
+	sort.SliceStable(encodings, func(i, j int) bool {
+		return encodings[i].Q > encodings[j].Q
+	})
+
+	return encodings
+}
+
+// NegotiateEncoding returns the supported content encoding most preferred
+// by the Accept-Encoding of a request, and whether any is acceptable. A
+// request without an Accept-Encoding only accepts the identity encoding
+// (RFC 3261 section 20.2), which is otherwise acceptable unless it, or
+// "*", is listed with a q-value of 0.
+func NegotiateEncoding(req *Request) (string, bool) {
+	values := req.Header.Values("Accept-Encoding")
+	if len(values) == 0 {
+		return EncodingIdentity, true
+	}
+
+	identity := true
+	for _, accepted := range ParseAcceptEncoding(strings.Join(values, ",")) {
+		switch accepted.Encoding {
+		case EncodingGzip, EncodingIdentity:
+			if accepted.Q > 0 {
+				return accepted.Encoding, true
+			}
+			if accepted.Encoding == EncodingIdentity {
+				identity = false
+			}
+		case "*":
+			if accepted.Q > 0 {
+				return EncodingGzip, true
+			}
+			identity = false
+		}
+	}
+
+	return EncodingIdentity, identity
+}
+
+// decodeBody decodes a body according to the Content-Encoding of the
+// header, to at most limit bytes if limit is positive. The Content-Encoding
+// header is removed once the body is decoded.
+func decodeBody(h Header, body []byte, limit int) ([]byte, error) {
+	encoding := strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case EncodingGzip:
+		rd, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return body, err
+		}
+
+		var src io.Reader = rd
+		if limit > 0 {
+			src = io.LimitReader(rd, int64(limit)+1)
+		}
+
+		decoded, err := ioutil.ReadAll(src)
+		if err != nil {
+			return body, err
+		}
+
+		if limit > 0 && len(decoded) > limit {
+			return body, ErrBodyTooLarge
+		}
+
+		h.Del("Content-Encoding")
+		h.Set("Content-Length", strconv.Itoa(len(decoded)))
+		return decoded, nil
+	default:
+		return body, ErrUnsupportedEncoding
+	}
+}
+
+func gzipBody(h Header, body []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	wr := gzip.NewWriter(buf)
+	_, err := wr.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = wr.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	h.Set("Content-Encoding", EncodingGzip)
+	return buf.Bytes(), nil
+}
+
+// CompressBody compresses the body of the request with gzip, and sets the
+// Content-Encoding and Content-Length headers accordingly.
+func (r *Request) CompressBody() error {
+	body, err := gzipBody(r.Header, r.Body)
+	if err != nil {
+		return err
+	}
+
+	r.SetBody(body)
+	return nil
+}
+
+// CompressBody compresses the body of the response with gzip, and sets the
+// Content-Encoding and Content-Length headers accordingly.
+func (r *Response) CompressBody() error {
+	body, err := gzipBody(r.Header, r.Body)
+	if err != nil {
+		return err
+	}
+
+	r.SetBody(body)
+	return nil
+}
+
+// EncodeFor encodes the body of the response with the content encoding
+// most preferred by the request's Accept-Encoding, compressing it with gzip
+// if it is preferred to the identity encoding. ErrUnacceptableEncoding is
+// returned if no supported encoding is acceptable, in which case the
+// request can be answered with UnsupportedMediaType instead.
+func (r *Response) EncodeFor(req *Request) error {
+	if len(r.Body) == 0 || r.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	encoding, ok := NegotiateEncoding(req)
+	if !ok {
+		return ErrUnacceptableEncoding
+	}
+
+	if encoding == EncodingGzip {
+		return r.CompressBody()
+	}
+
+	return nil
+}
+
+// UnsupportedMediaType responds to a Conn with a StatusUnsupportedMediaType
+// listing the supported content encodings for convenience.
+func (r *Response) UnsupportedMediaType(conn *Conn, req *Request, reason string) {
+	r.StatusCode = StatusUnsupportedMediaType
+	r.Header.Set("Accept-Encoding", EncodingGzip+", "+EncodingIdentity)
+	r.Header.Set("Reason-Phrase", reason)
+	r.WriteTo(conn, req)
+}
+
+// RequestEntityTooLarge responds to a Conn with a
+// StatusRequestEntityTooLarge for convenience, such as for a request whose
+// body exceeds the MaxDecodedBodySize of the parser.
+func (r *Response) RequestEntityTooLarge(conn *Conn, req *Request, reason string) {
+	r.StatusCode = StatusRequestEntityTooLarge
+	r.Header.Set("Reason-Phrase", reason)
+	r.WriteTo(conn, req)
+}
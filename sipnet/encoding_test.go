@@ -0,0 +1,177 @@
+package sipnet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testSDP = "v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 49170 RTP/AVP 0 8\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n"
+
+func TestGzipBodyRoundTrip(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKgzip"))
+	req.Header.Set("Content-Type", "application/sdp")
+	req.SetBody([]byte(testSDP))
+	if err := req.CompressBody(); err != nil {
+		t.Fatalf("failed to compress body: %v", err)
+	}
+
+	data := writeRequest(t, req)
+	if strings.Contains(data, "m=audio") {
+		t.Fatal("body was written uncompressed")
+	}
+	if !strings.Contains(data, "Content-Encoding: gzip\r\n") {
+		t.Fatal("Content-Encoding was not written")
+	}
+
+	decoded := parseRequest(t, data)
+	if !bytes.Equal(decoded.Body, []byte(testSDP)) {
+		t.Errorf("decoded body %q, expected %q", decoded.Body, testSDP)
+	}
+	if encoding := decoded.Header.Get("Content-Encoding"); encoding != "" {
+		t.Errorf("Content-Encoding %q remains after decoding", encoding)
+	}
+}
+
+func TestGzipBodyTooLarge(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKgzip"))
+	req.SetBody(bytes.Repeat([]byte("a"), 4096))
+	if err := req.CompressBody(); err != nil {
+		t.Fatalf("failed to compress body: %v", err)
+	}
+
+	parser := &Parser{MaxDecodedBodySize: 1024}
+	_, err := parser.ReadRequest(strings.NewReader(writeRequest(t, req)))
+	if err != ErrBodyTooLarge {
+		t.Errorf("parsed with %v, expected ErrBodyTooLarge", err)
+	}
+}
+
+func TestUnsupportedEncodingRejected(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	writePipe(t, remote, testRequest(MethodMessage, "z9hG4bKbrotli",
+		"Content-Encoding: br"))
+	resp := readPipe(t, remote)
+	if line := startLine(resp); line != "SIP/2.0 415 Unsupported Media Type" {
+		t.Errorf("responded with %q, expected a 415", line)
+	}
+	if !strings.Contains(resp, "Accept-Encoding: gzip, identity\r\n") {
+		t.Error("415 doesn't list the supported encodings")
+	}
+	expectNoMessage(t, conn)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		ok             bool
+	}{
+		{"", EncodingIdentity, true},
+		{"gzip", EncodingGzip, true},
+		{"identity;q=1, GZIP;q=0.5", EncodingIdentity, true},
+		{"identity;q=0.2, gzip;q=0.8", EncodingGzip, true},
+		{"br, gzip;q=0.1", EncodingGzip, true},
+		{"br", EncodingIdentity, true},
+		{"*", EncodingGzip, true},
+		{"br, identity;q=0", EncodingIdentity, false},
+		{"br, *;q=0", EncodingIdentity, false},
+	}
+
+	for _, test := range tests {
+		req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKnegotiate"))
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+
+		encoding, ok := NegotiateEncoding(req)
+		if encoding != test.encoding || ok != test.ok {
+			t.Errorf("%q: negotiated %q, %v, expected %q, %v",
+				test.acceptEncoding, encoding, ok, test.encoding, test.ok)
+		}
+	}
+}
+
+func TestEncodeForUnacceptable(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	writePipe(t, remote, testRequest(MethodMessage, "z9hG4bKunacceptable",
+		"Accept-Encoding: br, identity;q=0"))
+	req := readRequest(t, conn)
+
+	resp := NewResponse()
+	resp.StatusCode = StatusOK
+	resp.Body = []byte(testSDP)
+	if err := resp.EncodeFor(req); err != ErrUnacceptableEncoding {
+		t.Fatalf("encoded with %v, expected ErrUnacceptableEncoding", err)
+	}
+
+	errs := goWrite(func() error {
+		NewResponse().UnsupportedMediaType(conn, req, "no acceptable encoding")
+		return nil
+	})
+	data := readPipe(t, remote)
+	<-errs
+	if line := startLine(data); line != "SIP/2.0 415 Unsupported Media Type" {
+		t.Errorf("responded with %q, expected a 415", line)
+	}
+}
+
+func TestGzipThreshold(t *testing.T) {
+	l := listenTest(t, Config{GzipThreshold: 512})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	body := strings.Repeat("compressible ", 50)
+	tests := []struct {
+		acceptEncoding string
+		compressed     bool
+	}{
+		{"gzip", true},
+		{"identity, gzip;q=0.5", false},
+		{"", false},
+	}
+
+	for i, test := range tests {
+		var extra []string
+		if test.acceptEncoding != "" {
+			extra = append(extra, "Accept-Encoding: "+test.acceptEncoding)
+		}
+		sendUDP(t, peer, l, testRequest(MethodMessage,
+			"z9hG4bKthreshold"+string(rune('a'+i)), extra...))
+		req, conn := acceptRequest(t, l)
+
+		resp := NewResponse()
+		resp.StatusCode = StatusOK
+		resp.Header.Set("From", req.Header.Get("From"))
+		resp.Header.Set("To", req.Header.Get("To")+";tag=b1")
+		resp.Header.Set("Content-Type", "text/plain")
+		resp.Body = []byte(body)
+		if err := <-goWrite(func() error {
+			return resp.WriteTo(conn, req)
+		}); err != nil {
+			t.Fatalf("%q: failed to respond: %v", test.acceptEncoding, err)
+		}
+
+		data, _ := readUDP(t, peer)
+		compressed := strings.Contains(data, "Content-Encoding: gzip\r\n")
+		if compressed != test.compressed {
+			t.Errorf("%q: compressed is %v, expected %v",
+				test.acceptEncoding, compressed, test.compressed)
+		}
+		if compressed == strings.Contains(data, body) {
+			t.Errorf("%q: body was written as %q", test.acceptEncoding, data)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package sipnet
+
+import "strings"
+
+// eventTokens returns the comma separated tokens of a header, which may
+// also be sent in its compact form (RFC 3261 section 7.3.3).
+func eventTokens(h Header, key, compact string) []string {
+	var tokens []string
+	for _, value := range append(h.Values(key), h.Values(compact)...) {
+		for _, token := range strings.Split(value, ",") {
+			token = strings.TrimSpace(token)
+			if token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	return tokens
+}
+
+// AllowEvents returns the event packages listed in the Allow-Events header
+// (compact form "u"), which are the packages a UA accepts subscriptions to
+// (RFC 6665).
+func (h Header) AllowEvents() []string {
+	return eventTokens(h, "Allow-Events", "u")
+}
+
+// SetAllowEvents sets the Allow-Events header to the given event packages.
+func (h Header) SetAllowEvents(packages []string) {
+	h.Del("u")
+	h.Set("Allow-Events", strings.Join(packages, ", "))
+}
+
+// AllowsEvent returns whether an event package is listed in the
+// Allow-Events header, ignoring case, such as to check whether a UA
+// supports a package before sending it a SUBSCRIBE.
+func (h Header) AllowsEvent(pkg string) bool {
+	for _, allowed := range h.AllowEvents() {
+		if strings.EqualFold(allowed, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventPackage returns the event package of the Event header (compact form
+// "o") without its parameters, i.e. "presence" for "presence;id=1", or an
+// empty string if there is none.
+func (h Header) EventPackage() string {
+	event := h.Get("Event")
+	if event == "" {
+		event = h.Get("o")
+	}
+
+	return strings.TrimSpace(strings.Split(event, ";")[0])
+}
+
+// BadEvent responds to a Conn with a StatusBadEvent listing the supported
+// event packages in Allow-Events for convenience, such as for a SUBSCRIBE
+// to an unsupported package.
+func (r *Response) BadEvent(conn *Conn, req *Request, supported []string) {
+	r.StatusCode = StatusBadEvent
+	r.Header.SetAllowEvents(supported)
+	r.WriteTo(conn, req)
+}
+
+// CheckEvent checks that the event package of a SUBSCRIBE is in supported,
+// and responds with BadEvent and returns false if it isn't.
+func CheckEvent(conn *Conn, req *Request, supported []string) bool {
+	pkg := req.Header.EventPackage()
+	for _, allowed := range supported {
+		if pkg != "" && strings.EqualFold(allowed, pkg) {
+			return true
+		}
+	}
+
+	NewResponse().BadEvent(conn, req, supported)
+	return false
+}
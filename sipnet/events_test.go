@@ -0,0 +1,68 @@
+package sipnet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAllowsEvent(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodOptions, "z9hG4bKevents",
+		"Allow-Events: presence, dialog", "u: message-summary"))
+
+	expected := []string{"presence", "dialog", "message-summary"}
+	if packages := req.Header.AllowEvents(); !reflect.DeepEqual(packages, expected) {
+		t.Errorf("parsed %q, expected %q", packages, expected)
+	}
+
+	for _, pkg := range []string{"presence", "Dialog", "message-summary"} {
+		if !req.Header.AllowsEvent(pkg) {
+			t.Errorf("%s isn't allowed", pkg)
+		}
+	}
+	if req.Header.AllowsEvent("reg") {
+		t.Error("reg is allowed")
+	}
+
+	// Setting the header replaces the compact form.
+	req.Header.SetAllowEvents([]string{"reg"})
+	parsed := parseRequest(t, writeRequest(t, req))
+	if packages := parsed.Header.AllowEvents(); !reflect.DeepEqual(packages, []string{"reg"}) {
+		t.Errorf("round-tripped to %q, expected [\"reg\"]", packages)
+	}
+}
+
+func TestCheckEvent(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+	supported := []string{"presence", "dialog"}
+
+	writePipe(t, remote, testRequest(MethodSubscribe, "z9hG4bKpresence",
+		"Event: Presence;id=1"))
+	req := readRequest(t, conn)
+	if !CheckEvent(conn, req, supported) {
+		t.Error("presence was rejected")
+	}
+
+	writePipe(t, remote, testRequest(MethodSubscribe, "z9hG4bKreg",
+		"Event: reg"))
+	req = readRequest(t, conn)
+	results := make(chan bool, 1)
+	go func() {
+		results <- CheckEvent(conn, req, supported)
+	}()
+
+	resp, err := ReadResponse(strings.NewReader(readPipe(t, remote)))
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if resp.StatusCode != StatusBadEvent {
+		t.Errorf("responded with %d, expected a 489", resp.StatusCode)
+	}
+	if packages := resp.Header.AllowEvents(); !reflect.DeepEqual(packages, supported) {
+		t.Errorf("489 allows %q, expected %q", packages, supported)
+	}
+	if <-results {
+		t.Error("reg was accepted")
+	}
+}
@@ -0,0 +1,87 @@
+package sipnet
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrIntervalTooBrief is returned by ExpiryLimits.Grant if the requested
+// duration is shorter than the minimum.
+var ErrIntervalTooBrief = errors.New("sip: interval too brief")
+
+// ExpiryLimits bounds the durations of registrations and subscriptions
+// requested by the Expires of REGISTER and SUBSCRIBE requests.
+type ExpiryLimits struct {
+	// Min is the shortest duration accepted. Shorter durations are rejected
+	// with a 423 Interval Too Brief.
+	Min time.Duration
+
+	// Max is the longest duration granted. Longer durations are reduced to
+	// Max. If zero, there is no maximum.
+	Max time.Duration
+
+	// Default is the duration granted if the request has no Expires.
+	Default time.Duration
+}
+
+// Grant returns the duration granted for a request. An Expires of 0 (i.e.
+// to unsubscribe) is always granted.
+func (l ExpiryLimits) Grant(req *Request) (time.Duration, error) {
+	value := strings.TrimSpace(req.Header.Get("Expires"))
+	if value == "" {
+		return l.Default, nil
+	}
+
+	seconds, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, ErrParseError
+	}
+
+	expires := time.Duration(seconds) * time.Second
+	if expires == 0 {
+		return 0, nil
+	}
+
+	if expires < l.Min {
+		return 0, ErrIntervalTooBrief
+	}
+
+	if l.Max > 0 && expires > l.Max {
+		return l.Max, nil
+	}
+
+	return expires, nil
+}
+
+// Enforce returns the duration granted for a request, and whether it was
+// granted. If the requested duration is too short, the request is answered
+// with a 423 Interval Too Brief, and if its Expires is malformed, with a 400.
+// The granted duration should be returned in the Expires of the 200, with
+// SetExpires.
+func (l ExpiryLimits) Enforce(conn *Conn, req *Request) (time.Duration, bool) {
+	expires, err := l.Grant(req)
+	if err == ErrIntervalTooBrief {
+		NewResponse().IntervalTooBrief(conn, req, l.Min)
+		return 0, false
+	} else if err != nil {
+		NewResponse().BadRequest(conn, req, "Malformed Expires header.")
+		return 0, false
+	}
+
+	return expires, true
+}
+
+// SetExpires sets the Expires header to a duration in seconds.
+func (h Header) SetExpires(expires time.Duration) {
+	h.Set("Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+}
+
+// IntervalTooBrief responds to a Conn with a StatusIntervalTooBrief with
+// the minimum duration in Min-Expires for convenience.
+func (r *Response) IntervalTooBrief(conn *Conn, req *Request, min time.Duration) {
+	r.StatusCode = StatusIntervalTooBrief
+	r.Header.Set("Min-Expires", strconv.FormatInt(int64(min/time.Second), 10))
+	r.WriteTo(conn, req)
+}
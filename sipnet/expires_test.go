@@ -0,0 +1,69 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiryLimitsGrant(t *testing.T) {
+	limits := ExpiryLimits{
+		Min:     time.Minute,
+		Max:     time.Hour,
+		Default: 30 * time.Minute,
+	}
+
+	tests := []struct {
+		expires  string
+		granted  time.Duration
+		expected error
+	}{
+		{"", 30 * time.Minute, nil},
+		{"600", 10 * time.Minute, nil},
+		{"7200", time.Hour, nil},
+		{"0", 0, nil},
+		{"10", 0, ErrIntervalTooBrief},
+		{"soon", 0, ErrParseError},
+	}
+
+	for _, test := range tests {
+		req := NewRequest()
+		if test.expires != "" {
+			req.Header.Set("Expires", test.expires)
+		}
+
+		granted, err := limits.Grant(req)
+		if granted != test.granted || err != test.expected {
+			t.Errorf("Expires %q granted %v, %v, expected %v, %v",
+				test.expires, granted, err, test.granted, test.expected)
+		}
+	}
+}
+
+func TestSubscribeIntervalTooBrief(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodSubscribe, "z9hG4bKbrief",
+		"Event: presence", "Expires: 10"))
+	req, conn := acceptRequest(t, l)
+
+	limits := ExpiryLimits{Min: time.Minute, Default: time.Hour}
+	if _, ok := limits.Enforce(conn, req); ok {
+		t.Fatal("a subscription of 10 seconds was granted")
+	}
+
+	data, _ := readUDP(t, peer)
+	resp, err := ReadResponse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if resp.StatusCode != StatusIntervalTooBrief {
+		t.Errorf("answered with %d, expected 423", resp.StatusCode)
+	}
+	if min := resp.Header.Get("Min-Expires"); min != "60" {
+		t.Errorf("Min-Expires is %q, expected \"60\"", min)
+	}
+}
@@ -0,0 +1,51 @@
+package sipnet
+
+import "strings"
+
+// OptionTags returns the option tags listed under a header key such as
+// Require, Proxy-Require, Supported or Unsupported.
+func (h Header) OptionTags(key string) []string {
+	var tags []string
+	for _, value := range h.Values(key) {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+// HasOptionTag returns whether an option tag is listed under a header key,
+// ignoring case.
+func (h Header) HasOptionTag(key, tag string) bool {
+	for _, t := range h.OptionTags(key) {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedTags returns the option tags listed under a header key which
+// are not in supported.
+func (h Header) UnsupportedTags(key string, supported map[string]bool) []string {
+	var unsupported []string
+	for _, tag := range h.OptionTags(key) {
+		if !supported[strings.ToLower(tag)] {
+			unsupported = append(unsupported, tag)
+		}
+	}
+
+	return unsupported
+}
+
+// BadExtension responds to a Conn with a StatusBadExtension listing the
+// unsupported option tags for convenience.
+func (r *Response) BadExtension(conn *Conn, req *Request, unsupported []string) {
+	r.StatusCode = StatusBadExtension
+	r.Header.Set("Unsupported", strings.Join(unsupported, ", "))
+	r.WriteTo(conn, req)
+}
@@ -0,0 +1,277 @@
+package sipnet
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrNoAddresses is returned when dialing a host which has no addresses.
+var ErrNoAddresses = errors.New("sip: no addresses")
+
+// DefaultHappyEyeballsDelay is the head start of each connection attempt
+// over the next when racing them, if HappyEyeballsDelay is not configured
+// (RFC 8305 section 5).
+const DefaultHappyEyeballsDelay = 250 * time.Millisecond
+
+// AddressFamily is the IP address family preferred when a host resolves to
+// both IPv4 and IPv6 addresses.
+type AddressFamily int
+
+// Address family preferences.
+const (
+	// FamilyAny keeps the order of the addresses given by the resolver.
+	FamilyAny AddressFamily = iota
+	// FamilyIPv4 tries IPv4 addresses first.
+	FamilyIPv4
+	// FamilyIPv6 tries IPv6 addresses first.
+	FamilyIPv6
+)
+
+// addrFamily returns the family of an address (an IP or host:port), or
+// FamilyAny if its host is not an IP.
+func addrFamily(addr string) AddressFamily {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return FamilyAny
+	case ip.To4() != nil:
+		return FamilyIPv4
+	default:
+		return FamilyIPv6
+	}
+}
+
+// SortByFamily returns the addresses (IPs or host:port) ordered with those
+// of the preferred family first, keeping their order otherwise.
+func SortByFamily(addrs []string, family AddressFamily) []string {
+	if family == FamilyAny {
+		return addrs
+	}
+
+	sorted := make([]string, 0, len(addrs))
+	var others []string
+	for _, addr := range addrs {
+		if addrFamily(addr) == family {
+			sorted = append(sorted, addr)
+		} else {
+			others = append(others, addr)
+		}
+	}
+
+	return append(sorted, others...)
+}
+
+// interleaveFamilies returns the addresses alternating between families,
+// starting with the family of the first address, so a race of connection
+// attempts tries both families early (RFC 8305 section 4).
+func interleaveFamilies(addrs []string) []string {
+	if len(addrs) == 0 {
+		return addrs
+	}
+
+	first := addrFamily(addrs[0])
+	var preferred, others []string
+	for _, addr := range addrs {
+		if addrFamily(addr) == first {
+			preferred = append(preferred, addr)
+		} else {
+			others = append(others, addr)
+		}
+	}
+
+	interleaved := make([]string, 0, len(addrs))
+	for len(preferred) > 0 || len(others) > 0 {
+		if len(preferred) > 0 {
+			interleaved = append(interleaved, preferred[0])
+			preferred = preferred[1:]
+		}
+		if len(others) > 0 {
+			interleaved = append(interleaved, others[0])
+			others = others[1:]
+		}
+	}
+
+	return interleaved
+}
+
+// ResolveAddrs resolves the host of addr (host:port) into the addresses
+// (IP:port) to dial, with those of the preferred family first. An addr with
+// an IP host is returned as is. If dns is nil, SystemDNS is used.
+func ResolveAddrs(dns DNS, addr string, family AddressFamily) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+
+	if dns == nil {
+		dns = SystemDNS
+	}
+
+	ips, _, err := dns.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+
+	if len(addrs) == 0 {
+		return nil, ErrNoAddresses
+	}
+
+	return SortByFamily(addrs, family), nil
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// DialRace dials the addresses over a stream transport concurrently (Happy
+// Eyeballs, RFC 8305), and returns the first connection established. Each
+// attempt is given a head start of delay over the next, which starts
+// earlier if the attempt fails. The addresses are tried alternating
+// between families, starting with the family of the first address, and the
+// other connections are closed. The error of the last attempt is returned
+// if they all fail.
+func DialRace(t Transport, addrs []string, delay time.Duration) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoAddresses
+	}
+
+	addrs = interleaveFamilies(addrs)
+	results := make(chan dialResult, len(addrs))
+	start := func(addr string) {
+		go func() {
+			conn, err := t.Dial(addr)
+			results <- dialResult{conn, err}
+		}()
+	}
+
+	start(addrs[0])
+	next, pending := 1, 1
+	var lastErr error
+	for pending > 0 {
+		var headStart <-chan time.Time
+		if next < len(addrs) {
+			headStart = time.After(delay)
+		}
+
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				// Close the connections of the other attempts as they
+				// complete.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.err == nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return result.conn, nil
+			}
+			lastErr = result.err
+
+			if next < len(addrs) {
+				start(addrs[next])
+				next++
+				pending++
+			}
+		case <-headStart:
+			start(addrs[next])
+			next++
+			pending++
+		}
+	}
+
+	return nil, lastErr
+}
+
+// withServerName returns the transport dialing with host as its TLS server
+// name if it is a TLSTransport, so the certificate of a host dialed by its
+// resolved address is still verified against the host.
+func withServerName(t Transport, host string) Transport {
+	tlsTransport, ok := t.(*TLSTransport)
+	if !ok || net.ParseIP(host) != nil {
+		return t
+	}
+
+	config := new(tls.Config)
+	if current := tlsTransport.config(); current != nil {
+		config = current.Clone()
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	return &TLSTransport{
+		Config:            config,
+		ClientCertificate: tlsTransport.ClientCertificate,
+	}
+}
+
+// dialStream dials addr over a stream transport for the listener. If an
+// address family is preferred or HappyEyeballs is configured, the host is
+// resolved first, and its addresses are tried in order of preference, or
+// raced.
+func (l *Listener) dialStream(t Transport, addr string) (net.Conn, error) {
+	if l.config.PreferredFamily == FamilyAny && !l.config.HappyEyeballs {
+		return t.Dial(addr)
+	}
+
+	addrs, err := ResolveAddrs(l.config.DNS, addr, l.config.PreferredFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	t = withServerName(t, host)
+
+	if l.config.HappyEyeballs {
+		delay := l.config.HappyEyeballsDelay
+		if delay <= 0 {
+			delay = DefaultHappyEyeballsDelay
+		}
+		return DialRace(t, addrs, delay)
+	}
+
+	for _, target := range addrs {
+		var conn net.Conn
+		conn, err = t.Dial(target)
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// resolveUDP resolves addr for sending datagrams from the listener,
+// preferring the configured address family.
+func (l *Listener) resolveUDP(addr string) (*net.UDPAddr, error) {
+	if l.config.PreferredFamily != FamilyAny {
+		addrs, err := ResolveAddrs(l.config.DNS, addr, l.config.PreferredFamily)
+		if err != nil {
+			return nil, err
+		}
+		addr = addrs[0]
+	}
+
+	return net.ResolveUDPAddr("udp", addr)
+}
@@ -0,0 +1,194 @@
+package sipnet
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSortByFamily(t *testing.T) {
+	addrs := []string{"192.0.2.1:5060", "[2001:db8::1]:5060", "192.0.2.2",
+		"2001:db8::2"}
+	tests := []struct {
+		family AddressFamily
+		sorted []string
+	}{
+		{FamilyAny, addrs},
+		{FamilyIPv4, []string{"192.0.2.1:5060", "192.0.2.2",
+			"[2001:db8::1]:5060", "2001:db8::2"}},
+		{FamilyIPv6, []string{"[2001:db8::1]:5060", "2001:db8::2",
+			"192.0.2.1:5060", "192.0.2.2"}},
+	}
+
+	for _, test := range tests {
+		if sorted := SortByFamily(addrs, test.family); !reflect.DeepEqual(
+			sorted, test.sorted) {
+			t.Errorf("%d: sorted %q, expected %q", test.family, sorted,
+				test.sorted)
+		}
+	}
+}
+
+func TestResolveAddrs(t *testing.T) {
+	dns := &stubDNS{hosts: map[string][]string{
+		"dual.example.com":  {"192.0.2.1", "2001:db8::1", "192.0.2.2"},
+		"empty.example.com": {},
+	}}
+
+	addrs, err := ResolveAddrs(dns, "dual.example.com:5060", FamilyIPv6)
+	if err != nil {
+		t.Fatalf("failed to resolve: %v", err)
+	}
+	expected := []string{"[2001:db8::1]:5060", "192.0.2.1:5060",
+		"192.0.2.2:5060"}
+	if !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("resolved %q, expected %q", addrs, expected)
+	}
+
+	// An IP isn't resolved.
+	dns.queries = 0
+	addrs, err = ResolveAddrs(dns, "[2001:db8::5]:5061", FamilyIPv4)
+	if err != nil || len(addrs) != 1 || addrs[0] != "[2001:db8::5]:5061" ||
+		dns.queries != 0 {
+		t.Errorf("resolved an IP to %q, %v with %d queries", addrs, err,
+			dns.queries)
+	}
+
+	if _, err := ResolveAddrs(dns, "empty.example.com:5060",
+		FamilyAny); err != ErrNoAddresses {
+		t.Errorf("resolving a host without addresses returned %v", err)
+	}
+}
+
+// raceDial is how a raceTransport dials an address.
+type raceDial struct {
+	delay time.Duration
+	fail  bool
+}
+
+// raceTransport is a stream transport dialing pipes after the delay of
+// each address, which records the order the addresses are dialed in.
+type raceTransport struct {
+	Transport
+	dials map[string]raceDial
+
+	mutex   sync.Mutex
+	dialed  []string
+	conns   map[string]net.Conn
+	remotes map[string]net.Conn
+}
+
+func newRaceTransport(dials map[string]raceDial) *raceTransport {
+	return &raceTransport{
+		Transport: TCP,
+		dials:     dials,
+		conns:     make(map[string]net.Conn),
+		remotes:   make(map[string]net.Conn),
+	}
+}
+
+func (r *raceTransport) Dial(addr string) (net.Conn, error) {
+	r.mutex.Lock()
+	r.dialed = append(r.dialed, addr)
+	r.mutex.Unlock()
+
+	dial := r.dials[addr]
+	time.Sleep(dial.delay)
+	if dial.fail {
+		return nil, errors.New("unreachable " + addr)
+	}
+
+	local, remote := net.Pipe()
+	r.mutex.Lock()
+	r.conns[addr] = local
+	r.remotes[addr] = remote
+	r.mutex.Unlock()
+	return local, nil
+}
+
+// dialOrder returns the addresses dialed so far, in order.
+func (r *raceTransport) dialOrder() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.dialed...)
+}
+
+func TestDialRaceHeadStart(t *testing.T) {
+	const v6, v4 = "[2001:db8::1]:5060", "192.0.2.1:5060"
+	transport := newRaceTransport(map[string]raceDial{
+		v6: {delay: 300 * time.Millisecond},
+		v4: {},
+	})
+
+	// The IPv6 attempt is slower than its head start, so the IPv4 attempt
+	// wins, and the IPv6 connection is closed once established.
+	conn, err := DialRace(transport, []string{v6, v4}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	transport.mutex.Lock()
+	winner := transport.conns[v4]
+	transport.mutex.Unlock()
+	if conn != winner {
+		t.Error("the IPv4 connection didn't win the race")
+	}
+	if order := transport.dialOrder(); !reflect.DeepEqual(order,
+		[]string{v6, v4}) {
+		t.Errorf("dialed %q, expected the IPv6 address first", order)
+	}
+
+	waitFor(t, "the IPv6 connection to be established", func() bool {
+		transport.mutex.Lock()
+		defer transport.mutex.Unlock()
+		return transport.remotes[v6] != nil
+	})
+	transport.mutex.Lock()
+	loser := transport.remotes[v6]
+	transport.mutex.Unlock()
+	loser.SetReadDeadline(time.Now().Add(testTimeout))
+	if _, err := loser.Read(make([]byte, 1)); err == nil ||
+		isTimeout(err) {
+		t.Errorf("losing connection read with %v, expected it closed", err)
+	}
+}
+
+// isTimeout returns whether err is a timeout.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func TestDialRaceFailures(t *testing.T) {
+	const v6a, v6b, v4 = "[2001:db8::1]:5060", "[2001:db8::2]:5060",
+		"192.0.2.1:5060"
+	transport := newRaceTransport(map[string]raceDial{
+		v6a: {fail: true},
+		v6b: {fail: true},
+		v4:  {fail: true},
+	})
+
+	// A failed attempt starts the next without waiting for its head start,
+	// alternating between families.
+	start := time.Now()
+	_, err := DialRace(transport, []string{v6a, v6b, v4}, testTimeout)
+	if err == nil || err.Error() != "unreachable "+v6b {
+		t.Errorf("dialed with %v, expected the error of the last attempt", err)
+	}
+	if elapsed := time.Since(start); elapsed >= testTimeout {
+		t.Errorf("failed after %v, expected failures to skip the head start",
+			elapsed)
+	}
+	if order := transport.dialOrder(); !reflect.DeepEqual(order,
+		[]string{v6a, v4, v6b}) {
+		t.Errorf("dialed %q, expected the families interleaved", order)
+	}
+
+	if _, err := DialRace(transport, nil, testTimeout); err != ErrNoAddresses {
+		t.Errorf("dialing no addresses returned %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package sipnet
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParseFeatureCaps parses a Feature-Caps header value (RFC 6809) into its
+// feature-capability indicators, i.e. "*;+sip.pns=\"apns\"". Indicators
+// without a value have an empty value.
+func ParseFeatureCaps(str string) (HeaderArgs, error) {
+	str = strings.TrimSpace(str)
+	if !strings.HasPrefix(str, "*") {
+		return nil, ErrParseError
+	}
+
+	return ParsePairs(str[1:]), nil
+}
+
+// FeatureCapsString returns the Feature-Caps header value of the given
+// feature-capability indicators. Values are always quoted.
+func FeatureCapsString(caps HeaderArgs) string {
+	names := make([]string, 0, len(caps))
+	for name := range caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := "*"
+	for _, name := range names {
+		result += ";" + name
+		if caps[name] != "" {
+			result += "=" + QuoteString(caps[name])
+		}
+	}
+	return result
+}
+
+// FeatureCaps returns the feature-capability indicators of each Feature-Caps
+// value of a header in order, which are inserted by each proxy the message
+// passed through.
+func FeatureCaps(h Header) ([]HeaderArgs, error) {
+	var all []HeaderArgs
+	for _, value := range h.Values("Feature-Caps") {
+		for _, item := range splitUserList(value) {
+			caps, err := ParseFeatureCaps(item)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, caps)
+		}
+	}
+
+	return all, nil
+}
+
+// HasFeatureCap returns whether any Feature-Caps value of a header has the
+// given feature-capability indicator, i.e. "+sip.pns".
+func HasFeatureCap(h Header, name string) bool {
+	all, err := FeatureCaps(h)
+	if err != nil {
+		return false
+	}
+
+	for _, caps := range all {
+		if _, found := caps[name]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFeatureCaps adds a Feature-Caps value with the indicators of a proxy
+// forwarding the request, above those of previous proxies.
+func (r *Request) AddFeatureCaps(caps HeaderArgs) {
+	r.Header.Prepend("Feature-Caps", FeatureCapsString(caps))
+}
@@ -0,0 +1,75 @@
+package sipnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFeatureCaps(t *testing.T) {
+	caps, err := ParseFeatureCaps(`*;+sip.pns="apns";+sip.vapid="ab;cd";+sip.trans`)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	expected := HeaderArgs{
+		"+sip.pns":   "apns",
+		"+sip.vapid": "ab;cd",
+		"+sip.trans": "",
+	}
+	if !reflect.DeepEqual(caps, expected) {
+		t.Errorf("parsed %v, expected %v", caps, expected)
+	}
+
+	if _, err := ParseFeatureCaps(`+sip.pns="apns"`); err != ErrParseError {
+		t.Errorf("parsing a value without \"*\" returned %v, expected "+
+			"ErrParseError", err)
+	}
+}
+
+func TestFeatureCapsRoundTrip(t *testing.T) {
+	caps := HeaderArgs{
+		"+sip.pns":   "apns",
+		"+sip.trans": "",
+	}
+
+	str := FeatureCapsString(caps)
+	if str != `*;+sip.pns="apns";+sip.trans` {
+		t.Errorf("serialized %q", str)
+	}
+
+	parsed, err := ParseFeatureCaps(str)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", str, err)
+	}
+	if !reflect.DeepEqual(parsed, caps) {
+		t.Errorf("round-tripped to %v, expected %v", parsed, caps)
+	}
+}
+
+func TestForwardedFeatureCaps(t *testing.T) {
+	req := NewRequest()
+	req.Header.Add("Feature-Caps", `*;+sip.pns="apns", *;+sip.trans`)
+	req.AddFeatureCaps(HeaderArgs{"+sip.b2bua": ""})
+
+	all, err := FeatureCaps(req.Header)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	// The indicators of the last proxy come first.
+	expected := []HeaderArgs{
+		{"+sip.b2bua": ""},
+		{"+sip.pns": "apns"},
+		{"+sip.trans": ""},
+	}
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("parsed %v, expected %v", all, expected)
+	}
+
+	if !HasFeatureCap(req.Header, "+sip.trans") {
+		t.Error("+sip.trans wasn't found")
+	}
+	if HasFeatureCap(req.Header, "+sip.rendering") {
+		t.Error("+sip.rendering was found")
+	}
+}
@@ -0,0 +1,66 @@
+package sipnet
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNoGRUU is returned by ParseGRUU if the registrar didn't assign a GRUU
+// to the instance.
+var ErrNoGRUU = errors.New("sip: no gruu assigned")
+
+// InstanceID returns the instance ID of a Contact from its +sip.instance
+// parameter (RFC 5626), without the angle brackets, i.e. "urn:uuid:...".
+// It returns an empty string if the Contact has no instance ID.
+func (u User) InstanceID() string {
+	id := u.Arguments.Get("+sip.instance")
+	return strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+}
+
+// SetInstanceID sets the +sip.instance parameter of a Contact, to be sent in
+// a REGISTER to request a GRUU (RFC 5627).
+func (u *User) SetInstanceID(id string) {
+	if u.Arguments == nil {
+		u.Arguments = make(HeaderArgs)
+	}
+	u.Arguments.Set("+sip.instance", "<"+id+">")
+}
+
+// ParseGRUU returns the public and temporary GRUUs assigned by a registrar
+// to an instance, from the Contact of the 200 response to a REGISTER.
+func ParseGRUU(resp *Response, instanceID string) (URI, URI, error) {
+	contacts, err := ParseUsers(resp.Header, "Contact")
+	if err != nil {
+		return URI{}, URI{}, err
+	}
+
+	for _, contact := range contacts {
+		if contact.InstanceID() != instanceID ||
+			contact.Arguments.Get("pub-gruu") == "" {
+			continue
+		}
+
+		pub, err := ParseURI(contact.Arguments.Get("pub-gruu"))
+		if err != nil {
+			return URI{}, URI{}, err
+		}
+
+		var temp URI
+		if value := contact.Arguments.Get("temp-gruu"); value != "" {
+			temp, err = ParseURI(value)
+			if err != nil {
+				return URI{}, URI{}, err
+			}
+		}
+
+		return pub, temp, nil
+	}
+
+	return URI{}, URI{}, ErrNoGRUU
+}
+
+// IsGRUU returns whether a URI is a GRUU, which has the gr parameter.
+func (u URI) IsGRUU() bool {
+	_, found := u.Arguments["gr"]
+	return found
+}
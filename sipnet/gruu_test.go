@@ -0,0 +1,56 @@
+package sipnet
+
+import "testing"
+
+const testInstanceID = "urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6"
+
+func TestInstanceIDRoundTrip(t *testing.T) {
+	contact, err := ParseUser("<sip:alice@192.0.2.4;transport=tcp>")
+	if err != nil {
+		t.Fatalf("failed to parse contact: %v", err)
+	}
+	contact.SetInstanceID(testInstanceID)
+
+	parsed, err := ParseUser(contact.String())
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", contact.String(), err)
+	}
+	if parsed.InstanceID() != testInstanceID {
+		t.Errorf("instance ID is %q, expected %q", parsed.InstanceID(),
+			testInstanceID)
+	}
+
+	none, _ := ParseUser("<sip:alice@192.0.2.4>")
+	if none.InstanceID() != "" {
+		t.Errorf("instance ID is %q, expected none", none.InstanceID())
+	}
+}
+
+func TestParseGRUU(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	register := parseRequest(t, testRequest(MethodRegister, "z9hG4bK1"))
+	writePipe(t, remote, testResponse(register, "200 OK",
+		"Contact: <sip:alice@192.0.2.4>;+sip.instance=\"<"+testInstanceID+">\""+
+			";pub-gruu=\"sip:alice@example.com;gr="+testInstanceID+"\""+
+			";temp-gruu=\"sip:tgruu.7hs==jd7vnzga5w7fajsc7-ajd6fabz0f8g5@example.com;gr\""))
+	resp := readResponse(t, conn)
+
+	pub, temp, err := ParseGRUU(resp, testInstanceID)
+	if err != nil {
+		t.Fatalf("failed to parse GRUU: %v", err)
+	}
+	if !pub.IsGRUU() || pub.Arguments.Get("gr") != testInstanceID ||
+		pub.Username != "alice" {
+		t.Errorf("public GRUU is %q", pub)
+	}
+	if !temp.IsGRUU() || temp.Username != "tgruu.7hs==jd7vnzga5w7fajsc7-ajd6fabz0f8g5" {
+		t.Errorf("temporary GRUU is %q", temp)
+	}
+
+	if _, _, err := ParseGRUU(resp, "urn:uuid:other"); err != ErrNoGRUU {
+		t.Errorf("got error %v for another instance, expected ErrNoGRUU", err)
+	}
+}
@@ -2,38 +2,90 @@ package sipnet
 
 import (
 	"io"
+	"sort"
 	"strings"
 )
 
-// Header represents the headers of a SIP Request or Response.
-type Header map[string]string
+// Header represents the headers of a SIP Request or Response. A header may
+// have multiple values, which are kept in the order they were added.
+type Header map[string][]string
 
-// Del deletes the key and its value from the header. Deleting a non-existent
+// Add adds a value to a header key, after any existing values.
+func (h Header) Add(key, value string) {
+	key = normalizeKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Prepend adds a value to a header key, before any existing values.
+func (h Header) Prepend(key, value string) {
+	key = normalizeKey(key)
+	h[key] = append([]string{value}, h[key]...)
+}
+
+// Del deletes the key and its values from the header. Deleting a non-existent
 // key is a no-op.
 func (h Header) Del(key string) {
 	delete(h, normalizeKey(key))
 }
 
-// Get returns the value at a given key. It returns an empty string if the
-// key does not exist.
+// Get returns the first value at a given key. It returns an empty string if
+// the key does not exist.
 func (h Header) Get(key string) string {
+	values := h[normalizeKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// Values returns all of the values at a given key.
+func (h Header) Values(key string) []string {
 	return h[normalizeKey(key)]
 }
 
-// Set sets a header key with a value.
+// Set sets a header key with a value, replacing any existing values.
 func (h Header) Set(key, value string) {
-	h[normalizeKey(key)] = value
+	h[normalizeKey(key)] = []string{value}
+}
+
+// Each calls f for every value of every header key. Keys are visited in
+// sorted order, and the values of a key in the order they were added.
+func (h Header) Each(f func(key, value string)) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range h[key] {
+			f(key, value)
+		}
+	}
+}
+
+// Clone returns a deep copy of the header.
+func (h Header) Clone() Header {
+	clone := make(Header, len(h))
+	for key, values := range h {
+		clone[key] = append([]string(nil), values...)
+	}
+
+	return clone
 }
 
 // WriteTo writes the header data to a writer, with an additional CRLF
 // (i.e. "\r\n") at the end.
 func (h Header) WriteTo(w io.Writer) (int64, error) {
 	var total int64
-	for key, value := range h {
-		n, err := w.Write([]byte(key + ": " + value + "\r\n"))
-		total += int64(n)
-		if err != nil {
-			return total, err
+	for key, values := range h {
+		for _, value := range values {
+			n, err := w.Write([]byte(key + ": " + value + "\r\n"))
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
 		}
 	}
 
@@ -42,6 +94,71 @@ func (h Header) WriteTo(w io.Writer) (int64, error) {
 	return total, err
 }
 
+// writeHeader writes a header like Header.WriteTo, except that the values
+// of headers in raw are replaced by the raw lines, which are written
+// unchanged. The raw lines of a header are only written if it still has
+// the values parsed from them, so a header changed or deleted since it was
+// parsed, such as a Route rewritten by a proxy, is written from h instead.
+// A raw Content-Length is never written, as the body may have changed since
+// it was received.
+func writeHeader(w io.Writer, h Header, raw []string) (int64, error) {
+	if len(raw) == 0 {
+		return h.WriteTo(w)
+	}
+
+	parsed := make(map[string][]string)
+	for _, line := range raw {
+		if i := strings.Index(line, ":"); i >= 0 {
+			key := normalizeKey(strings.TrimSpace(line[:i]))
+			parsed[key] = append(parsed[key], strings.TrimSpace(line[i+1:]))
+		}
+	}
+
+	filtered := h.Clone()
+	var lines []string
+	for _, line := range raw {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			lines = append(lines, line)
+			continue
+		}
+
+		key := normalizeKey(strings.TrimSpace(line[:i]))
+		if key == "Content-Length" || key == "L" ||
+			!sameValues(h[key], parsed[key]) {
+			continue
+		}
+		filtered.Del(key)
+		lines = append(lines, line)
+	}
+
+	var total int64
+	for _, line := range lines {
+		n, err := w.Write([]byte(line + "\r\n"))
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	n, err := filtered.WriteTo(w)
+	return total + n, err
+}
+
+// sameValues returns whether two lists of header values are equal.
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func normalizeKey(key string) string {
 	return strings.Title(strings.ToLower(key))
 }
@@ -2,7 +2,6 @@ package sipnet
 
 import (
 	"bytes"
-	"strconv"
 	"strings"
 )
 
@@ -59,11 +58,15 @@ func ParseList(value string) []string {
 func ParsePairs(value string) HeaderArgs {
 	m := make(HeaderArgs)
 	for _, pair := range ParseList(strings.TrimSpace(value)) {
+		if pair == "" {
+			continue
+		}
+
 		if i := strings.Index(pair, "="); i < 0 {
 			m[pair] = ""
 		} else {
 			v := pair[i+1:]
-			if v[0] == '"' && v[len(v)-1] == '"' {
+			if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
 				v = v[1 : len(v)-1]
 			}
 			m[pair[:i]] = v
@@ -100,14 +103,29 @@ func (h HeaderArgs) Set(key, value string) {
 }
 
 // SemicolonString returns the header arguments as a semicolon
-// separated unquoted strings with a leading semicolon.
+// separated string with a leading semicolon. Values are quoted if they
+// are not tokens.
 func (h HeaderArgs) SemicolonString() string {
 	var result string
 	for key, value := range h {
 		if value == "" {
 			result += ";" + key
 		} else {
-			result += ";" + key + "=" + value
+			result += ";" + key + "=" + QuoteParam(value)
+		}
+	}
+	return result
+}
+
+// uriString returns the header arguments as URI parameters, with a leading
+// semicolon. Names and values are escaped.
+func (h HeaderArgs) uriString() string {
+	var result string
+	for key, value := range h {
+		if value == "" {
+			result += ";" + EscapeURIParam(key)
+		} else {
+			result += ";" + EscapeURIParam(key) + "=" + EscapeURIParam(value)
 		}
 	}
 	return result
@@ -122,7 +140,7 @@ func (h HeaderArgs) CommaString() string {
 
 	var result string
 	for key, value := range h {
-		result += key + "=" + strconv.Quote(value) + ", "
+		result += key + "=" + QuoteString(value) + ", "
 	}
 	return result[:len(result)-2]
 }
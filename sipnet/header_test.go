@@ -0,0 +1,133 @@
+package sipnet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMultiValuedRoute(t *testing.T) {
+	h := make(Header)
+	h.Add("Route", "<sip:p1.example.com;lr>")
+	h.Add("route", "<sip:p2.example.com;lr>")
+	h.Prepend("ROUTE", "<sip:p0.example.com;lr>")
+
+	expected := []string{
+		"<sip:p0.example.com;lr>",
+		"<sip:p1.example.com;lr>",
+		"<sip:p2.example.com;lr>",
+	}
+	if values := h.Values("Route"); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Route is %q after adding, expected %q", values, expected)
+	}
+
+	var visited []string
+	h.Each(func(key, value string) {
+		visited = append(visited, key+": "+value)
+	})
+	if len(visited) != 3 || visited[0] != "Route: <sip:p0.example.com;lr>" {
+		t.Errorf("Each visited %q, expected the Route values in order", visited)
+	}
+
+	h.Set("Route", "<sip:p3.example.com;lr>")
+	if values := h.Values("Route"); len(values) != 1 ||
+		values[0] != "<sip:p3.example.com;lr>" {
+		t.Errorf("Route is %q after replacing, expected a single value", values)
+	}
+
+	h.Del("route")
+	if values := h.Values("Route"); len(values) != 0 {
+		t.Errorf("Route is %q after deleting, expected no values", values)
+	}
+}
+
+func TestMutatedHeaderSerialized(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKmutate",
+		"Route: <sip:p1.example.com;lr>", "Route: <sip:p2.example.com;lr>"))
+
+	req.Header.Set("Route", req.Header.Values("Route")[1])
+	req.Header.Add("Route", "<sip:p3.example.com;lr>")
+	req.SetBody([]byte("body"))
+
+	data := writeRequest(t, req)
+	if strings.Contains(data, "p1.example.com") {
+		t.Error("deleted Route value was written")
+	}
+	if !strings.Contains(data, "Route: <sip:p2.example.com;lr>\r\n"+
+		"Route: <sip:p3.example.com;lr>\r\n") {
+		t.Errorf("Route values not written in order: %q", data)
+	}
+	if !strings.Contains(data, "Content-Length: 4\r\n") {
+		t.Error("Content-Length wasn't recomputed for the new body")
+	}
+}
+
+func TestPreservedHeaderByteForByte(t *testing.T) {
+	identity := "identity:  eyJhbGciOiJFUzI1NiJ9.e30.c2ln ;info=<https://cert.example.org/passport.cer>;alg=ES256"
+	parser := &Parser{PreserveHeaders: []string{"Identity"}}
+	req, err := parser.ReadRequest(strings.NewReader(testRequest(MethodInvite,
+		"z9hG4bK1", identity)))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	written := writeRequest(t, req)
+	if !strings.Contains(written, "\r\n"+identity+"\r\n") {
+		t.Errorf("preserved header not written verbatim:\n%s", written)
+	}
+	if strings.Count(strings.ToLower(written), "identity:") != 1 {
+		t.Errorf("preserved header written more than once:\n%s", written)
+	}
+}
+
+func TestChangedPreservedHeaderRewritten(t *testing.T) {
+	parser := &Parser{PreserveHeaders: []string{"Route"}}
+	req, err := parser.ReadRequest(strings.NewReader(testRequest(MethodInvite,
+		"z9hG4bK1", "route:<sip:p1.example.com;lr>")))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	// A proxy which rewrites a preserved header sends the new value.
+	req.Header.Set("Route", "<sip:p2.example.com;lr>")
+	written := writeRequest(t, req)
+	if strings.Contains(written, "p1.example.com") ||
+		!strings.Contains(written, "p2.example.com") {
+		t.Errorf("changed header not written from Header:\n%s", written)
+	}
+}
+
+func TestUnknownHeaderForwarded(t *testing.T) {
+	foo := "x-foo:  bar ;baz=\"qux\""
+	for _, preserve := range []bool{false, true} {
+		parser := &Parser{PreserveUnknownHeaders: preserve}
+		req, err := parser.ReadRequest(strings.NewReader(testRequest(MethodMessage,
+			"z9hG4bK1", foo)))
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+		if value := req.Header.Get("X-Foo"); value != "bar ;baz=\"qux\"" {
+			t.Errorf("X-Foo is %q", value)
+		}
+
+		// The request is forwarded by a proxy.
+		req.Header.Prepend("Via", "SIP/2.0/TCP 127.0.0.2:5060;branch=z9hG4bK2")
+		req.SetMaxForwards(69)
+		written := writeRequest(t, req)
+
+		if preserve && !strings.Contains(written, "\r\n"+foo+"\r\n") {
+			t.Errorf("unknown header not forwarded verbatim:\n%s", written)
+		} else if !preserve && !strings.Contains(written,
+			"\r\nX-Foo: bar ;baz=\"qux\"\r\n") {
+			t.Errorf("unknown header not forwarded:\n%s", written)
+		}
+		if strings.Count(strings.ToLower(written), "x-foo:") != 1 {
+			t.Errorf("unknown header forwarded more than once:\n%s", written)
+		}
+
+		// Known headers are still written from Header.
+		if !strings.Contains(written, "\r\nMax-Forwards: 69\r\n") {
+			t.Errorf("Max-Forwards not rewritten:\n%s", written)
+		}
+	}
+}
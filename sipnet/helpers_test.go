@@ -0,0 +1,385 @@
+package sipnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTimeout is how long tests wait for a message which is expected to
+// arrive.
+const testTimeout = 2 * time.Second
+
+// quietTimeout is how long tests wait for a message which is expected not
+// to arrive.
+const quietTimeout = 200 * time.Millisecond
+
+// testRequest returns a serialized request with the given method and top
+// Via branch, sent by a UA at 127.0.0.1:5070. Extra header lines are added
+// before the Content-Length.
+func testRequest(method, branch string, extra ...string) string {
+	msg := method + " sip:bob@127.0.0.1 SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 127.0.0.1:5070;branch=" + branch + "\r\n" +
+		"From: <sip:alice@127.0.0.1>;tag=a1\r\n" +
+		"To: <sip:bob@127.0.0.1>\r\n" +
+		"Call-ID: call1@127.0.0.1\r\n" +
+		"CSeq: 1 " + method + "\r\n" +
+		"Max-Forwards: 70\r\n"
+	for _, line := range extra {
+		msg += line + "\r\n"
+	}
+	return msg + "Content-Length: 0\r\n\r\n"
+}
+
+// startLine returns the first line of a serialized message.
+func startLine(msg string) string {
+	return strings.SplitN(msg, "\r\n", 2)[0]
+}
+
+// goWrite calls write from a goroutine of its own, returning a channel
+// receiving its error, as writes to a pipe block until they are read.
+func goWrite(write func() error) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- write()
+	}()
+	return errs
+}
+
+// writePipe writes a message to the remote end of a pipe from NewPipeConn.
+func writePipe(t *testing.T, remote net.Conn, msg string) {
+	t.Helper()
+
+	remote.SetWriteDeadline(time.Now().Add(testTimeout))
+	if _, err := remote.Write([]byte(msg)); err != nil {
+		t.Fatalf("failed to write to pipe: %v", err)
+	}
+}
+
+// readPipe reads the data of the next write of a Conn from the remote end of
+// a pipe from NewPipeConn.
+func readPipe(t *testing.T, remote net.Conn) string {
+	t.Helper()
+
+	buf := make([]byte, 65535)
+	remote.SetReadDeadline(time.Now().Add(testTimeout))
+	n, err := remote.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read from pipe: %v", err)
+	}
+	return string(buf[:n])
+}
+
+// expectNoPipeData fails the test if the Conn writes anything to the remote
+// end of a pipe from NewPipeConn within quietTimeout.
+func expectNoPipeData(t *testing.T, remote net.Conn) {
+	t.Helper()
+
+	buf := make([]byte, 65535)
+	remote.SetReadDeadline(time.Now().Add(quietTimeout))
+	n, err := remote.Read(buf)
+	if err == nil {
+		t.Fatalf("unexpected data written: %q", buf[:n])
+	}
+}
+
+// readConn reads the next message received by a locked Conn.
+func readConn(t *testing.T, conn *Conn) interface{} {
+	t.Helper()
+
+	msg, err := conn.ReadTimeout(testTimeout)
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	return msg
+}
+
+// readRequest reads the next message received by a locked Conn, which must
+// be a request.
+func readRequest(t *testing.T, conn *Conn) *Request {
+	t.Helper()
+
+	msg := readConn(t, conn)
+	req, ok := msg.(*Request)
+	if !ok {
+		t.Fatalf("read %T, expected a request", msg)
+	}
+	return req
+}
+
+// readResponse reads the next message received by a locked Conn, which must
+// be a response.
+func readResponse(t *testing.T, conn *Conn) *Response {
+	t.Helper()
+
+	msg := readConn(t, conn)
+	resp, ok := msg.(*Response)
+	if !ok {
+		t.Fatalf("read %T, expected a response", msg)
+	}
+	return resp
+}
+
+// expectNoMessage fails the test if a locked Conn receives a message within
+// quietTimeout.
+func expectNoMessage(t *testing.T, conn *Conn) {
+	t.Helper()
+
+	msg, err := conn.ReadTimeout(quietTimeout)
+	if err != ErrTimeout {
+		t.Fatalf("unexpected message read: %v, %v", msg, err)
+	}
+}
+
+// respond writes a response with the given status code and To tag to req
+// from a goroutine of its own, returning a channel receiving its error.
+func respond(conn *Conn, req *Request, statusCode int, toTag string) <-chan error {
+	resp := NewResponse()
+	resp.StatusCode = statusCode
+	resp.Header.Set("From", req.Header.Get("From"))
+	resp.Header.Set("To", req.Header.Get("To"))
+	if toTag != "" {
+		resp.Header.Set("To", req.Header.Get("To")+";tag="+toTag)
+	}
+
+	return goWrite(func() error {
+		return resp.WriteTo(conn, req)
+	})
+}
+
+// listenTest listens on an ephemeral port of the loopback interface with
+// the given configuration.
+func listenTest(t *testing.T, config Config) *Listener {
+	t.Helper()
+
+	l, err := ListenWithConfig("127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return l
+}
+
+// udpPeer returns a UDP socket on an ephemeral port of the loopback
+// interface, acting as a remote UA.
+func udpPeer(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	return peer
+}
+
+// sendUDP sends a message from a UDP peer to the UDP socket of a listener.
+func sendUDP(t *testing.T, peer net.PacketConn, l *Listener, msg string) {
+	t.Helper()
+
+	if _, err := peer.WriteTo([]byte(msg), l.TransportAddr("udp")); err != nil {
+		t.Fatalf("failed to send datagram: %v", err)
+	}
+}
+
+// readUDP reads the next datagram received by a UDP peer.
+func readUDP(t *testing.T, peer net.PacketConn) (string, net.Addr) {
+	t.Helper()
+
+	buf := make([]byte, 65535)
+	peer.SetReadDeadline(time.Now().Add(testTimeout))
+	n, addr, err := peer.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("failed to read datagram: %v", err)
+	}
+	return string(buf[:n]), addr
+}
+
+// expectNoUDP fails the test if a UDP peer receives a datagram within
+// quietTimeout.
+func expectNoUDP(t *testing.T, peer net.PacketConn) {
+	t.Helper()
+
+	buf := make([]byte, 65535)
+	peer.SetReadDeadline(time.Now().Add(quietTimeout))
+	n, _, err := peer.ReadFrom(buf)
+	if err == nil {
+		t.Fatalf("unexpected datagram received: %q", buf[:n])
+	}
+}
+
+// acceptResult is the result of Listener.AcceptRequest.
+type acceptResult struct {
+	req  *Request
+	conn *Conn
+	err  error
+}
+
+// acceptRequest accepts the next request received by a listener.
+func acceptRequest(t *testing.T, l *Listener) (*Request, *Conn) {
+	t.Helper()
+
+	results := make(chan acceptResult, 1)
+	go func() {
+		req, conn, err := l.AcceptRequest()
+		results <- acceptResult{req, conn, err}
+	}()
+
+	select {
+	case result := <-results:
+		if result.err != nil {
+			t.Fatalf("failed to accept request: %v", result.err)
+		}
+		return result.req, result.conn
+	case <-time.After(testTimeout):
+		t.Fatal("timed out accepting request")
+	}
+	return nil, nil
+}
+
+// waitFor polls cond until it returns true, failing the test with the
+// description of what was waited for if it doesn't within testTimeout.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeRequest returns a request as it is written to a TCP Conn.
+func writeRequest(t *testing.T, req *Request) string {
+	t.Helper()
+
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+
+	errs := goWrite(func() error {
+		return req.WriteTo(conn)
+	})
+	data := readPipe(t, remote)
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+	return data
+}
+
+// writeResponse returns a response to req as it is written to a TCP Conn.
+func writeResponse(t *testing.T, resp *Response, req *Request) string {
+	t.Helper()
+
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+
+	errs := goWrite(func() error {
+		return resp.WriteTo(conn, req)
+	})
+	data := readPipe(t, remote)
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+	return data
+}
+
+// parseRequest parses a serialized request with the default parser.
+func parseRequest(t *testing.T, msg string) *Request {
+	t.Helper()
+
+	req, err := ReadRequest(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("failed to parse request: %v", err)
+	}
+	return req
+}
+
+// freeUDPPort returns a UDP port of the loopback interface which was free
+// when it was checked.
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+
+	peer := udpPeer(t)
+	defer peer.Close()
+	return peer.LocalAddr().(*net.UDPAddr).Port
+}
+
+// newTestRequest returns a request from alice to the given Request-URI, to
+// be sent with Conn.Do.
+func newTestRequest(method, server string) *Request {
+	req := NewRequest()
+	req.Method = method
+	req.Server = server
+	req.Header.Set("From", "<sip:alice@127.0.0.1>;tag=a1")
+	req.Header.Set("To", "<sip:bob@127.0.0.1>")
+	req.Header.Set("Call-ID", NewCallID("127.0.0.1"))
+	req.Header.Set("CSeq", "1 "+method)
+	return req
+}
+
+// testResponse returns a serialized response to a request, with the given
+// status line (i.e. "200 OK") and a To tag. Extra header lines are added
+// before the Content-Length.
+func testResponse(req *Request, status string, extra ...string) string {
+	msg := SIPVersion + " " + status + "\r\n"
+	for _, via := range req.Header.Values("Via") {
+		msg += "Via: " + via + "\r\n"
+	}
+
+	to := req.Header.Get("To")
+	if !strings.Contains(to, "tag=") {
+		to += ";tag=b1"
+	}
+	msg += "From: " + req.Header.Get("From") + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Call-ID: " + req.Header.Get("Call-ID") + "\r\n" +
+		"CSeq: " + req.Header.Get("CSeq") + "\r\n"
+	for _, line := range extra {
+		msg += line + "\r\n"
+	}
+	return msg + "Content-Length: 0\r\n\r\n"
+}
+
+// readUDPRequest reads the next datagram received by a UDP peer, which
+// must be a request.
+func readUDPRequest(t *testing.T, peer net.PacketConn) (*Request, net.Addr) {
+	t.Helper()
+
+	data, addr := readUDP(t, peer)
+	return parseRequest(t, data), addr
+}
+
+// waitForTimer waits until a timer or ticker of a FakeClock is due at the
+// given time, such as one started concurrently by the code under test.
+func waitForTimer(t *testing.T, clock *FakeClock, at time.Time) {
+	t.Helper()
+
+	waitFor(t, "a timer due at "+at.String(), func() bool {
+		clock.mutex.Lock()
+		defer clock.mutex.Unlock()
+		for _, w := range clock.waiters {
+			if !w.stopped && w.at.Equal(at) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// doResult is the result of Conn.Do.
+type doResult struct {
+	resp *Response
+	err  error
+}
+
+// goDo sends a request with Conn.DoProgress from a goroutine of its own,
+// returning a channel receiving its result.
+func goDo(conn *Conn, req *Request, progress func(*Response)) <-chan doResult {
+	results := make(chan doResult, 1)
+	go func() {
+		resp, err := conn.DoProgress(req, progress)
+		results <- doResult{resp, err}
+	}()
+	return results
+}
@@ -0,0 +1,151 @@
+package sipnet
+
+import (
+	"net"
+	"strings"
+)
+
+// PrivacyID is the Privacy (RFC 3323) value requesting that the network
+// asserted identity of the user is withheld outside of the trust domain.
+const PrivacyID = "id"
+
+// ParseAssertedIdentity returns the P-Asserted-Identity (RFC 3325) entries
+// of a header, which are the identities of the user asserted by a node of a
+// trust domain, such as a SIP URI and a tel URI.
+func ParseAssertedIdentity(h Header) ([]User, error) {
+	return ParseUsers(h, "P-Asserted-Identity")
+}
+
+// ParsePreferredIdentity returns the P-Preferred-Identity (RFC 3325)
+// entries of a header, which are the identities a UA would like asserted
+// for it by the trust domain.
+func ParsePreferredIdentity(h Header) ([]User, error) {
+	return ParseUsers(h, "P-Preferred-Identity")
+}
+
+// HasPrivacy returns whether the Privacy header (RFC 3323) of a message
+// requests the given type of privacy, i.e. PrivacyID.
+func (h Header) HasPrivacy(privacy string) bool {
+	for _, value := range h.Values("Privacy") {
+		for _, priv := range strings.Split(value, ";") {
+			if strings.EqualFold(strings.TrimSpace(priv), privacy) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TrustDomain is the set of nodes trusted to assert the identity of users
+// with P-Asserted-Identity (RFC 3325), identified by their addresses. The
+// zero value trusts no node, and it is safe to use from multiple goroutines
+// once created.
+type TrustDomain struct {
+	networks []*net.IPNet
+}
+
+// NewTrustDomain returns a trust domain of the given IPs and CIDR networks
+// (i.e. "10.0.0.0/8"). ErrParseError is returned if one is malformed.
+func NewTrustDomain(trusted ...string) (*TrustDomain, error) {
+	t := &TrustDomain{}
+	for _, str := range trusted {
+		if !strings.Contains(str, "/") {
+			ip := net.ParseIP(str)
+			if ip == nil {
+				return nil, ErrParseError
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			t.networks = append(t.networks, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(str)
+		if err != nil {
+			return nil, ErrParseError
+		}
+		t.networks = append(t.networks, network)
+	}
+
+	return t, nil
+}
+
+// Trusts returns whether the node at addr is within the trust domain.
+func (t *TrustDomain) Trusts(addr net.Addr) bool {
+	if t == nil || addr == nil {
+		return false
+	}
+
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Receive applies the trust boundary to a request received from its
+// RemoteAddr. A request from within the trust domain is unchanged. From
+// outside of it, any P-Asserted-Identity is removed as it can't be
+// trusted, and the P-Preferred-Identity is asserted in its place if
+// authorized is true, such as once the UA has authenticated as a user
+// allowed to use those identities, and is otherwise removed.
+func (t *TrustDomain) Receive(req *Request, authorized bool) {
+	if t.Trusts(req.RemoteAddr) {
+		return
+	}
+
+	req.Header.Del("P-Asserted-Identity")
+
+	preferred, err := ParsePreferredIdentity(req.Header)
+	req.Header.Del("P-Preferred-Identity")
+	if err != nil || !authorized {
+		return
+	}
+
+	for _, identity := range preferred {
+		req.Header.Add("P-Asserted-Identity", identity.String())
+	}
+}
+
+// Forward applies the trust boundary to a request forwarded to addr.
+// P-Preferred-Identity is always removed, as it is only meant for the first
+// node of the trust domain. Toward a node within the trust domain, the
+// asserted identities are added as P-Asserted-Identity if the request
+// doesn't have one yet. Toward a node outside of it, P-Asserted-Identity is
+// removed if the request asks for PrivacyID.
+func (t *TrustDomain) Forward(req *Request, addr net.Addr, asserted ...User) {
+	req.Header.Del("P-Preferred-Identity")
+
+	if !t.Trusts(addr) {
+		if req.Header.HasPrivacy(PrivacyID) {
+			req.Header.Del("P-Asserted-Identity")
+		}
+		return
+	}
+
+	if len(req.Header.Values("P-Asserted-Identity")) > 0 {
+		return
+	}
+
+	for _, identity := range asserted {
+		req.Header.Add("P-Asserted-Identity", identity.String())
+	}
+}
@@ -0,0 +1,137 @@
+package sipnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// identities returns the SIP URIs of the identities in a header.
+func identities(t *testing.T, h Header, parse func(Header) ([]User, error)) []string {
+	t.Helper()
+
+	users, err := parse(h)
+	if err != nil {
+		t.Fatalf("failed to parse identities: %v", err)
+	}
+
+	var uris []string
+	for _, user := range users {
+		uris = append(uris, user.URI.SchemeUserDomain())
+	}
+	return uris
+}
+
+// testTrustDomain returns a trust domain of 10.0.0.0/8 and 192.0.2.1.
+func testTrustDomain(t *testing.T) *TrustDomain {
+	t.Helper()
+
+	trust, err := NewTrustDomain("10.0.0.0/8", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("failed to create the trust domain: %v", err)
+	}
+	return trust
+}
+
+func TestTrustDomainReceive(t *testing.T) {
+	trust := testTrustDomain(t)
+	tests := []struct {
+		name       string
+		remote     string
+		authorized bool
+		asserted   string
+		preferred  string
+	}{
+		{"untrusted", "198.51.100.1:5060", false, "", ""},
+		{"untrusted and authorized", "198.51.100.1:5060", true,
+			"sip:alice@example.com", ""},
+		{"trusted", "10.1.2.3:5060", false, "sip:forged@example.com",
+			"sip:alice@example.com"},
+	}
+
+	for _, test := range tests {
+		req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKidentity",
+			"P-Asserted-Identity: <sip:forged@example.com>",
+			"P-Preferred-Identity: <sip:alice@example.com>"))
+		req.RemoteAddr, _ = net.ResolveUDPAddr("udp", test.remote)
+		trust.Receive(req, test.authorized)
+
+		asserted := strings.Join(identities(t, req.Header,
+			ParseAssertedIdentity), ", ")
+		if asserted != test.asserted {
+			t.Errorf("%s: asserted %q, expected %q", test.name, asserted,
+				test.asserted)
+		}
+		preferred := strings.Join(identities(t, req.Header,
+			ParsePreferredIdentity), ", ")
+		if preferred != test.preferred {
+			t.Errorf("%s: preferred %q, expected %q", test.name, preferred,
+				test.preferred)
+		}
+	}
+}
+
+func TestTrustDomainForward(t *testing.T) {
+	trust := testTrustDomain(t)
+	alice := User{URI: URI{Scheme: "sip", Username: "alice",
+		Domain: "example.com"}, Arguments: make(HeaderArgs)}
+	trusted := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5060}
+	untrusted := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}
+
+	// Toward the trust domain the identity is asserted, in place of the
+	// preferred identity.
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKforward",
+		"P-Preferred-Identity: <sip:alice@example.com>"))
+	trust.Forward(req, trusted, alice)
+	if asserted := identities(t, req.Header, ParseAssertedIdentity); len(
+		asserted) != 1 || asserted[0] != "sip:alice@example.com" {
+		t.Errorf("asserted %q toward the trust domain", asserted)
+	}
+	if preferred := req.Header.Get("P-Preferred-Identity"); preferred != "" {
+		t.Errorf("P-Preferred-Identity %q was forwarded", preferred)
+	}
+
+	// Outside of it the identity is only withheld if privacy is requested.
+	tests := []struct {
+		privacy string
+		kept    bool
+	}{
+		{"", true},
+		{"header; id", false},
+	}
+	for _, test := range tests {
+		req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKforward",
+			"P-Asserted-Identity: <sip:alice@example.com>"))
+		if test.privacy != "" {
+			req.Header.Set("Privacy", test.privacy)
+		}
+		trust.Forward(req, untrusted)
+		if kept := req.Header.Get("P-Asserted-Identity") != ""; kept != test.kept {
+			t.Errorf("Privacy %q: P-Asserted-Identity kept is %v", test.privacy,
+				kept)
+		}
+	}
+}
+
+func TestNewTrustDomain(t *testing.T) {
+	if _, err := NewTrustDomain("10.0.0.0/33"); err != ErrParseError {
+		t.Errorf("a malformed network returned %v", err)
+	}
+	if _, err := NewTrustDomain("proxy.example.com"); err != ErrParseError {
+		t.Errorf("a host name returned %v", err)
+	}
+
+	trust, err := NewTrustDomain("2001:db8::1")
+	if err != nil {
+		t.Fatalf("failed to create the trust domain: %v", err)
+	}
+	if !trust.Trusts(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5061}) {
+		t.Error("the trusted IPv6 address isn't trusted")
+	}
+	if trust.Trusts(&net.TCPAddr{IP: net.ParseIP("2001:db8::2")}) {
+		t.Error("another IPv6 address is trusted")
+	}
+	if (*TrustDomain)(nil).Trusts(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}) {
+		t.Error("a nil trust domain trusts an address")
+	}
+}
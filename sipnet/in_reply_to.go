@@ -0,0 +1,46 @@
+package sipnet
+
+import "strings"
+
+// InReplyTo returns the Call-IDs of the In-Reply-To header, which are the
+// calls a request is returning, such as a call back to a missed call.
+func (h Header) InReplyTo() []string {
+	var callIDs []string
+	for _, value := range h.Values("In-Reply-To") {
+		for _, callID := range strings.Split(value, ",") {
+			if callID = strings.TrimSpace(callID); callID != "" {
+				callIDs = append(callIDs, callID)
+			}
+		}
+	}
+
+	return callIDs
+}
+
+// SetInReplyTo sets the In-Reply-To header to the given Call-IDs, or removes
+// it if there are none.
+func (h Header) SetInReplyTo(callIDs []string) {
+	if len(callIDs) == 0 {
+		h.Del("In-Reply-To")
+		return
+	}
+
+	h.Set("In-Reply-To", strings.Join(callIDs, ", "))
+}
+
+// Organization returns the Organization header, which is the name of the
+// organization the sender of the message belongs to.
+func (h Header) Organization() string {
+	return strings.TrimSpace(h.Get("Organization"))
+}
+
+// SetOrganization sets the Organization header, or removes it if
+// organization is empty.
+func (h Header) SetOrganization(organization string) {
+	if organization == "" {
+		h.Del("Organization")
+		return
+	}
+
+	h.Set("Organization", organization)
+}
@@ -0,0 +1,47 @@
+package sipnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInReplyToRoundTrip(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKreply",
+		"In-Reply-To: 70710@saturn.bell-tel.com,  17320@saturn.bell-tel.com",
+		"In-Reply-To: 9876@example.com"))
+
+	expected := []string{"70710@saturn.bell-tel.com",
+		"17320@saturn.bell-tel.com", "9876@example.com"}
+	if callIDs := req.Header.InReplyTo(); !reflect.DeepEqual(callIDs, expected) {
+		t.Fatalf("parsed %q, expected %q", callIDs, expected)
+	}
+
+	req.Header.SetInReplyTo(expected[:2])
+	parsed := parseRequest(t, writeRequest(t, req))
+	if callIDs := parsed.Header.InReplyTo(); !reflect.DeepEqual(callIDs, expected[:2]) {
+		t.Errorf("round-tripped to %q, expected %q", callIDs, expected[:2])
+	}
+
+	parsed.Header.SetInReplyTo(nil)
+	if values := parsed.Header.Values("In-Reply-To"); len(values) != 0 {
+		t.Errorf("In-Reply-To is %q after removing it", values)
+	}
+}
+
+func TestOrganizationRoundTrip(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKorg"))
+	if organization := req.Header.Organization(); organization != "" {
+		t.Errorf("Organization is %q, expected none", organization)
+	}
+
+	req.Header.SetOrganization("Boxes by Bob")
+	parsed := parseRequest(t, writeRequest(t, req))
+	if organization := parsed.Header.Organization(); organization != "Boxes by Bob" {
+		t.Errorf("round-tripped to %q, expected \"Boxes by Bob\"", organization)
+	}
+
+	parsed.Header.SetOrganization("")
+	if values := parsed.Header.Values("Organization"); len(values) != 0 {
+		t.Errorf("Organization is %q after removing it", values)
+	}
+}
@@ -0,0 +1,85 @@
+package sipnet
+
+import "strings"
+
+// Info represents a value of an Alert-Info or Call-Info header, which is an
+// absolute URI (i.e. an HTTP URL of a ringtone or icon) with parameters,
+// such as the purpose of a Call-Info.
+type Info struct {
+	URI       string
+	Arguments HeaderArgs
+}
+
+// ParseInfo parses a single Alert-Info or Call-Info value, i.e.
+// "<http://example.com/ring.wav>;info=alert-external".
+func ParseInfo(str string) (Info, error) {
+	str = strings.TrimSpace(str)
+	if !strings.HasPrefix(str, "<") {
+		return Info{}, ErrParseError
+	}
+
+	end := strings.Index(str, ">")
+	if end < 0 {
+		return Info{}, ErrParseError
+	}
+
+	return Info{
+		URI:       str[1:end],
+		Arguments: ParsePairs(str[end+1:]),
+	}, nil
+}
+
+// String returns the header value of the info.
+func (i Info) String() string {
+	return "<" + i.URI + ">" + i.Arguments.SemicolonString()
+}
+
+// ParseInfos parses all of the values of an Alert-Info or Call-Info header,
+// including comma separated values.
+func ParseInfos(h Header, key string) ([]Info, error) {
+	var infos []Info
+	for _, value := range h.Values(key) {
+		for _, item := range splitUserList(value) {
+			info, err := ParseInfo(item)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+// SetInfos sets an Alert-Info or Call-Info header to the given infos,
+// replacing any existing values.
+func (h Header) SetInfos(key string, infos []Info) {
+	h.Del(key)
+	for _, info := range infos {
+		h.Add(key, info.String())
+	}
+}
+
+// AlertInfo returns the Alert-Info of the request, such as an alternative
+// ringtone for the callee.
+func (r *Request) AlertInfo() ([]Info, error) {
+	return ParseInfos(r.Header, "Alert-Info")
+}
+
+// CallInfo returns the Call-Info of the request, which is information about
+// the caller, such as an icon with purpose=icon.
+func (r *Request) CallInfo() ([]Info, error) {
+	return ParseInfos(r.Header, "Call-Info")
+}
+
+// AlertInfo returns the Alert-Info of the response, such as an alternative
+// ringback tone for the caller.
+func (r *Response) AlertInfo() ([]Info, error) {
+	return ParseInfos(r.Header, "Alert-Info")
+}
+
+// CallInfo returns the Call-Info of the response, which is information
+// about the callee.
+func (r *Response) CallInfo() ([]Info, error) {
+	return ParseInfos(r.Header, "Call-Info")
+}
@@ -0,0 +1,61 @@
+package sipnet
+
+import "testing"
+
+func TestParseAlertAndCallInfo(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKinfo",
+		"Alert-Info: <http://www.example.com/sounds/moo.wav>",
+		"Call-Info: <http://www.example.com/alice/photo.jpg>;purpose=icon, "+
+			"<http://www.example.com/alice/>;purpose=info"))
+
+	alert, err := req.AlertInfo()
+	if err != nil {
+		t.Fatalf("failed to parse Alert-Info: %v", err)
+	}
+	if len(alert) != 1 || alert[0].URI != "http://www.example.com/sounds/moo.wav" {
+		t.Errorf("parsed Alert-Info %v", alert)
+	}
+
+	call, err := req.CallInfo()
+	if err != nil {
+		t.Fatalf("failed to parse Call-Info: %v", err)
+	}
+	if len(call) != 2 {
+		t.Fatalf("parsed %d Call-Info values, expected 2", len(call))
+	}
+	if call[0].URI != "http://www.example.com/alice/photo.jpg" ||
+		call[0].Arguments.Get("purpose") != "icon" {
+		t.Errorf("parsed %v, expected the icon", call[0])
+	}
+	if call[1].URI != "http://www.example.com/alice/" ||
+		call[1].Arguments.Get("purpose") != "info" {
+		t.Errorf("parsed %v, expected the info page", call[1])
+	}
+
+	if _, err := ParseInfo("http://www.example.com/sounds/moo.wav"); err != ErrParseError {
+		t.Errorf("parsing a URI without angle brackets returned %v", err)
+	}
+}
+
+func TestSetInfos(t *testing.T) {
+	resp := NewResponse()
+	resp.Header.Set("Alert-Info", "<http://www.example.com/old.wav>")
+	resp.Header.SetInfos("Alert-Info", []Info{
+		{URI: "http://www.example.com/ringback.wav", Arguments: HeaderArgs{}},
+		{URI: "urn:alert:service:call-waiting",
+			Arguments: HeaderArgs{"appearance": "2"}},
+	})
+
+	values := resp.Header.Values("Alert-Info")
+	if len(values) != 2 ||
+		values[0] != "<http://www.example.com/ringback.wav>" ||
+		values[1] != "<urn:alert:service:call-waiting>;appearance=2" {
+		t.Errorf("set %q", values)
+	}
+
+	infos, err := resp.AlertInfo()
+	if err != nil || len(infos) != 2 ||
+		infos[1].Arguments.Get("appearance") != "2" {
+		t.Errorf("round-tripped to %v, %v", infos, err)
+	}
+}
@@ -0,0 +1,103 @@
+package sipnet
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReasonPhraseProvider provides localized reason phrases for status codes.
+type ReasonPhraseProvider interface {
+	// ReasonPhrase returns the reason phrase of a status code in the given
+	// language tag (i.e. "fr" or "de-CH"), and whether a translation is
+	// available.
+	ReasonPhrase(code int, language string) (string, bool)
+}
+
+// ReasonPhrases is a ReasonPhraseProvider backed by a map of lower case
+// language tags to reason phrases by status code.
+type ReasonPhrases map[string]map[int]string
+
+// ReasonPhrase returns the reason phrase of a status code in the given
+// language. If there is no translation for the language and it has a
+// subtag (i.e. "de-CH"), the primary language ("de") is tried.
+func (p ReasonPhrases) ReasonPhrase(code int, language string) (string, bool) {
+	language = strings.ToLower(language)
+	if phrase, found := p[language][code]; found {
+		return phrase, true
+	}
+
+	if i := strings.Index(language, "-"); i > 0 {
+		phrase, found := p[language[:i]][code]
+		return phrase, found
+	}
+
+	return "", false
+}
+
+// ParseAcceptLanguage parses the value of an Accept-Language header, and
+// returns its language tags in order of preference (q-value). Languages
+// with a q-value of 0 are omitted.
+func ParseAcceptLanguage(str string) []string {
+	type language struct {
+		tag string
+		q   float64
+	}
+
+	var languages []language
+	for _, value := range strings.Split(str, ",") {
+		parts := strings.Split(value, ";")
+		tag := strings.TrimSpace(parts[0])
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				parsed, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		if q > 0 {
+			languages = append(languages, language{tag, q})
+		}
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].q > languages[j].q
+	})
+
+	tags := make([]string, len(languages))
+	for i, language := range languages {
+		tags[i] = language.tag
+	}
+	return tags
+}
+
+// reasonPhrase returns the reason phrase of a response to req, localized
+// with the connection's ReasonPhraseProvider in the most preferred language
+// of the request's Accept-Language which has a translation. It defaults
+// to the configured StatusTexts, and then to the English phrase of
+// RFC 3261.
+func (c *Conn) reasonPhrase(req *Request, code int) string {
+	config := c.config()
+	provider := config.ReasonPhrases
+	if provider != nil && req != nil {
+		for _, language := range ParseAcceptLanguage(req.Header.Get("Accept-Language")) {
+			if phrase, found := provider.ReasonPhrase(code, language); found {
+				return phrase
+			}
+		}
+	}
+
+	if phrase, found := config.StatusTexts[code]; found {
+		return phrase
+	}
+
+	return StatusText(code)
+}
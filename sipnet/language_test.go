@@ -0,0 +1,73 @@
+package sipnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{"da, en-gb;q=0.8, en;q=0.7", []string{"da", "en-gb", "en"}},
+		{"en;q=0.5, fr;q=0.9, de", []string{"de", "fr", "en"}},
+		{"fr;q=0, de;q=0.1", []string{"de"}},
+		{"en, fr", []string{"en", "fr"}},
+		{"", []string{}},
+	}
+
+	for _, test := range tests {
+		if tags := ParseAcceptLanguage(test.value); !reflect.DeepEqual(tags, test.expected) {
+			t.Errorf("parsed %q as %q, expected %q", test.value, tags,
+				test.expected)
+		}
+	}
+}
+
+var testReasonPhrases = ReasonPhrases{
+	"fr": {StatusBusyHere: "Occupé ici"},
+	"de": {StatusBusyHere: "Hier besetzt"},
+}
+
+func TestReasonPhrasesSubtag(t *testing.T) {
+	if phrase, found := testReasonPhrases.ReasonPhrase(StatusBusyHere, "DE-ch"); !found ||
+		phrase != "Hier besetzt" {
+		t.Errorf("got %q, %v, expected the phrase of the primary language",
+			phrase, found)
+	}
+	if _, found := testReasonPhrases.ReasonPhrase(StatusOK, "fr"); found {
+		t.Error("found a phrase without a translation")
+	}
+}
+
+func TestLocalizedReasonPhrase(t *testing.T) {
+	l := listenTest(t, Config{ReasonPhrases: testReasonPhrases})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	tests := []struct {
+		acceptLanguage string
+		expected       string
+	}{
+		{"da, de;q=0.8, fr;q=0.9", "SIP/2.0 486 Occupé ici"},
+		{"fr;q=0.2, de;q=0.3", "SIP/2.0 486 Hier besetzt"},
+		{"da", "SIP/2.0 486 Busy Here"},
+	}
+
+	for i, test := range tests {
+		branch := "z9hG4bKlang" + string(rune('a'+i))
+		sendUDP(t, peer, l, testRequest(MethodMessage, branch,
+			"Accept-Language: "+test.acceptLanguage))
+		req, conn := acceptRequest(t, l)
+		if err := <-respond(conn, req, StatusBusyHere, "b1"); err != nil {
+			t.Fatalf("failed to respond: %v", err)
+		}
+
+		if data, _ := readUDP(t, peer); startLine(data) != test.expected {
+			t.Errorf("Accept-Language %q: got %q, expected %q",
+				test.acceptLanguage, startLine(data), test.expected)
+		}
+	}
+}
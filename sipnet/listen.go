@@ -1,14 +1,19 @@
 package sipnet
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
+	"strings"
 	"sync"
 )
 
 // ErrClosed is returned if AcceptRequest is called on a closed listener.
-// io.EOF may also be returned on a closed underlying connection, in which
-// the connection itself will also be returned.
+// A connection closing is not an error of the listener, so it is never
+// returned by AcceptRequest. Errors reading from the listener's sockets are
+// returned without a Conn, and errors parsing a message with the Conn it
+// was received over.
 var ErrClosed = errors.New("sip: closed")
 
 type requestPackage struct {
@@ -17,21 +22,55 @@ type requestPackage struct {
 	err  error
 }
 
-// Listener represents a TCP and UDP wrapper listener.
+// Listener represents a TCP and UDP wrapper listener, which may also listen
+// on other transports.
 type Listener struct {
+	// throttled, nonSIP, callIDThrottled, streamLimited and streamConns are
+	// accessed atomically, and must be first for 64-bit alignment.
+	throttled       uint64
+	nonSIP          uint64
+	callIDThrottled uint64
+	streamLimited   uint64
+	streamConns     int64
+
+	streamLimitLogged uint32
+	streamFreed       chan struct{}
+
 	tcpListener net.Listener
 	udpListener *net.UDPConn
+	udpSender   *net.UDPConn
 	closed      bool
+	config      *Config
 
 	requestChannel chan requestPackage
 
-	udpPool      map[string]*Conn
-	udpPoolMutex *sync.Mutex
+	udpPool *connPool
+
+	tcpConns      map[string]*Conn
+	tcpConnsMutex *sync.Mutex
+
+	streamListeners []net.Listener
+	packetConns     []net.PacketConn
+	packetSenders   map[string]net.PacketConn
+	transportAddrs  map[string]net.Addr
+	transportsMutex *sync.Mutex
+
+	limiter *rateLimiter
+	callIDs *callIDLimiter
+
+	transactions *TransactionTable
 }
 
-// Listen listens on an address (IP:port) on both TCP and UDP.
+// Listen listens on an address (IP:port) on both TCP and UDP using the
+// default configuration.
 func Listen(addr string) (*Listener, error) {
-	tcpListener, err := net.Listen("tcp", addr)
+	return ListenWithConfig(addr, Config{})
+}
+
+// ListenWithConfig listens on an address (IP:port) on both TCP and UDP
+// using the provided configuration.
+func ListenWithConfig(addr string, config Config) (*Listener, error) {
+	tcpListener, err := TCP.Listen(addr)
 	if err != nil {
 		return nil, err
 	}
@@ -42,33 +81,138 @@ func Listen(addr string) (*Listener, error) {
 		return nil, err
 	}
 
-	udpListener, err := net.ListenUDP("udp", udpAddr)
+	udpListener, err := listenUDP(udpAddr, config.ConnectedUDP)
 	if err != nil {
 		tcpListener.Close()
 		return nil, err
 	}
 
+	if config.PacketInfo {
+		err = enablePacketInfo(udpListener)
+		if err != nil {
+			tcpListener.Close()
+			udpListener.Close()
+			return nil, err
+		}
+	}
+
+	udpSender := udpListener
+	if config.UDPSourcePort != 0 {
+		senderAddr := &net.UDPAddr{
+			IP:   udpAddr.IP,
+			Port: config.UDPSourcePort,
+			Zone: udpAddr.Zone,
+		}
+
+		udpSender, err = net.ListenUDP("udp", senderAddr)
+		if err != nil {
+			tcpListener.Close()
+			udpListener.Close()
+			return nil, err
+		}
+	}
+
 	listener := &Listener{
-		tcpListener:    tcpListener,
-		udpListener:    udpListener,
-		closed:         false,
-		requestChannel: make(chan requestPackage),
-		udpPool:        make(map[string]*Conn),
-		udpPoolMutex:   new(sync.Mutex),
+		tcpListener:     tcpListener,
+		udpListener:     udpListener,
+		udpSender:       udpSender,
+		closed:          false,
+		config:          &config,
+		requestChannel:  make(chan requestPackage),
+		udpPool:         newConnPool(),
+		tcpConns:        make(map[string]*Conn),
+		tcpConnsMutex:   new(sync.Mutex),
+		transportsMutex: new(sync.Mutex),
+		streamFreed:     make(chan struct{}, 1),
+	}
+
+	listener.transactions = config.Transactions
+	if listener.transactions == nil {
+		listener.transactions = new(TransactionTable)
+	}
+
+	if config.RateLimit > 0 {
+		listener.limiter = newRateLimiter(config.RateLimit, config.RateBurst,
+			config.clock())
+	}
+
+	if config.CallIDRate > 0 {
+		listener.callIDs = newCallIDLimiter(config.CallIDRate,
+			config.CallIDBurst, config.clock())
 	}
 
 	go listener.udpJanitor()
-	go handleTCPListening(listener)
-	go handleUDPListening(listener)
+	go handleStreamListening(listener, TCP, tcpListener)
+	go handlePacketListening(listener, UDP, udpListener, udpSender)
+	if udpSender != udpListener {
+		go handlePacketListening(listener, UDP, udpSender, udpSender)
+	}
 
 	return listener, nil
 }
 
-func handleTCPListening(listener *Listener) {
+// ListenTransport additionally listens on an address (IP:port) with the
+// given transport. Requests received on it are accepted by AcceptRequest
+// alongside those received on TCP and UDP.
+func (l *Listener) ListenTransport(t Transport, addr string) error {
+	if t.IsStream() {
+		streamListener, err := t.Listen(addr)
+		if err != nil {
+			return err
+		}
+
+		l.transportsMutex.Lock()
+		l.streamListeners = append(l.streamListeners, streamListener)
+		l.setTransportAddr(t, streamListener.Addr())
+		l.transportsMutex.Unlock()
+
+		go handleStreamListening(l, t, streamListener)
+		return nil
+	}
+
+	packetConn, err := t.ListenPacket(addr)
+	if err != nil {
+		return err
+	}
+
+	l.transportsMutex.Lock()
+	l.packetConns = append(l.packetConns, packetConn)
+	l.setTransportAddr(t, packetConn.LocalAddr())
+	if l.packetSenders == nil {
+		l.packetSenders = make(map[string]net.PacketConn)
+	}
+	if _, found := l.packetSenders[t.Name()]; !found {
+		l.packetSenders[t.Name()] = packetConn
+	}
+	l.transportsMutex.Unlock()
+
+	go handlePacketListening(l, t, packetConn, packetConn)
+	return nil
+}
+
+func listenUDP(addr *net.UDPAddr, reusable bool) (*net.UDPConn, error) {
+	if !reusable {
+		return net.ListenUDP("udp", addr)
+	}
+
+	listenConfig := net.ListenConfig{Control: reuseAddr}
+	packetConn, err := listenConfig.ListenPacket(context.Background(), "udp",
+		addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return packetConn.(*net.UDPConn), nil
+}
+
+func handleStreamListening(listener *Listener, t Transport,
+	streamListener net.Listener) {
 	defer listener.Close()
 
 	for {
-		conn, err := listener.tcpListener.Accept()
+		listener.waitStreamSlot()
+
+		conn, err := streamListener.Accept()
 		if err != nil {
 			if listener.closed {
 				return
@@ -83,16 +227,40 @@ func handleTCPListening(listener *Listener) {
 			return
 		}
 
-		listener.registerTCPConn(conn)
+		if listener.rateLimited(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+
+		if listener.rejectStream(conn) {
+			continue
+		}
+
+		listener.registerStreamConn(t, conn)
 	}
 }
 
-func handleUDPListening(listener *Listener) {
+// handlePacketListening reads frames from readConn into pooled connections,
+// which send their frames over sendConn.
+func handlePacketListening(listener *Listener, t Transport, readConn,
+	sendConn net.PacketConn) {
+	defer listener.closeUDPPool()
 	defer listener.Close()
 
+	udpConn, _ := readConn.(*net.UDPConn)
+	withDestination := t == UDP && udpConn != nil &&
+		listener.config.PacketInfo
+
 	for {
-		data := make([]byte, 65535)
-		n, addr, err := listener.udpListener.ReadFrom(data)
+		var data []byte
+		var addr net.Addr
+		var destination net.IP
+		var err error
+		if withDestination {
+			data, addr, destination, err = readUDPWithDestination(udpConn)
+		} else {
+			data, addr, err = t.ReadFrame(readConn)
+		}
 		if err != nil {
 			if listener.closed {
 				return
@@ -107,10 +275,31 @@ func handleUDPListening(listener *Listener) {
 			return
 		}
 
-		listener.getUDPConnFromPool(addr).writeReceivedUDP(data[:n])
+		if listener.rateLimited(addr) {
+			continue
+		}
+
+		dispatchFrame(listener, t, sendConn, addr, destination, data)
 	}
 }
 
+// dispatchFrame hands a received frame to the pooled connection of its
+// sender, recording the local IP it was sent to if it is known. A panic is
+// recovered, so the socket keeps being read for other peers.
+func dispatchFrame(listener *Listener, t Transport, sendConn net.PacketConn,
+	addr net.Addr, destination net.IP, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("warning: recovered from panic dispatching frame from",
+				addr, ":", r)
+		}
+	}()
+
+	conn := listener.getPacketConnFromPool(t, sendConn, addr)
+	conn.setDestination(destination)
+	conn.writeReceivedUDP(data)
+}
+
 // AcceptRequest blocks until it receives a Request message on either TCP or UDP
 // listeners. Responses are to be written to *Conn (and then flushed).
 func (l *Listener) AcceptRequest() (*Request, *Conn, error) {
@@ -133,6 +322,19 @@ func (l *Listener) Close() error {
 		err = l.udpListener.Close()
 	}
 
+	if l.udpSender != l.udpListener {
+		l.udpSender.Close()
+	}
+
+	l.transportsMutex.Lock()
+	for _, streamListener := range l.streamListeners {
+		streamListener.Close()
+	}
+	for _, packetConn := range l.packetConns {
+		packetConn.Close()
+	}
+	l.transportsMutex.Unlock()
+
 closeLoop:
 	for {
 		select {
@@ -149,3 +351,34 @@ closeLoop:
 func (l *Listener) Addr() net.Addr {
 	return l.tcpListener.Addr()
 }
+
+// setTransportAddr records the address the listener listens on with a
+// transport, keeping the first if there are several. transportsMutex must
+// be held.
+func (l *Listener) setTransportAddr(t Transport, addr net.Addr) {
+	if l.transportAddrs == nil {
+		l.transportAddrs = make(map[string]net.Addr)
+	}
+
+	if _, found := l.transportAddrs[t.Name()]; !found {
+		l.transportAddrs[t.Name()] = addr
+	}
+}
+
+// TransportAddr returns the address the listener is listening on with the
+// named transport (i.e. "tls"), or Addr if it doesn't listen with it.
+func (l *Listener) TransportAddr(transport string) net.Addr {
+	switch strings.ToLower(transport) {
+	case "tcp":
+		return l.tcpListener.Addr()
+	case "udp":
+		return l.udpListener.LocalAddr()
+	}
+
+	l.transportsMutex.Lock()
+	defer l.transportsMutex.Unlock()
+	if addr, found := l.transportAddrs[strings.ToLower(transport)]; found {
+		return addr
+	}
+	return l.Addr()
+}
@@ -0,0 +1,91 @@
+package sipnet
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// IsLocal returns whether a URI targets the listener itself, either by one
+// of the configured LocalDomains, or by the listener's address or
+// advertised host and port.
+func (l *Listener) IsLocal(u URI) bool {
+	host, port, err := net.SplitHostPort(u.Domain)
+	if err != nil {
+		host = strings.Trim(u.Domain, "[]")
+		port = ""
+	}
+
+	for _, domain := range l.config.LocalDomains {
+		if strings.EqualFold(host, domain) {
+			return true
+		}
+	}
+
+	localHost, localPort, err := net.SplitHostPort(l.SentBy())
+	if err != nil {
+		return false
+	}
+
+	if port == "" {
+		port = strconv.Itoa(DefaultPort)
+		if l.config.DefaultPort != 0 {
+			port = strconv.Itoa(l.config.DefaultPort)
+		}
+	}
+
+	if port != localPort {
+		return false
+	}
+
+	if strings.EqualFold(host, localHost) {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	if addr, ok := l.Addr().(*net.TCPAddr); ok && addr.IP.Equal(ip) {
+		return true
+	}
+
+	return false
+}
+
+// ForwardTarget returns the URI a proxy should forward a request to. Route
+// entries targeting the listener are removed from the top of the Route of
+// the request first, as they were meant for the listener. The next hop is
+// the top remaining Route entry, or otherwise the Request-URI.
+//
+// local is true if the request has no remaining Route and its Request-URI
+// targets the listener, in which case forwarding it would spiral back to
+// the listener, and the request should be processed locally instead.
+func (l *Listener) ForwardTarget(req *Request) (target URI, local bool, err error) {
+	routes, err := ParseUsers(req.Header, "Route")
+	if err != nil {
+		return URI{}, false, err
+	}
+
+	removed := 0
+	for removed < len(routes) && l.IsLocal(routes[removed].URI) {
+		removed++
+	}
+
+	if removed > 0 {
+		req.SetRouteSet(routes[removed:])
+		routes = routes[removed:]
+	}
+
+	if len(routes) > 0 {
+		return routes[0].URI, false, nil
+	}
+
+	target, err = ParseURI(req.Server)
+	if err != nil {
+		return URI{}, false, err
+	}
+
+	return target, l.IsLocal(target), nil
+}
@@ -0,0 +1,72 @@
+package sipnet
+
+import "testing"
+
+func TestIsLocal(t *testing.T) {
+	l := listenTest(t, Config{LocalDomains: []string{"example.com"}})
+	defer l.Close()
+
+	tests := []struct {
+		uri   string
+		local bool
+	}{
+		{"sip:bob@example.com", true},
+		{"sip:bob@EXAMPLE.com:5080", true},
+		{"sip:bob@sub.example.com", false},
+		{"sip:bob@example.org", false},
+		{"sip:bob@" + l.SentBy(), true},
+		{"sip:bob@127.0.0.1", false},
+	}
+
+	for _, test := range tests {
+		uri, err := ParseURI(test.uri)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", test.uri, err)
+		}
+		if local := l.IsLocal(uri); local != test.local {
+			t.Errorf("IsLocal(%s) = %v, expected %v", test.uri, local,
+				test.local)
+		}
+	}
+}
+
+func TestForwardTargetLocalDomain(t *testing.T) {
+	l := listenTest(t, Config{LocalDomains: []string{"example.com"}})
+	defer l.Close()
+
+	// A Request-URI in a local domain is processed locally.
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bK1"))
+	req.Server = "sip:bob@example.com"
+	target, local, err := l.ForwardTarget(req)
+	if err != nil {
+		t.Fatalf("failed to get forward target: %v", err)
+	}
+	if !local || target.Domain != "example.com" {
+		t.Errorf("ForwardTarget returned %s, %v, expected a local target",
+			target, local)
+	}
+
+	// A Route to the listener itself is removed, and the request is
+	// forwarded to the next Route.
+	req = parseRequest(t, testRequest(MethodInvite, "z9hG4bK2",
+		"Route: <sip:"+l.SentBy()+";lr>, <sip:next.example.org;lr>"))
+	req.Server = "sip:bob@example.com"
+	target, local, err = l.ForwardTarget(req)
+	if err != nil {
+		t.Fatalf("failed to get forward target: %v", err)
+	}
+	if local || target.Domain != "next.example.org" {
+		t.Errorf("ForwardTarget returned %s, %v, expected the next Route",
+			target, local)
+	}
+	if routes := req.Header.Values("Route"); len(routes) != 1 {
+		t.Errorf("request has Route %v, expected only the next Route", routes)
+	}
+
+	// A Request-URI elsewhere is forwarded.
+	req = parseRequest(t, testRequest(MethodInvite, "z9hG4bK3"))
+	req.Server = "sip:bob@example.org"
+	if _, local, _ = l.ForwardTarget(req); local {
+		t.Error("request to another domain is processed locally")
+	}
+}
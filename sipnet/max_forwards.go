@@ -0,0 +1,80 @@
+package sipnet
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrMaxForwards is returned if a Max-Forwards is not a number from 0 to
+// MaxMaxForwards.
+var ErrMaxForwards = errors.New("sip: Max-Forwards out of range")
+
+// DefaultMaxForwards is the Max-Forwards of requests sent without one
+// (RFC 3261 section 8.1.1.6).
+const DefaultMaxForwards = 70
+
+// MaxMaxForwards is the largest valid Max-Forwards.
+const MaxMaxForwards = 255
+
+// MaxForwards returns the Max-Forwards of a request, and whether it has
+// one. ErrMaxForwards is returned if it is malformed or out of range.
+func (r *Request) MaxForwards() (int, bool, error) {
+	value := strings.TrimSpace(r.Header.Get("Max-Forwards"))
+	if value == "" {
+		return 0, false, nil
+	}
+
+	hops, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, false, ErrMaxForwards
+	}
+
+	return int(hops), true, nil
+}
+
+// SetMaxForwards sets the Max-Forwards of a request, returning
+// ErrMaxForwards if hops is not from 0 to MaxMaxForwards.
+func (r *Request) SetMaxForwards(hops int) error {
+	if hops < 0 || hops > MaxMaxForwards {
+		return ErrMaxForwards
+	}
+
+	r.Header.Set("Max-Forwards", strconv.Itoa(hops))
+	return nil
+}
+
+// DecrementMaxForwards decrements the Max-Forwards of a request received
+// over conn before it is forwarded by a proxy (RFC 3261 section 16.6), and
+// returns whether it may be forwarded. A request without a Max-Forwards is
+// forwarded with DefaultMaxForwards. A request with a Max-Forwards of 0 is
+// answered with a 483 Too Many Hops instead, unless it is an ACK, and one
+// with a malformed Max-Forwards with a 400 Bad Request.
+func (r *Request) DecrementMaxForwards(conn *Conn) bool {
+	hops, ok, err := r.MaxForwards()
+	if err != nil {
+		NewResponse().BadRequest(conn, r, "Malformed Max-Forwards.")
+		return false
+	}
+
+	if !ok {
+		r.SetMaxForwards(DefaultMaxForwards)
+		return true
+	}
+
+	if hops == 0 {
+		if r.Method == MethodAck {
+			return false
+		}
+
+		resp := NewResponse()
+		resp.StatusCode = StatusTooManyHops
+		resp.Header.Set("To", withToTag(r.Header.Get("To")))
+		resp.Header.Set("From", r.Header.Get("From"))
+		resp.WriteTo(conn, r)
+		return false
+	}
+
+	r.SetMaxForwards(hops - 1)
+	return true
+}
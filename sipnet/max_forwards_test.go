@@ -0,0 +1,117 @@
+package sipnet
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestWriteToDefaultsMaxForwards(t *testing.T) {
+	req := newTestRequest(MethodOptions, "sip:bob@127.0.0.1")
+	parsed, err := ReadRequestBuffered(bufio.NewReader(
+		strings.NewReader(writeRequest(t, req))))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if hops, ok, err := parsed.MaxForwards(); hops != DefaultMaxForwards ||
+		!ok || err != nil {
+		t.Errorf("sent Max-Forwards %d, %v, %v, expected the default",
+			hops, ok, err)
+	}
+
+	// An explicit Max-Forwards is kept.
+	req = newTestRequest(MethodOptions, "sip:bob@127.0.0.1")
+	req.SetMaxForwards(10)
+	if data := writeRequest(t, req); !strings.Contains(data,
+		"Max-Forwards: 10\r\n") {
+		t.Errorf("sent %q, expected Max-Forwards 10", data)
+	}
+}
+
+func TestMaxForwardsRange(t *testing.T) {
+	req := NewRequest()
+	for _, hops := range []int{0, MaxMaxForwards} {
+		if err := req.SetMaxForwards(hops); err != nil {
+			t.Errorf("setting %d returned %v", hops, err)
+		}
+	}
+	for _, hops := range []int{-1, MaxMaxForwards + 1} {
+		if err := req.SetMaxForwards(hops); err != ErrMaxForwards {
+			t.Errorf("setting %d returned %v, expected ErrMaxForwards", hops,
+				err)
+		}
+	}
+
+	tests := []struct {
+		value string
+		hops  int
+		ok    bool
+		err   error
+	}{
+		{"", 0, false, nil},
+		{" 255 ", 255, true, nil},
+		{"256", 0, false, ErrMaxForwards},
+		{"-1", 0, false, ErrMaxForwards},
+		{"seventy", 0, false, ErrMaxForwards},
+	}
+	for _, test := range tests {
+		req.Header.Set("Max-Forwards", test.value)
+		hops, ok, err := req.MaxForwards()
+		if hops != test.hops || ok != test.ok || err != test.err {
+			t.Errorf("%q: parsed %d, %v, %v", test.value, hops, ok, err)
+		}
+	}
+}
+
+func TestDecrementMaxForwards(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	tests := []struct {
+		method      string
+		maxForwards string
+		forward     bool
+		forwarded   string
+		status      string
+	}{
+		{MethodInvite, "3", true, "2", ""},
+		{MethodInvite, "", true, "70", ""},
+		{MethodInvite, "0", false, "", "SIP/2.0 483 Too Many Hops"},
+		{MethodInvite, "300", false, "", "SIP/2.0 400 Bad Request"},
+		{MethodAck, "0", false, "", ""},
+	}
+
+	for _, test := range tests {
+		msg := testRequest(test.method, "z9hG4bKhops")
+		msg = strings.Replace(msg, "Max-Forwards: 70\r\n", "", 1)
+		req := parseRequest(t, msg)
+		if test.maxForwards != "" {
+			req.Header.Set("Max-Forwards", test.maxForwards)
+		}
+
+		var forward bool
+		done := goWrite(func() error {
+			forward = req.DecrementMaxForwards(conn)
+			return nil
+		})
+		if test.status != "" {
+			if line := startLine(readPipe(t, remote)); line != test.status {
+				t.Errorf("%s with %q answered with %q, expected %q",
+					test.method, test.maxForwards, line, test.status)
+			}
+		}
+		<-done
+
+		if forward != test.forward {
+			t.Errorf("%s with %q: forward is %v", test.method,
+				test.maxForwards, forward)
+		}
+		if forward {
+			if hops := req.Header.Get("Max-Forwards"); hops != test.forwarded {
+				t.Errorf("%s with %q forwarded with %q, expected %q",
+					test.method, test.maxForwards, hops, test.forwarded)
+			}
+		}
+	}
+	expectNoPipeData(t, remote)
+}
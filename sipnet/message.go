@@ -0,0 +1,102 @@
+package sipnet
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// ErrContentType is returned by Request.JSON and Request.Form if the
+// Content-Type of the request doesn't match the format being decoded.
+var ErrContentType = errors.New("sip: unexpected content type")
+
+// Content types of MESSAGE bodies.
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeForm = "application/x-www-form-urlencoded"
+	ContentTypeCPIM = "message/cpim"
+)
+
+// mediaType returns the lower case media type of a Content-Type, without
+// its parameters, or an empty string if it is malformed.
+func mediaType(contentType string) string {
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return media
+}
+
+// isJSON returns whether a media type is JSON, i.e. application/json or a
+// structured syntax suffix such as application/vnd.example+json.
+func isJSON(media string) bool {
+	return media == ContentTypeJSON || strings.HasSuffix(media, "+json")
+}
+
+// content returns the Content-Type and body of the request. The content of
+// a CPIM message (RFC 3862), as used by IM gateways, is returned in place of
+// the CPIM wrapper.
+func (r *Request) content() (string, []byte) {
+	contentType := r.Header.Get("Content-Type")
+	if mediaType(contentType) != ContentTypeCPIM {
+		return contentType, r.Body
+	}
+
+	// The CPIM message headers and the MIME headers of the content are
+	// each followed by an empty line.
+	body := bytes.Replace(r.Body, []byte("\r\n"), []byte("\n"), -1)
+	parts := bytes.SplitN(body, []byte("\n\n"), 3)
+	if len(parts) < 3 {
+		return contentType, r.Body
+	}
+
+	for _, line := range strings.Split(string(parts[1]), "\n") {
+		colon := strings.Index(line, ":")
+		if colon >= 0 && strings.EqualFold(
+			strings.TrimSpace(line[:colon]), "Content-Type") {
+			return strings.TrimSpace(line[colon+1:]), parts[2]
+		}
+	}
+
+	return "", parts[2]
+}
+
+// JSON decodes the JSON body of the request into v, such as of a MESSAGE
+// from an IM gateway. ErrContentType is returned if the Content-Type of
+// the request, or of its content if it is a CPIM message, isn't JSON.
+func (r *Request) JSON(v interface{}) error {
+	contentType, body := r.content()
+	if !isJSON(mediaType(contentType)) {
+		return ErrContentType
+	}
+
+	return unmarshalBody(contentType, body, v)
+}
+
+// SetJSON sets the body of the request to v encoded as JSON, with a
+// Content-Type of application/json.
+func (r *Request) SetJSON(v interface{}) error {
+	return r.EncodeBody(ContentTypeJSON, v)
+}
+
+// Form decodes the URL encoded form body of the request. ErrContentType
+// is returned if the Content-Type of the request, or of its content if it
+// is a CPIM message, isn't application/x-www-form-urlencoded.
+func (r *Request) Form() (url.Values, error) {
+	contentType, body := r.content()
+	if mediaType(contentType) != ContentTypeForm {
+		return nil, ErrContentType
+	}
+
+	var values url.Values
+	err := unmarshalBody(contentType, body, &values)
+	return values, err
+}
+
+// SetForm sets the body of the request to the URL encoded form values,
+// with a Content-Type of application/x-www-form-urlencoded.
+func (r *Request) SetForm(values url.Values) {
+	r.EncodeBody(ContentTypeForm, values)
+}
@@ -0,0 +1,87 @@
+package sipnet
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// chatMessage is a JSON MESSAGE body from an IM gateway.
+type chatMessage struct {
+	From string   `json:"from"`
+	Text string   `json:"text"`
+	Tags []string `json:"tags"`
+}
+
+func TestJSONMessageRoundTrip(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKjson"))
+	sent := chatMessage{"alice", "héllo, \"bob\"\r\n", []string{"a", "b"}}
+	if err := req.SetJSON(sent); err != nil {
+		t.Fatalf("failed to set the body: %v", err)
+	}
+
+	parsed := parseRequest(t, writeRequest(t, req))
+	if contentType := parsed.Header.Get("Content-Type"); contentType !=
+		ContentTypeJSON {
+		t.Errorf("Content-Type is %q, expected %q", contentType,
+			ContentTypeJSON)
+	}
+
+	var received chatMessage
+	if err := parsed.JSON(&received); err != nil {
+		t.Fatalf("failed to decode the body: %v", err)
+	}
+	if received.From != sent.From || received.Text != sent.Text ||
+		strings.Join(received.Tags, ",") != "a,b" {
+		t.Errorf("decoded %+v, expected %+v", received, sent)
+	}
+}
+
+func TestJSONContentType(t *testing.T) {
+	const body = `{"from":"alice","text":"hi"}`
+	tests := []struct {
+		contentType string
+		body        string
+		err         error
+	}{
+		{"application/json; charset=utf-8", body, nil},
+		{"application/vnd.example+json", body, nil},
+		{"text/plain", body, ErrContentType},
+		{"message/cpim", "From: <im:alice@example.com>\r\n\r\n" +
+			"Content-Type: application/json\r\n\r\n" + body, nil},
+		{"message/cpim", "From: <im:alice@example.com>\r\n\r\n" +
+			"Content-Type: text/plain\r\n\r\n" + body, ErrContentType},
+	}
+
+	for _, test := range tests {
+		req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKjson"))
+		req.Header.Set("Content-Type", test.contentType)
+		req.SetBody([]byte(test.body))
+
+		var msg chatMessage
+		err := req.JSON(&msg)
+		if err != test.err {
+			t.Errorf("%q: decoded with %v, expected %v", test.contentType,
+				err, test.err)
+		} else if err == nil && (msg.From != "alice" || msg.Text != "hi") {
+			t.Errorf("%q: decoded %+v", test.contentType, msg)
+		}
+	}
+}
+
+func TestFormMessageRoundTrip(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKform"))
+	req.SetForm(url.Values{"text": {"hi & bye"}, "to": {"bob"}})
+
+	form, err := parseRequest(t, writeRequest(t, req)).Form()
+	if err != nil {
+		t.Fatalf("failed to decode the body: %v", err)
+	}
+	if form.Get("text") != "hi & bye" || form.Get("to") != "bob" {
+		t.Errorf("decoded %v", form)
+	}
+
+	if err := req.JSON(new(chatMessage)); err != ErrContentType {
+		t.Errorf("decoding a form as JSON returned %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package sipnet
+
+// Middleware intercepts a *Request or *Response received or sent by a
+// connection, such as to scrub headers or hide the topology of a network.
+// It calls next with the message to continue, which may be modified in
+// place or replaced by another message of the same type. A middleware which
+// doesn't call next drops the message.
+//
+// Middleware is run in the order it is configured, and the last middleware
+// to call next passes the message on to the connection. Outbound middleware
+// is run again for each retransmission of a request, so it should not
+// assume a message is only seen once.
+type Middleware func(msg interface{}, next func(interface{}))
+
+// runMiddleware passes msg through a middleware chain, and returns the
+// resulting message and whether it was passed through all of the chain.
+func runMiddleware(chain []Middleware, msg interface{}) (interface{}, bool) {
+	if len(chain) == 0 {
+		return msg, true
+	}
+
+	var result interface{}
+	passed := false
+
+	var call func(i int, msg interface{})
+	call = func(i int, msg interface{}) {
+		if i == len(chain) {
+			result = msg
+			passed = true
+			return
+		}
+
+		chain[i](msg, func(next interface{}) {
+			call(i+1, next)
+		})
+	}
+	call(0, msg)
+
+	return result, passed
+}
+
+// inbound passes a received message through the configured inbound
+// middleware, and returns the message to deliver, or nil if it was dropped.
+func (c *Conn) inbound(msg interface{}) interface{} {
+	switch msg.(type) {
+	case *Request, *Response:
+	default:
+		return msg
+	}
+
+	result, passed := runMiddleware(c.config().InboundMiddleware, msg)
+	if !passed {
+		return nil
+	}
+	return result
+}
+
+// outboundRequest passes a request to be sent through the configured
+// outbound middleware, and returns the request to send, or nil if it was
+// dropped.
+func (c *Conn) outboundRequest(r *Request) *Request {
+	result, passed := runMiddleware(c.config().OutboundMiddleware, r)
+	if req, ok := result.(*Request); passed && ok {
+		return req
+	}
+	return nil
+}
+
+// outboundResponse passes a response to be sent through the configured
+// outbound middleware, and returns the response to send, or nil if it was
+// dropped.
+func (c *Conn) outboundResponse(r *Response) *Response {
+	result, passed := runMiddleware(c.config().OutboundMiddleware, r)
+	if resp, ok := result.(*Response); passed && ok {
+		return resp
+	}
+	return nil
+}
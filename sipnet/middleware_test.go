@@ -0,0 +1,108 @@
+package sipnet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunMiddlewareOrder(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(msg interface{}, next func(interface{})) {
+			order = append(order, name)
+			next(msg)
+		}
+	}
+	drop := func(msg interface{}, next func(interface{})) {
+		order = append(order, "drop")
+	}
+
+	result, passed := runMiddleware([]Middleware{record("a"), record("b"),
+		record("c")}, "msg")
+	if !passed || result != "msg" {
+		t.Errorf("returned %v, %v, expected the message to pass", result, passed)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("ran %q, expected %q", order, expected)
+	}
+
+	// A middleware which doesn't call next short-circuits the chain.
+	order = nil
+	_, passed = runMiddleware([]Middleware{record("a"), drop, record("c")}, "msg")
+	if passed {
+		t.Error("dropped message passed")
+	}
+	if expected := []string{"a", "drop"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("ran %q, expected %q", order, expected)
+	}
+}
+
+func TestInboundMiddlewareScrubsHeader(t *testing.T) {
+	scrub := func(msg interface{}, next func(interface{})) {
+		if req, ok := msg.(*Request); ok {
+			req.Header.Del("X-Internal-Account")
+		}
+		next(msg)
+	}
+	drop := func(msg interface{}, next func(interface{})) {
+		if req, ok := msg.(*Request); !ok || req.Header.Get("Subject") != "drop" {
+			next(msg)
+		}
+	}
+
+	l := listenTest(t, Config{InboundMiddleware: []Middleware{scrub, drop}})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bK1",
+		"X-Internal-Account: 1234", "Subject: drop"))
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bK2",
+		"X-Internal-Account: 1234"))
+
+	req, _ := acceptRequest(t, l)
+	if !strings.HasSuffix(req.Header.Get("Via"), "branch=z9hG4bK2") {
+		t.Errorf("accepted %q, expected the dropped request to be skipped",
+			req.Header.Get("Via"))
+	}
+	if value := req.Header.Get("X-Internal-Account"); value != "" {
+		t.Errorf("X-Internal-Account is %q, expected it to be scrubbed", value)
+	}
+}
+
+func TestOutboundMiddlewareHidesTopology(t *testing.T) {
+	hide := func(msg interface{}, next func(interface{})) {
+		if req, ok := msg.(*Request); ok {
+			vias := req.Header.Values("Via")
+			req.Header.Del("Via")
+			req.Header.Add("Via", vias[0])
+		}
+		next(msg)
+	}
+
+	l := listenTest(t, Config{OutboundMiddleware: []Middleware{hide}})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	req := newTestRequest(MethodMessage, "sip:bob@"+peer.LocalAddr().String())
+	top := conn.NewVia().String()
+	req.Header.Set("Via", top)
+	req.Header.Add("Via", "SIP/2.0/UDP 10.0.0.5:5060;branch=z9hG4bKinternal")
+	if err := <-goWrite(func() error {
+		return req.WriteTo(conn)
+	}); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	sent, _ := readUDPRequest(t, peer)
+	if vias := sent.Header.Values("Via"); len(vias) != 1 || vias[0] != top {
+		t.Errorf("sent Vias %q, expected only %q", vias, top)
+	}
+}
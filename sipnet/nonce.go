@@ -0,0 +1,120 @@
+package sipnet
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrStaleNonce is returned by NonceStore.Use if the nonce has expired or
+// was not issued by the store. The client should be challenged again with
+// stale=true, so it retries with a new nonce without prompting the user.
+var ErrStaleNonce = errors.New("sip: stale nonce")
+
+// ErrNonceReplay is returned by NonceStore.Use if the nonce count is not
+// greater than that of the previous use of the nonce, which indicates a
+// replayed request.
+var ErrNonceReplay = errors.New("sip: nonce replayed")
+
+// DefaultNonceMaxAge is the duration nonces issued by a MemoryNonceStore
+// are valid for if MaxAge is not set.
+const DefaultNonceMaxAge = 5 * time.Minute
+
+// NonceStore issues the nonces of digest challenges, and validates their use
+// by clients to reject replayed requests.
+type NonceStore interface {
+	// Issue returns a new nonce to challenge a client with.
+	Issue() string
+
+	// Use validates a client's use of a nonce with the nonce count nc. It
+	// returns ErrStaleNonce if the nonce is unknown or has expired, and
+	// ErrNonceReplay if nc is not greater than in the previous use.
+	Use(nonce string, nc uint32) error
+}
+
+type nonceState struct {
+	issued time.Time
+	nc     uint32
+}
+
+// MemoryNonceStore is a NonceStore which keeps issued nonces in memory. It
+// is safe to use from multiple goroutines.
+type MemoryNonceStore struct {
+	// MaxAge is the duration a nonce is valid for after it is issued. If
+	// zero, DefaultNonceMaxAge is used.
+	MaxAge time.Duration
+
+	// Clock provides the time nonces expire by. If nil, RealClock is used.
+	Clock Clock
+
+	mutex  sync.Mutex
+	nonces map[string]*nonceState
+}
+
+// NewMemoryNonceStore returns a new empty memory nonce store, with nonces
+// valid for maxAge.
+func NewMemoryNonceStore(maxAge time.Duration) *MemoryNonceStore {
+	return &MemoryNonceStore{
+		MaxAge: maxAge,
+		nonces: make(map[string]*nonceState),
+	}
+}
+
+func (s *MemoryNonceStore) maxAge() time.Duration {
+	if s.MaxAge == 0 {
+		return DefaultNonceMaxAge
+	}
+	return s.MaxAge
+}
+
+func (s *MemoryNonceStore) clock() Clock {
+	if s.Clock == nil {
+		return RealClock
+	}
+	return s.Clock
+}
+
+// Issue returns a new nonce, and expires old nonces.
+func (s *MemoryNonceStore) Issue() string {
+	nonce := randomHex(16)
+	now := s.clock().Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.nonces == nil {
+		s.nonces = make(map[string]*nonceState)
+	}
+
+	for key, state := range s.nonces {
+		if now.Sub(state.issued) > s.maxAge() {
+			delete(s.nonces, key)
+		}
+	}
+
+	s.nonces[nonce] = &nonceState{issued: now}
+	return nonce
+}
+
+// Use validates the use of a nonce with the nonce count nc.
+func (s *MemoryNonceStore) Use(nonce string, nc uint32) error {
+	now := s.clock().Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, found := s.nonces[nonce]
+	if !found {
+		return ErrStaleNonce
+	}
+
+	if now.Sub(state.issued) > s.maxAge() {
+		delete(s.nonces, nonce)
+		return ErrStaleNonce
+	}
+
+	if nc <= state.nc {
+		return ErrNonceReplay
+	}
+
+	state.nc = nc
+	return nil
+}
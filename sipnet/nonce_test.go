@@ -0,0 +1,58 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryNonceStoreReplay(t *testing.T) {
+	store := NewMemoryNonceStore(time.Minute)
+	nonce := store.Issue()
+
+	if err := store.Use(nonce, 1); err != nil {
+		t.Fatalf("first use failed: %v", err)
+	}
+	if err := store.Use(nonce, 1); err != ErrNonceReplay {
+		t.Errorf("reusing nc 1 returned %v, expected ErrNonceReplay", err)
+	}
+	if err := store.Use(nonce, 2); err != nil {
+		t.Errorf("using nc 2 failed: %v", err)
+	}
+	if err := store.Use(nonce, 1); err != ErrNonceReplay {
+		t.Errorf("going back to nc 1 returned %v, expected ErrNonceReplay", err)
+	}
+
+	if err := store.Use("0123456789abcdef", 1); err != ErrStaleNonce {
+		t.Errorf("using an unknown nonce returned %v, expected ErrStaleNonce",
+			err)
+	}
+}
+
+func TestMemoryNonceStoreStale(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	store := NewMemoryNonceStore(time.Minute)
+	store.Clock = clock
+
+	nonce := store.Issue()
+	clock.Advance(time.Minute)
+	if err := store.Use(nonce, 1); err != nil {
+		t.Fatalf("use at the maximum age failed: %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := store.Use(nonce, 2); err != ErrStaleNonce {
+		t.Errorf("use after the maximum age returned %v, expected "+
+			"ErrStaleNonce", err)
+	}
+
+	// Expired nonces are removed when a new one is issued.
+	old := store.Issue()
+	clock.Advance(2 * time.Minute)
+	store.Issue()
+	store.mutex.Lock()
+	_, found := store.nonces[old]
+	store.mutex.Unlock()
+	if found {
+		t.Error("expired nonce wasn't removed")
+	}
+}
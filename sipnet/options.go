@@ -0,0 +1,91 @@
+package sipnet
+
+import "strings"
+
+// Capabilities are the capabilities a UA answers an OPTIONS request with
+// (RFC 3261 section 11).
+type Capabilities struct {
+	// Allow are the methods supported, i.e. INVITE and BYE.
+	Allow []string
+
+	// Supported are the option tags of the extensions supported.
+	Supported []string
+
+	// SDP is the session description of the media capabilities (RFC 3264
+	// section 9), such as the codecs and directions offered, which may be
+	// built with the sdp package. It is only sent if the OPTIONS accepts
+	// application/sdp.
+	SDP []byte
+}
+
+// acceptsSDP returns whether a request accepts application/sdp bodies in its
+// response. A request without an Accept accepts application/sdp.
+func acceptsSDP(req *Request) bool {
+	accept := req.Header.Values("Accept")
+	if len(accept) == 0 {
+		return true
+	}
+
+	for _, value := range accept {
+		for _, mediaType := range strings.Split(value, ",") {
+			mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+			if strings.EqualFold(mediaType, "application/sdp") ||
+				mediaType == "application/*" || mediaType == "*/*" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Capabilities responds to an OPTIONS request with a StatusOK listing the
+// capabilities for convenience. The From and To are copied from the request
+// if they are not set, with a new tag added to the To.
+func (r *Response) Capabilities(conn *Conn, req *Request, caps Capabilities) {
+	r.StatusCode = StatusOK
+	if r.Header.Get("From") == "" {
+		r.Header.Set("From", req.Header.Get("From"))
+	}
+	if r.Header.Get("To") == "" {
+		to, err := req.To()
+		if err == nil && to.Tag() == "" {
+			to.Arguments.Set("tag", NewTag())
+			r.Header.Set("To", to.String())
+		} else {
+			r.Header.Set("To", req.Header.Get("To"))
+		}
+	}
+
+	if len(caps.Allow) > 0 {
+		r.Header.Set("Allow", strings.Join(caps.Allow, ", "))
+	}
+	if len(caps.Supported) > 0 {
+		r.Header.Set("Supported", strings.Join(caps.Supported, ", "))
+	}
+
+	r.Header.Set("Accept", "application/sdp")
+	if len(caps.SDP) > 0 && acceptsSDP(req) {
+		r.Header.Set("Content-Type", ContentTypeSDP)
+		r.SetBody(caps.SDP)
+	}
+
+	r.WriteTo(conn, req)
+}
+
+// answerOptions answers an OPTIONS request received outside of a dialog
+// with the configured Capabilities, returning true if it was answered.
+func (l *Listener) answerOptions(req *Request, conn *Conn) bool {
+	caps := l.config.Capabilities
+	if caps == nil || req.Method != MethodOptions {
+		return false
+	}
+
+	to, err := req.To()
+	if err != nil || to.Tag() != "" {
+		return false
+	}
+
+	NewResponse().Capabilities(conn, req, *caps)
+	return true
+}
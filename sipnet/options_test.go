@@ -0,0 +1,57 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptionsAnsweredWithSDP(t *testing.T) {
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=-\r\nc=IN IP4 127.0.0.1\r\n" +
+		"t=0 0\r\nm=audio 0 RTP/AVP 0 8\r\na=sendrecv\r\n"
+	l := listenTest(t, Config{Capabilities: &Capabilities{
+		Allow: []string{MethodInvite, MethodAck, MethodBye, MethodOptions},
+		SDP:   []byte(sdp),
+	}})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	tests := []struct {
+		accept string
+		body   string
+	}{
+		{"", sdp},
+		{"Accept: application/sdp", sdp},
+		{"Accept: text/plain", ""},
+	}
+
+	for i, test := range tests {
+		var extra []string
+		if test.accept != "" {
+			extra = append(extra, test.accept)
+		}
+		sendUDP(t, peer, l, testRequest(MethodOptions,
+			"z9hG4bKoptions"+string(rune('a'+i)), extra...))
+
+		data, _ := readUDP(t, peer)
+		resp, err := ReadResponse(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("%q: failed to parse response: %v", test.accept, err)
+		}
+
+		if resp.StatusCode != StatusOK {
+			t.Errorf("%q: answered with %d", test.accept, resp.StatusCode)
+		}
+		if allow := resp.Header.Get("Allow"); allow != "INVITE, ACK, BYE, OPTIONS" {
+			t.Errorf("%q: Allow is %q", test.accept, allow)
+		}
+		if string(resp.Body) != test.body {
+			t.Errorf("%q: body is %q, expected %q", test.accept, resp.Body,
+				test.body)
+		}
+		contentType := resp.Header.Get("Content-Type")
+		if test.body != "" && contentType != "application/sdp" {
+			t.Errorf("%q: Content-Type is %q", test.accept, contentType)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package sipnet
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// inviteKey returns the key matching an ACK to the INVITE it acknowledges,
+// made from the Call-ID, From tag and CSeq number, as an ACK for a 2xx
+// response has a branch of its own.
+func inviteKey(h Header) string {
+	cseq, err := ParseCSeq(h.Get("CSeq"))
+	if err != nil {
+		return ""
+	}
+
+	from, err := ParseUser(h.Get("From"))
+	if err != nil {
+		return ""
+	}
+
+	return h.Get("Call-ID") + " " +
+		strconv.FormatUint(uint64(cseq.Sequence), 10) + " " +
+		from.Arguments.Get("tag")
+}
+
+// expectAck records a received INVITE, so the ACK for its response is
+// matched to it. s.mutex must be held.
+func (s *Server) expectAck(req *Request) {
+	key := inviteKey(req.Header)
+	if key == "" {
+		return
+	}
+
+	s.invites[key] = s.clock().Now()
+}
+
+// isOrphanAck returns whether an ACK matches neither a dialog of the server
+// nor an INVITE it has received recently, such as a late ACK after its
+// INVITE timed out, or a spoofed one, in which case it is counted.
+func (s *Server) isOrphanAck(req *Request) bool {
+	if s.Dialogs.Find(req) != nil {
+		return false
+	}
+
+	key := inviteKey(req.Header)
+	s.mutex.Lock()
+	_, found := s.invites[key]
+	s.mutex.Unlock()
+	if found && key != "" {
+		return false
+	}
+
+	atomic.AddUint64(&s.orphanAcks, 1)
+	return true
+}
+
+// OrphanAcks returns the number of ACKs dropped by the server because they
+// matched no dialog or INVITE.
+func (s *Server) OrphanAcks() uint64 {
+	return atomic.LoadUint64(&s.orphanAcks)
+}
@@ -0,0 +1,140 @@
+package sipnet
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrBadFlowToken is returned by ParseFlowToken if a flow token is
+// malformed or was not generated with the key.
+var ErrBadFlowToken = errors.New("sip: invalid flow token")
+
+// Option tags of the registration extensions for UAs behind NATs.
+const (
+	// OptionPath indicates support for the Path header (RFC 3327).
+	OptionPath = "path"
+	// OptionOutbound indicates support for client initiated connections
+	// (RFC 5626).
+	OptionOutbound = "outbound"
+)
+
+// AddOptionTag adds an option tag to a header key such as Supported, unless
+// it is already listed.
+func (h Header) AddOptionTag(key, tag string) {
+	if h.HasOptionTag(key, tag) {
+		return
+	}
+
+	tags := append(h.OptionTags(key), tag)
+	h.Set(key, strings.Join(tags, ", "))
+}
+
+// RegID returns the reg-id parameter of a Contact (RFC 5626), which
+// distinguishes the flows registered by the same instance, and whether it
+// has a valid one.
+func (u User) RegID() (uint32, bool) {
+	id, err := strconv.ParseUint(u.Arguments.Get("reg-id"), 10, 32)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+
+	return uint32(id), true
+}
+
+// SetRegID sets the reg-id parameter of a Contact, to be sent in a REGISTER
+// alongside its instance ID.
+func (u *User) SetRegID(id uint32) {
+	if u.Arguments == nil {
+		u.Arguments = make(HeaderArgs)
+	}
+	u.Arguments.Set("reg-id", strconv.FormatUint(uint64(id), 10))
+}
+
+// Flow identifies a registration flow (RFC 5626) of a UA instance.
+type Flow struct {
+	InstanceID string
+	RegID      uint32
+}
+
+// RegistrationFlows returns the flows registered by a REGISTER, which are
+// the Contacts with both an instance ID and a reg-id, if the UA indicated
+// support for outbound. Each flow is a separate registration, so a UA may
+// register over several connections at once.
+func RegistrationFlows(req *Request) ([]Flow, error) {
+	if !req.Header.HasOptionTag("Supported", OptionOutbound) {
+		return nil, nil
+	}
+
+	contacts, err := ParseUsers(req.Header, "Contact")
+	if err != nil {
+		return nil, err
+	}
+
+	var flows []Flow
+	for _, contact := range contacts {
+		id, ok := contact.RegID()
+		if !ok || contact.InstanceID() == "" {
+			continue
+		}
+
+		flows = append(flows, Flow{
+			InstanceID: contact.InstanceID(),
+			RegID:      id,
+		})
+	}
+
+	return flows, nil
+}
+
+// FlowAddr is the transport and addresses of the connection identified by
+// a flow token.
+type FlowAddr struct {
+	Transport string
+	Local     string
+	Remote    string
+}
+
+// NewFlowToken returns a flow token (RFC 5626 section 5.2) identifying the
+// connection, to be used in the user part of the Path or Record-Route added
+// by an edge proxy, so requests to the UA are sent back over the same flow.
+// The token is signed with key, so it cannot be forged by the UA.
+func NewFlowToken(conn *Conn, key []byte) string {
+	data := conn.protocol().Name() + " " + conn.LocalAddr().String() + " " +
+		conn.Addr().String()
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(append(mac.Sum(nil),
+		data...))
+}
+
+// ParseFlowToken returns the flow a flow token generated by NewFlowToken
+// with the same key identifies.
+func ParseFlowToken(token string, key []byte) (FlowAddr, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < sha1.Size {
+		return FlowAddr{}, ErrBadFlowToken
+	}
+
+	sum, data := raw[:sha1.Size], raw[sha1.Size:]
+	mac := hmac.New(sha1.New, key)
+	mac.Write(data)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return FlowAddr{}, ErrBadFlowToken
+	}
+
+	parts := strings.Split(string(data), " ")
+	if len(parts) != 3 {
+		return FlowAddr{}, ErrBadFlowToken
+	}
+
+	return FlowAddr{
+		Transport: parts[0],
+		Local:     parts[1],
+		Remote:    parts[2],
+	}, nil
+}
@@ -0,0 +1,86 @@
+package sipnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegistrationFlows(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodRegister, "z9hG4bKflows",
+		"Supported: path, outbound",
+		`Contact: <sip:alice@192.0.2.1;transport=tcp>;reg-id=1;+sip.instance="<urn:uuid:00000000-0000-1000-8000-AABBCCDDEEFF>"`,
+		`Contact: <sip:alice@192.0.2.1;transport=tcp>;reg-id=2;+sip.instance="<urn:uuid:00000000-0000-1000-8000-AABBCCDDEEFF>"`,
+		"Contact: <sip:alice@192.0.2.2>;reg-id=3",
+		`Contact: <sip:alice@192.0.2.3>;+sip.instance="<urn:uuid:00000000-0000-1000-8000-000000000000>"`))
+
+	flows, err := RegistrationFlows(req)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	// Contacts without both an instance ID and a reg-id aren't flows.
+	instance := "urn:uuid:00000000-0000-1000-8000-AABBCCDDEEFF"
+	expected := []Flow{{instance, 1}, {instance, 2}}
+	if !reflect.DeepEqual(flows, expected) {
+		t.Errorf("parsed flows %v, expected %v", flows, expected)
+	}
+
+	req.Header.Del("Supported")
+	if flows, err := RegistrationFlows(req); flows != nil || err != nil {
+		t.Errorf("parsed flows %v, %v without outbound support", flows, err)
+	}
+}
+
+func TestRegID(t *testing.T) {
+	contact, _ := ParseUser("<sip:alice@192.0.2.1>")
+	if _, ok := contact.RegID(); ok {
+		t.Error("found a reg-id in a Contact without one")
+	}
+
+	contact.SetRegID(7)
+	if id, ok := contact.RegID(); !ok || id != 7 {
+		t.Errorf("reg-id is %d, %v, expected 7", id, ok)
+	}
+
+	contact.Arguments.Set("reg-id", "0")
+	if _, ok := contact.RegID(); ok {
+		t.Error("accepted a reg-id of 0")
+	}
+}
+
+func TestFlowToken(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Unlock()
+
+	key := []byte("edge proxy key")
+	token := NewFlowToken(conn, key)
+	flow, err := ParseFlowToken(token, key)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", token, err)
+	}
+	expected := FlowAddr{
+		Transport: "udp",
+		Local:     conn.LocalAddr().String(),
+		Remote:    peer.LocalAddr().String(),
+	}
+	if flow != expected {
+		t.Errorf("parsed %+v, expected %+v", flow, expected)
+	}
+
+	if _, err := ParseFlowToken(token, []byte("another key")); err != ErrBadFlowToken {
+		t.Errorf("parsing with another key returned %v, expected "+
+			"ErrBadFlowToken", err)
+	}
+	if _, err := ParseFlowToken(token[1:], key); err != ErrBadFlowToken {
+		t.Errorf("parsing a truncated token returned %v, expected "+
+			"ErrBadFlowToken", err)
+	}
+}
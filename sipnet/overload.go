@@ -0,0 +1,85 @@
+package sipnet
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultOverloadRetryAfter is the Retry-After of the 503s sent by an
+// overloaded Server at its OverloadThreshold, if OverloadRetryAfter is not
+// set.
+const DefaultOverloadRetryAfter = 5 * time.Second
+
+// maxOverloadFactor bounds the Retry-After of an overloaded Server to a
+// multiple of its OverloadRetryAfter.
+const maxOverloadFactor = 10
+
+// rejectUnavailable answers a request with a 503 Service Unavailable and a
+// Retry-After of delay, rounded up to a second, such as when shedding load.
+func rejectUnavailable(c *Conn, req *Request, delay time.Duration) {
+	resp := NewResponse()
+	resp.StatusCode = StatusServiceUnavailable
+	resp.Header.Set("To", withToTag(req.Header.Get("To")))
+	resp.Header.Set("From", req.Header.Get("From"))
+	resp.Header.Set("Retry-After", RetryAfter{
+		Delay: time.Duration(math.Ceil(delay.Seconds())) * time.Second,
+	}.String())
+	resp.WriteTo(c, req)
+}
+
+// Load returns the number of requests being dispatched by the server, which
+// are queued for or being handled by the handler.
+func (s *Server) Load() int {
+	return int(atomic.LoadInt64(&s.load))
+}
+
+// Shed returns the number of requests the server has answered with a 503
+// because it was overloaded.
+func (s *Server) Shed() uint64 {
+	return atomic.LoadUint64(&s.shed)
+}
+
+// overloadRetryAfter returns the Retry-After of a 503 sent at the given
+// load, which grows with the load above the threshold.
+func (s *Server) overloadRetryAfter(load int) time.Duration {
+	base := s.OverloadRetryAfter
+	if base <= 0 {
+		base = DefaultOverloadRetryAfter
+	}
+
+	factor := float64(load) / float64(s.OverloadThreshold)
+	if factor > maxOverloadFactor {
+		factor = maxOverloadFactor
+	}
+
+	return time.Duration(float64(base) * factor)
+}
+
+// overloaded returns whether a request is shed because more than
+// OverloadThreshold requests are being dispatched, answering it with a 503
+// Service Unavailable if it is. ACKs, CANCELs and requests within a dialog
+// are never shed, so calls in progress can still complete and end.
+func (s *Server) overloaded(req *Request, conn *Conn) bool {
+	if s.OverloadThreshold <= 0 {
+		return false
+	}
+
+	load := s.Load()
+	if load < s.OverloadThreshold {
+		return false
+	}
+
+	switch req.Method {
+	case MethodAck, MethodCancel:
+		return false
+	}
+
+	if s.Dialogs.Find(req) != nil {
+		return false
+	}
+
+	atomic.AddUint64(&s.shed, 1)
+	rejectUnavailable(conn, req, s.overloadRetryAfter(load))
+	return true
+}
@@ -0,0 +1,87 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverloadedServerSheds(t *testing.T) {
+	l := listenTest(t, Config{})
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	release := make(chan struct{})
+	s := NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+		if req.Header.Get("Subject") == "slow" {
+			<-release
+		}
+		resp := NewResponse()
+		resp.StatusCode = StatusOK
+		resp.Header.Set("From", req.Header.Get("From"))
+		resp.Header.Set("To", req.Header.Get("To")+";tag=b1")
+		resp.WriteTo(conn, req)
+	}, l)
+	s.OverloadThreshold = 2
+	s.OverloadRetryAfter = 2 * time.Second
+	go s.Serve()
+	defer s.Close()
+
+	// Two slow requests fill the queue.
+	for _, callID := range []string{"call2@", "call3@"} {
+		sendUDP(t, peer, l, strings.Replace(testRequest(MethodMessage,
+			"z9hG4bK"+strings.TrimSuffix(callID, "@"), "Subject: slow"), "call1@", callID, 1))
+	}
+	waitFor(t, "the slow requests to be dispatched", func() bool {
+		return s.Load() == 2
+	})
+
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKshed"))
+	data, _ := readUDP(t, peer)
+	resp, err := ReadResponse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse the response: %v", err)
+	}
+	if resp.StatusCode != StatusServiceUnavailable ||
+		resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("answered with %d and Retry-After %q, expected a 503",
+			resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if shed := s.Shed(); shed != 1 {
+		t.Errorf("%d requests shed, expected 1", shed)
+	}
+
+	// Once the queue drains, requests are handled again.
+	close(release)
+	for i := 0; i < 2; i++ {
+		if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+			t.Errorf("slow request answered with %q", startLine(data))
+		}
+	}
+	waitFor(t, "the queue to drain", func() bool {
+		return s.Load() == 0
+	})
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKdrained"))
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Errorf("request after draining answered with %q", startLine(data))
+	}
+}
+
+func TestOverloadRetryAfterGrows(t *testing.T) {
+	s := &Server{OverloadThreshold: 10}
+	tests := []struct {
+		load  int
+		delay time.Duration
+	}{
+		{10, DefaultOverloadRetryAfter},
+		{25, 5 * DefaultOverloadRetryAfter / 2},
+		{1000, maxOverloadFactor * DefaultOverloadRetryAfter},
+	}
+
+	for _, test := range tests {
+		if delay := s.overloadRetryAfter(test.load); delay != test.delay {
+			t.Errorf("load %d: Retry-After %v, expected %v", test.load, delay,
+				test.delay)
+		}
+	}
+}
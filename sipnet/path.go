@@ -0,0 +1,60 @@
+package sipnet
+
+// ParsePath returns the Path (RFC 3327) entries of a header in order, from
+// the proxy closest to the registrar to the proxy closest to the UA.
+func ParsePath(h Header) ([]User, error) {
+	return ParseUsers(h, "Path")
+}
+
+// AddPath adds the local side of conn to the top of the Path of a REGISTER
+// forwarded by a proxy over conn, so requests to the registered UA are
+// routed through the proxy.
+func (r *Request) AddPath(conn *Conn) {
+	r.Header.Prepend("Path", conn.RecordRoute().String())
+}
+
+// SetRouteSet replaces the Route of a request with the given route set, in
+// order. The route set of a request to a registered UA is its stored Path.
+func (r *Request) SetRouteSet(routes []User) {
+	r.Header.Del("Route")
+	for _, route := range routes {
+		r.Header.Add("Route", route.String())
+	}
+}
+
+// ParseServiceRoute returns the Service-Route (RFC 3608) entries of the
+// header of a REGISTER response in order.
+func ParseServiceRoute(h Header) ([]User, error) {
+	return ParseUsers(h, "Service-Route")
+}
+
+// preloadServiceRoute sets the Route of an out-of-dialog request without
+// a Route to the Service-Route of the connection.
+func (c *Conn) preloadServiceRoute(req *Request) {
+	if len(c.ServiceRoute) == 0 || req.Method == MethodRegister ||
+		len(req.Header.Values("Route")) > 0 {
+		return
+	}
+
+	to, err := ParseUser(req.Header.Get("To"))
+	if err != nil || to.Arguments.Get("tag") != "" {
+		return
+	}
+
+	req.SetRouteSet(c.ServiceRoute)
+}
+
+// updateServiceRoute stores the Service-Route of a successful response to
+// a REGISTER.
+func (c *Conn) updateServiceRoute(req *Request, resp *Response) {
+	if req.Method != MethodRegister || resp.StatusCode >= 300 {
+		return
+	}
+
+	routes, err := ParseServiceRoute(resp.Header)
+	if err != nil {
+		return
+	}
+
+	c.ServiceRoute = routes
+}
@@ -0,0 +1,99 @@
+package sipnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddPath(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	req := parseRequest(t, testRequest(MethodRegister, "z9hG4bK1",
+		"Path: <sip:edge1.example.com;lr>"))
+	req.AddPath(conn)
+
+	paths, err := ParsePath(req.Header)
+	if err != nil {
+		t.Fatalf("failed to parse Path: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("got %d Path entries, expected 2", len(paths))
+	}
+	if paths[0].URI.Domain != conn.SentBy() ||
+		paths[0].URI.Arguments.Get("transport") != "tcp" {
+		t.Errorf("top Path is %q, expected the proxy %q", paths[0],
+			conn.RecordRoute())
+	}
+	if paths[1].URI.Domain != "edge1.example.com" {
+		t.Errorf("second Path is %q, expected the existing Path", paths[1])
+	}
+}
+
+func TestPathRouteSet(t *testing.T) {
+	register := parseRequest(t, testRequest(MethodRegister, "z9hG4bK1",
+		"Path: <sip:p1.example.com;lr>, <sip:p2.example.com;lr>",
+		"Path: <sip:p3.example.com;lr>"))
+	paths, err := ParsePath(register.Header)
+	if err != nil {
+		t.Fatalf("failed to parse Path: %v", err)
+	}
+
+	invite := parseRequest(t, testRequest(MethodInvite, "z9hG4bK2",
+		"Route: <sip:stale.example.com;lr>"))
+	invite.SetRouteSet(paths)
+
+	routes, err := ParseUsers(invite.Header, "Route")
+	if err != nil {
+		t.Fatalf("failed to parse Route: %v", err)
+	}
+	var domains []string
+	for _, route := range routes {
+		domains = append(domains, route.URI.Domain)
+	}
+	expected := []string{"p1.example.com", "p2.example.com", "p3.example.com"}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Errorf("route set is %v, expected %v", domains, expected)
+	}
+}
+
+func TestServiceRoutePreloaded(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	results := goDo(conn, newTestRequest(MethodRegister, "sip:127.0.0.1"), nil)
+	register := parseRequest(t, readPipe(t, remote))
+	writePipe(t, remote, testResponse(register, "200 OK",
+		"Service-Route: <sip:orig1.example.com;lr>, <sip:orig2.example.com;lr>"))
+	if result := <-results; result.err != nil {
+		t.Fatalf("failed to register: %v", result.err)
+	}
+
+	results = goDo(conn, newTestRequest(MethodMessage, "sip:bob@127.0.0.1"), nil)
+	message := parseRequest(t, readPipe(t, remote))
+	routes, err := ParseUsers(message.Header, "Route")
+	if err != nil {
+		t.Fatalf("failed to parse Route: %v", err)
+	}
+	if len(routes) != 2 || routes[0].URI.Domain != "orig1.example.com" ||
+		routes[1].URI.Domain != "orig2.example.com" {
+		t.Errorf("request has Route %v, expected the Service-Route",
+			message.Header.Values("Route"))
+	}
+	writePipe(t, remote, testResponse(message, "200 OK"))
+	<-results
+
+	// A request within a dialog is routed by the route set of the dialog.
+	req := newTestRequest(MethodBye, "sip:bob@127.0.0.1")
+	req.Header.Set("To", "<sip:bob@127.0.0.1>;tag=b1")
+	results = goDo(conn, req, nil)
+	bye := parseRequest(t, readPipe(t, remote))
+	if len(bye.Header.Values("Route")) > 0 {
+		t.Errorf("in-dialog request has Route %v, expected none",
+			bye.Header.Values("Route"))
+	}
+	writePipe(t, remote, testResponse(bye, "200 OK"))
+	<-results
+}
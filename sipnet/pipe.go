@@ -0,0 +1,23 @@
+package sipnet
+
+import "net"
+
+// NewPipeConn returns a Conn for the given transport ("udp" or "tcp") which
+// is connected to an in-memory pipe rather than a network socket, along with
+// the other end of the pipe. It is intended for testing.
+//
+// Bytes written to the returned net.Conn are received by the Conn through the
+// same code path as data received from a real peer, with each write treated
+// as a single datagram on UDP. Received messages are read with Conn.Read,
+// and data flushed by the Conn can be read from the returned net.Conn.
+// Closing the returned net.Conn closes the pipe.
+func NewPipeConn(transport string) (*Conn, net.Conn) {
+	local, remote := net.Pipe()
+
+	t := transportByName(transport)
+	if t == nil {
+		t = TCP
+	}
+
+	return newConn(t, local), remote
+}
@@ -0,0 +1,69 @@
+package sipnet
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func ExampleNewPipeConn() {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	remote.Write([]byte("OPTIONS sip:bob@127.0.0.1 SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKexample\r\n" +
+		"From: <sip:alice@127.0.0.1>;tag=a1\r\n" +
+		"To: <sip:bob@127.0.0.1>\r\n" +
+		"Call-ID: example@127.0.0.1\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"Max-Forwards: 70\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+
+	req := conn.Read().(*Request)
+	fmt.Println(req.Method, req.Server)
+	fmt.Println(req.Header.Get("Call-ID"))
+	// Output:
+	// OPTIONS sip:bob@127.0.0.1
+	// example@127.0.0.1
+}
+
+func TestPipeConnParsesStream(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+
+	msg := testRequest(MethodMessage, "z9hG4bKstream")
+	msg = msg[:len(msg)-len("Content-Length: 0\r\n\r\n")] +
+		"Content-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello"
+
+	// Messages split across writes are reassembled, and messages sharing a
+	// write are separated.
+	writePipe(t, remote, msg[:20])
+	writePipe(t, remote, msg[20:]+testRequest(MethodOptions, "z9hG4bKnext"))
+
+	req := readRequest(t, conn)
+	if req.Method != MethodMessage || string(req.Body) != "hello" {
+		t.Errorf("read %s with body %q, expected MESSAGE with body \"hello\"",
+			req.Method, req.Body)
+	}
+	if req := readRequest(t, conn); req.Method != MethodOptions {
+		t.Errorf("read %s, expected OPTIONS", req.Method)
+	}
+}
+
+func TestPipeConnFlushes(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	writePipe(t, remote, testRequest(MethodOptions, "z9hG4bKflush"))
+	req := readRequest(t, conn)
+
+	respond(conn, req, StatusOK, "b1")
+	resp, err := ReadResponse(strings.NewReader(readPipe(t, remote)))
+	if err != nil {
+		t.Fatalf("failed to parse flushed response: %v", err)
+	}
+	if resp.StatusCode != StatusOK || resp.Header.Get("CSeq") != "1 OPTIONS" {
+		t.Errorf("flushed %d with CSeq %q, expected 200 with CSeq \"1 OPTIONS\"",
+			resp.StatusCode, resp.Header.Get("CSeq"))
+	}
+}
@@ -0,0 +1,55 @@
+package sipnet
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrPacketInfoUnsupported is returned by ListenWithConfig if PacketInfo is
+// configured on a platform which doesn't support it.
+var ErrPacketInfoUnsupported = errors.New("sip: packet info unsupported")
+
+// readUDPWithDestination reads a datagram like ReadFrame, and also returns
+// the local IP it was sent to, which is nil if it is unknown.
+func readUDPWithDestination(conn *net.UDPConn) ([]byte, net.Addr, net.IP, error) {
+	buf := udpBuffers.Get().([]byte)
+	defer udpBuffers.Put(buf)
+
+	oob := make([]byte, packetInfoSpace)
+	n, oobn, _, addr, err := conn.ReadMsgUDP(buf, oob)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return append([]byte(nil), buf[:n]...), addr, parsePacketInfo(oob[:oobn]),
+		nil
+}
+
+// setDestination records the local IP the last datagram from the peer was
+// received on.
+func (c *Conn) setDestination(ip net.IP) {
+	if ip != nil {
+		c.destination.Store(ip)
+	}
+}
+
+// receivedOn returns the local IP the last datagram from the peer was
+// received on, or nil if it is unknown.
+func (c *Conn) receivedOn() net.IP {
+	ip, _ := c.destination.Load().(net.IP)
+	return ip
+}
+
+// writeFromDestination sends b to the peer from the local IP its last
+// datagram was received on, returning false if it is unknown.
+func (c *Conn) writeFromDestination(b []byte) (bool, error) {
+	ip := c.receivedOn()
+	udpConn, ok := c.packetConn.(*net.UDPConn)
+	addr, isUDP := c.Address.(*net.UDPAddr)
+	if ip == nil || !ok || !isUDP {
+		return false, nil
+	}
+
+	_, _, err := udpConn.WriteMsgUDP(b, packetInfoFrom(ip), addr)
+	return true, err
+}
@@ -0,0 +1,89 @@
+package sipnet
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// packetInfoSpace is the size of the control message buffer needed to
+// receive the packet info of a datagram.
+var packetInfoSpace = syscall.CmsgSpace(syscall.SizeofInet6Pktinfo)
+
+// enablePacketInfo enables the packet info of datagrams received on conn.
+// An IPv6 socket may also receive IPv4 datagrams, so both are enabled, and
+// it is an error only if neither can be.
+func enablePacketInfo(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ipv4Err, ipv6Err error
+	err = raw.Control(func(fd uintptr) {
+		ipv4Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP,
+			syscall.IP_PKTINFO, 1)
+		ipv6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6,
+			syscall.IPV6_RECVPKTINFO, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	if ipv4Err != nil && ipv6Err != nil {
+		return ipv4Err
+	}
+
+	return nil
+}
+
+// parsePacketInfo returns the destination IP of a datagram from its
+// control messages, or nil if there is none.
+func parsePacketInfo(oob []byte) net.IP {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.IPPROTO_IP &&
+			msg.Header.Type == syscall.IP_PKTINFO &&
+			len(msg.Data) >= syscall.SizeofInet4Pktinfo:
+			info := (*syscall.Inet4Pktinfo)(unsafe.Pointer(&msg.Data[0]))
+			return net.IP(append([]byte(nil), info.Addr[:]...))
+		case msg.Header.Level == syscall.IPPROTO_IPV6 &&
+			msg.Header.Type == syscall.IPV6_PKTINFO &&
+			len(msg.Data) >= syscall.SizeofInet6Pktinfo:
+			info := (*syscall.Inet6Pktinfo)(unsafe.Pointer(&msg.Data[0]))
+			return net.IP(append([]byte(nil), info.Addr[:]...))
+		}
+	}
+
+	return nil
+}
+
+// packetInfoFrom returns the control message to send a datagram from ip.
+func packetInfoFrom(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		oob := make([]byte, syscall.CmsgSpace(syscall.SizeofInet4Pktinfo))
+		h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		h.Level = syscall.IPPROTO_IP
+		h.Type = syscall.IP_PKTINFO
+		h.SetLen(syscall.CmsgLen(syscall.SizeofInet4Pktinfo))
+
+		info := (*syscall.Inet4Pktinfo)(unsafe.Pointer(&oob[syscall.CmsgLen(0)]))
+		copy(info.Spec_dst[:], ip4)
+		return oob
+	}
+
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofInet6Pktinfo))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = syscall.IPPROTO_IPV6
+	h.Type = syscall.IPV6_PKTINFO
+	h.SetLen(syscall.CmsgLen(syscall.SizeofInet6Pktinfo))
+
+	info := (*syscall.Inet6Pktinfo)(unsafe.Pointer(&oob[syscall.CmsgLen(0)]))
+	copy(info.Addr[:], ip.To16())
+	return oob
+}
@@ -0,0 +1,42 @@
+package sipnet
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPacketInfoDestination(t *testing.T) {
+	l, err := ListenWithConfig("0.0.0.0:0", Config{PacketInfo: true})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	// The whole of 127.0.0.0/8 is local on Linux, so the datagram is
+	// received on an address other than the peer's.
+	_, port := splitAddr(l.TransportAddr("udp"))
+	dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: port}
+	msg := testRequest(MethodMessage, "z9hG4bKpktinfo")
+	if _, err := peer.WriteTo([]byte(msg), dst); err != nil {
+		t.Fatalf("failed to send datagram: %v", err)
+	}
+
+	req, conn := acceptRequest(t, l)
+	local, ok := req.LocalAddr.(*net.UDPAddr)
+	if !ok || !local.IP.Equal(dst.IP) {
+		t.Fatalf("received on %v, expected %v", req.LocalAddr, dst.IP)
+	}
+	if sentBy := conn.SentBy(); sentBy != dst.String() {
+		t.Errorf("sent-by is %q, expected %q", sentBy, dst)
+	}
+
+	// The response is sent from the address the request was received on.
+	if err := <-respond(conn, req, StatusOK, "b1"); err != nil {
+		t.Fatalf("failed to respond: %v", err)
+	}
+	if _, from := readUDP(t, peer); from.String() != dst.String() {
+		t.Errorf("response sent from %v, expected %v", from, dst)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package sipnet
+
+import "net"
+
+// packetInfoSpace is zero, as packet info is not supported on this
+// platform.
+const packetInfoSpace = 0
+
+// enablePacketInfo is not supported on this platform.
+func enablePacketInfo(conn *net.UDPConn) error {
+	return ErrPacketInfoUnsupported
+}
+
+func parsePacketInfo(oob []byte) net.IP {
+	return nil
+}
+
+func packetInfoFrom(ip net.IP) []byte {
+	return nil
+}
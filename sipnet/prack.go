@@ -0,0 +1,145 @@
+package sipnet
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrRSeqExhausted is returned by RSeqCounter.Next if the RSeq of a
+// transaction would overflow.
+var ErrRSeqExhausted = errors.New("sip: rseq exhausted")
+
+// ParseRSeq parses the value of an RSeq header (RFC 3262), which is a
+// sequence number from 1 to 2^32-1.
+func ParseRSeq(str string) (uint32, error) {
+	str = strings.TrimSpace(str)
+	if !isDigits(str) {
+		return 0, ErrParseError
+	}
+
+	seq, err := strconv.ParseUint(str, 10, 32)
+	if err != nil || seq == 0 {
+		return 0, ErrParseError
+	}
+
+	return uint32(seq), nil
+}
+
+// isDigits returns whether str is a non-empty string of decimal digits.
+func isDigits(str string) bool {
+	if str == "" {
+		return false
+	}
+
+	for _, c := range str {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// RSeq returns the RSeq of a reliable provisional response.
+func (r *Response) RSeq() (uint32, error) {
+	return ParseRSeq(r.Header.Get("RSeq"))
+}
+
+// RAck represents the contents of the RAck header of a PRACK (RFC 3262),
+// which identifies the reliable provisional response it acknowledges.
+type RAck struct {
+	RSeq     uint32
+	Sequence uint32
+	Method   string
+}
+
+// ParseRAck parses the value of a RAck header, i.e. "776656 1 INVITE".
+func ParseRAck(str string) (RAck, error) {
+	fields := strings.Fields(str)
+	if len(fields) != 3 {
+		return RAck{}, ErrParseError
+	}
+
+	rseq, err := ParseRSeq(fields[0])
+	if err != nil {
+		return RAck{}, err
+	}
+
+	if !isDigits(fields[1]) {
+		return RAck{}, ErrParseError
+	}
+
+	seq, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return RAck{}, ErrParseError
+	}
+
+	return RAck{
+		RSeq:     rseq,
+		Sequence: uint32(seq),
+		Method:   strings.ToUpper(fields[2]),
+	}, nil
+}
+
+// String returns the RAck as a header value.
+func (r RAck) String() string {
+	return strconv.FormatUint(uint64(r.RSeq), 10) + " " +
+		strconv.FormatUint(uint64(r.Sequence), 10) + " " + r.Method
+}
+
+// NewRAck returns the RAck of a PRACK acknowledging a reliable provisional
+// response, from its RSeq and CSeq.
+func NewRAck(resp *Response) (RAck, error) {
+	rseq, err := resp.RSeq()
+	if err != nil {
+		return RAck{}, err
+	}
+
+	cseq, err := ParseCSeq(resp.Header.Get("CSeq"))
+	if err != nil {
+		return RAck{}, err
+	}
+
+	return RAck{
+		RSeq:     rseq,
+		Sequence: cseq.Sequence,
+		Method:   cseq.Method,
+	}, nil
+}
+
+// Matches returns whether the RAck acknowledges a reliable provisional
+// response.
+func (r RAck) Matches(resp *Response) bool {
+	ack, err := NewRAck(resp)
+	return err == nil && ack == r
+}
+
+// RSeqCounter generates the RSeqs of the reliable provisional responses of
+// a single transaction (RFC 3262 section 3), which start at a random value
+// below 2^31 and increase by one for each response. The zero value is
+// ready to use, and it is safe to use from multiple goroutines.
+type RSeqCounter struct {
+	mutex sync.Mutex
+	last  uint32
+}
+
+// Next returns the RSeq of the next reliable provisional response, or
+// ErrRSeqExhausted if it would exceed 2^32-1.
+func (c *RSeqCounter) Next() (uint32, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.last == 0 {
+		c.last = uint32(rand.Int31n(1<<31-1)) + 1
+		return c.last, nil
+	}
+
+	if c.last == 1<<32-1 {
+		return 0, ErrRSeqExhausted
+	}
+
+	c.last++
+	return c.last, nil
+}
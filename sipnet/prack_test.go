@@ -0,0 +1,98 @@
+package sipnet
+
+import "testing"
+
+func TestParseRSeq(t *testing.T) {
+	tests := []struct {
+		str      string
+		rseq     uint32
+		expected error
+	}{
+		{"1", 1, nil},
+		{" 776656 ", 776656, nil},
+		{"4294967295", 1<<32 - 1, nil},
+		{"4294967296", 0, ErrParseError},
+		{"0", 0, ErrParseError},
+		{"-1", 0, ErrParseError},
+		{"+5", 0, ErrParseError},
+		{"12a", 0, ErrParseError},
+		{"", 0, ErrParseError},
+	}
+
+	for _, test := range tests {
+		rseq, err := ParseRSeq(test.str)
+		if rseq != test.rseq || err != test.expected {
+			t.Errorf("parsing %q returned %d, %v, expected %d, %v", test.str,
+				rseq, err, test.rseq, test.expected)
+		}
+	}
+}
+
+func TestParseRAck(t *testing.T) {
+	tests := []struct {
+		str      string
+		rack     RAck
+		expected error
+	}{
+		{"776656 1 INVITE", RAck{776656, 1, MethodInvite}, nil},
+		{"4294967295  4294967295 invite", RAck{1<<32 - 1, 1<<32 - 1, MethodInvite}, nil},
+		{"776656 0 INVITE", RAck{776656, 0, MethodInvite}, nil},
+		{"4294967296 1 INVITE", RAck{}, ErrParseError},
+		{"776656 4294967296 INVITE", RAck{}, ErrParseError},
+		{"0 1 INVITE", RAck{}, ErrParseError},
+		{"776656 -1 INVITE", RAck{}, ErrParseError},
+		{"776656 1", RAck{}, ErrParseError},
+		{"776656 1 INVITE extra", RAck{}, ErrParseError},
+	}
+
+	for _, test := range tests {
+		rack, err := ParseRAck(test.str)
+		if rack != test.rack || err != test.expected {
+			t.Errorf("parsing %q returned %+v, %v, expected %+v, %v",
+				test.str, rack, err, test.rack, test.expected)
+		}
+	}
+
+	if str := (RAck{776656, 1, MethodInvite}).String(); str != "776656 1 INVITE" {
+		t.Errorf("serialized %q", str)
+	}
+}
+
+func TestRAckMatches(t *testing.T) {
+	resp := NewResponse()
+	resp.StatusCode = StatusSessionProgress
+	resp.Header.Set("RSeq", "776656")
+	resp.Header.Set("CSeq", "1 INVITE")
+
+	rack, err := NewRAck(resp)
+	if err != nil {
+		t.Fatalf("failed to create RAck: %v", err)
+	}
+	if rack != (RAck{776656, 1, MethodInvite}) || !rack.Matches(resp) {
+		t.Errorf("created %+v, which doesn't match the response", rack)
+	}
+
+	resp.Header.Set("RSeq", "776657")
+	if rack.Matches(resp) {
+		t.Error("matched the next reliable response")
+	}
+}
+
+func TestRSeqCounter(t *testing.T) {
+	var counter RSeqCounter
+	first, err := counter.Next()
+	if err != nil || first == 0 || first >= 1<<31 {
+		t.Fatalf("first RSeq is %d, %v, expected one below 2^31", first, err)
+	}
+	if next, err := counter.Next(); err != nil || next != first+1 {
+		t.Errorf("next RSeq is %d, %v, expected %d", next, err, first+1)
+	}
+
+	counter.last = 1<<32 - 2
+	if last, err := counter.Next(); err != nil || last != 1<<32-1 {
+		t.Errorf("last RSeq is %d, %v, expected 2^32-1", last, err)
+	}
+	if _, err := counter.Next(); err != ErrRSeqExhausted {
+		t.Errorf("overflowing RSeq returned %v, expected ErrRSeqExhausted", err)
+	}
+}
@@ -0,0 +1,75 @@
+package sipnet
+
+import "strings"
+
+// Priority represents the value of the Priority header (RFC 3261 section
+// 20.26), which is the urgency of a request as perceived by the UAC.
+type Priority string
+
+// The priorities defined by RFC 3261. Other tokens are extension
+// priorities.
+const (
+	PriorityEmergency Priority = "emergency"
+	PriorityUrgent    Priority = "urgent"
+	PriorityNormal    Priority = "normal"
+	PriorityNonUrgent Priority = "non-urgent"
+)
+
+// ParsePriority parses the value of a Priority header, which is one of the
+// defined priorities or an extension token. Priorities are compared
+// case-insensitively, so the value is lower cased.
+func ParsePriority(str string) (Priority, error) {
+	str = strings.TrimSpace(str)
+	if !isToken(str, "") {
+		return "", ErrParseError
+	}
+
+	return Priority(strings.ToLower(str)), nil
+}
+
+// IsExtension returns whether the priority is an extension priority rather
+// than one defined by RFC 3261.
+func (p Priority) IsExtension() bool {
+	switch p {
+	case PriorityEmergency, PriorityUrgent, PriorityNormal, PriorityNonUrgent:
+		return false
+	}
+	return true
+}
+
+// Priority returns the Priority header of a request, or PriorityNormal if
+// it has none, which is its meaning when absent.
+func (h Header) Priority() (Priority, error) {
+	value := h.Get("Priority")
+	if value == "" {
+		return PriorityNormal, nil
+	}
+
+	return ParsePriority(value)
+}
+
+// SetPriority sets the Priority header, or removes it if priority is empty.
+func (h Header) SetPriority(priority Priority) {
+	if priority == "" {
+		h.Del("Priority")
+		return
+	}
+
+	h.Set("Priority", string(priority))
+}
+
+// Subject returns the Subject header, which is a summary or the nature of
+// the call.
+func (h Header) Subject() string {
+	return strings.TrimSpace(h.Get("Subject"))
+}
+
+// SetSubject sets the Subject header, or removes it if subject is empty.
+func (h Header) SetSubject(subject string) {
+	if subject == "" {
+		h.Del("Subject")
+		return
+	}
+
+	h.Set("Subject", subject)
+}
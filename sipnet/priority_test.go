@@ -0,0 +1,50 @@
+package sipnet
+
+import "testing"
+
+func TestPriorityAndSubjectRoundTrip(t *testing.T) {
+	tests := []struct {
+		priority  Priority
+		extension bool
+	}{
+		{PriorityEmergency, false},
+		{PriorityNonUrgent, false},
+		{Priority("x-life-threatening"), true},
+	}
+
+	for _, test := range tests {
+		req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKpriority"))
+		req.Header.SetPriority(test.priority)
+		req.Header.SetSubject("Project X, take two")
+
+		parsed := parseRequest(t, writeRequest(t, req))
+		priority, err := parsed.Header.Priority()
+		if err != nil {
+			t.Errorf("%s: failed to parse the priority: %v", test.priority, err)
+		} else if priority != test.priority {
+			t.Errorf("%s: round-tripped to %q", test.priority, priority)
+		}
+		if priority.IsExtension() != test.extension {
+			t.Errorf("%s: IsExtension is %v", test.priority, !test.extension)
+		}
+		if subject := parsed.Header.Subject(); subject != "Project X, take two" {
+			t.Errorf("%s: subject round-tripped to %q", test.priority, subject)
+		}
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	if priority, err := ParsePriority(" Urgent "); err != nil ||
+		priority != PriorityUrgent {
+		t.Errorf("parsed \"Urgent\" as %q, %v", priority, err)
+	}
+	if _, err := ParsePriority("very urgent"); err != ErrParseError {
+		t.Errorf("parsing a non-token returned %v, expected ErrParseError", err)
+	}
+
+	// A request without a Priority header has normal priority.
+	if priority, err := NewRequest().Header.Priority(); err != nil ||
+		priority != PriorityNormal {
+		t.Errorf("missing priority is %q, %v, expected normal", priority, err)
+	}
+}
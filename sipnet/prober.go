@@ -0,0 +1,102 @@
+package sipnet
+
+import (
+	"sync"
+	"time"
+)
+
+// Prober checks the liveness of a connection by periodically sending
+// OPTIONS requests over it with Do, which retransmits them over UDP. Any
+// response other than a 408 means the UA is alive.
+//
+// As probes are sent with Do, the connection must be locked, and must not
+// be read from elsewhere while the prober runs.
+type Prober struct {
+	conn     *Conn
+	target   URI
+	interval time.Duration
+	sequence uint32
+	callID   string
+	tag      string
+
+	dead     chan error
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewProber returns a prober which starts sending an OPTIONS to the target
+// over conn every interval.
+func NewProber(conn *Conn, target URI, interval time.Duration) *Prober {
+	p := &Prober{
+		conn:     conn,
+		target:   target,
+		interval: interval,
+		callID:   NewCallID(conn.SentBy()),
+		tag:      NewTag(),
+		dead:     make(chan error, 1),
+		stop:     make(chan struct{}),
+	}
+
+	go p.run()
+	return p
+}
+
+// Dead returns a channel which receives the error of the probe which failed
+// once the connection is found to be dead, after which probing stops.
+func (p *Prober) Dead() <-chan error {
+	return p.dead
+}
+
+// Stop stops probing.
+func (p *Prober) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+func (p *Prober) run() {
+	ticker := p.conn.clock().NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C():
+		}
+
+		err := p.probe()
+		if err != nil {
+			p.dead <- err
+			p.Stop()
+			return
+		}
+	}
+}
+
+// probe sends a single OPTIONS, and returns an error if it wasn't answered.
+func (p *Prober) probe() error {
+	p.sequence++
+
+	from := p.conn.Contact("")
+	from.Arguments.Set("tag", p.tag)
+	to := User{URI: p.target, Arguments: make(HeaderArgs)}
+
+	req := NewRequest()
+	req.Method = MethodOptions
+	req.Server = p.target.String()
+	req.Header.Set("From", from.String())
+	req.Header.Set("To", to.String())
+	req.Header.Set("Call-ID", p.callID)
+	req.Header.Set("CSeq", CSeq{Sequence: p.sequence, Method: MethodOptions}.String())
+	req.SetMaxForwards(DefaultMaxForwards)
+
+	resp, err := p.conn.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == StatusRequestTimeout {
+		return ErrTimeout
+	}
+
+	return nil
+}
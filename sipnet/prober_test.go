@@ -0,0 +1,85 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProberReportsDeadConnection(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	target := URI{Scheme: "sip", Username: "bob", Domain: peer.LocalAddr().String()}
+	prober := NewProber(conn, target, time.Minute)
+	defer prober.Stop()
+
+	// The first probe is answered, so the connection is alive.
+	waitForTimer(t, clock, start.Add(time.Minute))
+	clock.Advance(time.Minute)
+	first, _ := readUDPRequest(t, peer)
+	if first.Method != MethodOptions {
+		t.Fatalf("sent %s, expected OPTIONS", first.Method)
+	}
+	peer.WriteTo([]byte(testResponse(first, "200 OK")), l.TransportAddr("udp"))
+
+	timeout := start.Add(time.Minute + transactionTimeout)
+	waitFor(t, "the probe to complete", func() bool {
+		clock.mutex.Lock()
+		defer clock.mutex.Unlock()
+		for _, w := range clock.waiters {
+			if !w.stopped && w.at.Equal(timeout) {
+				return false
+			}
+		}
+		return true
+	})
+
+	select {
+	case err := <-prober.Dead():
+		t.Fatalf("connection reported dead after an answered probe: %v", err)
+	default:
+	}
+
+	// The stub stops answering, so the next probe times out.
+	waitForTimer(t, clock, start.Add(2*time.Minute))
+	clock.Advance(time.Minute)
+	second, _ := readUDPRequest(t, peer)
+	cseq, _ := ParseCSeq(second.Header.Get("CSeq"))
+	if second.Method != MethodOptions || cseq.Sequence != 2 {
+		t.Fatalf("sent %s with CSeq %d, expected OPTIONS with CSeq 2",
+			second.Method, cseq.Sequence)
+	}
+
+	waitForTimer(t, clock, start.Add(2*time.Minute+transactionTimeout))
+	clock.Advance(transactionTimeout)
+
+	select {
+	case err := <-prober.Dead():
+		if err != ErrTimeout {
+			t.Errorf("reported %v, expected ErrTimeout", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("connection wasn't reported dead")
+	}
+
+	// Probing stops once the connection is dead, after the retransmissions
+	// of the last probe are drained.
+	buf := make([]byte, 65535)
+	for {
+		peer.SetReadDeadline(time.Now().Add(quietTimeout))
+		if _, _, err := peer.ReadFrom(buf); err != nil {
+			break
+		}
+	}
+	clock.Advance(time.Minute)
+	expectNoUDP(t, peer)
+}
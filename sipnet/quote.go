@@ -0,0 +1,127 @@
+package sipnet
+
+import (
+	"net/url"
+	"strings"
+)
+
+// QuoteString returns s as a quoted-string as defined in RFC 3261, with
+// double quotes and backslashes escaped.
+func QuoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// UnquoteString returns the contents of a quoted-string with its escapes
+// removed. If s is not a quoted-string, it is returned unchanged.
+func UnquoteString(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	var b strings.Builder
+	var escape bool
+	for _, r := range s[1 : len(s)-1] {
+		if !escape && r == '\\' {
+			escape = true
+			continue
+		}
+		escape = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isTokenChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+		(r >= '0' && r <= '9') || strings.ContainsRune("-.!%*_+`'~", r)
+}
+
+// isToken returns whether s is a token as defined in RFC 3261, optionally
+// also allowing the extra characters in allowed.
+func isToken(s, allowed string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if !isTokenChar(r) && !strings.ContainsRune(allowed, r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// quoteDisplayName quotes a display name unless it is made of tokens
+// separated by spaces.
+func quoteDisplayName(name string) string {
+	for _, word := range strings.Split(name, " ") {
+		if !isToken(word, "") {
+			return QuoteString(name)
+		}
+	}
+
+	return name
+}
+
+// QuoteParam returns the value of a header parameter, which is quoted if it
+// is not a token or a host.
+func QuoteParam(value string) string {
+	if value == "" || isToken(value, ":[]") {
+		return value
+	}
+
+	return QuoteString(value)
+}
+
+// EscapeURIParam percent-encodes the characters of a URI parameter name or
+// value which are not allowed to appear unescaped, as defined in RFC 3261.
+func EscapeURIParam(value string) string {
+	return escapeURI(value, "[]/:&+$")
+}
+
+// escapeURIHeader percent-encodes the characters of a URI header name or
+// value which are not allowed to appear unescaped, as defined in RFC 3261.
+func escapeURIHeader(value string) string {
+	return escapeURI(value, "[]/?:+$")
+}
+
+// escapeURI percent-encodes the characters of value other than the
+// unreserved characters and those in allowed.
+func escapeURI(value, allowed string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9') || strings.IndexByte("-_.!~*'()", c) >= 0 ||
+			strings.IndexByte(allowed, c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+
+		b.WriteByte('%')
+		b.WriteByte("0123456789ABCDEF"[c>>4])
+		b.WriteByte("0123456789ABCDEF"[c&15])
+	}
+	return b.String()
+}
+
+// UnescapeURIParam decodes a percent-encoded URI parameter name or value.
+// If the value is not validly encoded, it is returned unchanged.
+func UnescapeURIParam(value string) string {
+	unescaped, err := url.PathUnescape(value)
+	if err != nil {
+		return value
+	}
+
+	return unescaped
+}
@@ -0,0 +1,106 @@
+package sipnet
+
+import "testing"
+
+func TestQuoteString(t *testing.T) {
+	tests := []struct {
+		value  string
+		quoted string
+	}{
+		{"Alice", `"Alice"`},
+		{`Say "hi"`, `"Say \"hi\""`},
+		{`back\slash`, `"back\\slash"`},
+	}
+
+	for _, test := range tests {
+		if quoted := QuoteString(test.value); quoted != test.quoted {
+			t.Errorf("quoted %q as %s, expected %s", test.value, quoted, test.quoted)
+		}
+		if value := UnquoteString(test.quoted); value != test.value {
+			t.Errorf("unquoted %s as %q, expected %q", test.quoted, value, test.value)
+		}
+	}
+
+	if value := UnquoteString("token"); value != "token" {
+		t.Errorf("unquoted a token as %q, expected it unchanged", value)
+	}
+}
+
+func TestDisplayNameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		formatted string
+	}{
+		{"Alice Smith", "Alice Smith <sip:alice@example.com>;tag=a1"},
+		{"Smith, Alice", `"Smith, Alice" <sip:alice@example.com>;tag=a1`},
+		{`Alice "Al" Smith`,
+			`"Alice \"Al\" Smith" <sip:alice@example.com>;tag=a1`},
+		{"<Alice>", `"<Alice>" <sip:alice@example.com>;tag=a1`},
+	}
+
+	for _, test := range tests {
+		args := make(HeaderArgs)
+		args.Set("tag", "a1")
+		user := User{
+			Name:      test.name,
+			URI:       URI{Scheme: "sip", Username: "alice", Domain: "example.com"},
+			Arguments: args,
+		}
+
+		formatted := user.String()
+		if formatted != test.formatted {
+			t.Errorf("formatted %q as %s, expected %s", test.name, formatted,
+				test.formatted)
+		}
+
+		parsed, err := ParseUser(formatted)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", formatted, err)
+			continue
+		}
+		if parsed.Name != test.name || parsed.Arguments.Get("tag") != "a1" {
+			t.Errorf("parsed %s as %q with tag %q", formatted, parsed.Name,
+				parsed.Arguments.Get("tag"))
+		}
+	}
+}
+
+func TestContactListWithCommas(t *testing.T) {
+	h := make(Header)
+	h.Set("Contact", `"Smith, Alice" <sip:alice@example.com>, `+
+		`"Bob \"B\", Jr" <sip:bob@example.com>`)
+
+	users, err := ParseUsers(h, "Contact")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if len(users) != 2 || users[0].Name != "Smith, Alice" ||
+		users[1].Name != `Bob "B", Jr` {
+		t.Errorf("parsed %+v, expected two contacts", users)
+	}
+}
+
+func TestQuoteParam(t *testing.T) {
+	tests := []struct {
+		value  string
+		quoted string
+	}{
+		{"token", "token"},
+		{"[2001:db8::1]", "[2001:db8::1]"},
+		{"two words", `"two words"`},
+		{"a,b", `"a,b"`},
+	}
+
+	for _, test := range tests {
+		if quoted := QuoteParam(test.value); quoted != test.quoted {
+			t.Errorf("quoted %q as %s, expected %s", test.value, quoted, test.quoted)
+		}
+	}
+
+	if escaped := EscapeURIParam("a b;c"); escaped != "a%20b%3Bc" {
+		t.Errorf("escaped URI parameter as %s, expected a%%20b%%3Bc", escaped)
+	}
+	if value := UnescapeURIParam("a%20b%3Bc"); value != "a b;c" {
+		t.Errorf("unescaped URI parameter as %q, expected \"a b;c\"", value)
+	}
+}
@@ -0,0 +1,99 @@
+package sipnet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter keyed by source IP.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	clock Clock
+
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int, clock Clock) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   clock,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from the bucket of the source IP of addr, and returns
+// whether there was one.
+func (r *rateLimiter) allow(addr net.Addr) bool {
+	key := addr.String()
+	if host, _, err := net.SplitHostPort(key); err == nil {
+		key = host
+	}
+
+	now := r.clock.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	b, found := r.buckets[key]
+	if !found {
+		b = &bucket{tokens: r.burst, last: now}
+		r.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * r.rate
+	if b.tokens > r.burst {
+		b.tokens = r.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// expire removes the buckets which have refilled, as they are equivalent
+// to new buckets.
+func (r *rateLimiter) expire() {
+	now := r.clock.Now()
+
+	r.mutex.Lock()
+	for key, b := range r.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*r.rate >= r.burst {
+			delete(r.buckets, key)
+		}
+	}
+	r.mutex.Unlock()
+}
+
+// rateLimited returns whether a message or connection from addr exceeds
+// the configured RateLimit, counting it in Throttled if it does.
+func (l *Listener) rateLimited(addr net.Addr) bool {
+	if l.limiter == nil || addr == nil || l.limiter.allow(addr) {
+		return false
+	}
+
+	atomic.AddUint64(&l.throttled, 1)
+	return true
+}
+
+// Throttled returns the number of datagrams and TCP connections that have
+// been dropped because their source exceeded the configured RateLimit.
+func (l *Listener) Throttled() uint64 {
+	return atomic.LoadUint64(&l.throttled)
+}
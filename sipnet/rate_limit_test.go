@@ -0,0 +1,103 @@
+package sipnet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	limiter := newRateLimiter(2, 3, clock)
+	alice := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5060}
+	aliceOtherPort := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5070}
+	bob := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}
+
+	// The burst is shared by all ports of a source IP.
+	for i, addr := range []net.Addr{alice, aliceOtherPort, alice} {
+		if !limiter.allow(addr) {
+			t.Fatalf("message %d of the burst was throttled", i+1)
+		}
+	}
+	if limiter.allow(aliceOtherPort) {
+		t.Error("message past the burst was allowed")
+	}
+	if !limiter.allow(bob) {
+		t.Error("another source was throttled")
+	}
+
+	// Tokens refill at the rate.
+	clock.Advance(500 * time.Millisecond)
+	if !limiter.allow(alice) {
+		t.Error("message after refilling a token was throttled")
+	}
+	if limiter.allow(alice) {
+		t.Error("second message after refilling a token was allowed")
+	}
+
+	// Buckets which have refilled are expired.
+	clock.Advance(2 * time.Second)
+	limiter.expire()
+	if len(limiter.buckets) != 0 {
+		t.Errorf("%d buckets remain after refilling", len(limiter.buckets))
+	}
+}
+
+func TestListenerRateLimitUDP(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock, RateLimit: 1, RateBurst: 2})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	for _, branch := range []string{"z9hG4bK1", "z9hG4bK2", "z9hG4bK3", "z9hG4bK4"} {
+		sendUDP(t, peer, l, testRequest(MethodMessage, branch))
+	}
+
+	for i := 0; i < 2; i++ {
+		_, conn := acceptRequest(t, l)
+		conn.Unlock()
+	}
+	waitFor(t, "the excess requests to be throttled", func() bool {
+		return l.Throttled() == 2
+	})
+
+	// Once a token is refilled, the next request is accepted, and the
+	// throttled requests were dropped rather than queued.
+	clock.Advance(time.Second)
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bK5"))
+	req, _ := acceptRequest(t, l)
+	if via := req.Header.Get("Via"); via != "SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bK5" {
+		t.Errorf("accepted %q, expected the request after the refill", via)
+	}
+}
+
+func TestListenerRateLimitTCP(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	l := listenTest(t, Config{Clock: clock, RateLimit: 1})
+	defer l.Close()
+
+	first, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer first.Close()
+	first.Write([]byte(testRequest(MethodMessage, "z9hG4bK1")))
+	acceptRequest(t, l)
+
+	// The second connection exceeds the limit, and is closed.
+	second, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(testTimeout))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Error("throttled connection wasn't closed")
+	}
+	if throttled := l.Throttled(); throttled != 1 {
+		t.Errorf("throttled %d connections, expected 1", throttled)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -12,67 +13,305 @@ import (
 // received failed to be parsed.
 var ErrBadMessage = errors.New("sip: bad message")
 
+// ErrVersionNotSupported is returned by ReadRequest and ReadResponse along
+// with the parsed message if its SIP version is not SIPVersion.
+var ErrVersionNotSupported = errors.New("sip: version not supported")
+
+// maxErrorData is the maximum number of bytes of a message kept in a
+// MessageError.
+const maxErrorData = 512
+
+// MessageError is read from a Conn when a received message fails to be
+// parsed. The connection remains usable, as the next message can still be
+// read.
+type MessageError struct {
+	// Addr is the address the message was received from.
+	Addr net.Addr
+
+	// Data is the start of the raw message, truncated to 512 bytes. It is
+	// nil for messages received over stream transports.
+	Data []byte
+
+	// Err is the error returned by the parser.
+	Err error
+}
+
+func (e *MessageError) Error() string {
+	addr := "unknown address"
+	if e.Addr != nil {
+		addr = e.Addr.String()
+	}
+	return "sip: failed to parse message from " + addr + ": " + e.Err.Error()
+}
+
+func newMessageError(addr net.Addr, data []byte, err error) *MessageError {
+	if len(data) > maxErrorData {
+		data = data[:maxErrorData]
+	}
+
+	return &MessageError{
+		Addr: addr,
+		Data: append([]byte(nil), data...),
+		Err:  err,
+	}
+}
+
+// Parser parses SIP messages. The zero value is a strict parser.
+type Parser struct {
+	// Lenient relaxes the checks of the parser to accept messages from
+	// non-conformant UAs, such as extra whitespace in the start line,
+	// malformed header lines, lines ending with a bare LF rather than CRLF,
+	// and a missing Max-Forwards in requests.
+	// Deviations are recorded in the message's Warnings rather than
+	// rejecting the message.
+	Lenient bool
+
+	// Validate checks the mandatory headers of each message after it is
+	// parsed. A message which fails validation is returned along with a
+	// *HeaderError.
+	Validate bool
+
+	// StreamBodyThreshold is the size in bytes above which the body of a
+	// request is streamed through the request's BodyReader rather than
+	// buffered in Body. A streamed body is not decoded. If zero, bodies are
+	// always buffered.
+	StreamBodyThreshold int
+
+	// MaxDecodedBodySize is the maximum size in bytes of a body once its
+	// Content-Encoding is decoded, so a small compressed body can't expand
+	// without bound. A larger body is returned still encoded, along with
+	// ErrBodyTooLarge. If zero, DefaultMaxDecodedBodySize is used, and if
+	// negative, there is no limit.
+	MaxDecodedBodySize int
+
+	// MaxRouteHeaders is the maximum number of Via, Route and Record-Route
+	// values accepted in a message, each counted separately. Further values
+	// are discarded while parsing, and the message is returned with a
+	// *HeaderError. If zero, DefaultMaxRouteHeaders is used, and if
+	// negative, there is no limit.
+	MaxRouteHeaders int
+
+	// PreserveHeaders are the names of headers (case insensitive) whose
+	// lines are kept verbatim in the message's RawHeaders, and re-emitted
+	// byte for byte, such as for signed headers.
+	PreserveHeaders []string
+
+	// PreserveUnknownHeaders also keeps the lines of headers the library has
+	// no support for verbatim in RawHeaders, such as X- headers, so they are
+	// forwarded exactly as received. Unknown headers are always available in
+	// Header regardless.
+	PreserveUnknownHeaders bool
+}
+
+// knownHeaders are the headers the library interprets, keyed by normalized
+// key.
+var knownHeaders = make(map[string]bool)
+
+func init() {
+	for _, key := range []string{
+		"Accept", "Accept-Contact", "Accept-Encoding", "Accept-Language",
+		"Alert-Info", "Allow", "Allow-Events", "Authentication-Info",
+		"Authorization", "Call-ID", "Call-Info", "Contact",
+		"Content-Encoding", "Content-Length", "Content-Type", "CSeq",
+		"Date", "Event", "Expires", "Feature-Caps", "From", "Identity",
+		"In-Reply-To", "Join", "Max-Forwards", "Organization",
+		"P-Asserted-Identity", "P-Preferred-Identity", "Path", "Priority",
+		"Privacy", "Proxy-Authenticate", "Proxy-Authorization",
+		"Proxy-Require", "RAck", "Reason-Phrase", "Record-Route",
+		"Refer-Sub", "Reject-Contact", "Replaces", "Request-Disposition",
+		"Require", "Retry-After", "Route", "RSeq", "Service-Route",
+		"Session-Expires", "Subject", "Supported", "To", "Unsupported",
+		"Via", "WWW-Authenticate",
+	} {
+		knownHeaders[normalizeKey(key)] = true
+	}
+}
+
+// preserved returns whether a header key is configured to be preserved.
+func (p *Parser) preserved(key string) bool {
+	if p.PreserveUnknownHeaders && !knownHeaders[key] {
+		return true
+	}
+
+	for _, name := range p.PreserveHeaders {
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultMaxRouteHeaders is the default maximum number of Via, Route and
+// Record-Route values accepted in a message.
+const DefaultMaxRouteHeaders = 70
+
+// routeHeaders are the headers limited by MaxRouteHeaders.
+var routeHeaders = map[string]bool{
+	"Via":          true,
+	"Route":        true,
+	"Record-Route": true,
+}
+
+func (p *Parser) maxRouteHeaders() int {
+	if p.MaxRouteHeaders == 0 {
+		return DefaultMaxRouteHeaders
+	}
+	return p.MaxRouteHeaders
+}
+
+func (p *Parser) maxDecodedBodySize() int {
+	if p.MaxDecodedBodySize == 0 {
+		return DefaultMaxDecodedBodySize
+	}
+	return p.MaxDecodedBodySize
+}
+
+// check returns the error of a parsed message, which is
+// ErrVersionNotSupported if its version is not SIPVersion, tooMany if a
+// header exceeded its limit, or otherwise the result of validate if
+// validation is enabled.
+func (p *Parser) check(version string, tooMany error, validate func() error) error {
+	if !strings.EqualFold(version, SIPVersion) {
+		return ErrVersionNotSupported
+	}
+
+	if tooMany != nil {
+		return tooMany
+	}
+
+	if p.Validate {
+		return validate()
+	}
+
+	return nil
+}
+
+var defaultParser = &Parser{}
+
 // ReadRequest reads a SIP request (i.e. message from a UAC) from a reader.
+// A gzip encoded body is transparently decoded.
 func ReadRequest(rd io.Reader) (*Request, error) {
-	buf := bufio.NewReader(rd)
-	line, err := buf.ReadString('\n')
-	if err != nil {
-		return nil, err
+	return defaultParser.ReadRequest(rd)
+}
+
+// ReadResponse reads a SIP response (i.e. message from a UAS) from a reader.
+// A gzip encoded body is transparently decoded.
+func ReadResponse(rd io.Reader) (*Response, error) {
+	return defaultParser.ReadResponse(rd)
+}
+
+// ReadRequestBuffered reads a SIP request from a buffered reader like
+// ReadRequest. Data following the message remains buffered in buf, so
+// sequential messages can be read from it.
+func ReadRequestBuffered(buf *bufio.Reader) (*Request, error) {
+	return defaultParser.ReadRequestBuffered(buf)
+}
+
+// ReadResponseBuffered reads a SIP response from a buffered reader like
+// ReadResponse. Data following the message remains buffered in buf, so
+// sequential messages can be read from it.
+func ReadResponseBuffered(buf *bufio.Reader) (*Response, error) {
+	return defaultParser.ReadResponseBuffered(buf)
+}
+
+// buffered returns rd as a *bufio.Reader, wrapping it only if it isn't one.
+func buffered(rd io.Reader) *bufio.Reader {
+	if buf, ok := rd.(*bufio.Reader); ok {
+		return buf
 	}
 
-	if line[len(line)-2:] != "\r\n" {
-		return nil, ErrBadMessage
+	return bufio.NewReader(rd)
+}
+
+// ReadRequest reads a SIP request (i.e. message from a UAC) from a reader.
+// A gzip encoded body is transparently decoded. If rd is a *bufio.Reader, it
+// is used directly, so data following the message remains buffered in it.
+// Otherwise, data read past the end of the message is lost.
+func (p *Parser) ReadRequest(rd io.Reader) (*Request, error) {
+	return p.ReadRequestBuffered(buffered(rd))
+}
+
+// ReadRequestBuffered reads a SIP request from a buffered reader like
+// ReadRequest, leaving data following the message buffered in buf.
+// ErrBadMessage is returned without a request if the request line is
+// malformed, such as with a missing or unparsable Request-URI, a method
+// which isn't a token, or a malformed version.
+func (p *Parser) ReadRequestBuffered(buf *bufio.Reader) (*Request, error) {
+	r := NewRequest()
+
+	args, err := p.readStartLine(buf, &r.Warnings)
+	if err != nil {
+		return nil, err
 	}
 
-	args := strings.Split(line, " ")
-	if len(args) != 3 {
+	if !isRequestLine(args) {
 		return nil, ErrBadMessage
 	}
 
-	r := NewRequest()
 	r.Method = args[0]
 	r.Server = args[1]
-	r.SIPVersion = args[2][:len(args[2])-2]
+	r.SIPVersion = args[2]
 
-	err = parseHeader(buf, r.Header)
+	tooMany, err := p.parseHeader(buf, r.Header, &r.Warnings, &r.RawHeaders)
 	if err != nil {
 		return nil, err
 	}
 
-	length, err := strconv.Atoi(r.Header.Get("Content-Length"))
+	if p.Lenient && r.Header.Get("Max-Forwards") == "" {
+		r.Warnings = append(r.Warnings, "missing Max-Forwards")
+	}
+
+	length, ok, err := contentLength(r.Header)
 	if err != nil {
-		return r, nil
+		return r, err
+	} else if !ok {
+		return r, p.check(r.SIPVersion, tooMany, r.Validate)
 	}
 
-	body := make([]byte, length)
-	_, err = buf.Read(body)
+	if p.StreamBodyThreshold > 0 && length > p.StreamBodyThreshold {
+		r.BodyReader = newBodyReader(buf, length)
+		return r, p.check(r.SIPVersion, tooMany, r.Validate)
+	}
+
+	body, err := readBody(buf, length)
 	if err != nil {
 		return r, err
 	}
 
-	r.Body = body
+	r.Body, err = decodeBody(r.Header, body, p.maxDecodedBodySize())
+	if err != nil {
+		return r, err
+	}
 
-	return r, nil
+	return r, p.check(r.SIPVersion, tooMany, r.Validate)
 }
 
 // ReadResponse reads a SIP response (i.e. message from a UAS) from a reader.
-func ReadResponse(rd io.Reader) (*Response, error) {
-	buf := bufio.NewReader(rd)
-	line, err := buf.ReadString('\n')
+// A gzip encoded body is transparently decoded. If rd is a *bufio.Reader, it
+// is used directly, so data following the message remains buffered in it.
+// Otherwise, data read past the end of the message is lost.
+func (p *Parser) ReadResponse(rd io.Reader) (*Response, error) {
+	return p.ReadResponseBuffered(buffered(rd))
+}
+
+// ReadResponseBuffered reads a SIP response from a buffered reader like
+// ReadResponse, leaving data following the message buffered in buf.
+func (p *Parser) ReadResponseBuffered(buf *bufio.Reader) (*Response, error) {
+	r := NewResponse()
+
+	args, err := p.readStartLine(buf, &r.Warnings)
 	if err != nil {
 		return nil, err
 	}
 
-	if line[len(line)-2:] != "\r\n" {
+	if len(args) < 3 {
 		return nil, ErrBadMessage
 	}
 
-	args := strings.Split(line, " ")
-	if len(args) < 3 {
+	if !isVersion(args[0]) {
 		return nil, ErrBadMessage
 	}
 
-	r := NewResponse()
 	r.SIPVersion = args[0]
 	r.StatusCode, err = strconv.Atoi(args[1])
 	if err != nil {
@@ -81,45 +320,232 @@ func ReadResponse(rd io.Reader) (*Response, error) {
 
 	r.Status = StatusText(r.StatusCode)
 
-	err = parseHeader(buf, r.Header)
+	tooMany, err := p.parseHeader(buf, r.Header, &r.Warnings, &r.RawHeaders)
 	if err != nil {
 		return nil, err
 	}
 
-	length, err := strconv.Atoi(r.Header.Get("Content-Length"))
+	length, ok, err := contentLength(r.Header)
 	if err != nil {
-		return r, nil
+		return r, err
+	} else if !ok {
+		return r, p.check(r.SIPVersion, tooMany, r.Validate)
 	}
 
-	body := make([]byte, length)
-	_, err = buf.Read(body)
+	body, err := readBody(buf, length)
+	if err != nil {
+		return r, err
+	}
+
+	r.Body, err = decodeBody(r.Header, body, p.maxDecodedBodySize())
 	if err != nil {
 		return r, err
 	}
 
-	r.Body = body
+	return r, p.check(r.SIPVersion, tooMany, r.Validate)
+}
+
+// contentLength returns the Content-Length of a message, and whether it has
+// one. ErrContentLength is returned if it is malformed or negative, or if
+// it has multiple values which disagree, as the message can't be framed.
+func contentLength(h Header) (int, bool, error) {
+	values := h.Values("Content-Length")
+	if len(values) == 0 {
+		return 0, false, nil
+	}
+
+	length := -1
+	for _, value := range values {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 || (length >= 0 && n != length) {
+			return 0, false, ErrContentLength
+		}
+		length = n
+	}
+
+	return length, true, nil
+}
+
+// readBody reads a body of the given length. ErrContentLength is returned
+// if the data ends before the body does.
+func readBody(buf *bufio.Reader, length int) ([]byte, error) {
+	body := make([]byte, length)
+	_, err := io.ReadFull(buf, body)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrContentLength
+	}
+	return body, err
+}
+
+// isVersion returns whether a version is a well formed SIP version, i.e.
+// "SIP/2.0".
+func isVersion(version string) bool {
+	if len(version) < 4 || !strings.EqualFold(version[:4], "SIP/") {
+		return false
+	}
+
+	parts := strings.Split(version[4:], ".")
+	if len(parts) != 2 {
+		return false
+	}
+
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isRequestLine returns whether the fields of a start line form a well
+// formed request line (RFC 3261 section 7.1), i.e. a method token, a
+// parsable Request-URI and a SIP version, separated by single spaces.
+func isRequestLine(args []string) bool {
+	if len(args) != 3 || !isToken(args[0], "") || !isVersion(args[2]) {
+		return false
+	}
+
+	_, err := ParseURI(args[1])
+	return err == nil
+}
+
+// maxLeadingEmptyLines is the number of empty lines accepted before the
+// start line of a message.
+const maxLeadingEmptyLines = 32
+
+// readStartLine reads the start line of a message and returns its space
+// separated fields, with the line ending removed. Empty lines before the
+// start line are discarded (RFC 3261 section 7.5), up to
+// maxLeadingEmptyLines of them, and in lenient mode, so are lines of only
+// whitespace.
+func (p *Parser) readStartLine(buf *bufio.Reader, warnings *[]string) ([]string, error) {
+	var line string
+	for skipped := 0; ; skipped++ {
+		read, err := buf.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line, err = p.trimLineEnding(read, warnings)
+		if err != nil {
+			return nil, err
+		}
+
+		empty := line == ""
+		if p.Lenient && strings.TrimSpace(line) == "" {
+			empty = true
+		}
+
+		if !empty {
+			break
+		}
+
+		if skipped == maxLeadingEmptyLines {
+			return nil, ErrBadMessage
+		}
+	}
+
+	args := strings.Split(line, " ")
+	if !p.Lenient {
+		return args, nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != len(args) {
+		*warnings = append(*warnings, "extra whitespace in start line")
+	}
+
+	if len(fields) > 3 && strings.HasPrefix(fields[0], "SIP/") {
+		// The reason phrase of a status line may contain spaces.
+		return append(fields[:2], strings.Join(fields[2:], " ")), nil
+	}
 
-	return r, nil
+	return fields, nil
 }
 
-func parseHeader(buf *bufio.Reader, h Header) error {
+// bareLFWarning is the warning recorded for lines ending with a bare LF.
+const bareLFWarning = "bare LF line ending"
+
+// trimLineEnding returns a line read from a message without its CRLF line
+// ending. A bare LF is only accepted in lenient mode, and is recorded in
+// warnings once per message.
+func (p *Parser) trimLineEnding(line string, warnings *[]string) (string, error) {
+	if strings.HasSuffix(line, "\r\n") {
+		return line[:len(line)-2], nil
+	}
+
+	if !p.Lenient || !strings.HasSuffix(line, "\n") {
+		return "", ErrBadMessage
+	}
+
+	found := false
+	for _, warning := range *warnings {
+		if warning == bareLFWarning {
+			found = true
+			break
+		}
+	}
+	if !found {
+		*warnings = append(*warnings, bareLFWarning)
+	}
+
+	return line[:len(line)-1], nil
+}
+
+// parseHeader parses the header of a message into h. If a header exceeds
+// its limit, its further values are discarded, and a *HeaderError is
+// returned as tooMany.
+func (p *Parser) parseHeader(buf *bufio.Reader, h Header,
+	warnings *[]string, raw *[]string) (tooMany error, err error) {
+	counts := make(map[string]int)
 	for {
 		line, err := buf.ReadString('\n')
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if line == "\r\n" {
-			return nil
+		line, err = p.trimLineEnding(line, warnings)
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "" {
+			return tooMany, nil
 		}
 
 		keyPosition := strings.Index(line, ":")
 		if keyPosition == -1 {
-			return ErrBadMessage
+			if p.Lenient {
+				*warnings = append(*warnings, "malformed header line: "+
+					strings.TrimSpace(line))
+				continue
+			}
+
+			return nil, ErrBadMessage
 		}
 
 		key := normalizeKey(strings.TrimSpace(line[:keyPosition]))
 		value := strings.TrimSpace(line[keyPosition+1:])
-		h.Set(key, value)
+
+		if p.preserved(key) {
+			*raw = append(*raw, line)
+		}
+
+		if routeHeaders[key] && p.maxRouteHeaders() > 0 {
+			counts[key] += strings.Count(value, ",") + 1
+			if counts[key] > p.maxRouteHeaders() {
+				if tooMany == nil {
+					tooMany = &HeaderError{Key: key, TooMany: true}
+				}
+				continue
+			}
+		}
+
+		h.Add(key, value)
 	}
 }
@@ -0,0 +1,351 @@
+package sipnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// malformedInvite has extra whitespace in its request line, a header line
+// without a colon, lower case header names and no Max-Forwards.
+const malformedInvite = "INVITE  sip:bob@127.0.0.1 SIP/2.0\r\n" +
+	"via: SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKlenient\r\n" +
+	"from: <sip:alice@127.0.0.1>;tag=a1\r\n" +
+	"to: <sip:bob@127.0.0.1>\r\n" +
+	"call-id: lenient@127.0.0.1\r\n" +
+	"cseq: 1 INVITE\r\n" +
+	"this line is malformed\r\n" +
+	"content-length: 0\r\n\r\n"
+
+func TestParseMalformedInviteStrict(t *testing.T) {
+	_, err := ReadRequest(strings.NewReader(malformedInvite))
+	if err != ErrBadMessage {
+		t.Errorf("parsed with %v, expected ErrBadMessage", err)
+	}
+}
+
+func TestParseMalformedInviteLenient(t *testing.T) {
+	parser := &Parser{Lenient: true}
+	req, err := parser.ReadRequest(strings.NewReader(malformedInvite))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	if req.Method != MethodInvite || req.Server != "sip:bob@127.0.0.1" {
+		t.Errorf("parsed request line %q %q", req.Method, req.Server)
+	}
+	if callID := req.Header.Get("Call-ID"); callID != "lenient@127.0.0.1" {
+		t.Errorf("Call-ID is %q, expected lenient@127.0.0.1", callID)
+	}
+
+	expected := []string{
+		"extra whitespace in start line",
+		"malformed header line: this line is malformed",
+		"missing Max-Forwards",
+	}
+	if len(req.Warnings) != len(expected) {
+		t.Fatalf("warnings are %q, expected %q", req.Warnings, expected)
+	}
+	for i, warning := range expected {
+		if req.Warnings[i] != warning {
+			t.Errorf("warning %d is %q, expected %q", i, req.Warnings[i], warning)
+		}
+	}
+}
+
+func TestMessageErrorCarriesSource(t *testing.T) {
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	garbage := append([]byte("SIP/2.0 abc\r\n"), bytes.Repeat([]byte("x"), 1000)...)
+	if _, err := peer.WriteTo(garbage, conn.LocalAddr()); err != nil {
+		t.Fatalf("failed to send datagram: %v", err)
+	}
+
+	_, err = conn.ReadTimeout(testTimeout)
+	msgErr, ok := err.(*MessageError)
+	if !ok {
+		t.Fatalf("read error %v, expected a *MessageError", err)
+	}
+	if msgErr.Addr.String() != peer.LocalAddr().String() {
+		t.Errorf("error has address %v, expected %v", msgErr.Addr,
+			peer.LocalAddr())
+	}
+	if !bytes.Equal(msgErr.Data, garbage[:maxErrorData]) {
+		t.Errorf("error has %d bytes of data, expected the first %d of the datagram",
+			len(msgErr.Data), maxErrorData)
+	}
+	if !strings.Contains(msgErr.Error(), peer.LocalAddr().String()) {
+		t.Errorf("error %q doesn't mention the address", msgErr)
+	}
+
+	// The connection remains usable after a parse error.
+	req := parseRequest(t, testRequest(MethodOptions, "z9hG4bK1"))
+	peer.WriteTo([]byte(testResponse(req, "200 OK")), conn.LocalAddr())
+	if resp, err := conn.ReadTimeout(testTimeout); err != nil {
+		t.Errorf("failed to read after a parse error: %v", err)
+	} else if _, ok := resp.(*Response); !ok {
+		t.Errorf("read %T, expected a response", resp)
+	}
+}
+
+func TestStreamErrorClosesConn(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+
+	writePipe(t, remote, "SIP/2.0 200 OK\r\nVia: SIP/2.0/TCP 127.0.0.1")
+	remote.Close()
+
+	waitFor(t, "the conn to close after its stream ended mid-message",
+		func() bool { return conn.Closed })
+}
+
+// viaStack returns n Via header lines.
+func viaStack(n int) []string {
+	vias := make([]string, n)
+	for i := range vias {
+		vias[i] = "Via: SIP/2.0/UDP 192.0.2." + strconv.Itoa(i%250+1) +
+			";branch=z9hG4bKstack" + strconv.Itoa(i)
+	}
+	return vias
+}
+
+func TestOversizedViaStack(t *testing.T) {
+	msg := testRequest(MethodOptions, "z9hG4bK1", viaStack(5000)...)
+	req, err := ReadRequest(strings.NewReader(msg))
+	headerErr, ok := err.(*HeaderError)
+	if !ok || headerErr.Key != "Via" || !headerErr.TooMany {
+		t.Fatalf("parsed with error %v, expected too many Via headers", err)
+	}
+	if vias := len(req.Header.Values("Via")); vias > DefaultMaxRouteHeaders {
+		t.Errorf("kept %d Via values, expected at most %d", vias,
+			DefaultMaxRouteHeaders)
+	}
+
+	parser := &Parser{MaxRouteHeaders: -1}
+	req, err = parser.ReadRequest(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("failed to parse without a limit: %v", err)
+	}
+	if vias := len(req.Header.Values("Via")); vias != 5001 {
+		t.Errorf("kept %d Via values, expected all 5001", vias)
+	}
+}
+
+func TestOversizedViaStackRejected(t *testing.T) {
+	l := listenTest(t, Config{Parser: Parser{MaxRouteHeaders: 10}})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodOptions, "z9hG4bK1", viaStack(20)...))
+	data, _ := readUDP(t, peer)
+	if startLine(data) != "SIP/2.0 400 Bad Request" {
+		t.Errorf("got %q, expected a 400", startLine(data))
+	}
+}
+
+func TestReadUnsupportedVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected error
+	}{
+		{"SIP/2.0", nil},
+		{"sip/2.0", nil},
+		{"SIP/1.0", ErrVersionNotSupported},
+		{"SIP/3.1", ErrVersionNotSupported},
+		{"SIP/2", ErrBadMessage},
+		{"SIP/2.x", ErrBadMessage},
+		{"HTTP/1.1", ErrBadMessage},
+		{"garbage", ErrBadMessage},
+	}
+
+	for _, test := range tests {
+		msg := strings.Replace(testRequest(MethodMessage, "z9hG4bKversion"),
+			" SIP/2.0\r\n", " "+test.version+"\r\n", 1)
+		if _, err := ReadRequest(strings.NewReader(msg)); err != test.expected {
+			t.Errorf("request with %q: got %v, expected %v", test.version,
+				err, test.expected)
+		}
+
+		msg = test.version + " 200 OK\r\n" +
+			"Via: SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKversion\r\n" +
+			"Content-Length: 0\r\n\r\n"
+		if _, err := ReadResponse(strings.NewReader(msg)); err != test.expected {
+			t.Errorf("response with %q: got %v, expected %v", test.version,
+				err, test.expected)
+		}
+	}
+}
+
+func TestUnsupportedVersionRejected(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, strings.Replace(
+		testRequest(MethodMessage, "z9hG4bKold"), " SIP/2.0\r\n", " SIP/1.0\r\n", 1))
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 505 Version Not Supported" {
+		t.Errorf("answered with %q, expected a 505", startLine(data))
+	}
+
+	// An ACK is not answered.
+	sendUDP(t, peer, l, strings.Replace(
+		testRequest(MethodAck, "z9hG4bKoldack"), " SIP/2.0\r\n", " SIP/1.0\r\n", 1))
+	expectNoUDP(t, peer)
+}
+
+func TestParseBareLF(t *testing.T) {
+	msg := strings.Replace(testRequest(MethodMessage, "z9hG4bKbarelf"),
+		"\r\n", "\n", -1)
+	msg = strings.Replace(msg, "Content-Length: 0\n\n",
+		"Content-Length: 5\n\nhello", 1)
+
+	if _, err := ReadRequest(strings.NewReader(msg)); err != ErrBadMessage {
+		t.Errorf("strict parse returned %v, expected ErrBadMessage", err)
+	}
+
+	parser := &Parser{Lenient: true}
+	req, err := parser.ReadRequest(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("lenient parse failed: %v", err)
+	}
+
+	if req.Method != MethodMessage || req.Server != "sip:bob@127.0.0.1" {
+		t.Errorf("parsed request line %q %q", req.Method, req.Server)
+	}
+	if callID := req.Header.Get("Call-ID"); callID != "call1@127.0.0.1" {
+		t.Errorf("Call-ID is %q, expected call1@127.0.0.1", callID)
+	}
+	if string(req.Body) != "hello" {
+		t.Errorf("body is %q, expected \"hello\"", req.Body)
+	}
+	if len(req.Warnings) != 1 || req.Warnings[0] != bareLFWarning {
+		t.Errorf("warnings are %q, expected a single %q", req.Warnings,
+			bareLFWarning)
+	}
+}
+
+func TestReadSequentialBuffered(t *testing.T) {
+	message := strings.Replace(testRequest(MethodMessage, "z9hG4bKseq1"),
+		"Content-Length: 0\r\n\r\n", "Content-Length: 5\r\n\r\nhello", 1)
+	req := parseRequest(t, message)
+	stream := message + testResponse(req, "200 OK") +
+		testRequest(MethodOptions, "z9hG4bKseq2")
+
+	// The bufio.Reader is shared, so the data read ahead of each message
+	// must remain buffered for the next.
+	buf := bufio.NewReaderSize(strings.NewReader(stream), 16)
+	first, err := ReadRequestBuffered(buf)
+	if err != nil {
+		t.Fatalf("failed to read the first request: %v", err)
+	}
+	if first.Method != MethodMessage || string(first.Body) != "hello" {
+		t.Errorf("read %s with body %q, expected MESSAGE with \"hello\"",
+			first.Method, first.Body)
+	}
+
+	resp, err := ReadResponseBuffered(buf)
+	if err != nil {
+		t.Fatalf("failed to read the response: %v", err)
+	}
+	if resp.StatusCode != StatusOK {
+		t.Errorf("read a %d, expected a 200", resp.StatusCode)
+	}
+
+	last, err := ReadRequest(buf)
+	if err != nil {
+		t.Fatalf("failed to read the last request: %v", err)
+	}
+	if last.Method != MethodOptions {
+		t.Errorf("read %s, expected OPTIONS", last.Method)
+	}
+
+	if _, err := ReadRequestBuffered(buf); err != io.EOF {
+		t.Errorf("read past the last message returned %v, expected io.EOF", err)
+	}
+}
+
+func TestLeadingEmptyLines(t *testing.T) {
+	msg := testRequest(MethodMessage, "z9hG4bKleading")
+
+	req, err := ReadRequest(strings.NewReader("\r\n\r\n\r\n" + msg))
+	if err != nil {
+		t.Fatalf("failed to parse a request after empty lines: %v", err)
+	}
+	if req.Method != MethodMessage || req.Server != "sip:bob@127.0.0.1" {
+		t.Errorf("parsed request line %q %q", req.Method, req.Server)
+	}
+
+	resp, err := ReadResponse(strings.NewReader("\r\n\r\n" +
+		testResponse(req, "200 OK")))
+	if err != nil {
+		t.Fatalf("failed to parse a response after empty lines: %v", err)
+	}
+	if resp.StatusCode != StatusOK {
+		t.Errorf("parsed a %d, expected a 200", resp.StatusCode)
+	}
+
+	// Lines of whitespace are only skipped in lenient mode.
+	spaced := " \r\n\t\r\n" + msg
+	if _, err := ReadRequest(strings.NewReader(spaced)); err == nil {
+		t.Error("strict parse of whitespace lines succeeded")
+	}
+	parser := &Parser{Lenient: true}
+	if _, err := parser.ReadRequest(strings.NewReader(spaced)); err != nil {
+		t.Errorf("lenient parse of whitespace lines failed: %v", err)
+	}
+
+	// The number of empty lines is bounded.
+	flood := strings.Repeat("\r\n", maxLeadingEmptyLines+1) + msg
+	if _, err := ReadRequest(strings.NewReader(flood)); err != ErrBadMessage {
+		t.Errorf("parsing %d empty lines returned %v, expected ErrBadMessage",
+			maxLeadingEmptyLines+1, err)
+	}
+	allowed := strings.Repeat("\r\n", maxLeadingEmptyLines) + msg
+	if _, err := ReadRequest(strings.NewReader(allowed)); err != nil {
+		t.Errorf("parsing %d empty lines failed: %v", maxLeadingEmptyLines, err)
+	}
+}
+
+func TestMalformedRequestLine(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+	}{
+		{"INVITE sip:bob@127.0.0.1 SIP/2.0", true},
+		{"INVITE SIP/2.0", false},
+		{"INVITE  sip:bob@127.0.0.1 SIP/2.0", false},
+		{"INVITE sip:bob@127.0.0.1  SIP/2.0", false},
+		{"INVITE sip:bob@127.0.0.1 SIP/2", false},
+		{"INVITE sip:bob@127.0.0.1 SIP/two", false},
+		{"INV@TE sip:bob@127.0.0.1 SIP/2.0", false},
+		{"INVITE sip: SIP/2.0", false},
+	}
+
+	for _, test := range tests {
+		msg := strings.Replace(testRequest(MethodInvite, "z9hG4bKline"),
+			"INVITE sip:bob@127.0.0.1 SIP/2.0", test.line, 1)
+		req, err := ReadRequest(strings.NewReader(msg))
+		if test.ok {
+			if err != nil {
+				t.Errorf("%q: failed to parse: %v", test.line, err)
+			}
+			continue
+		}
+		if req != nil || err != ErrBadMessage {
+			t.Errorf("%q: parsed %v with %v, expected ErrBadMessage",
+				test.line, req, err)
+		}
+	}
+}
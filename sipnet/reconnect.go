@@ -0,0 +1,65 @@
+package sipnet
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotRedialable is returned by Conn.Redial if the connection was not
+// dialed, as only the UA which opened a connection can open it again.
+var ErrNotRedialable = errors.New("sip: connection cannot be redialed")
+
+// Redial returns a new Conn to the peer of a dialed connection over the
+// same transport, such as to replace a stream connection which dropped. It
+// belongs to the same listener, and is locked like the Conn returned by
+// DialConn.
+func (c *Conn) Redial() (*Conn, error) {
+	if !c.dialed {
+		return nil, ErrNotRedialable
+	}
+
+	if c.Listener != nil {
+		return c.Listener.DialConn(c.Address.String(), c.protocol().Name())
+	}
+
+	return DialConn(c.Address.String(), c.protocol().Name())
+}
+
+// isDropped returns whether an error returned by Do means the connection
+// dropped before the final response was received.
+func isDropped(conn *Conn, err error) bool {
+	return conn.protocol().IsStream() && (err == io.EOF || conn.Closed)
+}
+
+// DoRedialing sends a request like Do, but if the connection is a stream
+// connection which drops before the final response is received, a new
+// connection is dialed with Redial and the request is sent again over it,
+// at most maxRedials times. The Conn the final response was received over
+// is returned, which replaces c if it was redialed.
+//
+// Each time the request is sent again, its top Via is replaced with a new
+// Via of the new connection, which modifies req. The Vias below it, such
+// as of the upstream hops of a forwarded request, are kept.
+func (c *Conn) DoRedialing(req *Request, maxRedials int) (*Response, *Conn, error) {
+	conn := c
+	for redials := 0; ; redials++ {
+		resp, err := conn.Do(req)
+		if err == nil || !isDropped(conn, err) || redials >= maxRedials {
+			return resp, conn, err
+		}
+
+		next, dialErr := conn.Redial()
+		if dialErr != nil {
+			return nil, conn, err
+		}
+
+		conn = next
+		vias := splitVias(req.Header)
+		req.Header.Set("Via", conn.NewVia().String())
+		if len(vias) > 0 {
+			for _, via := range vias[1:] {
+				req.Header.Add("Via", via)
+			}
+		}
+	}
+}
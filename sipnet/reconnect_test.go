@@ -0,0 +1,135 @@
+package sipnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// redialResult is the result of Conn.DoRedialing.
+type redialResult struct {
+	resp *Response
+	conn *Conn
+	err  error
+}
+
+// goDoRedialing sends a request with Conn.DoRedialing from a goroutine of
+// its own, returning a channel receiving its result.
+func goDoRedialing(conn *Conn, req *Request, maxRedials int) <-chan redialResult {
+	results := make(chan redialResult, 1)
+	go func() {
+		resp, conn, err := conn.DoRedialing(req, maxRedials)
+		results <- redialResult{resp, conn, err}
+	}()
+	return results
+}
+
+// acceptTCPRequest accepts the next connection of a TCP server and reads a
+// request from it.
+func acceptTCPRequest(t *testing.T, server net.Listener) (net.Conn, *Request) {
+	t.Helper()
+
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	peer.SetDeadline(time.Now().Add(testTimeout))
+
+	req, err := ReadRequest(peer)
+	if err != nil {
+		t.Fatalf("failed to read request: %v", err)
+	}
+	return peer, req
+}
+
+func TestDoRedialingResends(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer server.Close()
+
+	conn, err := l.DialConn(server.Addr().String(), "tcp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	req := newTestRequest(MethodOptions, "sip:bob@"+server.Addr().String())
+	results := goDoRedialing(conn, req, 1)
+
+	// The connection drops after the request is sent.
+	dropped, first := acceptTCPRequest(t, server)
+	dropped.Close()
+
+	peer, resent := acceptTCPRequest(t, server)
+	defer peer.Close()
+	if resent.Header.Get("Call-ID") != first.Header.Get("Call-ID") ||
+		resent.Header.Get("CSeq") != first.Header.Get("CSeq") {
+		t.Errorf("resent %s with CSeq %q, expected the same request",
+			resent.Header.Get("Call-ID"), resent.Header.Get("CSeq"))
+	}
+	if TransactionKey(resent) == TransactionKey(first) {
+		t.Error("the request was resent with the Via of the dropped connection")
+	}
+	peer.Write([]byte(testResponse(resent, "200 OK")))
+
+	select {
+	case result := <-results:
+		if result.err != nil || result.resp.StatusCode != StatusOK {
+			t.Fatalf("returned %v, %v, expected the 200", result.resp,
+				result.err)
+		}
+		if result.conn == conn {
+			t.Error("returned the dropped connection")
+		}
+		result.conn.Close()
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the final response")
+	}
+}
+
+func TestDoRedialingLimit(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer server.Close()
+
+	conn, err := l.DialConn(server.Addr().String(), "tcp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	req := newTestRequest(MethodOptions, "sip:bob@"+server.Addr().String())
+	results := goDoRedialing(conn, req, 0)
+
+	dropped, _ := acceptTCPRequest(t, server)
+	dropped.Close()
+
+	select {
+	case result := <-results:
+		if result.err != io.EOF || result.conn != conn {
+			t.Errorf("returned %v over %p, expected io.EOF over the dropped "+
+				"connection", result.err, result.conn)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the dropped connection wasn't noticed")
+	}
+}
+
+func TestRedialAcceptedConn(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKredial"))
+	_, conn := acceptRequest(t, l)
+	if _, err := conn.Redial(); err != ErrNotRedialable {
+		t.Errorf("redialing an accepted conn returned %v, expected "+
+			"ErrNotRedialable", err)
+	}
+}
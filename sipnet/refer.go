@@ -0,0 +1,47 @@
+package sipnet
+
+import "strings"
+
+// ImplicitSubscription returns whether a REFER request creates an implicit
+// subscription to the progress of the referral. The referrer can opt out of
+// the subscription with "Refer-Sub: false", as defined in RFC 4488.
+func ImplicitSubscription(r *Request) bool {
+	return strings.ToLower(strings.TrimSpace(r.Header.Get("Refer-Sub"))) != "false"
+}
+
+// AcceptRefer responds to a REFER request with a StatusAccepted, and returns
+// whether the implicit subscription was created, in which case the progress
+// of the referral should be reported with sipfrag NOTIFY requests. If the
+// referrer opted out of the subscription, "Refer-Sub: false" is included in
+// the response to confirm no NOTIFY requests will be sent.
+func (r *Response) AcceptRefer(conn *Conn, req *Request) (bool, error) {
+	subscribed := ImplicitSubscription(req)
+
+	r.StatusCode = StatusAccepted
+	r.Header.Set("Supported", "norefersub")
+	if !subscribed {
+		r.Header.Set("Refer-Sub", "false")
+	}
+
+	return subscribed, r.WriteTo(conn, req)
+}
+
+// ReferTarget returns the target of a REFER request from its Refer-To, and
+// the headers embedded in the Refer-To URI, such as a Replaces or Require,
+// which are to be added to the INVITE sent to the target. The returned
+// target has no embedded headers.
+func ReferTarget(r *Request) (URI, Header, error) {
+	referTo, err := ParseUser(r.Header.Get("Refer-To"))
+	if err != nil {
+		return URI{}, nil, err
+	}
+
+	headers := referTo.URI.Headers
+	if headers == nil {
+		headers = make(Header)
+	}
+
+	target := referTo.URI
+	target.Headers = nil
+	return target, headers, nil
+}
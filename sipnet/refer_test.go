@@ -0,0 +1,107 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAcceptReferWithoutSubscription(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	writePipe(t, remote, testRequest(MethodRefer, "z9hG4bKrefer",
+		"Refer-To: <sip:carol@127.0.0.1>", "Refer-Sub: false"))
+	req := readRequest(t, conn)
+
+	results := make(chan bool, 1)
+	go func() {
+		subscribed, _ := NewResponse().AcceptRefer(conn, req)
+		results <- subscribed
+	}()
+
+	resp := readPipe(t, remote)
+	if startLine(resp) != "SIP/2.0 202 Accepted" {
+		t.Errorf("responded with %q, expected a 202", startLine(resp))
+	}
+	if !strings.Contains(resp, "Refer-Sub: false\r\n") {
+		t.Error("202 doesn't confirm Refer-Sub: false")
+	}
+	if <-results {
+		t.Error("implicit subscription created despite Refer-Sub: false")
+	}
+
+	// Nothing else, such as a NOTIFY, is sent for the REFER.
+	expectNoPipeData(t, remote)
+}
+
+func TestAcceptReferWithSubscription(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	writePipe(t, remote, testRequest(MethodRefer, "z9hG4bKrefer",
+		"Refer-To: <sip:carol@127.0.0.1>"))
+	req := readRequest(t, conn)
+
+	results := make(chan bool, 1)
+	go func() {
+		subscribed, _ := NewResponse().AcceptRefer(conn, req)
+		results <- subscribed
+	}()
+
+	if resp := readPipe(t, remote); strings.Contains(resp, "Refer-Sub") {
+		t.Error("202 has a Refer-Sub without one in the REFER")
+	}
+	if !<-results {
+		t.Error("implicit subscription not created")
+	}
+}
+
+func TestReferTargetWithEscapedReplaces(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodRefer, "z9hG4bKtransfer",
+		"Refer-To: <sips:dave@chicago.example.com?Replaces=12345%40192.168.118.3"+
+			"%3Bto-tag%3D12345%3Bfrom-tag%3D5FFE-3994&Require=replaces>"))
+
+	target, headers, err := ReferTarget(req)
+	if err != nil {
+		t.Fatalf("failed to parse Refer-To: %v", err)
+	}
+	if target.String() != "sips:dave@chicago.example.com" {
+		t.Errorf("target is %s, expected sips:dave@chicago.example.com",
+			target.String())
+	}
+	if require := headers.Get("Require"); require != "replaces" {
+		t.Errorf("Require is %q, expected replaces", require)
+	}
+
+	replaces := headers.Get("Replaces")
+	if replaces != "12345@192.168.118.3;to-tag=12345;from-tag=5FFE-3994" {
+		t.Fatalf("Replaces is %q", replaces)
+	}
+	id, err := ParseDialogID(replaces)
+	if err != nil {
+		t.Fatalf("failed to parse Replaces: %v", err)
+	}
+	if id.CallID != "12345@192.168.118.3" || id.ToTag != "12345" ||
+		id.FromTag != "5FFE-3994" {
+		t.Errorf("Replaces is %+v", id)
+	}
+}
+
+func TestURIHeadersRoundTrip(t *testing.T) {
+	uri := URI{Scheme: "sip", Username: "dave", Domain: "chicago.example.com",
+		Headers: make(Header)}
+	uri.Headers.Set("Replaces", "12345@192.168.118.3;to-tag=12345;from-tag=5FFE-3994")
+
+	str := uri.String()
+	if !strings.Contains(str, "?Replaces=12345%40192.168.118.3%3Bto-tag%3D12345") {
+		t.Errorf("serialized %s with an unescaped Replaces", str)
+	}
+
+	parsed, err := ParseURI(str)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", str, err)
+	}
+	if parsed.Headers.Get("Replaces") != uri.Headers.Get("Replaces") {
+		t.Errorf("round-tripped Replaces to %q", parsed.Headers.Get("Replaces"))
+	}
+}
@@ -0,0 +1,146 @@
+package sipnet
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotAcknowledged is returned by ReliableSender.Send if a reliable
+// provisional response sent over UDP is not acknowledged with a PRACK
+// before the transaction times out.
+var ErrNotAcknowledged = errors.New("sip: reliable provisional response not acknowledged")
+
+type reliableResponse struct {
+	acked chan struct{}
+	sent  time.Time
+}
+
+// ReliableSender sends reliable provisional responses (RFC 3262), and
+// matches the PRACKs acknowledging them. The zero value is ready to use,
+// and it is safe to use from multiple goroutines.
+type ReliableSender struct {
+	mutex     sync.Mutex
+	responses map[string]*reliableResponse
+}
+
+// reliableKey returns the key matching a reliable provisional response to
+// the RAck of its PRACK.
+func reliableKey(callID string, rack RAck) string {
+	return callID + " " + rack.String()
+}
+
+// Send sends a provisional response to a request. If the response is
+// reliable, with an RSeq and "Require: 100rel" as negotiated by
+// SessionProgress, it is retransmitted over UDP with the timers of RFC 3262
+// section 3 until its PRACK is passed to HandlePrack, and Send blocks until
+// then, returning ErrNotAcknowledged if the PRACK isn't received in time.
+// Stream transports are reliable, so over TCP and TLS the response is only
+// sent once and Send returns without waiting for its PRACK, which is still
+// answered by HandlePrack.
+func (s *ReliableSender) Send(conn *Conn, req *Request, resp *Response) error {
+	resp.Header.Set("CSeq", req.Header.Get("CSeq"))
+	rack, err := NewRAck(resp)
+	if err != nil || !resp.Header.HasOptionTag("Require", Option100rel) {
+		return resp.WriteTo(conn, req)
+	}
+
+	clock := conn.clock()
+	pending := &reliableResponse{
+		acked: make(chan struct{}),
+		sent:  clock.Now(),
+	}
+	key := reliableKey(req.Header.Get("Call-ID"), rack)
+
+	s.mutex.Lock()
+	if s.responses == nil {
+		s.responses = make(map[string]*reliableResponse)
+	}
+	s.expire(pending.sent)
+	s.responses[key] = pending
+	s.mutex.Unlock()
+
+	err = resp.WriteTo(conn, req)
+	if err != nil || conn.protocol().IsStream() {
+		return err
+	}
+
+	interval := timerT1
+	retransmit := clock.NewTimer(interval)
+	defer retransmit.Stop()
+	timeout := clock.NewTimer(transactionTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-pending.acked:
+			return nil
+		case <-retransmit.C():
+			err := resp.WriteTo(conn, req)
+			if err != nil {
+				return err
+			}
+			conn.countRetransmission()
+
+			interval *= 2
+			retransmit.Reset(interval)
+		case <-timeout.C():
+			s.mutex.Lock()
+			delete(s.responses, key)
+			s.mutex.Unlock()
+			return ErrNotAcknowledged
+		}
+	}
+}
+
+// SessionProgress responds to a Conn with a StatusSessionProgress like
+// Response.SessionProgress, sending it with Send if it is reliable.
+func (s *ReliableSender) SessionProgress(conn *Conn, req *Request,
+	answer []byte, rseq uint32) (uint32, error) {
+	resp := NewResponse()
+	rseq = resp.prepareSessionProgress(req, answer, rseq)
+	return rseq, s.Send(conn, req, resp)
+}
+
+// HandlePrack responds to a received PRACK, and returns whether it
+// acknowledged a reliable provisional response sent with Send. The PRACK
+// is answered with a 200 OK if it matches a response, which stops its
+// retransmission, and with a 481 Call/Transaction Does Not Exist otherwise.
+func (s *ReliableSender) HandlePrack(prack *Request, conn *Conn) bool {
+	rack, err := ParseRAck(prack.Header.Get("RAck"))
+
+	var pending *reliableResponse
+	if err == nil {
+		key := reliableKey(prack.Header.Get("Call-ID"), rack)
+		s.mutex.Lock()
+		pending = s.responses[key]
+		delete(s.responses, key)
+		s.mutex.Unlock()
+	}
+
+	resp := NewResponse()
+	resp.StatusCode = StatusOK
+	if pending == nil {
+		resp.StatusCode = StatusCallTransactionDoesNotExist
+	}
+	resp.Header.Set("To", prack.Header.Get("To"))
+	resp.Header.Set("From", prack.Header.Get("From"))
+	resp.WriteTo(conn, prack)
+
+	if pending == nil {
+		return false
+	}
+
+	close(pending.acked)
+	return true
+}
+
+// expire removes responses which can no longer be acknowledged, such as
+// those sent over stream transports whose PRACK never arrived. s.mutex
+// must be held.
+func (s *ReliableSender) expire(now time.Time) {
+	for key, pending := range s.responses {
+		if now.Sub(pending.sent) > transactionTimeout {
+			delete(s.responses, key)
+		}
+	}
+}
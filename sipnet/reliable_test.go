@@ -0,0 +1,127 @@
+package sipnet
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// prackFor returns a PRACK acknowledging the reliable provisional response
+// with the given RSeq to the INVITE of testRequest.
+func prackFor(t *testing.T, rseq uint32) *Request {
+	t.Helper()
+
+	return parseRequest(t, testRequest(MethodPrack, "z9hG4bKprack",
+		"RAck: "+strconv.FormatUint(uint64(rseq), 10)+" 1 INVITE"))
+}
+
+func TestReliableProvisionalNotRetransmittedOverTCP(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKreliable",
+		"Require: 100rel"))
+	answer := sendonlyAnswer(t)
+	var s ReliableSender
+	var rseq uint32
+	errs := goWrite(func() error {
+		var err error
+		rseq, err = s.SessionProgress(conn, req, answer, 0)
+		return err
+	})
+	data := readPipe(t, remote)
+
+	// Send returns without waiting for the PRACK.
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Send waited for the PRACK over TCP")
+	}
+
+	resp, err := ReadResponse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse the response: %v", err)
+	}
+	if !resp.Header.HasOptionTag("Require", Option100rel) || rseq == 0 {
+		t.Errorf("sent Require %q with RSeq %d, expected a reliable response",
+			resp.Header.Get("Require"), rseq)
+	}
+
+	expectNoPipeData(t, remote)
+	if retransmissions := conn.Stats().Retransmissions; retransmissions != 0 {
+		t.Errorf("%d retransmissions counted, expected none", retransmissions)
+	}
+
+	// The PRACK is still answered.
+	acked := make(chan bool, 1)
+	go func() {
+		acked <- s.HandlePrack(prackFor(t, rseq), conn)
+	}()
+	if line := startLine(readPipe(t, remote)); line != "SIP/2.0 200 OK" {
+		t.Errorf("PRACK answered with %q, expected a 200", line)
+	}
+	if !<-acked {
+		t.Error("PRACK didn't match the response")
+	}
+}
+
+func TestReliableProvisionalRetransmittedOverUDP(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKreliable",
+		"Supported: 100rel"))
+	answer := sendonlyAnswer(t)
+	var s ReliableSender
+	rseqs := make(chan uint32, 1)
+	errs := goWrite(func() error {
+		rseq, err := s.SessionProgress(conn, req, answer, 7)
+		rseqs <- rseq
+		return err
+	})
+	readUDP(t, peer)
+
+	waitForTimer(t, clock, start.Add(timerT1))
+	clock.Advance(timerT1)
+	again, _ := readUDP(t, peer)
+	resp, err := ReadResponse(strings.NewReader(again))
+	if err != nil {
+		t.Fatalf("failed to parse the retransmission: %v", err)
+	}
+	if resp.StatusCode != StatusSessionProgress ||
+		resp.Header.Get("RSeq") != "7" {
+		t.Errorf("retransmitted %d with RSeq %q, expected the 183",
+			resp.StatusCode, resp.Header.Get("RSeq"))
+	}
+
+	if !s.HandlePrack(prackFor(t, 7), conn) {
+		t.Fatal("PRACK didn't match the response")
+	}
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Errorf("PRACK answered with %q, expected a 200", startLine(data))
+	}
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("failed to send: %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Send didn't return after the PRACK")
+	}
+	if rseq := <-rseqs; rseq != 7 {
+		t.Errorf("sent RSeq %d, expected 7", rseq)
+	}
+}
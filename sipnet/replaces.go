@@ -0,0 +1,140 @@
+package sipnet
+
+import "strings"
+
+// DialogID identifies an existing dialog in a Replaces (RFC 3891) or Join
+// (RFC 3911) header, such as for an attended transfer or call pickup. The
+// tags are those of the dialog as seen by the UA receiving the header, so
+// ToTag is its local tag and FromTag its remote tag.
+type DialogID struct {
+	CallID  string
+	ToTag   string
+	FromTag string
+
+	// EarlyOnly is set by the early-only parameter of a Replaces, which
+	// only allows an early dialog to be replaced.
+	EarlyOnly bool
+
+	Arguments HeaderArgs
+}
+
+// ParseDialogID parses the value of a Replaces or Join header.
+func ParseDialogID(str string) (DialogID, error) {
+	parts := strings.SplitN(str, ";", 2)
+	callID := strings.TrimSpace(parts[0])
+	if callID == "" || len(parts) < 2 {
+		return DialogID{}, ErrParseError
+	}
+
+	args := ParsePairs(parts[1])
+	id := DialogID{
+		CallID:    callID,
+		ToTag:     args.Get("to-tag"),
+		FromTag:   args.Get("from-tag"),
+		Arguments: args,
+	}
+	if id.ToTag == "" || id.FromTag == "" {
+		return DialogID{}, ErrParseError
+	}
+
+	_, id.EarlyOnly = args["early-only"]
+	for _, key := range []string{"to-tag", "from-tag", "early-only"} {
+		args.Del(key)
+	}
+
+	return id, nil
+}
+
+// String returns the dialog ID as the value of a Replaces or Join header.
+func (d DialogID) String() string {
+	result := d.CallID + ";to-tag=" + d.ToTag + ";from-tag=" + d.FromTag
+	if d.EarlyOnly {
+		result += ";early-only"
+	}
+
+	return result + d.Arguments.SemicolonString()
+}
+
+// ID returns the ID of the dialog, as it would be sent in a Replaces or
+// Join header by the remote UA to refer to it.
+func (d *Dialog) ID() DialogID {
+	return DialogID{
+		CallID:    d.CallID,
+		ToTag:     d.LocalTag,
+		FromTag:   d.RemoteTag,
+		Arguments: make(HeaderArgs),
+	}
+}
+
+// Lookup returns the dialog with the given ID, or nil if there is none.
+func (s *DialogStore) Lookup(id DialogID) *Dialog {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dialogs[dialogKey(id.CallID, id.ToTag, id.FromTag)]
+}
+
+// FindReplaced returns the dialog an INVITE with a Replaces header is to
+// replace, or nil if the request has no Replaces header or the dialog does
+// not exist. A malformed Replaces header returns ErrParseError, which
+// should be answered with a 400, and a missing dialog with a 481.
+//
+// Whether an early-only Replaces can be honoured depends on the state of
+// the dialog, which is left to the caller.
+func (s *DialogStore) FindReplaced(req *Request) (*Dialog, error) {
+	return s.findReferenced(req, "Replaces")
+}
+
+// FindJoined returns the dialog an INVITE with a Join header is to join,
+// like FindReplaced.
+func (s *DialogStore) FindJoined(req *Request) (*Dialog, error) {
+	return s.findReferenced(req, "Join")
+}
+
+func (s *DialogStore) findReferenced(req *Request, key string) (*Dialog, error) {
+	value := req.Header.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	id, err := ParseDialogID(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Lookup(id), nil
+}
+
+// HandleJoin honours the Join header (RFC 3911) of a received INVITE,
+// returning the dialog it joins so the application can bridge the media of
+// the new dialog into it, and whether the INVITE may proceed. An INVITE
+// without a Join may proceed with no joined dialog. If the Join is
+// malformed or sent alongside a Replaces, the INVITE is answered with a 400
+// Bad Request, and if it matches no dialog of the store, with a 481
+// Call/Transaction Does Not Exist, and false is returned.
+func (s *DialogStore) HandleJoin(req *Request, conn *Conn) (*Dialog, bool) {
+	if req.Method != MethodInvite || req.Header.Get("Join") == "" {
+		return nil, true
+	}
+
+	if req.Header.Get("Replaces") != "" {
+		NewResponse().BadRequest(conn, req, "Join with Replaces.")
+		return nil, false
+	}
+
+	dialog, err := s.FindJoined(req)
+	if err != nil {
+		NewResponse().BadRequest(conn, req, "Malformed Join.")
+		return nil, false
+	}
+
+	if dialog == nil {
+		resp := NewResponse()
+		resp.StatusCode = StatusCallTransactionDoesNotExist
+		resp.Header.Set("To", withToTag(req.Header.Get("To")))
+		resp.Header.Set("From", req.Header.Get("From"))
+		resp.WriteTo(conn, req)
+		return nil, false
+	}
+
+	return dialog, true
+}
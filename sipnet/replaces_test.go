@@ -0,0 +1,131 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDialogID(t *testing.T) {
+	id, err := ParseDialogID("98732@sip.example.com ;from-tag=r33th4x0r" +
+		";to-tag=ff87ff;early-only")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if id.CallID != "98732@sip.example.com" || id.ToTag != "ff87ff" ||
+		id.FromTag != "r33th4x0r" || !id.EarlyOnly {
+		t.Errorf("parsed %+v", id)
+	}
+	if str := id.String(); str != "98732@sip.example.com;to-tag=ff87ff"+
+		";from-tag=r33th4x0r;early-only" {
+		t.Errorf("serialized %q", str)
+	}
+
+	for _, str := range []string{
+		"",
+		"98732@sip.example.com",
+		"98732@sip.example.com;to-tag=ff87ff",
+		";to-tag=ff87ff;from-tag=r33th4x0r",
+	} {
+		if _, err := ParseDialogID(str); err != ErrParseError {
+			t.Errorf("parsing %q returned %v, expected ErrParseError", str, err)
+		}
+	}
+}
+
+func TestFindReferencedDialog(t *testing.T) {
+	store := NewDialogStore()
+	dialog := testDialog(nil)
+	store.Add(dialog)
+
+	tests := []struct {
+		header   string
+		value    string
+		found    bool
+		expected error
+	}{
+		{"Replaces", "call1@127.0.0.1;to-tag=a1;from-tag=b1", true, nil},
+		{"Join", "call1@127.0.0.1;to-tag=a1;from-tag=b1", true, nil},
+		{"Replaces", "call1@127.0.0.1;to-tag=b1;from-tag=a1", false, nil},
+		{"Join", "call2@127.0.0.1;to-tag=a1;from-tag=b1", false, nil},
+		{"Replaces", "call1@127.0.0.1", false, ErrParseError},
+		{"Join", "", false, nil},
+	}
+
+	for _, test := range tests {
+		req := NewRequest()
+		req.Method = MethodInvite
+		if test.value != "" {
+			req.Header.Set(test.header, test.value)
+		}
+
+		find := store.FindReplaced
+		if test.header == "Join" {
+			find = store.FindJoined
+		}
+		found, err := find(req)
+		if (found == dialog) != test.found || err != test.expected {
+			t.Errorf("%s %q found %v, %v, expected %v, %v", test.header,
+				test.value, found != nil, err, test.found, test.expected)
+		}
+	}
+
+	if found := store.Lookup(dialog.ID()); found != dialog {
+		t.Error("the dialog wasn't found by its ID")
+	}
+}
+
+func TestHandleJoin(t *testing.T) {
+	store := NewDialogStore()
+	dialog := testDialog(nil)
+	store.Add(dialog)
+
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	const join = "Join: call1@127.0.0.1;to-tag=a1;from-tag=b1"
+	tests := []struct {
+		name    string
+		extra   []string
+		joined  *Dialog
+		proceed bool
+		status  string
+	}{
+		{"stored dialog", []string{join}, dialog, true, ""},
+		{"without Join", nil, nil, true, ""},
+		{"unknown dialog", []string{
+			"Join: call9@127.0.0.1;to-tag=a1;from-tag=b1"}, nil, false,
+			"SIP/2.0 481 Call/Transaction Does Not Exist"},
+		{"malformed", []string{"Join: call1@127.0.0.1;to-tag=a1"}, nil,
+			false, "SIP/2.0 400 Bad Request"},
+		{"with Replaces", []string{join,
+			"Replaces: call1@127.0.0.1;to-tag=a1;from-tag=b1"}, nil, false,
+			"SIP/2.0 400 Bad Request"},
+	}
+
+	for _, test := range tests {
+		msg := testRequest(MethodInvite, "z9hG4bKjoin", test.extra...)
+		req := parseRequest(t, strings.Replace(msg, "Call-ID: call1@",
+			"Call-ID: call2@", 1))
+
+		var joined *Dialog
+		var proceed bool
+		done := goWrite(func() error {
+			joined, proceed = store.HandleJoin(req, conn)
+			return nil
+		})
+		if test.status != "" {
+			if line := startLine(readPipe(t, remote)); line != test.status {
+				t.Errorf("%s: answered with %q, expected %q", test.name, line,
+					test.status)
+			}
+		}
+		<-done
+
+		if joined != test.joined || proceed != test.proceed {
+			t.Errorf("%s: joined %v and proceed %v, expected %v and %v",
+				test.name, joined != nil, proceed, test.joined != nil,
+				test.proceed)
+		}
+	}
+	expectNoPipeData(t, remote)
+}
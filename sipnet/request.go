@@ -1,7 +1,8 @@
 package sipnet
 
 import (
-	"strconv"
+	"io"
+	"net"
 )
 
 // SIPVersion is the version of SIP used by this library.
@@ -9,13 +10,19 @@ const SIPVersion = "SIP/2.0"
 
 // SIP request methods.
 const (
-	MethodInvite   = "INVITE"
-	MethodAck      = "ACK"
-	MethodBye      = "BYE"
-	MethodCancel   = "CANCEL"
-	MethodRegister = "REGISTER"
-	MethodOptions  = "OPTIONS"
-	MethodInfo     = "INFO"
+	MethodInvite    = "INVITE"
+	MethodAck       = "ACK"
+	MethodBye       = "BYE"
+	MethodCancel    = "CANCEL"
+	MethodRegister  = "REGISTER"
+	MethodOptions   = "OPTIONS"
+	MethodInfo      = "INFO"
+	MethodRefer     = "REFER"
+	MethodNotify    = "NOTIFY"
+	MethodPrack     = "PRACK"
+	MethodSubscribe = "SUBSCRIBE"
+	MethodUpdate    = "UPDATE"
+	MethodMessage   = "MESSAGE"
 )
 
 // Request represents a SIP request (i.e. a message sent by a UAC to a UAS).
@@ -25,6 +32,39 @@ type Request struct {
 	SIPVersion string
 	Header     Header
 	Body       []byte
+
+	// BodyReader streams the body of the request instead of Body, if the
+	// body is larger than the parser's StreamBodyThreshold. It must be read
+	// to the end or closed, as the next message on the connection can't be
+	// read until then.
+	BodyReader io.ReadCloser
+
+	// Warnings are the deviations from the standard found while parsing
+	// the request in lenient mode.
+	Warnings []string
+
+	// RawHeaders are the header lines preserved verbatim while parsing,
+	// without their line endings, for the headers configured in the
+	// parser's PreserveHeaders. They are written unchanged in place of the
+	// values of the same headers in Header, unless a header has been changed
+	// in Header since it was parsed.
+	RawHeaders []string
+
+	// RemoteAddr is the network address the request was received from.
+	// It is nil for requests that were not received by a Conn.
+	RemoteAddr net.Addr
+
+	// LocalAddr is the local network address the request was received on.
+	// For a listener bound to an unspecified address, it is only the
+	// address the datagram was sent to if PacketInfo is configured.
+	LocalAddr net.Addr
+
+	// transport is the transport the request was received over.
+	transport Transport
+
+	// requestURI caches the parsed Server while it is uriServer.
+	requestURI URI
+	uriServer  string
 }
 
 // NewRequest returns a new request.
@@ -35,17 +75,63 @@ func NewRequest() *Request {
 	}
 }
 
+// Clone returns a deep copy of the request, so the copy can be modified
+// (i.e. forwarded to a different target) without affecting the original.
+func (r *Request) Clone() *Request {
+	clone := *r
+	clone.Header = r.Header.Clone()
+	clone.Body = append([]byte(nil), r.Body...)
+	clone.Warnings = append([]string(nil), r.Warnings...)
+	clone.RawHeaders = append([]string(nil), r.RawHeaders...)
+	return &clone
+}
+
+// RequestURI returns the parsed Request-URI (i.e. Server) of the request.
+// The result is cached until Server is changed.
+func (r *Request) RequestURI() (URI, error) {
+	if r.uriServer == "" || r.uriServer != r.Server {
+		uri, err := ParseURI(r.Server)
+		if err != nil {
+			return URI{}, err
+		}
+
+		r.requestURI = uri
+		r.uriServer = r.Server
+	}
+
+	uri := r.requestURI
+	uri.Arguments = make(HeaderArgs)
+	for key, value := range r.requestURI.Arguments {
+		uri.Arguments.Set(key, value)
+	}
+	return uri, nil
+}
+
 // WriteTo writes the request data to a Conn. It automatically adds a
-// a Content-Length to the header, calls Flush() on the Conn.
+// a Content-Length to the header, and a Max-Forwards of DefaultMaxForwards
+// if there is none, calls Flush() on the Conn. Messages written concurrently
+// to the same Conn are written one at a time.
 func (r *Request) WriteTo(conn *Conn) error {
+	r = conn.outboundRequest(r)
+	if r == nil {
+		return nil
+	}
+
+	if r.Header.Get("Max-Forwards") == "" {
+		r.SetMaxForwards(DefaultMaxForwards)
+	}
+
+	conn.sendMutex.Lock()
+	defer conn.sendMutex.Unlock()
+
 	_, err := conn.Write([]byte(r.Method + " " + r.Server + " " + SIPVersion + "\r\n"))
 	if err != nil {
 		return err
 	}
 
-	r.Header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+	conn.setContentLength(r.Header, len(r.Body))
 
-	_, err = r.Header.WriteTo(conn)
+	_, err = writeHeader(conn, r.Header, r.RawHeaders)
 	if err != nil {
 		return err
 	}
@@ -55,5 +141,9 @@ func (r *Request) WriteTo(conn *Conn) error {
 		return err
 	}
 
+	if r.Method == MethodAck {
+		conn.rememberAck(r, conn.WriteBuffer.Bytes())
+	}
+
 	return conn.Flush()
 }
@@ -0,0 +1,87 @@
+package sipnet
+
+import "testing"
+
+func TestRequestClone(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKclone"))
+	req.SetBody([]byte("original"))
+	via := req.Header.Get("Via")
+
+	clone := req.Clone()
+	clone.Header.Set("Via", "SIP/2.0/UDP 127.0.0.2:5060;branch=z9hG4bKfork")
+	clone.Header.Add("Via", via)
+	clone.Body[0] = 'O'
+
+	if values := req.Header.Values("Via"); len(values) != 1 || values[0] != via {
+		t.Errorf("original Via is %q after mutating the clone, expected %q",
+			values, via)
+	}
+	if string(req.Body) != "original" {
+		t.Errorf("original body is %q after mutating the clone", req.Body)
+	}
+}
+
+func TestResponseClone(t *testing.T) {
+	resp := NewResponse()
+	resp.StatusCode = StatusOK
+	resp.Header.Add("Via", "SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKa")
+	resp.Header.Add("Via", "SIP/2.0/UDP 127.0.0.1:5080;branch=z9hG4bKb")
+
+	clone := resp.Clone()
+	clone.Header["Via"][0] = "SIP/2.0/UDP 127.0.0.3:5060;branch=z9hG4bKc"
+	clone.Header.Del("Via")
+
+	if values := resp.Header.Values("Via"); len(values) != 2 ||
+		values[0] != "SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKa" {
+		t.Errorf("original Via is %q after mutating the clone", values)
+	}
+}
+
+func TestRequestURI(t *testing.T) {
+	req := parseRequest(t, "INVITE sip:bob@example.com;transport=tcp SIP/2.0\r\n"+
+		"Via: SIP/2.0/TCP 127.0.0.1:5070;branch=z9hG4bKuri\r\n"+
+		"Content-Length: 0\r\n\r\n")
+	if req.Method != MethodInvite {
+		t.Errorf("method is %q, expected INVITE", req.Method)
+	}
+
+	uri, err := req.RequestURI()
+	if err != nil {
+		t.Fatalf("failed to parse the Request-URI: %v", err)
+	}
+	if uri.Scheme != "sip" || uri.Username != "bob" ||
+		uri.Domain != "example.com" || uri.Arguments.Get("transport") != "tcp" {
+		t.Errorf("parsed %+v, expected sip:bob@example.com;transport=tcp", uri)
+	}
+
+	// The cached URI isn't changed through the returned arguments.
+	uri.Arguments.Set("transport", "udp")
+	if uri, _ := req.RequestURI(); uri.Arguments.Get("transport") != "tcp" {
+		t.Errorf("cached transport changed to %q", uri.Arguments.Get("transport"))
+	}
+
+	// Changing Server invalidates the cache.
+	req.Server = "sip:carol@example.org"
+	if uri, _ := req.RequestURI(); uri.Username != "carol" ||
+		uri.Domain != "example.org" {
+		t.Errorf("parsed %+v after changing Server", uri)
+	}
+
+	req.Server = "not a uri"
+	if _, err := req.RequestURI(); err == nil {
+		t.Error("parsing an invalid Request-URI succeeded")
+	}
+}
+
+func TestResponseReason(t *testing.T) {
+	resp := NewResponse()
+	resp.StatusCode = StatusBusyHere
+	if reason := resp.Reason(); reason != "Busy Here" {
+		t.Errorf("reason is %q, expected \"Busy Here\"", reason)
+	}
+
+	resp.Status = "Gone Fishing"
+	if reason := resp.Reason(); reason != "Gone Fishing" {
+		t.Errorf("reason is %q, expected \"Gone Fishing\"", reason)
+	}
+}
@@ -1,8 +1,8 @@
 package sipnet
 
 import (
+	"net"
 	"strconv"
-	"strings"
 )
 
 // Response represents a SIP response (i.e. a message sent by a UAS to a UAC).
@@ -12,6 +12,17 @@ type Response struct {
 	SIPVersion string
 	Header     Header
 	Body       []byte
+
+	// Warnings are the deviations from the standard found while parsing
+	// the response in lenient mode.
+	Warnings []string
+
+	// RawHeaders are the header lines preserved verbatim while parsing,
+	// without their line endings, for the headers configured in the
+	// parser's PreserveHeaders. They are written unchanged in place of the
+	// values of the same headers in Header, unless a header has been changed
+	// in Header since it was parsed.
+	RawHeaders []string
 }
 
 // NewResponse returns a new response.
@@ -22,38 +33,152 @@ func NewResponse() *Response {
 	}
 }
 
+// Clone returns a deep copy of the response, so the copy can be modified
+// without affecting the original.
+func (r *Response) Clone() *Response {
+	clone := *r
+	clone.Header = r.Header.Clone()
+	clone.Body = append([]byte(nil), r.Body...)
+	clone.Warnings = append([]string(nil), r.Warnings...)
+	clone.RawHeaders = append([]string(nil), r.RawHeaders...)
+	return &clone
+}
+
+// Reason returns the reason phrase of the response, which is Status, or the
+// phrase of RFC 3261 for the status code if Status is empty.
+func (r *Response) Reason() string {
+	if r.Status != "" {
+		return r.Status
+	}
+	return StatusText(r.StatusCode)
+}
+
 // WriteTo writes the response data to a Conn. It automatically adds a
-// a Content-Length, CSeq, Call-ID and Via header. It also sets the Status message
-// appropriately and automatically calls Flush() on the Conn.
+// a Content-Length, CSeq, Call-ID and Via header. If Status is empty, the
+// reason phrase is chosen from the Conn's configured ReasonPhrases (by the
+// request's Accept-Language) and StatusTexts, or the phrase of RFC 3261.
+// It automatically calls Flush() on the Conn. Messages written concurrently
+// to the same Conn are written one at a time.
+//
+// The response is remembered for the request's transaction, and is
+// automatically re-sent if the request is retransmitted. A response to a
+// request received over UDP which is larger than the configured UDPMTU is
+// sent over TCP instead if TCPFallback is configured.
 func (r *Response) WriteTo(conn *Conn, req *Request) error {
-	_, err := conn.Write([]byte(SIPVersion + " " + strconv.Itoa(r.StatusCode) +
-		" " + StatusText(r.StatusCode) + "\r\n"))
-	if err != nil {
-		return err
+	vias := splitVias(req.Header)
+	if len(vias) == 0 {
+		return ErrParseError
 	}
 
-	r.Header.Set("Content-Length", strconv.Itoa(len(r.Body)))
-	reqVia, err := ParseVia(req.Header.Get("Via"))
+	reqVia, err := ParseVia(vias[0])
 	if err != nil {
 		return err
 	}
 
-	ipPort := strings.Split(conn.Addr().String(), ":")
-	reqVia.Arguments.Set("received", ipPort[0])
-	reqVia.Arguments.Set("rport", ipPort[1])
+	host, port, err := net.SplitHostPort(conn.Addr().String())
+	if err == nil {
+		reqVia.Arguments.Set("received", host)
+		reqVia.Arguments.Set("rport", port)
+	}
 	r.Header.Set("Via", reqVia.String())
+	for _, via := range vias[1:] {
+		r.Header.Add("Via", via)
+	}
 	r.Header.Set("CSeq", req.Header.Get("CSeq"))
 	r.Header.Set("Call-ID", req.Header.Get("Call-ID"))
 
-	_, err = r.Header.WriteTo(conn)
+	r = conn.outboundResponse(r)
+	if r == nil {
+		return nil
+	}
+
+	if r.StatusCode >= 200 && !conn.startFinalResponse(req, r.StatusCode) {
+		return ErrRequestTerminated
+	}
+
+	threshold := conn.config().GzipThreshold
+	if threshold > 0 && len(r.Body) >= threshold {
+		if encoding, _ := NegotiateEncoding(req); encoding == EncodingGzip {
+			r.EncodeFor(req)
+		}
+	}
+
+	conn.sendMutex.Lock()
+	defer conn.sendMutex.Unlock()
+
+	status := r.Status
+	if status == "" {
+		status = conn.reasonPhrase(req, r.StatusCode)
+	}
+
+	_, err = conn.Write([]byte(SIPVersion + " " + strconv.Itoa(r.StatusCode) +
+		" " + status + "\r\n"))
+	if err != nil {
+		return err
+	}
+
+	conn.setContentLength(r.Header, len(r.Body))
+	_, err = writeHeader(conn, r.Header, r.RawHeaders)
 	if err != nil {
 		return err
 	}
 
 	conn.Write(r.Body)
+	conn.cacheResponse(req, r.StatusCode, responseToTag(r),
+		conn.WriteBuffer.Bytes())
+
+	mtu := conn.config().udpMTU()
+	if conn.config().TCPFallback && !conn.protocol().IsStream() && mtu > 0 &&
+		conn.WriteBuffer.Len() > mtu {
+		return conn.flushOverTCP(reqVia)
+	}
+
 	return conn.Flush()
 }
 
+// responseToTag returns the To tag of a response, or an empty string if it
+// has none.
+func responseToTag(r *Response) string {
+	to, err := ParseUser(r.Header.Get("To"))
+	if err != nil {
+		return ""
+	}
+	return to.Tag()
+}
+
+// flushOverTCP sends the buffered response over a TCP connection to the UA
+// that sent the request with the given Via, which is the address the
+// request was received from and the port of its sent-by. If the connection
+// fails, the response is flushed over UDP instead.
+func (c *Conn) flushOverTCP(via Via) error {
+	host := via.Arguments.Get("received")
+	sentByHost, port, err := net.SplitHostPort(via.Client)
+	if err != nil {
+		sentByHost = via.Client
+		port = strconv.Itoa(DefaultPort)
+	}
+	if host == "" {
+		host = sentByHost
+	}
+
+	netConn, err := TCP.Dial(net.JoinHostPort(host, port))
+	if err != nil {
+		return c.Flush()
+	}
+
+	data := append([]byte(nil), c.WriteBuffer.Bytes()...)
+	c.WriteBuffer.Reset()
+
+	if c.Listener == nil {
+		defer netConn.Close()
+		_, err = netConn.Write(data)
+		return err
+	}
+
+	// Keep the connection so the UA can send further messages over it.
+	return c.Listener.registerStreamConn(TCP, netConn).writeRaw(data)
+}
+
 // BadRequest responds to a Conn with a StatusBadRequest for convenience.
 func (r *Response) BadRequest(conn *Conn, req *Request, reason string) {
 	r.StatusCode = StatusBadRequest
@@ -61,6 +186,13 @@ func (r *Response) BadRequest(conn *Conn, req *Request, reason string) {
 	r.WriteTo(conn, req)
 }
 
+// VersionNotSupported responds to a Conn with a StatusVersionNotSupported
+// for convenience.
+func (r *Response) VersionNotSupported(conn *Conn, req *Request) {
+	r.StatusCode = StatusVersionNotSupported
+	r.WriteTo(conn, req)
+}
+
 // ServerError responds to a Conn with a StatusServerInternalError
 // for convenience.
 func (r *Response) ServerError(conn *Conn, req *Request, reason string) {
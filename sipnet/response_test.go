@@ -0,0 +1,122 @@
+package sipnet
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestFromPort returns a serialized request with its Via sent-by at the
+// given port of the loopback interface.
+func requestFromPort(method, branch string, port int) string {
+	return strings.Replace(testRequest(method, branch), "127.0.0.1:5070",
+		"127.0.0.1:"+strconv.Itoa(port), 1)
+}
+
+// respondWithBody writes a 200 with a body of the given size to req from a
+// goroutine of its own, returning a channel receiving its error.
+func respondWithBody(conn *Conn, req *Request, size int) <-chan error {
+	resp := NewResponse()
+	resp.StatusCode = StatusOK
+	resp.Header.Set("To", req.Header.Get("To")+";tag=b1")
+	resp.Header.Set("Content-Type", "text/plain")
+	resp.Body = bytes.Repeat([]byte("x"), size)
+	return goWrite(func() error {
+		return resp.WriteTo(conn, req)
+	})
+}
+
+func TestOversizedResponseFallsBackToTCP(t *testing.T) {
+	l := listenTest(t, Config{TCPFallback: true})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	// The UA listens for TCP on the port of its Via.
+	tcpPeer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on TCP: %v", err)
+	}
+	defer tcpPeer.Close()
+	port := tcpPeer.Addr().(*net.TCPAddr).Port
+
+	sendUDP(t, peer, l, requestFromPort(MethodOptions, "z9hG4bKlarge", port))
+	req, conn := acceptRequest(t, l)
+	errs := respondWithBody(conn, req, 2*DefaultUDPMTU)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		netConn, err := tcpPeer.Accept()
+		if err == nil {
+			accepted <- netConn
+		}
+	}()
+
+	var netConn net.Conn
+	select {
+	case netConn = <-accepted:
+	case <-time.After(testTimeout):
+		t.Fatal("response wasn't sent over TCP")
+	}
+	defer netConn.Close()
+
+	netConn.SetReadDeadline(time.Now().Add(testTimeout))
+	resp, err := ReadResponse(bufio.NewReader(netConn))
+	if err != nil {
+		t.Fatalf("failed to read response over TCP: %v", err)
+	}
+	if resp.StatusCode != StatusOK || len(resp.Body) != 2*DefaultUDPMTU {
+		t.Errorf("received %d with %d byte body over TCP", resp.StatusCode,
+			len(resp.Body))
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("failed to write response: %v", err)
+	}
+	expectNoUDP(t, peer)
+}
+
+func TestResponseSentOverUDP(t *testing.T) {
+	// A port nothing listens for TCP on.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on TCP: %v", err)
+	}
+	unreachable := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		config Config
+		size   int
+	}{
+		{"small response", Config{TCPFallback: true}, 100},
+		{"fallback disabled", Config{}, 2 * DefaultUDPMTU},
+		{"UA unreachable over TCP", Config{TCPFallback: true}, 2 * DefaultUDPMTU},
+	}
+
+	for _, test := range tests {
+		l := listenTest(t, test.config)
+		peer := udpPeer(t)
+
+		sendUDP(t, peer, l, requestFromPort(MethodOptions, "z9hG4bKudp",
+			unreachable))
+		req, conn := acceptRequest(t, l)
+		if err := <-respondWithBody(conn, req, test.size); err != nil {
+			t.Errorf("%s: failed to write response: %v", test.name, err)
+		}
+
+		data, _ := readUDP(t, peer)
+		if resp, err := ReadResponse(strings.NewReader(data)); err != nil ||
+			len(resp.Body) != test.size {
+			t.Errorf("%s: received %q over UDP, expected the response",
+				test.name, startLine(data))
+		}
+
+		peer.Close()
+		l.Close()
+	}
+}
@@ -0,0 +1,127 @@
+package sipnet
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryAfter represents the contents of the Retry-After header line.
+type RetryAfter struct {
+	// Delay is how long to wait before retrying the request.
+	Delay time.Duration
+
+	// Comment is the optional comment, without its parentheses.
+	Comment string
+
+	// Duration is how long the callee will be available for once the
+	// delay has passed, from the duration parameter. It is zero if not
+	// specified.
+	Duration time.Duration
+}
+
+// ParseRetryAfter parses a given Retry-After header value into a RetryAfter.
+func ParseRetryAfter(str string) (RetryAfter, error) {
+	str = strings.TrimSpace(str)
+	end := strings.IndexAny(str, " \t(;")
+	if end < 0 {
+		end = len(str)
+	}
+
+	delay, err := strconv.ParseUint(str[:end], 10, 32)
+	if err != nil {
+		return RetryAfter{}, ErrParseError
+	}
+
+	retry := RetryAfter{Delay: time.Duration(delay) * time.Second}
+	rest := strings.TrimSpace(str[end:])
+
+	if strings.HasPrefix(rest, "(") {
+		closing := strings.LastIndex(rest, ")")
+		if closing < 0 {
+			return RetryAfter{}, ErrParseError
+		}
+		retry.Comment = rest[1:closing]
+		rest = strings.TrimSpace(rest[closing+1:])
+	}
+
+	if strings.HasPrefix(rest, ";") {
+		args := ParsePairs(rest[1:])
+		if value := args.Get("duration"); value != "" {
+			duration, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return RetryAfter{}, ErrParseError
+			}
+			retry.Duration = time.Duration(duration) * time.Second
+		}
+	}
+
+	return retry, nil
+}
+
+// String returns the string representation of the Retry-After header line.
+func (r RetryAfter) String() string {
+	str := strconv.FormatInt(int64(r.Delay/time.Second), 10)
+	if r.Comment != "" {
+		str += " (" + r.Comment + ")"
+	}
+	if r.Duration > 0 {
+		str += ";duration=" + strconv.FormatInt(int64(r.Duration/time.Second), 10)
+	}
+	return str
+}
+
+// retryable returns whether a response may carry a Retry-After to indicate
+// when the request can be retried.
+func retryable(resp *Response) bool {
+	switch resp.StatusCode {
+	case StatusServiceUnavailable, StatusNoResponse,
+		StatusBusyHere, StatusBusyEverywhere:
+		return true
+	}
+	return false
+}
+
+// RetryAfter returns the parsed Retry-After of a response, and whether it
+// has a valid one.
+func (r *Response) RetryAfter() (RetryAfter, bool) {
+	value := r.Header.Get("Retry-After")
+	if value == "" {
+		return RetryAfter{}, false
+	}
+
+	retry, err := ParseRetryAfter(value)
+	return retry, err == nil
+}
+
+// DoRetrying sends a request like Do, but if a 503, 480, 486 or 600
+// response is received with a Retry-After of at most maxDelay, the request
+// is sent again after the delay, with an incremented CSeq and a new branch.
+// The request is retried at most maxRetries times.
+func (c *Conn) DoRetrying(req *Request, maxRetries int,
+	maxDelay time.Duration) (*Response, error) {
+	for retries := 0; ; retries++ {
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if !retryable(resp) || retries >= maxRetries {
+			return resp, nil
+		}
+
+		retry, ok := resp.RetryAfter()
+		if !ok || retry.Delay > maxDelay {
+			return resp, nil
+		}
+
+		<-c.clock().After(retry.Delay)
+
+		req.Header.Set("Via", c.NewVia().String())
+		cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+		if err == nil {
+			cseq.Sequence++
+			req.Header.Set("CSeq", cseq.String())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected RetryAfter
+	}{
+		{"120", RetryAfter{Delay: 120 * time.Second}},
+		{" 18000 ", RetryAfter{Delay: 18000 * time.Second}},
+		{"120 (I'm in a meeting)", RetryAfter{Delay: 120 * time.Second,
+			Comment: "I'm in a meeting"}},
+		{"18000;duration=3600", RetryAfter{Delay: 18000 * time.Second,
+			Duration: 3600 * time.Second}},
+		{"120 (Back soon) ;duration=60", RetryAfter{Delay: 120 * time.Second,
+			Comment: "Back soon", Duration: 60 * time.Second}},
+	}
+
+	for _, test := range tests {
+		retry, err := ParseRetryAfter(test.value)
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", test.value, err)
+		} else if retry != test.expected {
+			t.Errorf("parsed %q as %+v, expected %+v", test.value, retry,
+				test.expected)
+		}
+	}
+
+	for _, value := range []string{"", "soon", "-5", "120 (unclosed",
+		"120;duration=long"} {
+		if _, err := ParseRetryAfter(value); err != ErrParseError {
+			t.Errorf("parsed %q with %v, expected ErrParseError", value, err)
+		}
+	}
+}
+
+func TestRetryAfterString(t *testing.T) {
+	retry := RetryAfter{Delay: 120 * time.Second, Comment: "Back soon",
+		Duration: time.Hour}
+	if str := retry.String(); str != "120 (Back soon);duration=3600" {
+		t.Errorf("got %q", str)
+	}
+}
+
+func TestDoRetryingDefers(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	req := newTestRequest(MethodMessage, "sip:bob@"+peer.LocalAddr().String())
+	results := make(chan doResult, 1)
+	go func() {
+		resp, err := conn.DoRetrying(req, 3, time.Minute)
+		results <- doResult{resp, err}
+	}()
+
+	first, from := readUDPRequest(t, peer)
+	peer.WriteTo([]byte(testResponse(first, "503 Service Unavailable",
+		"Retry-After: 5")), from)
+
+	// The request isn't sent again until the Retry-After has passed.
+	waitForTimer(t, clock, start.Add(5*time.Second))
+	expectNoUDP(t, peer)
+	clock.Advance(5 * time.Second)
+
+	second, from := readUDPRequest(t, peer)
+	if second.Header.Get("CSeq") != "2 MESSAGE" {
+		t.Errorf("retried with CSeq %q, expected 2 MESSAGE",
+			second.Header.Get("CSeq"))
+	}
+	if TransactionKey(second) == TransactionKey(first) {
+		t.Error("retried with the branch of the first request")
+	}
+	peer.WriteTo([]byte(testResponse(second, "200 OK")), from)
+
+	select {
+	case result := <-results:
+		if result.err != nil || result.resp.StatusCode != StatusOK {
+			t.Errorf("returned %v, %v, expected the 200", result.resp, result.err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("DoRetrying didn't return")
+	}
+}
+
+func TestDoRetryingDelayTooLong(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	req := newTestRequest(MethodMessage, "sip:bob@127.0.0.1")
+	results := make(chan doResult, 1)
+	go func() {
+		resp, err := conn.DoRetrying(req, 3, time.Minute)
+		results <- doResult{resp, err}
+	}()
+
+	msg := parseRequest(t, readPipe(t, remote))
+	writePipe(t, remote, testResponse(msg, "486 Busy Here",
+		"Retry-After: 3600"))
+
+	select {
+	case result := <-results:
+		if result.err != nil || result.resp.StatusCode != StatusBusyHere {
+			t.Errorf("returned %v, %v, expected the 486", result.resp, result.err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("DoRetrying waited for a Retry-After longer than maxDelay")
+	}
+}
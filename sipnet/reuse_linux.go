@@ -0,0 +1,19 @@
+package sipnet
+
+import "syscall"
+
+// reuseAddr allows a connected UDP socket to be bound to the same address
+// as the listening UDP socket. Linux delivers datagrams from the connected
+// peer to the connected socket.
+func reuseAddr(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET,
+			syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
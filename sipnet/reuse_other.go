@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package sipnet
+
+import "syscall"
+
+// reuseAddr is not supported on this platform.
+func reuseAddr(network, address string, c syscall.RawConn) error {
+	return ErrConnectUnsupported
+}
@@ -0,0 +1,140 @@
+package sipnet
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrHeaderTooLarge is returned by HeaderScanner if the header of a message
+// exceeds MaxHeaderBytes.
+var ErrHeaderTooLarge = errors.New("sip: header too large")
+
+// MaxHeaderBytes is the maximum size of the start line and header of a
+// message scanned by HeaderScanner.
+const MaxHeaderBytes = 65535
+
+// HeaderScanner scans the header fields of a raw message without
+// allocating, for read only inspection of messages in the hot path, such as
+// by a proxy. The keys and values returned refer to the message data, and
+// are only valid as long as it is not modified. Use String or copy a value
+// to keep it.
+//
+// Unlike Parser, keys are not normalized, and each header line is a single
+// field even if it has multiple comma separated values.
+type HeaderScanner struct {
+	data  []byte
+	pos   int
+	key   []byte
+	value []byte
+	err   error
+	done  bool
+}
+
+// NewHeaderScanner returns a scanner over the header of msg, which starts
+// with the start line of the message.
+func NewHeaderScanner(msg []byte) *HeaderScanner {
+	s := &HeaderScanner{data: msg}
+	if _, ok := s.line(); !ok {
+		s.done = true
+	}
+	return s
+}
+
+// line returns the next line without its line ending, and whether there
+// was a complete line.
+func (s *HeaderScanner) line() ([]byte, bool) {
+	end := bytes.IndexByte(s.data[s.pos:], '\n')
+	if end < 0 {
+		s.err = ErrBadMessage
+		return nil, false
+	} else if s.pos+end >= MaxHeaderBytes {
+		s.err = ErrHeaderTooLarge
+		return nil, false
+	}
+
+	line := s.data[s.pos : s.pos+end]
+	s.pos += end + 1
+	if len(line) == 0 || line[len(line)-1] != '\r' {
+		s.err = ErrBadMessage
+		return nil, false
+	}
+
+	return line[:len(line)-1], true
+}
+
+// Scan advances to the next header field, which is then available through
+// Key and Value. It returns false at the end of the header or on an error.
+func (s *HeaderScanner) Scan() bool {
+	if s.done {
+		return false
+	}
+
+	line, ok := s.line()
+	if !ok || len(line) == 0 {
+		s.done = true
+		return false
+	}
+
+	colon := bytes.IndexByte(line, ':')
+	if colon < 0 {
+		s.err = ErrBadMessage
+		s.done = true
+		return false
+	}
+
+	s.key = bytes.TrimSpace(line[:colon])
+	s.value = bytes.TrimSpace(line[colon+1:])
+	return true
+}
+
+// Key returns the key of the current header field.
+func (s *HeaderScanner) Key() []byte {
+	return s.key
+}
+
+// Value returns the value of the current header field.
+func (s *HeaderScanner) Value() []byte {
+	return s.value
+}
+
+// Is returns whether the key of the current header field is key, ignoring
+// case.
+func (s *HeaderScanner) Is(key string) bool {
+	if len(s.key) != len(key) {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if lower(s.key[i]) != lower(key[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns a copy of the value of the current header field, which
+// remains valid after the message data is reused.
+func (s *HeaderScanner) String() string {
+	return string(s.value)
+}
+
+// Err returns the error encountered while scanning, if any.
+func (s *HeaderScanner) Err() error {
+	return s.err
+}
+
+// Body returns the data following the header, once Scan has returned false
+// without an error.
+func (s *HeaderScanner) Body() []byte {
+	if !s.done || s.err != nil {
+		return nil
+	}
+	return s.data[s.pos:]
+}
+
+func lower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
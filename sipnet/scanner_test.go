@@ -0,0 +1,105 @@
+package sipnet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeaderScanner(t *testing.T) {
+	msg := []byte(testRequest(MethodMessage, "z9hG4bKscan",
+		"Content-Type: text/plain") + "hello")
+	msg = bytes.Replace(msg, []byte("Content-Length: 0"),
+		[]byte("Content-Length: 5"), 1)
+
+	s := NewHeaderScanner(msg)
+	var via, callID string
+	fields := 0
+	for s.Scan() {
+		fields++
+		if s.Is("via") {
+			via = s.String()
+		} else if s.Is("Call-ID") {
+			callID = string(s.Value())
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if fields != 8 {
+		t.Errorf("scanned %d fields, expected 8", fields)
+	}
+	if via != "SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKscan" {
+		t.Errorf("scanned Via %q", via)
+	}
+	if callID != "call1@127.0.0.1" {
+		t.Errorf("scanned Call-ID %q", callID)
+	}
+	if body := string(s.Body()); body != "hello" {
+		t.Errorf("body is %q, expected \"hello\"", body)
+	}
+}
+
+func TestHeaderScannerErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		expected error
+	}{
+		{"missing colon", "MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\nVia\r\n\r\n",
+			ErrBadMessage},
+		{"bare line feed", "MESSAGE sip:bob@127.0.0.1 SIP/2.0\nVia: x\n\n",
+			ErrBadMessage},
+		{"unterminated", "MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\nVia: x",
+			ErrBadMessage},
+		{"too large", "MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\nSubject: " +
+			string(bytes.Repeat([]byte("x"), MaxHeaderBytes)) + "\r\n\r\n",
+			ErrHeaderTooLarge},
+	}
+
+	for _, test := range tests {
+		s := NewHeaderScanner([]byte(test.msg))
+		for s.Scan() {
+		}
+		if s.Err() != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, s.Err(),
+				test.expected)
+		}
+		if s.Body() != nil {
+			t.Errorf("%s: has a body after an error", test.name)
+		}
+	}
+}
+
+// benchmarkMessage is a request with a typical number of header fields.
+var benchmarkMessage = []byte(testRequest(MethodInvite, "z9hG4bKbench",
+	"Contact: <sip:alice@127.0.0.1:5070>",
+	"Allow: INVITE, ACK, CANCEL, BYE, OPTIONS",
+	"Supported: replaces, timer",
+	"User-Agent: go-sip"))
+
+func BenchmarkHeaderScanner(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := NewHeaderScanner(benchmarkMessage)
+		for s.Scan() {
+			if s.Is("Via") {
+				_ = s.Value()
+			}
+		}
+		if s.Err() != nil {
+			b.Fatal(s.Err())
+		}
+	}
+}
+
+func BenchmarkParserReadRequest(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req, err := ReadRequest(bytes.NewReader(benchmarkMessage))
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = req.Header.Get("Via")
+	}
+}
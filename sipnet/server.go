@@ -0,0 +1,301 @@
+package sipnet
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DialogStore stores the dialogs of a Server, so requests within a dialog
+// are matched to it regardless of the listener or transport they are
+// received over. It is safe to use from multiple goroutines.
+type DialogStore struct {
+	mutex   sync.Mutex
+	dialogs map[string]*Dialog
+}
+
+// NewDialogStore returns a new empty dialog store.
+func NewDialogStore() *DialogStore {
+	return &DialogStore{
+		dialogs: make(map[string]*Dialog),
+	}
+}
+
+func dialogKey(callID, localTag, remoteTag string) string {
+	return callID + "|" + localTag + "|" + remoteTag
+}
+
+// Add adds a dialog to the store.
+func (s *DialogStore) Add(d *Dialog) {
+	s.mutex.Lock()
+	s.dialogs[dialogKey(d.CallID, d.LocalTag, d.RemoteTag)] = d
+	s.mutex.Unlock()
+}
+
+// Remove removes a dialog from the store.
+func (s *DialogStore) Remove(d *Dialog) {
+	s.mutex.Lock()
+	delete(s.dialogs, dialogKey(d.CallID, d.LocalTag, d.RemoteTag))
+	s.mutex.Unlock()
+}
+
+// Find returns the dialog a received request is within, or nil if there is
+// none.
+func (s *DialogStore) Find(req *Request) *Dialog {
+	from, to, err := ParseUserHeader(req.Header)
+	if err != nil {
+		return nil
+	}
+
+	key := dialogKey(req.Header.Get("Call-ID"), to.Arguments.Get("tag"),
+		from.Arguments.Get("tag"))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dialogs[key]
+}
+
+// Handler handles a request received by a Server. dialog is the dialog the
+// request is within, or nil if it is not within a known dialog.
+type Handler func(req *Request, conn *Conn, dialog *Dialog)
+
+// Server serves requests received on multiple listeners, such as one for
+// each transport, with a single handler. The listeners share the dialogs of
+// the server, and retransmissions of a request are absorbed even if they are
+// received on a different listener, in which case the last response sent
+// for the request is sent again over the connection of the retransmission.
+type Server struct {
+	// orphanAcks, shed and load are accessed atomically, and must be first
+	// for 64-bit alignment.
+	orphanAcks uint64
+	shed       uint64
+	load       int64
+
+	Listeners []*Listener
+	Handler   Handler
+	Dialogs   *DialogStore
+
+	// Invites tracks the pending INVITEs received by the server. A CANCEL is
+	// answered automatically with a 200, and its INVITE with a 487, rather
+	// than being passed to the handler. The handler can wait on
+	// Invites.Cancelled to stop ringing. If nil, CANCELs are passed to the
+	// handler.
+	Invites *PendingInvites
+
+	// Dispatch is how the handler is called for each request. The default
+	// is DispatchGoroutine.
+	Dispatch DispatchMode
+
+	// Workers is the number of workers with the DispatchWorkers mode. If
+	// zero, DefaultWorkers is used.
+	Workers int
+
+	// OverloadThreshold is the number of requests being dispatched, queued
+	// for or being handled by the handler, from which new requests outside
+	// of a dialog are answered with a 503 Service Unavailable rather than
+	// dispatched, and counted by Shed. If zero, requests are never shed.
+	OverloadThreshold int
+
+	// OverloadRetryAfter is the Retry-After of the 503s sent at the
+	// OverloadThreshold, which grows in proportion to the load above it, up
+	// to tenfold. If zero, DefaultOverloadRetryAfter is used.
+	OverloadRetryAfter time.Duration
+
+	mutex        sync.Mutex
+	workers      []chan dispatchedRequest
+	transactions map[string]serverTransaction
+	invites      map[string]time.Time
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// NewServer returns a new server serving requests received on the listeners
+// with handler.
+func NewServer(handler Handler, listeners ...*Listener) *Server {
+	return &Server{
+		Listeners:    listeners,
+		Handler:      handler,
+		Dialogs:      NewDialogStore(),
+		Invites:      NewPendingInvites(),
+		transactions: make(map[string]serverTransaction),
+		invites:      make(map[string]time.Time),
+		done:         make(chan struct{}),
+	}
+}
+
+// Serve accepts requests from all of the listeners, calling the handler for
+// each according to the Dispatch mode. Messages which fail to be read, such
+// as a malformed datagram, are logged and skipped. It blocks until a
+// listener fails, returning its error, which is ErrClosed if the server was
+// closed.
+func (s *Server) Serve() error {
+	if s.Dispatch == DispatchWorkers {
+		s.startWorkers()
+	}
+
+	errs := make(chan error, len(s.Listeners))
+	for _, l := range s.Listeners {
+		go func(l *Listener) {
+			for {
+				req, conn, err := l.AcceptRequest()
+				if err != nil && conn != nil {
+					// The error is of a single message or connection, such
+					// as a malformed datagram, rather than the listener.
+					fmt.Println("warning: failed to read request:", err)
+					continue
+				} else if err != nil {
+					errs <- err
+					return
+				}
+
+				s.handle(req, conn)
+			}
+		}(l)
+	}
+
+	go s.janitor()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-s.done:
+		err = ErrClosed
+	}
+
+	s.Close()
+	return err
+}
+
+// dispatch calls the handler for a request, unless it is a retransmission
+// received over another listener. If the request is within a dialog
+// received over another connection, such as after the remote UA changed
+// transport, the dialog is moved to that connection. A request within a
+// dialog with an out of order CSeq is answered with a 500 instead. An ACK
+// which matches no dialog or INVITE of the server is dropped.
+func (s *Server) dispatch(req *Request, conn *Conn) {
+	defer atomic.AddInt64(&s.load, -1)
+	defer conn.recoverHandler()
+
+	if req.Method != MethodAck && s.seen(req, conn) {
+		return
+	}
+
+	if req.Method == MethodAck && s.isOrphanAck(req) {
+		return
+	}
+
+	if s.Invites != nil {
+		switch req.Method {
+		case MethodInvite:
+			s.Invites.Add(req, conn)
+		case MethodCancel:
+			s.Invites.HandleCancel(req, conn)
+			return
+		}
+	}
+
+	if span := conn.startSpan(req); span != nil {
+		defer span.Finish()
+	}
+
+	dialog := s.Dialogs.Find(req)
+	if dialog != nil {
+		if dialog.Receive(req) == ErrCSeqOutOfOrder {
+			NewResponse().ServerError(conn, req, "CSeq out of order.")
+			return
+		}
+		dialog.bind(conn)
+	}
+
+	s.Handler(req, conn, dialog)
+}
+
+// serverTransaction is a transaction received by a server.
+type serverTransaction struct {
+	conn     *Conn
+	received time.Time
+}
+
+// seen records the transaction of a request received over conn, and returns
+// whether it was already received. A retransmission received over another
+// connection than the request is answered with the last response sent for
+// the request, as the response may have been lost.
+func (s *Server) seen(req *Request, conn *Conn) bool {
+	key := TransactionKey(req)
+	if key == "" {
+		return false
+	}
+
+	s.mutex.Lock()
+	first, found := s.transactions[key]
+	if !found {
+		s.transactions[key] = serverTransaction{
+			conn:     conn,
+			received: s.clock().Now(),
+		}
+		if req.Method == MethodInvite {
+			s.expectAck(req)
+		}
+	}
+	s.mutex.Unlock()
+
+	if found && first.conn != conn {
+		conn.resendResponse(first.conn, key)
+	}
+	return found
+}
+
+func (s *Server) clock() Clock {
+	if len(s.Listeners) > 0 {
+		return s.Listeners[0].config.clock()
+	}
+	return defaultConfig.clock()
+}
+
+// janitor expires the transactions and pending INVITEs of the server.
+func (s *Server) janitor() {
+	clock := s.clock()
+	ticker := clock.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C():
+		}
+
+		now := clock.Now()
+		s.mutex.Lock()
+		for key, t := range s.transactions {
+			if now.Sub(t.received) > transactionTimeout {
+				delete(s.transactions, key)
+			}
+		}
+		for key, t := range s.invites {
+			if now.Sub(t) > maxPendingInvite+transactionTimeout {
+				delete(s.invites, key)
+			}
+		}
+		s.mutex.Unlock()
+
+		if s.Invites != nil {
+			s.Invites.expire(now)
+		}
+	}
+}
+
+// Close closes all of the listeners of the server.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		for _, l := range s.Listeners {
+			if closeErr := l.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,363 @@
+package sipnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerSurvivesPanickingHandler(t *testing.T) {
+	l := listenTest(t, Config{})
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	s := NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+		if req.Header.Get("Subject") == "panic" {
+			panic("handler failed")
+		}
+		resp := NewResponse()
+		resp.StatusCode = StatusOK
+		resp.WriteTo(conn, req)
+	}, l)
+	s.Dispatch = DispatchSync
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve()
+	}()
+	defer s.Close()
+
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKpanic",
+		"Subject: panic"))
+	expectNoUDP(t, peer)
+
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKok"))
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Errorf("got %q, expected the 200 from the handler", startLine(data))
+	}
+
+	select {
+	case err := <-served:
+		t.Fatalf("server stopped with %v", err)
+	default:
+	}
+}
+
+// handled is a request received by a Server's handler.
+type handled struct {
+	req    *Request
+	conn   *Conn
+	dialog *Dialog
+}
+
+func TestServerDialogChangesTransport(t *testing.T) {
+	udp := listenTest(t, Config{})
+	tcp := listenTest(t, Config{})
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	requests := make(chan handled, 2)
+	var s *Server
+	s = NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+		if req.Method == MethodInvite {
+			d, err := NewServerDialog(req, "b1", conn)
+			if err != nil {
+				t.Errorf("failed to create the dialog: %v", err)
+				return
+			}
+			s.Dialogs.Add(d)
+			dialog = d
+		}
+		requests <- handled{req, conn, dialog}
+
+		if req.Method != MethodAck {
+			resp := NewResponse()
+			resp.StatusCode = StatusOK
+			resp.Header.Set("From", req.Header.Get("From"))
+			resp.Header.Set("To", "<sip:bob@127.0.0.1>;tag=b1")
+			resp.WriteTo(conn, req)
+		}
+	}, udp, tcp)
+	go s.Serve()
+	defer s.Close()
+
+	// The dialog is established over UDP on the first listener.
+	sendUDP(t, peer, udp, testRequest(MethodInvite, "z9hG4bKinvite",
+		"Contact: <sip:alice@127.0.0.1:5070>"))
+	invite := <-requests
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Fatalf("INVITE answered with %q", startLine(data))
+	}
+
+	// The BYE arrives over TCP on the second listener.
+	remote, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer remote.Close()
+
+	bye := testRequest(MethodBye, "z9hG4bKbye")
+	bye = strings.Replace(bye, "UDP", "TCP", 1)
+	bye = strings.Replace(bye, "To: <sip:bob@127.0.0.1>",
+		"To: <sip:bob@127.0.0.1>;tag=b1", 1)
+	bye = strings.Replace(bye, "CSeq: 1", "CSeq: 2", 1)
+	remote.SetWriteDeadline(time.Now().Add(testTimeout))
+	remote.Write([]byte(bye))
+
+	var byeReq handled
+	select {
+	case byeReq = <-requests:
+	case <-time.After(testTimeout):
+		t.Fatal("BYE wasn't handled")
+	}
+
+	if byeReq.req.Method != MethodBye {
+		t.Fatalf("handled %s, expected BYE", byeReq.req.Method)
+	}
+	if byeReq.dialog == nil || byeReq.dialog != invite.dialog {
+		t.Fatal("BYE wasn't matched to the dialog established over UDP")
+	}
+	if byeReq.conn.protocol() != TCP || byeReq.dialog.Conn != byeReq.conn {
+		t.Error("dialog wasn't moved to the TCP connection")
+	}
+
+	remote.SetReadDeadline(time.Now().Add(testTimeout))
+	resp, err := ReadResponse(remote)
+	if err != nil {
+		t.Fatalf("failed to read the response: %v", err)
+	}
+	if resp.StatusCode != StatusOK {
+		t.Errorf("BYE answered with %d, expected 200", resp.StatusCode)
+	}
+}
+
+func TestSlowHandlerDoesNotDelayOtherRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		dispatch DispatchMode
+	}{
+		{"goroutine", DispatchGoroutine},
+		{"workers", DispatchWorkers},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := listenTest(t, Config{})
+			peer := udpPeer(t)
+			defer peer.Close()
+
+			release := make(chan struct{})
+			defer close(release)
+			s := NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+				if req.Header.Get("Subject") == "slow" {
+					<-release
+				}
+				resp := NewResponse()
+				resp.StatusCode = StatusOK
+				resp.Header.Set("From", req.Header.Get("From"))
+				resp.Header.Set("To", req.Header.Get("To")+";tag=b1")
+				resp.WriteTo(conn, req)
+			}, l)
+			s.Dispatch = test.dispatch
+			s.Workers = 4
+			go s.Serve()
+			defer s.Close()
+
+			// The requests of call1 and call2 are queued on different
+			// workers.
+			sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKslow",
+				"Subject: slow"))
+			sendUDP(t, peer, l, strings.Replace(testRequest(MethodMessage,
+				"z9hG4bKfast"), "call1@", "call2@", 1))
+
+			data, _ := readUDP(t, peer)
+			resp, err := ReadResponse(strings.NewReader(data))
+			if err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			if callID := resp.Header.Get("Call-ID"); callID != "call2@127.0.0.1" {
+				t.Errorf("first response is to %s, expected the fast request",
+					callID)
+			}
+		})
+	}
+}
+
+func TestServerRejectsStaleCSeq(t *testing.T) {
+	l := listenTest(t, Config{})
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	requests := make(chan handled, 3)
+	var s *Server
+	s = NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+		if req.Method == MethodInvite {
+			d, err := NewServerDialog(req, "b1", conn)
+			if err != nil {
+				t.Errorf("failed to create the dialog: %v", err)
+				return
+			}
+			s.Dialogs.Add(d)
+		}
+		requests <- handled{req, conn, dialog}
+
+		resp := NewResponse()
+		resp.StatusCode = StatusOK
+		resp.Header.Set("From", req.Header.Get("From"))
+		resp.Header.Set("To", "<sip:bob@127.0.0.1>;tag=b1")
+		resp.WriteTo(conn, req)
+	}, l)
+	go s.Serve()
+	defer s.Close()
+
+	sendUDP(t, peer, l, strings.Replace(testRequest(MethodInvite,
+		"z9hG4bKstale", "Contact: <sip:alice@127.0.0.1:5070>"),
+		"CSeq: 1 ", "CSeq: 5 ", 1))
+	<-requests
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Fatalf("INVITE answered with %q", startLine(data))
+	}
+
+	tests := []struct {
+		branch string
+		cseq   string
+		status string
+	}{
+		{"z9hG4bKstale1", "CSeq: 3 ", "SIP/2.0 500 Server Internal Error"},
+		{"z9hG4bKstale2", "CSeq: 6 ", "SIP/2.0 200 OK"},
+	}
+
+	for _, test := range tests {
+		info := testRequest(MethodInfo, test.branch)
+		info = strings.Replace(info, "To: <sip:bob@127.0.0.1>",
+			"To: <sip:bob@127.0.0.1>;tag=b1", 1)
+		info = strings.Replace(info, "CSeq: 1 ", test.cseq, 1)
+		sendUDP(t, peer, l, info)
+
+		if data, _ := readUDP(t, peer); startLine(data) != test.status {
+			t.Errorf("%sINFO answered with %q, expected %q", test.cseq,
+				startLine(data), test.status)
+		}
+	}
+
+	// Only the in order INFO reaches the handler.
+	select {
+	case info := <-requests:
+		if cseq := info.req.Header.Get("CSeq"); cseq != "6 INFO" {
+			t.Errorf("handled INFO with CSeq %q, expected 6", cseq)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("INFO wasn't handled")
+	}
+	select {
+	case info := <-requests:
+		t.Errorf("handled another INFO with CSeq %q",
+			info.req.Header.Get("CSeq"))
+	default:
+	}
+}
+
+func TestServerDropsOrphanAck(t *testing.T) {
+	l := listenTest(t, Config{})
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	requests := make(chan *Request, 3)
+	s := NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+		requests <- req
+		if req.Method == MethodInvite {
+			resp := NewResponse()
+			resp.StatusCode = StatusOK
+			resp.Header.Set("From", req.Header.Get("From"))
+			resp.Header.Set("To", "<sip:bob@127.0.0.1>;tag=b1")
+			resp.WriteTo(conn, req)
+		}
+	}, l)
+	s.Dispatch = DispatchSync
+	go s.Serve()
+	defer s.Close()
+
+	// An ACK for another call is dropped.
+	sendUDP(t, peer, l, strings.Replace(testRequest(MethodAck,
+		"z9hG4bKorphan"), "call1@127.0.0.1", "call2@127.0.0.1", 1))
+	waitFor(t, "the orphan ACK to be counted", func() bool {
+		return s.OrphanAcks() == 1
+	})
+
+	// The ACK for the 2xx of an INVITE is delivered.
+	sendUDP(t, peer, l, testRequest(MethodInvite, "z9hG4bKacked"))
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Fatalf("INVITE answered with %q", startLine(data))
+	}
+	sendUDP(t, peer, l, testRequest(MethodAck, "z9hG4bKack"))
+
+	for _, method := range []string{MethodInvite, MethodAck} {
+		select {
+		case req := <-requests:
+			if req.Method != method {
+				t.Errorf("handled %s, expected %s", req.Method, method)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("%s wasn't handled", method)
+		}
+	}
+	if orphans := s.OrphanAcks(); orphans != 1 {
+		t.Errorf("%d orphan ACKs counted, expected 1", orphans)
+	}
+}
+
+func TestRespondAfterHandlerReturns(t *testing.T) {
+	l := listenTest(t, Config{})
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	requests := make(chan handled, 2)
+	s := NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+		requests <- handled{req, conn, dialog}
+	}, l)
+	go s.Serve()
+	defer s.Close()
+
+	msg := testRequest(MethodMessage, "z9hG4bKasync")
+	sendUDP(t, peer, l, msg)
+	var pending handled
+	select {
+	case pending = <-requests:
+	case <-time.After(testTimeout):
+		t.Fatal("request wasn't handled")
+	}
+
+	// A retransmission while the backend lookup is in progress isn't
+	// handled again.
+	sendUDP(t, peer, l, msg)
+	expectNoUDP(t, peer)
+
+	// The response is sent from another goroutine after the handler
+	// returned.
+	time.Sleep(time.Second)
+	errs := make(chan error, 1)
+	go func() {
+		resp := NewResponse()
+		resp.StatusCode = StatusOK
+		resp.Header.Set("From", pending.req.Header.Get("From"))
+		resp.Header.Set("To", pending.req.Header.Get("To")+";tag=b1")
+		errs <- resp.WriteTo(pending.conn, pending.req)
+	}()
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to respond: %v", err)
+	}
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Fatalf("answered with %q, expected the 200", startLine(data))
+	}
+
+	// The transaction is kept, so a retransmission is answered with the 200.
+	sendUDP(t, peer, l, msg)
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Errorf("retransmission answered with %q", startLine(data))
+	}
+	select {
+	case <-requests:
+		t.Error("the retransmission was handled")
+	default:
+	}
+}
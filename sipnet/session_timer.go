@@ -0,0 +1,332 @@
+package sipnet
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSessionTimer is returned by NewSessionTimer if the 2xx of the INVITE
+// has no Session-Expires, so no session timer was negotiated.
+var ErrNoSessionTimer = errors.New("sip: no session timer negotiated")
+
+// ErrSessionExpired is received from SessionTimer.Expired if a refresh of
+// the session failed, or the remote UA didn't refresh it in time.
+var ErrSessionExpired = errors.New("sip: session expired")
+
+// OptionTimer is the option tag of session timers (RFC 4028), listed in the
+// Supported or Require of messages of UAs which support them.
+const OptionTimer = "timer"
+
+// supportsTimer returns whether a message lists the timer option tag in its
+// Supported or Require header.
+func supportsTimer(h Header) bool {
+	return h.HasOptionTag("Supported", OptionTimer) ||
+		h.HasOptionTag("Require", OptionTimer)
+}
+
+// The refresher parameter values of the Session-Expires header, which are
+// the role of the UA that refreshes the session in the transaction the
+// header was sent in.
+const (
+	RefresherUAC = "uac"
+	RefresherUAS = "uas"
+)
+
+// SessionExpires represents the value of the Session-Expires header (RFC
+// 4028), which is the interval after which the session ends unless it is
+// refreshed by a re-INVITE or UPDATE.
+type SessionExpires struct {
+	Delta     time.Duration
+	Refresher string
+}
+
+// ParseSessionExpires parses the value of a Session-Expires header, i.e.
+// "1800;refresher=uac".
+func ParseSessionExpires(str string) (SessionExpires, error) {
+	value := strings.TrimSpace(strings.Split(str, ";")[0])
+	seconds, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || seconds == 0 {
+		return SessionExpires{}, ErrParseError
+	}
+
+	refresher := strings.ToLower(ParseHeaderArgs(str).Get("refresher"))
+	if refresher != "" && refresher != RefresherUAC && refresher != RefresherUAS {
+		return SessionExpires{}, ErrParseError
+	}
+
+	return SessionExpires{
+		Delta:     time.Duration(seconds) * time.Second,
+		Refresher: refresher,
+	}, nil
+}
+
+// String returns the value of the Session-Expires header.
+func (s SessionExpires) String() string {
+	value := strconv.FormatInt(int64(s.Delta/time.Second), 10)
+	if s.Refresher != "" {
+		value += ";refresher=" + s.Refresher
+	}
+	return value
+}
+
+// SessionExpires returns the Session-Expires header (compact form "x"), and
+// whether there is one.
+func (h Header) SessionExpires() (SessionExpires, bool, error) {
+	value := h.Get("Session-Expires")
+	if value == "" {
+		value = h.Get("x")
+	}
+
+	if value == "" {
+		return SessionExpires{}, false, nil
+	}
+
+	expires, err := ParseSessionExpires(value)
+	if err != nil {
+		return SessionExpires{}, false, err
+	}
+
+	return expires, true, nil
+}
+
+// SetSessionExpires sets the Session-Expires header.
+func (h Header) SetSessionExpires(expires SessionExpires) {
+	h.Del("x")
+	h.Set("Session-Expires", expires.String())
+}
+
+// NegotiateSessionTimer adds the session timer of a received INVITE or
+// UPDATE to its 2xx response (RFC 4028 section 9), and returns whether one
+// was negotiated. If the request has no Session-Expires, no session timer
+// is negotiated. If the UAC supports session timers, the Session-Expires
+// of the request is echoed, with the UAC as the refresher unless the
+// request chose one, and the response requires the timer option tag.
+// Otherwise, the UAC can't refresh the session, so the UAS is the refresher.
+func (r *Response) NegotiateSessionTimer(req *Request) bool {
+	expires, ok, err := req.Header.SessionExpires()
+	if err != nil || !ok {
+		return false
+	}
+
+	if !supportsTimer(req.Header) {
+		expires.Refresher = RefresherUAS
+	} else {
+		if expires.Refresher == "" {
+			expires.Refresher = RefresherUAC
+		}
+		r.Header.AddOptionTag("Require", OptionTimer)
+	}
+
+	r.Header.SetSessionExpires(expires)
+	return true
+}
+
+// SessionTimer keeps a session of a dialog alive as negotiated by the
+// Session-Expires of the 2xx of its INVITE (RFC 4028). If the local UA is
+// the refresher, a refresh is sent with the dialog's Do half way through
+// each interval, and if it isn't answered with a 2xx, the dialog is
+// terminated with a BYE. Otherwise, the dialog is terminated with a BYE if
+// the remote UA doesn't refresh the session before the interval ends, less
+// the lesser of 32 seconds and a third of the interval.
+//
+// As refreshes are sent with Do, the dialog's connection must be locked,
+// and must not be read from elsewhere while a refresh is sent.
+type SessionTimer struct {
+	// Method is the method of the refreshes sent, which is MethodUpdate if
+	// the remote UA allows UPDATE, otherwise MethodInvite.
+	Method string
+
+	// Prepare is called with each refresh before it is sent, such as to add
+	// the session description of a re-INVITE. It may be nil.
+	Prepare func(*Request)
+
+	dialog    *Dialog
+	clock     Clock
+	expires   SessionExpires
+	refresher bool
+
+	refreshed chan SessionExpires
+	expired   chan error
+	stop      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewSessionTimer returns the session timer of a dialog negotiated by an
+// INVITE and its 2xx, which starts once Start is called. uac is whether the
+// local UA sent the INVITE. ErrNoSessionTimer is returned if the 2xx has no
+// Session-Expires, such as when neither UA supports session timers. A UA
+// which doesn't support the timer option tag can't refresh the session, so
+// the other UA is the refresher regardless of the refresher parameter.
+func NewSessionTimer(d *Dialog, invite *Request, resp *Response, uac bool) (*SessionTimer, error) {
+	expires, ok, err := resp.Header.SessionExpires()
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNoSessionTimer
+	}
+
+	switch {
+	case uac && !resp.Header.HasOptionTag("Require", OptionTimer):
+		// The UAS doesn't support session timers, and the Session-Expires
+		// was added by a proxy, so only the UAC can refresh the session.
+		expires.Refresher = RefresherUAC
+	case !uac && !supportsTimer(invite.Header):
+		expires.Refresher = RefresherUAS
+	case expires.Refresher == "":
+		// The UAC refreshes the session if the UAS doesn't choose.
+		expires.Refresher = RefresherUAC
+	}
+
+	remote := invite.Header
+	if uac {
+		remote = resp.Header
+	}
+
+	clock := RealClock
+	if d.Conn != nil {
+		clock = d.Conn.clock()
+	}
+
+	return &SessionTimer{
+		Method:    refreshMethod(remote),
+		dialog:    d,
+		clock:     clock,
+		expires:   expires,
+		refresher: (expires.Refresher == RefresherUAC) == uac,
+		refreshed: make(chan SessionExpires, 1),
+		expired:   make(chan error, 1),
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// refreshMethod returns the method of refreshes sent to a UA, from the
+// Allow header of a message it sent.
+func refreshMethod(h Header) string {
+	for _, value := range h.Values("Allow") {
+		for _, method := range strings.Split(value, ",") {
+			if strings.TrimSpace(method) == MethodUpdate {
+				return MethodUpdate
+			}
+		}
+	}
+	return MethodInvite
+}
+
+// Start starts the session timer.
+func (t *SessionTimer) Start() {
+	t.startOnce.Do(func() { go t.run() })
+}
+
+// Stop stops the session timer, such as once the dialog has terminated.
+func (t *SessionTimer) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// Expired returns a channel which receives ErrSessionExpired, or the error
+// of the refresh which failed, once the session has expired and the BYE
+// terminating the dialog has been sent, after which the timer stops.
+func (t *SessionTimer) Expired() <-chan error {
+	return t.expired
+}
+
+// Refreshed restarts the interval of the session once it has been refreshed
+// by the remote UA, with the Session-Expires of the 2xx to its re-INVITE or
+// UPDATE. The local UA refreshes the session from then on if the 2xx names
+// it as the refresher.
+func (t *SessionTimer) Refreshed(resp *Response) error {
+	expires, ok, err := resp.Header.SessionExpires()
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrNoSessionTimer
+	}
+
+	select {
+	case <-t.refreshed:
+	default:
+	}
+	t.refreshed <- expires
+	return nil
+}
+
+// wait returns how long to wait before refreshing the session, or before
+// it expires if the local UA isn't the refresher.
+func (t *SessionTimer) wait() time.Duration {
+	if t.refresher {
+		return t.expires.Delta / 2
+	}
+
+	margin := t.expires.Delta / 3
+	if margin > 32*time.Second {
+		margin = 32 * time.Second
+	}
+	return t.expires.Delta - margin
+}
+
+func (t *SessionTimer) run() {
+	for {
+		select {
+		case <-t.stop:
+			return
+		case expires := <-t.refreshed:
+			// The remote UA is the UAC of its refresh, so the local UA is
+			// the refresher only if the 2xx names the UAS.
+			t.expires = expires
+			t.refresher = expires.Refresher == RefresherUAS
+			continue
+		case <-t.clock.After(t.wait()):
+		}
+
+		var err error = ErrSessionExpired
+		if t.refresher {
+			err = t.refresh()
+			if err == nil {
+				continue
+			}
+		}
+
+		t.dialog.Hangup(nil)
+		t.expired <- err
+		t.Stop()
+		return
+	}
+}
+
+// refresh sends a single refresh of the session, and returns an error if it
+// wasn't answered with a 2xx.
+func (t *SessionTimer) refresh() error {
+	req := t.dialog.NewRequest(t.Method)
+	req.Header.SetSessionExpires(SessionExpires{
+		Delta:     t.expires.Delta,
+		Refresher: RefresherUAC,
+	})
+	req.Header.AddOptionTag("Supported", OptionTimer)
+	if t.Prepare != nil {
+		t.Prepare(req)
+	}
+
+	resp, err := t.dialog.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if t.Method == MethodInvite && resp.StatusCode < 300 {
+		t.dialog.NewRequest(MethodAck).WriteTo(t.dialog.Conn)
+	}
+
+	if resp.StatusCode >= 300 {
+		return ErrSessionExpired
+	}
+
+	if expires, ok, err := resp.Header.SessionExpires(); err == nil && ok {
+		t.expires.Delta = expires.Delta
+		t.refresher = expires.Refresher != RefresherUAS ||
+			!resp.Header.HasOptionTag("Require", OptionTimer)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionTimerRefreshesAndExpires(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	l := listenTest(t, Config{Clock: clock})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	d := testDialog(conn)
+	invite := parseRequest(t, testRequest(MethodInvite, "z9hG4bKsession",
+		"Supported: timer", "Session-Expires: 90"))
+	resp, err := ReadResponse(strings.NewReader(testResponse(invite, "200 OK",
+		"Session-Expires: 90;refresher=uac", "Require: timer",
+		"Allow: INVITE, ACK, BYE, UPDATE")))
+	if err != nil {
+		t.Fatalf("failed to parse the 2xx: %v", err)
+	}
+
+	timer, err := NewSessionTimer(d, invite, resp, true)
+	if err != nil {
+		t.Fatalf("failed to create the session timer: %v", err)
+	}
+	if timer.Method != MethodUpdate {
+		t.Errorf("refreshes with %s, expected UPDATE", timer.Method)
+	}
+	timer.Start()
+	defer timer.Stop()
+
+	// The local UA is the refresher, so it refreshes half way through the
+	// interval.
+	waitForTimer(t, clock, start.Add(45*time.Second))
+	clock.Advance(45 * time.Second)
+	update, from := readUDPRequest(t, peer)
+	if update.Method != MethodUpdate {
+		t.Fatalf("sent %s, expected an UPDATE", update.Method)
+	}
+	if expires := update.Header.Get("Session-Expires"); expires != "90;refresher=uac" {
+		t.Errorf("UPDATE with Session-Expires %q", expires)
+	}
+	peer.WriteTo([]byte(testResponse(update, "200 OK",
+		"Session-Expires: 90;refresher=uac", "Require: timer")), from)
+
+	// The next refresh fails, so the dialog is terminated.
+	waitForTimer(t, clock, start.Add(90*time.Second))
+	clock.Advance(45 * time.Second)
+	update, from = readUDPRequest(t, peer)
+	if update.Method != MethodUpdate {
+		t.Fatalf("sent %s, expected an UPDATE", update.Method)
+	}
+	peer.WriteTo([]byte(testResponse(update,
+		"481 Call/Transaction Does Not Exist")), from)
+
+	bye, from := readUDPRequest(t, peer)
+	if bye.Method != MethodBye {
+		t.Fatalf("sent %s after the failed refresh, expected a BYE", bye.Method)
+	}
+	peer.WriteTo([]byte(testResponse(bye, "200 OK")), from)
+
+	select {
+	case err := <-timer.Expired():
+		if err != ErrSessionExpired {
+			t.Errorf("expired with %v, expected ErrSessionExpired", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the session didn't expire")
+	}
+}
+
+func TestNegotiateSessionTimer(t *testing.T) {
+	tests := []struct {
+		name       string
+		extra      []string
+		negotiated bool
+		expires    string
+		require    bool
+	}{
+		{"supported", []string{"Supported: timer", "Session-Expires: 1800"},
+			true, "1800;refresher=uac", true},
+		{"UAS refresher chosen", []string{"Supported: 100rel, timer",
+			"Session-Expires: 1800;refresher=uas"}, true,
+			"1800;refresher=uas", true},
+		{"unsupported", []string{"Session-Expires: 1800;refresher=uac"},
+			true, "1800;refresher=uas", false},
+		{"no Session-Expires", []string{"Supported: timer"}, false, "", false},
+	}
+
+	for _, test := range tests {
+		req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKnegotiate",
+			test.extra...))
+		resp := NewResponse()
+		resp.StatusCode = StatusOK
+
+		if negotiated := resp.NegotiateSessionTimer(req); negotiated !=
+			test.negotiated {
+			t.Errorf("%s: negotiated is %v", test.name, negotiated)
+		}
+		if expires := resp.Header.Get("Session-Expires"); expires != test.expires {
+			t.Errorf("%s: Session-Expires %q, expected %q", test.name, expires,
+				test.expires)
+		}
+		if require := resp.Header.HasOptionTag("Require", OptionTimer); require !=
+			test.require {
+			t.Errorf("%s: requires timer is %v", test.name, require)
+		}
+	}
+}
+
+func TestSessionTimerRefresherFollowsTimerTag(t *testing.T) {
+	tests := []struct {
+		name      string
+		uac       bool
+		invite    []string
+		resp      []string
+		refresher bool
+	}{
+		{"UAS supports timer", true, []string{"Supported: timer"},
+			[]string{"Session-Expires: 90;refresher=uas", "Require: timer"},
+			false},
+		{"UAS lacks timer", true, []string{"Supported: timer"},
+			[]string{"Session-Expires: 90;refresher=uas"}, true},
+		{"UAC supports timer", false, []string{"Supported: timer"},
+			[]string{"Session-Expires: 90;refresher=uac", "Require: timer"},
+			false},
+		{"UAC lacks timer", false, nil,
+			[]string{"Session-Expires: 90;refresher=uac"}, true},
+	}
+
+	for _, test := range tests {
+		invite := parseRequest(t, testRequest(MethodInvite, "z9hG4bKtimer",
+			test.invite...))
+		resp, err := ReadResponse(strings.NewReader(testResponse(invite,
+			"200 OK", test.resp...)))
+		if err != nil {
+			t.Fatalf("%s: failed to parse the 2xx: %v", test.name, err)
+		}
+
+		timer, err := NewSessionTimer(testDialog(nil), invite, resp, test.uac)
+		if err != nil {
+			t.Fatalf("%s: failed to create the session timer: %v", test.name,
+				err)
+		}
+		if timer.refresher != test.refresher {
+			t.Errorf("%s: local refresher is %v, expected %v", test.name,
+				timer.refresher, test.refresher)
+		}
+	}
+
+	// Without a Session-Expires in the 2xx, no refresh is scheduled.
+	invite := parseRequest(t, testRequest(MethodInvite, "z9hG4bKtimer"))
+	resp, _ := ReadResponse(strings.NewReader(testResponse(invite, "200 OK")))
+	if _, err := NewSessionTimer(testDialog(nil), invite, resp,
+		true); err != ErrNoSessionTimer {
+		t.Errorf("created a session timer with %v, expected ErrNoSessionTimer",
+			err)
+	}
+}
@@ -0,0 +1,111 @@
+package sipnet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrNonSIP is the cause of a stream connection closed by RejectNonSIP, as
+// passed to Config.OnDisconnect.
+var ErrNonSIP = errors.New("sip: connection is not SIP")
+
+// maxSniffedLine is the longest start line accepted by looksLikeSIP.
+const maxSniffedLine = 1024
+
+// looksLikeSIP sniffs the start line of the next message of a stream,
+// without consuming it, and returns whether it is plausibly a SIP request
+// line or status line. Binary data, such as a TLS handshake, is rejected as
+// soon as its first bytes are received, and text protocols such as HTTP
+// once their first line is. An error is returned if the stream fails
+// before that.
+func looksLikeSIP(rd *bufio.Reader) (bool, error) {
+	n := 1
+	for {
+		line, err := rd.Peek(n)
+		if err != nil {
+			return false, err
+		}
+
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			return isSIPStartLine(bytes.TrimRight(line[:end], "\r")), nil
+		}
+
+		if !isSIPStartLinePrefix(line) || len(line) >= maxSniffedLine {
+			return false, nil
+		}
+
+		// Check all of the data received so far, or wait for more.
+		n = len(line) + 1
+		if buffered := rd.Buffered(); buffered > len(line) {
+			n = buffered
+		}
+	}
+}
+
+// isSIPStartLinePrefix returns whether the start of a line could begin a
+// request line (a method token, Request-URI and SIP version separated by
+// spaces) or a status line.
+func isSIPStartLinePrefix(line []byte) bool {
+	if bytes.HasPrefix(line, []byte("SIP/")) {
+		return true
+	}
+
+	method := bytes.IndexByte(line, ' ')
+	if method < 0 {
+		method = len(line)
+	}
+	if method == 0 && len(line) > 0 {
+		return false
+	}
+
+	for _, c := range line[:method] {
+		if !isTokenChar(rune(c)) {
+			return false
+		}
+	}
+
+	if method == len(line) {
+		return true
+	}
+
+	uri := bytes.IndexByte(line[method+1:], ' ')
+	if uri < 0 {
+		return true
+	}
+
+	version := line[method+1+uri+1:]
+	if len(version) > 4 {
+		version = version[:4]
+	}
+	return bytes.HasPrefix([]byte("SIP/"), version)
+}
+
+// isSIPStartLine returns whether a complete line is plausibly a SIP status
+// line, or a request line with a SIP version. The version itself is
+// checked by the parser.
+func isSIPStartLine(line []byte) bool {
+	if bytes.HasPrefix(line, []byte("SIP/")) {
+		return true
+	}
+
+	fields := bytes.Fields(line)
+	return len(fields) == 3 && isSIPStartLinePrefix(line) &&
+		bytes.HasPrefix(fields[2], []byte("SIP/"))
+}
+
+// rejectNonSIP records a stream connection closed by RejectNonSIP.
+func (c *Conn) rejectNonSIP() {
+	if c.Listener != nil {
+		atomic.AddUint64(&c.Listener.nonSIP, 1)
+	}
+	c.closeFromReader(ErrNonSIP)
+}
+
+// NonSIP returns the number of stream connections that have been closed
+// because they received data which is not SIP, with RejectNonSIP
+// configured.
+func (l *Listener) NonSIP() uint64 {
+	return atomic.LoadUint64(&l.nonSIP)
+}
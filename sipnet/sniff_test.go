@@ -0,0 +1,73 @@
+package sipnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNonSIPStreamClosed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		// The connection is closed without waiting for the rest of the
+		// request.
+		{"HTTP", "GET / HTTP/1.1\r\nHost: 127.0.0.1"},
+		{"TLS", "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03"},
+	}
+
+	l := listenTest(t, Config{RejectNonSIP: true})
+	defer l.Close()
+
+	for i, test := range tests {
+		peer, err := net.Dial("tcp", l.TransportAddr("tcp").String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer peer.Close()
+
+		peer.Write([]byte(test.data))
+		peer.SetReadDeadline(time.Now().Add(testTimeout))
+		if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("%s: read %v, expected the connection to be closed",
+				test.name, err)
+		}
+		if rejected := l.NonSIP(); rejected != uint64(i+1) {
+			t.Errorf("%s: %d connections rejected, expected %d", test.name,
+				rejected, i+1)
+		}
+	}
+
+	// SIP is still accepted.
+	peer, err := net.Dial("tcp", l.TransportAddr("tcp").String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer peer.Close()
+	peer.Write([]byte(testRequest(MethodOptions, "z9hG4bKsniff")))
+	if req, _ := acceptRequest(t, l); req.Method != MethodOptions {
+		t.Errorf("accepted %s, expected OPTIONS", req.Method)
+	}
+}
+
+func TestIsSIPStartLine(t *testing.T) {
+	tests := []struct {
+		line string
+		sip  bool
+	}{
+		{"INVITE sip:bob@biloxi.com SIP/2.0", true},
+		{"SIP/2.0 200 OK", true},
+		{"GET / HTTP/1.1", false},
+		{"INVITE sip:bob@biloxi.com", false},
+		{"SSH-2.0-OpenSSH_8.9", false},
+	}
+
+	for _, test := range tests {
+		if sip := isSIPStartLine([]byte(test.line)); sip != test.sip {
+			t.Errorf("%q: isSIPStartLine is %v, expected %v", test.line, sip,
+				test.sip)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package sipnet
+
+import "bytes"
+
+// SplitMessage splits a raw message into its start line, header block and
+// body without parsing it, such as for logging, signing or routing before a
+// full parse. The start line and header block are returned without their
+// line endings, and lines may end with a bare LF rather than CRLF. The
+// returned slices refer to msg. ErrBadMessage is returned if the end of the
+// header can't be found.
+func SplitMessage(msg []byte) (startLine []byte, headerBlock []byte,
+	body []byte, err error) {
+	pos := 0
+	headerStart := -1
+	for pos < len(msg) {
+		end := bytes.IndexByte(msg[pos:], '\n')
+		if end < 0 {
+			break
+		}
+
+		line := bytes.TrimSuffix(msg[pos:pos+end], []byte("\r"))
+		next := pos + end + 1
+
+		if headerStart < 0 {
+			startLine = line
+			headerStart = next
+		} else if len(line) == 0 {
+			headerBlock = trimLineEnding(msg[headerStart:pos])
+			return startLine, headerBlock, msg[next:], nil
+		}
+
+		pos = next
+	}
+
+	return nil, nil, nil, ErrBadMessage
+}
+
+// JoinMessage joins a start line, header block and body split by
+// SplitMessage back into a message, with CRLF line endings after the start
+// line and header block.
+func JoinMessage(startLine []byte, headerBlock []byte, body []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(startLine)
+	buf.WriteString("\r\n")
+	if len(headerBlock) > 0 {
+		buf.Write(headerBlock)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func trimLineEnding(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	return bytes.TrimSuffix(b, []byte("\r"))
+}
@@ -0,0 +1,86 @@
+package sipnet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    string
+		header string
+		body   string
+	}{
+		{"without a body", "MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 127.0.0.1:5070\r\nContent-Length: 0\r\n\r\n",
+			"Via: SIP/2.0/UDP 127.0.0.1:5070\r\nContent-Length: 0", ""},
+		{"with a body", "MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\n" +
+			"Content-Length: 9\r\n\r\nhi\r\n\r\nbob",
+			"Content-Length: 9", "hi\r\n\r\nbob"},
+		{"with bare LF", "MESSAGE sip:bob@127.0.0.1 SIP/2.0\n" +
+			"Via: SIP/2.0/UDP 127.0.0.1:5070\nContent-Length: 5\n\nhello",
+			"Via: SIP/2.0/UDP 127.0.0.1:5070\nContent-Length: 5", "hello"},
+		{"without headers", "MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\n\r\n",
+			"", ""},
+	}
+
+	for _, test := range tests {
+		start, header, body, err := SplitMessage([]byte(test.msg))
+		if err != nil {
+			t.Errorf("%s: failed to split: %v", test.name, err)
+			continue
+		}
+
+		if string(start) != "MESSAGE sip:bob@127.0.0.1 SIP/2.0" {
+			t.Errorf("%s: start line is %q", test.name, start)
+		}
+		if string(header) != test.header {
+			t.Errorf("%s: header block is %q, expected %q", test.name,
+				header, test.header)
+		}
+		if string(body) != test.body {
+			t.Errorf("%s: body is %q, expected %q", test.name, body, test.body)
+		}
+	}
+}
+
+func TestSplitMessageIncomplete(t *testing.T) {
+	for _, msg := range []string{
+		"",
+		"MESSAGE sip:bob@127.0.0.1 SIP/2.0",
+		"MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\nContent-Length: 0\r\n",
+	} {
+		if _, _, _, err := SplitMessage([]byte(msg)); err != ErrBadMessage {
+			t.Errorf("splitting %q returned %v, expected ErrBadMessage", msg, err)
+		}
+	}
+}
+
+func TestJoinMessage(t *testing.T) {
+	tests := []struct {
+		msg      string
+		expected string
+	}{
+		{"MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\nCall-ID: c1\r\n" +
+			"Content-Length: 5\r\n\r\nhello",
+			"MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\nCall-ID: c1\r\n" +
+				"Content-Length: 5\r\n\r\nhello"},
+		{"MESSAGE sip:bob@127.0.0.1 SIP/2.0\nContent-Length: 0\n\n",
+			"MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\nContent-Length: 0\r\n\r\n"},
+		{"MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\n\r\n",
+			"MESSAGE sip:bob@127.0.0.1 SIP/2.0\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		start, header, body, err := SplitMessage([]byte(test.msg))
+		if err != nil {
+			t.Fatalf("failed to split %q: %v", test.msg, err)
+		}
+
+		joined := JoinMessage(start, header, body)
+		if !bytes.Equal(joined, []byte(test.expected)) {
+			t.Errorf("joined %q, expected %q", joined, test.expected)
+		}
+	}
+}
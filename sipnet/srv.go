@@ -0,0 +1,148 @@
+package sipnet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoSRVTargets is returned by Listener.DialSRV if a domain has no SRV
+// targets to connect to.
+var ErrNoSRVTargets = errors.New("sip: no srv targets")
+
+// OrderSRV returns SRV records in the order their targets should be tried
+// as defined in RFC 2782: by ascending priority, and within a priority in a
+// weighted random order, so records with a higher weight are more likely to
+// be tried first. The records given are not modified.
+//
+// The order is chosen again on every call, so it should be called for each
+// request rather than once for cached records.
+func OrderSRV(records []*net.SRV) []*net.SRV {
+	sorted := append([]*net.SRV(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	ordered := make([]*net.SRV, 0, len(sorted))
+	for start := 0; start < len(sorted); {
+		end := start
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+
+		ordered = append(ordered, weightedOrder(sorted[start:end])...)
+		start = end
+	}
+
+	return ordered
+}
+
+// weightedOrder orders records of the same priority by repeatedly selecting
+// a random record with a probability proportional to its weight. Records
+// with a weight of zero are placed first in the list to be selected from,
+// so they have a small chance of being selected early (RFC 2782).
+func weightedOrder(records []*net.SRV) []*net.SRV {
+	remaining := make([]*net.SRV, 0, len(records))
+	for _, record := range records {
+		if record.Weight == 0 {
+			remaining = append(remaining, record)
+		}
+	}
+	for _, record := range records {
+		if record.Weight != 0 {
+			remaining = append(remaining, record)
+		}
+	}
+
+	ordered := make([]*net.SRV, 0, len(records))
+	for len(remaining) > 0 {
+		var total int
+		for _, record := range remaining {
+			total += int(record.Weight)
+		}
+
+		chosen := 0
+		n := rand.Intn(total + 1)
+		sum := 0
+		for i, record := range remaining {
+			sum += int(record.Weight)
+			if sum >= n {
+				chosen = i
+				break
+			}
+		}
+
+		ordered = append(ordered, remaining[chosen])
+		remaining = append(remaining[:chosen], remaining[chosen+1:]...)
+	}
+
+	return ordered
+}
+
+// srvName returns the SRV name of a SIP domain for a transport (RFC 3263
+// section 4.2), i.e. "_sip._udp.example.com", or "_sips._tcp.example.com"
+// for TLS.
+func srvName(domain, transport string) string {
+	switch strings.ToLower(transport) {
+	case "tls":
+		return "_sips._tcp." + domain
+	case "tcp":
+		return "_sip._tcp." + domain
+	default:
+		return "_sip._udp." + domain
+	}
+}
+
+// SRVTargets returns the addresses (host:port) of the servers of a SIP
+// domain for a transport from its SRV records, in the order they should
+// be tried as chosen by OrderSRV. If dns is nil, SystemDNS is used.
+func SRVTargets(dns DNS, domain, transport string) ([]string, error) {
+	if dns == nil {
+		dns = SystemDNS
+	}
+
+	records, _, err := dns.LookupSRV(srvName(domain, transport))
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for _, record := range OrderSRV(records) {
+		if record.Target == "." {
+			// The service is decidedly not available at the domain.
+			continue
+		}
+
+		targets = append(targets, net.JoinHostPort(
+			strings.TrimSuffix(record.Target, "."),
+			strconv.Itoa(int(record.Port))))
+	}
+
+	return targets, nil
+}
+
+// DialSRV returns a *Conn like Listener.DialConn to the first server of a
+// SIP domain from its SRV records which can be connected to, trying them
+// in the order given by SRVTargets, so a server at a lower priority is only
+// used if those at higher priorities fail. If dns is nil, SystemDNS is
+// used.
+func (l *Listener) DialSRV(dns DNS, domain, transport string) (*Conn, error) {
+	targets, err := SRVTargets(dns, domain, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	lastErr := error(ErrNoSRVTargets)
+	for _, target := range targets {
+		conn, err := l.DialConn(target, transport)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
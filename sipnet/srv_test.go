@@ -0,0 +1,114 @@
+package sipnet
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// srvDNS is a DNS answering SRV queries with fixed records.
+type srvDNS []*net.SRV
+
+func (d srvDNS) LookupSRV(name string) ([]*net.SRV, time.Duration, error) {
+	return d, time.Minute, nil
+}
+
+func (d srvDNS) LookupHost(host string) ([]string, time.Duration, error) {
+	return []string{host}, time.Minute, nil
+}
+
+// testSRVRecords are two servers at priority 10 with differing weights, and
+// a backup at priority 20.
+var testSRVRecords = []*net.SRV{
+	{Target: "backup.example.com.", Port: 5060, Priority: 20, Weight: 0},
+	{Target: "small.example.com.", Port: 5060, Priority: 10, Weight: 20},
+	{Target: "large.example.com.", Port: 5060, Priority: 10, Weight: 60},
+}
+
+func TestOrderSRV(t *testing.T) {
+	const rounds = 10000
+
+	first := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		ordered := OrderSRV(testSRVRecords)
+		if len(ordered) != 3 || ordered[2].Target != "backup.example.com." {
+			t.Fatalf("ordered %v, expected the backup last", ordered)
+		}
+		first[ordered[0].Target]++
+	}
+
+	// The server with three times the weight is tried first about three
+	// times as often.
+	share := float64(first["large.example.com."]) / rounds
+	if share < 0.70 || share > 0.80 {
+		t.Errorf("large.example.com was tried first in %.2f of the rounds, "+
+			"expected about 0.75", share)
+	}
+
+	if testSRVRecords[0].Target != "backup.example.com." {
+		t.Error("the records given were modified")
+	}
+}
+
+func TestSRVTargets(t *testing.T) {
+	dns := srvDNS{
+		{Target: "sip.example.com.", Port: 5070, Priority: 10, Weight: 1},
+		{Target: ".", Port: 0, Priority: 20, Weight: 0},
+	}
+
+	targets, err := SRVTargets(dns, "example.com", "tcp")
+	if err != nil {
+		t.Fatalf("failed to look up: %v", err)
+	}
+	if !reflect.DeepEqual(targets, []string{"sip.example.com:5070"}) {
+		t.Errorf("got targets %v, expected only sip.example.com:5070", targets)
+	}
+
+	for transport, expected := range map[string]string{
+		"udp": "_sip._udp.example.com",
+		"tcp": "_sip._tcp.example.com",
+		"tls": "_sips._tcp.example.com",
+	} {
+		if name := srvName("example.com", transport); name != expected {
+			t.Errorf("SRV name for %s is %q, expected %q", transport, name,
+				expected)
+		}
+	}
+}
+
+func TestDialSRVFailover(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+
+	// The server at the higher priority refuses connections, so the backup
+	// is dialed.
+	refused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	refusedPort := refused.Addr().(*net.TCPAddr).Port
+	refused.Close()
+
+	backup, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer backup.Close()
+	backupPort := backup.Addr().(*net.TCPAddr).Port
+
+	dns := srvDNS{
+		{Target: "127.0.0.1.", Port: uint16(backupPort), Priority: 20, Weight: 0},
+		{Target: "127.0.0.1.", Port: uint16(refusedPort), Priority: 10, Weight: 0},
+	}
+	conn, err := l.DialSRV(dns, "example.com", "tcp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if addr := conn.Addr().String(); addr != "127.0.0.1:"+strconv.Itoa(backupPort) {
+		t.Errorf("dialed %s, expected the backup", addr)
+	}
+}
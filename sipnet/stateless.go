@@ -0,0 +1,137 @@
+package sipnet
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SendStatelessResponse sends a response to the address given by its top Via
+// (RFC 3261 section 18.2.2), without creating any transaction state, so it
+// is neither cached nor re-sent when the request is retransmitted. The
+// response must already have the Via, From, To, Call-ID and CSeq of the
+// request it responds to.
+//
+// The response is sent to the received host (or the sent-by host), and the
+// rport port (or the sent-by port), over the transport of the Via. Over TCP,
+// an existing connection to that address is used if there is one, or a new
+// one is dialed.
+func (l *Listener) SendStatelessResponse(resp *Response) error {
+	vias := splitVias(resp.Header)
+	if len(vias) == 0 {
+		return ErrParseError
+	}
+
+	via, err := ParseVia(vias[0])
+	if err != nil {
+		return err
+	}
+
+	conn, err := l.statelessConn(via)
+	if err != nil {
+		return err
+	}
+
+	resp = conn.outboundResponse(resp)
+	if resp == nil {
+		return nil
+	}
+
+	conn.sendMutex.Lock()
+	defer conn.sendMutex.Unlock()
+
+	status := resp.Status
+	if status == "" {
+		status = conn.reasonPhrase(nil, resp.StatusCode)
+	}
+
+	_, err = conn.Write([]byte(SIPVersion + " " +
+		strconv.Itoa(resp.StatusCode) + " " + status + "\r\n"))
+	if err != nil {
+		return err
+	}
+
+	conn.setContentLength(resp.Header, len(resp.Body))
+	_, err = writeHeader(conn, resp.Header, resp.RawHeaders)
+	if err != nil {
+		return err
+	}
+
+	conn.Write(resp.Body)
+	return conn.Flush()
+}
+
+// SendStatelessResponseBytes sends a serialized response to the address
+// given by its top Via like SendStatelessResponse, finding the top Via with
+// TopVia rather than parsing the response. The response is sent as it is,
+// without passing through the OutboundMiddleware.
+func (l *Listener) SendStatelessResponseBytes(data []byte) error {
+	via, err := TopVia(data)
+	if err != nil {
+		return err
+	}
+
+	conn, err := l.statelessConn(*via)
+	if err != nil {
+		return err
+	}
+
+	conn.sendMutex.Lock()
+	defer conn.sendMutex.Unlock()
+
+	_, err = conn.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return conn.Flush()
+}
+
+// statelessConn returns the connection a response with the given top Via
+// is sent over, dialing a new TCP connection if there is none.
+func (l *Listener) statelessConn(via Via) (*Conn, error) {
+	addr := viaDestination(via)
+	switch strings.ToLower(via.Transport) {
+	case TCP.Name():
+		l.tcpConnsMutex.Lock()
+		conn := l.tcpConns[addr]
+		l.tcpConnsMutex.Unlock()
+
+		if conn == nil || conn.Closed {
+			netConn, err := TCP.Dial(addr)
+			if err != nil {
+				return nil, err
+			}
+			conn = l.registerStreamConn(TCP, netConn)
+		}
+		return conn, nil
+	case UDP.Name():
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return l.getUDPConnFromPool(udpAddr), nil
+	default:
+		return nil, ErrInvalidTransport
+	}
+}
+
+// viaDestination returns the address a response is sent to for a Via,
+// which is its received (or sent-by) host, and its rport (or sent-by) port.
+func viaDestination(via Via) string {
+	host, port, err := net.SplitHostPort(via.Client)
+	if err != nil {
+		host = strings.Trim(via.Client, "[]")
+		port = strconv.Itoa(DefaultPort)
+	}
+
+	if received := via.Arguments.Get("received"); received != "" {
+		host = received
+	}
+
+	if rport := via.Arguments.Get("rport"); rport != "" {
+		port = rport
+	}
+
+	return net.JoinHostPort(host, port)
+}
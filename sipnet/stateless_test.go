@@ -0,0 +1,87 @@
+package sipnet
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// statelessResponse returns a 200 with the given top Via.
+func statelessResponse(via string) *Response {
+	resp := NewResponse()
+	resp.StatusCode = StatusOK
+	resp.Header.Set("Via", via)
+	resp.Header.Set("From", "<sip:alice@127.0.0.1>;tag=a1")
+	resp.Header.Set("To", "<sip:bob@127.0.0.1>;tag=b1")
+	resp.Header.Set("Call-ID", "call1@127.0.0.1")
+	resp.Header.Set("CSeq", "1 MESSAGE")
+	return resp
+}
+
+func TestSendStatelessResponse(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+	port := strconv.Itoa(peer.LocalAddr().(*net.UDPAddr).Port)
+
+	tests := []struct {
+		name string
+		via  string
+	}{
+		{"sent-by", "SIP/2.0/UDP 127.0.0.1:" + port + ";branch=z9hG4bK1"},
+		{"received and rport", "SIP/2.0/UDP 192.0.2.1:5070" +
+			";received=127.0.0.1;rport=" + port + ";branch=z9hG4bK2"},
+	}
+
+	for _, test := range tests {
+		if err := l.SendStatelessResponse(statelessResponse(test.via)); err != nil {
+			t.Fatalf("%s: failed to send: %v", test.name, err)
+		}
+
+		data, from := readUDP(t, peer)
+		if startLine(data) != "SIP/2.0 200 OK" {
+			t.Errorf("%s: received %q, expected the 200", test.name,
+				startLine(data))
+		}
+		if from.String() != l.TransportAddr("udp").String() {
+			t.Errorf("%s: sent from %v, expected the listener's port",
+				test.name, from)
+		}
+	}
+}
+
+func TestSendStatelessResponseTCP(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+
+	peer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer peer.Close()
+
+	via := "SIP/2.0/TCP " + peer.Addr().String() + ";branch=z9hG4bK1"
+	errs := goWrite(func() error {
+		return l.SendStatelessResponse(statelessResponse(via))
+	})
+
+	remote, err := peer.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer remote.Close()
+
+	remote.SetReadDeadline(time.Now().Add(testTimeout))
+	resp, err := ReadResponse(remote)
+	if err != nil {
+		t.Fatalf("failed to read the response: %v", err)
+	}
+	if resp.StatusCode != StatusOK {
+		t.Errorf("received %d, expected 200", resp.StatusCode)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("failed to send: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package sipnet
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats is a snapshot of the statistics of a Conn.
+type ConnStats struct {
+	// BytesIn and BytesOut are the bytes received and sent, including
+	// keep-alives.
+	BytesIn  uint64
+	BytesOut uint64
+
+	// MessagesIn and MessagesOut are the requests and responses received
+	// and sent, including retransmissions.
+	MessagesIn  uint64
+	MessagesOut uint64
+
+	// Retransmissions are the retransmitted requests and responses received
+	// and sent.
+	Retransmissions uint64
+
+	// Dropped and Oversized are as returned by Conn.Dropped and
+	// Conn.Oversized.
+	Dropped   uint64
+	Oversized uint64
+
+	// Transactions is the number of client transactions in progress, and
+	// server transactions remembered to absorb retransmissions, which
+	// excludes those recorded in a configured BranchStore.
+	Transactions int
+
+	// LastActivity is when data was last received or sent.
+	LastActivity time.Time
+}
+
+// connCounters are the counters of a Conn, which are accessed atomically.
+type connCounters struct {
+	bytesIn            uint64
+	bytesOut           uint64
+	messagesIn         uint64
+	messagesOut        uint64
+	retransmissions    uint64
+	clientTransactions int64
+	lastActivity       int64
+}
+
+// Stats returns a snapshot of the statistics of the connection.
+func (c *Conn) Stats() ConnStats {
+	c.BranchMutex.Lock()
+	transactions := len(c.ReceivedBranches)
+	c.BranchMutex.Unlock()
+
+	var lastActivity time.Time
+	if nanos := atomic.LoadInt64(&c.counters.lastActivity); nanos != 0 {
+		lastActivity = time.Unix(0, nanos)
+	}
+
+	return ConnStats{
+		BytesIn:         atomic.LoadUint64(&c.counters.bytesIn),
+		BytesOut:        atomic.LoadUint64(&c.counters.bytesOut),
+		MessagesIn:      atomic.LoadUint64(&c.counters.messagesIn),
+		MessagesOut:     atomic.LoadUint64(&c.counters.messagesOut),
+		Retransmissions: atomic.LoadUint64(&c.counters.retransmissions),
+		Dropped:         c.Dropped(),
+		Oversized:       c.Oversized(),
+		Transactions: transactions +
+			int(atomic.LoadInt64(&c.counters.clientTransactions)),
+		LastActivity: lastActivity,
+	}
+}
+
+// countIn records data received by the connection.
+func (c *Conn) countIn(n int) {
+	atomic.AddUint64(&c.counters.bytesIn, uint64(n))
+	atomic.StoreInt64(&c.counters.lastActivity, c.clock().Now().UnixNano())
+}
+
+// countOut records data sent by the connection.
+func (c *Conn) countOut(n int) {
+	atomic.AddUint64(&c.counters.bytesOut, uint64(n))
+	atomic.StoreInt64(&c.counters.lastActivity, c.clock().Now().UnixNano())
+}
+
+// countRetransmission records a retransmitted message received or sent.
+func (c *Conn) countRetransmission() {
+	atomic.AddUint64(&c.counters.retransmissions, 1)
+}
+
+// countingReader counts the bytes read from a stream connection.
+type countingReader struct {
+	conn *Conn
+	rd   io.Reader
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.conn.countIn(n)
+	return n, err
+}
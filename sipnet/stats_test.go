@@ -0,0 +1,47 @@
+package sipnet
+
+import "testing"
+
+func TestStatsAfterExchange(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	msg := testRequest(MethodMessage, "z9hG4bKstats")
+	writePipe(t, remote, msg)
+	req := readRequest(t, conn)
+	errs := respond(conn, req, StatusOK, "b1")
+	sent := readPipe(t, remote)
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to respond: %v", err)
+	}
+
+	stats := conn.Stats()
+	expected := ConnStats{
+		BytesIn:      uint64(len(msg)),
+		BytesOut:     uint64(len(sent)),
+		MessagesIn:   1,
+		MessagesOut:  1,
+		Transactions: 1,
+		LastActivity: stats.LastActivity,
+	}
+	if stats != expected {
+		t.Errorf("stats are %+v, expected %+v", stats, expected)
+	}
+	if stats.LastActivity.IsZero() {
+		t.Error("no last activity recorded")
+	}
+
+	// The retransmission is absorbed and answered with the cached response.
+	writePipe(t, remote, msg)
+	readPipe(t, remote)
+	expected.BytesIn *= 2
+	expected.BytesOut *= 2
+	expected.MessagesIn = 2
+	expected.MessagesOut = 2
+	expected.Retransmissions = 2
+	waitFor(t, "the retransmission to be counted", func() bool {
+		stats = conn.Stats()
+		expected.LastActivity = stats.LastActivity
+		return stats == expected
+	})
+}
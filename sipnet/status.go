@@ -8,7 +8,8 @@ const (
 	StatusQueued               = 182
 	StatusSessionProgress      = 183
 
-	StatusOK = 200
+	StatusOK       = 200
+	StatusAccepted = 202
 
 	StatusMultipleChoices    = 300
 	StatusMovedPermanently   = 301
@@ -42,6 +43,7 @@ const (
 	StatusBusyHere                    = 486
 	StatusRequestTerminated           = 487
 	StatusNotAcceptableHere           = 488
+	StatusBadEvent                    = 489
 	StatusRequestPending              = 491
 	StatusUndecipherable              = 493
 
@@ -66,6 +68,7 @@ var statusTexts = map[int]string{
 	StatusQueued:                      "Queued",
 	StatusSessionProgress:             "Session Progress",
 	StatusOK:                          "OK",
+	StatusAccepted:                    "Accepted",
 	StatusMultipleChoices:             "Multiple Choices",
 	StatusMovedPermanently:            "Moved Permanently",
 	StatusMovedTemporarily:            "Moved Temporarily",
@@ -97,6 +100,7 @@ var statusTexts = map[int]string{
 	StatusBusyHere:                    "Busy Here",
 	StatusRequestTerminated:           "Request Terminated",
 	StatusNotAcceptableHere:           "Not Acceptable Here",
+	StatusBadEvent:                    "Bad Event",
 	StatusRequestPending:              "Request Pending",
 	StatusUndecipherable:              "Undecipherable",
 	StatusServerInternalError:         "Server Internal Error",
@@ -112,6 +116,16 @@ var statusTexts = map[int]string{
 	StatusUnacceptable:                "Not Acceptable",
 }
 
+// DefaultStatusTexts returns a copy of the reason phrases of RFC 3261 by
+// status code, to be used as a base for Config.StatusTexts.
+func DefaultStatusTexts() map[int]string {
+	texts := make(map[int]string, len(statusTexts))
+	for code, text := range statusTexts {
+		texts[code] = text
+	}
+	return texts
+}
+
 // StatusText returns the human readable text representation of a status code.
 func StatusText(code int) string {
 	return statusTexts[code]
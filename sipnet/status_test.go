@@ -0,0 +1,57 @@
+package sipnet
+
+import "testing"
+
+func TestDefaultStatusTexts(t *testing.T) {
+	texts := DefaultStatusTexts()
+	if texts[StatusBusyHere] != "Busy Here" {
+		t.Errorf("486 is %q, expected \"Busy Here\"", texts[StatusBusyHere])
+	}
+
+	// The returned table is a copy.
+	texts[StatusBusyHere] = "Changed"
+	if text := StatusText(StatusBusyHere); text != "Busy Here" {
+		t.Errorf("changing the copy changed 486 to %q", text)
+	}
+}
+
+func TestStatusTextOverride(t *testing.T) {
+	l := listenTest(t, Config{StatusTexts: map[int]string{
+		StatusBusyHere: "Busy Being Awesome",
+	}})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	tests := []struct {
+		statusCode int
+		status     string
+		expected   string
+	}{
+		{StatusBusyHere, "", "SIP/2.0 486 Busy Being Awesome"},
+		{StatusNotFound, "", "SIP/2.0 404 Not Found"},
+		{StatusBusyHere, "Gone Fishing", "SIP/2.0 486 Gone Fishing"},
+	}
+
+	for i, test := range tests {
+		branch := "z9hG4bKstatus" + string(rune('a'+i))
+		sendUDP(t, peer, l, testRequest(MethodMessage, branch))
+		req, conn := acceptRequest(t, l)
+
+		resp := NewResponse()
+		resp.StatusCode = test.statusCode
+		resp.Status = test.status
+		resp.Header.Set("From", req.Header.Get("From"))
+		resp.Header.Set("To", req.Header.Get("To")+";tag=b1")
+		if err := <-goWrite(func() error {
+			return resp.WriteTo(conn, req)
+		}); err != nil {
+			t.Fatalf("failed to respond: %v", err)
+		}
+
+		if data, _ := readUDP(t, peer); startLine(data) != test.expected {
+			t.Errorf("%d %q: got %q, expected %q", test.statusCode,
+				test.status, startLine(data), test.expected)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package sipnet
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// StreamLimitPolicy determines what happens to new stream connections
+// once a listener has MaxStreamConns open.
+type StreamLimitPolicy int
+
+// Policies for new stream connections beyond MaxStreamConns.
+const (
+	// StreamLimitReject accepts new connections and closes them
+	// immediately.
+	StreamLimitReject StreamLimitPolicy = iota
+	// StreamLimitWait stops accepting connections until one of the open
+	// connections closes, leaving new connections in the backlog of the
+	// socket.
+	StreamLimitWait
+)
+
+// atStreamLimit returns whether the listener has MaxStreamConns open,
+// counting it in StreamLimited and logging it the first time the limit is
+// reached since a connection last closed.
+func (l *Listener) atStreamLimit() bool {
+	max := l.config.MaxStreamConns
+	if max <= 0 || l.StreamConns() < max {
+		return false
+	}
+
+	atomic.AddUint64(&l.streamLimited, 1)
+	if atomic.CompareAndSwapUint32(&l.streamLimitLogged, 0, 1) {
+		fmt.Println("warning: listener reached its limit of", max,
+			"stream connections")
+	}
+	return true
+}
+
+// waitStreamSlot blocks until the listener has fewer than MaxStreamConns
+// open, or is closed, with the StreamLimitWait policy.
+func (l *Listener) waitStreamSlot() {
+	if l.config.StreamLimitPolicy != StreamLimitWait || !l.atStreamLimit() {
+		return
+	}
+
+	for !l.closed && l.StreamConns() >= l.config.MaxStreamConns {
+		select {
+		case <-l.streamFreed:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// rejectStream returns whether a connection accepted from a stream listener
+// is closed because the listener has MaxStreamConns open.
+func (l *Listener) rejectStream(netConn net.Conn) bool {
+	if l.config.StreamLimitPolicy != StreamLimitReject || !l.atStreamLimit() {
+		return false
+	}
+
+	netConn.Close()
+	return true
+}
+
+// streamOpened records a stream connection registered with the listener.
+func (l *Listener) streamOpened() {
+	atomic.AddInt64(&l.streamConns, 1)
+}
+
+// streamClosed records a stream connection of the listener having closed,
+// waking up an acceptor waiting for a slot.
+func (l *Listener) streamClosed() {
+	atomic.AddInt64(&l.streamConns, -1)
+	atomic.StoreUint32(&l.streamLimitLogged, 0)
+
+	select {
+	case l.streamFreed <- struct{}{}:
+	default:
+	}
+}
+
+// StreamConns returns the number of open stream connections of the
+// listener, whether accepted or dialed.
+func (l *Listener) StreamConns() int {
+	return int(atomic.LoadInt64(&l.streamConns))
+}
+
+// StreamLimited returns the number of stream connections that have been
+// rejected, or delayed with the StreamLimitWait policy, because the
+// listener had MaxStreamConns open.
+func (l *Listener) StreamLimited() uint64 {
+	return atomic.LoadUint64(&l.streamLimited)
+}
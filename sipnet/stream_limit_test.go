@@ -0,0 +1,104 @@
+package sipnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialListener dials a TCP connection to the listener, which must be
+// registered by it.
+func dialListener(t *testing.T, l *Listener) net.Conn {
+	t.Helper()
+
+	remote, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	remote.SetDeadline(time.Now().Add(testTimeout))
+	return remote
+}
+
+// tcpRequest returns a request of testRequest with a TCP Via.
+func tcpRequest(method, branch string) string {
+	return strings.Replace(testRequest(method, branch), "SIP/2.0/UDP",
+		"SIP/2.0/TCP", 1)
+}
+
+func TestStreamLimitRejects(t *testing.T) {
+	l := listenTest(t, Config{MaxStreamConns: 2})
+	defer l.Close()
+
+	first := dialListener(t, l)
+	defer first.Close()
+	second := dialListener(t, l)
+	defer second.Close()
+	waitFor(t, "the connections to be registered", func() bool {
+		return l.StreamConns() == 2
+	})
+
+	// A connection beyond the limit is closed.
+	rejected := dialListener(t, l)
+	defer rejected.Close()
+	if _, err := rejected.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("rejected connection read with %v, expected it closed", err)
+	}
+	if limited := l.StreamLimited(); limited != 1 {
+		t.Errorf("%d connections limited, expected 1", limited)
+	}
+
+	// The open connections are unaffected.
+	first.Write([]byte(tcpRequest(MethodMessage, "z9hG4bKopen")))
+	req, conn := acceptRequest(t, l)
+	if err := <-respond(conn, req, StatusOK, "b1"); err != nil {
+		t.Fatalf("failed to respond: %v", err)
+	}
+	if resp, err := ReadResponse(first); err != nil ||
+		resp.StatusCode != StatusOK {
+		t.Fatalf("open connection got %v, %v, expected a 200", resp, err)
+	}
+
+	// Once a connection closes, new ones are accepted again.
+	second.Close()
+	waitFor(t, "the connection to close", func() bool {
+		return l.StreamConns() == 1
+	})
+	third := dialListener(t, l)
+	defer third.Close()
+	waitFor(t, "the new connection to be registered", func() bool {
+		return l.StreamConns() == 2
+	})
+}
+
+func TestStreamLimitWaits(t *testing.T) {
+	l := listenTest(t, Config{
+		MaxStreamConns:    1,
+		StreamLimitPolicy: StreamLimitWait,
+	})
+	defer l.Close()
+
+	first := dialListener(t, l)
+	defer first.Close()
+	waitFor(t, "the connection to be registered", func() bool {
+		return l.StreamConns() == 1
+	})
+
+	// The next connection stays in the backlog, with its request unread.
+	waiting := dialListener(t, l)
+	defer waiting.Close()
+	waiting.Write([]byte(tcpRequest(MethodMessage, "z9hG4bKwaiting")))
+	waitFor(t, "the connection to be limited", func() bool {
+		return l.StreamLimited() == 1
+	})
+	time.Sleep(quietTimeout)
+	if conns := l.StreamConns(); conns != 1 {
+		t.Fatalf("%d connections open, expected the limit of 1", conns)
+	}
+
+	first.Close()
+	req, _ := acceptRequest(t, l)
+	if via := req.Header.Get("Via"); !strings.Contains(via, "z9hG4bKwaiting") {
+		t.Errorf("accepted %q, expected the waiting request", via)
+	}
+}
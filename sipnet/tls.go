@@ -0,0 +1,153 @@
+package sipnet
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoCertificate is returned when listening over TLS without a
+// certificate configured.
+var ErrNoCertificate = errors.New("sip: no TLS certificate configured")
+
+// TLSTransport is a stream transport over TLS, used for sips URIs. Its zero
+// value dials with the default TLS configuration, verifying the server's
+// certificate against the dialed host.
+type TLSTransport struct {
+	// Config is the TLS configuration used to dial and listen. If its
+	// ServerName is empty, the host being dialed is sent as the SNI and the
+	// server's certificate is verified against it. Listening requires its
+	// Certificates (or GetCertificate or GetConfigForClient) to be set. It
+	// must not be modified once the transport is in use, but can be
+	// replaced with SetConfig.
+	Config *tls.Config
+
+	// ClientCertificate returns the client certificate to present to the
+	// server with the given name when it requests one, so a different
+	// certificate can be used for each destination. If nil, the
+	// certificates of Config are presented.
+	ClientCertificate func(serverName string,
+		info *tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+	mutex   sync.RWMutex
+	updated *tls.Config
+}
+
+// SetConfig replaces the TLS configuration of the transport, such as to
+// rotate its certificate without restarting. Connections already
+// established are unaffected, while the handshakes of connections accepted
+// by its listeners and of connections dialed afterwards use the new
+// configuration. It is safe to call while the transport is in use.
+func (t *TLSTransport) SetConfig(config *tls.Config) {
+	t.mutex.Lock()
+	t.updated = config
+	t.mutex.Unlock()
+}
+
+// config returns the configuration set with SetConfig, or Config if none
+// was.
+func (t *TLSTransport) config() *tls.Config {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.updated != nil {
+		return t.updated
+	}
+	return t.Config
+}
+
+// TLS is the built in TLS transport, with the default configuration.
+var TLS Transport = &TLSTransport{}
+
+// Name returns "tls".
+func (*TLSTransport) Name() string {
+	return "tls"
+}
+
+// IsStream returns true.
+func (*TLSTransport) IsStream() bool {
+	return true
+}
+
+// clientConfig returns the configuration used to dial addr.
+func (t *TLSTransport) clientConfig(addr string) *tls.Config {
+	config := new(tls.Config)
+	if current := t.config(); current != nil {
+		config = current.Clone()
+	}
+
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = strings.Trim(host, "[]")
+	}
+
+	if t.ClientCertificate != nil {
+		serverName := config.ServerName
+		config.GetClientCertificate = func(
+			info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return t.ClientCertificate(serverName, info)
+		}
+	}
+
+	return config
+}
+
+// Dial creates a TLS connection to a SIP UA at addr, sending the host of
+// addr as the SNI unless the Config has a ServerName.
+func (t *TLSTransport) Dial(addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: time.Second * 10}
+	return tls.DialWithDialer(dialer, "tcp", addr, t.clientConfig(addr))
+}
+
+// Listen listens for TLS connections on addr. The configuration of the
+// transport is looked up for each handshake, so a configuration set with
+// SetConfig applies to connections accepted afterwards.
+func (t *TLSTransport) Listen(addr string) (net.Listener, error) {
+	config := t.config()
+	if config == nil || (len(config.Certificates) == 0 &&
+		config.GetCertificate == nil && config.GetConfigForClient == nil) {
+		return nil, ErrNoCertificate
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(ln, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			config := t.config()
+			if config == nil {
+				return nil, ErrNoCertificate
+			}
+
+			if config.GetConfigForClient != nil {
+				clientConfig, err := config.GetConfigForClient(hello)
+				if err != nil || clientConfig != nil {
+					return clientConfig, err
+				}
+			}
+			return config, nil
+		},
+	}), nil
+}
+
+// ListenPacket returns ErrInvalidTransport, as TLS is a stream transport.
+func (*TLSTransport) ListenPacket(addr string) (net.PacketConn, error) {
+	return nil, ErrInvalidTransport
+}
+
+// ReadFrame returns ErrInvalidTransport, as TLS is a stream transport.
+func (*TLSTransport) ReadFrame(conn net.PacketConn) ([]byte, net.Addr, error) {
+	return nil, nil, ErrInvalidTransport
+}
+
+// WriteFrame returns ErrInvalidTransport, as TLS is a stream transport.
+func (*TLSTransport) WriteFrame(conn net.PacketConn, addr net.Addr, b []byte) error {
+	return ErrInvalidTransport
+}
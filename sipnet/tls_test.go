@@ -0,0 +1,252 @@
+package sipnet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for host, and a pool
+// trusting it.
+func testCertificate(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key,
+		Leaf: cert}, pool
+}
+
+// handshake is the ClientHello and client certificates received by a TLS
+// server.
+type handshake struct {
+	serverName string
+	clientCert bool
+}
+
+// tlsServer listens for TLS connections on the loopback interface with a
+// certificate for localhost, sending the handshake of each connection
+// to the returned channel. It returns the port listened on.
+func tlsServer(t *testing.T) (net.Listener, int, *x509.CertPool, <-chan handshake) {
+	t.Helper()
+
+	cert, pool := testCertificate(t, "localhost")
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	handshakes := make(chan handshake, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			tlsConn := conn.(*tls.Conn)
+			tlsConn.SetDeadline(time.Now().Add(testTimeout))
+			if tlsConn.Handshake() == nil {
+				state := tlsConn.ConnectionState()
+				handshakes <- handshake{state.ServerName,
+					len(state.PeerCertificates) > 0}
+			}
+			conn.Close()
+		}
+	}()
+
+	return l, l.Addr().(*net.TCPAddr).Port, pool, handshakes
+}
+
+func TestTLSDialSendsHost(t *testing.T) {
+	l, port, pool, handshakes := tlsServer(t)
+	defer l.Close()
+
+	transport := &TLSTransport{Config: &tls.Config{RootCAs: pool}}
+	conn, err := transport.Dial("localhost:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case hello := <-handshakes:
+		if hello.serverName != "localhost" {
+			t.Errorf("server received SNI %q, expected localhost",
+				hello.serverName)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("handshake wasn't completed")
+	}
+}
+
+func TestTLSDialVerifiesHost(t *testing.T) {
+	l, port, pool, _ := tlsServer(t)
+	defer l.Close()
+
+	// The certificate of localhost isn't valid for 127.0.0.1.
+	transport := &TLSTransport{Config: &tls.Config{RootCAs: pool}}
+	if conn, err := transport.Dial("127.0.0.1:" + strconv.Itoa(port)); err == nil {
+		conn.Close()
+		t.Fatal("dialed a host the certificate isn't valid for")
+	}
+
+	transport.Config.ServerName = "localhost"
+	conn, err := transport.Dial("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("failed to dial with an explicit ServerName: %v", err)
+	}
+	conn.Close()
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	l, port, pool, handshakes := tlsServer(t)
+	defer l.Close()
+
+	clientCert, _ := testCertificate(t, "client.example.com")
+	requested := make(chan string, 1)
+	transport := &TLSTransport{
+		Config: &tls.Config{RootCAs: pool, ServerName: "localhost"},
+		ClientCertificate: func(serverName string,
+			info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			requested <- serverName
+			return &clientCert, nil
+		},
+	}
+
+	conn, err := transport.Dial("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if serverName := <-requested; serverName != "localhost" {
+		t.Errorf("certificate requested for %q, expected localhost",
+			serverName)
+	}
+	select {
+	case hello := <-handshakes:
+		if !hello.clientCert {
+			t.Error("client certificate wasn't presented")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("handshake wasn't completed")
+	}
+}
+
+// handshakeAll completes the handshake of every connection accepted by l
+// until it's closed.
+func handshakeAll(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(testTimeout))
+			conn.(*tls.Conn).Handshake()
+			conn.Read(make([]byte, 1))
+		}()
+	}
+}
+
+func TestTLSCertificateRotation(t *testing.T) {
+	oldCert, oldPool := testCertificate(t, "localhost")
+	newCert, newPool := testCertificate(t, "localhost")
+
+	transport := &TLSTransport{Config: &tls.Config{
+		Certificates: []tls.Certificate{oldCert},
+	}}
+	l, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	go handshakeAll(l)
+
+	dial := func(pool *x509.CertPool) (*tls.Conn, error) {
+		return tls.Dial("tcp", l.Addr().String(), &tls.Config{
+			RootCAs:    pool,
+			ServerName: "localhost",
+		})
+	}
+
+	before, err := dial(oldPool)
+	if err != nil {
+		t.Fatalf("failed to dial with the old certificate: %v", err)
+	}
+	defer before.Close()
+
+	transport.SetConfig(&tls.Config{Certificates: []tls.Certificate{newCert}})
+
+	if conn, err := dial(oldPool); err == nil {
+		conn.Close()
+		t.Fatal("new connection still presented the old certificate")
+	}
+	after, err := dial(newPool)
+	if err != nil {
+		t.Fatalf("failed to dial with the new certificate: %v", err)
+	}
+	defer after.Close()
+
+	peer := after.ConnectionState().PeerCertificates[0]
+	if !peer.Equal(newCert.Leaf) {
+		t.Error("new connection didn't present the new certificate")
+	}
+	peer = before.ConnectionState().PeerCertificates[0]
+	if !peer.Equal(oldCert.Leaf) {
+		t.Error("established connection changed certificate")
+	}
+}
+
+func TestTLSListenRequiresCertificate(t *testing.T) {
+	transports := []*TLSTransport{
+		{},
+		{Config: &tls.Config{}},
+	}
+
+	for i, transport := range transports {
+		if l, err := transport.Listen("127.0.0.1:0"); err != ErrNoCertificate {
+			if err == nil {
+				l.Close()
+			}
+			t.Errorf("%d: listened with %v, expected ErrNoCertificate", i, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package sipnet
+
+// TopVia returns the top Via of a raw message, scanning only as far as the
+// first Via header ("Via" or its compact form "v") rather than parsing the
+// whole message, such as to route a response in the hot path.
+// ErrParseError is returned if the message has no Via.
+func TopVia(msg []byte) (*Via, error) {
+	s := NewHeaderScanner(msg)
+	for s.Scan() {
+		if !s.Is("Via") && !s.Is("v") {
+			continue
+		}
+
+		vias := splitVias(Header{"Via": {s.String()}})
+		via, err := ParseVia(vias[0])
+		if err != nil {
+			return nil, err
+		}
+		return &via, nil
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrParseError
+}
@@ -0,0 +1,67 @@
+package sipnet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTopVia(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKtop"))
+	tests := []struct {
+		name   string
+		msg    string
+		client string
+		branch string
+	}{
+		{"response", testResponse(req, "200 OK"), "127.0.0.1:5070", "z9hG4bKtop"},
+		{"several Vias", strings.Replace(testResponse(req, "200 OK"),
+			"Via: ", "Via: SIP/2.0/TCP 10.0.0.1;branch=z9hG4bKproxy, ", 1),
+			"10.0.0.1", "z9hG4bKproxy"},
+		{"compact form", strings.Replace(testResponse(req, "200 OK"),
+			"Via: ", "v: ", 1), "127.0.0.1:5070", "z9hG4bKtop"},
+	}
+
+	for _, test := range tests {
+		via, err := TopVia([]byte(test.msg))
+		if err != nil {
+			t.Errorf("%s: failed to find the top Via: %v", test.name, err)
+			continue
+		}
+		if via.Client != test.client || via.Arguments.Get("branch") != test.branch {
+			t.Errorf("%s: top Via is %s with branch %q", test.name, via.Client,
+				via.Arguments.Get("branch"))
+		}
+	}
+
+	withoutVia := strings.Replace(testResponse(req, "200 OK"), "Via: ",
+		"X-Via: ", 1)
+	if _, err := TopVia([]byte(withoutVia)); err != ErrParseError {
+		t.Errorf("message without a Via returned %v, expected ErrParseError",
+			err)
+	}
+}
+
+func BenchmarkTopVia(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := TopVia(benchmarkMessage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTopViaFullParse finds the top Via by parsing the whole message,
+// for comparison with BenchmarkTopVia.
+func BenchmarkTopViaFullParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req, err := ReadRequest(bytes.NewReader(benchmarkMessage))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := ParseVia(splitVias(req.Header)[0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package sipnet
+
+import "sync"
+
+// topologyParam is the URI parameter of a Contact replaced by a
+// ContactRewriter which identifies the original Contact.
+const topologyParam = "th"
+
+// rewrittenContact is an original Contact replaced by a ContactRewriter.
+type rewrittenContact struct {
+	callID   string
+	original URI
+}
+
+// ContactRewriter hides the topology of the network behind an SBC. The
+// Contacts of messages it forwards are replaced with its own address, so
+// in-dialog requests are sent through it, and the original Contact is
+// restored as the target of those requests. The mappings of a dialog are
+// kept until it is removed with Remove. The zero value is ready to use, and
+// it is safe to use from multiple goroutines.
+type ContactRewriter struct {
+	mutex     sync.Mutex
+	contacts  map[string]rewrittenContact
+	byContact map[string]string
+}
+
+// contactKey returns the key of an original Contact within a dialog.
+func contactKey(callID string, uri URI) string {
+	return callID + " " + uri.String()
+}
+
+// token returns the token identifying an original Contact within a dialog,
+// the same one each time the Contact is rewritten for the dialog.
+func (r *ContactRewriter) token(callID string, original URI) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.contacts == nil {
+		r.contacts = make(map[string]rewrittenContact)
+		r.byContact = make(map[string]string)
+	}
+
+	key := contactKey(callID, original)
+	if token, found := r.byContact[key]; found {
+		return token
+	}
+
+	token := randomHex(8)
+	r.contacts[token] = rewrittenContact{
+		callID:   callID,
+		original: original,
+	}
+	r.byContact[key] = token
+	return token
+}
+
+// Rewrite replaces the Contacts of a request or response header about to be
+// forwarded over conn with the Contact of conn, keeping their header
+// parameters. The original Contacts are remembered for the dialog of the
+// header's Call-ID.
+func (r *ContactRewriter) Rewrite(h Header, conn *Conn) error {
+	contacts, err := ParseUsers(h, "Contact")
+	if err != nil || len(contacts) == 0 {
+		return err
+	}
+
+	callID := h.Get("Call-ID")
+	h.Del("Contact")
+	for _, contact := range contacts {
+		replaced := conn.Contact("")
+		replaced.URI.Arguments.Set(topologyParam, r.token(callID,
+			contact.URI))
+		replaced.Arguments = contact.Arguments
+		h.Add("Contact", replaced.String())
+	}
+
+	return nil
+}
+
+// Original returns the original Contact a Contact returned by Rewrite
+// replaced, and whether it is known.
+func (r *ContactRewriter) Original(uri URI) (URI, bool) {
+	token := uri.Arguments.Get(topologyParam)
+	if token == "" {
+		return URI{}, false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	contact, found := r.contacts[token]
+	return contact.original, found
+}
+
+// Restore reverses the rewriting of a Contact for a received in-dialog
+// request targeting a rewritten Contact, by replacing its Request-URI with
+// the original Contact. It returns whether the request was restored.
+func (r *ContactRewriter) Restore(req *Request) bool {
+	uri, err := req.RequestURI()
+	if err != nil {
+		return false
+	}
+
+	original, found := r.Original(uri)
+	if !found {
+		return false
+	}
+
+	req.Server = original.String()
+	return true
+}
+
+// Remove removes the mappings of the dialog with the given Call-ID, such as
+// once it has been terminated with a BYE.
+func (r *ContactRewriter) Remove(callID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for token, contact := range r.contacts {
+		if contact.callID == callID {
+			delete(r.contacts, token)
+			delete(r.byContact, contactKey(callID, contact.original))
+		}
+	}
+}
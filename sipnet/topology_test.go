@@ -0,0 +1,57 @@
+package sipnet
+
+import "testing"
+
+func TestContactRewriteRoundTrip(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+	defer remote.Close()
+
+	var rewriter ContactRewriter
+	invite := parseRequest(t, testRequest(MethodInvite, "z9hG4bKhide",
+		"Contact: <sip:alice@10.0.0.5:5060>;expires=60"))
+	if err := rewriter.Rewrite(invite.Header, conn); err != nil {
+		t.Fatalf("failed to rewrite: %v", err)
+	}
+
+	contacts, err := ParseUsers(invite.Header, "Contact")
+	if err != nil || len(contacts) != 1 {
+		t.Fatalf("rewritten to %q: %v", invite.Header.Values("Contact"), err)
+	}
+	contact := contacts[0]
+	if contact.URI.Domain != conn.SentBy() || contact.URI.Username != "" {
+		t.Errorf("rewritten to %s, expected the address of the conn",
+			contact.String())
+	}
+	if expires := contact.Arguments.Get("expires"); expires != "60" {
+		t.Errorf("expires parameter is %q, expected 60", expires)
+	}
+
+	// A retransmission or re-INVITE with the same Contact is given the same
+	// rewritten Contact.
+	again := parseRequest(t, testRequest(MethodInvite, "z9hG4bKhide2",
+		"Contact: <sip:alice@10.0.0.5:5060>"))
+	rewriter.Rewrite(again.Header, conn)
+	rewritten, err := ParseUser(again.Header.Get("Contact"))
+	if err != nil || !rewritten.URI.Equals(contact.URI) {
+		t.Errorf("rewritten again to %q, expected %s",
+			again.Header.Get("Contact"), contact.URI.String())
+	}
+
+	// An in-dialog request sent to the rewritten Contact is restored to
+	// target the original.
+	bye := parseRequest(t, testRequest(MethodBye, "z9hG4bKhidebye"))
+	bye.Server = contact.URI.String()
+	if !rewriter.Restore(bye) {
+		t.Fatal("failed to restore the request")
+	}
+	if bye.Server != "sip:alice@10.0.0.5:5060" {
+		t.Errorf("restored to %q, expected sip:alice@10.0.0.5:5060", bye.Server)
+	}
+
+	rewriter.Remove(bye.Header.Get("Call-ID"))
+	bye.Server = contact.URI.String()
+	if rewriter.Restore(bye) {
+		t.Errorf("restored to %q after the dialog was removed", bye.Server)
+	}
+}
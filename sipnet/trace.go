@@ -0,0 +1,58 @@
+package sipnet
+
+import "net"
+
+// Tracer starts spans around the processing of requests received by a
+// Server, such as to report them to a distributed tracing system.
+type Tracer interface {
+	// StartSpan starts the span of a received request, with the trace ID of
+	// its trace header (which may be empty), its method and the address it
+	// was received from.
+	StartSpan(traceID, method string, peer net.Addr) Span
+}
+
+// Span is the span of the processing of a received request.
+type Span interface {
+	// Finish finishes the span once the request has been handled.
+	Finish()
+}
+
+// TraceID returns the trace ID of a message in the configured TraceHeader,
+// or an empty string if it has none or no trace header is configured.
+func (c *Conn) TraceID(h Header) string {
+	key := c.config().TraceHeader
+	if key == "" {
+		return ""
+	}
+	return h.Get(key)
+}
+
+// PropagateTrace copies the configured TraceHeader of a message received
+// over the connection to a message forwarded because of it, such as by a
+// proxy or B2BUA, so the trace continues across hops. A new trace ID is
+// generated if the received message has none. It does nothing if no trace
+// header is configured.
+func (c *Conn) PropagateTrace(received, forwarded Header) {
+	key := c.config().TraceHeader
+	if key == "" {
+		return
+	}
+
+	traceID := received.Get(key)
+	if traceID == "" {
+		traceID = randomHex(16)
+		received.Set(key, traceID)
+	}
+	forwarded.Set(key, traceID)
+}
+
+// startSpan starts the span of a request received over the connection with
+// the configured Tracer, or returns nil if there is none.
+func (c *Conn) startSpan(req *Request) Span {
+	tracer := c.config().Tracer
+	if tracer == nil {
+		return nil
+	}
+
+	return tracer.StartSpan(c.TraceID(req.Header), req.Method, c.Addr())
+}
@@ -0,0 +1,110 @@
+package sipnet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// span is a span started by a recordingTracer.
+type span struct {
+	traceID  string
+	method   string
+	peer     net.Addr
+	finished chan bool
+}
+
+func (s *span) Finish() {
+	s.finished <- true
+}
+
+// recordingTracer is a Tracer which sends the spans it starts to a channel.
+type recordingTracer chan *span
+
+func (r recordingTracer) StartSpan(traceID, method string, peer net.Addr) Span {
+	s := &span{traceID, method, peer, make(chan bool, 1)}
+	r <- s
+	return s
+}
+
+func TestPropagateTrace(t *testing.T) {
+	conn := &Conn{Listener: &Listener{config: &Config{TraceHeader: "X-Trace-Id"}}}
+
+	received := make(Header)
+	received.Set("X-Trace-Id", "abc123")
+	forwarded := make(Header)
+	conn.PropagateTrace(received, forwarded)
+	if id := forwarded.Get("X-Trace-Id"); id != "abc123" {
+		t.Errorf("forwarded trace ID %q, expected \"abc123\"", id)
+	}
+
+	// A trace is started for a message without one.
+	received = make(Header)
+	forwarded = make(Header)
+	conn.PropagateTrace(received, forwarded)
+	id := forwarded.Get("X-Trace-Id")
+	if id == "" || received.Get("X-Trace-Id") != id {
+		t.Errorf("started trace ID %q, received message has %q", id,
+			received.Get("X-Trace-Id"))
+	}
+
+	disabled := &Conn{Listener: &Listener{config: &Config{}}}
+	forwarded = make(Header)
+	disabled.PropagateTrace(received, forwarded)
+	if len(forwarded) != 0 {
+		t.Errorf("propagated %v without a trace header", forwarded)
+	}
+}
+
+func TestTracePropagatedAcrossForward(t *testing.T) {
+	tracer := make(recordingTracer, 1)
+	l := listenTest(t, Config{TraceHeader: "X-Trace-Id", Tracer: tracer})
+	caller := udpPeer(t)
+	defer caller.Close()
+	callee := udpPeer(t)
+	defer callee.Close()
+
+	s := NewServer(func(req *Request, conn *Conn, dialog *Dialog) {
+		out, err := l.DialConn(callee.LocalAddr().String(), "udp")
+		if err != nil {
+			t.Errorf("failed to dial: %v", err)
+			return
+		}
+		defer out.Unlock()
+
+		forwarded := newTestRequest(MethodMessage, "sip:carol@127.0.0.1")
+		forwarded.Header.Set("Via", out.NewVia().String())
+		conn.PropagateTrace(req.Header, forwarded.Header)
+		if err := forwarded.WriteTo(out); err != nil {
+			t.Errorf("failed to forward: %v", err)
+		}
+	}, l)
+	s.Dispatch = DispatchSync
+	go s.Serve()
+	defer s.Close()
+
+	sendUDP(t, caller, l, testRequest(MethodMessage, "z9hG4bKtrace",
+		"X-Trace-Id: 4bf92f3577b34da6"))
+
+	forwarded, _ := readUDPRequest(t, callee)
+	if id := forwarded.Header.Get("X-Trace-Id"); id != "4bf92f3577b34da6" {
+		t.Errorf("forwarded trace ID %q, expected \"4bf92f3577b34da6\"", id)
+	}
+
+	select {
+	case started := <-tracer:
+		if started.traceID != "4bf92f3577b34da6" ||
+			started.method != MethodMessage ||
+			started.peer.String() != caller.LocalAddr().String() {
+			t.Errorf("started span %q, %s, %v", started.traceID,
+				started.method, started.peer)
+		}
+		select {
+		case <-started.finished:
+		case <-time.After(testTimeout):
+			t.Error("span wasn't finished")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no span was started")
+	}
+}
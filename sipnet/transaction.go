@@ -0,0 +1,421 @@
+package sipnet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Timer values as defined in RFC 3261.
+const (
+	timerT1 = 500 * time.Millisecond
+	timerT2 = 4 * time.Second
+)
+
+// transactionTimeout is the duration a transaction lasts for, which
+// corresponds to Timers B, F, H and J (64*T1) as defined in RFC 3261.
+const transactionTimeout = 64 * timerT1
+
+// TransactionKey returns the key which identifies the transaction a *Request
+// or *Response belongs to, following the matching rules of RFC 3261
+// sections 17.1.3 and 17.2.3. An empty string is returned if the message
+// has no valid Via or CSeq.
+//
+// The key is made from the branch (ignoring case) and sent-by of the top Via,
+// and the method. The method of a response is that of its CSeq, and the
+// method of an ACK is INVITE, so an ACK for a non-2xx response has the same
+// key as the INVITE. A CANCEL shares the branch of the INVITE it cancels,
+// but has a different key, as does any other request reusing a branch with
+// a different method, so they are never absorbed as retransmissions of each
+// other. Requests without an RFC 3261 branch are keyed by their Call-ID,
+// From tag and CSeq number instead.
+func TransactionKey(msg interface{}) string {
+	switch msg := msg.(type) {
+	case *Request:
+		return transactionKey(msg.Header, msg.Method)
+	case *Response:
+		cseq, err := ParseCSeq(msg.Header.Get("CSeq"))
+		if err != nil {
+			return ""
+		}
+		return transactionKey(msg.Header, cseq.Method)
+	default:
+		return ""
+	}
+}
+
+// transactionKey returns the TransactionKey of a message with the given
+// headers for the method of its transaction.
+func transactionKey(h Header, method string) string {
+	if method == MethodAck {
+		method = MethodInvite
+	}
+
+	vias := splitVias(h)
+	if len(vias) == 0 {
+		return ""
+	}
+
+	via, err := ParseVia(vias[0])
+	if err != nil {
+		return ""
+	}
+
+	branch := normalizedBranch(via)
+	sentBy := strings.ToLower(via.Client)
+	if strings.HasPrefix(branch, strings.ToLower(BranchMagicCookie)) {
+		return branch + " " + sentBy + " " + method
+	}
+
+	cseq, err := ParseCSeq(h.Get("CSeq"))
+	if err != nil {
+		return ""
+	}
+
+	from, err := ParseUser(h.Get("From"))
+	if err != nil {
+		return ""
+	}
+
+	return h.Get("Call-ID") + " " + from.Arguments.Get("tag") + " " +
+		strconv.FormatUint(uint64(cseq.Sequence), 10) + " " + sentBy +
+		" " + method
+}
+
+// normalizedBranch returns the branch of a Via for use in a transaction
+// key. The parameter name and value are trimmed and compared ignoring case,
+// as some UAs vary them across retransmissions.
+func normalizedBranch(via Via) string {
+	for key, value := range via.Arguments {
+		if strings.EqualFold(strings.TrimSpace(key), "branch") {
+			return strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	return ""
+}
+
+// cachedResponse is the last response sent for a server transaction.
+type cachedResponse struct {
+	statusCode int
+	data       []byte
+	sent       time.Time
+
+	// toTag is the To tag of the responses sent for the transaction.
+	toTag string
+
+	// terminated is whether the transaction is an INVITE which was
+	// cancelled and answered with a 487 by PendingInvites.HandleCancel.
+	terminated bool
+}
+
+// absorbRetransmission records the branch of a received request. If the
+// request is a retransmission of one already received on this connection,
+// the last response sent for it (if any) is written again and true is
+// returned, indicating the request should not be delivered.
+//
+// An ACK for a non-2xx final response is part of the INVITE server
+// transaction, and is also absorbed. An ACK for a 2xx response is a
+// transaction of its own, and is always delivered.
+func (c *Conn) absorbRetransmission(req *Request) bool {
+	key := TransactionKey(req)
+	if key == "" {
+		return false
+	}
+
+	if req.Method == MethodAck {
+		return c.completedWithFailure(key)
+	}
+
+	c.BranchMutex.Lock()
+	if !c.seenBranch(key) {
+		c.recordBranch(key)
+		c.checkBranchMethod(req)
+		c.BranchMutex.Unlock()
+		return false
+	}
+	cached := c.responseCache[key]
+	c.BranchMutex.Unlock()
+
+	atomic.AddUint64(&c.counters.messagesIn, 1)
+	c.countRetransmission()
+	if cached.data != nil && c.writeRaw(cached.data) == nil {
+		atomic.AddUint64(&c.counters.messagesOut, 1)
+		c.countRetransmission()
+	}
+
+	return true
+}
+
+// resendResponse writes the last response origin sent for the server
+// transaction with the given key to c, if there is one, such as for a
+// retransmission received over c rather than origin. The response is also
+// cached by c, so further retransmissions received over c are answered by
+// absorbRetransmission.
+func (c *Conn) resendResponse(origin *Conn, key string) {
+	origin.BranchMutex.Lock()
+	cached := origin.responseCache[key]
+	origin.BranchMutex.Unlock()
+
+	c.countRetransmission()
+	if cached.data == nil {
+		return
+	}
+
+	c.BranchMutex.Lock()
+	if c.responseCache != nil && c.seenBranch(key) {
+		c.responseCache[key] = cached
+	}
+	c.BranchMutex.Unlock()
+
+	if c.WriteMessage(cached.data) == nil {
+		c.countRetransmission()
+	}
+}
+
+// branchMethod is the method of the first request received with a branch.
+type branchMethod struct {
+	method   string
+	received time.Time
+}
+
+// checkBranchMethod logs a warning if the method of a new transaction
+// doesn't match the method of its CSeq, or if its branch was already used
+// by a request of another method. Only an ACK or CANCEL may share the
+// branch of an INVITE, so any other reuse indicates a broken or spoofed
+// request. BranchMutex must be held.
+func (c *Conn) checkBranchMethod(req *Request) {
+	cseq, err := ParseCSeq(req.Header.Get("CSeq"))
+	if err == nil && cseq.Method != req.Method {
+		fmt.Println("warning: received", req.Method, "request from", c.Address,
+			"with a CSeq method of", cseq.Method)
+	}
+
+	vias := splitVias(req.Header)
+	if len(vias) == 0 {
+		return
+	}
+
+	via, err := ParseVia(vias[0])
+	if err != nil {
+		return
+	}
+
+	branch := normalizedBranch(via)
+	if !strings.HasPrefix(branch, strings.ToLower(BranchMagicCookie)) {
+		return
+	}
+	key := branch + " " + strings.ToLower(via.Client)
+
+	method := req.Method
+	if method == MethodAck || method == MethodCancel {
+		method = MethodInvite
+	}
+
+	if c.branchMethods == nil {
+		c.branchMethods = make(map[string]branchMethod)
+	}
+
+	first, found := c.branchMethods[key]
+	if !found {
+		c.branchMethods[key] = branchMethod{
+			method:   method,
+			received: c.clock().Now(),
+		}
+		return
+	}
+
+	if first.method != method {
+		fmt.Println("warning: received", req.Method, "request from", c.Address,
+			"reusing the branch of an earlier", first.method, "request:", branch)
+	}
+}
+
+// completedWithFailure returns whether the server transaction with the given
+// key was completed with a non-2xx final response.
+func (c *Conn) completedWithFailure(key string) bool {
+	c.BranchMutex.Lock()
+	cached, found := c.responseCache[key]
+	c.BranchMutex.Unlock()
+
+	return found && cached.statusCode >= 300
+}
+
+// cacheResponse stores the serialized response sent for req, so it can be
+// re-sent if req is retransmitted. The To tag of a response without one,
+// such as a 100 Trying, doesn't replace the tag of earlier responses.
+func (c *Conn) cacheResponse(req *Request, statusCode int, toTag string,
+	data []byte) {
+	key := TransactionKey(req)
+	if key == "" || c.responseCache == nil {
+		return
+	}
+
+	cached := make([]byte, len(data))
+	copy(cached, data)
+
+	c.BranchMutex.Lock()
+	if c.seenBranch(key) {
+		previous := c.responseCache[key]
+		if toTag == "" {
+			toTag = previous.toTag
+		}
+
+		c.responseCache[key] = cachedResponse{
+			statusCode: statusCode,
+			data:       cached,
+			sent:       c.clock().Now(),
+			toTag:      toTag,
+			terminated: previous.terminated,
+		}
+	}
+	c.BranchMutex.Unlock()
+}
+
+// startFinalResponse records that a final response is being sent for the
+// server transaction of req, so that a CANCEL received meanwhile doesn't
+// answer it with a 487 as well. It returns false if the response must not
+// be sent, as the transaction was already terminated by a CANCEL, in which
+// case only a 487 may be sent.
+func (c *Conn) startFinalResponse(req *Request, statusCode int) bool {
+	key := TransactionKey(req)
+	if key == "" || c.responseCache == nil {
+		return true
+	}
+
+	c.BranchMutex.Lock()
+	defer c.BranchMutex.Unlock()
+	cached, found := c.responseCache[key]
+	if cached.terminated {
+		return statusCode == StatusRequestTerminated
+	}
+
+	if (found || c.seenBranch(key)) && cached.statusCode < 200 {
+		cached.statusCode = statusCode
+		c.responseCache[key] = cached
+	}
+	return true
+}
+
+// terminate marks the server transaction with the given key as terminated
+// by a CANCEL, unless a final response has been sent for it, returning
+// whether it was terminated and the To tag of the responses already sent
+// for it, if any.
+func (c *Conn) terminate(key string) (bool, string) {
+	c.BranchMutex.Lock()
+	defer c.BranchMutex.Unlock()
+	if c.responseCache == nil {
+		return true, ""
+	}
+
+	cached := c.responseCache[key]
+	if cached.statusCode >= 200 {
+		return false, ""
+	}
+
+	cached.terminated = true
+	c.responseCache[key] = cached
+	return true, cached.toTag
+}
+
+// sentAck is the last ACK sent for a final response to an INVITE.
+type sentAck struct {
+	sent time.Time
+	data []byte
+}
+
+// responseKey returns the key identifying a final response received for a
+// client transaction. Responses from different forks of an INVITE have
+// different To tags, and are not duplicates of each other.
+func responseKey(resp *Response) string {
+	key := TransactionKey(resp)
+	if key == "" {
+		return ""
+	}
+
+	to, err := ParseUser(resp.Header.Get("To"))
+	if err != nil {
+		return ""
+	}
+
+	return key + " " + to.Arguments.Get("tag")
+}
+
+// ackKey returns the key which matches an ACK to the final response it
+// acknowledges, made from the Call-ID, CSeq number and To tag.
+func ackKey(h Header) string {
+	cseq, err := ParseCSeq(h.Get("CSeq"))
+	if err != nil {
+		return ""
+	}
+
+	to, err := ParseUser(h.Get("To"))
+	if err != nil {
+		return ""
+	}
+
+	return h.Get("Call-ID") + " " +
+		strconv.FormatUint(uint64(cseq.Sequence), 10) + " " +
+		to.Arguments.Get("tag")
+}
+
+// absorbResponseRetransmission records a received final response. If the
+// response is a retransmission of a final response already received for the
+// same client transaction, true is returned, indicating the response should
+// not be delivered. A retransmitted final response to an INVITE is
+// acknowledged again with the last ACK sent for it.
+func (c *Conn) absorbResponseRetransmission(resp *Response) bool {
+	if resp.StatusCode < 200 {
+		return false
+	}
+
+	key := responseKey(resp)
+	if key == "" {
+		return false
+	}
+
+	c.BranchMutex.Lock()
+	if c.receivedResponses == nil {
+		c.receivedResponses = make(map[string]time.Time)
+	}
+
+	if _, seen := c.receivedResponses[key]; !seen {
+		c.receivedResponses[key] = c.clock().Now()
+		c.BranchMutex.Unlock()
+		return false
+	}
+
+	ack := c.sentAcks[ackKey(resp.Header)]
+	c.BranchMutex.Unlock()
+
+	atomic.AddUint64(&c.counters.messagesIn, 1)
+	c.countRetransmission()
+	cseq, _ := ParseCSeq(resp.Header.Get("CSeq"))
+	if cseq.Method == MethodInvite && ack.data != nil &&
+		c.writeRaw(ack.data) == nil {
+		atomic.AddUint64(&c.counters.messagesOut, 1)
+		c.countRetransmission()
+	}
+
+	return true
+}
+
+// rememberAck stores the serialized ACK sent for a final response, so it
+// can be re-sent if the response is retransmitted.
+func (c *Conn) rememberAck(ack *Request, data []byte) {
+	key := ackKey(ack.Header)
+	if key == "" {
+		return
+	}
+
+	c.BranchMutex.Lock()
+	if c.sentAcks == nil {
+		c.sentAcks = make(map[string]sentAck)
+	}
+	c.sentAcks[key] = sentAck{
+		sent: c.clock().Now(),
+		data: append([]byte(nil), data...),
+	}
+	c.BranchMutex.Unlock()
+}
@@ -0,0 +1,100 @@
+package sipnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// TransactionTable routes the responses received by a listener to the Conn
+// their client transaction was sent over. A response from a different
+// address than its request was sent to, such as from another interface of
+// a multi-homed UA, arrives on a different pooled UDP Conn, but still
+// reaches the transaction waiting for it. A table may be shared by the
+// listeners of a Server with Config.Transactions. The zero value is ready
+// to use, and it is safe to use from multiple goroutines.
+type TransactionTable struct {
+	mutex sync.Mutex
+	conns map[string]*Conn
+}
+
+// Add records the Conn a client transaction is sent over. The returned
+// function removes the transaction, and should be called once it is
+// complete.
+func (t *TransactionTable) Add(req *Request, conn *Conn) func() {
+	key := TransactionKey(req)
+	if key == "" {
+		return func() {}
+	}
+
+	t.mutex.Lock()
+	if t.conns == nil {
+		t.conns = make(map[string]*Conn)
+	}
+	t.conns[key] = conn
+	t.mutex.Unlock()
+
+	return func() {
+		t.mutex.Lock()
+		if t.conns[key] == conn {
+			delete(t.conns, key)
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// Lookup returns the Conn the client transaction of a response was sent
+// over, or nil if it is unknown.
+func (t *TransactionTable) Lookup(resp *Response) *Conn {
+	key := TransactionKey(resp)
+	if key == "" {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.conns[key]
+}
+
+// transactionTable returns the transaction table of the connection's
+// listener, or nil if it has none.
+func (c *Conn) transactionTable() *TransactionTable {
+	if c.Listener == nil {
+		return nil
+	}
+
+	return c.Listener.transactions
+}
+
+// addTransaction records the client transaction of req as sent over the
+// connection, returning the function to remove it.
+func (c *Conn) addTransaction(req *Request) func() {
+	atomic.AddInt64(&c.counters.clientTransactions, 1)
+
+	remove := func() {}
+	if table := c.transactionTable(); table != nil {
+		remove = table.Add(req, c)
+	}
+
+	return func() {
+		atomic.AddInt64(&c.counters.clientTransactions, -1)
+		remove()
+	}
+}
+
+// routeResponse passes a received response to the Conn its client
+// transaction was sent over, if that is another open Conn, returning true
+// if it was passed on.
+func (c *Conn) routeResponse(resp *Response) bool {
+	table := c.transactionTable()
+	if table == nil {
+		return false
+	}
+
+	origin := table.Lookup(resp)
+	if origin == nil || origin == c || origin.Closed {
+		return false
+	}
+
+	origin.deliver(resp)
+	return true
+}
@@ -0,0 +1,39 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponseFromAnotherPeerRouted(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+	other := udpPeer(t)
+	defer other.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := newTestRequest(MethodOptions, "sip:bob@"+peer.LocalAddr().String())
+	results := goDo(conn, req, nil)
+
+	// The response is sent from another address, so it arrives on another
+	// pooled Conn of the listener.
+	options, _ := readUDPRequest(t, peer)
+	sendUDP(t, other, l, testResponse(options, "200 OK"))
+
+	select {
+	case result := <-results:
+		if result.err != nil || result.resp.StatusCode != StatusOK {
+			t.Fatalf("returned %v, %v, expected the 200", result.resp,
+				result.err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the response wasn't routed to the transaction")
+	}
+}
@@ -0,0 +1,238 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRetransmittedRequestResendsResponse(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	msg := testRequest(MethodMessage, "z9hG4bKretrans")
+	writePipe(t, remote, msg)
+	req := readRequest(t, conn)
+
+	errs := respond(conn, req, StatusOK, "b1")
+	sent := readPipe(t, remote)
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+
+	writePipe(t, remote, msg)
+	if resent := readPipe(t, remote); resent != sent {
+		t.Errorf("re-sent response %q, expected %q", resent, sent)
+	}
+	expectNoMessage(t, conn)
+}
+
+func TestAckRouting(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		ackBranch  string
+		delivered  bool
+	}{
+		{"non-2xx", StatusBusyHere, "z9hG4bKinvite", false},
+		{"2xx", StatusOK, "z9hG4bKack", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, remote := NewPipeConn("udp")
+			defer conn.Close()
+
+			writePipe(t, remote, testRequest(MethodInvite, "z9hG4bKinvite"))
+			req := readRequest(t, conn)
+
+			errs := respond(conn, req, test.statusCode, "b1")
+			readPipe(t, remote)
+			if err := <-errs; err != nil {
+				t.Fatalf("failed to write response: %v", err)
+			}
+
+			writePipe(t, remote, testRequest(MethodAck, test.ackBranch))
+			if !test.delivered {
+				expectNoMessage(t, conn)
+				return
+			}
+
+			ack := readRequest(t, conn)
+			if ack.Method != MethodAck {
+				t.Errorf("read %s, expected ACK", ack.Method)
+			}
+		})
+	}
+}
+
+func TestTransactionKeyRelationships(t *testing.T) {
+	key := func(method, branch string) string {
+		return TransactionKey(parseRequest(t, testRequest(method, branch)))
+	}
+
+	invite := key(MethodInvite, "z9hG4bKinvite")
+	if invite == "" {
+		t.Fatal("INVITE has no transaction key")
+	}
+	if ack := key(MethodAck, "z9hG4bKinvite"); ack != invite {
+		t.Errorf("ACK for a non-2xx has key %q, expected the INVITE's %q",
+			ack, invite)
+	}
+	if cancel := key(MethodCancel, "z9hG4bKinvite"); cancel == invite {
+		t.Error("CANCEL has the same key as the INVITE it cancels")
+	}
+	if ack := key(MethodAck, "z9hG4bKack"); ack == invite {
+		t.Error("ACK with a new branch has the key of the INVITE")
+	}
+
+	if bye := key(MethodBye, "z9hG4bKinvite"); bye == invite {
+		t.Error("BYE reusing the INVITE's branch has the INVITE's key")
+	}
+	if key(MethodOptions, "z9hG4bKa") == key(MethodOptions, "z9hG4bKb") {
+		t.Error("non-INVITE requests with different branches share a key")
+	}
+
+	// Responses are matched by the method of their CSeq.
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKinvite"))
+	resp := NewResponse()
+	resp.StatusCode = StatusOK
+	resp.Header.Set("Via", req.Header.Get("Via"))
+	resp.Header.Set("CSeq", req.Header.Get("CSeq"))
+	if respKey := TransactionKey(resp); respKey != invite {
+		t.Errorf("response has key %q, expected the INVITE's %q", respKey, invite)
+	}
+}
+
+func TestTransactionKeySentBy(t *testing.T) {
+	a := parseRequest(t, testRequest(MethodInvite, "z9hG4bKsame"))
+	b := parseRequest(t, testRequest(MethodInvite, "z9hG4bKsame"))
+	b.Header.Set("Via", "SIP/2.0/UDP 127.0.0.2:5070;branch=z9hG4bKsame")
+
+	if TransactionKey(a) == TransactionKey(b) {
+		t.Error("requests from different sent-bys share a key")
+	}
+}
+
+func TestTransactionKeyWithoutCookie(t *testing.T) {
+	invite := parseRequest(t, testRequest(MethodInvite, "old"))
+	ack := parseRequest(t, testRequest(MethodAck, "old"))
+	options := parseRequest(t, testRequest(MethodOptions, "old"))
+
+	if TransactionKey(invite) == "" {
+		t.Fatal("INVITE without an RFC 3261 branch has no key")
+	}
+	if TransactionKey(ack) != TransactionKey(invite) {
+		t.Error("ACK without an RFC 3261 branch doesn't match its INVITE")
+	}
+	if TransactionKey(options) == TransactionKey(invite) {
+		t.Error("OPTIONS without an RFC 3261 branch shares the INVITE's key")
+	}
+}
+
+func TestDuplicateFinalResponseDropped(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+
+	req := parseRequest(t, testRequest(MethodMessage, "z9hG4bKdup"))
+	ok := testResponse(req, "200 OK")
+	writePipe(t, remote, ok)
+	writePipe(t, remote, ok)
+
+	readResponse(t, conn)
+	expectNoMessage(t, conn)
+
+	// A response from another fork isn't a duplicate.
+	writePipe(t, remote, strings.Replace(ok, "tag=b1", "tag=b2", 1))
+	if resp := readResponse(t, conn); !strings.Contains(resp.Header.Get("To"), "b2") {
+		t.Errorf("read response with To %q, expected the other fork",
+			resp.Header.Get("To"))
+	}
+}
+
+func TestRetransmitted2xxAcknowledgedAgain(t *testing.T) {
+	conn, remote := NewPipeConn("tcp")
+	defer conn.Close()
+
+	invite := parseRequest(t, testRequest(MethodInvite, "z9hG4bKinvite"))
+	ok := testResponse(invite, "200 OK")
+	writePipe(t, remote, ok)
+	resp := readResponse(t, conn)
+
+	ack := newAck(invite, resp)
+	ack.Header.Set("Via", "SIP/2.0/TCP 127.0.0.1:5070;branch=z9hG4bKack")
+	errs := goWrite(func() error {
+		return ack.WriteTo(conn)
+	})
+	sent := readPipe(t, remote)
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to write ACK: %v", err)
+	}
+
+	// The 200 is retransmitted, as if the ACK was lost.
+	writePipe(t, remote, ok)
+	if resent := readPipe(t, remote); resent != sent {
+		t.Errorf("sent %q for the retransmitted 200, expected the ACK %q",
+			resent, sent)
+	}
+	expectNoMessage(t, conn)
+}
+
+func TestRetransmissionWithVaryingBranchWhitespace(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	first := strings.Replace(testRequest(MethodMessage, "z9hG4bKws"),
+		";branch=z9hG4bKws", " ; branch = z9hG4bKws ", 1)
+	second := strings.Replace(testRequest(MethodMessage, "z9hG4bKws"),
+		";branch=z9hG4bKws", ";Branch=z9hG4bKWS", 1)
+
+	firstKey := TransactionKey(parseRequest(t, first))
+	if firstKey == "" || firstKey != TransactionKey(parseRequest(t, second)) {
+		t.Fatalf("retransmissions have keys %q and %q", firstKey,
+			TransactionKey(parseRequest(t, second)))
+	}
+
+	sendUDP(t, peer, l, first)
+	req, conn := acceptRequest(t, l)
+	if err := <-respond(conn, req, StatusOK, "b1"); err != nil {
+		t.Fatalf("failed to respond: %v", err)
+	}
+	readUDP(t, peer)
+	conn.Unlock()
+
+	// The retransmission is answered from the cache rather than accepted.
+	sendUDP(t, peer, l, second)
+	if data, _ := readUDP(t, peer); startLine(data) != "SIP/2.0 200 OK" {
+		t.Errorf("retransmission answered with %q", startLine(data))
+	}
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKnext"))
+	if next, _ := acceptRequest(t, l); TransactionKey(next) == firstKey {
+		t.Error("retransmission was accepted as a new request")
+	}
+}
+
+func TestSpoofedBranchNotAbsorbed(t *testing.T) {
+	conn, remote := NewPipeConn("udp")
+	defer conn.Close()
+
+	invite := testRequest(MethodInvite, "z9hG4bKspoof")
+	bye := testRequest(MethodBye, "z9hG4bKspoof")
+	if TransactionKey(parseRequest(t, invite)) ==
+		TransactionKey(parseRequest(t, bye)) {
+		t.Fatal("INVITE and BYE sharing a branch have the same key")
+	}
+
+	writePipe(t, remote, invite)
+	if req := readRequest(t, conn); req.Method != MethodInvite {
+		t.Fatalf("read %s, expected INVITE", req.Method)
+	}
+
+	// The BYE reusing the branch of the INVITE is a new transaction rather
+	// than a retransmission.
+	writePipe(t, remote, bye)
+	if req := readRequest(t, conn); req.Method != MethodBye {
+		t.Errorf("read %s, expected BYE", req.Method)
+	}
+}
@@ -0,0 +1,150 @@
+package sipnet
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport represents a transport protocol SIP messages can be sent over.
+// Stream transports (i.e. TCP and TLS) carry messages over a connection
+// framed by their Content-Length, while message transports (i.e. UDP) carry
+// a single message per frame.
+type Transport interface {
+	// Name returns the lower case name of the transport as used in
+	// transport URI parameters, i.e. "udp".
+	Name() string
+
+	// IsStream returns whether the transport is a stream transport.
+	IsStream() bool
+
+	// Dial creates a connection to a SIP UA at addr.
+	Dial(addr string) (net.Conn, error)
+
+	// Listen listens for connections on addr. It is only used for stream
+	// transports.
+	Listen(addr string) (net.Listener, error)
+
+	// ListenPacket listens for frames on addr. It is only used for message
+	// transports.
+	ListenPacket(addr string) (net.PacketConn, error)
+
+	// ReadFrame reads a single frame from conn, and returns the address it
+	// was received from. It is only used for message transports.
+	ReadFrame(conn net.PacketConn) ([]byte, net.Addr, error)
+
+	// WriteFrame writes b as a single frame to addr over conn. It is only
+	// used for message transports.
+	WriteFrame(conn net.PacketConn, addr net.Addr, b []byte) error
+}
+
+// The built in transports.
+var (
+	UDP Transport = udpTransport{}
+	TCP Transport = tcpTransport{}
+)
+
+type udpTransport struct{}
+
+func (udpTransport) Name() string {
+	return "udp"
+}
+
+func (udpTransport) IsStream() bool {
+	return false
+}
+
+func (udpTransport) Dial(addr string) (net.Conn, error) {
+	return net.Dial("udp", addr)
+}
+
+func (udpTransport) Listen(addr string) (net.Listener, error) {
+	return nil, ErrInvalidTransport
+}
+
+func (udpTransport) ListenPacket(addr string) (net.PacketConn, error) {
+	return net.ListenPacket("udp", addr)
+}
+
+// udpBuffers holds buffers large enough for any datagram, so only the
+// received data is allocated for each frame.
+var udpBuffers = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 65535)
+	},
+}
+
+func (udpTransport) ReadFrame(conn net.PacketConn) ([]byte, net.Addr, error) {
+	buf := udpBuffers.Get().([]byte)
+	defer udpBuffers.Put(buf)
+
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append([]byte(nil), buf[:n]...), addr, nil
+}
+
+func (udpTransport) WriteFrame(conn net.PacketConn, addr net.Addr, b []byte) error {
+	_, err := conn.WriteTo(b, addr)
+	return err
+}
+
+type tcpTransport struct{}
+
+func (tcpTransport) Name() string {
+	return "tcp"
+}
+
+func (tcpTransport) IsStream() bool {
+	return true
+}
+
+func (tcpTransport) Dial(addr string) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, time.Second*10)
+}
+
+func (tcpTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (tcpTransport) ListenPacket(addr string) (net.PacketConn, error) {
+	return nil, ErrInvalidTransport
+}
+
+func (tcpTransport) ReadFrame(conn net.PacketConn) ([]byte, net.Addr, error) {
+	return nil, nil, ErrInvalidTransport
+}
+
+func (tcpTransport) WriteFrame(conn net.PacketConn, addr net.Addr, b []byte) error {
+	return ErrInvalidTransport
+}
+
+// transportByName returns the built in transport with the given name, or nil
+// if there is none.
+func transportByName(name string) Transport {
+	switch name {
+	case "udp":
+		return UDP
+	case "tcp":
+		return TCP
+	case "tls":
+		return TLS
+	default:
+		return nil
+	}
+}
+
+// protocol returns the transport of the connection.
+func (c *Conn) protocol() Transport {
+	if c.transport != nil {
+		return c.transport
+	}
+
+	if t := transportByName(c.Transport); t != nil {
+		return t
+	}
+
+	return TCP
+}
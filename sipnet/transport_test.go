@@ -0,0 +1,130 @@
+package sipnet
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockAddr is the address of a peer of a mockTransport.
+type mockAddr string
+
+func (a mockAddr) Network() string { return "mock" }
+func (a mockAddr) String() string  { return string(a) }
+
+// mockFrame is a frame received or sent over a mockTransport.
+type mockFrame struct {
+	data []byte
+	addr net.Addr
+}
+
+// mockPacketConn is an in-memory net.PacketConn of a mockTransport.
+type mockPacketConn struct {
+	received chan mockFrame
+	sent     chan mockFrame
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newMockPacketConn() *mockPacketConn {
+	return &mockPacketConn{
+		received: make(chan mockFrame, 8),
+		sent:     make(chan mockFrame, 8),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (c *mockPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case frame := <-c.received:
+		return copy(b, frame.data), frame.addr, nil
+	case <-c.closed:
+		return 0, nil, errors.New("mock: closed")
+	}
+}
+
+func (c *mockPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.sent <- mockFrame{append([]byte(nil), b...), addr}
+	return len(b), nil
+}
+
+func (c *mockPacketConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *mockPacketConn) LocalAddr() net.Addr                { return mockAddr("local") }
+func (c *mockPacketConn) SetDeadline(t time.Time) error      { return nil }
+func (c *mockPacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *mockPacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// mockTransport is a message transport over a mockPacketConn, which is not
+// known to Conn or Listener.
+type mockTransport struct {
+	conn *mockPacketConn
+}
+
+func (mockTransport) Name() string   { return "mock" }
+func (mockTransport) IsStream() bool { return false }
+
+func (mockTransport) Dial(addr string) (net.Conn, error) {
+	return nil, ErrInvalidTransport
+}
+
+func (mockTransport) Listen(addr string) (net.Listener, error) {
+	return nil, ErrInvalidTransport
+}
+
+func (t mockTransport) ListenPacket(addr string) (net.PacketConn, error) {
+	return t.conn, nil
+}
+
+func (mockTransport) ReadFrame(conn net.PacketConn) ([]byte, net.Addr, error) {
+	buf := make([]byte, 65535)
+	n, addr, err := conn.ReadFrom(buf)
+	return buf[:n], addr, err
+}
+
+func (mockTransport) WriteFrame(conn net.PacketConn, addr net.Addr, b []byte) error {
+	_, err := conn.WriteTo(b, addr)
+	return err
+}
+
+func TestMockTransport(t *testing.T) {
+	l := listenTest(t, Config{})
+	defer l.Close()
+
+	mock := mockTransport{newMockPacketConn()}
+	if err := l.ListenTransport(mock, "mock"); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	mock.conn.received <- mockFrame{
+		[]byte(testRequest(MethodOptions, "z9hG4bKmock")),
+		mockAddr("peer"),
+	}
+	req, conn := acceptRequest(t, l)
+	if conn.Transport != "mock" || conn.Addr() != mockAddr("peer") {
+		t.Errorf("request received over %s from %v, expected mock from peer",
+			conn.Transport, conn.Addr())
+	}
+	if via := conn.NewVia(); via.Transport != "MOCK" {
+		t.Errorf("Via of the conn is over %s, expected MOCK", via.Transport)
+	}
+
+	if err := <-respond(conn, req, StatusOK, "b1"); err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+	select {
+	case frame := <-mock.conn.sent:
+		if frame.addr != mockAddr("peer") ||
+			startLine(string(frame.data)) != "SIP/2.0 200 OK" {
+			t.Errorf("sent %q to %v, expected the 200 to peer",
+				startLine(string(frame.data)), frame.addr)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("response wasn't written over the transport")
+	}
+}
@@ -0,0 +1,51 @@
+package sipnet
+
+import "fmt"
+
+// ResponseHandler handles a response received over a connection.
+type ResponseHandler func(resp *Response, conn *Conn)
+
+// HandleResponses registers a handler for the responses to a client
+// transaction sent over the connection, which are received while the
+// connection is read by AcceptRequest rather than locked, such as by a UAC
+// embedded in a server. The returned function unregisters the handler, and
+// should be called once the transaction is complete.
+func (c *Conn) HandleResponses(req *Request, handler ResponseHandler) func() {
+	key := TransactionKey(req)
+
+	c.BranchMutex.Lock()
+	if c.responseHandlers == nil {
+		c.responseHandlers = make(map[string]ResponseHandler)
+	}
+	c.responseHandlers[key] = handler
+	c.BranchMutex.Unlock()
+	remove := c.addTransaction(req)
+
+	return func() {
+		remove()
+		c.BranchMutex.Lock()
+		delete(c.responseHandlers, key)
+		c.BranchMutex.Unlock()
+	}
+}
+
+// handleResponse passes a response read by AcceptRequest to the handler of
+// its client transaction if there is one, or to the configured
+// UnhandledResponse handler otherwise. If there are neither, a warning is
+// logged and the response is discarded.
+func (c *Conn) handleResponse(resp *Response) {
+	c.BranchMutex.Lock()
+	handler := c.responseHandlers[TransactionKey(resp)]
+	c.BranchMutex.Unlock()
+
+	if handler == nil {
+		handler = c.config().UnhandledResponse
+	}
+
+	if handler == nil {
+		fmt.Println("warning: unhandled response from", c.Address)
+		return
+	}
+
+	handler(resp, c)
+}
@@ -0,0 +1,86 @@
+package sipnet
+
+import (
+	"testing"
+	"time"
+)
+
+// handledResponse is a response passed to a ResponseHandler.
+type handledResponse struct {
+	resp *Response
+	conn *Conn
+}
+
+// responseRecorder returns a ResponseHandler sending the responses it
+// handles to the returned channel.
+func responseRecorder() (ResponseHandler, <-chan handledResponse) {
+	responses := make(chan handledResponse, 1)
+	return func(resp *Response, conn *Conn) {
+		responses <- handledResponse{resp, conn}
+	}, responses
+}
+
+func TestUnhandledResponseDelivered(t *testing.T) {
+	unhandled, responses := responseRecorder()
+	l := listenTest(t, Config{UnhandledResponse: unhandled})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	stray := parseRequest(t, testRequest(MethodOptions, "z9hG4bKstray"))
+	sendUDP(t, peer, l, testResponse(stray, "200 OK"))
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKafter"))
+
+	if req, _ := acceptRequest(t, l); req.Method != MethodMessage {
+		t.Errorf("accepted %s, expected MESSAGE", req.Method)
+	}
+
+	select {
+	case handled := <-responses:
+		if handled.resp.StatusCode != StatusOK ||
+			handled.resp.Header.Get("CSeq") != "1 OPTIONS" {
+			t.Errorf("handled a %d to %s, expected the 200 to OPTIONS",
+				handled.resp.StatusCode, handled.resp.Header.Get("CSeq"))
+		}
+		if handled.conn.Addr().String() != peer.LocalAddr().String() {
+			t.Errorf("handled a response from %v, expected %v",
+				handled.conn.Addr(), peer.LocalAddr())
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("response wasn't delivered to the callback")
+	}
+}
+
+func TestHandleResponsesByTransaction(t *testing.T) {
+	unhandled, strays := responseRecorder()
+	l := listenTest(t, Config{UnhandledResponse: unhandled})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	conn, err := l.DialConn(peer.LocalAddr().String(), "udp")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	req := parseRequest(t, testRequest(MethodOptions, "z9hG4bKclient"))
+	handler, responses := responseRecorder()
+	unregister := conn.HandleResponses(req, handler)
+	defer unregister()
+	conn.Unlock()
+
+	sendUDP(t, peer, l, testResponse(req, "200 OK"))
+	sendUDP(t, peer, l, testRequest(MethodMessage, "z9hG4bKafter"))
+	acceptRequest(t, l)
+
+	select {
+	case handled := <-responses:
+		if handled.resp.StatusCode != StatusOK {
+			t.Errorf("handled a %d, expected a 200", handled.resp.StatusCode)
+		}
+	case <-strays:
+		t.Fatal("response was handled as unhandled")
+	case <-time.After(testTimeout):
+		t.Fatal("response wasn't delivered to the transaction's handler")
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"strings"
 )
 
-var uriRegexp = regexp.MustCompile("^([A-Za-z]+):([^@]+)@([^\\s;]+)(.*)$")
+var uriRegexp = regexp.MustCompile("^([A-Za-z]+):(?:([^@]+)@)?([^\\s;@]+)(.*)$")
 
 // URI represents a Uniform Resource Identifier.
 type URI struct {
@@ -13,10 +13,20 @@ type URI struct {
 	Username  string
 	Domain    string
 	Arguments HeaderArgs
+
+	// Headers are the headers embedded after the "?" of the URI, unescaped,
+	// which are to be added to a request sent to it.
+	Headers Header
 }
 
 // ParseURI parses a given URI into a URI struct.
 func ParseURI(str string) (URI, error) {
+	var headers Header
+	if i := strings.Index(str, "?"); i >= 0 {
+		headers = parseURIHeaders(str[i+1:])
+		str = str[:i]
+	}
+
 	result := uriRegexp.FindStringSubmatch(str)
 	if len(result) == 0 {
 		return URI{}, ErrParseError
@@ -24,7 +34,15 @@ func ParseURI(str string) (URI, error) {
 
 	arguments := make(HeaderArgs)
 	if result[4] != "" && result[4][0] == ';' {
-		arguments = ParseHeaderArgs(strings.TrimSpace(result[4][1:]))
+		for key, value := range ParsePairs(result[4][1:]) {
+			arguments.Set(UnescapeURIParam(key), UnescapeURIParam(value))
+		}
+	}
+
+	if strings.EqualFold(result[1], "urn") && result[2] != "" {
+		// A URN has no user part, so its whole name is kept in Domain.
+		result[3] = result[2] + "@" + result[3]
+		result[2] = ""
 	}
 
 	return URI{
@@ -32,13 +50,48 @@ func ParseURI(str string) (URI, error) {
 		Username:  result[2],
 		Domain:    result[3],
 		Arguments: arguments,
+		Headers:   headers,
 	}, nil
 }
 
+// parseURIHeaders parses the "&" separated headers of a URI, unescaping
+// their names and values.
+func parseURIHeaders(str string) Header {
+	headers := make(Header)
+	for _, pair := range strings.Split(str, "&") {
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = UnescapeURIParam(parts[1])
+		}
+		headers.Add(UnescapeURIParam(parts[0]), value)
+	}
+
+	return headers
+}
+
 // String returns the full text representation of the URI with additional
-// semicolon arguments.
+// semicolon arguments and embedded headers.
 func (u URI) String() string {
-	return u.SchemeUserDomain() + u.Arguments.SemicolonString()
+	return u.SchemeUserDomain() + u.Arguments.uriString() + u.headersString()
+}
+
+// headersString returns the embedded headers of the URI escaped after a
+// "?", or an empty string if it has none.
+func (u URI) headersString() string {
+	var pairs []string
+	u.Headers.Each(func(key, value string) {
+		pairs = append(pairs, escapeURIHeader(key)+"="+escapeURIHeader(value))
+	})
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "?" + strings.Join(pairs, "&")
 }
 
 // SchemeUserDomain returns the text representation of the scheme:user@domain.
@@ -46,7 +99,64 @@ func (u URI) SchemeUserDomain() string {
 	return u.Scheme + ":" + u.UserDomain()
 }
 
-// UserDomain returns the text representation of user@domain.
+// UserDomain returns the text representation of user@domain, or just the
+// domain if the URI has no user.
 func (u URI) UserDomain() string {
+	if u.Username == "" {
+		return u.Domain
+	}
 	return u.Username + "@" + u.Domain
 }
+
+// uriEqualityParams are the URI parameters which must be present in both
+// URIs for them to be equal if they are present in either.
+var uriEqualityParams = []string{"user", "ttl", "method", "maddr", "transport"}
+
+// Equals returns whether two URIs are equivalent following the rules of
+// RFC 3261 section 19.1.4. The user is compared case sensitively, the
+// scheme, host and parameters are not, and an explicit default port is not
+// equal to a missing port. Parameters present in only one of the URIs are
+// ignored, except for user, ttl, method, maddr and transport.
+func (u URI) Equals(other URI) bool {
+	if !strings.EqualFold(u.Scheme, other.Scheme) ||
+		UnescapeURIParam(u.Username) != UnescapeURIParam(other.Username) ||
+		!strings.EqualFold(u.Domain, other.Domain) {
+		return false
+	}
+
+	params := lowerKeys(u.Arguments)
+	otherParams := lowerKeys(other.Arguments)
+
+	for _, key := range uriEqualityParams {
+		_, found := params[key]
+		_, otherFound := otherParams[key]
+		if found != otherFound {
+			return false
+		}
+	}
+
+	for key, value := range params {
+		otherValue, found := otherParams[key]
+		if found && !strings.EqualFold(value, otherValue) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func lowerKeys(args HeaderArgs) HeaderArgs {
+	lower := make(HeaderArgs, len(args))
+	for key, value := range args {
+		lower[strings.ToLower(key)] = value
+	}
+	return lower
+}
+
+// Target returns the address (host:port) and transport that a request to
+// the URI should be sent to. If the URI does not specify them, the port
+// defaults to 5060 (5061 for TLS), and the transport defaults to UDP
+// (TLS for sips URIs).
+func (u URI) Target() (string, string) {
+	return defaultConfig.Target(u)
+}
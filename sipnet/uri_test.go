@@ -0,0 +1,50 @@
+package sipnet
+
+import "testing"
+
+func TestURIEquals(t *testing.T) {
+	// The examples of RFC 3261 section 19.1.4.
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"sip:%61lice@atlanta.com;transport=TCP",
+			"sip:alice@AtLanTa.CoM;Transport=tcp", true},
+		{"sip:carol@chicago.com", "sip:carol@chicago.com;newparam=5", true},
+		{"sip:carol@chicago.com", "sip:carol@chicago.com;security=on", true},
+		{"sip:carol@chicago.com;newparam=5",
+			"sip:carol@chicago.com;security=on", true},
+		{"sip:biloxi.com;transport=tcp;method=REGISTER",
+			"sip:biloxi.com;method=REGISTER;transport=tcp", true},
+
+		{"SIP:ALICE@AtLanTa.CoM;Transport=udp",
+			"sip:alice@AtLanTa.CoM;Transport=UDP", false},
+		{"sip:bob@biloxi.com", "sip:bob@biloxi.com:5060", false},
+		{"sip:bob@biloxi.com", "sip:bob@biloxi.com;transport=udp", false},
+		{"sip:bob@biloxi.com", "sip:bob@biloxi.com:6000;transport=tcp", false},
+		{"sip:bob@phone21.boxesbybob.com", "sip:bob@192.0.2.4", false},
+		{"sip:carol@chicago.com;newparam=5",
+			"sip:carol@chicago.com;newparam=6", false},
+		{"sip:carol@chicago.com", "sips:carol@chicago.com", false},
+	}
+
+	for _, test := range tests {
+		a, err := ParseURI(test.a)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", test.a, err)
+		}
+		b, err := ParseURI(test.b)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", test.b, err)
+		}
+
+		if equal := a.Equals(b); equal != test.equal {
+			t.Errorf("%q equals %q is %v, expected %v", test.a, test.b,
+				equal, test.equal)
+		}
+		if equal := b.Equals(a); equal != test.equal {
+			t.Errorf("%q equals %q is %v, expected %v", test.b, test.a,
+				equal, test.equal)
+		}
+	}
+}
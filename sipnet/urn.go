@@ -0,0 +1,34 @@
+package sipnet
+
+import "strings"
+
+// IsURN returns whether the URI is a URN, such as a service URN
+// (RFC 5031). The name of a URN, i.e. "service:sos", is held in Domain.
+func (u URI) IsURN() bool {
+	return strings.EqualFold(u.Scheme, "urn")
+}
+
+// Service returns the service of a service URN (RFC 5031) in lower case,
+// i.e. "sos.police" for "urn:service:sos.police", and whether the URI is a
+// service URN.
+func (u URI) Service() (string, bool) {
+	if !u.IsURN() {
+		return "", false
+	}
+
+	parts := strings.SplitN(u.Domain, ":", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "service") ||
+		parts[1] == "" {
+		return "", false
+	}
+
+	return strings.ToLower(parts[1]), true
+}
+
+// IsEmergency returns whether the URI is the emergency service URN
+// "urn:service:sos" or one of its sub-services, such as
+// "urn:service:sos.police", so emergency calls can be routed specially.
+func (u URI) IsEmergency() bool {
+	service, ok := u.Service()
+	return ok && (service == "sos" || strings.HasPrefix(service, "sos."))
+}
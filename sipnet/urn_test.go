@@ -0,0 +1,56 @@
+package sipnet
+
+import "testing"
+
+func TestParseServiceURN(t *testing.T) {
+	tests := []struct {
+		uri       string
+		service   string
+		emergency bool
+	}{
+		{"urn:service:sos", "sos", true},
+		{"urn:service:sos.police", "sos.police", true},
+		{"URN:Service:SOS.Fire", "sos.fire", true},
+		{"urn:service:counseling", "counseling", false},
+		{"urn:service:sosa", "sosa", false},
+	}
+
+	for _, test := range tests {
+		uri, err := ParseURI(test.uri)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", test.uri, err)
+			continue
+		}
+
+		if !uri.IsURN() {
+			t.Errorf("%s: not a URN", test.uri)
+		}
+		if service, ok := uri.Service(); !ok || service != test.service {
+			t.Errorf("%s: service is %q, %v, expected %q", test.uri, service,
+				ok, test.service)
+		}
+		if uri.IsEmergency() != test.emergency {
+			t.Errorf("%s: IsEmergency is %v", test.uri, !test.emergency)
+		}
+	}
+}
+
+func TestNonServiceURNs(t *testing.T) {
+	for _, str := range []string{
+		"sip:sos@example.com",
+		"urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6",
+	} {
+		uri, err := ParseURI(str)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", str, err)
+			continue
+		}
+
+		if _, ok := uri.Service(); ok {
+			t.Errorf("%s: parsed as a service URN", str)
+		}
+		if uri.IsEmergency() {
+			t.Errorf("%s: parsed as an emergency URN", str)
+		}
+	}
+}
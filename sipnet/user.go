@@ -12,25 +12,45 @@ var ErrParseError = errors.New("sip: parse error")
 
 var nameRegexp = regexp.MustCompile("^([^<]*)<([^>]+)>(.*)$")
 
-// User represents a SIP user.
+// User represents a SIP user. The Name is the display name, unquoted.
 type User struct {
 	Name      string
 	URI       URI
 	Arguments HeaderArgs
 }
 
+// NameAddr is the name-addr (display name, URI and header parameters) of
+// the From, To, Contact and Record-Route headers, as defined in RFC 3261.
+type NameAddr = User
+
+// Tag returns the tag parameter of the user, as used in the From and To
+// headers to identify a dialog.
+func (u User) Tag() string {
+	return u.Arguments.Get("tag")
+}
+
 // String returns the string representation of a user to be used on user lines.
 func (u User) String() string {
 	if u.Name == "" {
 		return "<" + u.URI.String() + ">" + u.Arguments.SemicolonString()
 	}
-	return u.Name + " <" + u.URI.String() + ">" +
+	return quoteDisplayName(u.Name) + " <" + u.URI.String() + ">" +
 		u.Arguments.SemicolonString()
 }
 
-// ParseUser parses a given user line into a User.
+// ParseUser parses a given user line into a User. A quoted display name
+// may contain any characters, including angle brackets.
 func ParseUser(str string) (User, error) {
-	result := nameRegexp.FindStringSubmatch(str)
+	str = strings.TrimSpace(str)
+	quoted := quotedPrefix(str)
+	result := nameRegexp.FindStringSubmatch(str[len(quoted):])
+	if len(result) > 0 && quoted != "" {
+		if strings.TrimSpace(result[1]) != "" {
+			return User{}, ErrParseError
+		}
+		result[1] = quoted
+	}
+
 	if len(result) == 0 {
 		uri, err := ParseURI(str)
 		if err != nil {
@@ -49,12 +69,34 @@ func ParseUser(str string) (User, error) {
 	}
 
 	return User{
-		Name:      strings.TrimSpace(result[1]),
+		Name:      UnquoteString(strings.TrimSpace(result[1])),
 		URI:       uri,
 		Arguments: ParseHeaderArgs(strings.TrimSpace(result[3])),
 	}, nil
 }
 
+// quotedPrefix returns the quoted-string at the start of str, including its
+// quotes, or an empty string if str doesn't start with a complete one.
+func quotedPrefix(str string) string {
+	if !strings.HasPrefix(str, "\"") {
+		return ""
+	}
+
+	var escape bool
+	for i := 1; i < len(str); i++ {
+		switch {
+		case escape:
+			escape = false
+		case str[i] == '\\':
+			escape = true
+		case str[i] == '"':
+			return str[:i+1]
+		}
+	}
+
+	return ""
+}
+
 // ParseUserHeader returns the parsed users from the From, and the To fields
 // respectively from the header.
 func ParseUserHeader(h Header) (User, User, error) {
@@ -68,3 +110,87 @@ func ParseUserHeader(h Header) (User, User, error) {
 
 	return from, to, err
 }
+
+// From returns the parsed From header of the request.
+func (r *Request) From() (User, error) {
+	return ParseUser(r.Header.Get("From"))
+}
+
+// To returns the parsed To header of the request.
+func (r *Request) To() (User, error) {
+	return ParseUser(r.Header.Get("To"))
+}
+
+// From returns the parsed From header of the response.
+func (r *Response) From() (User, error) {
+	return ParseUser(r.Header.Get("From"))
+}
+
+// To returns the parsed To header of the response.
+func (r *Response) To() (User, error) {
+	return ParseUser(r.Header.Get("To"))
+}
+
+// ParseUserList parses a comma separated list of users, such as the value of
+// a Contact or Route header.
+func ParseUserList(str string) ([]User, error) {
+	var users []User
+	for _, value := range splitUserList(str) {
+		user, err := ParseUser(value)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// ParseUsers parses all of the users of a header key, which may be spread
+// across multiple header lines and comma separated values.
+func ParseUsers(h Header, key string) ([]User, error) {
+	var users []User
+	for _, value := range h.Values(key) {
+		list, err := ParseUserList(value)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, list...)
+	}
+
+	return users, nil
+}
+
+// splitUserList splits a comma separated list of users, ignoring commas in
+// quoted display names and angle bracketed URIs.
+func splitUserList(str string) []string {
+	var list []string
+	var quote, escape, bracket bool
+	start := 0
+	for i, r := range str {
+		switch {
+		case escape:
+			escape = false
+		case quote && r == '\\':
+			escape = true
+		case r == '"':
+			quote = !quote
+		case quote:
+		case r == '<':
+			bracket = true
+		case r == '>':
+			bracket = false
+		case r == ',' && !bracket:
+			if value := strings.TrimSpace(str[start:i]); value != "" {
+				list = append(list, value)
+			}
+			start = i + 1
+		}
+	}
+
+	if value := strings.TrimSpace(str[start:]); value != "" {
+		list = append(list, value)
+	}
+
+	return list
+}
@@ -0,0 +1,46 @@
+package sipnet
+
+import "testing"
+
+func TestRequestFromWithQuotedName(t *testing.T) {
+	req := parseRequest(t, testRequest(MethodInvite, "z9hG4bKfrom"))
+	req.Header.Set("From", `"Alice <Work> \"A\"" <sip:alice@atlanta.com>;tag=1928301774`)
+
+	from, err := req.From()
+	if err != nil {
+		t.Fatalf("failed to parse From: %v", err)
+	}
+	if from.Name != `Alice <Work> "A"` {
+		t.Errorf("display name is %q", from.Name)
+	}
+	if from.URI.Username != "alice" || from.URI.Domain != "atlanta.com" {
+		t.Errorf("URI is %v, expected sip:alice@atlanta.com", from.URI)
+	}
+	if tag := from.Tag(); tag != "1928301774" {
+		t.Errorf("tag is %q, expected 1928301774", tag)
+	}
+
+	// The display name is quoted again when serialized.
+	reparsed, err := ParseUser(from.String())
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", from.String(), err)
+	}
+	if reparsed.Name != from.Name || reparsed.Tag() != from.Tag() {
+		t.Errorf("%s round-tripped to %q with tag %q", from.String(),
+			reparsed.Name, reparsed.Tag())
+	}
+
+	to, err := req.To()
+	if err != nil {
+		t.Fatalf("failed to parse To: %v", err)
+	}
+	if to.URI.Username != "bob" || to.Tag() != "" {
+		t.Errorf("To is %s", to.String())
+	}
+}
+
+func TestParseUserRejectsTextAfterQuotedName(t *testing.T) {
+	if _, err := ParseUser(`"Alice" Smith <sip:alice@atlanta.com>`); err != ErrParseError {
+		t.Errorf("parsing returned %v, expected ErrParseError", err)
+	}
+}
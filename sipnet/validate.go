@@ -0,0 +1,98 @@
+package sipnet
+
+import "strings"
+
+// HeaderError is returned when validating a message if a mandatory header
+// is missing or malformed. Requests that fail validation should be
+// responded to with a 400 Bad Request.
+type HeaderError struct {
+	// Key is the header key, i.e. "Call-ID".
+	Key string
+
+	// Missing is true if the header is missing, and false if it is
+	// malformed.
+	Missing bool
+
+	// TooMany is true if the header has more values than the parser's
+	// limit.
+	TooMany bool
+}
+
+func (e *HeaderError) Error() string {
+	if e.Missing {
+		return "sip: missing " + e.Key + " header"
+	}
+	if e.TooMany {
+		return "sip: too many " + e.Key + " headers"
+	}
+	return "sip: malformed " + e.Key + " header"
+}
+
+// Validate checks that the mandatory headers of a request (Via, From, To,
+// Call-ID, CSeq and Max-Forwards) are present and well formed, and that the
+// CSeq method matches the request method. It returns a *HeaderError for the
+// first header that fails.
+func (r *Request) Validate() error {
+	err := validateHeader(r.Header)
+	if err != nil {
+		return err
+	}
+
+	cseq, _ := ParseCSeq(r.Header.Get("CSeq"))
+	if cseq.Method != strings.ToUpper(r.Method) {
+		return &HeaderError{Key: "CSeq"}
+	}
+
+	_, ok, err := r.MaxForwards()
+	if err != nil {
+		return &HeaderError{Key: "Max-Forwards"}
+	} else if !ok {
+		return &HeaderError{Key: "Max-Forwards", Missing: true}
+	}
+
+	return nil
+}
+
+// Validate checks that the mandatory headers of a response (Via, From, To,
+// Call-ID and CSeq) are present and well formed. It returns a *HeaderError
+// for the first header that fails.
+func (r *Response) Validate() error {
+	return validateHeader(r.Header)
+}
+
+func validateHeader(h Header) error {
+	vias := splitVias(h)
+	if len(vias) == 0 {
+		return &HeaderError{Key: "Via", Missing: true}
+	}
+
+	for _, via := range vias {
+		if _, err := ParseVia(via); err != nil {
+			return &HeaderError{Key: "Via"}
+		}
+	}
+
+	for _, key := range []string{"From", "To"} {
+		if h.Get(key) == "" {
+			return &HeaderError{Key: key, Missing: true}
+		}
+
+		if _, err := ParseUser(h.Get(key)); err != nil {
+			return &HeaderError{Key: key}
+		}
+	}
+
+	if h.Get("Call-ID") == "" {
+		return &HeaderError{Key: "Call-ID", Missing: true}
+	}
+
+	if h.Get("CSeq") == "" {
+		return &HeaderError{Key: "CSeq", Missing: true}
+	}
+
+	if _, err := ParseCSeq(h.Get("CSeq")); err != nil {
+		return &HeaderError{Key: "CSeq"}
+	}
+
+	return nil
+}
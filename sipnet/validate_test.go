@@ -0,0 +1,98 @@
+package sipnet
+
+import (
+	"strings"
+	"testing"
+)
+
+// withoutHeader removes the lines of a header from a serialized message.
+func withoutHeader(msg, key string) string {
+	lines := strings.Split(msg, "\r\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(line, key+":") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\r\n")
+}
+
+func TestValidateMissingHeader(t *testing.T) {
+	parser := &Parser{Validate: true}
+	for _, key := range []string{"Via", "From", "To", "Call-ID", "CSeq",
+		"Max-Forwards"} {
+		msg := withoutHeader(testRequest(MethodInvite, "z9hG4bK1"), key)
+		req, err := parser.ReadRequest(strings.NewReader(msg))
+		headerErr, ok := err.(*HeaderError)
+		if !ok {
+			t.Errorf("without %s: got error %v, expected a *HeaderError", key,
+				err)
+			continue
+		}
+		if headerErr.Key != key || !headerErr.Missing {
+			t.Errorf("without %s: got %+v", key, headerErr)
+		}
+		if req == nil {
+			t.Errorf("without %s: request not returned with the error", key)
+		}
+	}
+}
+
+func TestValidateMalformedHeader(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{"Via", "SIP/2.0/UDP"},
+		{"From", "alice"},
+		{"CSeq", "one INVITE"},
+		{"CSeq", "1 BYE"},
+		{"Max-Forwards", "seventy"},
+	}
+
+	parser := &Parser{Validate: true}
+	for _, test := range tests {
+		msg := withoutHeader(testRequest(MethodInvite, "z9hG4bK1"), test.key)
+		msg = strings.Replace(msg, "Content-Length", test.key+": "+
+			test.value+"\r\nContent-Length", 1)
+		_, err := parser.ReadRequest(strings.NewReader(msg))
+		headerErr, ok := err.(*HeaderError)
+		if !ok || headerErr.Key != test.key || headerErr.Missing {
+			t.Errorf("%s: %s: got error %v, expected a malformed %s", test.key,
+				test.value, err, test.key)
+		}
+	}
+}
+
+func TestValidateWellFormed(t *testing.T) {
+	parser := &Parser{Validate: true}
+	msg := testRequest(MethodInvite, "z9hG4bK1")
+	if _, err := parser.ReadRequest(strings.NewReader(msg)); err != nil {
+		t.Errorf("valid request failed validation: %v", err)
+	}
+
+	// Validation is disabled by default.
+	msg = withoutHeader(msg, "Call-ID")
+	if _, err := ReadRequest(strings.NewReader(msg)); err != nil {
+		t.Errorf("request failed to parse without validation: %v", err)
+	}
+}
+
+func TestValidateRejectsWithBadRequest(t *testing.T) {
+	l := listenTest(t, Config{Parser: Parser{Validate: true}})
+	defer l.Close()
+	peer := udpPeer(t)
+	defer peer.Close()
+
+	sendUDP(t, peer, l, withoutHeader(testRequest(MethodInvite, "z9hG4bK1"),
+		"Call-ID"))
+	data, _ := readUDP(t, peer)
+	if startLine(data) != "SIP/2.0 400 Bad Request" {
+		t.Errorf("got %q, expected a 400", startLine(data))
+	}
+
+	// An ACK is never responded to.
+	sendUDP(t, peer, l, withoutHeader(testRequest(MethodAck, "z9hG4bK2"),
+		"Call-ID"))
+	expectNoUDP(t, peer)
+}
@@ -35,3 +35,25 @@ func (v Via) String() string {
 	return v.SIPVersion + "/" + v.Transport + " " + v.Client +
 		v.Arguments.SemicolonString()
 }
+
+// splitVias returns the individual Via values of a header in order, which
+// may be spread across multiple Via lines and comma separated values.
+func splitVias(h Header) []string {
+	var vias []string
+	for _, value := range h.Values("Via") {
+		var quote bool
+		start := 0
+		for i, r := range value {
+			switch {
+			case r == '"':
+				quote = !quote
+			case r == ',' && !quote:
+				vias = append(vias, strings.TrimSpace(value[start:i]))
+				start = i + 1
+			}
+		}
+		vias = append(vias, strings.TrimSpace(value[start:]))
+	}
+
+	return vias
+}
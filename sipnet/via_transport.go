@@ -0,0 +1,77 @@
+package sipnet
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ViaTransportPolicy determines what happens to a received request whose top
+// Via sent-protocol transport differs from the transport it was received
+// over, such as a request received over TCP with a Via of "SIP/2.0/UDP".
+type ViaTransportPolicy int
+
+// Policies for requests with a mismatched Via transport.
+const (
+	// ViaTransportIgnore accepts the request unchanged.
+	ViaTransportIgnore ViaTransportPolicy = iota
+	// ViaTransportWarn accepts the request unchanged, but logs a warning and
+	// records it in the request's Warnings.
+	ViaTransportWarn
+	// ViaTransportRewrite replaces the transport of the top Via with the
+	// actual transport, and sets its received and rport parameters to the
+	// address the request was received from, so responses are routed back
+	// over the connection the request arrived on.
+	ViaTransportRewrite
+	// ViaTransportReject responds with a 400 Bad Request and discards the
+	// request.
+	ViaTransportReject
+)
+
+// checkViaTransport applies the configured ViaTransportPolicy to a received
+// request, and returns whether the request was rejected. An ACK is never
+// responded to, but is still discarded if rejected.
+func (c *Conn) checkViaTransport(req *Request) bool {
+	policy := c.config().ViaTransportPolicy
+	if policy == ViaTransportIgnore {
+		return false
+	}
+
+	vias := splitVias(req.Header)
+	if len(vias) == 0 {
+		return false
+	}
+
+	via, err := ParseVia(vias[0])
+	if err != nil || strings.EqualFold(via.Transport, c.protocol().Name()) {
+		return false
+	}
+
+	switch policy {
+	case ViaTransportWarn:
+		warning := "Via transport " + via.Transport + " received over " +
+			strings.ToUpper(c.protocol().Name())
+		fmt.Println("warning: " + warning + " from " + c.Addr().String())
+		req.Warnings = append(req.Warnings, warning)
+	case ViaTransportRewrite:
+		via.Transport = strings.ToUpper(c.protocol().Name())
+		host, port, err := net.SplitHostPort(c.Addr().String())
+		if err == nil {
+			via.Arguments.Set("received", host)
+			via.Arguments.Set("rport", port)
+		}
+
+		req.Header.Set("Via", via.String())
+		for _, rest := range vias[1:] {
+			req.Header.Add("Via", rest)
+		}
+	case ViaTransportReject:
+		if req.Method != MethodAck {
+			NewResponse().BadRequest(c, req, "Via transport does not match "+
+				"the transport of the connection.")
+		}
+		return true
+	}
+
+	return false
+}
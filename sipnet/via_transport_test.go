@@ -0,0 +1,77 @@
+package sipnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestViaTransportMismatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    ViaTransportPolicy
+		transport string
+		received  string
+		warnings  int
+	}{
+		{"ignore", ViaTransportIgnore, "UDP", "", 0},
+		{"warn", ViaTransportWarn, "UDP", "", 1},
+		{"rewrite", ViaTransportRewrite, "TCP", "127.0.0.1", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := listenTest(t, Config{ViaTransportPolicy: test.policy})
+			defer l.Close()
+			peer, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer peer.Close()
+
+			// The top Via of the request says it was sent over UDP.
+			peer.Write([]byte(testRequest(MethodMessage, "z9hG4bKvia")))
+			req, _ := acceptRequest(t, l)
+
+			via, err := ParseVia(req.Header.Get("Via"))
+			if err != nil {
+				t.Fatalf("failed to parse Via: %v", err)
+			}
+			if via.Transport != test.transport {
+				t.Errorf("Via transport is %s, expected %s", via.Transport,
+					test.transport)
+			}
+			if received := via.Arguments.Get("received"); received != test.received {
+				t.Errorf("Via received is %q, expected %q", received,
+					test.received)
+			}
+			if len(req.Warnings) != test.warnings {
+				t.Errorf("got warnings %q, expected %d", req.Warnings,
+					test.warnings)
+			}
+		})
+	}
+}
+
+func TestViaTransportMismatchRejected(t *testing.T) {
+	l := listenTest(t, Config{ViaTransportPolicy: ViaTransportReject})
+	defer l.Close()
+	peer, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer peer.Close()
+
+	peer.Write([]byte(testRequest(MethodMessage, "z9hG4bKviareject")))
+
+	buf := make([]byte, 65535)
+	peer.SetReadDeadline(time.Now().Add(testTimeout))
+	n, err := peer.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if line := startLine(string(buf[:n])); !strings.HasPrefix(line, "SIP/2.0 400 ") {
+		t.Errorf("answered with %q, expected a 400", line)
+	}
+}